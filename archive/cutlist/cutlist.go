@@ -0,0 +1,102 @@
+// Package cutlist exports event time ranges over indexed recordings as edit
+// decision lists (CMX 3600 EDL, FCPXML) for non-linear editors.
+package cutlist
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"path/filepath"
+	"time"
+
+	"github.com/deepch/vdk/archive/index"
+)
+
+// Event is an annotated wall clock range, a motion hit or a bookmark.
+type Event struct {
+	Name  string
+	Start time.Time
+	End   time.Time
+}
+
+// Clip is the part of one recording covered by an event, In and Out are
+// offsets from the beginning of the file.
+type Clip struct {
+	Name     string
+	Path     string
+	In       time.Duration
+	Out      time.Duration
+	Duration time.Duration // of the whole recording
+	Width    int
+	Height   int
+}
+
+// Clips resolves events against the index, an event spanning several
+// recordings gives one clip per recording.
+func Clips(ix *index.Index, events []Event) (clips []Clip, err error) {
+	for _, event := range events {
+		var hits []index.Hit
+		if hits, err = ix.Query(event.Start, event.End); err != nil {
+			return
+		}
+		for _, hit := range hits {
+			clip := Clip{
+				Name:     event.Name,
+				Path:     hit.Path,
+				In:       event.Start.Sub(hit.Start),
+				Out:      event.End.Sub(hit.Start),
+				Duration: hit.Duration,
+			}
+			if clip.In < 0 {
+				clip.In = 0
+			}
+			if clip.Out > hit.Duration {
+				clip.Out = hit.Duration
+			}
+			for _, stream := range hit.Streams {
+				if stream.Type.IsVideo() {
+					clip.Width, clip.Height = stream.Width, stream.Height
+					break
+				}
+			}
+			if clip.Out > clip.In {
+				clips = append(clips, clip)
+			}
+		}
+	}
+	return
+}
+
+func frames(tm time.Duration, fps int) int64 {
+	return int64((tm*time.Duration(fps) + time.Second/2) / time.Second)
+}
+
+func timecode(n int64, fps int) string {
+	f := int64(fps)
+	return fmt.Sprintf("%02d:%02d:%02d:%02d", n/(3600*f), n/(60*f)%60, n/f%60, n%f)
+}
+
+// WriteEDL writes a CMX 3600 list, non drop frame at fps, the record side
+// starts at 01:00:00:00.
+func WriteEDL(w io.Writer, title string, clips []Clip, fps int) (err error) {
+	if fps <= 0 {
+		err = fmt.Errorf("cutlist: invalid fps=%d", fps)
+		return
+	}
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "TITLE: %s\n", title)
+	fmt.Fprintf(bw, "FCM: NON-DROP FRAME\n\n")
+	rec := int64(3600 * fps)
+	for i, clip := range clips {
+		in, out := frames(clip.In, fps), frames(clip.Out, fps)
+		fmt.Fprintf(bw, "%03d  AX       V     C        %s %s %s %s\n", i+1,
+			timecode(in, fps), timecode(out, fps), timecode(rec, fps), timecode(rec+out-in, fps))
+		fmt.Fprintf(bw, "* FROM CLIP NAME: %s\n", filepath.Base(clip.Path))
+		if clip.Name != "" {
+			fmt.Fprintf(bw, "* COMMENT: %s\n", clip.Name)
+		}
+		fmt.Fprintf(bw, "\n")
+		rec += out - in
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,133 @@
+package cutlist
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/url"
+	"path/filepath"
+)
+
+type fcpxml struct {
+	XMLName xml.Name    `xml:"fcpxml"`
+	Version string      `xml:"version,attr"`
+	Formats []fcpFormat `xml:"resources>format"`
+	Assets  []fcpAsset  `xml:"resources>asset"`
+	Event   fcpEvent    `xml:"library>event"`
+}
+
+type fcpEvent struct {
+	Name    string     `xml:"name,attr"`
+	Project fcpProject `xml:"project"`
+}
+
+type fcpFormat struct {
+	ID            string `xml:"id,attr"`
+	FrameDuration string `xml:"frameDuration,attr"`
+	Width         int    `xml:"width,attr,omitempty"`
+	Height        int    `xml:"height,attr,omitempty"`
+}
+
+type fcpAsset struct {
+	ID       string      `xml:"id,attr"`
+	Name     string      `xml:"name,attr"`
+	Start    string      `xml:"start,attr"`
+	Duration string      `xml:"duration,attr"`
+	HasVideo int         `xml:"hasVideo,attr"`
+	Format   string      `xml:"format,attr"`
+	MediaRep fcpMediaRep `xml:"media-rep"`
+}
+
+type fcpMediaRep struct {
+	Kind string `xml:"kind,attr"`
+	Src  string `xml:"src,attr"`
+}
+
+type fcpProject struct {
+	Name     string      `xml:"name,attr"`
+	Sequence fcpSequence `xml:"sequence"`
+}
+
+type fcpSequence struct {
+	Format string         `xml:"format,attr"`
+	Clips  []fcpAssetClip `xml:"spine>asset-clip"`
+}
+
+type fcpAssetClip struct {
+	Ref      string `xml:"ref,attr"`
+	Name     string `xml:"name,attr"`
+	Offset   string `xml:"offset,attr"`
+	Start    string `xml:"start,attr"`
+	Duration string `xml:"duration,attr"`
+}
+
+// WriteFCPXML writes an FCPXML 1.9 library holding one project with the
+// clips laid end to end, one asset per referenced file.
+func WriteFCPXML(w io.Writer, title string, clips []Clip, fps int) (err error) {
+	if fps <= 0 {
+		err = fmt.Errorf("cutlist: invalid fps=%d", fps)
+		return
+	}
+	rational := func(n int64) string {
+		return fmt.Sprintf("%d/%ds", n, fps)
+	}
+
+	doc := fcpxml{
+		Version: "1.9",
+		Event: fcpEvent{
+			Name: title,
+			Project: fcpProject{
+				Name:     title,
+				Sequence: fcpSequence{Format: "r0"},
+			},
+		},
+	}
+	format := fcpFormat{ID: "r0", FrameDuration: rational(1)}
+	assets := map[string]string{}
+	var offset int64
+	for _, clip := range clips {
+		if format.Width == 0 {
+			format.Width, format.Height = clip.Width, clip.Height
+		}
+		id, ok := assets[clip.Path]
+		if !ok {
+			id = fmt.Sprintf("r%d", len(assets)+1)
+			assets[clip.Path] = id
+			var abs string
+			if abs, err = filepath.Abs(clip.Path); err != nil {
+				return
+			}
+			src := url.URL{Scheme: "file", Path: filepath.ToSlash(abs)}
+			doc.Assets = append(doc.Assets, fcpAsset{
+				ID:       id,
+				Name:     filepath.Base(clip.Path),
+				Start:    "0s",
+				Duration: rational(frames(clip.Duration, fps)),
+				HasVideo: 1,
+				Format:   "r0",
+				MediaRep: fcpMediaRep{Kind: "original-media", Src: src.String()},
+			})
+		}
+		in, out := frames(clip.In, fps), frames(clip.Out, fps)
+		doc.Event.Project.Sequence.Clips = append(doc.Event.Project.Sequence.Clips, fcpAssetClip{
+			Ref:      id,
+			Name:     clip.Name,
+			Offset:   rational(offset),
+			Start:    rational(in),
+			Duration: rational(out - in),
+		})
+		offset += out - in
+	}
+	doc.Formats = []fcpFormat{format}
+
+	if _, err = io.WriteString(w, xml.Header+"<!DOCTYPE fcpxml>\n"); err != nil {
+		return
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err = enc.Encode(doc); err != nil {
+		return
+	}
+	_, err = io.WriteString(w, "\n")
+	return
+}
@@ -0,0 +1,368 @@
+// Package export runs trim and remux jobs over indexed recordings
+// concurrently, with priorities, per job progress and a read rate shared
+// by all the jobs.
+package export
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/deepch/vdk/archive/cutlist"
+	"github.com/deepch/vdk/av"
+	"github.com/deepch/vdk/av/avutil"
+	"github.com/deepch/vdk/utils/iorate"
+)
+
+const DefaultWorkers = 2
+
+type State int
+
+const (
+	Queued State = iota
+	Running
+	Done
+	Failed
+	Cancelled
+)
+
+func (self State) String() string {
+	switch self {
+	case Queued:
+		return "queued"
+	case Running:
+		return "running"
+	case Done:
+		return "done"
+	case Failed:
+		return "failed"
+	case Cancelled:
+		return "cancelled"
+	}
+	return fmt.Sprintf("State(%d)", int(self))
+}
+
+// Job exports the part of a recording given by Clip to Dst, whose
+// extension picks the format. Higher Priority jobs start first.
+type Job struct {
+	Clip     cutlist.Clip
+	Dst      string
+	Priority int
+}
+
+type Status struct {
+	Job
+	ID       int
+	State    State
+	Progress avutil.Progress
+	Err      error
+}
+
+type job struct {
+	status Status
+	cancel context.CancelFunc
+}
+
+// Manager runs up to Workers jobs at once, the fields are read when a job
+// starts.
+type Manager struct {
+	Workers        int   // DefaultWorkers if zero
+	BytesPerSecond int64 // read by all the jobs, unlimited if zero
+
+	// Open and Create default to avutil.Open and avutil.Create.
+	Open       func(path string) (av.DemuxCloser, error)
+	Create     func(path string) (av.MuxCloser, error)
+	OnProgress func(Status)
+
+	lock    sync.Mutex
+	jobs    []*job
+	running int
+	wg      sync.WaitGroup
+	limiter *iorate.Limiter
+}
+
+func NewManager(workers int) *Manager {
+	return &Manager{Workers: workers}
+}
+
+// ClipJobs makes one job per clip, written to dir as numbered files of
+// extension ext named after the clips.
+func ClipJobs(clips []cutlist.Clip, dir string, ext string) (jobs []Job) {
+	for i, clip := range clips {
+		name := strings.Map(func(r rune) rune {
+			if r == '/' || r == '\\' || r == ':' || r < ' ' {
+				return '_'
+			}
+			return r
+		}, clip.Name)
+		if name == "" {
+			name = strings.TrimSuffix(filepath.Base(clip.Path), filepath.Ext(clip.Path))
+		}
+		jobs = append(jobs, Job{
+			Clip: clip,
+			Dst:  filepath.Join(dir, fmt.Sprintf("%03d-%s%s", i+1, name, ext)),
+		})
+	}
+	return
+}
+
+// Submit queues job and returns its id.
+func (self *Manager) Submit(j Job) (id int) {
+	self.lock.Lock()
+	id = len(self.jobs) + 1
+	self.jobs = append(self.jobs, &job{status: Status{Job: j, ID: id}})
+	self.wg.Add(1)
+	self.lock.Unlock()
+	self.schedule()
+	return
+}
+
+// Cancel stops a running job, its output keeps what was written, or drops
+// a queued one.
+func (self *Manager) Cancel(id int) bool {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if id < 1 || id > len(self.jobs) {
+		return false
+	}
+	j := self.jobs[id-1]
+	switch j.status.State {
+	case Queued:
+		j.status.State = Cancelled
+		self.wg.Done()
+	case Running:
+		j.cancel()
+	default:
+		return false
+	}
+	return true
+}
+
+// CancelAll cancels every queued and running job.
+func (self *Manager) CancelAll() {
+	self.lock.Lock()
+	n := len(self.jobs)
+	self.lock.Unlock()
+	for id := 1; id <= n; id++ {
+		self.Cancel(id)
+	}
+}
+
+func (self *Manager) Status(id int) (status Status, ok bool) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if id < 1 || id > len(self.jobs) {
+		return
+	}
+	return self.jobs[id-1].status, true
+}
+
+// List returns the status of all the jobs submitted, in id order.
+func (self *Manager) List() (list []Status) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	for _, j := range self.jobs {
+		list = append(list, j.status)
+	}
+	return
+}
+
+// Wait returns once all the jobs submitted so far have ended.
+func (self *Manager) Wait() {
+	self.wg.Wait()
+}
+
+// schedule starts the queued jobs of highest priority while workers are
+// free, the oldest first among equal ones.
+func (self *Manager) schedule() {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	workers := self.Workers
+	if workers <= 0 {
+		workers = DefaultWorkers
+	}
+	for self.running < workers {
+		var next *job
+		for _, j := range self.jobs {
+			if j.status.State == Queued && (next == nil || j.status.Priority > next.status.Priority) {
+				next = j
+			}
+		}
+		if next == nil {
+			return
+		}
+		var ctx context.Context
+		ctx, next.cancel = context.WithCancel(context.Background())
+		next.status.State = Running
+		self.running++
+		go self.run(ctx, next)
+	}
+}
+
+func (self *Manager) run(ctx context.Context, j *job) {
+	err := self.export(ctx, j)
+	self.lock.Lock()
+	switch {
+	case err == nil:
+		j.status.State = Done
+	case err == ctx.Err():
+		j.status.State = Cancelled
+	default:
+		j.status.State = Failed
+		j.status.Err = err
+	}
+	j.cancel()
+	status := j.status
+	self.running--
+	self.lock.Unlock()
+	if self.OnProgress != nil {
+		self.OnProgress(status)
+	}
+	self.wg.Done()
+	self.schedule()
+}
+
+func (self *Manager) export(ctx context.Context, j *job) (err error) {
+	open, create := self.Open, self.Create
+	if open == nil {
+		open = avutil.Open
+	}
+	if create == nil {
+		create = avutil.Create
+	}
+	clip := j.status.Clip
+	var src av.DemuxCloser
+	if src, err = open(clip.Path); err != nil {
+		return fmt.Errorf("export: %s: %s", clip.Path, err)
+	}
+	defer src.Close()
+	var streams []av.CodecData
+	if streams, err = src.Streams(); err != nil {
+		return fmt.Errorf("export: %s: %s", clip.Path, err)
+	}
+	var dst av.MuxCloser
+	if dst, err = create(j.status.Dst); err != nil {
+		return fmt.Errorf("export: %s: %s", j.status.Dst, err)
+	}
+	defer dst.Close()
+
+	r := &trimReader{ctx: ctx, src: src, in: clip.In, out: clip.Out, videoidx: -1}
+	for i, stream := range streams {
+		if stream.Type().IsVideo() {
+			r.videoidx = i
+			break
+		}
+	}
+	r.limiter = self.rateLimiter()
+	duration := clip.Out - clip.In
+	if clip.Out <= 0 {
+		duration = clip.Duration - clip.In
+	}
+	opts := avutil.CopyOptions{
+		Duration: duration,
+		OnProgress: func(p avutil.Progress) {
+			self.lock.Lock()
+			j.status.Progress = p
+			status := j.status
+			self.lock.Unlock()
+			if self.OnProgress != nil {
+				self.OnProgress(status)
+			}
+		},
+	}
+	if err = avutil.CopyFileContext(ctx, dst, &headerDemuxer{r, streams}, opts); err != nil && err != ctx.Err() {
+		err = fmt.Errorf("export: %s: %s", j.status.Dst, err)
+	}
+	return
+}
+
+// rateLimiter returns the limiter shared by the jobs, made again if
+// BytesPerSecond changed, nil if unlimited.
+func (self *Manager) rateLimiter() *iorate.Limiter {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if self.BytesPerSecond <= 0 {
+		return nil
+	}
+	if self.limiter == nil || self.limiter.Rate != self.BytesPerSecond {
+		self.limiter = iorate.NewLimiter(self.BytesPerSecond, 0)
+	}
+	return self.limiter
+}
+
+type headerDemuxer struct {
+	av.PacketReader
+	streams []av.CodecData
+}
+
+func (self *headerDemuxer) Streams() ([]av.CodecData, error) {
+	return self.streams, nil
+}
+
+// trimReader gives the packets from the last keyframe at or before in up
+// to out, or to the end if out is zero, offsets from the first packet,
+// with times starting at zero.
+type trimReader struct {
+	ctx      context.Context
+	src      av.PacketReader
+	in, out  time.Duration
+	videoidx int
+	limiter  *iorate.Limiter
+
+	first   time.Duration
+	based   bool
+	started bool
+	start   time.Duration
+	gop     []av.Packet
+}
+
+func (self *trimReader) ReadPacket() (pkt av.Packet, err error) {
+	for {
+		if self.started && len(self.gop) > 0 {
+			pkt = self.gop[0]
+			self.gop = self.gop[1:]
+			pkt.Time -= self.start
+			return
+		}
+		if pkt, err = self.src.ReadPacket(); err != nil {
+			return
+		}
+		if err = self.limiter.WaitContext(self.ctx, len(pkt.Data)); err != nil {
+			return
+		}
+		if !self.based {
+			self.first = pkt.Time
+			self.based = true
+		}
+		tm := pkt.Time - self.first
+		if self.out > 0 && tm >= self.out {
+			err = io.EOF
+			return
+		}
+		if self.started {
+			pkt.Time -= self.start
+			return
+		}
+		if self.videoidx == -1 {
+			if tm >= self.in {
+				self.gop = append(self.gop, pkt)
+			}
+		} else {
+			keyframe := int(pkt.Idx) == self.videoidx && pkt.IsKeyFrame
+			if keyframe && tm <= self.in {
+				self.gop = self.gop[:0]
+			}
+			if keyframe || len(self.gop) > 0 {
+				self.gop = append(self.gop, pkt)
+			}
+		}
+		if tm >= self.in && len(self.gop) > 0 {
+			self.started = true
+			self.start = self.gop[0].Time
+		}
+	}
+}
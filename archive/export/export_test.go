@@ -0,0 +1,160 @@
+package export
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/deepch/vdk/archive/cutlist"
+	"github.com/deepch/vdk/av"
+	"github.com/deepch/vdk/codec"
+	"github.com/deepch/vdk/codec/h264parser"
+)
+
+// testPackets is 12s of video at 1 fps with a keyframe every 3s and audio
+// every 500ms, from 10s on.
+func testPackets() (pkts []av.Packet) {
+	for ms := 0; ms < 12000; ms += 500 {
+		tm := 10*time.Second + time.Duration(ms)*time.Millisecond
+		if ms%1000 == 0 {
+			pkts = append(pkts, av.Packet{Idx: 0, Time: tm, IsKeyFrame: ms%3000 == 0, Data: []byte{0}})
+		}
+		pkts = append(pkts, av.Packet{Idx: 1, Time: tm, Data: []byte{1}})
+	}
+	return
+}
+
+type sliceDemuxer struct {
+	streams []av.CodecData
+	pkts    []av.Packet
+}
+
+func (self *sliceDemuxer) Streams() ([]av.CodecData, error) {
+	return self.streams, nil
+}
+
+func (self *sliceDemuxer) ReadPacket() (pkt av.Packet, err error) {
+	if len(self.pkts) == 0 {
+		err = io.EOF
+		return
+	}
+	pkt = self.pkts[0]
+	self.pkts = self.pkts[1:]
+	return
+}
+
+func (self *sliceDemuxer) Close() error {
+	return nil
+}
+
+type recordMuxer struct {
+	pkts []av.Packet
+}
+
+func (self *recordMuxer) WriteHeader(streams []av.CodecData) error { return nil }
+func (self *recordMuxer) WriteTrailer() error                      { return nil }
+func (self *recordMuxer) Close() error                             { return nil }
+
+func (self *recordMuxer) WritePacket(pkt av.Packet) error {
+	self.pkts = append(self.pkts, pkt)
+	return nil
+}
+
+func TestTrimReader(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		in, out  time.Duration
+		videoidx int
+		from, to time.Duration // of the packets read, to is zero to the end
+	}{
+		{"whole", 0, 0, 0, 0, 0},
+		{"to the end", 4 * time.Second, 0, 0, 3 * time.Second, 0},
+		{"from a keyframe", 3 * time.Second, 8 * time.Second, 0, 3 * time.Second, 8 * time.Second},
+		{"between keyframes", 5 * time.Second, 8 * time.Second, 0, 3 * time.Second, 8 * time.Second},
+		{"first gop", 0, 2 * time.Second, 0, 0, 2 * time.Second},
+		{"audio", 2 * time.Second, 0, -1, 2 * time.Second, 0},
+		{"audio to", 2 * time.Second, 5 * time.Second, -1, 2 * time.Second, 5 * time.Second},
+		{"past the end", 20 * time.Second, 0, 0, -1, 0},
+	} {
+		r := &trimReader{ctx: context.Background(), src: &sliceDemuxer{pkts: testPackets()}, in: test.in, out: test.out, videoidx: test.videoidx}
+		var want []av.Packet
+		for _, pkt := range testPackets() {
+			tm := pkt.Time - 10*time.Second
+			if test.from >= 0 && tm >= test.from && (test.to == 0 || tm < test.to) {
+				pkt.Time = tm - test.from
+				want = append(want, pkt)
+			}
+		}
+		var got []av.Packet
+		var err error
+		for {
+			var pkt av.Packet
+			if pkt, err = r.ReadPacket(); err != nil {
+				break
+			}
+			got = append(got, pkt)
+		}
+		if err != io.EOF || fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("%s: %v\ngot  %v\nwant %v", test.name, err, got, want)
+		}
+	}
+}
+
+func TestManager(t *testing.T) {
+	streams := []av.CodecData{h264parser.CodecData{}, codec.NewPCMMulawCodecData()}
+	outputs := map[string]*recordMuxer{}
+	manager := &Manager{
+		Workers: 1,
+		Open: func(path string) (av.DemuxCloser, error) {
+			if path != "rec.mp4" {
+				return nil, fmt.Errorf("no %s", path)
+			}
+			return &sliceDemuxer{streams: streams, pkts: testPackets()}, nil
+		},
+		Create: func(path string) (av.MuxCloser, error) {
+			outputs[path] = &recordMuxer{}
+			return outputs[path], nil
+		},
+	}
+	durations := map[int]time.Duration{}
+	manager.OnProgress = func(status Status) {
+		if status.State == Running {
+			durations[status.ID] = status.Progress.Duration
+		}
+	}
+	clips := []cutlist.Clip{
+		{Path: "rec.mp4", In: 3 * time.Second, Out: 6 * time.Second},
+		{Path: "rec.mp4", In: 3 * time.Second, Duration: 12 * time.Second},
+		{Path: "rec.mp4"},
+		{Path: "missing.mp4"},
+	}
+	for _, job := range ClipJobs(clips, "out", ".ts") {
+		manager.Submit(job)
+	}
+	manager.Wait()
+	for i, want := range []struct {
+		state State
+		dst   string
+		n     int
+	}{
+		{Done, "out/001-rec.ts", 9},
+		{Done, "out/002-rec.ts", 27},
+		{Done, "out/003-rec.ts", 36},
+		{Failed, "out/004-missing.ts", 0},
+	} {
+		status, _ := manager.Status(i + 1)
+		if status.State != want.state || status.Dst != want.dst {
+			t.Errorf("job %d: %v to %s: %v", i+1, status.State, status.Dst, status.Err)
+			continue
+		}
+		if output := outputs[want.dst]; want.n > 0 && (output == nil || len(output.pkts) != want.n) {
+			t.Errorf("job %d: output %v", i+1, output)
+		}
+	}
+	// the length of a clip to the end comes from the recording
+	if durations[1] != 3*time.Second || durations[2] != 9*time.Second || durations[3] > 0 {
+		t.Errorf("progress of %v", durations)
+	}
+}
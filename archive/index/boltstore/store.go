@@ -0,0 +1,235 @@
+// Package boltstore keeps an archive index in a bbolt database. Entries are
+// keyed by path and by start time, so a time range query only reads the
+// entries it returns, and their keyframe maps are read on demand.
+package boltstore
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+	"time"
+
+	"github.com/deepch/vdk/archive/index"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	entriesBucket   = []byte("entries")   // path: entry without keyframes
+	startsBucket    = []byte("starts")    // start time and path: empty
+	keyframesBucket = []byte("keyframes") // path: keyframe times
+	metaBucket      = []byte("meta")
+
+	// the longest duration put, how far before a range entries overlapping
+	// it may start
+	maxDurationKey = []byte("maxduration")
+)
+
+// Store is an index.Store and index.RangeStore.
+type Store struct {
+	db *bolt.DB
+}
+
+func Open(path string) (self *Store, err error) {
+	var db *bolt.DB
+	if db, err = bolt.Open(path, 0644, &bolt.Options{Timeout: time.Second}); err != nil {
+		return
+	}
+	if err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{entriesBucket, startsBucket, keyframesBucket, metaBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		db.Close()
+		return
+	}
+	self = &Store{db: db}
+	return
+}
+
+// startKey sorts by start time then path, the sign bit is flipped so that
+// times before 1970 sort first.
+func startKey(start time.Time, path string) []byte {
+	b := make([]byte, 8, 8+len(path))
+	binary.BigEndian.PutUint64(b, uint64(start.UnixNano())^1<<63)
+	return append(b, path...)
+}
+
+func keyStart(key []byte) int64 {
+	return int64(binary.BigEndian.Uint64(key) ^ 1<<63)
+}
+
+func encodeKeyframes(keyframes []time.Duration) []byte {
+	b := make([]byte, 0, len(keyframes)*4)
+	var buf [binary.MaxVarintLen64]byte
+	var last time.Duration
+	for _, tm := range keyframes {
+		n := binary.PutVarint(buf[:], int64(tm-last))
+		b = append(b, buf[:n]...)
+		last = tm
+	}
+	return b
+}
+
+func decodeKeyframes(b []byte) (keyframes []time.Duration, err error) {
+	var last time.Duration
+	for len(b) > 0 {
+		d, n := binary.Varint(b)
+		if n <= 0 {
+			err = fmt.Errorf("boltstore: bad keyframes")
+			return
+		}
+		last += time.Duration(d)
+		keyframes = append(keyframes, last)
+		b = b[n:]
+	}
+	return
+}
+
+func getEntry(tx *bolt.Tx, path string) (entry index.Entry, ok bool, err error) {
+	v := tx.Bucket(entriesBucket).Get([]byte(path))
+	if v == nil {
+		return
+	}
+	if err = gob.NewDecoder(bytes.NewReader(v)).Decode(&entry); err != nil {
+		return
+	}
+	ok = true
+	return
+}
+
+func getKeyframes(tx *bolt.Tx, path string) ([]time.Duration, error) {
+	return decodeKeyframes(tx.Bucket(keyframesBucket).Get([]byte(path)))
+}
+
+func (self *Store) Get(path string) (entry index.Entry, ok bool, err error) {
+	err = self.db.View(func(tx *bolt.Tx) (err error) {
+		if entry, ok, err = getEntry(tx, path); err != nil || !ok {
+			return
+		}
+		entry.Keyframes, err = getKeyframes(tx, path)
+		return
+	})
+	return
+}
+
+func (self *Store) Put(entry index.Entry) error {
+	keyframes := entry.Keyframes
+	entry.Keyframes = nil
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(entry); err != nil {
+		return err
+	}
+	return self.db.Update(func(tx *bolt.Tx) (err error) {
+		if err = deleteEntry(tx, entry.Path); err != nil {
+			return
+		}
+		path := []byte(entry.Path)
+		if err = tx.Bucket(entriesBucket).Put(path, buf.Bytes()); err != nil {
+			return
+		}
+		if err = tx.Bucket(startsBucket).Put(startKey(entry.Start, entry.Path), []byte{}); err != nil {
+			return
+		}
+		if err = tx.Bucket(keyframesBucket).Put(path, encodeKeyframes(keyframes)); err != nil {
+			return
+		}
+		meta := tx.Bucket(metaBucket)
+		if v := meta.Get(maxDurationKey); len(v) == 8 && time.Duration(binary.BigEndian.Uint64(v)) >= entry.Duration {
+			return
+		}
+		v := make([]byte, 8)
+		binary.BigEndian.PutUint64(v, uint64(entry.Duration))
+		return meta.Put(maxDurationKey, v)
+	})
+}
+
+func deleteEntry(tx *bolt.Tx, path string) (err error) {
+	var entry index.Entry
+	var ok bool
+	if entry, ok, err = getEntry(tx, path); err != nil || !ok {
+		return
+	}
+	if err = tx.Bucket(startsBucket).Delete(startKey(entry.Start, path)); err != nil {
+		return
+	}
+	if err = tx.Bucket(keyframesBucket).Delete([]byte(path)); err != nil {
+		return
+	}
+	return tx.Bucket(entriesBucket).Delete([]byte(path))
+}
+
+func (self *Store) Delete(path string) error {
+	return self.db.Update(func(tx *bolt.Tx) error {
+		return deleteEntry(tx, path)
+	})
+}
+
+// All returns every entry with its keyframes, in path order.
+func (self *Store) All() (entries []index.Entry, err error) {
+	err = self.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(entriesBucket).ForEach(func(k, v []byte) (err error) {
+			var entry index.Entry
+			if err = gob.NewDecoder(bytes.NewReader(v)).Decode(&entry); err != nil {
+				return
+			}
+			if entry.Keyframes, err = getKeyframes(tx, string(k)); err != nil {
+				return
+			}
+			entries = append(entries, entry)
+			return
+		})
+	})
+	return
+}
+
+func (self *Store) Paths() (paths []string, err error) {
+	err = self.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(entriesBucket).ForEach(func(k, v []byte) error {
+			paths = append(paths, string(k))
+			return nil
+		})
+	})
+	return
+}
+
+// Range returns the entries overlapping [from, to) in start order, without
+// their keyframes. Only the entries starting in the range, or before it by
+// at most the longest duration put, are read.
+func (self *Store) Range(from, to time.Time) (entries []index.Entry, err error) {
+	err = self.db.View(func(tx *bolt.Tx) (err error) {
+		var maxDuration time.Duration
+		if v := tx.Bucket(metaBucket).Get(maxDurationKey); len(v) == 8 {
+			maxDuration = time.Duration(binary.BigEndian.Uint64(v))
+		}
+		end := to.UnixNano()
+		c := tx.Bucket(startsBucket).Cursor()
+		for k, _ := c.Seek(startKey(from.Add(-maxDuration), "")); k != nil && keyStart(k) < end; k, _ = c.Next() {
+			var entry index.Entry
+			var ok bool
+			if entry, ok, err = getEntry(tx, string(k[8:])); err != nil {
+				return
+			}
+			if ok && entry.End().After(from) {
+				entries = append(entries, entry)
+			}
+		}
+		return
+	})
+	return
+}
+
+func (self *Store) Keyframes(path string) (keyframes []time.Duration, err error) {
+	err = self.db.View(func(tx *bolt.Tx) (err error) {
+		keyframes, err = getKeyframes(tx, path)
+		return
+	})
+	return
+}
+
+func (self *Store) Close() error {
+	return self.db.Close()
+}
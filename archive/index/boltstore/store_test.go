@@ -0,0 +1,66 @@
+package boltstore
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/deepch/vdk/archive/index"
+)
+
+func TestRange(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "index.db")
+	store, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, name := range []string{"a.mp4", "b.mp4", "c.mp4"} {
+		entry := index.Entry{
+			Path:      name,
+			Start:     base.Add(time.Duration(i) * time.Hour),
+			Duration:  time.Hour,
+			Keyframes: []time.Duration{0, 2 * time.Second, 4 * time.Second},
+		}
+		if err = store.Put(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// moved to the next day
+	if err = store.Put(index.Entry{Path: "c.mp4", Start: base.Add(24 * time.Hour), Duration: time.Hour}); err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+	if store, err = Open(path); err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	idx := index.New(store)
+	hits, err := idx.Query(base.Add(3*time.Second), base.Add(90*time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var paths []string
+	for _, hit := range hits {
+		paths = append(paths, hit.Path)
+	}
+	if !reflect.DeepEqual(paths, []string{"a.mp4", "b.mp4"}) {
+		t.Fatalf("hits %v", paths)
+	}
+	if hits[0].Seek != 2*time.Second || hits[0].Keyframes == nil || hits[1].Keyframes != nil {
+		t.Fatalf("seek %v keyframes %v %v", hits[0].Seek, hits[0].Keyframes, hits[1].Keyframes)
+	}
+
+	if err = store.Delete("a.mp4"); err != nil {
+		t.Fatal(err)
+	}
+	if entries, err := store.Range(base, base.Add(48*time.Hour)); err != nil || len(entries) != 2 {
+		t.Fatalf("range %v %v", entries, err)
+	}
+	entry, ok, err := store.Get("b.mp4")
+	if err != nil || !ok || len(entry.Keyframes) != 3 || entry.Keyframes[2] != 4*time.Second {
+		t.Fatalf("get %v %v %v", entry, ok, err)
+	}
+}
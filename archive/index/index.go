@@ -0,0 +1,270 @@
+// Package index keeps a persistent database of recordings, their streams and
+// keyframe positions, and answers time-range queries over it.
+package index
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/deepch/vdk/av"
+	"github.com/deepch/vdk/av/avutil"
+)
+
+type StreamInfo struct {
+	Type       av.CodecType
+	Width      int
+	Height     int
+	SampleRate int
+	Channels   int
+}
+
+// Entry describes one recording. Keyframes are packet times as returned by
+// the demuxer, they can be given to SeekToTime as is.
+type Entry struct {
+	Path      string
+	Size      int64
+	ModTime   time.Time
+	Start     time.Time
+	Duration  time.Duration
+	Streams   []StreamInfo
+	Keyframes []time.Duration
+	FirstTime time.Duration // time of the first packet
+	Partial   bool          // a read error ended the recording before its end
+}
+
+func (self Entry) End() time.Time {
+	return self.Start.Add(self.Duration)
+}
+
+// KeyframeAt returns the last keyframe at or before wall clock time tm.
+func (self Entry) KeyframeAt(tm time.Time) time.Duration {
+	off := self.FirstTime + tm.Sub(self.Start)
+	i := sort.Search(len(self.Keyframes), func(i int) bool {
+		return self.Keyframes[i] > off
+	})
+	if i == 0 {
+		return self.FirstTime
+	}
+	return self.Keyframes[i-1]
+}
+
+// Hit is a recording overlapping a queried range, Seek is where playback of
+// the range has to start.
+type Hit struct {
+	Entry
+	Seek time.Duration
+}
+
+type Index struct {
+	Store Store
+	Exts  []string // file extensions scanned, DefaultExts if nil
+
+	// Open defaults to avutil.Open, the formats must have been registered.
+	Open func(path string) (av.DemuxCloser, error)
+
+	// StartTime gives the wall clock time of the first packet, defaults to
+	// the modification time minus the duration.
+	StartTime func(path string, info os.FileInfo, duration time.Duration) time.Time
+}
+
+var DefaultExts = []string{".mp4", ".ts"}
+
+func New(store Store) *Index {
+	return &Index{Store: store}
+}
+
+func (self *Index) exts() []string {
+	if self.Exts != nil {
+		return self.Exts
+	}
+	return DefaultExts
+}
+
+// Scan indexes the new or changed recordings under dir and forgets the ones
+// which were removed. A recording which can not be opened does not stop the
+// scan, the first such error is returned at the end.
+func (self *Index) Scan(dir string) (err error) {
+	if batcher, ok := self.Store.(Batcher); ok {
+		return batcher.Batch(func() error {
+			return self.scan(dir)
+		})
+	}
+	return self.scan(dir)
+}
+
+func (self *Index) scan(dir string) (err error) {
+	seen := map[string]bool{}
+	var fileErr error
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || !self.matchExt(path) {
+			return nil
+		}
+		seen[path] = true
+		if entry, ok, err := self.Store.Get(path); err != nil {
+			return err
+		} else if ok && entry.Size == info.Size() && entry.ModTime.Equal(info.ModTime()) {
+			return nil
+		}
+		if err := self.scanFile(path, info); err != nil && fileErr == nil {
+			fileErr = err
+		}
+		return nil
+	})
+	if err != nil {
+		return
+	}
+
+	var paths []string
+	if paths, err = self.Store.Paths(); err != nil {
+		return
+	}
+	for _, path := range paths {
+		if inDir(dir, path) && !seen[path] {
+			if err = self.Store.Delete(path); err != nil {
+				return
+			}
+		}
+	}
+	err = fileErr
+	return
+}
+
+// inDir tells if path, as walked from dir or another directory, is under
+// dir.
+func inDir(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// ScanFile (re)indexes a single recording.
+func (self *Index) ScanFile(path string) (err error) {
+	var info os.FileInfo
+	if info, err = os.Stat(path); err != nil {
+		return
+	}
+	return self.scanFile(path, info)
+}
+
+func (self *Index) matchExt(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	for _, e := range self.exts() {
+		if e == ext {
+			return true
+		}
+	}
+	return false
+}
+
+func (self *Index) scanFile(path string, info os.FileInfo) (err error) {
+	open := self.Open
+	if open == nil {
+		open = avutil.Open
+	}
+	var demuxer av.DemuxCloser
+	if demuxer, err = open(path); err != nil {
+		return fmt.Errorf("index: %s: %s", path, err)
+	}
+	defer demuxer.Close()
+
+	entry := Entry{
+		Path:    path,
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+	}
+	var streams []av.CodecData
+	if streams, err = demuxer.Streams(); err != nil {
+		return fmt.Errorf("index: %s: %s", path, err)
+	}
+	videoidx := -1
+	for i, stream := range streams {
+		si := StreamInfo{Type: stream.Type()}
+		if vs, ok := stream.(av.VideoCodecData); ok {
+			si.Width, si.Height = vs.Width(), vs.Height()
+			if videoidx == -1 {
+				videoidx = i
+			}
+		}
+		if as, ok := stream.(av.AudioCodecData); ok {
+			si.SampleRate, si.Channels = as.SampleRate(), as.ChannelLayout().Count()
+		}
+		entry.Streams = append(entry.Streams, si)
+	}
+
+	// a truncated recording is indexed up to where it can be read
+	var end time.Duration
+	first := true
+	for {
+		var pkt av.Packet
+		if pkt, err = demuxer.ReadPacket(); err != nil {
+			entry.Partial = err != io.EOF
+			err = nil
+			break
+		}
+		if first {
+			entry.FirstTime = pkt.Time
+			end = pkt.Time
+			first = false
+		}
+		if e := pkt.Time + pkt.Duration; e > end {
+			end = e
+		}
+		if int(pkt.Idx) == videoidx && pkt.IsKeyFrame {
+			entry.Keyframes = append(entry.Keyframes, pkt.Time)
+		}
+	}
+	entry.Duration = end - entry.FirstTime
+
+	if self.StartTime != nil {
+		entry.Start = self.StartTime(path, info, entry.Duration)
+	} else {
+		entry.Start = info.ModTime().Add(-entry.Duration)
+	}
+	return self.Store.Put(entry)
+}
+
+// Query returns the recordings overlapping [from, to) in start order. With
+// a RangeStore the keyframes of a hit are only read if the range starts
+// inside it, the other hits have nil Keyframes.
+func (self *Index) Query(from, to time.Time) (hits []Hit, err error) {
+	rangeStore, isRange := self.Store.(RangeStore)
+	var entries []Entry
+	if isRange {
+		entries, err = rangeStore.Range(from, to)
+	} else {
+		entries, err = self.Store.All()
+	}
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		if !entry.Start.Before(to) || !entry.End().After(from) {
+			continue
+		}
+		hit := Hit{Entry: entry, Seek: entry.FirstTime}
+		if from.After(entry.Start) {
+			if isRange && hit.Keyframes == nil {
+				if hit.Keyframes, err = rangeStore.Keyframes(entry.Path); err != nil {
+					return
+				}
+			}
+			hit.Seek = hit.KeyframeAt(from)
+		}
+		hits = append(hits, hit)
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		return hits[i].Start.Before(hits[j].Start)
+	})
+	return
+}
+
+func (self *Index) Close() error {
+	return self.Store.Close()
+}
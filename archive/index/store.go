@@ -0,0 +1,162 @@
+package index
+
+import (
+	"encoding/gob"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Store persists the entries, keyed by path. FileStore suits small archives,
+// boltstore keeps months of footage.
+type Store interface {
+	Get(path string) (entry Entry, ok bool, err error)
+	Put(entry Entry) error
+	Delete(path string) error
+	All() ([]Entry, error)
+	Paths() ([]string, error)
+	Close() error
+}
+
+// RangeStore is a Store able to find the entries overlapping a time range
+// without reading the others, Query uses it when its store is one. Range
+// may leave the Keyframes of the entries nil, Keyframes reads them.
+type RangeStore interface {
+	Range(from, to time.Time) ([]Entry, error)
+	Keyframes(path string) ([]time.Duration, error)
+}
+
+// Batcher is a Store able to group writes, Scan runs in a single batch when
+// its store is one.
+type Batcher interface {
+	Batch(fn func() error) error
+}
+
+// FileStore keeps the entries in memory and rewrites a gob file on change,
+// once per batch inside Batch.
+type FileStore struct {
+	path    string
+	entries map[string]Entry
+	lock    sync.Mutex
+	batch   int
+	dirty   bool
+}
+
+func OpenFileStore(path string) (self *FileStore, err error) {
+	self = &FileStore{
+		path:    path,
+		entries: map[string]Entry{},
+	}
+	var f *os.File
+	if f, err = os.Open(path); err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return
+	}
+	defer f.Close()
+	var entries []Entry
+	if err = gob.NewDecoder(f).Decode(&entries); err != nil {
+		return
+	}
+	for _, entry := range entries {
+		self.entries[entry.Path] = entry
+	}
+	return
+}
+
+func (self *FileStore) Get(path string) (entry Entry, ok bool, err error) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	entry, ok = self.entries[path]
+	return
+}
+
+func (self *FileStore) Put(entry Entry) error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.entries[entry.Path] = entry
+	return self.changed()
+}
+
+func (self *FileStore) Delete(path string) error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	delete(self.entries, path)
+	return self.changed()
+}
+
+func (self *FileStore) Paths() (paths []string, err error) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	for path := range self.entries {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return
+}
+
+func (self *FileStore) All() (entries []Entry, err error) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	for _, entry := range self.entries {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Path < entries[j].Path
+	})
+	return
+}
+
+// Batch runs fn and saves the changes it made once at the end.
+func (self *FileStore) Batch(fn func() error) (err error) {
+	self.lock.Lock()
+	self.batch++
+	self.lock.Unlock()
+
+	err = fn()
+
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.batch--
+	if self.batch == 0 && self.dirty {
+		if serr := self.save(); err == nil {
+			err = serr
+		}
+	}
+	return
+}
+
+func (self *FileStore) changed() error {
+	if self.batch > 0 {
+		self.dirty = true
+		return nil
+	}
+	return self.save()
+}
+
+func (self *FileStore) Close() error {
+	return nil
+}
+
+func (self *FileStore) save() (err error) {
+	self.dirty = false
+	entries := make([]Entry, 0, len(self.entries))
+	for _, entry := range self.entries {
+		entries = append(entries, entry)
+	}
+	tmp := self.path + ".tmp"
+	var f *os.File
+	if f, err = os.Create(tmp); err != nil {
+		return
+	}
+	if err = gob.NewEncoder(f).Encode(entries); err != nil {
+		f.Close()
+		return
+	}
+	if err = f.Close(); err != nil {
+		return
+	}
+	return os.Rename(tmp, self.path)
+}
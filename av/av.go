@@ -3,6 +3,7 @@ package av
 
 import (
 	"fmt"
+	"strings"
 	"time"
 )
 
@@ -129,10 +130,15 @@ var (
 	NELLYMOSER = MakeAudioCodecType(avCodecTypeMagic + 5)
 	PCM        = MakeAudioCodecType(avCodecTypeMagic + 6)
 	OPUS       = MakeAudioCodecType(avCodecTypeMagic + 7)
+
+	DVB_SUBTITLE   = MakeDataCodecType(avCodecTypeMagic + 1)
+	DVB_TELETEXT   = MakeDataCodecType(avCodecTypeMagic + 2)
+	ONVIF_METADATA = MakeDataCodecType(avCodecTypeMagic + 3)
 )
 
 const codecTypeAudioBit = 0x1
 const codecTypeOtherBits = 1
+const codecTypeDataBit = 0x80000000
 
 func (self CodecType) String() string {
 	switch self {
@@ -162,6 +168,12 @@ func (self CodecType) String() string {
 		return "PCM"
 	case OPUS:
 		return "OPUS"
+	case DVB_SUBTITLE:
+		return "DVB_SUBTITLE"
+	case DVB_TELETEXT:
+		return "DVB_TELETEXT"
+	case ONVIF_METADATA:
+		return "ONVIF_METADATA"
 	}
 	return ""
 }
@@ -171,7 +183,12 @@ func (self CodecType) IsAudio() bool {
 }
 
 func (self CodecType) IsVideo() bool {
-	return self&codecTypeAudioBit == 0
+	return self&codecTypeAudioBit == 0 && self&codecTypeDataBit == 0
+}
+
+// IsData reports streams which are neither audio nor video, such as subtitles.
+func (self CodecType) IsData() bool {
+	return self&codecTypeDataBit != 0
 }
 
 // Make a new audio codec type.
@@ -186,6 +203,12 @@ func MakeVideoCodecType(base uint32) (c CodecType) {
 	return
 }
 
+// Make a new data codec type.
+func MakeDataCodecType(base uint32) (c CodecType) {
+	c = CodecType(base)<<codecTypeOtherBits | CodecType(codecTypeDataBit)
+	return
+}
+
 const avCodecTypeMagic = 233333
 
 // CodecData is some important bytes for initializing audio/video decoder,
@@ -204,6 +227,86 @@ type VideoCodecData interface {
 	Height() int // Video height
 }
 
+// MasteringDisplay is the SMPTE ST 2086 mastering display colour volume.
+// Chromaticities are in 0.00002 units, luminance in 0.0001 cd/m2.
+type MasteringDisplay struct {
+	DisplayPrimaries [3][2]uint16 // G, B, R (x, y)
+	WhitePoint       [2]uint16
+	MaxLuminance     uint32
+	MinLuminance     uint32
+}
+
+// ContentLightLevel is the CTA-861.3 content light level, in cd/m2.
+type ContentLightLevel struct {
+	MaxCLL  uint16
+	MaxFALL uint16
+}
+
+// ColorInfo carries video colour signaling using ISO/IEC 23091-2 code points.
+// A zero Primaries means the colour description is not present.
+type ColorInfo struct {
+	Primaries        uint8
+	Transfer         uint8
+	Matrix           uint8
+	FullRange        bool
+	MasteringDisplay *MasteringDisplay
+	ContentLight     *ContentLightLevel
+}
+
+// HasColorDescription reports whether primaries/transfer/matrix are set.
+func (self ColorInfo) HasColorDescription() bool {
+	return self.Primaries != 0
+}
+
+// ColorCodecData is implemented by video codec data which carries colour signaling.
+type ColorCodecData interface {
+	VideoCodecData
+	ColorInfo() ColorInfo
+}
+
+// FieldOrder describes how the pictures of a video stream are scanned.
+type FieldOrder uint8
+
+const (
+	FieldOrderUnknown FieldOrder = iota
+	FieldOrderProgressive
+	FieldOrderTopFirst
+	FieldOrderBottomFirst
+)
+
+func (self FieldOrder) String() string {
+	switch self {
+	case FieldOrderProgressive:
+		return "progressive"
+	case FieldOrderTopFirst:
+		return "tff"
+	case FieldOrderBottomFirst:
+		return "bff"
+	}
+	return "unknown"
+}
+
+// FieldOrderCodecData is implemented by video codec data which knows
+// whether it is interlaced.
+type FieldOrderCodecData interface {
+	VideoCodecData
+	FieldOrder() FieldOrder
+}
+
+// RotationCodecData is implemented by video codec data which carries a
+// display rotation (degrees clockwise).
+type RotationCodecData interface {
+	VideoCodecData
+	DisplayRotation() int
+}
+
+// ReorderCodecData is implemented by video codec data which knows how many
+// frames may be reordered between decode and presentation order.
+type ReorderCodecData interface {
+	VideoCodecData
+	ReorderDepth() int
+}
+
 type AudioCodecData interface {
 	CodecData
 	SampleFormat() SampleFormat                   // audio sample format
@@ -255,6 +358,67 @@ type Packet struct {
 	Time            time.Duration // packet decode time
 	Duration        time.Duration //packet duration
 	Data            []byte        // packet data
+	Flags           PacketFlags   // set by the demuxers which can tell
+}
+
+// PacketFlags tell downstream which packets matter least, a shaper under
+// congestion drops the disposable ones first.
+type PacketFlags uint8
+
+const (
+	PacketDisposable    PacketFlags = 1 << iota // no other frame is predicted from it
+	PacketReference                             // other frames are predicted from it
+	PacketCorrupt                               // data is known to be damaged or incomplete
+	PacketDiscontinuity                         // time or data does not follow the previous packet
+	PacketRandomAccess                          // decoding can start here, though not at an IDR frame
+	PacketGap                                   // audio was lost, no Data, Duration is the missing span
+)
+
+func (self PacketFlags) Has(flags PacketFlags) bool {
+	return self&flags == flags
+}
+
+func (self PacketFlags) String() string {
+	var names []string
+	for _, flag := range []struct {
+		flag PacketFlags
+		name string
+	}{
+		{PacketDisposable, "disposable"},
+		{PacketReference, "reference"},
+		{PacketCorrupt, "corrupt"},
+		{PacketDiscontinuity, "discontinuity"},
+		{PacketRandomAccess, "random_access"},
+		{PacketGap, "gap"},
+	} {
+		if self&flag.flag != 0 {
+			names = append(names, flag.name)
+		}
+	}
+	return strings.Join(names, "|")
+}
+
+// IsRandomAccess tells whether decoding can start at the packet: a key frame,
+// or an entry point of a stream refreshed gradually, whose pictures are
+// correct only after a recovery period.
+func (self Packet) IsRandomAccess() bool {
+	return self.IsKeyFrame || self.Flags&PacketRandomAccess != 0
+}
+
+// Priority orders packets for dropping, lowest first: corrupt, disposable,
+// unflagged, reference and key frames.
+func (self Packet) Priority() int {
+	switch {
+	case self.Flags&PacketCorrupt != 0:
+		return 0
+	case self.Flags&PacketDisposable != 0:
+		return 1
+	case self.IsKeyFrame:
+		return 4
+	case self.Flags&PacketReference != 0:
+		return 3
+	}
+	return 2
 }
 
 // Raw audio frame.
@@ -2,8 +2,10 @@ package avutil
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io"
+	"net/http"
 	"net/url"
 	"os"
 	"path"
@@ -12,6 +14,7 @@ import (
 	"github.com/deepch/vdk/av"
 	"github.com/deepch/vdk/codec/aacparser"
 	"github.com/deepch/vdk/codec/h264parser"
+	"github.com/deepch/vdk/utils/rangecache"
 )
 
 type HandlerDemuxer struct {
@@ -71,8 +74,17 @@ type RegisterHandler struct {
 	CodecTypes    []av.CodecType
 }
 
+// DefaultProbeSize is how many bytes of a stream are read to detect its
+// format.
+const DefaultProbeSize = 1024
+
 type Handlers struct {
-	handlers []RegisterHandler
+	handlers  []RegisterHandler
+	ProbeSize int // DefaultProbeSize if zero
+	// HTTPCache, if set, opens http and https URLs served with range
+	// requests as seekable files read through it, which formats such as
+	// mp4 need. They are streamed otherwise.
+	HTTPCache *rangecache.Cache
 }
 
 func (self *Handlers) Add(fn func(*RegisterHandler)) {
@@ -91,6 +103,27 @@ func (self *Handlers) openUrl(u *url.URL, uri string) (r io.ReadCloser, err erro
 				}
 			}
 		}
+		if (u.Scheme == "http" || u.Scheme == "https") && self.HTTPCache != nil {
+			var src *rangecache.HTTPSource
+			if src, err = rangecache.NewHTTPSource(uri, nil); err == nil {
+				cached := self.HTTPCache.Open(src.Key(), src, src.Size())
+				r = nopCloser{io.NewSectionReader(cached, 0, cached.Size())}
+				return
+			}
+		}
+		if u.Scheme == "http" || u.Scheme == "https" {
+			var res *http.Response
+			if res, err = http.Get(uri); err != nil {
+				return
+			}
+			if res.StatusCode != http.StatusOK {
+				res.Body.Close()
+				err = fmt.Errorf("avutil: openUrl %s: status %s", uri, res.Status)
+				return
+			}
+			r = res.Body
+			return
+		}
 		err = fmt.Errorf("avutil: openUrl %s failed", uri)
 	} else {
 		r, err = os.Open(uri)
@@ -98,6 +131,15 @@ func (self *Handlers) openUrl(u *url.URL, uri string) (r io.ReadCloser, err erro
 	return
 }
 
+// nopCloser keeps the io.Seeker of the reader, which io.NopCloser hides.
+type nopCloser struct {
+	io.ReadSeeker
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
+
 func (self *Handlers) createUrl(u *url.URL, uri string) (w io.WriteCloser, err error) {
 	w, err = os.Create(uri)
 	return
@@ -161,52 +203,90 @@ func (self *Handlers) Open(uri string) (demuxer av.DemuxCloser, err error) {
 		ext = path.Ext(uri)
 	}
 
+	if r, err = self.openUrl(u, uri); err != nil {
+		return
+	}
+	var d av.Demuxer
+	if d, err = self.openReader(r, ext); err != nil {
+		r.Close()
+		err = fmt.Errorf("avutil: open %s failed: %s", uri, err)
+		return
+	}
+	demuxer = &HandlerDemuxer{
+		Demuxer: d,
+		r:       r,
+	}
+	return
+}
+
+func (self *Handlers) probeSize() int {
+	if self.ProbeSize > 0 {
+		return self.ProbeSize
+	}
+	return DefaultProbeSize
+}
+
+// Probe returns the handler able to demux the stream starting with b, as
+// told by its Probe func.
+func (self *Handlers) Probe(b []byte) (handler RegisterHandler, ok bool) {
+	if size := self.probeSize(); len(b) < size {
+		// probes look at fixed offsets, shorter streams are padded
+		padded := make([]byte, size)
+		copy(padded, b)
+		b = padded
+	}
+	for _, handler = range self.handlers {
+		if handler.Probe != nil && handler.ReaderDemuxer != nil && handler.Probe(b) {
+			ok = true
+			return
+		}
+	}
+	handler = RegisterHandler{}
+	return
+}
+
+// OpenReader detects the format of r from its first ProbeSize bytes and
+// returns its demuxer. When r is an io.ReadSeeker it seeks back over the
+// bytes probed, otherwise they are read again from memory.
+func (self *Handlers) OpenReader(r io.Reader) (demuxer av.Demuxer, err error) {
+	return self.openReader(r, "")
+}
+
+// openReader uses the handler of ext, r is probed only when ext is empty or
+// no handler demuxes it.
+func (self *Handlers) openReader(r io.Reader, ext string) (demuxer av.Demuxer, err error) {
 	if ext != "" {
 		for _, handler := range self.handlers {
-			if handler.Ext == ext {
-				if handler.ReaderDemuxer != nil {
-					if r, err = self.openUrl(u, uri); err != nil {
-						return
-					}
-					demuxer = &HandlerDemuxer{
-						Demuxer: handler.ReaderDemuxer(r),
-						r:       r,
-					}
-					return
-				}
+			if handler.Ext == ext && handler.ReaderDemuxer != nil {
+				demuxer = handler.ReaderDemuxer(r)
+				return
 			}
 		}
 	}
 
-	var probebuf [1024]byte
-	if r, err = self.openUrl(u, uri); err != nil {
-		return
+	probebuf := make([]byte, self.probeSize())
+	var n int
+	if n, err = io.ReadFull(r, probebuf); err == io.ErrUnexpectedEOF {
+		err = nil
 	}
-	if _, err = io.ReadFull(r, probebuf[:]); err != nil {
+	if err != nil {
 		return
 	}
+	probebuf = probebuf[:n]
 
-	for _, handler := range self.handlers {
-		if handler.Probe != nil && handler.Probe(probebuf[:]) && handler.ReaderDemuxer != nil {
-			var _r io.Reader
-			if rs, ok := r.(io.ReadSeeker); ok {
-				if _, err = rs.Seek(0, 0); err != nil {
-					return
-				}
-				_r = rs
-			} else {
-				_r = io.MultiReader(bytes.NewReader(probebuf[:]), r)
-			}
-			demuxer = &HandlerDemuxer{
-				Demuxer: handler.ReaderDemuxer(_r),
-				r:       r,
-			}
+	handler, ok := self.Probe(probebuf)
+	if !ok {
+		err = fmt.Errorf("avutil: format not recognized")
+		return
+	}
+	if rs, ok := r.(io.ReadSeeker); ok {
+		if _, err = rs.Seek(int64(-n), io.SeekCurrent); err != nil {
 			return
 		}
+		demuxer = handler.ReaderDemuxer(rs)
+	} else {
+		demuxer = handler.ReaderDemuxer(io.MultiReader(bytes.NewReader(probebuf), r))
 	}
-
-	r.Close()
-	err = fmt.Errorf("avutil: open %s failed", uri)
 	return
 }
 
@@ -278,39 +358,17 @@ func Create(url string) (muxer av.MuxCloser, err error) {
 	return DefaultHandlers.Create(url)
 }
 
+func OpenReader(r io.Reader) (demuxer av.Demuxer, err error) {
+	return DefaultHandlers.OpenReader(r)
+}
+
+// CopyPackets returns io.EOF once src is drained.
 func CopyPackets(dst av.PacketWriter, src av.PacketReader) (err error) {
-	for {
-		var pkt av.Packet
-		if pkt, err = src.ReadPacket(); err != nil {
-			if err == io.EOF {
-				break
-			}
-			return
-		}
-		if err = dst.WritePacket(pkt); err != nil {
-			return
-		}
-	}
-	return
+	return CopyPacketsContext(context.Background(), dst, src, CopyOptions{})
 }
 
 func CopyFile(dst av.Muxer, src av.Demuxer) (err error) {
-	var streams []av.CodecData
-	if streams, err = src.Streams(); err != nil {
-		return
-	}
-	if err = dst.WriteHeader(streams); err != nil {
-		return
-	}
-	if err = CopyPackets(dst, src); err != nil {
-		if err != io.EOF {
-			return
-		}
-	}
-	if err = dst.WriteTrailer(); err != nil {
-		return
-	}
-	return
+	return CopyFileContext(context.Background(), dst, src, CopyOptions{})
 }
 
 func Equal(c1 []av.CodecData, c2 []av.CodecData) bool {
@@ -0,0 +1,138 @@
+package avutil
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/deepch/vdk/av"
+)
+
+const DefaultProgressInterval = time.Second
+
+// Progress of a copy, ETA is only known with the Duration of the source.
+type Progress struct {
+	Packets  int64
+	Bytes    int64
+	Time     time.Duration // of the media copied, from the first packet
+	Duration time.Duration
+	Elapsed  time.Duration
+	ETA      time.Duration
+	Done     bool
+}
+
+// Fraction returns the part copied from 0 to 1, or -1 without Duration.
+func (self Progress) Fraction() float64 {
+	if self.Duration <= 0 {
+		return -1
+	}
+	if self.Done || self.Time >= self.Duration {
+		return 1
+	}
+	return float64(self.Time) / float64(self.Duration)
+}
+
+type CopyOptions struct {
+	Duration   time.Duration // of the source, for the ETA
+	Interval   time.Duration // between OnProgress calls, DefaultProgressInterval if zero
+	OnProgress func(Progress)
+}
+
+type progressTracker struct {
+	opts     CopyOptions
+	start    time.Time
+	last     time.Time
+	first    time.Duration
+	started  bool
+	progress Progress
+}
+
+func (self *progressTracker) add(pkt av.Packet) {
+	if !self.started {
+		self.first = pkt.Time
+		self.started = true
+	}
+	self.progress.Packets++
+	self.progress.Bytes += int64(len(pkt.Data))
+	if tm := pkt.Time - self.first; tm > self.progress.Time {
+		self.progress.Time = tm
+	}
+	if self.opts.OnProgress == nil {
+		return
+	}
+	interval := self.opts.Interval
+	if interval <= 0 {
+		interval = DefaultProgressInterval
+	}
+	if now := time.Now(); now.Sub(self.last) >= interval {
+		self.last = now
+		self.report(false)
+	}
+}
+
+func (self *progressTracker) report(done bool) {
+	if self.opts.OnProgress == nil {
+		return
+	}
+	p := self.progress
+	p.Duration = self.opts.Duration
+	p.Elapsed = time.Since(self.start)
+	p.Done = done
+	if !done && p.Duration > 0 && p.Time > 0 && p.Time < p.Duration {
+		p.ETA = time.Duration(float64(p.Elapsed) * float64(p.Duration-p.Time) / float64(p.Time))
+	}
+	self.opts.OnProgress(p)
+}
+
+// CopyPacketsContext is CopyPackets stopping between packets with the error
+// of ctx once done, and reporting its progress. Like CopyPackets it returns
+// io.EOF once src is drained.
+func CopyPacketsContext(ctx context.Context, dst av.PacketWriter, src av.PacketReader, opts CopyOptions) (err error) {
+	tracker := &progressTracker{opts: opts, start: time.Now()}
+	tracker.last = tracker.start
+	for {
+		select {
+		case <-ctx.Done():
+			tracker.report(false)
+			return ctx.Err()
+		default:
+		}
+		var pkt av.Packet
+		if pkt, err = src.ReadPacket(); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return
+		}
+		if err = dst.WritePacket(pkt); err != nil {
+			return
+		}
+		tracker.add(pkt)
+	}
+	tracker.report(true)
+	return
+}
+
+// CopyFileContext is CopyFile with the cancellation and progress of
+// CopyPacketsContext, the trailer is still written when cancelled so the
+// output holds what was copied.
+func CopyFileContext(ctx context.Context, dst av.Muxer, src av.Demuxer, opts CopyOptions) (err error) {
+	var streams []av.CodecData
+	if streams, err = src.Streams(); err != nil {
+		return
+	}
+	if err = dst.WriteHeader(streams); err != nil {
+		return
+	}
+	if err = CopyPacketsContext(ctx, dst, src, opts); err == io.EOF {
+		err = nil
+	} else if err != ctx.Err() {
+		return
+	}
+	cancelled := err
+	if err = dst.WriteTrailer(); err != nil {
+		return
+	}
+	err = cancelled
+	return
+}
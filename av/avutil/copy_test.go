@@ -0,0 +1,55 @@
+package avutil
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/deepch/vdk/av"
+)
+
+func TestCopyEnd(t *testing.T) {
+	readErr := fmt.Errorf("read failed")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for _, test := range []struct {
+		name    string
+		ctx     context.Context
+		items   []scriptItem
+		packets int
+		err     error // of CopyPackets, CopyFile returns nil for io.EOF
+	}{
+		{"drained", context.Background(), []scriptItem{{pkt: av.Packet{Time: 0}}, {pkt: av.Packet{Time: time.Second}}}, 2, io.EOF},
+		{"empty", context.Background(), nil, 0, io.EOF},
+		{"read error", context.Background(), []scriptItem{{pkt: av.Packet{}}, {err: readErr}}, 1, readErr},
+		{"cancelled", ctx, []scriptItem{{pkt: av.Packet{}}}, 0, context.Canceled},
+	} {
+		muxer := &recordMuxer{}
+		err := CopyPacketsContext(test.ctx, muxer, &scriptDemuxer{items: append([]scriptItem(nil), test.items...)}, CopyOptions{})
+		if err != test.err || len(muxer.pkts) != test.packets {
+			t.Errorf("%s: CopyPacketsContext copied %d packets with %v, want %d with %v", test.name, len(muxer.pkts), err, test.packets, test.err)
+		}
+		if test.ctx == context.Background() {
+			muxer = &recordMuxer{}
+			err = CopyPackets(muxer, &scriptDemuxer{items: append([]scriptItem(nil), test.items...)})
+			if err != test.err || len(muxer.pkts) != test.packets {
+				t.Errorf("%s: CopyPackets copied %d packets with %v, want %d with %v", test.name, len(muxer.pkts), err, test.packets, test.err)
+			}
+		}
+
+		want := test.err
+		if want == io.EOF {
+			want = nil
+		}
+		muxer = &recordMuxer{}
+		err = CopyFileContext(test.ctx, muxer, &scriptDemuxer{items: append([]scriptItem(nil), test.items...)}, CopyOptions{})
+		if err != want || len(muxer.pkts) != test.packets {
+			t.Errorf("%s: CopyFileContext copied %d packets with %v, want %d with %v", test.name, len(muxer.pkts), err, test.packets, want)
+		}
+		if trailer := test.err != readErr; muxer.trailer != trailer {
+			t.Errorf("%s: trailer written %v, want %v", test.name, muxer.trailer, trailer)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package avutil
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/deepch/vdk/av"
+)
+
+// DefaultTimeTolerance is the time a round trip may shift packets by, the
+// millisecond of the FLV timestamps.
+const DefaultTimeTolerance = time.Millisecond
+
+// memFile is a file in memory for the muxers that seek back.
+type memFile struct {
+	b   []byte
+	pos int64
+}
+
+func (self *memFile) Write(b []byte) (n int, err error) {
+	if end := self.pos + int64(len(b)); end > int64(len(self.b)) {
+		self.b = append(self.b, make([]byte, end-int64(len(self.b)))...)
+	}
+	n = copy(self.b[self.pos:], b)
+	self.pos += int64(n)
+	return
+}
+
+func (self *memFile) Seek(offset int64, whence int) (pos int64, err error) {
+	switch whence {
+	case io.SeekStart:
+		pos = offset
+	case io.SeekCurrent:
+		pos = self.pos + offset
+	case io.SeekEnd:
+		pos = int64(len(self.b)) + offset
+	}
+	if pos < 0 {
+		err = fmt.Errorf("avutil: negative seek position")
+		return
+	}
+	self.pos = pos
+	return
+}
+
+func (self *memFile) ReadAt(b []byte, off int64) (n int, err error) {
+	if off >= int64(len(self.b)) {
+		err = io.EOF
+		return
+	}
+	if n = copy(b, self.b[off:]); n < len(b) {
+		err = io.EOF
+	}
+	return
+}
+
+// RoundTrip muxes streams and pkts with the muxer of each format of exts
+// in turn, as ".mp4", ".ts", the packets read back from one feeding the
+// next, and compares the packets read back from the last with pkts, see
+// ComparePackets. A zero tolerance is DefaultTimeTolerance.
+func (self *Handlers) RoundTrip(streams []av.CodecData, pkts []av.Packet, tolerance time.Duration, exts ...string) (err error) {
+	gotStreams, got := streams, pkts
+	for _, ext := range exts {
+		if gotStreams, got, err = self.roundTrip(ext, gotStreams, got); err != nil {
+			err = fmt.Errorf("avutil: round trip through %s: %v", ext, err)
+			return
+		}
+	}
+	if len(gotStreams) != len(streams) {
+		err = fmt.Errorf("avutil: %d streams, want %d", len(gotStreams), len(streams))
+		return
+	}
+	for i := range streams {
+		if gotStreams[i].Type() != streams[i].Type() {
+			err = fmt.Errorf("avutil: stream#%d: codec %v, want %v", i, gotStreams[i].Type(), streams[i].Type())
+			return
+		}
+	}
+	if tolerance == 0 {
+		tolerance = DefaultTimeTolerance
+	}
+	return ComparePackets(streams, pkts, got, tolerance)
+}
+
+func (self *Handlers) roundTrip(ext string, streams []av.CodecData, pkts []av.Packet) (outStreams []av.CodecData, out []av.Packet, err error) {
+	var handler *RegisterHandler
+	for i := range self.handlers {
+		if h := &self.handlers[i]; h.Ext == ext && h.WriterMuxer != nil && h.ReaderDemuxer != nil {
+			handler = h
+			break
+		}
+	}
+	if handler == nil {
+		err = fmt.Errorf("no muxer and demuxer")
+		return
+	}
+
+	file := &memFile{}
+	muxer := handler.WriterMuxer(file)
+	if muxer == nil {
+		err = fmt.Errorf("no muxer")
+		return
+	}
+	if err = muxer.WriteHeader(streams); err != nil {
+		return
+	}
+	for _, pkt := range pkts {
+		if err = muxer.WritePacket(pkt); err != nil {
+			return
+		}
+	}
+	if err = muxer.WriteTrailer(); err != nil {
+		return
+	}
+
+	demuxer := handler.ReaderDemuxer(bytes.NewReader(file.b))
+	if outStreams, err = demuxer.Streams(); err != nil {
+		return
+	}
+	for {
+		var pkt av.Packet
+		if pkt, err = demuxer.ReadPacket(); err != nil {
+			if err == io.EOF {
+				err = nil
+			}
+			return
+		}
+		out = append(out, pkt)
+	}
+}
+
+// RoundTrip is DefaultHandlers.RoundTrip.
+func RoundTrip(streams []av.CodecData, pkts []av.Packet, tolerance time.Duration, exts ...string) (err error) {
+	return DefaultHandlers.RoundTrip(streams, pkts, tolerance, exts...)
+}
+
+// ComparePackets returns the first difference between the packets want
+// and got of streams: their number by stream, then in the order of each
+// stream their data, their time and composition time within tolerance,
+// and the key frame flag of video. Streams may be interleaved differently
+// and times are from the first packet, as formats may start from another
+// time.
+func ComparePackets(streams []av.CodecData, want, got []av.Packet, tolerance time.Duration) (err error) {
+	byStream := func(pkts []av.Packet) map[int8][]av.Packet {
+		m := map[int8][]av.Packet{}
+		for _, pkt := range pkts {
+			m[pkt.Idx] = append(m[pkt.Idx], pkt)
+		}
+		return m
+	}
+	start := func(pkts []av.Packet) (tm time.Duration) {
+		for i, pkt := range pkts {
+			if i == 0 || pkt.Time < tm {
+				tm = pkt.Time
+			}
+		}
+		return
+	}
+	wants, gots := byStream(want), byStream(got)
+	offset := start(got) - start(want)
+	within := func(a, b time.Duration) bool {
+		d := a - b
+		return d <= tolerance && -d <= tolerance
+	}
+	for idx := int8(0); int(idx) < len(streams); idx++ {
+		w, g := wants[idx], gots[idx]
+		if len(w) != len(g) {
+			err = fmt.Errorf("avutil: stream#%d: %d packets, want %d", idx, len(g), len(w))
+			return
+		}
+		video := streams[idx].Type().IsVideo()
+		for i := range w {
+			switch {
+			case !bytes.Equal(w[i].Data, g[i].Data):
+				err = fmt.Errorf("avutil: stream#%d packet#%d: data differs", idx, i)
+			case !within(w[i].Time, g[i].Time-offset):
+				err = fmt.Errorf("avutil: stream#%d packet#%d: time=%v, want %v", idx, i, g[i].Time-offset, w[i].Time)
+			case !within(w[i].CompositionTime, g[i].CompositionTime):
+				err = fmt.Errorf("avutil: stream#%d packet#%d: composition time=%v, want %v", idx, i, g[i].CompositionTime, w[i].CompositionTime)
+			case video && w[i].IsKeyFrame != g[i].IsKeyFrame:
+				err = fmt.Errorf("avutil: stream#%d packet#%d: keyframe=%v, want %v", idx, i, g[i].IsKeyFrame, w[i].IsKeyFrame)
+			}
+			if err != nil {
+				return
+			}
+		}
+	}
+	return
+}
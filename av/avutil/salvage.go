@@ -0,0 +1,139 @@
+package avutil
+
+import (
+	"io"
+	"time"
+
+	"github.com/deepch/vdk/av"
+)
+
+const DefaultMaxSalvageErrors = 1000
+
+// Resyncer is a demuxer able to go on after a read error by skipping to the
+// next point it can parse. Resync returns the byte region given up, from
+// the start of what failed, and io.EOF when nothing parsable is left.
+type Resyncer interface {
+	Resync() (offset int64, length int64, err error)
+}
+
+// SkippedRegion is a part of the source given up after Err.
+type SkippedRegion struct {
+	Offset int64
+	Length int64
+	After  time.Duration // time of the last packet read before it
+	Err    error
+}
+
+type SalvageOptions struct {
+	MaxErrors int // DefaultMaxSalvageErrors if zero
+}
+
+// SalvageResult tells what Salvage got out of the source. Err is the error
+// it could not get past, nil when it read to the end.
+type SalvageResult struct {
+	Streams []av.CodecData
+	Packets int64
+	Start   time.Duration
+	End     time.Duration
+	Skipped []SkippedRegion
+	Err     error
+}
+
+type salvager struct {
+	src    Resyncer
+	opts   SalvageOptions
+	result *SalvageResult
+	errors int
+}
+
+// skip resyncs src after err, it returns false when the read cannot go on
+// and sets the error of the result.
+func (self *salvager) skip(err error) bool {
+	max := self.opts.MaxErrors
+	if max <= 0 {
+		max = DefaultMaxSalvageErrors
+	}
+	if self.src == nil || self.errors >= max {
+		self.result.Err = err
+		return false
+	}
+	self.errors++
+	offset, length, rerr := self.src.Resync()
+	region := SkippedRegion{Offset: offset, Length: length, After: self.result.End, Err: err}
+	if n := len(self.result.Skipped); n > 0 && self.result.Skipped[n-1].Offset+self.result.Skipped[n-1].Length == offset {
+		self.result.Skipped[n-1].Length += length
+	} else {
+		self.result.Skipped = append(self.result.Skipped, region)
+	}
+	if rerr != nil {
+		if rerr != io.EOF {
+			self.result.Err = rerr
+		}
+		return false
+	}
+	return true
+}
+
+// Salvage copies src to dst as far as it can be read, skipping the damaged
+// regions of a demuxer which is a Resyncer instead of stopping at the first
+// one. dst may be nil to only scan the source. The returned error is the
+// one of dst, the errors of src are in the result. The first packet after a
+// skipped region is flagged as a discontinuity.
+func Salvage(dst av.Muxer, src av.Demuxer, opts SalvageOptions) (result SalvageResult, err error) {
+	self := &salvager{opts: opts, result: &result}
+	self.src, _ = src.(Resyncer)
+
+	for {
+		var serr error
+		if result.Streams, serr = src.Streams(); serr == nil {
+			break
+		}
+		if serr == io.EOF || !self.skip(serr) {
+			if result.Err == nil {
+				result.Err = serr
+			}
+			return
+		}
+	}
+	if dst != nil {
+		if err = dst.WriteHeader(result.Streams); err != nil {
+			return
+		}
+	}
+
+	discontinuity := false
+	for {
+		pkt, rerr := src.ReadPacket()
+		if rerr == io.EOF {
+			break
+		}
+		if rerr != nil {
+			if !self.skip(rerr) {
+				break
+			}
+			discontinuity = true
+			continue
+		}
+		if discontinuity {
+			pkt.Flags |= av.PacketDiscontinuity
+			discontinuity = false
+		}
+		if dst != nil {
+			if err = dst.WritePacket(pkt); err != nil {
+				return
+			}
+		}
+		if result.Packets == 0 {
+			result.Start = pkt.Time
+		}
+		result.Packets++
+		if pkt.Time > result.End {
+			result.End = pkt.Time
+		}
+	}
+
+	if dst != nil {
+		err = dst.WriteTrailer()
+	}
+	return
+}
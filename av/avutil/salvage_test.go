@@ -0,0 +1,183 @@
+package avutil
+
+import (
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/deepch/vdk/av"
+	"github.com/deepch/vdk/codec"
+)
+
+type scriptItem struct {
+	pkt av.Packet
+	err error
+}
+
+type scriptRegion struct {
+	offset, length int64
+	err            error
+}
+
+// scriptDemuxer reads items in order and resyncs to the next region.
+type scriptDemuxer struct {
+	streamErrs []error
+	items      []scriptItem
+	regions    []scriptRegion
+}
+
+func (self *scriptDemuxer) Streams() ([]av.CodecData, error) {
+	if len(self.streamErrs) > 0 {
+		err := self.streamErrs[0]
+		self.streamErrs = self.streamErrs[1:]
+		return nil, err
+	}
+	return []av.CodecData{codec.NewPCMMulawCodecData()}, nil
+}
+
+func (self *scriptDemuxer) ReadPacket() (pkt av.Packet, err error) {
+	if len(self.items) == 0 {
+		err = io.EOF
+		return
+	}
+	item := self.items[0]
+	self.items = self.items[1:]
+	return item.pkt, item.err
+}
+
+func (self *scriptDemuxer) Resync() (offset int64, length int64, err error) {
+	region := self.regions[0]
+	self.regions = self.regions[1:]
+	return region.offset, region.length, region.err
+}
+
+// plainDemuxer hides Resync.
+type plainDemuxer struct {
+	av.Demuxer
+}
+
+type recordMuxer struct {
+	streams []av.CodecData
+	pkts    []av.Packet
+	err     error
+	trailer bool
+}
+
+func (self *recordMuxer) WriteHeader(streams []av.CodecData) error {
+	self.streams = streams
+	return nil
+}
+
+func (self *recordMuxer) WritePacket(pkt av.Packet) error {
+	self.pkts = append(self.pkts, pkt)
+	return self.err
+}
+
+func (self *recordMuxer) WriteTrailer() error {
+	self.trailer = true
+	return nil
+}
+
+func TestSalvage(t *testing.T) {
+	errA, errB, errC := fmt.Errorf("a"), fmt.Errorf("b"), fmt.Errorf("c")
+	at := func(sec int) scriptItem {
+		return scriptItem{pkt: av.Packet{Time: time.Duration(sec) * time.Second, Data: []byte{byte(sec)}}}
+	}
+	fail := func(err error) scriptItem {
+		return scriptItem{err: err}
+	}
+	for _, test := range []struct {
+		name      string
+		src       *scriptDemuxer
+		plain     bool
+		max       int
+		times     []int // of the packets read
+		breaks    []int // the packets flagged as discontinuities
+		skipped   []SkippedRegion
+		err       error
+		streamsOK bool
+	}{
+		{"clean", &scriptDemuxer{items: []scriptItem{at(1), at(2), at(3)}},
+			false, 0, []int{1, 2, 3}, nil, nil, nil, true},
+		{"skips", &scriptDemuxer{
+			items:   []scriptItem{at(0), fail(errA), at(1), fail(errB), fail(errC), at(2)},
+			regions: []scriptRegion{{50, 10, nil}, {100, 10, nil}, {110, 5, nil}},
+		}, false, 0, []int{0, 1, 2}, []int{1, 2}, []SkippedRegion{
+			{Offset: 50, Length: 10, After: 0, Err: errA},
+			{Offset: 100, Length: 15, After: time.Second, Err: errB},
+		}, nil, true},
+		{"max errors", &scriptDemuxer{
+			items:   []scriptItem{at(0), fail(errA), at(1), fail(errB), at(2)},
+			regions: []scriptRegion{{50, 10, nil}},
+		}, false, 1, []int{0, 1}, []int{1}, []SkippedRegion{{Offset: 50, Length: 10, Err: errA}}, errB, true},
+		{"not a resyncer", &scriptDemuxer{items: []scriptItem{at(0), fail(errA), at(1)}},
+			true, 0, []int{0}, nil, nil, errA, true},
+		{"nothing left", &scriptDemuxer{
+			items:   []scriptItem{at(0), fail(errA), at(1)},
+			regions: []scriptRegion{{50, 20, io.EOF}},
+		}, false, 0, []int{0}, nil, []SkippedRegion{{Offset: 50, Length: 20, Err: errA}}, nil, true},
+		{"resync fails", &scriptDemuxer{
+			items:   []scriptItem{at(0), fail(errA), at(1)},
+			regions: []scriptRegion{{50, 0, errC}},
+		}, false, 0, []int{0}, nil, []SkippedRegion{{Offset: 50, Err: errA}}, errC, true},
+		{"bad header", &scriptDemuxer{
+			streamErrs: []error{errA},
+			items:      []scriptItem{at(3)},
+			regions:    []scriptRegion{{0, 30, nil}},
+		}, false, 0, []int{3}, nil, []SkippedRegion{{Offset: 0, Length: 30, Err: errA}}, nil, true},
+		{"no header", &scriptDemuxer{streamErrs: []error{io.EOF}}, false, 0, nil, nil, nil, io.EOF, false},
+		{"bad header only", &scriptDemuxer{
+			streamErrs: []error{errA, errB},
+			regions:    []scriptRegion{{0, 30, nil}, {30, 10, io.EOF}},
+		}, false, 0, nil, nil, []SkippedRegion{{Offset: 0, Length: 40, Err: errA}}, errB, false},
+	} {
+		var src av.Demuxer = test.src
+		if test.plain {
+			src = plainDemuxer{test.src}
+		}
+		dst := &recordMuxer{}
+		result, err := Salvage(dst, src, SalvageOptions{MaxErrors: test.max})
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if result.Err != test.err || fmt.Sprint(result.Skipped) != fmt.Sprint(test.skipped) {
+			t.Errorf("%s: skipped %v, error %v", test.name, result.Skipped, result.Err)
+		}
+		if (len(result.Streams) == 1) != test.streamsOK || (dst.streams != nil) != test.streamsOK || dst.trailer != test.streamsOK {
+			t.Errorf("%s: streams %v, header %v, trailer %v", test.name, result.Streams, dst.streams, dst.trailer)
+		}
+		if result.Packets != int64(len(test.times)) || len(dst.pkts) != len(test.times) {
+			t.Errorf("%s: %d packets, %d written", test.name, result.Packets, len(dst.pkts))
+			continue
+		}
+		breaks := map[int]bool{}
+		for _, i := range test.breaks {
+			breaks[i] = true
+		}
+		for i, pkt := range dst.pkts {
+			if pkt.Time != time.Duration(test.times[i])*time.Second || pkt.Flags.Has(av.PacketDiscontinuity) != breaks[i] {
+				t.Errorf("%s: packet %d at %v flags %v", test.name, i, pkt.Time, pkt.Flags)
+			}
+		}
+		if len(test.times) > 0 && (result.Start != time.Duration(test.times[0])*time.Second || result.End != time.Duration(test.times[len(test.times)-1])*time.Second) {
+			t.Errorf("%s: from %v to %v", test.name, result.Start, result.End)
+		}
+	}
+}
+
+func TestSalvageMuxerError(t *testing.T) {
+	errW := fmt.Errorf("disk full")
+	src := &scriptDemuxer{items: []scriptItem{{pkt: av.Packet{}}, {pkt: av.Packet{}}}}
+	dst := &recordMuxer{err: errW}
+	result, err := Salvage(dst, src, SalvageOptions{})
+	if err != errW || result.Packets != 0 || dst.trailer {
+		t.Errorf("%d packets: %v", result.Packets, err)
+	}
+
+	// a nil muxer only scans
+	src = &scriptDemuxer{items: []scriptItem{{pkt: av.Packet{}}, {pkt: av.Packet{}}}}
+	if result, err = Salvage(nil, src, SalvageOptions{}); err != nil || result.Packets != 2 {
+		t.Errorf("%d packets: %v", result.Packets, err)
+	}
+}
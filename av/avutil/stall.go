@@ -0,0 +1,158 @@
+package avutil
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/deepch/vdk/av"
+)
+
+// ErrStreamStalled is matched by errors.Is on every StallError.
+var ErrStreamStalled = errors.New("avutil: stream stalled")
+
+// StallError tells a source gave no packet for Timeout, Last is the time
+// of its last packet.
+type StallError struct {
+	Timeout time.Duration
+	Last    time.Duration
+}
+
+func (self StallError) Error() string {
+	return fmt.Sprintf("avutil: stream stalled, no packet for %v after %v", self.Timeout, self.Last)
+}
+
+func (self StallError) Is(target error) bool {
+	return target == ErrStreamStalled
+}
+
+type readResult struct {
+	pkt     av.Packet
+	streams []av.CodecData
+	err     error
+}
+
+// StallDetector is a demuxer returning a StallError instead of blocking
+// once its source gave no packet for Timeout. The source is closed on a
+// stall when it is an io.Closer, so the blocked read returns.
+type StallDetector struct {
+	Demuxer av.Demuxer
+	Timeout time.Duration
+
+	once    sync.Once
+	results chan readResult
+	done    chan struct{}
+	last    time.Duration
+	err     error
+}
+
+func NewStallDetector(demuxer av.Demuxer, timeout time.Duration) *StallDetector {
+	return &StallDetector{Demuxer: demuxer, Timeout: timeout}
+}
+
+func (self *StallDetector) start() {
+	self.results = make(chan readResult)
+	self.done = make(chan struct{})
+	go func() {
+		for {
+			var r readResult
+			r.pkt, r.err = self.Demuxer.ReadPacket()
+			select {
+			case self.results <- r:
+			case <-self.done:
+				return
+			}
+			if r.err != nil {
+				return
+			}
+		}
+	}()
+}
+
+// wait runs fn with the timeout, fn keeps running after a stall.
+func (self *StallDetector) wait(fn func() readResult) (r readResult) {
+	if self.Timeout <= 0 {
+		return fn()
+	}
+	results := make(chan readResult, 1)
+	go func() {
+		results <- fn()
+	}()
+	timer := time.NewTimer(self.Timeout)
+	defer timer.Stop()
+	select {
+	case r = <-results:
+	case <-timer.C:
+		r.err = self.stall()
+	}
+	return
+}
+
+func (self *StallDetector) stall() error {
+	self.err = StallError{Timeout: self.Timeout, Last: self.last}
+	if self.done != nil {
+		close(self.done)
+	}
+	if closer, ok := self.Demuxer.(io.Closer); ok {
+		closer.Close()
+	}
+	return self.err
+}
+
+func (self *StallDetector) Streams() (streams []av.CodecData, err error) {
+	if self.err != nil {
+		err = self.err
+		return
+	}
+	r := self.wait(func() (r readResult) {
+		r.streams, r.err = self.Demuxer.Streams()
+		return
+	})
+	streams, err = r.streams, r.err
+	return
+}
+
+func (self *StallDetector) ReadPacket() (pkt av.Packet, err error) {
+	if self.err != nil {
+		err = self.err
+		return
+	}
+	if self.Timeout <= 0 {
+		return self.Demuxer.ReadPacket()
+	}
+	self.once.Do(self.start)
+	timer := time.NewTimer(self.Timeout)
+	defer timer.Stop()
+	select {
+	case r := <-self.results:
+		if r.err != nil {
+			self.err = r.err
+			err = r.err
+			return
+		}
+		pkt = r.pkt
+		self.last = pkt.Time
+	case <-timer.C:
+		err = self.stall()
+	}
+	return
+}
+
+// TimeoutReader reads Conn with a deadline of Timeout for each read, for
+// demuxers over a connection such as ts over UDP.
+type TimeoutReader struct {
+	Conn    net.Conn
+	Timeout time.Duration
+}
+
+func (self TimeoutReader) Read(b []byte) (n int, err error) {
+	if self.Timeout > 0 {
+		if err = self.Conn.SetReadDeadline(time.Now().Add(self.Timeout)); err != nil {
+			return
+		}
+	}
+	return self.Conn.Read(b)
+}
@@ -0,0 +1,146 @@
+package avutil
+
+import (
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/deepch/vdk/av"
+	"github.com/deepch/vdk/codec"
+)
+
+// chanDemuxer reads the packets sent on pkts, closing it ends the stream
+// and Close unblocks the reads.
+type chanDemuxer struct {
+	header chan struct{}
+	pkts   chan av.Packet
+	closed chan struct{}
+}
+
+func newChanDemuxer() *chanDemuxer {
+	return &chanDemuxer{header: make(chan struct{}), pkts: make(chan av.Packet), closed: make(chan struct{})}
+}
+
+func (self *chanDemuxer) Streams() ([]av.CodecData, error) {
+	select {
+	case <-self.header:
+		return []av.CodecData{codec.NewPCMMulawCodecData()}, nil
+	case <-self.closed:
+		return nil, io.ErrClosedPipe
+	}
+}
+
+func (self *chanDemuxer) ReadPacket() (pkt av.Packet, err error) {
+	select {
+	case pkt, ok := <-self.pkts:
+		if !ok {
+			return pkt, io.EOF
+		}
+		return pkt, nil
+	case <-self.closed:
+		return pkt, io.ErrClosedPipe
+	}
+}
+
+func (self *chanDemuxer) Close() error {
+	close(self.closed)
+	return nil
+}
+
+func TestStallDetector(t *testing.T) {
+	src := newChanDemuxer()
+	detector := NewStallDetector(src, 50*time.Millisecond)
+	close(src.header)
+	if streams, err := detector.Streams(); err != nil || len(streams) != 1 {
+		t.Fatal(streams, err)
+	}
+	go func() {
+		for i := 1; i <= 3; i++ {
+			time.Sleep(20 * time.Millisecond)
+			src.pkts <- av.Packet{Time: time.Duration(i) * time.Second}
+		}
+	}()
+	for i := 1; i <= 3; i++ {
+		pkt, err := detector.ReadPacket()
+		if err != nil || pkt.Time != time.Duration(i)*time.Second {
+			t.Fatalf("packet %d at %v: %v", i, pkt.Time, err)
+		}
+	}
+
+	start := time.Now()
+	_, err := detector.ReadPacket()
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond || elapsed > time.Second {
+		t.Errorf("stalled after %v", elapsed)
+	}
+	var stall StallError
+	if !errors.Is(err, ErrStreamStalled) || !errors.As(err, &stall) || stall.Last != 3*time.Second || stall.Timeout != 50*time.Millisecond {
+		t.Fatalf("stall error %v", err)
+	}
+	select {
+	case <-src.closed:
+	default:
+		t.Error("source not closed")
+	}
+	// the error stays
+	if _, err = detector.ReadPacket(); err != stall {
+		t.Errorf("read after the stall: %v", err)
+	}
+	if _, err = detector.Streams(); err != stall {
+		t.Errorf("streams after the stall: %v", err)
+	}
+}
+
+func TestStallDetectorStreams(t *testing.T) {
+	src := newChanDemuxer()
+	detector := NewStallDetector(src, 20*time.Millisecond)
+	_, err := detector.Streams()
+	if !errors.Is(err, ErrStreamStalled) {
+		t.Fatalf("stall error %v", err)
+	}
+	if _, err = detector.ReadPacket(); !errors.Is(err, ErrStreamStalled) {
+		t.Errorf("read after the stall: %v", err)
+	}
+}
+
+func TestStallDetectorEnd(t *testing.T) {
+	for _, timeout := range []time.Duration{0, time.Second} {
+		src := newChanDemuxer()
+		detector := NewStallDetector(src, timeout)
+		go func() {
+			src.pkts <- av.Packet{Time: time.Second}
+			close(src.pkts)
+		}()
+		if pkt, err := detector.ReadPacket(); err != nil || pkt.Time != time.Second {
+			t.Errorf("timeout %v: %v %v", timeout, pkt.Time, err)
+		}
+		for i := 0; i < 2; i++ {
+			if _, err := detector.ReadPacket(); err != io.EOF {
+				t.Errorf("timeout %v: end %v", timeout, err)
+			}
+		}
+		select {
+		case <-src.closed:
+			t.Errorf("timeout %v: source closed", timeout)
+		default:
+		}
+	}
+}
+
+func TestTimeoutReader(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+	r := TimeoutReader{Conn: client, Timeout: 20 * time.Millisecond}
+	go server.Write([]byte("ts"))
+	b := make([]byte, 2)
+	if n, err := io.ReadFull(r, b); err != nil || string(b[:n]) != "ts" {
+		t.Fatal(n, err)
+	}
+	start := time.Now()
+	_, err := r.Read(b)
+	if nerr, ok := err.(net.Error); !ok || !nerr.Timeout() || time.Since(start) > time.Second {
+		t.Errorf("read without data: %v after %v", err, time.Since(start))
+	}
+}
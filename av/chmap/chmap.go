@@ -0,0 +1,247 @@
+// Package chmap remaps the channels of raw audio frames: downmix, channel
+// selection and swapping, as an av.AudioResampler.
+package chmap
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+
+	"github.com/deepch/vdk/av"
+)
+
+// Order of the channels in AudioFrame data, the WAVE/ffmpeg order.
+var Order = []av.ChannelLayout{
+	av.CH_FRONT_LEFT,
+	av.CH_FRONT_RIGHT,
+	av.CH_FRONT_CENTER,
+	av.CH_LOW_FREQ,
+	av.CH_BACK_LEFT,
+	av.CH_BACK_RIGHT,
+	av.CH_BACK_CENTER,
+	av.CH_SIDE_LEFT,
+	av.CH_SIDE_RIGHT,
+}
+
+// Channels lists the channels of layout in data order.
+func Channels(layout av.ChannelLayout) (chs []av.ChannelLayout) {
+	for _, ch := range Order {
+		if layout&ch != 0 {
+			chs = append(chs, ch)
+		}
+	}
+	return
+}
+
+// Mapper computes each output channel as a weighted sum of the input ones,
+// Matrix[out][in] in data order. Samples are clipped, the sample format and
+// rate are kept.
+type Mapper struct {
+	InLayout  av.ChannelLayout
+	OutLayout av.ChannelLayout
+	Matrix    [][]float64
+}
+
+func newMapper(in, out av.ChannelLayout) *Mapper {
+	self := &Mapper{InLayout: in, OutLayout: out}
+	self.Matrix = make([][]float64, out.Count())
+	for i := range self.Matrix {
+		self.Matrix[i] = make([]float64, in.Count())
+	}
+	return self
+}
+
+func (self *Mapper) set(out, in av.ChannelLayout, v float64) {
+	oi, ii := index(self.OutLayout, out), index(self.InLayout, in)
+	if oi >= 0 && ii >= 0 {
+		self.Matrix[oi][ii] += v
+	}
+}
+
+func index(layout av.ChannelLayout, ch av.ChannelLayout) int {
+	for i, c := range Channels(layout) {
+		if c == ch {
+			return i
+		}
+	}
+	return -1
+}
+
+// NewDownmix maps in to stereo or mono with the usual -3dB centre and
+// surround weights, LFE is dropped. Rows are normalized so a full scale
+// input can not clip.
+func NewDownmix(in, out av.ChannelLayout) (self *Mapper, err error) {
+	if out != av.CH_STEREO && out != av.CH_MONO {
+		err = fmt.Errorf("chmap: downmix to %s not supported", out)
+		return
+	}
+	stereo := newMapper(in, av.CH_STEREO)
+	stereo.set(av.CH_FRONT_LEFT, av.CH_FRONT_LEFT, 1)
+	stereo.set(av.CH_FRONT_RIGHT, av.CH_FRONT_RIGHT, 1)
+	for _, side := range []struct{ out, back, side av.ChannelLayout }{
+		{av.CH_FRONT_LEFT, av.CH_BACK_LEFT, av.CH_SIDE_LEFT},
+		{av.CH_FRONT_RIGHT, av.CH_BACK_RIGHT, av.CH_SIDE_RIGHT},
+	} {
+		stereo.set(side.out, av.CH_FRONT_CENTER, math.Sqrt2/2)
+		stereo.set(side.out, av.CH_BACK_CENTER, 0.5)
+		stereo.set(side.out, side.back, math.Sqrt2/2)
+		stereo.set(side.out, side.side, math.Sqrt2/2)
+	}
+	if in == av.CH_MONO {
+		// the centre goes to both sides at full level
+		stereo.Matrix[0][0], stereo.Matrix[1][0] = 1, 1
+	}
+
+	if out == av.CH_STEREO {
+		self = stereo
+	} else {
+		self = newMapper(in, av.CH_MONO)
+		for i := range self.Matrix[0] {
+			self.Matrix[0][i] = (stereo.Matrix[0][i] + stereo.Matrix[1][i]) / 2
+		}
+	}
+	self.normalize()
+	return
+}
+
+// NewSelect outputs channel ch of in as mono.
+func NewSelect(in av.ChannelLayout, ch av.ChannelLayout) (self *Mapper, err error) {
+	if index(in, ch) < 0 {
+		err = fmt.Errorf("chmap: channel %x not in layout %x", uint16(ch), uint16(in))
+		return
+	}
+	self = newMapper(in, av.CH_MONO)
+	self.Matrix[0][index(in, ch)] = 1
+	return
+}
+
+// NewSwapLR swaps the front left and right channels of in.
+func NewSwapLR(in av.ChannelLayout) (self *Mapper, err error) {
+	if in&av.CH_STEREO != av.CH_STEREO {
+		err = fmt.Errorf("chmap: layout %x has no left/right pair", uint16(in))
+		return
+	}
+	self = newMapper(in, in)
+	for _, ch := range Channels(in) {
+		switch ch {
+		case av.CH_FRONT_LEFT:
+			self.set(av.CH_FRONT_RIGHT, ch, 1)
+		case av.CH_FRONT_RIGHT:
+			self.set(av.CH_FRONT_LEFT, ch, 1)
+		default:
+			self.set(ch, ch, 1)
+		}
+	}
+	return
+}
+
+func (self *Mapper) normalize() {
+	for _, row := range self.Matrix {
+		var sum float64
+		for _, v := range row {
+			sum += math.Abs(v)
+		}
+		if sum > 1 {
+			for i := range row {
+				row[i] /= sum
+			}
+		}
+	}
+}
+
+func (self *Mapper) Resample(in av.AudioFrame) (out av.AudioFrame, err error) {
+	if in.ChannelLayout != self.InLayout {
+		err = fmt.Errorf("chmap: frame layout %x, expected %x", uint16(in.ChannelLayout), uint16(self.InLayout))
+		return
+	}
+	size := in.SampleFormat.BytesPerSample()
+	if size == 0 {
+		err = fmt.Errorf("chmap: sample format %s not supported", in.SampleFormat)
+		return
+	}
+	inch, outch := self.InLayout.Count(), self.OutLayout.Count()
+	planar := in.SampleFormat.IsPlanar()
+	if planar && len(in.Data) < inch || !planar && len(in.Data) < 1 {
+		err = fmt.Errorf("chmap: frame data missing")
+		return
+	}
+
+	out = in
+	out.ChannelLayout = self.OutLayout
+	if planar {
+		out.Data = make([][]byte, outch)
+		for i := range out.Data {
+			out.Data[i] = make([]byte, in.SampleCount*size)
+		}
+	} else {
+		out.Data = [][]byte{make([]byte, in.SampleCount*size*outch)}
+	}
+
+	src := make([]float64, inch)
+	for n := 0; n < in.SampleCount; n++ {
+		for c := range src {
+			if planar {
+				src[c] = getSample(in.Data[c][n*size:], in.SampleFormat)
+			} else {
+				src[c] = getSample(in.Data[0][(n*inch+c)*size:], in.SampleFormat)
+			}
+		}
+		for c, row := range self.Matrix {
+			var v float64
+			for i, w := range row {
+				v += w * src[i]
+			}
+			if planar {
+				putSample(out.Data[c][n*size:], in.SampleFormat, v)
+			} else {
+				putSample(out.Data[0][(n*outch+c)*size:], in.SampleFormat, v)
+			}
+		}
+	}
+	return
+}
+
+// samples are handled as float64 in [-1, 1]
+func getSample(b []byte, format av.SampleFormat) float64 {
+	switch format {
+	case av.U8, av.U8P:
+		return float64(int(b[0])-128) / 128
+	case av.S16, av.S16P:
+		return float64(int16(binary.LittleEndian.Uint16(b))) / 32768
+	case av.S32, av.S32P:
+		return float64(int32(binary.LittleEndian.Uint32(b))) / 2147483648
+	case av.U32:
+		return float64(int64(binary.LittleEndian.Uint32(b))-2147483648) / 2147483648
+	case av.FLT, av.FLTP:
+		return float64(math.Float32frombits(binary.LittleEndian.Uint32(b)))
+	case av.DBL, av.DBLP:
+		return math.Float64frombits(binary.LittleEndian.Uint64(b))
+	}
+	return 0
+}
+
+func putSample(b []byte, format av.SampleFormat, v float64) {
+	switch format {
+	case av.FLT, av.FLTP:
+		binary.LittleEndian.PutUint32(b, math.Float32bits(float32(v)))
+		return
+	case av.DBL, av.DBLP:
+		binary.LittleEndian.PutUint64(b, math.Float64bits(v))
+		return
+	}
+	if v > 1 {
+		v = 1
+	} else if v < -1 {
+		v = -1
+	}
+	switch format {
+	case av.U8, av.U8P:
+		b[0] = uint8(math.Min(v*128+128, 255))
+	case av.S16, av.S16P:
+		binary.LittleEndian.PutUint16(b, uint16(int16(math.Min(v*32768, 32767))))
+	case av.S32, av.S32P:
+		binary.LittleEndian.PutUint32(b, uint32(int32(math.Min(v*2147483648, 2147483647))))
+	case av.U32:
+		binary.LittleEndian.PutUint32(b, uint32(math.Min(v*2147483648+2147483648, 4294967295)))
+	}
+}
@@ -0,0 +1,281 @@
+// Package frameinfo reads per frame quality indicators, the size and the
+// slice QP, from the bitstream without decoding, so cameras whose encoder
+// quality silently degraded can be spotted.
+package frameinfo
+
+import (
+	"sync"
+	"time"
+
+	"github.com/deepch/vdk/av"
+	"github.com/deepch/vdk/codec/h264parser"
+)
+
+const (
+	DefaultWindow         = 60 * time.Second
+	DefaultMaxQPRise      = 4
+	DefaultMaxBitrateDrop = 0.5
+)
+
+// Frame describes a video packet, QP is the average over its slices and
+// -1 when it cannot be read, such as for H265.
+type Frame struct {
+	Idx       int8
+	Time      time.Duration
+	Size      int
+	KeyFrame  bool
+	SliceType h264parser.SliceType
+	Slices    int
+	QP        float64
+	MinQP     int
+	MaxQP     int
+}
+
+type h264State struct {
+	sps map[uint]h264parser.SPSInfo
+	pps map[uint]h264parser.PPSInfo
+}
+
+func (self *h264State) update(nalu []byte) {
+	if len(nalu) == 0 {
+		return
+	}
+	switch nalu[0] & 0x1f {
+	case h264parser.NALU_SPS:
+		if sps, err := h264parser.ParseSPS(nalu); err == nil {
+			self.sps[sps.Id] = sps
+		}
+	case h264parser.NALU_PPS:
+		if pps, err := h264parser.ParsePPS(nalu); err == nil {
+			self.pps[pps.Id] = pps
+		}
+	}
+}
+
+// Parser keeps the parameter sets of the video streams, in band ones
+// replace those of the codec data.
+type Parser struct {
+	streams []av.CodecData
+	h264    map[int8]*h264State
+}
+
+func NewParser(streams []av.CodecData) *Parser {
+	self := &Parser{streams: streams, h264: map[int8]*h264State{}}
+	for i, stream := range streams {
+		if codec, ok := stream.(h264parser.CodecData); ok {
+			state := &h264State{sps: map[uint]h264parser.SPSInfo{}, pps: map[uint]h264parser.PPSInfo{}}
+			state.update(codec.SPS())
+			state.update(codec.PPS())
+			self.h264[int8(i)] = state
+		}
+	}
+	return self
+}
+
+// Parse returns the frame of a video packet, ok is false for other streams.
+func (self *Parser) Parse(pkt av.Packet) (frame Frame, ok bool) {
+	if int(pkt.Idx) >= len(self.streams) || !self.streams[pkt.Idx].Type().IsVideo() {
+		return
+	}
+	ok = true
+	frame = Frame{
+		Idx:      pkt.Idx,
+		Time:     pkt.Time,
+		Size:     len(pkt.Data),
+		KeyFrame: pkt.IsKeyFrame,
+		QP:       -1,
+	}
+	state := self.h264[pkt.Idx]
+	if state == nil {
+		return
+	}
+	nalus, _ := h264parser.SplitNALUs(pkt.Data)
+	sum := 0
+	for _, nalu := range nalus {
+		if len(nalu) == 0 {
+			continue
+		}
+		if !h264parser.IsDataNALU(nalu) {
+			state.update(nalu)
+			continue
+		}
+		id, err := h264parser.SlicePPSId(nalu)
+		if err != nil {
+			continue
+		}
+		pps, found := state.pps[id]
+		if !found {
+			continue
+		}
+		sps, found := state.sps[pps.SPSId]
+		if !found {
+			continue
+		}
+		h, err := h264parser.ParseSliceHeader(nalu, sps, pps)
+		if err != nil {
+			continue
+		}
+		if frame.Slices == 0 || h.QP < frame.MinQP {
+			frame.MinQP = h.QP
+		}
+		if frame.Slices == 0 || h.QP > frame.MaxQP {
+			frame.MaxQP = h.QP
+		}
+		if frame.Slices == 0 || h.Type == h264parser.SLICE_B || h.Type == h264parser.SLICE_P && frame.SliceType == h264parser.SLICE_I {
+			frame.SliceType = h.Type
+		}
+		frame.Slices++
+		sum += h.QP
+	}
+	if frame.Slices > 0 {
+		frame.QP = float64(sum) / float64(frame.Slices)
+	}
+	return
+}
+
+// Stats summarizes the frames of a window.
+type Stats struct {
+	Frames  int
+	Bitrate int // bits per second
+	AvgQP   float64
+	MaxQP   int
+}
+
+// Tracker keeps the stats of the last Window of a video stream and
+// compares them with a baseline, the first full window unless set. Feed it
+// with Observe or install it as a pktque.Filter.
+type Tracker struct {
+	Window         time.Duration // DefaultWindow if zero
+	MaxQPRise      float64       // DefaultMaxQPRise if zero
+	MaxBitrateDrop float64       // fraction of the baseline bitrate, DefaultMaxBitrateDrop if zero
+	OnFrame        func(frame Frame)
+	OnDegraded     func(current, baseline Stats) // called when quality falls below the baseline
+
+	lock     sync.Mutex
+	parser   *Parser
+	frames   []Frame
+	baseline *Stats
+	degraded bool
+}
+
+func (self *Tracker) window() time.Duration {
+	if self.Window > 0 {
+		return self.Window
+	}
+	return DefaultWindow
+}
+
+func (self *Tracker) Observe(frame Frame) {
+	if self.OnFrame != nil {
+		self.OnFrame(frame)
+	}
+
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	self.frames = append(self.frames, frame)
+	first := self.frames[0].Time
+	if frame.Time-first < self.window() {
+		return
+	}
+	i := 0
+	for i < len(self.frames) && frame.Time-self.frames[i].Time > self.window() {
+		i++
+	}
+	self.frames = self.frames[i:]
+
+	current := self.stats()
+	if self.baseline == nil {
+		self.baseline = &current
+		return
+	}
+	if degraded := self.isDegraded(current, *self.baseline); degraded != self.degraded {
+		self.degraded = degraded
+		if degraded && self.OnDegraded != nil {
+			self.OnDegraded(current, *self.baseline)
+		}
+	}
+}
+
+func (self *Tracker) isDegraded(current, baseline Stats) bool {
+	rise := self.MaxQPRise
+	if rise == 0 {
+		rise = DefaultMaxQPRise
+	}
+	drop := self.MaxBitrateDrop
+	if drop == 0 {
+		drop = DefaultMaxBitrateDrop
+	}
+	if current.AvgQP >= 0 && baseline.AvgQP >= 0 && current.AvgQP-baseline.AvgQP >= rise {
+		return true
+	}
+	return float64(current.Bitrate) < float64(baseline.Bitrate)*(1-drop)
+}
+
+func (self *Tracker) stats() (stats Stats) {
+	stats.Frames = len(self.frames)
+	stats.AvgQP = -1
+	if stats.Frames == 0 {
+		return
+	}
+	bytes, qps := 0, 0
+	sum := 0.0
+	for _, frame := range self.frames {
+		bytes += frame.Size
+		if frame.QP >= 0 {
+			sum += frame.QP
+			qps++
+			if frame.MaxQP > stats.MaxQP {
+				stats.MaxQP = frame.MaxQP
+			}
+		}
+	}
+	if qps > 0 {
+		stats.AvgQP = sum / float64(qps)
+	}
+	if dur := self.frames[stats.Frames-1].Time - self.frames[0].Time; dur > 0 {
+		stats.Bitrate = int(float64(bytes*8) / dur.Seconds())
+	}
+	return
+}
+
+// Stats returns the stats of the last window.
+func (self *Tracker) Stats() Stats {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return self.stats()
+}
+
+func (self *Tracker) Baseline() (stats Stats, ok bool) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if self.baseline != nil {
+		stats, ok = *self.baseline, true
+	}
+	return
+}
+
+// SetBaseline replaces the stats the window is compared with, as after the
+// camera settings were changed on purpose.
+func (self *Tracker) SetBaseline(stats Stats) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.baseline = &stats
+	self.degraded = false
+}
+
+func (self *Tracker) Degraded() bool {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return self.degraded
+}
+
+func (self *Tracker) ModifyPacket(pkt *av.Packet, streams []av.CodecData, videoidx int, audioidx int) (drop bool, err error) {
+	if self.parser == nil {
+		self.parser = NewParser(streams)
+	}
+	if frame, ok := self.parser.Parse(*pkt); ok {
+		self.Observe(frame)
+	}
+	return
+}
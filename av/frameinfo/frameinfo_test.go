@@ -0,0 +1,239 @@
+package frameinfo
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/deepch/vdk/av"
+	"github.com/deepch/vdk/av/generator"
+	"github.com/deepch/vdk/codec"
+	"github.com/deepch/vdk/codec/h264parser"
+	"github.com/deepch/vdk/codec/h265parser"
+	"github.com/deepch/vdk/utils/bits"
+)
+
+type golomb struct {
+	buf bytes.Buffer
+	w   *bits.Writer
+}
+
+func newGolomb(header byte) *golomb {
+	self := &golomb{}
+	self.buf.WriteByte(header)
+	self.w = &bits.Writer{W: &self.buf}
+	return self
+}
+
+func (self *golomb) u(v uint, n int) *golomb {
+	self.w.WriteBits(v, n)
+	return self
+}
+
+func (self *golomb) ue(v uint) *golomb {
+	n := 0
+	for (v+1)>>uint(n+1) != 0 {
+		n++
+	}
+	return self.u(0, n).u(v+1, n+1)
+}
+
+func (self *golomb) se(v int) *golomb {
+	if v > 0 {
+		return self.ue(uint(2*v - 1))
+	}
+	return self.ue(uint(-2 * v))
+}
+
+func (self *golomb) rbsp() []byte {
+	self.u(1, 1)
+	self.w.FlushBits()
+	return h264parser.RBSPToEBSP(self.buf.Bytes())
+}
+
+// pps is the parameter set of the generator with another id and QP.
+func pps(id uint, qp int) []byte {
+	return newGolomb(0x68).ue(id).ue(0).u(0, 1).u(0, 1).ue(0).ue(0).ue(0).u(0, 1).u(0, 2).
+		se(qp-26).se(0).se(0).u(1, 1).u(0, 1).u(0, 1).rbsp()
+}
+
+// slice is a slice header for the parameter sets of the generator, the
+// slice data is left out.
+func slice(typ h264parser.SliceType, ppsid uint, qpdelta int) []byte {
+	switch typ {
+	case h264parser.SLICE_I:
+		// IDR
+		return newGolomb(0x65).ue(0).ue(7).ue(ppsid).u(0, 4).ue(0).u(0, 2).se(qpdelta).rbsp()
+	case h264parser.SLICE_P:
+		return newGolomb(0x41).ue(0).ue(5).ue(ppsid).u(1, 4).u(0, 1).u(0, 1).u(0, 1).se(qpdelta).rbsp()
+	default:
+		// non reference B
+		return newGolomb(0x01).ue(0).ue(6).ue(ppsid).u(2, 4).u(0, 1).u(0, 1).u(0, 1).u(0, 1).se(qpdelta).rbsp()
+	}
+}
+
+func avcc(nalus ...[]byte) []byte {
+	b := []byte{}
+	for _, nalu := range nalus {
+		n := len(nalu)
+		b = append(b, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+		b = append(b, nalu...)
+	}
+	return b
+}
+
+func testStreams(t *testing.T) []av.CodecData {
+	video, err := generator.NewVideo(generator.Bars(64, 48), 25, 25)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return []av.CodecData{video.CodecData(), codec.NewPCMMulawCodecData(), h265parser.CodecData{}}
+}
+
+func TestParse(t *testing.T) {
+	const I, P, B = h264parser.SLICE_I, h264parser.SLICE_P, h264parser.SLICE_B
+	parser := NewParser(testStreams(t))
+	for _, test := range []struct {
+		name  string
+		idx   int8
+		data  []byte
+		ok    bool
+		typ   h264parser.SliceType
+		n     int
+		qp    float64
+		minqp int
+		maxqp int
+	}{
+		{"idr", 0, avcc(slice(I, 0, 0)), true, I, 1, 26, 26, 26},
+		{"slices", 0, avcc(slice(P, 0, -2), slice(P, 0, 4)), true, P, 2, 27, 24, 30},
+		{"i and p", 0, avcc(slice(I, 0, 0), slice(P, 0, 0)), true, P, 2, 26, 26, 26},
+		{"p and b", 0, avcc(slice(P, 0, 0), slice(B, 0, 1), slice(P, 0, 2)), true, B, 3, 27, 26, 28},
+		{"unknown pps", 0, avcc(slice(P, 1, 0)), true, 0, 0, -1, 0, 0},
+		{"in band pps", 0, avcc(pps(1, 30), slice(P, 1, 1), slice(P, 0, 0)), true, P, 2, 28.5, 26, 31},
+		{"pps kept", 0, avcc(slice(P, 1, 0)), true, P, 1, 30, 30, 30},
+		{"pps replaced", 0, avcc(pps(0, 20), slice(I, 0, 0)), true, I, 1, 20, 20, 20},
+		{"garbage", 0, []byte{0, 0, 0, 2, 0x41, 0xff}, true, 0, 0, -1, 0, 0},
+		{"audio", 1, []byte{1, 2, 3}, false, 0, 0, 0, 0, 0},
+		{"h265", 2, avcc([]byte{0x02, 0x01, 0xff}), true, 0, 0, -1, 0, 0},
+		{"no stream", 3, []byte{1}, false, 0, 0, 0, 0, 0},
+	} {
+		pkt := av.Packet{Idx: test.idx, Data: test.data, Time: time.Second, IsKeyFrame: test.typ == I}
+		frame, ok := parser.Parse(pkt)
+		if ok != test.ok {
+			t.Errorf("%s: ok %v", test.name, ok)
+			continue
+		}
+		want := Frame{}
+		if ok {
+			want = Frame{Idx: test.idx, Time: time.Second, Size: len(test.data), KeyFrame: test.typ == I,
+				SliceType: test.typ, Slices: test.n, QP: test.qp, MinQP: test.minqp, MaxQP: test.maxqp}
+		}
+		if frame != want {
+			t.Errorf("%s: %+v, want %+v", test.name, frame, want)
+		}
+	}
+}
+
+// trackerRun feeds tracker secs seconds of 25 fps frames of size bytes at qp.
+func trackerRun(tracker *Tracker, from time.Duration, secs, size int, qp float64) time.Duration {
+	for i := 0; i < secs*25; i++ {
+		tracker.Observe(Frame{Time: from, Size: size, QP: qp, MaxQP: int(qp) + 1})
+		from += 40 * time.Millisecond
+	}
+	return from
+}
+
+func TestTracker(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		size     int
+		qp       float64
+		degraded bool
+	}{
+		{"steady", 1000, 26, false},
+		{"qp rise", 1000, 30, true},
+		{"small qp rise", 1000, 29, false},
+		{"bitrate drop", 400, 26, true},
+		{"small bitrate drop", 600, 26, false},
+		{"unknown qp", 1000, -1, false},
+	} {
+		var calls []Stats
+		tracker := &Tracker{Window: 10 * time.Second, OnDegraded: func(current, baseline Stats) {
+			if baseline.AvgQP != 26 {
+				t.Errorf("%s: baseline %+v", test.name, baseline)
+			}
+			calls = append(calls, current)
+		}}
+		tm := trackerRun(tracker, 0, 5, 1000, 26)
+		if _, ok := tracker.Baseline(); ok {
+			t.Fatalf("%s: baseline before a full window", test.name)
+		}
+		tm = trackerRun(tracker, tm, 6, 1000, 26)
+		baseline, ok := tracker.Baseline()
+		if !ok || baseline.AvgQP != 26 || baseline.MaxQP != 27 || baseline.Bitrate != 200800 {
+			t.Fatalf("%s: baseline %+v", test.name, baseline)
+		}
+		tm = trackerRun(tracker, tm, 20, test.size, test.qp)
+		if tracker.Degraded() != test.degraded || len(calls) != map[bool]int{false: 0, true: 1}[test.degraded] {
+			t.Errorf("%s: degraded %v, %d calls", test.name, tracker.Degraded(), len(calls))
+		}
+		// the window holds both of its ends
+		stats := tracker.Stats()
+		if stats.Frames != 251 || stats.Bitrate != test.size*2008/10 {
+			t.Errorf("%s: stats %+v", test.name, stats)
+		}
+
+		// back to normal
+		trackerRun(tracker, tm, 20, 1000, 26)
+		if tracker.Degraded() || len(calls) > 1 {
+			t.Errorf("%s: degraded %v after recovering, %d calls", test.name, tracker.Degraded(), len(calls))
+		}
+	}
+}
+
+func TestTrackerSetBaseline(t *testing.T) {
+	var calls int
+	tracker := &Tracker{Window: 10 * time.Second, OnDegraded: func(current, baseline Stats) {
+		calls++
+	}}
+	tm := trackerRun(tracker, 0, 11, 1000, 26)
+	tm = trackerRun(tracker, tm, 11, 1000, 32)
+	if !tracker.Degraded() {
+		t.Fatal("not degraded")
+	}
+	// the new quality is accepted
+	tracker.SetBaseline(tracker.Stats())
+	trackerRun(tracker, tm, 11, 1000, 32)
+	if tracker.Degraded() || calls != 1 {
+		t.Errorf("degraded %v, %d calls", tracker.Degraded(), calls)
+	}
+}
+
+func TestTrackerFilter(t *testing.T) {
+	streams := testStreams(t)
+	video, _ := generator.NewVideo(generator.Bars(64, 48), 25, 25)
+	var frames []Frame
+	tracker := &Tracker{OnFrame: func(frame Frame) {
+		frames = append(frames, frame)
+	}}
+	for i := 0; i < 26; i++ {
+		pkt := video.Next()
+		if drop, err := tracker.ModifyPacket(&pkt, streams, 0, 1); drop || err != nil {
+			t.Fatal(drop, err)
+		}
+		audio := av.Packet{Idx: 1, Data: []byte{0}}
+		tracker.ModifyPacket(&audio, streams, 0, 1)
+	}
+	if len(frames) != 26 {
+		t.Fatalf("%d frames", len(frames))
+	}
+	for i, frame := range frames {
+		typ := h264parser.SliceType(h264parser.SLICE_P)
+		if i%25 == 0 {
+			typ = h264parser.SLICE_I
+		}
+		if frame.SliceType != typ || frame.QP != 26 || frame.KeyFrame != (i%25 == 0) {
+			t.Errorf("frame %d: %+v", i, frame)
+		}
+	}
+}
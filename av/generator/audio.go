@@ -0,0 +1,147 @@
+package generator
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/deepch/vdk/av"
+	"github.com/deepch/vdk/codec"
+	"github.com/deepch/vdk/codec/aacparser"
+	"github.com/deepch/vdk/codec/g711"
+	"github.com/deepch/vdk/utils/bits"
+)
+
+// PCMFrameDuration is the duration of the PCM and G.711 packets.
+const PCMFrameDuration = 20 * time.Millisecond
+
+// Audio is a sine tone of Freq hertz, or silence when Freq is zero. AAC is
+// made without an encoder and so is silence only.
+type Audio struct {
+	Freq      float64
+	Amplitude float64 // from 0 to 1, 0.5 by NewAudio
+
+	codec    av.AudioCodecData
+	channels int
+	samples  int // per packet
+	sample   int
+	silence  []byte // the AAC frame
+}
+
+// NewAudio makes a source of codec PCM, PCM_MULAW, PCM_ALAW or AAC. The
+// G.711 ones are at 8000 Hz mono whatever rate and layout.
+func NewAudio(typ av.CodecType, rate int, layout av.ChannelLayout, freq float64) (self *Audio, err error) {
+	self = &Audio{Freq: freq, Amplitude: 0.5}
+	switch typ {
+	case av.PCM:
+		if rate <= 0 || layout.Count() == 0 {
+			err = fmt.Errorf("generator: PCM rate %d layout %s invalid", rate, layout)
+			return
+		}
+		self.codec = codec.NewLinearPCMCodecData(av.S16, rate, layout, false)
+	case av.PCM_MULAW:
+		self.codec = codec.NewPCMMulawCodecData()
+	case av.PCM_ALAW:
+		self.codec = codec.NewPCMAlawCodecData()
+	case av.AAC:
+		if freq != 0 {
+			err = fmt.Errorf("generator: AAC tone not supported, only silence")
+			return
+		}
+		if self.codec, self.silence, err = aacSilence(rate, layout); err != nil {
+			return
+		}
+	default:
+		err = fmt.Errorf("generator: codec %s not supported", typ)
+		return
+	}
+	self.channels = self.codec.ChannelLayout().Count()
+	if typ == av.AAC {
+		self.samples = 1024
+	} else {
+		self.samples = self.codec.SampleRate() * int(PCMFrameDuration) / int(time.Second)
+	}
+	return
+}
+
+// aacSilence builds the AAC-LC raw data block of a silent frame: an empty
+// individual channel stream per channel then the END element.
+func aacSilence(rate int, layout av.ChannelLayout) (codecData aacparser.CodecData, frame []byte, err error) {
+	config := aacparser.MPEG4AudioConfig{SampleRate: rate, ChannelLayout: layout, ObjectType: aacparser.AOT_AAC_LC}
+	if codecData, err = aacparser.NewCodecDataFromMPEG4AudioConfig(config); err != nil {
+		return
+	}
+	if codecData.SampleRate() != rate || codecData.ChannelLayout() != layout {
+		err = fmt.Errorf("generator: AAC rate %d layout %s not supported", rate, layout)
+		return
+	}
+	ics := func(w *bits.Writer) {
+		w.WriteBits(100, 8) // global_gain
+		w.WriteBits(0, 11)  // long window, max_sfb 0, no predictor
+		w.WriteBits(0, 3)   // no pulse, tns, gain control
+	}
+	buf := &bytes.Buffer{}
+	w := &bits.Writer{W: buf}
+	switch layout.Count() {
+	case 1:
+		w.WriteBits(0, 3+4) // SCE
+		ics(w)
+	case 2:
+		w.WriteBits(1, 3) // CPE
+		w.WriteBits(0, 4+1)
+		ics(w)
+		ics(w)
+	default:
+		err = fmt.Errorf("generator: AAC layout %s not supported", layout)
+		return
+	}
+	w.WriteBits(7, 3) // END
+	w.FlushBits()
+	frame = buf.Bytes()
+	return
+}
+
+func (self *Audio) CodecData() av.CodecData {
+	return self.codec
+}
+
+func (self *Audio) Next() (pkt av.Packet) {
+	rate := self.codec.SampleRate()
+	pkt.Time = time.Duration(self.sample) * time.Second / time.Duration(rate)
+	pkt.Duration = time.Duration(self.samples) * time.Second / time.Duration(rate)
+	pkt.IsKeyFrame = true
+	start := self.sample
+	self.sample += self.samples
+	if self.codec.Type() == av.AAC {
+		pkt.Data = append([]byte(nil), self.silence...)
+		return
+	}
+	value := func(n int) int16 {
+		if self.Freq == 0 {
+			return 0
+		}
+		return int16(self.Amplitude * math.MaxInt16 * math.Sin(2*math.Pi*self.Freq*float64(n)/float64(rate)))
+	}
+	switch self.codec.Type() {
+	case av.PCM_MULAW, av.PCM_ALAW:
+		pkt.Data = make([]byte, self.samples)
+		for i := range pkt.Data {
+			if self.codec.Type() == av.PCM_MULAW {
+				pkt.Data[i] = g711.LinearToUlaw(value(start + i))
+			} else {
+				pkt.Data[i] = g711.LinearToAlaw(value(start + i))
+			}
+		}
+	default:
+		pkt.Data = make([]byte, self.samples*self.channels*2)
+		for i := 0; i < self.samples; i++ {
+			v := uint16(value(start + i))
+			for c := 0; c < self.channels; c++ {
+				binary.LittleEndian.PutUint16(pkt.Data[(i*self.channels+c)*2:], v)
+			}
+		}
+	}
+	return
+}
@@ -0,0 +1,77 @@
+// Package generator makes synthetic streams for tests and placeholders:
+// color bars or a solid color in H.264, and a tone or silence in PCM,
+// G.711 or AAC, without any encoder.
+package generator
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/deepch/vdk/av"
+)
+
+// Source gives the packets of one stream, in time order from zero.
+type Source interface {
+	CodecData() av.CodecData
+	Next() av.Packet
+}
+
+// Demuxer interleaves its sources by time, up to Duration if set. With
+// Realtime it waits for the wall clock, as a live source would.
+type Demuxer struct {
+	Sources  []Source
+	Duration time.Duration
+	Realtime bool
+
+	next  []av.Packet
+	start time.Time
+}
+
+func New(sources ...Source) *Demuxer {
+	return &Demuxer{Sources: sources}
+}
+
+func (self *Demuxer) Streams() (streams []av.CodecData, err error) {
+	if len(self.Sources) == 0 {
+		err = fmt.Errorf("generator: no sources")
+		return
+	}
+	for _, source := range self.Sources {
+		streams = append(streams, source.CodecData())
+	}
+	return
+}
+
+func (self *Demuxer) ReadPacket() (pkt av.Packet, err error) {
+	if len(self.Sources) == 0 {
+		err = fmt.Errorf("generator: no sources")
+		return
+	}
+	if self.next == nil {
+		self.next = make([]av.Packet, len(self.Sources))
+		for i, source := range self.Sources {
+			self.next[i] = source.Next()
+		}
+		self.start = time.Now()
+	}
+	idx := 0
+	for i := range self.next {
+		if self.next[i].Time < self.next[idx].Time {
+			idx = i
+		}
+	}
+	pkt = self.next[idx]
+	if self.Duration > 0 && pkt.Time >= self.Duration {
+		err = io.EOF
+		return
+	}
+	pkt.Idx = int8(idx)
+	self.next[idx] = self.Sources[idx].Next()
+	if self.Realtime {
+		if d := time.Until(self.start.Add(pkt.Time)); d > 0 {
+			time.Sleep(d)
+		}
+	}
+	return
+}
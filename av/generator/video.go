@@ -0,0 +1,211 @@
+package generator
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"time"
+
+	"github.com/deepch/vdk/av"
+	"github.com/deepch/vdk/codec/h264parser"
+	"github.com/deepch/vdk/utils/bits"
+)
+
+// Bars75 are the 75% SMPTE color bars from left to right.
+var Bars75 = []color.RGBA{
+	{191, 191, 191, 255},
+	{191, 191, 0, 255},
+	{0, 191, 191, 255},
+	{0, 191, 0, 255},
+	{191, 0, 191, 255},
+	{191, 0, 0, 255},
+	{0, 0, 191, 255},
+}
+
+// Bars returns the color bars picture.
+func Bars(width, height int) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for x := 0; x < width; x++ {
+		c := Bars75[x*len(Bars75)/width]
+		for y := 0; y < height; y++ {
+			img.SetRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+// Solid returns a picture of a single color.
+func Solid(width, height int, c color.Color) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(img, img.Bounds(), image.NewUniform(c), image.Point{}, draw.Src)
+	return img
+}
+
+type golombWriter struct {
+	buf bytes.Buffer
+	w   *bits.Writer
+}
+
+func newGolombWriter(header byte) *golombWriter {
+	self := &golombWriter{}
+	self.buf.WriteByte(header)
+	self.w = &bits.Writer{W: &self.buf}
+	return self
+}
+
+func (self *golombWriter) u(v uint, n int) *golombWriter {
+	self.w.WriteBits(v, n)
+	return self
+}
+
+func (self *golombWriter) ue(v uint) *golombWriter {
+	n := 0
+	for (v+1)>>uint(n+1) != 0 {
+		n++
+	}
+	return self.u(0, n).u(v+1, n+1)
+}
+
+func (self *golombWriter) se(v int) *golombWriter {
+	if v > 0 {
+		return self.ue(uint(2*v - 1))
+	}
+	return self.ue(uint(-2 * v))
+}
+
+// rbsp ends the NALU with the stop bit and escapes it.
+func (self *golombWriter) rbsp() []byte {
+	self.u(1, 1)
+	self.w.FlushBits()
+	return h264parser.RBSPToEBSP(self.buf.Bytes())
+}
+
+// Video is an H.264 stream of a still picture, made without an encoder: the
+// IDR frames carry the pixels as is in I_PCM macroblocks and the frames in
+// between skip every macroblock. The IDR frames are large, about 1.5 bytes
+// per pixel.
+type Video struct {
+	FPS int
+	GOP int // frames from one IDR frame to the next
+
+	codec h264parser.CodecData
+	pcm   [][]byte // luma and chroma of the macroblocks
+	mbs   int
+	frame int
+	idrID uint
+}
+
+// NewVideo makes the stream of img, whose size must be even.
+func NewVideo(img image.Image, fps int, gop int) (self *Video, err error) {
+	size := img.Bounds().Size()
+	if size.X <= 0 || size.Y <= 0 || size.X%2 != 0 || size.Y%2 != 0 {
+		err = fmt.Errorf("generator: picture size %dx%d is not even", size.X, size.Y)
+		return
+	}
+	if fps <= 0 || gop <= 0 {
+		err = fmt.Errorf("generator: fps %d gop %d invalid", fps, gop)
+		return
+	}
+	self = &Video{FPS: fps, GOP: gop}
+	mbw, mbh := (size.X+15)/16, (size.Y+15)/16
+	self.mbs = mbw * mbh
+
+	sps := newGolombWriter(0x67).u(66, 8).u(0xc0, 8).u(51, 8).ue(0).
+		ue(0).ue(2). // frame_num of 4 bits, POC from frame_num
+		ue(1).u(0, 1).ue(uint(mbw-1)).ue(uint(mbh-1)).u(1, 1).u(1, 1)
+	if crop := [2]int{mbw*16 - size.X, mbh*16 - size.Y}; crop != [2]int{} {
+		sps.u(1, 1).ue(0).ue(uint(crop[0] / 2)).ue(0).ue(uint(crop[1] / 2))
+	} else {
+		sps.u(0, 1)
+	}
+	// VUI with the timing only
+	sps.u(1, 1).u(0, 1).u(0, 1).u(0, 1).u(0, 1).
+		u(1, 1).u(1, 32).u(uint(2*fps), 32).u(1, 1).
+		u(0, 1).u(0, 1).u(0, 1).u(0, 1)
+	pps := newGolombWriter(0x68).ue(0).ue(0).u(0, 1).u(0, 1).ue(0).ue(0).ue(0).u(0, 1).u(0, 2).
+		se(0).se(0).se(0).u(1, 1).u(0, 1).u(0, 1)
+	if self.codec, err = h264parser.NewCodecDataFromSPSAndPPS(sps.rbsp(), pps.rbsp()); err != nil {
+		return
+	}
+
+	bounds := img.Bounds()
+	pixel := func(x, y int) (c color.YCbCr) {
+		if x >= size.X {
+			x = size.X - 1
+		}
+		if y >= size.Y {
+			y = size.Y - 1
+		}
+		r, g, b, _ := img.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
+		c.Y, c.Cb, c.Cr = color.RGBToYCbCr(uint8(r>>8), uint8(g>>8), uint8(b>>8))
+		return
+	}
+	for my := 0; my < mbh; my++ {
+		for mx := 0; mx < mbw; mx++ {
+			mb := make([]byte, 0, 384)
+			for y := 0; y < 16; y++ {
+				for x := 0; x < 16; x++ {
+					mb = append(mb, pixel(mx*16+x, my*16+y).Y)
+				}
+			}
+			for y := 0; y < 8; y++ {
+				for x := 0; x < 8; x++ {
+					mb = append(mb, pixel(mx*16+x*2, my*16+y*2).Cb)
+				}
+			}
+			for y := 0; y < 8; y++ {
+				for x := 0; x < 8; x++ {
+					mb = append(mb, pixel(mx*16+x*2, my*16+y*2).Cr)
+				}
+			}
+			self.pcm = append(self.pcm, mb)
+		}
+	}
+	return
+}
+
+// idrSlice writes the I_PCM macroblocks after the slice header, each one
+// aligned by zero bits.
+func (self *Video) idrSlice() []byte {
+	// slice_type I, idr_pic_id, deblocking disabled
+	w := newGolombWriter(0x65).ue(0).ue(7).ue(0).u(0, 4).ue(self.idrID%65536).u(0, 1).u(0, 1).se(0).ue(1)
+	self.idrID++
+	w.buf.Grow(len(self.pcm) * 386)
+	for _, mb := range self.pcm {
+		w.ue(25)
+		w.w.FlushBits()
+		w.buf.Write(mb)
+	}
+	return w.rbsp()
+}
+
+func (self *Video) CodecData() av.CodecData {
+	return self.codec
+}
+
+func avcc(nalu []byte) []byte {
+	b := make([]byte, 4+len(nalu))
+	b[0], b[1], b[2], b[3] = byte(len(nalu)>>24), byte(len(nalu)>>16), byte(len(nalu)>>8), byte(len(nalu))
+	copy(b[4:], nalu)
+	return b
+}
+
+func (self *Video) Next() (pkt av.Packet) {
+	n := self.frame % self.GOP
+	pkt.Time = time.Duration(self.frame) * time.Second / time.Duration(self.FPS)
+	pkt.Duration = time.Second / time.Duration(self.FPS)
+	self.frame++
+	if n == 0 {
+		pkt.Data = avcc(self.idrSlice())
+		pkt.IsKeyFrame = true
+		pkt.Flags = av.PacketReference
+		return
+	}
+	// slice_type P, frame_num, no overrides, mb_skip_run over the picture
+	w := newGolombWriter(0x41).ue(0).ue(5).ue(0).u(uint(n%16), 4).u(0, 1).u(0, 1).u(0, 1).se(0).ue(1).ue(uint(self.mbs))
+	pkt.Data = avcc(w.rbsp())
+	pkt.Flags = av.PacketReference
+	return
+}
@@ -0,0 +1,54 @@
+package generator
+
+import (
+	"testing"
+
+	"github.com/deepch/vdk/codec/h264parser"
+)
+
+func TestVideoParsesBack(t *testing.T) {
+	for _, size := range [][2]int{{64, 48}, {30, 18}} {
+		video, err := NewVideo(Bars(size[0], size[1]), 25, 5)
+		if err != nil {
+			t.Fatal(err)
+		}
+		codec := video.CodecData().(h264parser.CodecData)
+		sps, err := h264parser.ParseSPS(codec.SPS())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if int(sps.Width) != size[0] || int(sps.Height) != size[1] || sps.FPS != 25 {
+			t.Errorf("%v: sps of %dx%d at %d fps", size, sps.Width, sps.Height, sps.FPS)
+		}
+		pps, err := h264parser.ParsePPS(codec.PPS())
+		if err != nil {
+			t.Fatal(err)
+		}
+		mbs := int(sps.MbWidth * sps.MbHeight)
+
+		for i := 0; i < 7; i++ {
+			pkt := video.Next()
+			nalus, _ := h264parser.SplitNALUs(pkt.Data)
+			if len(nalus) != 1 {
+				t.Fatalf("%v: frame %d of %d nalus", size, i, len(nalus))
+			}
+			h, parsed, err := h264parser.ParseSliceData(nalus[0], sps, pps)
+			if err != nil {
+				t.Fatalf("%v: frame %d: %v", size, i, err)
+			}
+			if len(parsed) != mbs {
+				t.Errorf("%v: frame %d: %d macroblocks, want %d", size, i, len(parsed), mbs)
+			}
+			idr := i%5 == 0
+			if pkt.IsKeyFrame != idr || h.FrameNum != uint(i%5) {
+				t.Errorf("%v: frame %d: keyframe %v frame_num %d", size, i, pkt.IsKeyFrame, h.FrameNum)
+			}
+			for _, mb := range parsed {
+				if idr && (!mb.Intra || mb.Type != 25) || !idr && !mb.Skip {
+					t.Errorf("%v: frame %d: macroblock %+v", size, i, mb)
+					break
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,291 @@
+// Package health scores how well a live stream is doing from the packets
+// flowing through it, so failing cameras can be flagged automatically.
+package health
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/deepch/vdk/av"
+)
+
+const (
+	DefaultWindow  = 60 * time.Second
+	DefaultMaxGap  = 2 * time.Second
+	DefaultMaxJump = time.Second
+)
+
+// Reason codes.
+const (
+	Stalled          = "stalled"
+	PacketGaps       = "packet_gaps"
+	TimestampJumps   = "timestamp_jumps"
+	Reconnects       = "reconnects"
+	BitrateUnstable  = "bitrate_unstable"
+	KeyframeUnstable = "keyframe_unstable"
+	NoKeyframe       = "no_keyframe"
+)
+
+type Reason struct {
+	Code    string
+	Penalty int
+	Detail  string
+}
+
+// Report is the state over the last Window, Score is 100 for a healthy
+// stream and 0 for a dead one.
+type Report struct {
+	Score            int
+	Reasons          []Reason
+	Packets          int
+	Bitrate          int     // bits per second
+	BitrateVariation float64 // coefficient of variation of the per second bitrate
+	KeyframeInterval time.Duration
+	KeyframeJitter   float64 // coefficient of variation of the keyframe interval
+	Gaps             int
+	Jumps            int
+	Reconnects       int
+	LastPacket       time.Time
+}
+
+// Monitor collects the metrics of one stream. Feed it with Observe, or
+// install it as a pktque.Filter, and call Reconnected when the source is
+// reopened.
+type Monitor struct {
+	Window  time.Duration // DefaultWindow if zero
+	MaxGap  time.Duration // arrival gap counted as a packet gap, DefaultMaxGap if zero
+	MaxJump time.Duration // timestamp discontinuity, DefaultMaxJump if zero
+	Now     func() time.Time
+
+	lock       sync.Mutex
+	packets    []time.Time
+	buckets    []bucket
+	gaps       []time.Time
+	jumps      []time.Time
+	reconnects []time.Time
+	keyframes  []keyframe
+	lastTime   map[int8]time.Duration
+	last       time.Time
+	hasVideo   bool
+}
+
+type bucket struct {
+	sec   int64
+	bytes int
+}
+
+type keyframe struct {
+	at  time.Time
+	pts time.Duration
+}
+
+func (self *Monitor) now() time.Time {
+	if self.Now != nil {
+		return self.Now()
+	}
+	return time.Now()
+}
+
+func (self *Monitor) window() time.Duration {
+	if self.Window > 0 {
+		return self.Window
+	}
+	return DefaultWindow
+}
+
+func (self *Monitor) maxGap() time.Duration {
+	if self.MaxGap > 0 {
+		return self.MaxGap
+	}
+	return DefaultMaxGap
+}
+
+func (self *Monitor) maxJump() time.Duration {
+	if self.MaxJump > 0 {
+		return self.MaxJump
+	}
+	return DefaultMaxJump
+}
+
+// Observe accounts a packet, isvideo tells if it belongs to the video stream.
+func (self *Monitor) Observe(pkt av.Packet, isvideo bool) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	now := self.now()
+	if !self.last.IsZero() && now.Sub(self.last) > self.maxGap() || pkt.Flags.Has(av.PacketGap) {
+		self.gaps = append(self.gaps, now)
+	}
+	self.last = now
+	self.packets = append(self.packets, now)
+
+	sec := now.Unix()
+	if n := len(self.buckets); n > 0 && self.buckets[n-1].sec == sec {
+		self.buckets[n-1].bytes += len(pkt.Data)
+	} else {
+		self.buckets = append(self.buckets, bucket{sec: sec, bytes: len(pkt.Data)})
+	}
+
+	if self.lastTime == nil {
+		self.lastTime = map[int8]time.Duration{}
+	}
+	if prev, ok := self.lastTime[pkt.Idx]; ok {
+		if delta := pkt.Time - prev; delta > self.maxJump() || delta < -self.maxJump() {
+			self.jumps = append(self.jumps, now)
+		}
+	}
+	self.lastTime[pkt.Idx] = pkt.Time
+
+	if isvideo {
+		self.hasVideo = true
+		if pkt.IsKeyFrame {
+			self.keyframes = append(self.keyframes, keyframe{at: now, pts: pkt.Time})
+		}
+	}
+	self.prune(now)
+}
+
+func (self *Monitor) ModifyPacket(pkt *av.Packet, streams []av.CodecData, videoidx int, audioidx int) (drop bool, err error) {
+	isvideo := int(pkt.Idx) < len(streams) && streams[pkt.Idx].Type().IsVideo()
+	self.Observe(*pkt, isvideo)
+	return
+}
+
+// Reconnected records that the source had to be reopened.
+func (self *Monitor) Reconnected() {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	now := self.now()
+	self.reconnects = append(self.reconnects, now)
+	// timestamps restart with the new session
+	self.lastTime = nil
+	self.prune(now)
+}
+
+func pruneTimes(times []time.Time, from time.Time) []time.Time {
+	i := 0
+	for i < len(times) && times[i].Before(from) {
+		i++
+	}
+	return times[i:]
+}
+
+func (self *Monitor) prune(now time.Time) {
+	from := now.Add(-self.window())
+	self.packets = pruneTimes(self.packets, from)
+	self.gaps = pruneTimes(self.gaps, from)
+	self.jumps = pruneTimes(self.jumps, from)
+	self.reconnects = pruneTimes(self.reconnects, from)
+	i := 0
+	for i < len(self.buckets) && self.buckets[i].sec < from.Unix() {
+		i++
+	}
+	self.buckets = self.buckets[i:]
+	i = 0
+	for i < len(self.keyframes) && self.keyframes[i].at.Before(from) {
+		i++
+	}
+	self.keyframes = self.keyframes[i:]
+}
+
+func meanCV(values []float64) (mean float64, cv float64) {
+	if len(values) == 0 {
+		return
+	}
+	for _, v := range values {
+		mean += v
+	}
+	mean /= float64(len(values))
+	if mean == 0 {
+		return
+	}
+	var variance float64
+	for _, v := range values {
+		variance += (v - mean) * (v - mean)
+	}
+	variance /= float64(len(values))
+	cv = math.Sqrt(variance) / mean
+	return
+}
+
+func penalty(count, each, max int) int {
+	if p := count * each; p < max {
+		return p
+	}
+	return max
+}
+
+// Report scores the stream over the last Window.
+func (self *Monitor) Report() (report Report) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	now := self.now()
+	self.prune(now)
+	report.Score = 100
+	report.Packets = len(self.packets)
+	report.Gaps = len(self.gaps)
+	report.Jumps = len(self.jumps)
+	report.Reconnects = len(self.reconnects)
+	report.LastPacket = self.last
+
+	add := func(code string, p int, detail string) {
+		report.Reasons = append(report.Reasons, Reason{Code: code, Penalty: p, Detail: detail})
+		report.Score -= p
+	}
+
+	if self.last.IsZero() {
+		add(Stalled, 60, "no packet received")
+	} else if now.Sub(self.last) > self.maxGap() {
+		add(Stalled, 60, fmt.Sprintf("no packet for %v", now.Sub(self.last).Truncate(time.Millisecond)))
+	}
+	if report.Gaps > 0 {
+		add(PacketGaps, penalty(report.Gaps, 10, 30), fmt.Sprintf("%d gaps", report.Gaps))
+	}
+	if report.Jumps > 0 {
+		add(TimestampJumps, penalty(report.Jumps, 10, 30), fmt.Sprintf("%d jumps", report.Jumps))
+	}
+	if report.Reconnects > 0 {
+		add(Reconnects, penalty(report.Reconnects, 15, 45), fmt.Sprintf("%d reconnects", report.Reconnects))
+	}
+
+	// the current second is still filling up
+	var rates []float64
+	for _, b := range self.buckets {
+		if b.sec != now.Unix() {
+			rates = append(rates, float64(b.bytes*8))
+		}
+	}
+	var mean float64
+	mean, report.BitrateVariation = meanCV(rates)
+	report.Bitrate = int(mean)
+	if len(rates) >= 5 {
+		switch {
+		case report.BitrateVariation > 1:
+			add(BitrateUnstable, 20, fmt.Sprintf("bitrate variation %.2f", report.BitrateVariation))
+		case report.BitrateVariation > 0.5:
+			add(BitrateUnstable, 10, fmt.Sprintf("bitrate variation %.2f", report.BitrateVariation))
+		}
+	}
+
+	var intervals []float64
+	for i := 1; i < len(self.keyframes); i++ {
+		if d := self.keyframes[i].pts - self.keyframes[i-1].pts; d > 0 {
+			intervals = append(intervals, float64(d))
+		}
+	}
+	mean, report.KeyframeJitter = meanCV(intervals)
+	report.KeyframeInterval = time.Duration(mean)
+	if self.hasVideo && len(self.packets) > 0 && len(self.keyframes) == 0 {
+		add(NoKeyframe, 20, fmt.Sprintf("no keyframe in %v", self.window()))
+	} else if len(intervals) >= 3 && report.KeyframeJitter > 0.5 {
+		add(KeyframeUnstable, 10, fmt.Sprintf("keyframe interval variation %.2f", report.KeyframeJitter))
+	}
+
+	if report.Score < 0 {
+		report.Score = 0
+	}
+	return
+}
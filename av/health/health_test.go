@@ -0,0 +1,187 @@
+package health
+
+import (
+	"testing"
+	"time"
+
+	"github.com/deepch/vdk/av"
+	"github.com/deepch/vdk/codec"
+	"github.com/deepch/vdk/codec/h264parser"
+)
+
+// feeder drives a Monitor at 25 fps on a fake clock.
+type feeder struct {
+	m     *Monitor
+	now   time.Time
+	pts   time.Duration
+	frame int
+}
+
+func newFeeder(window time.Duration) *feeder {
+	f := &feeder{now: time.Unix(1000, 0)}
+	f.m = &Monitor{Window: window, Now: func() time.Time { return f.now }}
+	return f
+}
+
+// video feeds n frames of size bytes, with a keyframe every gop frames
+// or none if gop is zero.
+func (self *feeder) video(n, gop, size int) {
+	for i := 0; i < n; i++ {
+		key := gop > 0 && self.frame%gop == 0
+		self.m.Observe(av.Packet{IsKeyFrame: key, Time: self.pts, Data: make([]byte, size)}, true)
+		self.frame++
+		self.pts += 40 * time.Millisecond
+		self.now = self.now.Add(40 * time.Millisecond)
+	}
+}
+
+func (self *feeder) wait(d time.Duration) {
+	self.now = self.now.Add(d)
+}
+
+func TestReport(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		feed  func(f *feeder)
+		score int
+		codes []string
+	}{
+		{"healthy", func(f *feeder) { f.video(250, 25, 1000) }, 100, nil},
+		{"never fed", func(f *feeder) {}, 40, []string{Stalled}},
+		{"stalled", func(f *feeder) {
+			f.video(125, 25, 1000)
+			f.wait(3 * time.Second)
+		}, 40, []string{Stalled}},
+		{"arrival gap", func(f *feeder) {
+			f.video(125, 25, 1000)
+			f.wait(3 * time.Second)
+			f.video(125, 25, 1000)
+		}, 90, []string{PacketGaps}},
+		{"gap flag", func(f *feeder) {
+			f.video(125, 25, 1000)
+			f.m.Observe(av.Packet{Idx: 1, Flags: av.PacketGap, Time: f.pts}, false)
+			f.video(125, 25, 1000)
+		}, 90, []string{PacketGaps}},
+		{"gaps capped", func(f *feeder) {
+			for i := 0; i < 5; i++ {
+				f.video(50, 25, 1000)
+				f.wait(3 * time.Second)
+			}
+			f.video(50, 25, 1000)
+		}, 70, []string{PacketGaps}},
+		{"timestamp jump", func(f *feeder) {
+			f.m.Observe(av.Packet{Idx: 1, Time: 0}, false)
+			f.video(125, 25, 1000)
+			f.m.Observe(av.Packet{Idx: 1, Time: 10 * time.Second}, false)
+			f.video(125, 25, 1000)
+		}, 90, []string{TimestampJumps}},
+		{"reconnect", func(f *feeder) {
+			f.video(125, 25, 1000)
+			f.m.Reconnected()
+			// timestamps restart without counting as a jump
+			f.pts = 0
+			f.video(125, 25, 1000)
+		}, 85, []string{Reconnects}},
+		{"reconnects capped", func(f *feeder) {
+			for i := 0; i < 5; i++ {
+				f.video(50, 25, 1000)
+				f.m.Reconnected()
+			}
+			f.video(50, 25, 1000)
+		}, 55, []string{Reconnects}},
+		{"no keyframe", func(f *feeder) { f.video(250, 0, 1000) }, 80, []string{NoKeyframe}},
+		{"keyframe unstable", func(f *feeder) {
+			for i := 0; i < 4; i++ {
+				f.frame = 0
+				f.video(5, 100, 1000)
+				f.frame = 0
+				f.video(75, 100, 1000)
+			}
+		}, 90, []string{KeyframeUnstable}},
+		{"bitrate variation", func(f *feeder) {
+			for i := 0; i < 5; i++ {
+				f.video(25, 25, 100)
+				f.video(25, 25, 400)
+			}
+		}, 90, []string{BitrateUnstable}},
+		{"bitrate unstable", func(f *feeder) {
+			for i := 0; i < 9; i++ {
+				f.video(25, 25, 100)
+			}
+			f.video(25, 25, 10000)
+		}, 80, []string{BitrateUnstable}},
+		{"score floor", func(f *feeder) {
+			for i := 0; i < 5; i++ {
+				f.m.Reconnected()
+			}
+		}, 0, []string{Stalled, Reconnects}},
+	} {
+		f := newFeeder(0)
+		test.feed(f)
+		report := f.m.Report()
+		var codes []string
+		for _, reason := range report.Reasons {
+			codes = append(codes, reason.Code)
+		}
+		if report.Score != test.score || len(codes) != len(test.codes) {
+			t.Errorf("%s: score %d for %v, want %d for %v", test.name, report.Score, report.Reasons, test.score, test.codes)
+			continue
+		}
+		for i := range codes {
+			if codes[i] != test.codes[i] {
+				t.Errorf("%s: reasons %v, want %v", test.name, codes, test.codes)
+				break
+			}
+		}
+	}
+}
+
+func TestReportMetrics(t *testing.T) {
+	f := newFeeder(0)
+	f.video(250, 50, 1000)
+	report := f.m.Report()
+	if report.Packets != 250 || report.Bitrate != 200000 || report.BitrateVariation != 0 {
+		t.Errorf("%d packets at %d bps, variation %v", report.Packets, report.Bitrate, report.BitrateVariation)
+	}
+	if report.KeyframeInterval != 2*time.Second || report.KeyframeJitter != 0 {
+		t.Errorf("keyframe every %v, jitter %v", report.KeyframeInterval, report.KeyframeJitter)
+	}
+	if !report.LastPacket.Equal(f.now.Add(-40 * time.Millisecond)) {
+		t.Errorf("last packet at %v", report.LastPacket)
+	}
+}
+
+func TestWindow(t *testing.T) {
+	f := newFeeder(5 * time.Second)
+	f.m.Reconnected()
+	f.m.Observe(av.Packet{Idx: 1, Flags: av.PacketGap}, false)
+	f.video(250, 25, 1000)
+	report := f.m.Report()
+	// the reconnect and the gap are out of the window
+	if report.Score != 100 || report.Gaps != 0 || report.Reconnects != 0 {
+		t.Errorf("score %d with %d gaps and %d reconnects", report.Score, report.Gaps, report.Reconnects)
+	}
+	if report.Packets != 125 {
+		t.Errorf("%d packets in the window", report.Packets)
+	}
+}
+
+func TestModifyPacket(t *testing.T) {
+	f := newFeeder(0)
+	streams := []av.CodecData{codec.NewPCMMulawCodecData(), h264parser.CodecData{}}
+	for i := 0; i < 250; i++ {
+		// only the audio stream has keyframe flags
+		pkt := av.Packet{Idx: 0, IsKeyFrame: true, Time: f.pts}
+		if drop, err := f.m.ModifyPacket(&pkt, streams, 1, 0); drop || err != nil {
+			t.Fatal(drop, err)
+		}
+		pkt = av.Packet{Idx: 1, Time: f.pts}
+		f.m.ModifyPacket(&pkt, streams, 1, 0)
+		f.wait(40 * time.Millisecond)
+		f.pts += 40 * time.Millisecond
+	}
+	report := f.m.Report()
+	if report.Packets != 500 || len(report.Reasons) != 1 || report.Reasons[0].Code != NoKeyframe {
+		t.Errorf("%d packets, reasons %v", report.Packets, report.Reasons)
+	}
+}
@@ -0,0 +1,248 @@
+// Package motion estimates where a H.264 picture moved from the way its
+// macroblocks were coded, skipped or not, without decoding it, to trigger
+// recording on hardware too small to run a decoder. It is experimental and
+// reads CAVLC P pictures only, as those of the baseline profile.
+package motion
+
+import (
+	"image"
+	"sync"
+	"time"
+
+	"github.com/deepch/vdk/av"
+	"github.com/deepch/vdk/codec/h264parser"
+)
+
+const (
+	DefaultMinMotion = 8    // quarter samples, two pixels
+	DefaultMinCoeffs = 4    // non zero coefficients
+	DefaultThreshold = 0.01 // fraction of the macroblocks
+	DefaultHold      = 2 * time.Second
+)
+
+// Frame is the activity of a P picture. Active is nil for other pictures,
+// which tell nothing about motion.
+type Frame struct {
+	Idx    int8
+	Time   time.Duration
+	Width  int // in macroblocks
+	Height int
+	Active []bool // by macroblock address, in raster order
+	Count  int    // active macroblocks
+}
+
+// Ratio is the fraction of the macroblocks found active.
+func (self Frame) Ratio() float64 {
+	if len(self.Active) == 0 {
+		return 0
+	}
+	return float64(self.Count) / float64(len(self.Active))
+}
+
+// Regions returns the bounding boxes in pixels of the groups of adjacent
+// active macroblocks, those of fewer than min macroblocks left out.
+func (self Frame) Regions(min int) (regions []image.Rectangle) {
+	seen := make([]bool, len(self.Active))
+	var stack []int
+	for start, active := range self.Active {
+		if !active || seen[start] {
+			continue
+		}
+		seen[start] = true
+		stack = append(stack[:0], start)
+		var rect image.Rectangle
+		n := 0
+		for len(stack) > 0 {
+			addr := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			n++
+			x, y := addr%self.Width, addr/self.Width
+			rect = rect.Union(image.Rect(x, y, x+1, y+1))
+			for _, p := range [4]image.Point{{x - 1, y}, {x + 1, y}, {x, y - 1}, {x, y + 1}} {
+				if p.X < 0 || p.X >= self.Width || p.Y < 0 {
+					continue
+				}
+				next := p.Y*self.Width + p.X
+				if next >= len(self.Active) || !self.Active[next] || seen[next] {
+					continue
+				}
+				seen[next] = true
+				stack = append(stack, next)
+			}
+		}
+		if n >= min {
+			regions = append(regions, image.Rect(rect.Min.X*16, rect.Min.Y*16, rect.Max.X*16, rect.Max.Y*16))
+		}
+	}
+	return
+}
+
+type h264State struct {
+	sps map[uint]h264parser.SPSInfo
+	pps map[uint]h264parser.PPSInfo
+}
+
+func (self *h264State) update(nalu []byte) {
+	switch nalu[0] & 0x1f {
+	case h264parser.NALU_SPS:
+		if sps, err := h264parser.ParseSPS(nalu); err == nil {
+			self.sps[sps.Id] = sps
+		}
+	case h264parser.NALU_PPS:
+		if pps, err := h264parser.ParsePPS(nalu); err == nil {
+			self.pps[pps.Id] = pps
+		}
+	}
+}
+
+// Parser marks the macroblocks of P pictures active when they are coded
+// with a motion vector differing from the predicted one by MinMotion, or
+// with MinCoeffs coefficients, or intra. Skipped macroblocks are still.
+type Parser struct {
+	MinMotion int // DefaultMinMotion if zero
+	MinCoeffs int // DefaultMinCoeffs if zero
+
+	streams []av.CodecData
+	h264    map[int8]*h264State
+}
+
+func NewParser(streams []av.CodecData) *Parser {
+	self := &Parser{streams: streams, h264: map[int8]*h264State{}}
+	for i, stream := range streams {
+		if codec, ok := stream.(h264parser.CodecData); ok {
+			state := &h264State{sps: map[uint]h264parser.SPSInfo{}, pps: map[uint]h264parser.PPSInfo{}}
+			state.update(codec.SPS())
+			state.update(codec.PPS())
+			self.h264[int8(i)] = state
+		}
+	}
+	return self
+}
+
+func (self *Parser) active(mb h264parser.Macroblock) bool {
+	minMotion, minCoeffs := self.MinMotion, self.MinCoeffs
+	if minMotion == 0 {
+		minMotion = DefaultMinMotion
+	}
+	if minCoeffs == 0 {
+		minCoeffs = DefaultMinCoeffs
+	}
+	return !mb.Skip && (mb.Intra || mb.Motion >= minMotion || mb.Coeffs >= minCoeffs)
+}
+
+// Parse returns the frame of a H.264 packet, ok is false for other streams.
+func (self *Parser) Parse(pkt av.Packet) (frame Frame, ok bool) {
+	state := self.h264[pkt.Idx]
+	if state == nil {
+		return
+	}
+	ok = true
+	frame = Frame{Idx: pkt.Idx, Time: pkt.Time}
+	r := h264parser.NewNALUReader(pkt.Data)
+	for nalu, more := r.Next(); more; nalu, more = r.Next() {
+		if len(nalu) == 0 {
+			continue
+		}
+		if !h264parser.IsDataNALU(nalu) {
+			state.update(nalu)
+			continue
+		}
+		id, err := h264parser.SlicePPSId(nalu)
+		if err != nil {
+			continue
+		}
+		pps, found := state.pps[id]
+		if !found {
+			continue
+		}
+		sps, found := state.sps[pps.SPSId]
+		if !found {
+			continue
+		}
+		h, mbs, err := h264parser.ParseSliceData(nalu, sps, pps)
+		if err != nil || h.Type != h264parser.SLICE_P {
+			continue
+		}
+		if frame.Active == nil {
+			frame.Width, frame.Height = int(sps.MbWidth), int(sps.MbHeight)
+			frame.Active = make([]bool, frame.Width*frame.Height)
+		}
+		for _, mb := range mbs {
+			if mb.Addr < len(frame.Active) && self.active(mb) && !frame.Active[mb.Addr] {
+				frame.Active[mb.Addr] = true
+				frame.Count++
+			}
+		}
+	}
+	return
+}
+
+// Detector tells when motion starts and stops in a video stream: it starts
+// with a frame of more than Threshold active macroblocks and stops after
+// Hold without one. Feed it with Observe or install it as a pktque.Filter.
+type Detector struct {
+	MinMotion int           // see Parser
+	MinCoeffs int           // see Parser
+	Threshold float64       // DefaultThreshold if zero
+	Hold      time.Duration // DefaultHold if zero
+	OnFrame   func(frame Frame)
+	OnMotion  func(frame Frame)      // called with the frame motion starts at
+	OnStill   func(tm time.Duration) // called when motion stopped
+
+	lock   sync.Mutex
+	parser *Parser
+	motion bool
+	last   time.Duration
+}
+
+func (self *Detector) Observe(frame Frame) {
+	if self.OnFrame != nil {
+		self.OnFrame(frame)
+	}
+	if frame.Active == nil {
+		return
+	}
+
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	threshold := self.Threshold
+	if threshold == 0 {
+		threshold = DefaultThreshold
+	}
+	hold := self.Hold
+	if hold == 0 {
+		hold = DefaultHold
+	}
+	if frame.Ratio() > threshold {
+		self.last = frame.Time
+		if !self.motion {
+			self.motion = true
+			if self.OnMotion != nil {
+				self.OnMotion(frame)
+			}
+		}
+	} else if self.motion && frame.Time-self.last >= hold {
+		self.motion = false
+		if self.OnStill != nil {
+			self.OnStill(frame.Time)
+		}
+	}
+}
+
+func (self *Detector) Motion() bool {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return self.motion
+}
+
+func (self *Detector) ModifyPacket(pkt *av.Packet, streams []av.CodecData, videoidx int, audioidx int) (drop bool, err error) {
+	if self.parser == nil {
+		self.parser = NewParser(streams)
+		self.parser.MinMotion, self.parser.MinCoeffs = self.MinMotion, self.MinCoeffs
+	}
+	if frame, ok := self.parser.Parse(*pkt); ok {
+		self.Observe(frame)
+	}
+	return
+}
@@ -0,0 +1,69 @@
+package pktque
+
+import (
+	"time"
+)
+
+type AudioClockMode int
+
+const (
+	AudioClockContainer AudioClockMode = iota // packet times are the container timestamps
+	AudioClockSamples                         // packet times run from the sample count only
+	AudioClockReconcile                       // sample count, resynced to the container on drift
+)
+
+const DefaultMaxAudioDrift = 100 * time.Millisecond
+
+// AudioClock times audio packets from the number of samples they carry and
+// measures how far the container timestamps are from that clock.
+type AudioClock struct {
+	Mode     AudioClockMode
+	MaxDrift time.Duration             // AudioClockReconcile threshold, DefaultMaxAudioDrift if zero
+	OnDrift  func(drift time.Duration) // called when the threshold is crossed
+	next     time.Duration
+	started  bool
+}
+
+// Next returns the time of a packet with container timestamp ts holding dur
+// worth of samples, drift is ts minus the sample clock.
+func (self *AudioClock) Next(ts time.Duration, dur time.Duration) (tm time.Duration, drift time.Duration) {
+	if !self.started {
+		self.next = ts
+		self.started = true
+	}
+	drift = ts - self.next
+	tm = self.next
+	switch self.Mode {
+	case AudioClockContainer:
+		tm = ts
+	case AudioClockReconcile:
+		max := self.MaxDrift
+		if max == 0 {
+			max = DefaultMaxAudioDrift
+		}
+		if drift > max || drift < -max {
+			if self.OnDrift != nil {
+				self.OnDrift(drift)
+			}
+			tm = ts
+		}
+	}
+	self.next = tm + dur
+	return
+}
+
+// Expected returns the time of the next packet by the sample count, ok is
+// false before the first one. Resume carries it over to another clock.
+func (self *AudioClock) Expected() (next time.Duration, ok bool) {
+	return self.next, self.started
+}
+
+// Resume starts the clock as if the packets before next had been timed.
+func (self *AudioClock) Resume(next time.Duration) {
+	self.next = next
+	self.started = true
+}
+
+func (self *AudioClock) Reset() {
+	self.started = false
+}
@@ -0,0 +1,58 @@
+package pktque
+
+import (
+	"github.com/deepch/vdk/av"
+	"github.com/deepch/vdk/codec/h264parser"
+)
+
+// Wrap a Demuxer so H.264 packets get their CompositionTime from the POC of
+// their slices, as the MP4 muxer needs to write ctts for B-frames. Meant
+// for sources without composition times such as AVI or raw TS dumps,
+// composition times already set are overwritten. Other packets pass
+// through unchanged.
+type CompositionDemuxer struct {
+	av.Demuxer
+	analyzers []*h264parser.POCAnalyzer
+	out       []av.Packet
+	err       error
+}
+
+func (self *CompositionDemuxer) ReadPacket() (pkt av.Packet, err error) {
+	if self.analyzers == nil {
+		var streams []av.CodecData
+		if streams, err = self.Demuxer.Streams(); err != nil {
+			return
+		}
+		self.analyzers = make([]*h264parser.POCAnalyzer, len(streams))
+		for i, stream := range streams {
+			if codec, ok := stream.(h264parser.CodecData); ok {
+				self.analyzers[i] = h264parser.NewPOCAnalyzer(codec)
+			}
+		}
+	}
+
+	for len(self.out) == 0 {
+		if self.err != nil {
+			err = self.err
+			return
+		}
+		if pkt, err = self.Demuxer.ReadPacket(); err != nil {
+			self.err = err
+			err = nil
+			for _, analyzer := range self.analyzers {
+				if analyzer != nil {
+					self.out = append(self.out, analyzer.Flush()...)
+				}
+			}
+			continue
+		}
+		if int(pkt.Idx) >= len(self.analyzers) || self.analyzers[pkt.Idx] == nil {
+			return
+		}
+		self.out = self.analyzers[pkt.Idx].Push(pkt)
+	}
+
+	pkt = self.out[0]
+	self.out = self.out[1:]
+	return
+}
@@ -0,0 +1,167 @@
+package pktque
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/deepch/vdk/av"
+	"github.com/deepch/vdk/codec/fake"
+	"github.com/deepch/vdk/codec/h264parser"
+	"github.com/deepch/vdk/utils/bits"
+)
+
+type golomb struct {
+	buf bytes.Buffer
+	w   *bits.Writer
+}
+
+func newGolomb(header byte) *golomb {
+	self := &golomb{}
+	self.buf.WriteByte(header)
+	self.w = &bits.Writer{W: &self.buf}
+	return self
+}
+
+func (self *golomb) u(v uint, n int) *golomb {
+	self.w.WriteBits(v, n)
+	return self
+}
+
+func (self *golomb) ue(v uint) *golomb {
+	n := 0
+	for (v+1)>>uint(n+1) != 0 {
+		n++
+	}
+	return self.u(0, n).u(v+1, n+1)
+}
+
+func (self *golomb) rbsp() []byte {
+	self.u(1, 1)
+	self.w.FlushBits()
+	return h264parser.RBSPToEBSP(self.buf.Bytes())
+}
+
+// ibbpSlice is a slice of a 32x32 stream with a 4 bit frame_num and 6 bit
+// POC lsb, its slice data left out.
+func ibbpSlice(typ byte, frameNum, display uint) []byte {
+	switch typ {
+	case 'I':
+		return newGolomb(0x65).ue(0).ue(7).ue(0).u(frameNum, 4).ue(0).u(2*display, 6).u(0, 2).ue(0).rbsp()
+	case 'P':
+		return newGolomb(0x41).ue(0).ue(5).ue(0).u(frameNum, 4).u(2*display, 6).u(0, 3).ue(0).rbsp()
+	default:
+		// non reference B
+		return newGolomb(0x01).ue(0).ue(6).ue(0).u(frameNum, 4).u(2*display, 6).u(1, 1).u(0, 3).ue(0).rbsp()
+	}
+}
+
+type sliceDemuxer struct {
+	streams []av.CodecData
+	pkts    []av.Packet
+}
+
+func (self *sliceDemuxer) Streams() ([]av.CodecData, error) {
+	return self.streams, nil
+}
+
+func (self *sliceDemuxer) ReadPacket() (pkt av.Packet, err error) {
+	if len(self.pkts) == 0 {
+		err = io.EOF
+		return
+	}
+	pkt, self.pkts = self.pkts[0], self.pkts[1:]
+	return
+}
+
+func TestCompositionDemuxer(t *testing.T) {
+	const frame = 40 * time.Millisecond
+	sps := newGolomb(0x67).u(77, 8).u(0, 8).u(30, 8).ue(0).ue(0).ue(0).ue(2).
+		ue(2).u(0, 1).ue(1).ue(1).u(1, 1).u(1, 1).u(0, 1).
+		u(1, 1).u(0, 8).u(1, 1).u(1, 1).ue(0).ue(0).ue(0).ue(0).ue(1).ue(2). // vui with max_num_reorder_frames 1
+		rbsp()
+	pps := newGolomb(0x68).ue(0).ue(0).u(0, 2).ue(0).ue(0).ue(0).u(0, 3).ue(0).ue(0).ue(0).u(1, 1).u(0, 2).rbsp()
+	codec, err := h264parser.NewCodecDataFromSPSAndPPS(sps, pps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if codec.ReorderDepth() != 1 {
+		t.Fatalf("reorder depth %d", codec.ReorderDepth())
+	}
+
+	// IBBP in decode order, the composition times they should get with a
+	// reorder depth of one frame, and audio in between
+	video := []struct {
+		typ      byte
+		frameNum uint
+		display  uint
+		ct       time.Duration
+	}{
+		{'I', 0, 0, 1},
+		{'P', 1, 3, 3},
+		{'b', 2, 1, 0},
+		{'b', 2, 2, 0},
+		{'P', 2, 6, 3},
+		{'b', 3, 4, 0},
+		{'b', 3, 5, 0},
+		{'I', 0, 0, 1},
+		{'P', 1, 3, 3},
+		{'b', 2, 1, 0},
+		{'b', 2, 2, 0},
+	}
+	demuxer := &sliceDemuxer{streams: []av.CodecData{codec, fake.CodecData{CodecType_: av.AAC}, fake.CodecData{CodecType_: av.H265}}}
+	for i, f := range video {
+		demuxer.pkts = append(demuxer.pkts,
+			av.Packet{Idx: 0, IsKeyFrame: f.typ == 'I', Time: time.Duration(i) * frame, CompositionTime: time.Hour,
+				Data: h264parser.AppendNALU(nil, ibbpSlice(f.typ, f.frameNum, f.display), h264parser.NALU_AVCC)},
+			av.Packet{Idx: 1, Time: time.Duration(i) * frame, Data: []byte{byte(i)}},
+			av.Packet{Idx: 2, Time: time.Duration(i) * frame, CompositionTime: frame, Data: []byte{byte(i)}},
+		)
+	}
+
+	filter := &CompositionDemuxer{Demuxer: demuxer}
+	var got []av.Packet
+	for {
+		pkt, err := filter.ReadPacket()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, pkt)
+	}
+	if _, err = filter.ReadPacket(); err != io.EOF {
+		t.Errorf("read after the end: %v", err)
+	}
+
+	var h264, aac, h265 int
+	for _, pkt := range got {
+		switch pkt.Idx {
+		case 0:
+			if h264 >= len(video) {
+				t.Fatalf("%d video packets", h264+1)
+			}
+			f := video[h264]
+			if pkt.Time != time.Duration(h264)*frame || pkt.CompositionTime != f.ct*frame {
+				t.Errorf("frame %d %c%d at %v composition time %v, want %v", h264, f.typ, f.display, pkt.Time, pkt.CompositionTime, f.ct*frame)
+			}
+			h264++
+		case 1:
+			if pkt.Time != time.Duration(aac)*frame || pkt.CompositionTime != 0 {
+				t.Errorf("audio %d at %v composition time %v", aac, pkt.Time, pkt.CompositionTime)
+			}
+			aac++
+		case 2:
+			// only H.264 is rebuilt
+			if pkt.CompositionTime != frame {
+				t.Errorf("h265 %d composition time %v", h265, pkt.CompositionTime)
+			}
+			h265++
+		}
+	}
+	if h264 != len(video) || aac != len(video) || h265 != len(video) {
+		t.Errorf("read %d video, %d audio and %d h265 packets", h264, aac, h265)
+	}
+}
@@ -0,0 +1,177 @@
+package pktque
+
+import (
+	"time"
+
+	"github.com/deepch/vdk/av"
+)
+
+const (
+	DefaultMaxDriftCorrection = 0.001 // 1ms per second, about 86s a day
+	DefaultDriftWindow        = 30 * time.Minute
+	DefaultDriftInterval      = 10 * time.Second
+	DefaultDriftMinSpan       = 2 * time.Minute
+	DefaultDriftReset         = 10 * time.Second
+)
+
+type driftPoint struct {
+	ts     time.Duration // camera time since start
+	offset time.Duration // arrival time since start minus ts
+}
+
+// DriftCompensator estimates how fast the camera clock runs against the
+// arrival clock and rescales packet times to match, so long recordings stay
+// in step with wallclock. Network delay only adds to arrival times, so the
+// estimate fits the smallest offset seen in each interval over a sliding
+// window. The rate changes at interval boundaries with the mapping kept
+// continuous, times never jump.
+type DriftCompensator struct {
+	MaxCorrection float64       // largest relative rate change, DefaultMaxDriftCorrection if zero
+	Window        time.Duration // history the estimate is fitted on, DefaultDriftWindow if zero
+	Interval      time.Duration // DefaultDriftInterval if zero
+	MinSpan       time.Duration // history needed before correcting, DefaultDriftMinSpan if zero
+	Reset         time.Duration // offset jump restarting the estimate, DefaultDriftReset if zero
+	Now           func() time.Time
+	OnEstimate    func(ratio float64) // called when the applied rate changes
+
+	started  bool
+	ts0      time.Duration
+	arrival0 time.Time
+	points   []driftPoint
+	cur      driftPoint
+	curStart time.Duration
+	hasCur   bool
+
+	ratio   float64
+	base    time.Duration
+	baseOut time.Duration
+}
+
+// Ratio returns the rate applied to camera time, above 1 when the camera
+// clock is slow.
+func (self *DriftCompensator) Ratio() float64 {
+	if self.ratio == 0 {
+		return 1
+	}
+	return self.ratio
+}
+
+// Correct returns the compensated time of a packet with camera time ts
+// that arrived at arrival.
+func (self *DriftCompensator) Correct(ts time.Duration, arrival time.Time) (tm time.Duration) {
+	if !self.started {
+		self.restart(ts, arrival)
+		self.base, self.baseOut = ts, ts
+	}
+	elapsed := ts - self.ts0
+	offset := arrival.Sub(self.arrival0) - elapsed
+
+	reset := self.Reset
+	if reset == 0 {
+		reset = DefaultDriftReset
+	}
+	if self.hasCur {
+		if d := offset - self.cur.offset; d > reset || d < -reset {
+			// the camera restarted or jumped its clock, the new times
+			// are taken as they are
+			self.restart(ts, arrival)
+			self.setRatio(ts, 1)
+			self.base, self.baseOut = ts, ts
+			elapsed, offset = 0, 0
+		}
+	}
+
+	interval := self.Interval
+	if interval == 0 {
+		interval = DefaultDriftInterval
+	}
+	if !self.hasCur {
+		self.cur, self.curStart, self.hasCur = driftPoint{elapsed, offset}, elapsed, true
+	} else if elapsed-self.curStart >= interval {
+		self.points = append(self.points, self.cur)
+		self.cur, self.curStart = driftPoint{elapsed, offset}, elapsed
+		self.estimate(ts)
+	} else if offset < self.cur.offset {
+		self.cur = driftPoint{elapsed, offset}
+	}
+
+	tm = self.baseOut + time.Duration(float64(ts-self.base)*self.Ratio())
+	return
+}
+
+func (self *DriftCompensator) restart(ts time.Duration, arrival time.Time) {
+	self.started = true
+	self.ts0, self.arrival0 = ts, arrival
+	self.points = nil
+	self.hasCur = false
+}
+
+func (self *DriftCompensator) setRatio(ts time.Duration, ratio float64) {
+	if ratio == self.Ratio() {
+		return
+	}
+	self.baseOut += time.Duration(float64(ts-self.base) * self.Ratio())
+	self.base = ts
+	self.ratio = ratio
+	if self.OnEstimate != nil {
+		self.OnEstimate(ratio)
+	}
+}
+
+func (self *DriftCompensator) estimate(ts time.Duration) {
+	window := self.Window
+	if window == 0 {
+		window = DefaultDriftWindow
+	}
+	minSpan := self.MinSpan
+	if minSpan == 0 {
+		minSpan = DefaultDriftMinSpan
+	}
+	last := self.points[len(self.points)-1]
+	i := 0
+	for i < len(self.points) && last.ts-self.points[i].ts > window {
+		i++
+	}
+	self.points = self.points[i:]
+	if len(self.points) < 2 || last.ts-self.points[0].ts < minSpan {
+		return
+	}
+
+	// least squares slope of the offset over camera time
+	var sx, sy, sxx, sxy float64
+	for _, p := range self.points {
+		x, y := p.ts.Seconds(), p.offset.Seconds()
+		sx += x
+		sy += y
+		sxx += x * x
+		sxy += x * y
+	}
+	n := float64(len(self.points))
+	den := n*sxx - sx*sx
+	if den == 0 {
+		return
+	}
+	slope := (n*sxy - sx*sy) / den
+
+	max := self.MaxCorrection
+	if max == 0 {
+		max = DefaultMaxDriftCorrection
+	}
+	if slope > max {
+		slope = max
+	} else if slope < -max {
+		slope = -max
+	}
+	self.setRatio(ts, 1+slope)
+}
+
+// ModifyPacket makes DriftCompensator a Filter, packets are taken to
+// arrive when they are filtered.
+func (self *DriftCompensator) ModifyPacket(pkt *av.Packet, streams []av.CodecData, videoidx int, audioidx int) (drop bool, err error) {
+	now := time.Now
+	if self.Now != nil {
+		now = self.Now
+	}
+	pkt.Time = self.Correct(pkt.Time, now())
+	return
+}
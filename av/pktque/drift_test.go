@@ -0,0 +1,119 @@
+package pktque
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/deepch/vdk/av"
+)
+
+// driftRun feeds dc a packet every 100ms for dur, with a camera clock
+// running drift slower than the arrival clock and random network delays up
+// to jitter. It returns how far the last corrected time is from the
+// arrival clock.
+func driftRun(t *testing.T, dc *DriftCompensator, drift float64, dur, jitter time.Duration) (err time.Duration) {
+	rnd := rand.New(rand.NewSource(1))
+	start := time.Unix(1000, 0)
+	var last time.Duration
+	for real := time.Duration(0); real <= dur; real += 100 * time.Millisecond {
+		ts := time.Duration(float64(real) * (1 - drift))
+		arrival := start.Add(real)
+		if jitter > 0 {
+			arrival = arrival.Add(time.Duration(rnd.Int63n(int64(jitter))))
+		}
+		tm := dc.Correct(ts, arrival)
+		if tm < last {
+			t.Fatalf("time %v after %v", tm, last)
+		}
+		last = tm
+		err = tm - real
+	}
+	return
+}
+
+func TestDriftCompensator(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		drift  float64
+		jitter time.Duration
+		ratio  float64
+	}{
+		{"in step", 0, 0, 1},
+		{"slow camera", 0.0005, 0, 1 / 0.9995},
+		{"fast camera", -0.0005, 0, 1 / 1.0005},
+		{"network delay", 0.0005, 300 * time.Millisecond, 1 / 0.9995},
+		{"capped", 0.005, 0, 1 + DefaultMaxDriftCorrection},
+		{"capped fast", -0.005, 0, 1 - DefaultMaxDriftCorrection},
+	} {
+		dc := &DriftCompensator{}
+		var estimates int
+		dc.OnEstimate = func(ratio float64) {
+			estimates++
+		}
+		drift := driftRun(t, dc, test.drift, 40*time.Minute, test.jitter)
+		if math.Abs(dc.Ratio()-test.ratio) > 0.00005 {
+			t.Errorf("%s: ratio %v, want %v", test.name, dc.Ratio(), test.ratio)
+		}
+		if test.ratio == 1 && estimates != 0 {
+			t.Errorf("%s: %d estimates", test.name, estimates)
+		}
+		// uncorrected, a 0.05% drift is 1.2s after 40 minutes
+		if math.Abs(test.drift) < DefaultMaxDriftCorrection && (drift > 300*time.Millisecond || drift < -300*time.Millisecond) {
+			t.Errorf("%s: %v from the arrival clock", test.name, drift)
+		}
+	}
+}
+
+func TestDriftMinSpan(t *testing.T) {
+	dc := &DriftCompensator{}
+	driftRun(t, dc, 0.0005, time.Minute, 0)
+	if dc.Ratio() != 1 {
+		t.Errorf("ratio %v before the minimum span", dc.Ratio())
+	}
+	dc = &DriftCompensator{MinSpan: 30 * time.Second, Interval: time.Second}
+	driftRun(t, dc, 0.0005, time.Minute, 0)
+	if math.Abs(dc.Ratio()-1/0.9995) > 0.00005 {
+		t.Errorf("ratio %v after the minimum span", dc.Ratio())
+	}
+}
+
+func TestDriftReset(t *testing.T) {
+	dc := &DriftCompensator{}
+	var ratios []float64
+	dc.OnEstimate = func(ratio float64) {
+		ratios = append(ratios, ratio)
+	}
+	driftRun(t, dc, 0.0005, 10*time.Minute, 0)
+	if dc.Ratio() == 1 {
+		t.Fatal("no correction")
+	}
+	// the camera restarts its clock from zero
+	arrival := time.Unix(1000, 0).Add(11 * time.Minute)
+	for _, ts := range []time.Duration{0, 100 * time.Millisecond} {
+		if tm := dc.Correct(ts, arrival); tm != ts {
+			t.Errorf("time %v after the reset is %v", ts, tm)
+		}
+		arrival = arrival.Add(100 * time.Millisecond)
+	}
+	if dc.Ratio() != 1 || ratios[len(ratios)-1] != 1 {
+		t.Errorf("ratio %v after the reset", dc.Ratio())
+	}
+}
+
+func TestDriftModifyPacket(t *testing.T) {
+	now := time.Unix(1000, 0)
+	dc := &DriftCompensator{Now: func() time.Time { return now }}
+	pkt := av.Packet{Time: 5 * time.Second}
+	for i := 0; i < 3; i++ {
+		if drop, err := dc.ModifyPacket(&pkt, nil, 0, 1); drop || err != nil {
+			t.Fatal(drop, err)
+		}
+		if want := 5*time.Second + time.Duration(i)*time.Second; pkt.Time != want {
+			t.Errorf("packet %d at %v, want %v", i, pkt.Time, want)
+		}
+		pkt.Time = 5*time.Second + time.Duration(i+1)*time.Second
+		now = now.Add(time.Second)
+	}
+}
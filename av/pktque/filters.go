@@ -2,9 +2,12 @@
 package pktque
 
 import (
+	"bytes"
 	"time"
 
 	"github.com/deepch/vdk/av"
+	"github.com/deepch/vdk/codec/h264parser"
+	"github.com/deepch/vdk/codec/h265parser"
 )
 
 type Filter interface {
@@ -66,19 +69,131 @@ func (self *FilterDemuxer) ReadPacket() (pkt av.Packet, err error) {
 	return
 }
 
-// Drop packets until first video key frame arrived.
+// Drop packets until first video key frame arrived, or random access point
+// with RandomAccess.
 type WaitKeyFrame struct {
+	RandomAccess bool
+
 	ok bool
 }
 
 func (self *WaitKeyFrame) ModifyPacket(pkt *av.Packet, streams []av.CodecData, videoidx int, audioidx int) (drop bool, err error) {
-	if !self.ok && pkt.Idx == int8(videoidx) && pkt.IsKeyFrame {
+	if !self.ok && pkt.Idx == int8(videoidx) && (pkt.IsKeyFrame || self.RandomAccess && pkt.IsRandomAccess()) {
 		self.ok = true
 	}
 	drop = !self.ok
 	return
 }
 
+// Mark the H.264 frames with a recovery point SEI as key frames, so that
+// streams using intra refresh instead of IDR frames can be joined.
+type RecoveryPoint struct {
+	ExactMatch bool // only recovery points giving exact pictures
+	MaxFrames  int  // of recovery, any if zero
+	WhileNoIDR bool // until the first IDR frame, IDR frames only after it
+
+	idr bool
+}
+
+func (self *RecoveryPoint) ModifyPacket(pkt *av.Packet, streams []av.CodecData, videoidx int, audioidx int) (drop bool, err error) {
+	if pkt.Idx != int8(videoidx) || streams[videoidx].Type() != av.H264 {
+		return
+	}
+	if pkt.IsKeyFrame {
+		self.idr = true
+		return
+	}
+	if self.WhileNoIDR && self.idr {
+		return
+	}
+	rp, ok := h264parser.FindRecoveryPoint(pkt.Data)
+	if !ok || (self.ExactMatch && !rp.ExactMatch) || (self.MaxFrames > 0 && rp.FrameCnt > self.MaxFrames) {
+		return
+	}
+	pkt.IsKeyFrame = true
+	return
+}
+
+// Drop the video packets of the lowest priority while Congested returns
+// true, corrupt and disposable ones, and unflagged ones too with Level 3.
+type DropLowPriority struct {
+	Congested func() bool
+	Level     int // drop below this priority, 2 if zero
+}
+
+func (self *DropLowPriority) ModifyPacket(pkt *av.Packet, streams []av.CodecData, videoidx int, audioidx int) (drop bool, err error) {
+	if pkt.Idx != int8(videoidx) || self.Congested == nil || !self.Congested() {
+		return
+	}
+	level := self.Level
+	if level == 0 {
+		level = 2
+	}
+	drop = pkt.Priority() < level
+	return
+}
+
+// Keep the base layer of SVC and MVC H.264 video, for decoders that fail on
+// the extension NALUs, and drop the packets left empty.
+type BaseLayer struct {
+}
+
+func (self *BaseLayer) ModifyPacket(pkt *av.Packet, streams []av.CodecData, videoidx int, audioidx int) (drop bool, err error) {
+	if pkt.Idx != int8(videoidx) || streams[videoidx].Type() != av.H264 {
+		return
+	}
+	pkt.Data = h264parser.BaseLayer(pkt.Data)
+	drop = len(pkt.Data) == 0
+	return
+}
+
+// Drop the non-reference B-frames of H.264 and H.265 video and time the
+// frames left by their presentation time with no composition offset, for
+// consumers that cannot reorder frames, such as some WebRTC stacks and
+// hardware decoders. Reference B-frames, as in B-pyramids, cannot go
+// without a transcode, they are kept at the time of the frame before them so
+// the output times never go backwards.
+type DropBFrames struct {
+	maxPts  time.Duration
+	last    time.Duration
+	started bool
+}
+
+func (self *DropBFrames) ModifyPacket(pkt *av.Packet, streams []av.CodecData, videoidx int, audioidx int) (drop bool, err error) {
+	if pkt.Idx != int8(videoidx) {
+		return
+	}
+	flags := pkt.Flags
+	if !flags.Has(av.PacketDisposable) && !flags.Has(av.PacketReference) {
+		switch streams[videoidx].Type() {
+		case av.H264:
+			flags = h264parser.FrameFlags(pkt.Data)
+		case av.H265:
+			flags = h265parser.FrameFlags(pkt.Data)
+		default:
+			return
+		}
+	}
+	pts := pkt.Time + pkt.CompositionTime
+	if self.started && pts < self.maxPts && !pkt.IsKeyFrame {
+		// shown before a frame decoded earlier, a B-frame
+		if drop = flags.Has(av.PacketDisposable); !drop {
+			pkt.Time = self.last
+			pkt.CompositionTime = 0
+		}
+		return
+	}
+	self.started = true
+	self.maxPts = pts
+	if pts < self.last {
+		pts = self.last
+	}
+	self.last = pts
+	pkt.Time = pts
+	pkt.CompositionTime = 0
+	return
+}
+
 // Fix incorrect packet timestamps.
 type FixTime struct {
 	zerobase      time.Duration
@@ -202,3 +317,33 @@ func (self *Walltime) ModifyPacket(pkt *av.Packet, streams []av.CodecData, video
 	}
 	return
 }
+
+// Replace audio gap markers with G.711 silence of the same duration, drop
+// them for other codecs since the following packets keep their timestamps.
+type ConcealAudioGaps struct {
+}
+
+func (self *ConcealAudioGaps) ModifyPacket(pkt *av.Packet, streams []av.CodecData, videoidx int, audioidx int) (drop bool, err error) {
+	if !pkt.Flags.Has(av.PacketGap) {
+		return
+	}
+	codec, ok := streams[pkt.Idx].(av.AudioCodecData)
+	if !ok {
+		drop = true
+		return
+	}
+	var silence byte
+	switch codec.Type() {
+	case av.PCM_ALAW:
+		silence = 0xd5
+	case av.PCM_MULAW:
+		silence = 0xff
+	default:
+		drop = true
+		return
+	}
+	n := int(pkt.Duration*time.Duration(codec.SampleRate())/time.Second) * codec.ChannelLayout().Count()
+	pkt.Data = bytes.Repeat([]byte{silence}, n)
+	pkt.Flags &^= av.PacketGap
+	return
+}
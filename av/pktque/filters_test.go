@@ -0,0 +1,309 @@
+package pktque
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"github.com/deepch/vdk/av"
+	"github.com/deepch/vdk/codec/fake"
+	"github.com/deepch/vdk/codec/h264parser"
+	"github.com/deepch/vdk/codec/h265parser"
+)
+
+func TestDropBFramesPyramid(t *testing.T) {
+	const frame = 40 * time.Millisecond
+	// decode order of a B-pyramid GOP, B2 and B6 are reference B-frames
+	gop := []struct {
+		pts   int
+		flags av.PacketFlags
+	}{
+		{0, av.PacketReference},
+		{4, av.PacketReference},
+		{2, av.PacketReference},
+		{1, av.PacketDisposable},
+		{3, av.PacketDisposable},
+		{8, av.PacketReference},
+		{6, av.PacketReference},
+		{5, av.PacketDisposable},
+		{7, av.PacketDisposable},
+	}
+	filter := &DropBFrames{}
+	var times []time.Duration
+	for i, f := range gop {
+		pkt := &av.Packet{
+			IsKeyFrame:      i == 0,
+			Flags:           f.flags,
+			Time:            time.Duration(i) * frame,
+			CompositionTime: time.Duration(f.pts+2-i) * frame,
+		}
+		drop, err := filter.ModifyPacket(pkt, nil, 0, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if drop != (f.flags == av.PacketDisposable) {
+			t.Fatalf("frame %d: drop=%v", f.pts, drop)
+		}
+		if drop {
+			continue
+		}
+		if pkt.CompositionTime != 0 {
+			t.Fatalf("frame %d: composition time %v", f.pts, pkt.CompositionTime)
+		}
+		if len(times) > 0 && pkt.Time < times[len(times)-1] {
+			t.Fatalf("frame %d: time %v after %v", f.pts, pkt.Time, times[len(times)-1])
+		}
+		times = append(times, pkt.Time)
+	}
+	if len(times) != 5 {
+		t.Fatalf("kept %d frames, want 5", len(times))
+	}
+}
+
+func TestKeyFrameStartOtherCodecs(t *testing.T) {
+	vp9 := fake.CodecData{CodecType_: av.VP9}
+	aac := fake.CodecData{CodecType_: av.AAC}
+	frame := []byte{0x82, 0x49, 0x83, 0x42}
+
+	// VP9 waits for a key frame but is not framed as H.264
+	filter := &KeyFrameStart{AnnexB: true}
+	streams := []av.CodecData{vp9, aac}
+	for i, key := range []bool{false, true, false} {
+		pkt := &av.Packet{IsKeyFrame: key, Data: frame}
+		drop, err := filter.ModifyPacket(pkt, streams, 0, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if drop != (i == 0) || !bytes.Equal(pkt.Data, frame) {
+			t.Fatalf("vp9 packet %d: drop %v data %x", i, drop, pkt.Data)
+		}
+	}
+
+	// audio alone passes through from the first packet
+	filter = &KeyFrameStart{AnnexB: true}
+	streams = []av.CodecData{aac}
+	pkt := &av.Packet{Data: frame}
+	drop, err := filter.ModifyPacket(pkt, streams, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if drop || !bytes.Equal(pkt.Data, frame) {
+		t.Fatalf("aac: drop %v data %x", drop, pkt.Data)
+	}
+}
+
+func TestKeyFrameStartH264(t *testing.T) {
+	sps, _ := hex.DecodeString("6742001eda0507e8400000004000000ca36822116480")
+	pps := []byte{0x68, 0xce, 0x3c, 0x80}
+	codec, err := h264parser.NewCodecDataFromSPSAndPPS(sps, pps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	streams := []av.CodecData{codec}
+	idr := []byte{0x65, 0x88, 0x84}
+	slice := []byte{0x41, 0x9a, 0x02}
+	avcc := func(nalus ...[]byte) (b []byte) {
+		for _, nalu := range nalus {
+			b = h264parser.AppendNALU(b, nalu, h264parser.NALU_AVCC)
+		}
+		return
+	}
+	annexb := func(nalus ...[]byte) (b []byte) {
+		for _, nalu := range nalus {
+			b = append(append(b, 0, 0, 0, 1), nalu...)
+		}
+		return
+	}
+
+	sei := []byte{0x06, 0x05, 0x01, 0x00, 0x80}
+	// the codec data of a stream waiting for its parameter sets
+	waiting := []av.CodecData{h264parser.CodecData{}}
+
+	for _, test := range []struct {
+		name    string
+		filter  *KeyFrameStart
+		streams []av.CodecData // a stream with sps and pps if nil
+		in      [][]byte
+		want    [][]byte
+	}{
+		{"avcc", &KeyFrameStart{}, nil, [][]byte{avcc(slice), avcc(idr), avcc(slice)}, [][]byte{nil, avcc(sps, pps, idr), avcc(slice)}},
+		{"annexb", &KeyFrameStart{AnnexB: true}, nil, [][]byte{avcc(slice), avcc(idr), avcc(slice)}, [][]byte{nil, annexb(sps, pps, idr), annexb(slice)}},
+		{"sets in frame", &KeyFrameStart{}, nil, [][]byte{avcc(sps, pps, idr)}, [][]byte{avcc(sps, pps, idr)}},
+		{"sps in frame", &KeyFrameStart{}, nil, [][]byte{avcc(sps, idr)}, [][]byte{avcc(sps, pps, idr)}},
+		{"pps in frame", &KeyFrameStart{}, nil, [][]byte{avcc(pps, idr)}, [][]byte{avcc(sps, pps, idr)}},
+		{"sei first", &KeyFrameStart{}, nil, [][]byte{avcc(sei, pps, idr)}, [][]byte{avcc(sps, sei, pps, idr)}},
+		{"every key frame", &KeyFrameStart{EveryKeyFrame: true}, nil, [][]byte{avcc(idr), avcc(slice), avcc(sps, idr)}, [][]byte{avcc(sps, pps, idr), avcc(slice), avcc(sps, pps, idr)}},
+		{"first key frame", &KeyFrameStart{}, nil, [][]byte{avcc(idr), avcc(idr)}, [][]byte{avcc(sps, pps, idr), avcc(idr)}},
+		{"no sets yet", &KeyFrameStart{}, waiting, [][]byte{avcc(slice), avcc(idr)}, [][]byte{nil, avcc(idr)}},
+		{"no sets yet annexb", &KeyFrameStart{AnnexB: true}, waiting, [][]byte{avcc(idr)}, [][]byte{annexb(idr)}},
+	} {
+		streams := streams
+		if test.streams != nil {
+			streams = test.streams
+		}
+		for i, data := range test.in {
+			pkt := &av.Packet{IsKeyFrame: bytes.Contains(data, idr), Data: data}
+			drop, err := test.filter.ModifyPacket(pkt, streams, 0, -1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if drop != (test.want[i] == nil) || !drop && !bytes.Equal(pkt.Data, test.want[i]) {
+				t.Errorf("%s: packet %d: drop %v data %x", test.name, i, drop, pkt.Data)
+			}
+		}
+	}
+}
+
+func TestKeyFrameStartH265(t *testing.T) {
+	vps := []byte{0x40, 0x01, 0x0c}
+	sps := []byte{0x42, 0x01, 0x01}
+	pps := []byte{0x44, 0x01, 0xc1}
+	idr := []byte{0x26, 0x01, 0xaf}
+	codec := h265parser.CodecData{RecordInfo: h265parser.AVCDecoderConfRecord{VPS: [][]byte{vps}, SPS: [][]byte{sps}, PPS: [][]byte{pps}}}
+	annexb := func(nalus ...[]byte) (b []byte) {
+		for _, nalu := range nalus {
+			b = append(append(b, 0, 0, 0, 1), nalu...)
+		}
+		return
+	}
+	for _, test := range []struct {
+		name string
+		in   []byte
+		want []byte
+	}{
+		{"no sets", annexb(idr), annexb(vps, sps, pps, idr)},
+		{"all sets", annexb(vps, sps, pps, idr), annexb(vps, sps, pps, idr)},
+		{"sps only", annexb(sps, idr), annexb(vps, sps, pps, idr)},
+		{"vps and pps", annexb(vps, pps, idr), annexb(vps, sps, pps, idr)},
+	} {
+		pkt := &av.Packet{IsKeyFrame: true, Data: test.in}
+		filter := &KeyFrameStart{AnnexB: true}
+		if drop, err := filter.ModifyPacket(pkt, []av.CodecData{codec}, 0, -1); err != nil || drop {
+			t.Fatalf("%s: drop %v %v", test.name, drop, err)
+		}
+		if !bytes.Equal(pkt.Data, test.want) {
+			t.Errorf("%s: data %x, want %x", test.name, pkt.Data, test.want)
+		}
+	}
+}
+
+func TestBaseLayer(t *testing.T) {
+	sps, _ := hex.DecodeString("6742001eda0507e8400000004000000ca36822116480")
+	pps := []byte{0x68, 0xce, 0x3c, 0x80}
+	codec, err := h264parser.NewCodecDataFromSPSAndPPS(sps, pps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idr := []byte{0x65, 0x88, 0x84}
+	prefix := []byte{0x6e, 0xc0, 0x80, 0x0f}
+	subsetSPS := []byte{0x6f, 0x53, 0x00, 0x1e}
+	sliceExt := []byte{0x74, 0x80, 0x80, 0x0f, 1}
+	avcc := func(nalus ...[]byte) (b []byte) {
+		for _, nalu := range nalus {
+			b = h264parser.AppendNALU(b, nalu, h264parser.NALU_AVCC)
+		}
+		return
+	}
+	h264 := []av.CodecData{codec, fake.CodecData{CodecType_: av.AAC}}
+	h265 := []av.CodecData{fake.CodecData{CodecType_: av.H265}, fake.CodecData{CodecType_: av.AAC}}
+
+	for _, test := range []struct {
+		name    string
+		streams []av.CodecData
+		idx     int8
+		in      []byte
+		want    []byte // nil when dropped
+	}{
+		{"svc key frame", h264, 0, avcc(subsetSPS, prefix, idr, sliceExt), avcc(idr)},
+		{"enhancement only", h264, 0, avcc(prefix, sliceExt), nil},
+		{"avc frame", h264, 0, avcc(idr), avcc(idr)},
+		{"audio", h264, 1, []byte{0x74, 1, 2}, []byte{0x74, 1, 2}},
+		{"h265", h265, 0, []byte{0x74, 1, 2}, []byte{0x74, 1, 2}},
+	} {
+		pkt := &av.Packet{Idx: test.idx, Data: test.in}
+		drop, err := (&BaseLayer{}).ModifyPacket(pkt, test.streams, 0, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if drop != (test.want == nil) || !drop && !bytes.Equal(pkt.Data, test.want) {
+			t.Errorf("%s: drop %v data %x", test.name, drop, pkt.Data)
+		}
+	}
+}
+
+func TestRecoveryPoint(t *testing.T) {
+	sps, _ := hex.DecodeString("6742001eda0507e8400000004000000ca36822116480")
+	pps := []byte{0x68, 0xce, 0x3c, 0x80}
+	codec, err := h264parser.NewCodecDataFromSPSAndPPS(sps, pps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	streams := []av.CodecData{codec, fake.CodecData{CodecType_: av.AAC}}
+	avcc := func(nalus ...[]byte) (b []byte) {
+		for _, nalu := range nalus {
+			b = h264parser.AppendNALU(b, nalu, h264parser.NALU_AVCC)
+		}
+		return
+	}
+	recovery := func(payload ...byte) []byte {
+		return h264parser.BuildSEI([]h264parser.SEIMessage{{PayloadType: h264parser.SEI_RECOVERY_POINT, Payload: payload}})
+	}
+	idr := []byte{0x65, 0x88, 0x84}
+	slice := []byte{0x41, 0x9a, 0x02}
+	// an intra refresh stream with a late IDR frame
+	frames := []struct {
+		idx  int8
+		key  bool
+		data []byte
+	}{
+		{0, false, avcc(slice)},
+		{1, false, avcc(recovery(0xc4))},        // audio
+		{0, false, avcc(recovery(0xc4), slice)}, // 0 frames, exact
+		{0, false, avcc(slice)},
+		{0, false, avcc(recovery(0x0f, 0xa4), slice)}, // 30 frames, inexact
+		{0, true, avcc(idr)},
+		{0, false, avcc(recovery(0x22, 0x40), slice)}, // 3 frames, inexact
+	}
+
+	for _, test := range []struct {
+		name   string
+		filter *RecoveryPoint
+		keys   string // per packet
+		drops  string // with WaitKeyFrame after it
+	}{
+		{"any", &RecoveryPoint{}, "--k-kkk", "xxkkkkk"},
+		{"exact", &RecoveryPoint{ExactMatch: true}, "--k--k-", "xxkkkkk"},
+		{"max frames", &RecoveryPoint{MaxFrames: 10}, "--k--kk", "xxkkkkk"},
+		{"while no idr", &RecoveryPoint{WhileNoIDR: true}, "--k-kk-", "xxkkkkk"},
+	} {
+		var keys, drops string
+		filter := Filters{test.filter, &WaitKeyFrame{}}
+		for _, frame := range frames {
+			pkt := &av.Packet{Idx: frame.idx, IsKeyFrame: frame.key, Data: frame.data}
+			drop, err := filter.ModifyPacket(pkt, streams, 0, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if pkt.IsKeyFrame {
+				keys += "k"
+			} else {
+				keys += "-"
+			}
+			if drop {
+				drops += "x"
+			} else {
+				drops += "k"
+			}
+		}
+		if keys != test.keys || drops != test.drops {
+			t.Errorf("%s: key frames %s, kept %s, want %s and %s", test.name, keys, drops, test.keys, test.drops)
+		}
+	}
+
+	// other codecs are left alone
+	pkt := &av.Packet{Data: avcc(recovery(0xc4), slice)}
+	if _, err = (&RecoveryPoint{}).ModifyPacket(pkt, []av.CodecData{fake.CodecData{CodecType_: av.H265}}, 0, -1); err != nil || pkt.IsKeyFrame {
+		t.Errorf("h265 key frame %v %v", pkt.IsKeyFrame, err)
+	}
+}
@@ -0,0 +1,138 @@
+package pktque
+
+import (
+	"io"
+
+	"github.com/deepch/vdk/av"
+)
+
+// Hooks observe the packets crossing a HookDemuxer or HookMuxer, for
+// debugging, sampling or checksumming. Nil hooks are skipped.
+type Hooks struct {
+	OnPacketIn  func(pkt av.Packet) // packet as read from the demuxer or given to the muxer
+	OnPacketOut func(pkt av.Packet) // packet after Filter, not called for dropped ones
+	OnError     func(err error)     // any error but io.EOF
+	Filter      Filter              // optional, run between OnPacketIn and OnPacketOut
+	streams     []av.CodecData
+	videoidx    int
+	audioidx    int
+}
+
+func (self *Hooks) in(pkt av.Packet) {
+	if self.OnPacketIn != nil {
+		self.OnPacketIn(pkt)
+	}
+}
+
+func (self *Hooks) out(pkt av.Packet) {
+	if self.OnPacketOut != nil {
+		self.OnPacketOut(pkt)
+	}
+}
+
+func (self *Hooks) error(err error) error {
+	if err != nil && err != io.EOF && self.OnError != nil {
+		self.OnError(err)
+	}
+	return err
+}
+
+func (self *Hooks) filter(pkt *av.Packet) (drop bool, err error) {
+	if self.Filter == nil {
+		return
+	}
+	return self.Filter.ModifyPacket(pkt, self.streams, self.videoidx, self.audioidx)
+}
+
+func (self *Hooks) setStreams(streams []av.CodecData) {
+	self.streams = streams
+	for i, stream := range streams {
+		if stream.Type().IsVideo() {
+			self.videoidx = i
+		} else if stream.Type().IsAudio() {
+			self.audioidx = i
+		}
+	}
+}
+
+// Wrap a Demuxer and call Hooks on each packet read.
+type HookDemuxer struct {
+	av.Demuxer
+	Hooks
+}
+
+func (self *HookDemuxer) Streams() (streams []av.CodecData, err error) {
+	if streams, err = self.Demuxer.Streams(); err != nil {
+		self.error(err)
+		return
+	}
+	self.setStreams(streams)
+	return
+}
+
+func (self *HookDemuxer) ReadPacket() (pkt av.Packet, err error) {
+	if self.streams == nil && self.Filter != nil {
+		if _, err = self.Streams(); err != nil {
+			return
+		}
+	}
+	for {
+		if pkt, err = self.Demuxer.ReadPacket(); err != nil {
+			self.error(err)
+			return
+		}
+		self.in(pkt)
+		var drop bool
+		if drop, err = self.filter(&pkt); err != nil {
+			self.error(err)
+			return
+		}
+		if !drop {
+			break
+		}
+	}
+	self.out(pkt)
+	return
+}
+
+func (self *HookDemuxer) Close() (err error) {
+	if closer, ok := self.Demuxer.(io.Closer); ok {
+		err = self.error(closer.Close())
+	}
+	return
+}
+
+// Wrap a Muxer and call Hooks on each packet written.
+type HookMuxer struct {
+	av.Muxer
+	Hooks
+}
+
+func (self *HookMuxer) WriteHeader(streams []av.CodecData) (err error) {
+	self.setStreams(streams)
+	return self.error(self.Muxer.WriteHeader(streams))
+}
+
+func (self *HookMuxer) WritePacket(pkt av.Packet) (err error) {
+	self.in(pkt)
+	var drop bool
+	if drop, err = self.filter(&pkt); err != nil || drop {
+		return self.error(err)
+	}
+	if err = self.Muxer.WritePacket(pkt); err != nil {
+		return self.error(err)
+	}
+	self.out(pkt)
+	return
+}
+
+func (self *HookMuxer) WriteTrailer() (err error) {
+	return self.error(self.Muxer.WriteTrailer())
+}
+
+func (self *HookMuxer) Close() (err error) {
+	if closer, ok := self.Muxer.(io.Closer); ok {
+		err = self.error(closer.Close())
+	}
+	return
+}
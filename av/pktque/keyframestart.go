@@ -0,0 +1,137 @@
+package pktque
+
+import (
+	"github.com/deepch/vdk/av"
+	"github.com/deepch/vdk/codec/h264parser"
+	"github.com/deepch/vdk/codec/h265parser"
+)
+
+// Drop packets until the first video key frame and put the parameter sets
+// of the codec in front of it, so that a subscriber attaching to a live
+// stream starts with a frame it can decode alone. With AnnexB the video
+// packets are rewritten with start codes, for TS and raw H.264 or H.265
+// pipes. Parameter sets already in the frame are not repeated. Only H.264
+// and H.265 packets are rewritten, other video codecs only wait for a key
+// frame, and streams without video pass through.
+type KeyFrameStart struct {
+	AnnexB        bool
+	EveryKeyFrame bool // parameter sets in front of every key frame, not only the first
+	RandomAccess  bool // start at a random access point too
+
+	ok bool
+}
+
+func (self *KeyFrameStart) ModifyPacket(pkt *av.Packet, streams []av.CodecData, videoidx int, audioidx int) (drop bool, err error) {
+	if videoidx < 0 || videoidx >= len(streams) || !streams[videoidx].Type().IsVideo() {
+		return
+	}
+	if pkt.Idx != int8(videoidx) {
+		drop = !self.ok
+		return
+	}
+	start := !self.ok && (pkt.IsKeyFrame || self.RandomAccess && pkt.IsRandomAccess())
+	if !self.ok && !start {
+		drop = true
+		return
+	}
+	self.ok = true
+	if typ := streams[videoidx].Type(); typ != av.H264 && typ != av.H265 {
+		return
+	}
+
+	var sets [][]byte
+	var rank func(nalu []byte) int
+	if start || self.EveryKeyFrame && pkt.IsKeyFrame {
+		sets, rank = paramSets(streams[videoidx])
+	}
+	if len(sets) == 0 && !self.AnnexB {
+		return
+	}
+	var nalus [][]byte
+	r := h264parser.NewNALUReader(pkt.Data)
+	for nalu, ok := r.Next(); ok; nalu, ok = r.Next() {
+		nalus = append(nalus, nalu)
+	}
+	missing := 0
+	if len(sets) > 0 {
+		for _, nalu := range nalus {
+			if i := rank(nalu); i < len(sets) {
+				sets[i] = nil
+			}
+		}
+		for _, set := range sets {
+			if set != nil {
+				missing++
+			}
+		}
+	}
+	format := r.Format()
+	if missing == 0 && (!self.AnnexB || format == h264parser.NALU_ANNEXB) {
+		return
+	}
+	if self.AnnexB {
+		format = h264parser.NALU_ANNEXB
+	} else if format != h264parser.NALU_ANNEXB {
+		format = h264parser.NALU_AVCC
+	}
+	n := len(pkt.Data)
+	for _, set := range sets {
+		n += 4 + len(set)
+	}
+	// the missing sets go in decoding order, before the sets which follow
+	// them and the rest of the frame
+	b := make([]byte, 0, n+16)
+	next := 0
+	appendSets := func(upto int) {
+		for ; next < upto && next < len(sets); next++ {
+			if sets[next] != nil {
+				b = h264parser.AppendNALU(b, sets[next], format)
+			}
+		}
+	}
+	for _, nalu := range nalus {
+		if len(sets) > 0 {
+			appendSets(rank(nalu))
+		}
+		b = h264parser.AppendNALU(b, nalu, format)
+	}
+	appendSets(len(sets))
+	pkt.Data = b
+	return
+}
+
+// paramSets returns the parameter sets of an H.264 or H.265 stream in
+// decoding order, without the ones the codec data lacks, and the rank of a
+// NALU among them, len(sets) for a NALU which is none of them.
+func paramSets(stream av.CodecData) (sets [][]byte, rank func(nalu []byte) int) {
+	var types []int
+	var typ func(nalu []byte) int
+	add := func(set [][]byte, t int) {
+		if len(set) > 0 && len(set[0]) > 0 {
+			sets = append(sets, set[0])
+			types = append(types, t)
+		}
+	}
+	switch codec := stream.(type) {
+	case h264parser.CodecData:
+		add(codec.RecordInfo.SPS, h264parser.NALU_SPS)
+		add(codec.RecordInfo.PPS, h264parser.NALU_PPS)
+		typ = func(nalu []byte) int { return int(nalu[0] & 0x1f) }
+	case h265parser.CodecData:
+		add(codec.RecordInfo.VPS, h265parser.NAL_UNIT_VPS)
+		add(codec.RecordInfo.SPS, h265parser.NAL_UNIT_SPS)
+		add(codec.RecordInfo.PPS, h265parser.NAL_UNIT_PPS)
+		typ = func(nalu []byte) int { return int(nalu[0]>>1) & 0x3f }
+	}
+	rank = func(nalu []byte) int {
+		if len(nalu) > 0 {
+			for i, t := range types {
+				if typ(nalu) == t {
+					return i
+				}
+			}
+		}
+		return len(sets)
+	}
+	return
+}
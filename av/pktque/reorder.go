@@ -0,0 +1,117 @@
+package pktque
+
+import (
+	"time"
+
+	"github.com/deepch/vdk/av"
+)
+
+type ReorderOrder int
+
+const (
+	PresentationOrder ReorderOrder = iota // release by Time+CompositionTime
+	DecodeOrder                           // release by Time
+)
+
+// DefaultReorderDepth is used when the codec data does not tell, it is the
+// largest DPB H.264 and H.265 allow.
+const DefaultReorderDepth = 16
+
+// ReorderBuffer holds back up to Depth packets of one stream and releases
+// them sorted in Order.
+type ReorderBuffer struct {
+	Depth int
+	Order ReorderOrder
+	pkts  []av.Packet
+}
+
+// NewReorderBuffer sizes the buffer from the stream codec data.
+func NewReorderBuffer(codec av.CodecData, order ReorderOrder) *ReorderBuffer {
+	depth := DefaultReorderDepth
+	if rc, ok := codec.(av.ReorderCodecData); ok {
+		depth = rc.ReorderDepth()
+	}
+	return &ReorderBuffer{Depth: depth, Order: order}
+}
+
+func (self *ReorderBuffer) key(pkt av.Packet) time.Duration {
+	if self.Order == PresentationOrder {
+		return pkt.Time + pkt.CompositionTime
+	}
+	return pkt.Time
+}
+
+// Push adds pkt and returns the packets which can no longer be preceded by
+// a later one.
+func (self *ReorderBuffer) Push(pkt av.Packet) (out []av.Packet) {
+	i := len(self.pkts)
+	for i > 0 && self.key(self.pkts[i-1]) > self.key(pkt) {
+		i--
+	}
+	self.pkts = append(self.pkts, av.Packet{})
+	copy(self.pkts[i+1:], self.pkts[i:])
+	self.pkts[i] = pkt
+	if n := len(self.pkts) - self.Depth; n > 0 {
+		out = append(out, self.pkts[:n]...)
+		self.pkts = append(self.pkts[:0], self.pkts[n:]...)
+	}
+	return
+}
+
+// Flush returns all the buffered packets, at end of stream or before a
+// discontinuity.
+func (self *ReorderBuffer) Flush() (out []av.Packet) {
+	out = append(out, self.pkts...)
+	self.pkts = self.pkts[:0]
+	return
+}
+
+// Wrap a Demuxer so video packets come out in Order, other packets pass
+// through unchanged.
+type ReorderDemuxer struct {
+	av.Demuxer
+	Order ReorderOrder
+	bufs  []*ReorderBuffer
+	out   []av.Packet
+	err   error
+}
+
+func (self *ReorderDemuxer) ReadPacket() (pkt av.Packet, err error) {
+	if self.bufs == nil {
+		var streams []av.CodecData
+		if streams, err = self.Demuxer.Streams(); err != nil {
+			return
+		}
+		self.bufs = make([]*ReorderBuffer, len(streams))
+		for i, stream := range streams {
+			if stream.Type().IsVideo() {
+				self.bufs[i] = NewReorderBuffer(stream, self.Order)
+			}
+		}
+	}
+
+	for len(self.out) == 0 {
+		if self.err != nil {
+			err = self.err
+			return
+		}
+		if pkt, err = self.Demuxer.ReadPacket(); err != nil {
+			self.err = err
+			err = nil
+			for _, buf := range self.bufs {
+				if buf != nil {
+					self.out = append(self.out, buf.Flush()...)
+				}
+			}
+			continue
+		}
+		if int(pkt.Idx) >= len(self.bufs) || self.bufs[pkt.Idx] == nil {
+			return
+		}
+		self.out = self.bufs[pkt.Idx].Push(pkt)
+	}
+
+	pkt = self.out[0]
+	self.out = self.out[1:]
+	return
+}
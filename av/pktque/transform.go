@@ -0,0 +1,75 @@
+package pktque
+
+import (
+	"time"
+
+	"github.com/deepch/vdk/av"
+)
+
+// FilterFunc makes a Filter of a func, as http.HandlerFunc does.
+type FilterFunc func(pkt *av.Packet, streams []av.CodecData, videoidx int, audioidx int) (drop bool, err error)
+
+func (self FilterFunc) ModifyPacket(pkt *av.Packet, streams []av.CodecData, videoidx int, audioidx int) (drop bool, err error) {
+	return self(pkt, streams, videoidx, audioidx)
+}
+
+// Transform is a packet policy made of callbacks, for the custom rules
+// not worth a Filter type of their own. Packets Match selects are dropped
+// if DropIf returns true, otherwise retimed, tagged and modified in this
+// order. Nil callbacks are skipped.
+//
+//	// drop the audio of the first 2 seconds, shift the video by one
+//	filters := pktque.Filters{
+//		&pktque.Transform{Match: pktque.MatchAudio, DropIf: func(pkt av.Packet) bool { return pkt.Time < 2*time.Second }},
+//		&pktque.Transform{Match: pktque.MatchVideo, Retime: func(pkt av.Packet) time.Duration { return pkt.Time + time.Second }},
+//	}
+type Transform struct {
+	Match  func(pkt av.Packet, stream av.CodecData) bool // all packets if nil
+	DropIf func(pkt av.Packet) bool
+	Retime func(pkt av.Packet) time.Duration  // returns the new Time
+	Tag    func(pkt av.Packet) av.PacketFlags // returns flags to add
+	Modify func(pkt *av.Packet) error
+}
+
+func (self *Transform) ModifyPacket(pkt *av.Packet, streams []av.CodecData, videoidx int, audioidx int) (drop bool, err error) {
+	if self.Match != nil {
+		var stream av.CodecData
+		if int(pkt.Idx) < len(streams) {
+			stream = streams[pkt.Idx]
+		}
+		if !self.Match(*pkt, stream) {
+			return
+		}
+	}
+	if self.DropIf != nil && self.DropIf(*pkt) {
+		drop = true
+		return
+	}
+	if self.Retime != nil {
+		pkt.Time = self.Retime(*pkt)
+	}
+	if self.Tag != nil {
+		pkt.Flags |= self.Tag(*pkt)
+	}
+	if self.Modify != nil {
+		err = self.Modify(pkt)
+	}
+	return
+}
+
+// MatchVideo selects the packets of video streams, for Transform.Match.
+func MatchVideo(pkt av.Packet, stream av.CodecData) bool {
+	return stream != nil && stream.Type().IsVideo()
+}
+
+// MatchAudio selects the packets of audio streams, for Transform.Match.
+func MatchAudio(pkt av.Packet, stream av.CodecData) bool {
+	return stream != nil && stream.Type().IsAudio()
+}
+
+// MatchStream selects the packets of stream idx, for Transform.Match.
+func MatchStream(idx int) func(pkt av.Packet, stream av.CodecData) bool {
+	return func(pkt av.Packet, stream av.CodecData) bool {
+		return int(pkt.Idx) == idx
+	}
+}
@@ -30,6 +30,11 @@ type Queue struct {
 	streams                  []av.CodecData
 	videoidx                 int
 	closed                   bool
+
+	// RandomAccess makes GOPs start at random access points too, such as
+	// recovery point SEI or H.265 CRA frames, not only at key frames.
+	// Set it before the first WritePacket.
+	RandomAccess bool
 }
 
 func NewQueue() *Queue {
@@ -85,13 +90,13 @@ func (self *Queue) WritePacket(pkt av.Packet) (err error) {
 	self.lock.Lock()
 
 	self.buf.Push(pkt)
-	if pkt.Idx == int8(self.videoidx) && pkt.IsKeyFrame {
+	if self.isGopStart(pkt) {
 		self.curgopcount++
 	}
 
 	for self.curgopcount >= self.maxgopcount && self.buf.Count > 1 {
 		pkt := self.buf.Pop()
-		if pkt.Idx == int8(self.videoidx) && pkt.IsKeyFrame {
+		if self.isGopStart(pkt) {
 			self.curgopcount--
 		}
 		if self.curgopcount < self.maxgopcount {
@@ -106,6 +111,10 @@ func (self *Queue) WritePacket(pkt av.Packet) (err error) {
 	return
 }
 
+func (self *Queue) isGopStart(pkt av.Packet) bool {
+	return pkt.Idx == int8(self.videoidx) && (pkt.IsKeyFrame || self.RandomAccess && pkt.IsRandomAccess())
+}
+
 type QueueCursor struct {
 	que    *Queue
 	pos    pktque.BufPos
@@ -164,7 +173,7 @@ func (self *Queue) DelayedGopCount(n int) *QueueCursor {
 		if videoidx != -1 {
 			for gop := 0; buf.IsValidPos(i) && gop < n; i-- {
 				pkt := buf.Get(i)
-				if pkt.Idx == int8(self.videoidx) && pkt.IsKeyFrame {
+				if self.isGopStart(pkt) {
 					gop++
 				}
 			}
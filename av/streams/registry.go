@@ -0,0 +1,250 @@
+// Package streams maps string keys such as "tenant/camera" to pubsub queues,
+// the registry every server built on pubsub needs.
+package streams
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/deepch/vdk/av/pubsub"
+)
+
+var ErrPublishing = fmt.Errorf("streams: key already has a publisher")
+
+const DefaultIdleTimeout = time.Minute
+
+// Info describes a registered stream.
+type Info struct {
+	Key         string
+	Publishing  bool
+	Subscribers int
+	Created     time.Time
+	Idle        time.Duration // since the last publisher or subscriber left, zero while in use
+}
+
+type entry struct {
+	queue       *pubsub.Queue
+	publishing  bool
+	subscribers int
+	created     time.Time
+	released    time.Time
+}
+
+func (self *entry) inUse() bool {
+	return self.publishing || self.subscribers > 0
+}
+
+// Registry creates queues on first use and closes them once nobody has
+// used them for IdleTimeout.
+type Registry struct {
+	IdleTimeout time.Duration // DefaultIdleTimeout if zero, negative keeps idle queues
+
+	// OnAccess is called before publishing or subscribing, an error denies it.
+	OnAccess func(key string, publish bool) error
+	// OnCreate and OnRemove are called without the lock held, they may use
+	// the registry.
+	OnCreate func(key string, queue *pubsub.Queue)
+	OnRemove func(key string, queue *pubsub.Queue)
+
+	lock    sync.Mutex
+	entries map[string]*entry
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (self *Registry) idleTimeout() time.Duration {
+	if self.IdleTimeout != 0 {
+		return self.IdleTimeout
+	}
+	return DefaultIdleTimeout
+}
+
+// get returns the entry for key, creating it, with the lock held. A created
+// entry is passed to create once the lock is released.
+func (self *Registry) get(key string) (e *entry, created bool) {
+	if self.entries == nil {
+		self.entries = map[string]*entry{}
+	}
+	if e = self.entries[key]; e == nil {
+		e = &entry{queue: pubsub.NewQueue(), created: time.Now()}
+		e.released = e.created
+		self.entries[key] = e
+		created = true
+	}
+	return
+}
+
+func (self *Registry) create(key string, e *entry) {
+	if self.OnCreate != nil {
+		self.OnCreate(key, e.queue)
+	}
+}
+
+// Publish returns the queue to write key into, release must be called once
+// the publisher is gone. The queue stays open for the subscribers until it
+// is collected or removed.
+func (self *Registry) Publish(key string) (queue *pubsub.Queue, release func(), err error) {
+	if self.OnAccess != nil {
+		if err = self.OnAccess(key, true); err != nil {
+			return
+		}
+	}
+	self.lock.Lock()
+	e, created := self.get(key)
+	if e.publishing {
+		self.lock.Unlock()
+		err = ErrPublishing
+		return
+	}
+	e.publishing = true
+	self.lock.Unlock()
+	if created {
+		self.create(key, e)
+	}
+	queue = e.queue
+	var once sync.Once
+	release = func() {
+		once.Do(func() {
+			self.lock.Lock()
+			e.publishing = false
+			e.released = time.Now()
+			self.lock.Unlock()
+		})
+	}
+	return
+}
+
+// Subscribe returns a cursor on the latest packets of key, the stream is
+// created if it has no publisher yet and the cursor waits for it.
+func (self *Registry) Subscribe(key string) (cursor *pubsub.QueueCursor, release func(), err error) {
+	if self.OnAccess != nil {
+		if err = self.OnAccess(key, false); err != nil {
+			return
+		}
+	}
+	self.lock.Lock()
+	e, created := self.get(key)
+	e.subscribers++
+	self.lock.Unlock()
+	if created {
+		self.create(key, e)
+	}
+	cursor = e.queue.Latest()
+	var once sync.Once
+	release = func() {
+		once.Do(func() {
+			self.lock.Lock()
+			e.subscribers--
+			if !e.inUse() {
+				e.released = time.Now()
+			}
+			self.lock.Unlock()
+		})
+	}
+	return
+}
+
+// Get returns the queue of key without creating it.
+func (self *Registry) Get(key string) (queue *pubsub.Queue, ok bool) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if e := self.entries[key]; e != nil {
+		return e.queue, true
+	}
+	return
+}
+
+// List enumerates the streams whose key starts with prefix, sorted by key.
+func (self *Registry) List(prefix string) (infos []Info) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	now := time.Now()
+	for key, e := range self.entries {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		info := Info{
+			Key:         key,
+			Publishing:  e.publishing,
+			Subscribers: e.subscribers,
+			Created:     e.created,
+		}
+		if !e.inUse() {
+			info.Idle = now.Sub(e.released)
+		}
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Key < infos[j].Key
+	})
+	return
+}
+
+// Remove closes the queue of key, its cursors return io.EOF.
+func (self *Registry) Remove(key string) {
+	self.lock.Lock()
+	e := self.entries[key]
+	delete(self.entries, key)
+	self.lock.Unlock()
+	if e != nil {
+		self.close(key, e)
+	}
+}
+
+func (self *Registry) close(key string, e *entry) {
+	e.queue.Close()
+	if self.OnRemove != nil {
+		self.OnRemove(key, e.queue)
+	}
+}
+
+// Collect removes the streams idle for longer than IdleTimeout, it returns
+// their keys.
+func (self *Registry) Collect() (keys []string) {
+	timeout := self.idleTimeout()
+	if timeout < 0 {
+		return
+	}
+	now := time.Now()
+	removed := map[string]*entry{}
+	self.lock.Lock()
+	for key, e := range self.entries {
+		if !e.inUse() && now.Sub(e.released) >= timeout {
+			removed[key] = e
+			delete(self.entries, key)
+		}
+	}
+	self.lock.Unlock()
+	for key, e := range removed {
+		self.close(key, e)
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return
+}
+
+// StartCollector runs Collect every interval until stop is called.
+func (self *Registry) StartCollector(interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				self.Collect()
+			case <-done:
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() { close(done) })
+	}
+}
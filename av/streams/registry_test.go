@@ -0,0 +1,192 @@
+package streams
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/deepch/vdk/av/pubsub"
+)
+
+func TestPublishSubscribe(t *testing.T) {
+	registry := NewRegistry()
+	var created []string
+	registry.OnCreate = func(key string, queue *pubsub.Queue) {
+		created = append(created, key)
+	}
+
+	if _, ok := registry.Get("cam"); ok {
+		t.Fatal("got a stream never created")
+	}
+	cursor, unsubscribe, err := registry.Subscribe("cam")
+	if err != nil || cursor == nil {
+		t.Fatal(err)
+	}
+	queue, unpublish, err := registry.Publish("cam")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := registry.Get("cam"); !ok || got != queue {
+		t.Error("publisher and subscriber on different queues")
+	}
+	if _, _, err = registry.Publish("cam"); err != ErrPublishing {
+		t.Errorf("second publisher: %v", err)
+	}
+	unpublish()
+	unpublish()
+	if _, unpublish, err = registry.Publish("cam"); err != nil {
+		t.Errorf("publish after release: %v", err)
+	}
+	unpublish()
+	if len(created) != 1 || created[0] != "cam" {
+		t.Errorf("created %v", created)
+	}
+
+	infos := registry.List("")
+	if len(infos) != 1 || infos[0].Publishing || infos[0].Subscribers != 1 || infos[0].Idle != 0 {
+		t.Errorf("subscribed: %+v", infos)
+	}
+	// releasing twice does not count twice
+	unsubscribe()
+	unsubscribe()
+	if infos = registry.List(""); infos[0].Subscribers != 0 || infos[0].Idle <= 0 {
+		t.Errorf("released: %+v", infos)
+	}
+}
+
+func TestAccess(t *testing.T) {
+	registry := NewRegistry()
+	denied := fmt.Errorf("denied")
+	registry.OnAccess = func(key string, publish bool) error {
+		if publish != (key == "pub") {
+			return denied
+		}
+		return nil
+	}
+	if _, _, err := registry.Publish("sub"); err != denied {
+		t.Errorf("publish: %v", err)
+	}
+	if _, _, err := registry.Subscribe("pub"); err != denied {
+		t.Errorf("subscribe: %v", err)
+	}
+	if infos := registry.List(""); len(infos) != 0 {
+		t.Errorf("denied streams created: %+v", infos)
+	}
+	if _, _, err := registry.Publish("pub"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestList(t *testing.T) {
+	registry := NewRegistry()
+	for _, key := range []string{"b/2", "a/1", "b/1", "c"} {
+		registry.Publish(key)
+	}
+	for _, test := range []struct {
+		prefix string
+		keys   []string
+	}{
+		{"", []string{"a/1", "b/1", "b/2", "c"}},
+		{"b/", []string{"b/1", "b/2"}},
+		{"d", nil},
+	} {
+		infos := registry.List(test.prefix)
+		var keys []string
+		for _, info := range infos {
+			keys = append(keys, info.Key)
+		}
+		if fmt.Sprint(keys) != fmt.Sprint(test.keys) {
+			t.Errorf("%q: %v", test.prefix, keys)
+		}
+	}
+}
+
+func TestRemove(t *testing.T) {
+	registry := NewRegistry()
+	var removed []string
+	registry.OnRemove = func(key string, queue *pubsub.Queue) {
+		removed = append(removed, key)
+	}
+	cursor, _, _ := registry.Subscribe("cam")
+	registry.Remove("cam")
+	registry.Remove("none")
+	if _, err := cursor.ReadPacket(); err != io.EOF {
+		t.Errorf("cursor of a removed stream: %v", err)
+	}
+	if _, ok := registry.Get("cam"); ok {
+		t.Error("removed stream still registered")
+	}
+	if len(removed) != 1 || removed[0] != "cam" {
+		t.Errorf("removed %v", removed)
+	}
+	// the key is free for a new stream
+	queue, _, err := registry.Publish("cam")
+	if err != nil || queue == nil {
+		t.Error(err)
+	}
+}
+
+func TestCollect(t *testing.T) {
+	for _, test := range []struct {
+		timeout time.Duration
+		want    []string
+	}{
+		{time.Nanosecond, []string{"idle", "released"}},
+		{time.Hour, nil},
+		{-1, nil},
+	} {
+		registry := &Registry{IdleTimeout: test.timeout}
+		// created by a subscriber released at once
+		_, release, _ := registry.Subscribe("idle")
+		release()
+		_, release, _ = registry.Publish("released")
+		release()
+		registry.Publish("publishing")
+		registry.Subscribe("subscribed")
+		time.Sleep(time.Millisecond)
+		if keys := registry.Collect(); fmt.Sprint(keys) != fmt.Sprint(test.want) {
+			t.Errorf("timeout %v: collected %v", test.timeout, keys)
+		}
+	}
+}
+
+func TestConcurrentCreate(t *testing.T) {
+	registry := NewRegistry()
+	var lock sync.Mutex
+	created := map[string]int{}
+	registry.OnCreate = func(key string, queue *pubsub.Queue) {
+		// the lock is not held, the registry can be used
+		if got, ok := registry.Get(key); !ok || got != queue {
+			t.Errorf("%s: queue not registered", key)
+		}
+		lock.Lock()
+		created[key]++
+		lock.Unlock()
+	}
+	queues := make([]*pubsub.Queue, 20)
+	var wg sync.WaitGroup
+	for i := range queues {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			key := fmt.Sprint("cam", i%2)
+			if i < 2 {
+				queues[i], _, _ = registry.Publish(key)
+			} else {
+				registry.Subscribe(key)
+				queues[i], _ = registry.Get(key)
+			}
+		}(i)
+	}
+	wg.Wait()
+	for i, queue := range queues {
+		if queue != queues[i%2] {
+			t.Errorf("subscriber %d on another queue", i)
+		}
+	}
+	if len(created) != 2 || created["cam0"] != 1 || created["cam1"] != 1 {
+		t.Errorf("created %v", created)
+	}
+}
@@ -17,6 +17,7 @@ type tStream struct {
 	aencodec, adecodec av.AudioCodecData
 	aenc               av.AudioEncoder
 	adec               av.AudioDecoder
+	ares               av.AudioResampler
 }
 
 type Options struct {
@@ -24,6 +25,8 @@ type Options struct {
 	FindAudioDecoderEncoder func(codec av.AudioCodecData, i int) (
 		need bool, dec av.AudioDecoder, enc av.AudioEncoder, err error,
 	)
+	// optional, convert the decoded frames before encoding, e.g. a channel downmix.
+	FindAudioResampler func(codec av.AudioCodecData, i int) (av.AudioResampler, error)
 }
 
 type Transcoder struct {
@@ -54,6 +57,11 @@ func NewTranscoder(streams []av.CodecData, options Options) (_self *Transcoder,
 					ts.adecodec = stream.(av.AudioCodecData)
 					ts.aenc = enc
 					ts.adec = dec
+					if options.FindAudioResampler != nil {
+						if ts.ares, err = options.FindAudioResampler(ts.adecodec, i); err != nil {
+							return
+						}
+					}
 				}
 			}
 		}
@@ -85,6 +93,12 @@ func (self *tStream) audioDecodeAndEncode(inpkt av.Packet) (outpkts []av.Packet,
 	}
 	self.timeline.Push(inpkt.Time, dur)
 
+	if self.ares != nil {
+		if frame, err = self.ares.Resample(frame); err != nil {
+			return
+		}
+	}
+
 	var _outpkts [][]byte
 	if _outpkts, err = self.aenc.Encode(frame); err != nil {
 		return
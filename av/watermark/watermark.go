@@ -0,0 +1,131 @@
+// Package watermark marks H.264/H.265 packets with an ID carried in a SEI
+// user_data_unregistered message, so exports can be traced without
+// re-encoding.
+package watermark
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/deepch/vdk/av"
+	"github.com/deepch/vdk/codec/h264parser"
+	"github.com/deepch/vdk/codec/h265parser"
+	"github.com/deepch/vdk/utils/bits/pio"
+)
+
+// UUID identifies the watermark among other user data SEI.
+var UUID = [16]byte{'v', 'd', 'k', '-', 'w', 'a', 't', 'e', 'r', 'm', 'a', 'r', 'k', '-', '0', '1'}
+
+// Embed is a pktque.Filter which adds ID to the video keyframes, or to every
+// video frame when EveryFrame is set.
+type Embed struct {
+	ID         []byte
+	EveryFrame bool
+}
+
+func (self *Embed) ModifyPacket(pkt *av.Packet, streams []av.CodecData, videoidx int, audioidx int) (drop bool, err error) {
+	if int(pkt.Idx) >= len(streams) {
+		return
+	}
+	typ := streams[pkt.Idx].Type()
+	if typ != av.H264 && typ != av.H265 {
+		return
+	}
+	if !pkt.IsKeyFrame && !self.EveryFrame {
+		return
+	}
+	pkt.Data, err = Mark(pkt.Data, typ, self.ID)
+	return
+}
+
+func isVCL(typ av.CodecType, nalu []byte) bool {
+	if typ == av.H265 {
+		return (nalu[0]>>1)&0x3f < 32
+	}
+	return h264parser.IsDataNALU(nalu)
+}
+
+func isSEI(typ av.CodecType, nalu []byte) bool {
+	if typ == av.H265 {
+		t := (nalu[0] >> 1) & 0x3f
+		return t == h265parser.NAL_UNIT_PREFIX_SEI || t == h265parser.NAL_UNIT_SUFFIX_SEI
+	}
+	return nalu[0]&0x1f == h264parser.NALU_SEI
+}
+
+// Mark returns a copy of an AVCC or Annex B access unit with the ID SEI
+// inserted before the first slice.
+func Mark(data []byte, typ av.CodecType, id []byte) (out []byte, err error) {
+	msg := h264parser.SEIMessage{
+		PayloadType: h264parser.SEI_USER_DATA_UNREGISTERED,
+		Payload:     append(UUID[:], id...),
+	}
+	var sei []byte
+	switch typ {
+	case av.H264:
+		sei = h264parser.BuildSEI([]h264parser.SEIMessage{msg})
+	case av.H265:
+		sei = h265parser.BuildSEI([]h264parser.SEIMessage{msg})
+	default:
+		err = fmt.Errorf("watermark: codec type=%v is not supported", typ)
+		return
+	}
+
+	nalus, framing := h264parser.SplitNALUs(data)
+	if framing == h264parser.NALU_RAW {
+		err = fmt.Errorf("watermark: packet has no NALU framing")
+		return
+	}
+	out = make([]byte, 0, len(data)+len(sei)+4*(len(nalus)+1))
+	inserted := false
+	for _, nalu := range nalus {
+		if len(nalu) == 0 {
+			continue
+		}
+		if !inserted && isVCL(typ, nalu) {
+			out = appendNALU(out, framing, sei)
+			inserted = true
+		}
+		out = appendNALU(out, framing, nalu)
+	}
+	if !inserted {
+		out = appendNALU(out, framing, sei)
+	}
+	return
+}
+
+func appendNALU(b []byte, framing int, nalu []byte) []byte {
+	var hdr [4]byte
+	if framing == h264parser.NALU_AVCC {
+		pio.PutU32BE(hdr[:], uint32(len(nalu)))
+	} else {
+		pio.PutU32BE(hdr[:], 1)
+	}
+	return append(append(b, hdr[:]...), nalu...)
+}
+
+// Detect returns the ID embedded by Mark in an access unit.
+func Detect(data []byte, typ av.CodecType) (id []byte, ok bool) {
+	nalus, _ := h264parser.SplitNALUs(data)
+	for _, nalu := range nalus {
+		if len(nalu) < 2 || !isSEI(typ, nalu) {
+			continue
+		}
+		var msgs []h264parser.SEIMessage
+		var err error
+		if typ == av.H265 {
+			msgs, err = h265parser.ParseSEI(nalu)
+		} else {
+			msgs, err = h264parser.ParseSEI(nalu)
+		}
+		if err != nil {
+			continue
+		}
+		for _, msg := range msgs {
+			if msg.PayloadType == h264parser.SEI_USER_DATA_UNREGISTERED && bytes.HasPrefix(msg.Payload, UUID[:]) {
+				return msg.Payload[len(UUID):], true
+			}
+		}
+	}
+	return
+}
@@ -0,0 +1,6 @@
+// Package fdkaac is an AAC-LC av.AudioEncoder using libfdk-aac.
+//
+// It is only built with the fdkaac build tag:
+//
+//	go build -tags fdkaac
+package fdkaac
@@ -0,0 +1,327 @@
+//go:build fdkaac
+// +build fdkaac
+
+package fdkaac
+
+/*
+#cgo LDFLAGS: -lfdk-aac
+#include <fdk-aac/aacenc_lib.h>
+
+static AACENC_ERROR encode(HANDLE_AACENCODER h, void *in, int insize, int nsamples, void *out, int outsize, int *outbytes) {
+	AACENC_BufDesc inbuf = {0}, outbuf = {0};
+	AACENC_InArgs inargs = {0};
+	AACENC_OutArgs outargs = {0};
+	int inid = IN_AUDIO_DATA, inelsize = 2;
+	int outid = OUT_BITSTREAM_DATA, outelsize = 1;
+	AACENC_ERROR err;
+
+	inbuf.numBufs = 1;
+	inbuf.bufs = &in;
+	inbuf.bufferIdentifiers = &inid;
+	inbuf.bufSizes = &insize;
+	inbuf.bufElSizes = &inelsize;
+	outbuf.numBufs = 1;
+	outbuf.bufs = &out;
+	outbuf.bufferIdentifiers = &outid;
+	outbuf.bufSizes = &outsize;
+	outbuf.bufElSizes = &outelsize;
+	inargs.numInSamples = nsamples;
+
+	err = aacEncEncode(h, &inbuf, &outbuf, &inargs, &outargs);
+	*outbytes = outargs.numOutBytes;
+	return err;
+}
+
+// drain gives the encoder no input, it returns a delayed frame at each call
+// then AACENC_ENCODE_EOF.
+static AACENC_ERROR drain(HANDLE_AACENCODER h, void *out, int outsize, int *outbytes) {
+	AACENC_BufDesc inbuf = {0}, outbuf = {0};
+	AACENC_InArgs inargs = {0};
+	AACENC_OutArgs outargs = {0};
+	int outid = OUT_BITSTREAM_DATA, outelsize = 1;
+	AACENC_ERROR err;
+
+	outbuf.numBufs = 1;
+	outbuf.bufs = &out;
+	outbuf.bufferIdentifiers = &outid;
+	outbuf.bufSizes = &outsize;
+	outbuf.bufElSizes = &outelsize;
+	inargs.numInSamples = -1;
+
+	err = aacEncEncode(h, &inbuf, &outbuf, &inargs, &outargs);
+	*outbytes = outargs.numOutBytes;
+	return err;
+}
+*/
+import "C"
+import (
+	"fmt"
+	"unsafe"
+
+	"github.com/deepch/vdk/av"
+	"github.com/deepch/vdk/codec/aacparser"
+)
+
+// AudioEncoder encodes S16 (interleaved or planar) frames into raw AAC-LC
+// access units, the AudioSpecificConfig is in CodecData.
+type AudioEncoder struct {
+	SampleRate    int
+	ChannelLayout av.ChannelLayout
+	Bitrate       int
+	Afterburner   bool
+
+	handle    C.HANDLE_AACENCODER
+	codecData aacparser.CodecData
+	frameSize int
+	pcmbuf    []byte
+	outbuf    []byte
+	setupDone bool
+}
+
+func NewAudioEncoder() *AudioEncoder {
+	return &AudioEncoder{Afterburner: true}
+}
+
+func (self *AudioEncoder) SetSampleRate(rate int) (err error) {
+	self.SampleRate = rate
+	return
+}
+
+func (self *AudioEncoder) SetChannelLayout(ch av.ChannelLayout) (err error) {
+	self.ChannelLayout = ch
+	return
+}
+
+func (self *AudioEncoder) SetSampleFormat(sampleFormat av.SampleFormat) (err error) {
+	if sampleFormat != av.S16 && sampleFormat != av.S16P {
+		err = fmt.Errorf("fdkaac: sample format %s not supported", sampleFormat)
+	}
+	return
+}
+
+func (self *AudioEncoder) SetBitrate(bitrate int) (err error) {
+	self.Bitrate = bitrate
+	return
+}
+
+func (self *AudioEncoder) SetOption(key string, val interface{}) (err error) {
+	switch key {
+	case "afterburner":
+		b, ok := val.(bool)
+		if !ok {
+			err = fmt.Errorf("fdkaac: afterburner must be bool")
+			return
+		}
+		self.Afterburner = b
+	default:
+		err = fmt.Errorf("fdkaac: option `%s` not supported", key)
+	}
+	return
+}
+
+func (self *AudioEncoder) GetOption(key string, val interface{}) (err error) {
+	switch key {
+	case "afterburner":
+		p, ok := val.(*bool)
+		if !ok {
+			err = fmt.Errorf("fdkaac: afterburner receiver must be *bool")
+			return
+		}
+		*p = self.Afterburner
+	default:
+		err = fmt.Errorf("fdkaac: option `%s` not supported", key)
+	}
+	return
+}
+
+func (self *AudioEncoder) setParam(param C.AACENC_PARAM, v int) (err error) {
+	if e := C.aacEncoder_SetParam(self.handle, param, C.UINT(v)); e != C.AACENC_OK {
+		err = fmt.Errorf("fdkaac: set param %d=%d failed: %d", param, v, e)
+	}
+	return
+}
+
+func (self *AudioEncoder) Setup() (err error) {
+	if self.SampleRate == 0 {
+		self.SampleRate = 44100
+	}
+	if self.ChannelLayout == av.ChannelLayout(0) {
+		self.ChannelLayout = av.CH_STEREO
+	}
+	channels := self.ChannelLayout.Count()
+	if channels > 2 {
+		err = fmt.Errorf("fdkaac: %s layout not supported", self.ChannelLayout)
+		return
+	}
+	if self.Bitrate == 0 {
+		self.Bitrate = 64000 * channels
+	}
+
+	if e := C.aacEncOpen(&self.handle, 0, C.UINT(channels)); e != C.AACENC_OK {
+		err = fmt.Errorf("fdkaac: aacEncOpen failed: %d", e)
+		return
+	}
+	defer func() {
+		if err != nil {
+			C.aacEncClose(&self.handle)
+			self.handle = nil
+		}
+	}()
+	afterburner := 0
+	if self.Afterburner {
+		afterburner = 1
+	}
+	for _, p := range []struct {
+		param C.AACENC_PARAM
+		v     int
+	}{
+		{C.AACENC_AOT, 2},
+		{C.AACENC_SAMPLERATE, self.SampleRate},
+		{C.AACENC_CHANNELMODE, channels},
+		{C.AACENC_CHANNELORDER, 1},
+		{C.AACENC_BITRATE, self.Bitrate},
+		{C.AACENC_TRANSMUX, 0},
+		{C.AACENC_AFTERBURNER, afterburner},
+	} {
+		if err = self.setParam(p.param, p.v); err != nil {
+			return
+		}
+	}
+	if e := C.aacEncEncode(self.handle, nil, nil, nil, nil); e != C.AACENC_OK {
+		err = fmt.Errorf("fdkaac: encoder init failed: %d", e)
+		return
+	}
+	var info C.AACENC_InfoStruct
+	if e := C.aacEncInfo(self.handle, &info); e != C.AACENC_OK {
+		err = fmt.Errorf("fdkaac: aacEncInfo failed: %d", e)
+		return
+	}
+	config := C.GoBytes(unsafe.Pointer(&info.confBuf[0]), C.int(info.confSize))
+	if self.codecData, err = aacparser.NewCodecDataFromMPEG4AudioConfigBytes(config); err != nil {
+		return
+	}
+	self.codecData.Priming = int(info.nDelay)
+	self.frameSize = int(info.frameLength)
+	self.outbuf = make([]byte, int(info.maxOutBufBytes))
+	self.setupDone = true
+	return
+}
+
+func (self *AudioEncoder) prepare() (err error) {
+	if !self.setupDone {
+		return self.Setup()
+	}
+	return
+}
+
+func (self *AudioEncoder) CodecData() (codec av.AudioCodecData, err error) {
+	if err = self.prepare(); err != nil {
+		return
+	}
+	codec = self.codecData
+	return
+}
+
+// interleave returns the S16 samples of frame interleaved, the
+// frame is not sliced since AudioFrame.Slice assumes planar data.
+func interleave(frame av.AudioFrame) (b []byte, err error) {
+	switch frame.SampleFormat {
+	case av.S16:
+		b = frame.Data[0]
+	case av.S16P:
+		channels := frame.ChannelLayout.Count()
+		b = make([]byte, frame.SampleCount*channels*2)
+		for n := 0; n < frame.SampleCount; n++ {
+			for c := 0; c < channels; c++ {
+				copy(b[(n*channels+c)*2:], frame.Data[c][n*2:n*2+2])
+			}
+		}
+	default:
+		err = fmt.Errorf("fdkaac: sample format %s not supported", frame.SampleFormat)
+	}
+	return
+}
+
+func (self *AudioEncoder) encodeOne(in []byte) (pkt []byte, err error) {
+	var n C.int
+	e := C.encode(self.handle,
+		unsafe.Pointer(&in[0]), C.int(len(in)), C.int(len(in)/2),
+		unsafe.Pointer(&self.outbuf[0]), C.int(len(self.outbuf)), &n)
+	if e != C.AACENC_OK {
+		err = fmt.Errorf("fdkaac: aacEncEncode failed: %d", e)
+		return
+	}
+	if n > 0 {
+		pkt = append([]byte(nil), self.outbuf[:n]...)
+	}
+	return
+}
+
+func (self *AudioEncoder) Encode(frame av.AudioFrame) (pkts [][]byte, err error) {
+	if err = self.prepare(); err != nil {
+		return
+	}
+	if frame.SampleRate != self.SampleRate || frame.ChannelLayout != self.ChannelLayout {
+		err = fmt.Errorf("fdkaac: frame %dHz %s, encoder %dHz %s", frame.SampleRate, frame.ChannelLayout, self.SampleRate, self.ChannelLayout)
+		return
+	}
+	var in []byte
+	if in, err = interleave(frame); err != nil {
+		return
+	}
+	self.pcmbuf = append(self.pcmbuf, in...)
+	size := self.frameSize * self.ChannelLayout.Count() * 2
+	for len(self.pcmbuf) >= size {
+		var pkt []byte
+		if pkt, err = self.encodeOne(self.pcmbuf[:size]); err != nil {
+			return
+		}
+		if pkt != nil {
+			pkts = append(pkts, pkt)
+		}
+		self.pcmbuf = self.pcmbuf[size:]
+	}
+	return
+}
+
+// Flush encodes the samples left, shorter than a frame, and returns the
+// frames the encoder delays, after the last Encode and before Close.
+func (self *AudioEncoder) Flush() (pkts [][]byte, err error) {
+	if self.handle == nil {
+		return
+	}
+	if len(self.pcmbuf) > 0 {
+		var pkt []byte
+		if pkt, err = self.encodeOne(self.pcmbuf); err != nil {
+			return
+		}
+		if pkt != nil {
+			pkts = append(pkts, pkt)
+		}
+		self.pcmbuf = nil
+	}
+	for {
+		var n C.int
+		e := C.drain(self.handle, unsafe.Pointer(&self.outbuf[0]), C.int(len(self.outbuf)), &n)
+		if e == C.AACENC_ENCODE_EOF {
+			return
+		}
+		if e != C.AACENC_OK {
+			err = fmt.Errorf("fdkaac: aacEncEncode failed: %d", e)
+			return
+		}
+		if n == 0 {
+			return
+		}
+		pkts = append(pkts, append([]byte(nil), self.outbuf[:n]...))
+	}
+}
+
+// Close frees the encoder, the frames it still delays are lost unless
+// Flush was called.
+func (self *AudioEncoder) Close() {
+	if self.handle != nil {
+		C.aacEncClose(&self.handle)
+		self.handle = nil
+	}
+}
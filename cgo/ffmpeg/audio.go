@@ -313,9 +313,12 @@ func (self *AudioEncoder) Setup() (err error) {
 
 	switch ff.codecCtx.codec_id {
 	case C.AV_CODEC_ID_AAC:
-		if self.codecData, err = aacparser.NewCodecDataFromMPEG4AudioConfigBytes(extradata); err != nil {
+		var aaccodec aacparser.CodecData
+		if aaccodec, err = aacparser.NewCodecDataFromMPEG4AudioConfigBytes(extradata); err != nil {
 			return
 		}
+		aaccodec.Priming = int(ff.codecCtx.initial_padding)
+		self.codecData = aaccodec
 
 	default:
 		self.codecData = audioCodecData{
@@ -62,6 +62,23 @@ type MPEG4AudioConfig struct {
 	ObjectType      uint
 	SampleRateIndex uint
 	ChannelConfig   uint
+	FrameLength960  bool // GASpecificConfig frameLengthFlag
+}
+
+// SamplesPerFrame is the number of samples in one raw data block.
+func (self MPEG4AudioConfig) SamplesPerFrame() int {
+	if self.FrameLength960 {
+		return 960
+	}
+	return 1024
+}
+
+func hasGASpecificConfig(objectType uint) bool {
+	switch objectType {
+	case 1, 2, 3, 4, 6, 7, 17, 19, 20, 21, 22, 23:
+		return true
+	}
+	return false
 }
 
 var sampleRateTable = []int{
@@ -120,6 +137,35 @@ func ParseADTSHeader(frame []byte) (config MPEG4AudioConfig, hdrlen int, framele
 
 const ADTSHeaderLength = 7
 
+// SplitADTS splits data made only of ADTS frames into their raw payloads,
+// config is the one of the first frame.
+func SplitADTS(data []byte) (config MPEG4AudioConfig, frames [][]byte, err error) {
+	for len(data) > 0 {
+		if len(data) < ADTSHeaderLength {
+			err = fmt.Errorf("aacparser: adts header truncated")
+			return
+		}
+		var frameconfig MPEG4AudioConfig
+		var hdrlen, framelen int
+		if frameconfig, hdrlen, framelen, _, err = ParseADTSHeader(data); err != nil {
+			return
+		}
+		if framelen > len(data) {
+			err = fmt.Errorf("aacparser: adts frame truncated")
+			return
+		}
+		if len(frames) == 0 {
+			config = frameconfig
+		}
+		frames = append(frames, data[hdrlen:framelen])
+		data = data[framelen:]
+	}
+	if len(frames) == 0 {
+		err = fmt.Errorf("aacparser: no adts frame")
+	}
+	return
+}
+
 func FillADTSHeader(header []byte, config MPEG4AudioConfig, samples int, payloadLength int) {
 	payloadLength += 7
 	//AAAAAAAA AAAABCCD EEFFFFGH HHIJKLMM MMMMMMMM MMMOOOOO OOOOOOPP (QQQQQQQQ QQQQQQQQ)
@@ -227,6 +273,12 @@ func ParseMPEG4AudioConfigBytes(data []byte) (config MPEG4AudioConfig, err error
 	if config.ChannelConfig, err = br.ReadBits(4); err != nil {
 		return
 	}
+	if hasGASpecificConfig(config.ObjectType) {
+		// some muxers write the 2 byte config without GASpecificConfig
+		if flag, ferr := br.ReadBits(1); ferr == nil {
+			config.FrameLength960 = flag != 0
+		}
+	}
 	(&config).Complete()
 	return
 }
@@ -258,6 +310,11 @@ func WriteMPEG4AudioConfig(w io.Writer, config MPEG4AudioConfig) (err error) {
 	if err = bw.WriteBits(config.ChannelConfig, 4); err != nil {
 		return
 	}
+	if config.FrameLength960 && hasGASpecificConfig(config.ObjectType) {
+		if err = bw.WriteBits(1, 1); err != nil {
+			return
+		}
+	}
 
 	if err = bw.FlushBits(); err != nil {
 		return
@@ -268,8 +325,14 @@ func WriteMPEG4AudioConfig(w io.Writer, config MPEG4AudioConfig) (err error) {
 type CodecData struct {
 	ConfigBytes []byte
 	Config      MPEG4AudioConfig
+	// Priming is the encoder delay in samples, trimmed from the start on
+	// playback. Packet times include it as the encoder outputs them, most
+	// AAC encoders output 2112 (see DefaultPriming).
+	Priming int
 }
 
+const DefaultPriming = 2112
+
 func (self CodecData) Type() av.CodecType {
 	return av.AAC
 }
@@ -295,7 +358,7 @@ func (self CodecData) Tag() string {
 }
 
 func (self CodecData) PacketDuration(data []byte) (dur time.Duration, err error) {
-	dur = time.Duration(1024) * time.Second / time.Duration(self.Config.SampleRate)
+	dur = time.Duration(self.Config.SamplesPerFrame()) * time.Second / time.Duration(self.Config.SampleRate)
 	return
 }
 
@@ -0,0 +1,538 @@
+// Package av1parser splits AV1 temporal units in OBUs, reads the sequence
+// header that tells a stream's size and format, and builds the AV1 codec
+// configuration record of MP4 and Matroska.
+package av1parser
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/deepch/vdk/av"
+	"github.com/deepch/vdk/utils/bits"
+)
+
+const (
+	OBU_SEQUENCE_HEADER     = 1
+	OBU_TEMPORAL_DELIMITER  = 2
+	OBU_FRAME_HEADER        = 3
+	OBU_TILE_GROUP          = 4
+	OBU_METADATA            = 5
+	OBU_FRAME               = 6
+	OBU_REDUNDANT_FRAME_HDR = 7
+	OBU_TILE_LIST           = 8
+	OBU_PADDING             = 15
+)
+
+// OBUType is the type in the header of obu.
+func OBUType(obu []byte) int {
+	return int(obu[0]>>3) & 0xf
+}
+
+func readLEB128(b []byte) (v uint64, n int, err error) {
+	for n < 8 {
+		if n >= len(b) {
+			err = fmt.Errorf("av1parser: leb128 truncated")
+			return
+		}
+		v |= uint64(b[n]&0x7f) << uint(7*n)
+		n++
+		if b[n-1]&0x80 == 0 {
+			return
+		}
+	}
+	err = fmt.Errorf("av1parser: leb128 too long")
+	return
+}
+
+// SplitOBUs splits a temporal unit in the low overhead format, its OBUs
+// with a size field, as in MP4 samples and RTP. The last OBU may have no
+// size field.
+func SplitOBUs(b []byte) (obus [][]byte, err error) {
+	for len(b) > 0 {
+		hdr := 1
+		if b[0]&0x04 != 0 {
+			hdr = 2
+		}
+		if len(b) < hdr {
+			err = fmt.Errorf("av1parser: obu header truncated")
+			return
+		}
+		if b[0]&0x02 == 0 {
+			obus = append(obus, b)
+			return
+		}
+		var size uint64
+		var n int
+		if size, n, err = readLEB128(b[hdr:]); err != nil {
+			return
+		}
+		end := uint64(hdr+n) + size
+		if end > uint64(len(b)) {
+			err = fmt.Errorf("av1parser: obu size %d past the data", size)
+			return
+		}
+		obus = append(obus, b[:end])
+		b = b[end:]
+	}
+	return
+}
+
+// OBUPayload returns the payload of obu after its header and size.
+func OBUPayload(obu []byte) (payload []byte, err error) {
+	hdr := 1
+	if obu[0]&0x04 != 0 {
+		hdr = 2
+	}
+	if len(obu) < hdr {
+		err = fmt.Errorf("av1parser: obu header truncated")
+		return
+	}
+	if obu[0]&0x02 == 0 {
+		payload = obu[hdr:]
+		return
+	}
+	var size uint64
+	var n int
+	if size, n, err = readLEB128(obu[hdr:]); err != nil {
+		return
+	}
+	if uint64(hdr+n)+size > uint64(len(obu)) {
+		err = fmt.Errorf("av1parser: obu size %d past the data", size)
+		return
+	}
+	payload = obu[hdr+n : uint64(hdr+n)+size]
+	return
+}
+
+// RemoveTemporalDelimiters returns the temporal unit b without its
+// temporal delimiter OBUs, which MP4 samples must not have.
+func RemoveTemporalDelimiters(b []byte) []byte {
+	obus, err := SplitOBUs(b)
+	if err != nil {
+		return b
+	}
+	var out []byte
+	for i, obu := range obus {
+		if OBUType(obu) == OBU_TEMPORAL_DELIMITER {
+			if out == nil {
+				out = make([]byte, 0, len(b))
+				for _, prev := range obus[:i] {
+					out = append(out, prev...)
+				}
+			}
+			continue
+		}
+		if out != nil {
+			out = append(out, obu...)
+		}
+	}
+	if out == nil {
+		return b
+	}
+	return out
+}
+
+// FindSequenceHeader returns the sequence header OBU of a temporal unit.
+func FindSequenceHeader(b []byte) (obu []byte, ok bool) {
+	obus, _ := SplitOBUs(b)
+	for _, obu = range obus {
+		if OBUType(obu) == OBU_SEQUENCE_HEADER {
+			return obu, true
+		}
+	}
+	return nil, false
+}
+
+// SequenceHeader is what the av1C record takes from a sequence header.
+type SequenceHeader struct {
+	Profile              uint8
+	Level                uint8 // seq_level_idx of the first operating point
+	Tier                 uint8
+	BitDepth             int
+	Monochrome           bool
+	SubsamplingX         bool
+	SubsamplingY         bool
+	ChromaSamplePosition uint8
+	Width                int // max_frame_width
+	Height               int
+}
+
+type reader struct {
+	bits.GolombBitReader
+}
+
+func (self *reader) flag() (v bool, err error) {
+	var bit uint
+	bit, err = self.ReadBit()
+	v = bit != 0
+	return
+}
+
+func (self *reader) uvlc() (v uint, err error) {
+	zeros := 0
+	for {
+		var bit uint
+		if bit, err = self.ReadBit(); err != nil {
+			return
+		}
+		if bit != 0 {
+			break
+		}
+		if zeros++; zeros >= 32 {
+			return
+		}
+	}
+	v, err = self.ReadBits(zeros)
+	v += 1<<uint(zeros) - 1
+	return
+}
+
+// ParseSequenceHeader reads a sequence header OBU as section 5.5 of the
+// AV1 specification.
+func ParseSequenceHeader(obu []byte) (h SequenceHeader, err error) {
+	if OBUType(obu) != OBU_SEQUENCE_HEADER {
+		err = fmt.Errorf("av1parser: not a sequence header")
+		return
+	}
+	var payload []byte
+	if payload, err = OBUPayload(obu); err != nil {
+		return
+	}
+	r := &reader{bits.GolombBitReader{R: bytes.NewReader(payload)}}
+	var v uint
+	var flag bool
+	if v, err = r.ReadBits(3); err != nil {
+		return
+	}
+	h.Profile = uint8(v)
+	if _, err = r.ReadBit(); err != nil { // still_picture
+		return
+	}
+	var reduced bool
+	if reduced, err = r.flag(); err != nil {
+		return
+	}
+	if reduced {
+		if v, err = r.ReadBits(5); err != nil {
+			return
+		}
+		h.Level = uint8(v)
+	} else {
+		var timingInfo, decoderModelInfo, initialDisplayDelay bool
+		var bufferDelayLength uint
+		if timingInfo, err = r.flag(); err != nil {
+			return
+		}
+		if timingInfo {
+			// num_units_in_display_tick, time_scale
+			if _, err = r.ReadBits64(64); err != nil {
+				return
+			}
+			if flag, err = r.flag(); err != nil { // equal_picture_interval
+				return
+			}
+			if flag {
+				if _, err = r.uvlc(); err != nil {
+					return
+				}
+			}
+			if decoderModelInfo, err = r.flag(); err != nil {
+				return
+			}
+			if decoderModelInfo {
+				if bufferDelayLength, err = r.ReadBits(5); err != nil {
+					return
+				}
+				bufferDelayLength++
+				// num_units_in_decoding_tick, buffer_removal_time_length_minus_1,
+				// frame_presentation_time_length_minus_1
+				if _, err = r.ReadBits64(42); err != nil {
+					return
+				}
+			}
+		}
+		if initialDisplayDelay, err = r.flag(); err != nil {
+			return
+		}
+		var count uint
+		if count, err = r.ReadBits(5); err != nil {
+			return
+		}
+		for i := 0; i <= int(count); i++ {
+			if _, err = r.ReadBits(12); err != nil { // operating_point_idc
+				return
+			}
+			var level, tier uint
+			if level, err = r.ReadBits(5); err != nil {
+				return
+			}
+			if level > 7 {
+				if tier, err = r.ReadBit(); err != nil {
+					return
+				}
+			}
+			if i == 0 {
+				h.Level, h.Tier = uint8(level), uint8(tier)
+			}
+			if decoderModelInfo {
+				if flag, err = r.flag(); err != nil {
+					return
+				}
+				if flag {
+					// decoder_buffer_delay, encoder_buffer_delay, low_delay_mode_flag
+					if _, err = r.ReadBits64(2*bufferDelayLength + 1); err != nil {
+						return
+					}
+				}
+			}
+			if initialDisplayDelay {
+				if flag, err = r.flag(); err != nil {
+					return
+				}
+				if flag {
+					if _, err = r.ReadBits(4); err != nil {
+						return
+					}
+				}
+			}
+		}
+	}
+
+	var widthBits, heightBits uint
+	if widthBits, err = r.ReadBits(4); err != nil {
+		return
+	}
+	if heightBits, err = r.ReadBits(4); err != nil {
+		return
+	}
+	if v, err = r.ReadBits(int(widthBits) + 1); err != nil {
+		return
+	}
+	h.Width = int(v) + 1
+	if v, err = r.ReadBits(int(heightBits) + 1); err != nil {
+		return
+	}
+	h.Height = int(v) + 1
+
+	if !reduced {
+		if flag, err = r.flag(); err != nil { // frame_id_numbers_present_flag
+			return
+		}
+		if flag {
+			if _, err = r.ReadBits(7); err != nil {
+				return
+			}
+		}
+	}
+	// use_128x128_superblock, enable_filter_intra, enable_intra_edge_filter
+	if _, err = r.ReadBits(3); err != nil {
+		return
+	}
+	if !reduced {
+		// enable_interintra_compound, enable_masked_compound,
+		// enable_warped_motion, enable_dual_filter
+		if _, err = r.ReadBits(4); err != nil {
+			return
+		}
+		var orderHint bool
+		if orderHint, err = r.flag(); err != nil {
+			return
+		}
+		if orderHint {
+			// enable_jnt_comp, enable_ref_frame_mvs
+			if _, err = r.ReadBits(2); err != nil {
+				return
+			}
+		}
+		forceScreenContentTools := uint(2)
+		if flag, err = r.flag(); err != nil { // seq_choose_screen_content_tools
+			return
+		}
+		if !flag {
+			if forceScreenContentTools, err = r.ReadBit(); err != nil {
+				return
+			}
+		}
+		if forceScreenContentTools > 0 {
+			if flag, err = r.flag(); err != nil { // seq_choose_integer_mv
+				return
+			}
+			if !flag {
+				if _, err = r.ReadBit(); err != nil {
+					return
+				}
+			}
+		}
+		if orderHint {
+			if _, err = r.ReadBits(3); err != nil {
+				return
+			}
+		}
+	}
+	// enable_superres, enable_cdef, enable_restoration
+	if _, err = r.ReadBits(3); err != nil {
+		return
+	}
+
+	// color_config
+	var highBitDepth bool
+	if highBitDepth, err = r.flag(); err != nil {
+		return
+	}
+	h.BitDepth = 8
+	if highBitDepth {
+		h.BitDepth = 10
+		if h.Profile == 2 {
+			if flag, err = r.flag(); err != nil {
+				return
+			}
+			if flag {
+				h.BitDepth = 12
+			}
+		}
+	}
+	if h.Profile != 1 {
+		if h.Monochrome, err = r.flag(); err != nil {
+			return
+		}
+	}
+	var cp, tc, mc uint = 2, 2, 2
+	if flag, err = r.flag(); err != nil { // color_description_present_flag
+		return
+	}
+	if flag {
+		if cp, err = r.ReadBits(8); err != nil {
+			return
+		}
+		if tc, err = r.ReadBits(8); err != nil {
+			return
+		}
+		if mc, err = r.ReadBits(8); err != nil {
+			return
+		}
+	}
+	switch {
+	case h.Monochrome:
+		h.SubsamplingX, h.SubsamplingY = true, true
+		return
+	case cp == 1 && tc == 13 && mc == 0:
+		// sRGB, 4:4:4
+		return
+	}
+	if _, err = r.ReadBit(); err != nil { // color_range
+		return
+	}
+	switch h.Profile {
+	case 0:
+		h.SubsamplingX, h.SubsamplingY = true, true
+	case 1:
+	default:
+		if h.BitDepth == 12 {
+			if h.SubsamplingX, err = r.flag(); err != nil {
+				return
+			}
+			if h.SubsamplingX {
+				if h.SubsamplingY, err = r.flag(); err != nil {
+					return
+				}
+			}
+		} else {
+			h.SubsamplingX = true
+		}
+	}
+	if h.SubsamplingX && h.SubsamplingY {
+		if v, err = r.ReadBits(2); err != nil {
+			return
+		}
+		h.ChromaSamplePosition = uint8(v)
+	}
+	return
+}
+
+type CodecData struct {
+	Record         []byte // av1C content
+	SequenceHeader SequenceHeader
+}
+
+func (self CodecData) Type() av.CodecType {
+	return av.AV1
+}
+
+func (self CodecData) Width() int {
+	return self.SequenceHeader.Width
+}
+
+func (self CodecData) Height() int {
+	return self.SequenceHeader.Height
+}
+
+// AV1CodecConfRecordBytes returns the content of the av1C box.
+func (self CodecData) AV1CodecConfRecordBytes() []byte {
+	return self.Record
+}
+
+// NewCodecDataFromSequenceHeader makes the codec of a sequence header OBU,
+// which goes in the record with a size field.
+func NewCodecDataFromSequenceHeader(obu []byte) (self CodecData, err error) {
+	if self.SequenceHeader, err = ParseSequenceHeader(obu); err != nil {
+		return
+	}
+	h := self.SequenceHeader
+	b := []byte{0x81, h.Profile<<5 | h.Level, h.Tier << 7, 0}
+	if h.BitDepth > 8 {
+		b[2] |= 0x40
+	}
+	if h.BitDepth == 12 {
+		b[2] |= 0x20
+	}
+	if h.Monochrome {
+		b[2] |= 0x10
+	}
+	if h.SubsamplingX {
+		b[2] |= 0x08
+	}
+	if h.SubsamplingY {
+		b[2] |= 0x04
+	}
+	b[2] |= h.ChromaSamplePosition
+
+	if obu[0]&0x02 == 0 {
+		// add the size field
+		var payload []byte
+		if payload, err = OBUPayload(obu); err != nil {
+			return
+		}
+		hdr := len(obu) - len(payload)
+		sized := append([]byte{}, obu[:hdr]...)
+		sized[0] |= 0x02
+		for size := len(payload); ; size >>= 7 {
+			if size < 0x80 {
+				sized = append(sized, byte(size))
+				break
+			}
+			sized = append(sized, byte(size&0x7f|0x80))
+		}
+		obu = append(sized, payload...)
+	}
+	self.Record = append(b, obu...)
+	return
+}
+
+// NewCodecDataFromAV1CodecConfRecord makes the codec of an av1C box
+// content, read from the sequence header it holds.
+func NewCodecDataFromAV1CodecConfRecord(record []byte) (self CodecData, err error) {
+	if len(record) < 4 || record[0] != 0x81 {
+		err = fmt.Errorf("av1parser: av1C invalid")
+		return
+	}
+	obu, ok := FindSequenceHeader(record[4:])
+	if !ok {
+		err = fmt.Errorf("av1parser: av1C has no sequence header")
+		return
+	}
+	if self.SequenceHeader, err = ParseSequenceHeader(obu); err != nil {
+		return
+	}
+	self.Record = record
+	return
+}
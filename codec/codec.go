@@ -98,3 +98,30 @@ func NewSpeexCodecData(sr int, cl av.ChannelLayout) SpeexCodecData {
 	codec.ChannelLayout_ = cl
 	return codec
 }
+
+// LinearPCMCodecData is interleaved PCM of any rate and layout, little endian
+// unless BigEndian is set, as for RTP L16.
+type LinearPCMCodecData struct {
+	fake.CodecData
+	BigEndian bool
+}
+
+func (self LinearPCMCodecData) PacketDuration(data []byte) (time.Duration, error) {
+	frame := self.SampleFormat_.BytesPerSample() * self.ChannelLayout_.Count()
+	if frame == 0 || self.SampleRate_ == 0 {
+		return 0, nil
+	}
+	return time.Duration(len(data)/frame) * time.Second / time.Duration(self.SampleRate_), nil
+}
+
+// NewLinearPCMCodecData makes PCM of sample format sf. Little endian is the
+// byte order vdk assumes for PCM which does not say, av/chmap and the
+// generators produce it, bigEndian is for sources like RTP L16.
+func NewLinearPCMCodecData(sf av.SampleFormat, sr int, cl av.ChannelLayout, bigEndian bool) LinearPCMCodecData {
+	codec := LinearPCMCodecData{BigEndian: bigEndian}
+	codec.CodecType_ = av.PCM
+	codec.SampleFormat_ = sf
+	codec.SampleRate_ = sr
+	codec.ChannelLayout_ = cl
+	return codec
+}
@@ -0,0 +1,599 @@
+package dvbsub
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"time"
+)
+
+// Segment types.
+const (
+	SegmentPageComposition   = 0x10
+	SegmentRegionComposition = 0x11
+	SegmentCLUTDefinition    = 0x12
+	SegmentObjectData        = 0x13
+	SegmentDisplayDefinition = 0x14
+	SegmentEndOfDisplaySet   = 0x80
+)
+
+// Subtitle is a decoded display set. A subtitle with no region clears the
+// screen.
+type Subtitle struct {
+	Width, Height int
+	Timeout       time.Duration
+	Regions       []Region
+}
+
+type Region struct {
+	X, Y  int
+	Image *image.Paletted
+}
+
+// Image draws the regions on a transparent picture of the display size.
+func (self *Subtitle) Image() *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, self.Width, self.Height))
+	for _, region := range self.Regions {
+		r := region.Image.Bounds().Add(image.Pt(region.X, region.Y))
+		draw.Draw(img, r, region.Image, image.Point{}, draw.Over)
+	}
+	return img
+}
+
+// Decoder decodes the display sets of one subtitling service, it must be
+// fed every packet of the stream in order.
+type Decoder struct {
+	CompositionPageID uint16 // the first page seen if zero
+	AncillaryPageID   uint16
+
+	width, height int
+	timeout       time.Duration
+	page          []pageRegion
+	regions       map[uint8]*region
+	cluts         map[uint8]*clut
+}
+
+type pageRegion struct {
+	id   uint8
+	x, y int
+}
+
+type regionObject struct {
+	id        uint16
+	x, y      int
+	fg, bg    uint8
+	character bool
+}
+
+type region struct {
+	width, height int
+	depth         uint8 // 1, 2 or 3 for 2, 4 and 8 bits
+	clut          uint8
+	pixels        []byte
+	objects       []regionObject
+}
+
+type clut struct {
+	c2 [4]color.RGBA
+	c4 [16]color.RGBA
+	c8 [256]color.RGBA
+}
+
+func NewDecoder(composition, ancillary uint16) *Decoder {
+	return &Decoder{CompositionPageID: composition, AncillaryPageID: ancillary}
+}
+
+func (self *Decoder) reset() {
+	self.page = nil
+	self.regions = map[uint8]*region{}
+	self.cluts = map[uint8]*clut{}
+}
+
+// Decode handles a packet as demuxed, starting with the data_identifier. It
+// returns the subtitle to show once a display set is complete.
+func (self *Decoder) Decode(data []byte) (sub *Subtitle, err error) {
+	if self.regions == nil {
+		self.reset()
+	}
+	if len(data) < 2 || data[0] != 0x20 || data[1] != 0 {
+		err = fmt.Errorf("dvbsub: not a subtitle PES payload")
+		return
+	}
+	b := data[2:]
+	for len(b) >= 6 && b[0] == 0x0f {
+		typ := b[1]
+		pageid := uint16(b[2])<<8 | uint16(b[3])
+		length := int(b[4])<<8 | int(b[5])
+		if len(b) < 6+length {
+			err = fmt.Errorf("dvbsub: segment 0x%x truncated", typ)
+			return
+		}
+		seg := b[6 : 6+length]
+		b = b[6+length:]
+
+		if self.CompositionPageID == 0 {
+			self.CompositionPageID = pageid
+		}
+		if pageid != self.CompositionPageID && (pageid != self.AncillaryPageID || pageid == 0) {
+			continue
+		}
+		switch typ {
+		case SegmentPageComposition:
+			err = self.parsePage(seg)
+		case SegmentRegionComposition:
+			err = self.parseRegion(seg)
+		case SegmentCLUTDefinition:
+			err = self.parseCLUT(seg)
+		case SegmentObjectData:
+			err = self.parseObject(seg)
+		case SegmentDisplayDefinition:
+			err = self.parseDisplay(seg)
+		case SegmentEndOfDisplaySet:
+			sub = self.display()
+		}
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (self *Decoder) display() (sub *Subtitle) {
+	sub = &Subtitle{Width: self.width, Height: self.height, Timeout: self.timeout}
+	if sub.Width == 0 {
+		sub.Width, sub.Height = 720, 576
+	}
+	for _, pr := range self.page {
+		r := self.regions[pr.id]
+		if r == nil || r.width == 0 || r.height == 0 {
+			continue
+		}
+		img := &image.Paletted{
+			Pix:     append([]byte(nil), r.pixels...),
+			Stride:  r.width,
+			Rect:    image.Rect(0, 0, r.width, r.height),
+			Palette: self.palette(r),
+		}
+		sub.Regions = append(sub.Regions, Region{X: pr.x, Y: pr.y, Image: img})
+	}
+	return
+}
+
+func (self *Decoder) palette(r *region) color.Palette {
+	c := self.cluts[r.clut]
+	if c == nil {
+		c = defaultCLUT()
+	}
+	var colors []color.RGBA
+	switch r.depth {
+	case 1:
+		colors = c.c2[:]
+	case 2:
+		colors = c.c4[:]
+	default:
+		colors = c.c8[:]
+	}
+	palette := make(color.Palette, len(colors))
+	for i := range colors {
+		palette[i] = colors[i]
+	}
+	return palette
+}
+
+func (self *Decoder) parseDisplay(b []byte) (err error) {
+	if len(b) < 5 {
+		return fmt.Errorf("dvbsub: display definition segment too short")
+	}
+	self.width = (int(b[1])<<8 | int(b[2])) + 1
+	self.height = (int(b[3])<<8 | int(b[4])) + 1
+	return
+}
+
+func (self *Decoder) parsePage(b []byte) (err error) {
+	if len(b) < 2 {
+		return fmt.Errorf("dvbsub: page composition segment too short")
+	}
+	self.timeout = time.Duration(b[0]) * time.Second
+	state := (b[1] >> 2) & 3
+	if state != 0 {
+		// acquisition point or mode change, everything is sent again
+		self.reset()
+	}
+	self.page = nil
+	for b = b[2:]; len(b) >= 6; b = b[6:] {
+		self.page = append(self.page, pageRegion{
+			id: b[0],
+			x:  int(b[2])<<8 | int(b[3]),
+			y:  int(b[4])<<8 | int(b[5]),
+		})
+	}
+	return
+}
+
+func (self *Decoder) parseRegion(b []byte) (err error) {
+	if len(b) < 10 {
+		return fmt.Errorf("dvbsub: region composition segment too short")
+	}
+	id := b[0]
+	fill := b[1]&0x08 != 0
+	width := int(b[2])<<8 | int(b[3])
+	height := int(b[4])<<8 | int(b[5])
+	depth := (b[6] >> 2) & 7
+	if depth < 1 || depth > 3 {
+		return fmt.Errorf("dvbsub: region depth %d not supported", depth)
+	}
+
+	r := self.regions[id]
+	if r == nil || r.width != width || r.height != height || r.depth != depth {
+		r = &region{width: width, height: height, depth: depth}
+		r.pixels = make([]byte, width*height)
+		fill = true
+		self.regions[id] = r
+	}
+	r.clut = b[7]
+	if fill {
+		var code byte
+		switch depth {
+		case 1:
+			code = (b[9] >> 2) & 3
+		case 2:
+			code = b[9] >> 4
+		case 3:
+			code = b[8]
+		}
+		for i := range r.pixels {
+			r.pixels[i] = code
+		}
+	}
+
+	r.objects = nil
+	for b = b[10:]; len(b) >= 6; {
+		obj := regionObject{
+			id: uint16(b[0])<<8 | uint16(b[1]),
+			x:  int(b[2]&0x0f)<<8 | int(b[3]),
+			y:  int(b[4]&0x0f)<<8 | int(b[5]),
+		}
+		typ := b[2] >> 6
+		b = b[6:]
+		if typ == 1 || typ == 2 {
+			if len(b) < 2 {
+				break
+			}
+			obj.character = true
+			obj.fg, obj.bg = b[0], b[1]
+			b = b[2:]
+		}
+		r.objects = append(r.objects, obj)
+	}
+	return
+}
+
+func (self *Decoder) parseCLUT(b []byte) (err error) {
+	if len(b) < 2 {
+		return fmt.Errorf("dvbsub: CLUT definition segment too short")
+	}
+	c := self.cluts[b[0]]
+	if c == nil {
+		c = defaultCLUT()
+		self.cluts[b[0]] = c
+	}
+	for b = b[2:]; len(b) >= 2; {
+		entry := b[0]
+		flags := b[1]
+		var y, cr, cb, t uint8
+		if flags&1 != 0 {
+			if len(b) < 6 {
+				break
+			}
+			y, cr, cb, t = b[2], b[3], b[4], b[5]
+			b = b[6:]
+		} else {
+			if len(b) < 4 {
+				break
+			}
+			v := uint16(b[2])<<8 | uint16(b[3])
+			y = uint8(v>>10) << 2
+			cr = uint8(v>>6&0xf) << 4
+			cb = uint8(v>>2&0xf) << 4
+			t = uint8(v&3) << 6
+			b = b[4:]
+		}
+		rgba := color.RGBA{}
+		// a zero luma is full transparency
+		if y != 0 {
+			r, g, bl := color.YCbCrToRGB(y, cb, cr)
+			a := 255 - t
+			rgba = color.RGBA{
+				R: uint8(uint16(r) * uint16(a) / 255),
+				G: uint8(uint16(g) * uint16(a) / 255),
+				B: uint8(uint16(bl) * uint16(a) / 255),
+				A: a,
+			}
+		}
+		if flags&0x80 != 0 && entry < 4 {
+			c.c2[entry] = rgba
+		}
+		if flags&0x40 != 0 && entry < 16 {
+			c.c4[entry] = rgba
+		}
+		if flags&0x20 != 0 {
+			c.c8[entry] = rgba
+		}
+	}
+	return
+}
+
+func (self *Decoder) parseObject(b []byte) (err error) {
+	if len(b) < 3 {
+		return fmt.Errorf("dvbsub: object data segment too short")
+	}
+	id := uint16(b[0])<<8 | uint16(b[1])
+	method := (b[2] >> 2) & 3
+	nonModifying := b[2]&0x02 != 0
+	if method != 0 {
+		// character coded objects are not rendered
+		return
+	}
+	if len(b) < 7 {
+		return fmt.Errorf("dvbsub: object data segment too short")
+	}
+	toplen := int(b[3])<<8 | int(b[4])
+	bottomlen := int(b[5])<<8 | int(b[6])
+	b = b[7:]
+	if len(b) < toplen+bottomlen {
+		return fmt.Errorf("dvbsub: object %d data truncated", id)
+	}
+	top := b[:toplen]
+	bottom := b[toplen : toplen+bottomlen]
+	if bottomlen == 0 {
+		bottom = top
+	}
+
+	for _, r := range self.regions {
+		for _, obj := range r.objects {
+			if obj.id != id || obj.character {
+				continue
+			}
+			r.drawBlock(top, obj.x, obj.y, nonModifying)
+			r.drawBlock(bottom, obj.x, obj.y+1, nonModifying)
+		}
+	}
+	return
+}
+
+var (
+	default2to4 = []byte{0x0, 0x7, 0x8, 0xf}
+	default2to8 = []byte{0x00, 0x77, 0x88, 0xff}
+	default4to8 = []byte{
+		0x00, 0x11, 0x22, 0x33, 0x44, 0x55, 0x66, 0x77,
+		0x88, 0x99, 0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff,
+	}
+)
+
+// drawBlock draws the pixel-data sub-blocks of one field, lines go every
+// other row from y.
+func (self *region) drawBlock(b []byte, x, y int, nonModifying bool) {
+	map2to4 := append([]byte(nil), default2to4...)
+	map2to8 := append([]byte(nil), default2to8...)
+	map4to8 := append([]byte(nil), default4to8...)
+	px := x
+
+	put := func(code byte, run int, bits uint8) {
+		v := code
+		switch {
+		case bits == 2 && self.depth == 2:
+			v = map2to4[code]
+		case bits == 2 && self.depth == 3:
+			v = map2to8[code]
+		case bits == 4 && self.depth == 3:
+			v = map4to8[code]
+		case bits == 4 && self.depth == 1:
+			v = code >> 2
+		case bits == 8 && self.depth != 3:
+			v = code >> (8 - 2*self.depth)
+		}
+		for ; run > 0; run-- {
+			if px < self.width && y < self.height && !(nonModifying && code == 1) {
+				self.pixels[y*self.width+px] = v
+			}
+			px++
+		}
+	}
+
+	for len(b) > 0 {
+		typ := b[0]
+		b = b[1:]
+		switch typ {
+		case 0x10:
+			b = decode2bit(b, func(code byte, run int) { put(code, run, 2) })
+		case 0x11:
+			b = decode4bit(b, func(code byte, run int) { put(code, run, 4) })
+		case 0x12:
+			b = decode8bit(b, func(code byte, run int) { put(code, run, 8) })
+		case 0x20:
+			if len(b) < 2 {
+				return
+			}
+			map2to4 = []byte{b[0] >> 4, b[0] & 0xf, b[1] >> 4, b[1] & 0xf}
+			b = b[2:]
+		case 0x21:
+			if len(b) < 4 {
+				return
+			}
+			map2to8 = append([]byte(nil), b[:4]...)
+			b = b[4:]
+		case 0x22:
+			// 16 entries of 8 bits
+			if len(b) < 16 {
+				return
+			}
+			map4to8 = append([]byte(nil), b[:16]...)
+			b = b[16:]
+		case 0xf0:
+			px = x
+			y += 2
+		default:
+			return
+		}
+	}
+}
+
+type bitReader struct {
+	b   []byte
+	pos int
+}
+
+func (self *bitReader) read(n int) (v int) {
+	for i := 0; i < n; i++ {
+		v <<= 1
+		if self.pos>>3 < len(self.b) && self.b[self.pos>>3]&(0x80>>uint(self.pos&7)) != 0 {
+			v |= 1
+		}
+		self.pos++
+	}
+	return
+}
+
+func (self *bitReader) more() bool {
+	return self.pos>>3 < len(self.b)
+}
+
+// rest skips to the next byte and returns what follows.
+func (self *bitReader) rest() []byte {
+	n := (self.pos + 7) >> 3
+	if n > len(self.b) {
+		n = len(self.b)
+	}
+	return self.b[n:]
+}
+
+func decode2bit(b []byte, put func(code byte, run int)) []byte {
+	r := &bitReader{b: b}
+	for r.more() {
+		if code := r.read(2); code != 0 {
+			put(byte(code), 1)
+		} else if r.read(1) == 1 {
+			run := r.read(3) + 3
+			put(byte(r.read(2)), run)
+		} else if r.read(1) == 1 {
+			put(0, 1)
+		} else {
+			switch r.read(2) {
+			case 0:
+				return r.rest()
+			case 1:
+				put(0, 2)
+			case 2:
+				run := r.read(4) + 12
+				put(byte(r.read(2)), run)
+			case 3:
+				run := r.read(8) + 29
+				put(byte(r.read(2)), run)
+			}
+		}
+	}
+	return r.rest()
+}
+
+func decode4bit(b []byte, put func(code byte, run int)) []byte {
+	r := &bitReader{b: b}
+	for r.more() {
+		if code := r.read(4); code != 0 {
+			put(byte(code), 1)
+		} else if r.read(1) == 0 {
+			run := r.read(3)
+			if run == 0 {
+				return r.rest()
+			}
+			put(0, run+2)
+		} else if r.read(1) == 0 {
+			run := r.read(2) + 4
+			put(byte(r.read(4)), run)
+		} else {
+			switch r.read(2) {
+			case 0:
+				put(0, 1)
+			case 1:
+				put(0, 2)
+			case 2:
+				run := r.read(4) + 9
+				put(byte(r.read(4)), run)
+			case 3:
+				run := r.read(8) + 25
+				put(byte(r.read(4)), run)
+			}
+		}
+	}
+	return r.rest()
+}
+
+func decode8bit(b []byte, put func(code byte, run int)) []byte {
+	r := &bitReader{b: b}
+	for r.more() {
+		if code := r.read(8); code != 0 {
+			put(byte(code), 1)
+		} else if r.read(1) == 0 {
+			run := r.read(7)
+			if run == 0 {
+				return r.rest()
+			}
+			put(0, run)
+		} else {
+			run := r.read(7)
+			put(byte(r.read(8)), run)
+		}
+	}
+	return r.rest()
+}
+
+// defaultCLUT returns the colours used until a CLUT definition overrides them.
+func defaultCLUT() *clut {
+	c := &clut{}
+	c.c2 = [4]color.RGBA{{}, {255, 255, 255, 255}, {0, 0, 0, 255}, {127, 127, 127, 255}}
+
+	level := func(i int, on uint8) uint8 {
+		if i != 0 {
+			return on
+		}
+		return 0
+	}
+	for i := 1; i < 16; i++ {
+		on := uint8(255)
+		if i&8 != 0 {
+			on = 127
+		}
+		c.c4[i] = color.RGBA{level(i&1, on), level(i&2, on), level(i&4, on), 255}
+	}
+
+	for i := 1; i < 256; i++ {
+		var r, g, b int
+		a := 255
+		switch i & 0x88 {
+		case 0x00:
+			if i < 8 {
+				r, g, b, a = 255*(i&1), 255*(i>>1&1), 255*(i>>2&1), 191
+			} else {
+				r = 85*(i&1) + 170*(i>>4&1)
+				g = 85*(i>>1&1) + 170*(i>>5&1)
+				b = 85*(i>>2&1) + 170*(i>>6&1)
+			}
+		case 0x08:
+			r = 85*(i&1) + 170*(i>>4&1)
+			g = 85*(i>>1&1) + 170*(i>>5&1)
+			b = 85*(i>>2&1) + 170*(i>>6&1)
+			a = 127
+		case 0x80:
+			r = 127 + 43*(i&1) + 85*(i>>4&1)
+			g = 127 + 43*(i>>1&1) + 85*(i>>5&1)
+			b = 127 + 43*(i>>2&1) + 85*(i>>6&1)
+		case 0x88:
+			r = 43*(i&1) + 85*(i>>4&1)
+			g = 43*(i>>1&1) + 85*(i>>5&1)
+			b = 43*(i>>2&1) + 85*(i>>6&1)
+		}
+		// premultiplied like color.RGBA expects
+		c.c8[i] = color.RGBA{uint8(r * a / 255), uint8(g * a / 255), uint8(b * a / 255), uint8(a)}
+	}
+	return c
+}
@@ -0,0 +1,151 @@
+package dvbsub
+
+import (
+	"bytes"
+	"image/color"
+	"testing"
+)
+
+func TestDrawBlock(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		depth uint8
+		data  []byte // one line of pixel-data sub-blocks
+		want  []byte
+	}{
+		// 1, 2, 3, then 5 of 2 and the end
+		{"2-bit", 1, []byte{0x10, 0x6c, 0xa8, 0x00, 0xf0}, []byte{1, 2, 3, 2, 2, 2, 2, 2}},
+		{"2-bit to 4-bit", 2, []byte{0x10, 0x6c, 0xa8, 0x00, 0xf0}, []byte{7, 8, 15, 8, 8, 8, 8, 8}},
+		{"2-bit to 4-bit map", 2, []byte{0x20, 0x01, 0x23, 0x10, 0x6c, 0xa8, 0x00, 0xf0}, []byte{1, 2, 3, 2, 2, 2, 2, 2}},
+		{"2-bit to 8-bit map", 3, []byte{0x21, 0x00, 0x40, 0x80, 0xc0, 0x10, 0x6c, 0xa8, 0x00, 0xf0}, []byte{0x40, 0x80, 0xc0, 0x80, 0x80, 0x80, 0x80, 0x80}},
+		// 1, 1, 2, one of 0 and the end
+		{"4-bit", 2, []byte{0x11, 0x11, 0x20, 0xc0, 0x00, 0xf0}, []byte{1, 1, 2, 0, 0, 0, 0, 0}},
+		{"4-bit to 8-bit", 3, []byte{0x11, 0x11, 0x20, 0xc0, 0x00, 0xf0}, []byte{0x11, 0x11, 0x22, 0, 0, 0, 0, 0}},
+		{"4-bit to 8-bit map", 3, append(append([]byte{0x22},
+			0xa0, 0xa1, 0xa2, 0xa3, 0xa4, 0xa5, 0xa6, 0xa7, 0xa8, 0xa9, 0xaa, 0xab, 0xac, 0xad, 0xae, 0xaf),
+			0x11, 0x11, 0x20, 0xc0, 0x00, 0xf0), []byte{0xa1, 0xa1, 0xa2, 0xa0, 0, 0, 0, 0}},
+		// 4 of 3
+		{"4-bit run", 2, []byte{0x11, 0x08, 0x30, 0x00, 0xf0}, []byte{3, 3, 3, 3, 0, 0, 0, 0}},
+		// 5, then 3 of 9
+		{"8-bit", 3, []byte{0x12, 0x05, 0x00, 0x83, 0x09, 0x00, 0x00, 0xf0}, []byte{5, 9, 9, 9, 0, 0, 0, 0}},
+		// runs past the region width are clipped
+		{"clipped", 1, []byte{0x10, 0x0c, 0x02, 0x00}, []byte{2, 2, 2, 2, 2, 2, 2, 2}},
+		// cut short, drawn as far as it goes
+		{"truncated", 2, []byte{0x11, 0x11}, []byte{1, 1, 0, 0, 0, 0, 0, 0}},
+		{"truncated map", 3, []byte{0x22, 0x01, 0x02}, []byte{0, 0, 0, 0, 0, 0, 0, 0}},
+		{"unknown type", 2, []byte{0x33, 0x11, 0x11}, []byte{0, 0, 0, 0, 0, 0, 0, 0}},
+	} {
+		r := &region{width: 8, height: 2, depth: test.depth, pixels: make([]byte, 16)}
+		r.drawBlock(test.data, 0, 0, false)
+		if !bytes.Equal(r.pixels[:8], test.want) {
+			t.Errorf("%s: line %x, want %x", test.name, r.pixels[:8], test.want)
+		}
+		if !bytes.Equal(r.pixels[8:], make([]byte, 8)) {
+			t.Errorf("%s: drew on the second line %x", test.name, r.pixels[8:])
+		}
+	}
+}
+
+// segment returns a subtitling segment of page 1.
+func segment(typ byte, data ...byte) []byte {
+	return append([]byte{0x0f, typ, 0, 1, byte(len(data) >> 8), byte(len(data))}, data...)
+}
+
+func pesPayload(segments ...[]byte) []byte {
+	b := []byte{0x20, 0x00}
+	for _, seg := range segments {
+		b = append(b, seg...)
+	}
+	return append(b, 0xff)
+}
+
+func TestDecode(t *testing.T) {
+	displaySet := pesPayload(
+		segment(SegmentDisplayDefinition, 0x00, 0x02, 0xcf, 0x01, 0xdf), // 720x480
+		// timeout 5s, acquisition point, region 3 at 100,200
+		segment(SegmentPageComposition, 5, 0x04, 3, 0, 0, 100, 0, 200),
+		// region 3 of 4x2, 4-bit, CLUT 1, object 7 at 0,0
+		segment(SegmentRegionComposition, 3, 0, 0, 4, 0, 2, 0x08, 1, 0, 0, 0, 7, 0, 0, 0, 0),
+		// CLUT 1, entry 1 of 4-bit opaque grey
+		segment(SegmentCLUTDefinition, 1, 0, 1, 0x41, 235, 128, 128, 0),
+		// object 7, top line 1, 1, 2, 0 and bottom line 4 of 3
+		segment(SegmentObjectData, 0, 7, 0,
+			0, 6, 0, 5,
+			0x11, 0x11, 0x20, 0xc0, 0x00, 0xf0,
+			0x11, 0x08, 0x30, 0xf0, 0),
+		segment(SegmentEndOfDisplaySet),
+	)
+	d := NewDecoder(0, 0)
+	sub, err := d.Decode(displaySet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sub == nil || sub.Width != 720 || sub.Height != 480 || sub.Timeout != 5e9 || len(sub.Regions) != 1 {
+		t.Fatalf("subtitle %+v", sub)
+	}
+	region := sub.Regions[0]
+	if region.X != 100 || region.Y != 200 {
+		t.Errorf("region at %d,%d", region.X, region.Y)
+	}
+	if want := []byte{1, 1, 2, 0, 3, 3, 3, 3}; !bytes.Equal(region.Image.Pix, want) {
+		t.Errorf("pixels %x, want %x", region.Image.Pix, want)
+	}
+	if c := region.Image.Palette[1]; c != (color.RGBA{235, 235, 235, 255}) {
+		t.Errorf("CLUT entry 1 %v, want grey", c)
+	}
+	if c := region.Image.Palette[2]; c != defaultCLUT().c4[2] {
+		t.Errorf("CLUT entry 2 %v, want the default", c)
+	}
+
+	// a page with no region clears the screen
+	if sub, err = d.Decode(pesPayload(segment(SegmentPageComposition, 5, 0), segment(SegmentEndOfDisplaySet))); err != nil {
+		t.Fatal(err)
+	}
+	if sub == nil || len(sub.Regions) != 0 {
+		t.Errorf("clear subtitle %+v", sub)
+	}
+
+	// segments of other pages are skipped
+	other := segment(SegmentEndOfDisplaySet)
+	other[3] = 2
+	if sub, err = NewDecoder(1, 0).Decode(pesPayload(other)); err != nil || sub != nil {
+		t.Errorf("other page: %+v %v", sub, err)
+	}
+}
+
+func TestDecodeMalformed(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"not subtitles", []byte{0x10, 0x00, 0x0f}},
+		{"segment truncated", pesPayload(segment(SegmentPageComposition, 5, 0))[:8]},
+		{"display short", pesPayload(segment(SegmentDisplayDefinition, 0, 2))},
+		{"page short", pesPayload(segment(SegmentPageComposition, 5))},
+		{"region short", pesPayload(segment(SegmentRegionComposition, 3, 0, 0, 4))},
+		{"region depth", pesPayload(segment(SegmentRegionComposition, 3, 0, 0, 4, 0, 2, 0x1c, 1, 0, 0))},
+		{"CLUT short", pesPayload(segment(SegmentCLUTDefinition, 1))},
+		{"object short", pesPayload(segment(SegmentObjectData, 0, 7, 0, 0))},
+		{"object data truncated", pesPayload(segment(SegmentObjectData, 0, 7, 0, 0, 6, 0, 5, 0x11))},
+	} {
+		if _, err := NewDecoder(0, 0).Decode(test.data); err == nil {
+			t.Errorf("%s: no error", test.name)
+		}
+	}
+
+	// entries and region objects cut short are left out
+	d := NewDecoder(0, 0)
+	_, err := d.Decode(pesPayload(
+		segment(SegmentPageComposition, 5, 0x04, 3, 0, 0, 0, 0),
+		segment(SegmentRegionComposition, 3, 0, 0, 4, 0, 2, 0x08, 1, 0, 0, 0, 7, 0x40, 0, 0, 0),
+		segment(SegmentCLUTDefinition, 1, 0, 1, 0x41, 235),
+		segment(SegmentObjectData, 0, 7, 0, 0, 2, 0, 0, 0x11, 0x11),
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := d.regions[3]; r == nil || len(r.objects) != 0 {
+		t.Errorf("region %+v", r)
+	}
+}
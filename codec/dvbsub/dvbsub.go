@@ -0,0 +1,71 @@
+// Package dvbsub holds the codec data of DVB subtitle streams (EN 300 743)
+// and decodes their bitmaps, for burning them into video.
+package dvbsub
+
+import (
+	"fmt"
+
+	"github.com/deepch/vdk/av"
+)
+
+// Subtitling types of the subtitling descriptor.
+const (
+	TypeNormal              = 0x10
+	TypeNormal4x3           = 0x11
+	TypeNormal16x9          = 0x12
+	TypeNormalHD            = 0x14
+	TypeHearingImpaired     = 0x20
+	TypeHearingImpaired4x3  = 0x21
+	TypeHearingImpaired16x9 = 0x22
+	TypeHearingImpairedHD   = 0x24
+)
+
+// Subtitling is an entry of the subtitling descriptor.
+type Subtitling struct {
+	Language          string
+	Type              uint8
+	CompositionPageID uint16
+	AncillaryPageID   uint16
+}
+
+// CodecData keeps the subtitling descriptor of the stream so it can be
+// written back when remuxing.
+type CodecData struct {
+	Descriptor []byte
+}
+
+func NewCodecData(descriptor []byte) CodecData {
+	return CodecData{Descriptor: append([]byte(nil), descriptor...)}
+}
+
+// NewCodecDataFromSubtitlings builds the descriptor from subtitlings.
+func NewCodecDataFromSubtitlings(subs []Subtitling) (self CodecData, err error) {
+	for _, sub := range subs {
+		if len(sub.Language) != 3 {
+			err = fmt.Errorf("dvbsub: language `%s` is not an ISO 639 code", sub.Language)
+			return
+		}
+		self.Descriptor = append(self.Descriptor, sub.Language...)
+		self.Descriptor = append(self.Descriptor, sub.Type,
+			byte(sub.CompositionPageID>>8), byte(sub.CompositionPageID),
+			byte(sub.AncillaryPageID>>8), byte(sub.AncillaryPageID))
+	}
+	return
+}
+
+func (self CodecData) Type() av.CodecType {
+	return av.DVB_SUBTITLE
+}
+
+func (self CodecData) Subtitlings() (subs []Subtitling) {
+	b := self.Descriptor
+	for ; len(b) >= 8; b = b[8:] {
+		subs = append(subs, Subtitling{
+			Language:          string(b[:3]),
+			Type:              b[3],
+			CompositionPageID: uint16(b[4])<<8 | uint16(b[5]),
+			AncillaryPageID:   uint16(b[6])<<8 | uint16(b[7]),
+		})
+	}
+	return
+}
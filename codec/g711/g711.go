@@ -0,0 +1,244 @@
+// Package g711 converts between 16-bit linear PCM and G.711 µ-law/A-law,
+// and provides an av.AudioEncoder packetizing at the sizes cameras expect.
+package g711
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"github.com/deepch/vdk/av"
+	"github.com/deepch/vdk/codec"
+)
+
+func LinearToUlaw(sample int16) byte {
+	const bias, clip = 0x84, 32635
+	v := int(sample)
+	mask := byte(0xff)
+	if v < 0 {
+		v = -v
+		mask = 0x7f
+	}
+	if v > clip {
+		v = clip
+	}
+	v += bias
+	seg := 0
+	for t := v >> 8; t > 0; t >>= 1 {
+		seg++
+	}
+	return (byte(seg<<4) | byte(v>>(seg+3))&0x0f) ^ mask
+}
+
+func UlawToLinear(u byte) int16 {
+	const bias = 0x84
+	u = ^u
+	t := (int(u&0x0f) << 3) + bias
+	t <<= (u & 0x70) >> 4
+	if u&0x80 != 0 {
+		return int16(bias - t)
+	}
+	return int16(t - bias)
+}
+
+func LinearToAlaw(sample int16) byte {
+	v := int(sample)
+	mask := byte(0xd5)
+	if v < 0 {
+		v = -v - 1
+		mask = 0x55
+	}
+	// 13-bit magnitude, segments end at 0x1f, 0x3f, ... 0xfff
+	v >>= 3
+	seg := 0
+	for t := v >> 5; t > 0; t >>= 1 {
+		seg++
+	}
+	if seg >= 8 {
+		return 0x7f ^ mask
+	}
+	aval := byte(seg << 4)
+	if seg < 2 {
+		aval |= byte(v>>1) & 0x0f
+	} else {
+		aval |= byte(v>>seg) & 0x0f
+	}
+	return aval ^ mask
+}
+
+func AlawToLinear(a byte) int16 {
+	a ^= 0x55
+	t := int(a&0x0f) << 4
+	seg := int(a&0x70) >> 4
+	switch seg {
+	case 0:
+		t += 8
+	case 1:
+		t += 0x108
+	default:
+		t += 0x108
+		t <<= seg - 1
+	}
+	if a&0x80 != 0 {
+		return int16(t)
+	}
+	return int16(-t)
+}
+
+const DefaultPacketDuration = 20 * time.Millisecond
+
+// AudioEncoder encodes S16 frames into G.711 packets of PacketDuration.
+// Stereo input is averaged to mono, input rates which are a multiple of
+// 8000 are decimated, so decoded WebRTC audio can go to a camera backchannel.
+type AudioEncoder struct {
+	CodecType      av.CodecType // PCM_MULAW or PCM_ALAW
+	PacketDuration time.Duration
+	buf            []byte
+
+	// input samples of the next output one, carried over frames whose
+	// sample count is not a multiple of the decimation step
+	step, carried, sum int
+}
+
+func NewAudioEncoder(typ av.CodecType) (self *AudioEncoder, err error) {
+	if typ != av.PCM_MULAW && typ != av.PCM_ALAW {
+		err = fmt.Errorf("g711: codec %s not supported", typ)
+		return
+	}
+	self = &AudioEncoder{CodecType: typ, PacketDuration: DefaultPacketDuration}
+	return
+}
+
+func (self *AudioEncoder) CodecData() (av.AudioCodecData, error) {
+	if self.CodecType == av.PCM_ALAW {
+		return codec.NewPCMAlawCodecData(), nil
+	}
+	return codec.NewPCMMulawCodecData(), nil
+}
+
+func (self *AudioEncoder) SetSampleRate(rate int) (err error) {
+	if rate%8000 != 0 || rate == 0 {
+		err = fmt.Errorf("g711: sample rate %d not supported", rate)
+	}
+	return
+}
+
+func (self *AudioEncoder) SetChannelLayout(ch av.ChannelLayout) (err error) {
+	if ch.Count() == 0 {
+		err = fmt.Errorf("g711: channel layout %s not supported", ch)
+	}
+	return
+}
+
+func (self *AudioEncoder) SetSampleFormat(sampleFormat av.SampleFormat) (err error) {
+	if sampleFormat != av.S16 && sampleFormat != av.S16P {
+		err = fmt.Errorf("g711: sample format %s not supported", sampleFormat)
+	}
+	return
+}
+
+func (self *AudioEncoder) SetBitrate(bitrate int) (err error) {
+	if bitrate != 64000 {
+		err = fmt.Errorf("g711: bitrate %d not supported", bitrate)
+	}
+	return
+}
+
+func (self *AudioEncoder) SetOption(key string, val interface{}) (err error) {
+	switch key {
+	case "packet_duration":
+		d, ok := val.(time.Duration)
+		if !ok || d < time.Millisecond {
+			err = fmt.Errorf("g711: packet_duration must be a time.Duration >= 1ms")
+			return
+		}
+		self.PacketDuration = d
+	default:
+		err = fmt.Errorf("g711: option `%s` not supported", key)
+	}
+	return
+}
+
+func (self *AudioEncoder) GetOption(key string, val interface{}) (err error) {
+	switch key {
+	case "packet_duration":
+		p, ok := val.(*time.Duration)
+		if !ok {
+			err = fmt.Errorf("g711: packet_duration receiver must be *time.Duration")
+			return
+		}
+		*p = self.PacketDuration
+	default:
+		err = fmt.Errorf("g711: option `%s` not supported", key)
+	}
+	return
+}
+
+func (self *AudioEncoder) packetSize() int {
+	d := self.PacketDuration
+	if d == 0 {
+		d = DefaultPacketDuration
+	}
+	return int(d * 8000 / time.Second)
+}
+
+func (self *AudioEncoder) Encode(frame av.AudioFrame) (pkts [][]byte, err error) {
+	if frame.SampleFormat != av.S16 && frame.SampleFormat != av.S16P {
+		err = fmt.Errorf("g711: sample format %s not supported", frame.SampleFormat)
+		return
+	}
+	if frame.SampleRate%8000 != 0 || frame.SampleRate == 0 {
+		err = fmt.Errorf("g711: sample rate %d not supported", frame.SampleRate)
+		return
+	}
+	channels := frame.ChannelLayout.Count()
+	if channels == 0 {
+		channels = 1
+	}
+	sample := func(n, c int) int {
+		if frame.SampleFormat == av.S16P {
+			return int(int16(binary.LittleEndian.Uint16(frame.Data[c][n*2:])))
+		}
+		return int(int16(binary.LittleEndian.Uint16(frame.Data[0][(n*channels+c)*2:])))
+	}
+
+	if step := frame.SampleRate / 8000; step != self.step {
+		self.step, self.carried, self.sum = step, 0, 0
+	}
+	for n := 0; n < frame.SampleCount; n++ {
+		for c := 0; c < channels; c++ {
+			self.sum += sample(n, c)
+		}
+		if self.carried++; self.carried < self.step {
+			continue
+		}
+		v := int16(self.sum / (self.step * channels))
+		self.carried, self.sum = 0, 0
+		if self.CodecType == av.PCM_ALAW {
+			self.buf = append(self.buf, LinearToAlaw(v))
+		} else {
+			self.buf = append(self.buf, LinearToUlaw(v))
+		}
+	}
+
+	size := self.packetSize()
+	for len(self.buf) >= size {
+		pkts = append(pkts, append([]byte(nil), self.buf[:size]...))
+		self.buf = self.buf[size:]
+	}
+	return
+}
+
+// Flush returns the samples left, shorter than a packet. Input samples short
+// of a whole output one are dropped.
+func (self *AudioEncoder) Flush() (pkt []byte) {
+	pkt = self.buf
+	self.buf = nil
+	self.carried, self.sum = 0, 0
+	return
+}
+
+func (self *AudioEncoder) Close() {
+	self.buf = nil
+	self.carried, self.sum = 0, 0
+}
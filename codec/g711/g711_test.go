@@ -0,0 +1,84 @@
+package g711
+
+import (
+	"testing"
+
+	"github.com/deepch/vdk/av"
+)
+
+func TestRoundTrip(t *testing.T) {
+	if LinearToUlaw(0) != 0xff || LinearToAlaw(0) != 0xd5 {
+		t.Fatalf("silence encodes to %x %x", LinearToUlaw(0), LinearToAlaw(0))
+	}
+	for _, v := range []int16{1, -1, 100, -100, 1000, -1000, 10000, -10000, 32767, -32768} {
+		for _, c := range []struct {
+			name string
+			out  int16
+		}{
+			{"ulaw", UlawToLinear(LinearToUlaw(v))},
+			{"alaw", AlawToLinear(LinearToAlaw(v))},
+		} {
+			diff := int(c.out) - int(v)
+			if diff < 0 {
+				diff = -diff
+			}
+			max := int(v) / 16
+			if max < 0 {
+				max = -max
+			}
+			if max < 16 {
+				max = 16
+			}
+			if diff > max {
+				t.Errorf("%s %d decoded as %d", c.name, v, c.out)
+			}
+		}
+	}
+}
+
+func TestEncoderSetters(t *testing.T) {
+	enc, err := NewAudioEncoder(av.PCM_MULAW)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, rate := range []int{8000, 16000, 48000} {
+		if err := enc.SetSampleRate(rate); err != nil {
+			t.Error(err)
+		}
+	}
+	if err := enc.SetSampleRate(44100); err == nil {
+		t.Error("44100 accepted")
+	}
+	if err := enc.SetChannelLayout(av.CH_STEREO); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestEncoderCarriesSamples(t *testing.T) {
+	enc, err := NewAudioEncoder(av.PCM_MULAW)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 48kHz frames of 1001 samples, not a multiple of the step of 6
+	var total int
+	for i := 0; i < 48; i++ {
+		frame := av.AudioFrame{
+			SampleFormat:  av.S16,
+			SampleRate:    48000,
+			ChannelLayout: av.CH_MONO,
+			SampleCount:   1001,
+			Data:          [][]byte{make([]byte, 1001*2)},
+		}
+		pkts, err := enc.Encode(frame)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, pkt := range pkts {
+			total += len(pkt)
+		}
+	}
+	total += len(enc.Flush())
+	if want := 48 * 1001 / 6; total != want {
+		t.Errorf("%d samples out, want %d", total, want)
+	}
+}
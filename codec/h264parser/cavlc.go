@@ -0,0 +1,259 @@
+package h264parser
+
+import (
+	"fmt"
+)
+
+// vlc maps the codes of a table of variable length codes to the index of
+// their length.
+type vlc struct {
+	codes  map[uint32]int
+	maxLen int
+}
+
+func newVLC(lens []uint8, codes []uint8) (self vlc) {
+	self.codes = map[uint32]int{}
+	for i, n := range lens {
+		if n == 0 {
+			continue
+		}
+		self.codes[uint32(n)<<16|uint32(codes[i])] = i
+		if int(n) > self.maxLen {
+			self.maxLen = int(n)
+		}
+	}
+	return
+}
+
+func (self vlc) read(r *bitReader) (v int, err error) {
+	var code uint
+	for n := 1; n <= self.maxLen; n++ {
+		var bit uint
+		if bit, err = r.ReadBit(); err != nil {
+			return
+		}
+		code = code<<1 | bit
+		var ok bool
+		if v, ok = self.codes[uint32(n)<<16|uint32(code)]; ok {
+			return
+		}
+	}
+	err = fmt.Errorf("h264parser: invalid cavlc code")
+	return
+}
+
+// coeff_token tables for 0 <= nC < 2, 2 <= nC < 4 and 4 <= nC < 8, indexed
+// by TotalCoeff*4 + TrailingOnes.
+var coeffTokens = [3]vlc{
+	newVLC([]uint8{
+		1, 0, 0, 0, 6, 2, 0, 0, 8, 6, 3, 0, 9, 8, 7, 5,
+		10, 9, 8, 6, 11, 10, 9, 7, 13, 11, 10, 8, 13, 13, 11, 9,
+		13, 13, 13, 10, 14, 14, 13, 11, 14, 14, 14, 13, 15, 15, 14, 14,
+		15, 15, 15, 14, 16, 16, 15, 15, 16, 16, 16, 15, 16, 16, 16, 16,
+		16, 16, 16, 16,
+	}, []uint8{
+		1, 0, 0, 0, 5, 1, 0, 0, 7, 4, 1, 0, 7, 6, 5, 3,
+		7, 6, 5, 3, 7, 6, 5, 4, 15, 6, 5, 4, 11, 14, 5, 4,
+		8, 10, 13, 4, 15, 14, 9, 4, 11, 10, 13, 12, 15, 14, 9, 12,
+		11, 10, 13, 8, 15, 1, 9, 12, 11, 14, 13, 8, 7, 10, 9, 12,
+		4, 6, 5, 8,
+	}),
+	newVLC([]uint8{
+		2, 0, 0, 0, 6, 2, 0, 0, 6, 5, 3, 0, 7, 6, 6, 4,
+		8, 6, 6, 4, 8, 7, 7, 5, 9, 8, 8, 6, 11, 9, 9, 6,
+		11, 11, 11, 7, 12, 11, 11, 9, 12, 12, 12, 11, 12, 12, 12, 11,
+		13, 13, 13, 12, 13, 13, 13, 13, 13, 14, 13, 13, 14, 14, 14, 13,
+		14, 14, 14, 14,
+	}, []uint8{
+		3, 0, 0, 0, 11, 2, 0, 0, 7, 7, 3, 0, 7, 10, 9, 5,
+		7, 6, 5, 4, 4, 6, 5, 6, 7, 6, 5, 8, 15, 6, 5, 4,
+		11, 14, 13, 4, 15, 10, 9, 4, 11, 14, 13, 12, 8, 10, 9, 8,
+		15, 14, 13, 12, 11, 10, 9, 12, 7, 11, 6, 8, 9, 8, 10, 1,
+		7, 6, 5, 4,
+	}),
+	newVLC([]uint8{
+		4, 0, 0, 0, 6, 4, 0, 0, 6, 5, 4, 0, 6, 5, 5, 4,
+		7, 5, 5, 4, 7, 5, 5, 4, 7, 6, 6, 4, 7, 6, 6, 4,
+		8, 7, 7, 5, 8, 8, 7, 6, 9, 8, 8, 7, 9, 9, 8, 8,
+		9, 9, 9, 8, 10, 9, 9, 9, 10, 10, 10, 10, 10, 10, 10, 10,
+		10, 10, 10, 10,
+	}, []uint8{
+		15, 0, 0, 0, 15, 14, 0, 0, 11, 15, 13, 0, 8, 12, 14, 12,
+		15, 10, 11, 11, 11, 8, 9, 10, 9, 14, 13, 9, 8, 10, 9, 8,
+		15, 14, 13, 13, 11, 14, 10, 12, 15, 10, 13, 12, 11, 14, 9, 12,
+		8, 10, 13, 8, 13, 7, 9, 12, 9, 12, 11, 10, 5, 8, 7, 6,
+		1, 4, 3, 2,
+	}),
+}
+
+// coeff_token table of the chroma DC blocks, nC = -1.
+var chromaDCCoeffToken = newVLC([]uint8{
+	2, 0, 0, 0, 6, 1, 0, 0, 6, 6, 3, 0, 6, 7, 7, 6, 6, 8, 8, 7,
+}, []uint8{
+	1, 0, 0, 0, 7, 1, 0, 0, 4, 6, 1, 0, 3, 3, 2, 5, 2, 3, 2, 0,
+})
+
+// total_zeros tables of the 4x4 blocks, by TotalCoeff from 1.
+var totalZeros = [15]vlc{
+	newVLC([]uint8{1, 3, 3, 4, 4, 5, 5, 6, 6, 7, 7, 8, 8, 9, 9, 9}, []uint8{1, 3, 2, 3, 2, 3, 2, 3, 2, 3, 2, 3, 2, 3, 2, 1}),
+	newVLC([]uint8{3, 3, 3, 3, 3, 4, 4, 4, 4, 5, 5, 6, 6, 6, 6}, []uint8{7, 6, 5, 4, 3, 5, 4, 3, 2, 3, 2, 3, 2, 1, 0}),
+	newVLC([]uint8{4, 3, 3, 3, 4, 4, 3, 3, 4, 5, 5, 6, 5, 6}, []uint8{5, 7, 6, 5, 4, 3, 4, 3, 2, 3, 2, 1, 1, 0}),
+	newVLC([]uint8{5, 3, 4, 4, 3, 3, 3, 4, 3, 4, 5, 5, 5}, []uint8{3, 7, 5, 4, 6, 5, 4, 3, 3, 2, 2, 1, 0}),
+	newVLC([]uint8{4, 4, 4, 3, 3, 3, 3, 3, 4, 5, 4, 5}, []uint8{5, 4, 3, 7, 6, 5, 4, 3, 2, 1, 1, 0}),
+	newVLC([]uint8{6, 5, 3, 3, 3, 3, 3, 3, 4, 3, 6}, []uint8{1, 1, 7, 6, 5, 4, 3, 2, 1, 1, 0}),
+	newVLC([]uint8{6, 5, 3, 3, 3, 2, 3, 4, 3, 6}, []uint8{1, 1, 5, 4, 3, 3, 2, 1, 1, 0}),
+	newVLC([]uint8{6, 4, 5, 3, 2, 2, 3, 3, 6}, []uint8{1, 1, 1, 3, 3, 2, 2, 1, 0}),
+	newVLC([]uint8{6, 6, 4, 2, 2, 3, 2, 5}, []uint8{1, 0, 1, 3, 2, 1, 1, 1}),
+	newVLC([]uint8{5, 5, 3, 2, 2, 2, 4}, []uint8{1, 0, 1, 3, 2, 1, 1}),
+	newVLC([]uint8{4, 4, 3, 3, 1, 3}, []uint8{0, 1, 1, 2, 1, 3}),
+	newVLC([]uint8{4, 4, 2, 1, 3}, []uint8{0, 1, 1, 1, 1}),
+	newVLC([]uint8{3, 3, 1, 2}, []uint8{0, 1, 1, 1}),
+	newVLC([]uint8{2, 2, 1}, []uint8{0, 1, 1}),
+	newVLC([]uint8{1, 1}, []uint8{0, 1}),
+}
+
+// total_zeros tables of the chroma DC blocks, by TotalCoeff from 1.
+var chromaDCTotalZeros = [3]vlc{
+	newVLC([]uint8{1, 2, 3, 3}, []uint8{1, 1, 1, 0}),
+	newVLC([]uint8{1, 2, 2}, []uint8{1, 1, 0}),
+	newVLC([]uint8{1, 1}, []uint8{1, 0}),
+}
+
+// run_before tables, by zerosLeft from 1, the last one for more than 6.
+var runBefore = [7]vlc{
+	newVLC([]uint8{1, 1}, []uint8{1, 0}),
+	newVLC([]uint8{1, 2, 2}, []uint8{1, 1, 0}),
+	newVLC([]uint8{2, 2, 2, 2}, []uint8{3, 2, 1, 0}),
+	newVLC([]uint8{2, 2, 2, 3, 3}, []uint8{3, 2, 1, 1, 0}),
+	newVLC([]uint8{2, 2, 3, 3, 3, 3}, []uint8{3, 2, 3, 2, 1, 0}),
+	newVLC([]uint8{2, 3, 3, 3, 3, 3, 3}, []uint8{3, 0, 1, 3, 2, 5, 4}),
+	newVLC([]uint8{3, 3, 3, 3, 3, 3, 3, 4, 5, 6, 7, 8, 9, 10, 11}, []uint8{7, 6, 5, 4, 3, 2, 1, 1, 1, 1, 1, 1, 1, 1, 1}),
+}
+
+// block reads a residual_block_cavlc and returns its TotalCoeff, the
+// levels and runs are skipped.
+func (self *cavlcSlice) block(nC int, maxCoeff int) (total int, err error) {
+	r := self.r
+	var token int
+	switch {
+	case nC == -1:
+		token, err = chromaDCCoeffToken.read(r)
+	case nC < 2:
+		token, err = coeffTokens[0].read(r)
+	case nC < 4:
+		token, err = coeffTokens[1].read(r)
+	case nC < 8:
+		token, err = coeffTokens[2].read(r)
+	default:
+		// 6 bits fixed length, 000011 for no coefficient
+		var code uint
+		if code, err = r.ReadBits(6); err != nil {
+			return
+		}
+		if code != 3 {
+			token = int(code>>2+1)<<2 | int(code&3)
+		}
+	}
+	if err != nil {
+		return
+	}
+	total = token >> 2
+	trailingOnes := token & 3
+	if total == 0 {
+		return
+	}
+	if total > maxCoeff || trailingOnes > total {
+		err = fmt.Errorf("h264parser: coeff_token invalid")
+		return
+	}
+
+	suffixLength := 0
+	if total > 10 && trailingOnes < 3 {
+		suffixLength = 1
+	}
+	for i := 0; i < total; i++ {
+		if i < trailingOnes {
+			// trailing_ones_sign_flag
+			if _, err = r.ReadBit(); err != nil {
+				return
+			}
+			continue
+		}
+		prefix := 0
+		for {
+			var bit uint
+			if bit, err = r.ReadBit(); err != nil {
+				return
+			}
+			if bit != 0 {
+				break
+			}
+			if prefix++; prefix > 32 {
+				err = fmt.Errorf("h264parser: level_prefix invalid")
+				return
+			}
+		}
+		levelCode := prefix
+		if levelCode > 15 {
+			levelCode = 15
+		}
+		levelCode <<= uint(suffixLength)
+		if suffixLength > 0 || prefix >= 14 {
+			size := suffixLength
+			if prefix >= 15 {
+				size = prefix - 3
+			} else if suffixLength == 0 {
+				size = 4
+			}
+			var suffix uint
+			if suffix, err = r.ReadBits(size); err != nil {
+				return
+			}
+			levelCode += int(suffix)
+		}
+		if prefix >= 15 && suffixLength == 0 {
+			levelCode += 15
+		}
+		if prefix >= 16 {
+			levelCode += 1<<uint(prefix-3) - 4096
+		}
+		if i == trailingOnes && trailingOnes < 3 {
+			levelCode += 2
+		}
+		level := (levelCode + 2) >> 1
+		if suffixLength == 0 {
+			suffixLength = 1
+		}
+		if level > 3<<uint(suffixLength-1) && suffixLength < 6 {
+			suffixLength++
+		}
+	}
+
+	zeros := 0
+	if total < maxCoeff {
+		if maxCoeff == 4 {
+			zeros, err = chromaDCTotalZeros[total-1].read(r)
+		} else {
+			zeros, err = totalZeros[total-1].read(r)
+		}
+		if err != nil {
+			return
+		}
+	}
+	for i := 0; i < total-1 && zeros > 0; i++ {
+		n := zeros
+		if n > 7 {
+			n = 7
+		}
+		var run int
+		if run, err = runBefore[n-1].read(r); err != nil {
+			return
+		}
+		if run > zeros {
+			err = fmt.Errorf("h264parser: run_before invalid")
+			return
+		}
+		zeros -= run
+	}
+	return
+}
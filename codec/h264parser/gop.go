@@ -0,0 +1,128 @@
+package h264parser
+
+import (
+	"time"
+
+	"github.com/deepch/vdk/av"
+)
+
+// MaxGOPPattern bounds GOP.Pattern.
+const MaxGOPPattern = 64
+
+// GOP models the structure of a video stream as seen so far.
+type GOP struct {
+	GOPs         int           // complete GOPs seen
+	Length       int           // frames of the last complete GOP
+	Duration     time.Duration // of the last complete GOP
+	Fixed        bool          // the complete GOPs all had the same length
+	BFrames      bool          // frames come out of decode order
+	ReferenceB   bool          // B frames are referenced, as in a pyramid
+	ReorderDepth int           // most frames decoded ahead of one presented before them
+	Disposable   int           // non reference frames of the last complete GOP
+	Pattern      string        // frame types of the last complete GOP in decode order, as IPBBPBB
+}
+
+// FrameDuration is the average frame duration of the last complete GOP.
+func (self GOP) FrameDuration() time.Duration {
+	if self.Length == 0 {
+		return 0
+	}
+	return self.Duration / time.Duration(self.Length)
+}
+
+// ReorderDelay is the latency the frame reordering adds to decoding.
+func (self GOP) ReorderDelay() time.Duration {
+	return time.Duration(self.ReorderDepth) * self.FrameDuration()
+}
+
+// GOPAnalyzer builds the GOP of one video stream from its packets, the B
+// frames told by their presentation time coming before that of a frame
+// decoded earlier.
+type GOPAnalyzer struct {
+	// FrameFlags classifies frames whose packet has no reference flag,
+	// FrameFlags of this package by NewGOPAnalyzer.
+	FrameFlags func([]byte) av.PacketFlags
+
+	gop        GOP
+	started    bool
+	start      time.Duration
+	length     int
+	disposable int
+	pattern    []byte
+	recent     []time.Duration // presentation times of the last frames
+}
+
+func NewGOPAnalyzer() *GOPAnalyzer {
+	return &GOPAnalyzer{FrameFlags: FrameFlags}
+}
+
+// Observe takes the next video packet in decode order.
+func (self *GOPAnalyzer) Observe(pkt av.Packet) {
+	if pkt.IsKeyFrame {
+		if self.started {
+			self.endGOP(pkt.Time)
+		}
+		self.started = true
+		self.start = pkt.Time
+	}
+
+	pts := pkt.Time + pkt.CompositionTime
+	reordered := 0
+	for _, t := range self.recent {
+		if t > pts {
+			reordered++
+		}
+	}
+	if reordered > self.gop.ReorderDepth {
+		self.gop.ReorderDepth = reordered
+	}
+	self.recent = append(self.recent, pts)
+	if len(self.recent) > 16 {
+		self.recent = self.recent[1:]
+	}
+
+	if !self.started {
+		return
+	}
+	flags := pkt.Flags
+	if flags&(av.PacketReference|av.PacketDisposable) == 0 && self.FrameFlags != nil {
+		flags = self.FrameFlags(pkt.Data)
+	}
+	typ := byte('P')
+	switch {
+	case pkt.IsKeyFrame:
+		typ = 'I'
+	case reordered > 0:
+		typ = 'B'
+		self.gop.BFrames = true
+		if flags&av.PacketReference != 0 {
+			self.gop.ReferenceB = true
+		}
+	}
+	if flags&av.PacketDisposable != 0 {
+		self.disposable++
+	}
+	self.length++
+	if len(self.pattern) < MaxGOPPattern {
+		self.pattern = append(self.pattern, typ)
+	}
+}
+
+func (self *GOPAnalyzer) endGOP(next time.Duration) {
+	gop := &self.gop
+	gop.Fixed = gop.GOPs == 0 || gop.Fixed && gop.Length == self.length
+	gop.GOPs++
+	gop.Length = self.length
+	gop.Duration = next - self.start
+	gop.Disposable = self.disposable
+	gop.Pattern = string(self.pattern)
+	self.length = 0
+	self.disposable = 0
+	self.pattern = self.pattern[:0]
+}
+
+// GOP returns the model, its GOP fields are zero before the second key
+// frame.
+func (self *GOPAnalyzer) GOP() GOP {
+	return self.gop
+}
@@ -0,0 +1,108 @@
+package h264parser
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/deepch/vdk/av"
+)
+
+// gopPackets makes the packets of frames in decode order, each a frame type
+// and its presentation order as in I0 P3 b1 b2: I a key frame, upper case a
+// reference frame and lower case a disposable one. Without flags the
+// reference is only told by the nal_ref_idc of the slice.
+func gopPackets(frames string, flags bool) (pkts []av.Packet) {
+	const frame = 40 * time.Millisecond
+	for i, f := range strings.Fields(frames) {
+		pts, _ := strconv.Atoi(f[1:])
+		pkt := av.Packet{
+			IsKeyFrame:      f[0] == 'I',
+			Time:            time.Duration(i) * frame,
+			CompositionTime: time.Duration(pts-i+2) * frame,
+		}
+		nalu := []byte{0x41, 0x9a, 0x02}
+		if f[0] == 'I' {
+			nalu = []byte{0x65, 0x88, 0x84}
+		}
+		if f[0] >= 'a' {
+			nalu[0] &= 0x1f
+			if flags {
+				pkt.Flags = av.PacketDisposable
+			}
+		} else if flags {
+			pkt.Flags = av.PacketReference
+		}
+		pkt.Data = AppendNALU(nil, nalu, NALU_AVCC)
+		pkts = append(pkts, pkt)
+	}
+	return
+}
+
+func TestGOPAnalyzer(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		frames string
+		flags  bool
+		want   GOP
+	}{
+		{"empty", "", true, GOP{}},
+		{"one gop", "I0 P1 P2 P3", true, GOP{}},
+		{"ip", "I0 P1 P2 P3 P4 I5 P6 P7 P8 P9 I10 P11", true,
+			GOP{GOPs: 2, Length: 5, Duration: 200 * time.Millisecond, Fixed: true, Pattern: "IPPPP"}},
+		{"before the first key frame", "P0 P1 I2 P3 P4 I5 P6 P7 I8", true,
+			GOP{GOPs: 2, Length: 3, Duration: 120 * time.Millisecond, Fixed: true, Pattern: "IPP"}},
+		{"variable", "I0 P1 P2 I3 P4 I5 P6 P7 I8", true,
+			GOP{GOPs: 3, Length: 3, Duration: 120 * time.Millisecond, Pattern: "IPP"}},
+		{"ibbp", "I0 P3 b1 b2 P6 b4 b5 I9 P12 b10 b11", true,
+			GOP{GOPs: 1, Length: 7, Duration: 280 * time.Millisecond, Fixed: true, BFrames: true, ReorderDepth: 1, Disposable: 4, Pattern: "IPBBPBB"}},
+		{"open gop", "I2 b0 b1 P5 b3 b4 I8 b6 b7", true,
+			GOP{GOPs: 1, Length: 6, Duration: 240 * time.Millisecond, Fixed: true, BFrames: true, ReorderDepth: 1, Disposable: 4, Pattern: "IBBPBB"}},
+		{"pyramid", "I0 P8 B4 B2 b1 b3 B6 b5 b7 I16", true,
+			GOP{GOPs: 1, Length: 9, Duration: 360 * time.Millisecond, Fixed: true, BFrames: true, ReferenceB: true, ReorderDepth: 3, Disposable: 4, Pattern: "IPBBBBBBB"}},
+		{"flags from the frames", "I0 P3 b1 b2 P6 B4 b5 I9", false,
+			GOP{GOPs: 1, Length: 7, Duration: 280 * time.Millisecond, Fixed: true, BFrames: true, ReferenceB: true, ReorderDepth: 1, Disposable: 3, Pattern: "IPBBPBB"}},
+		{"disposable p", "I0 P1 p2 P3 p4 I5", true,
+			GOP{GOPs: 1, Length: 5, Duration: 200 * time.Millisecond, Fixed: true, Disposable: 2, Pattern: "IPPPP"}},
+	} {
+		analyzer := NewGOPAnalyzer()
+		for _, pkt := range gopPackets(test.frames, test.flags) {
+			analyzer.Observe(pkt)
+		}
+		if gop := analyzer.GOP(); gop != test.want {
+			t.Errorf("%s:\n%+v\nwant\n%+v", test.name, gop, test.want)
+		}
+	}
+}
+
+func TestGOPAnalyzerPattern(t *testing.T) {
+	frames := "I0"
+	for i := 1; i < 100; i++ {
+		frames += " P" + strconv.Itoa(i)
+	}
+	analyzer := NewGOPAnalyzer()
+	for _, pkt := range gopPackets(frames+" I100", true) {
+		analyzer.Observe(pkt)
+	}
+	gop := analyzer.GOP()
+	if gop.Length != 100 || len(gop.Pattern) != MaxGOPPattern || gop.Pattern != "I"+strings.Repeat("P", MaxGOPPattern-1) {
+		t.Errorf("length %d, pattern %s", gop.Length, gop.Pattern)
+	}
+}
+
+func TestGOPDurations(t *testing.T) {
+	for _, test := range []struct {
+		gop            GOP
+		frame, reorder time.Duration
+	}{
+		{GOP{}, 0, 0},
+		{GOP{Length: 25, Duration: time.Second}, 40 * time.Millisecond, 0},
+		{GOP{Length: 25, Duration: time.Second, ReorderDepth: 2}, 40 * time.Millisecond, 80 * time.Millisecond},
+		{GOP{Length: 30, Duration: time.Second, ReorderDepth: 1}, 33333333, 33333333},
+	} {
+		if frame, reorder := test.gop.FrameDuration(), test.gop.ReorderDelay(); frame != test.frame || reorder != test.reorder {
+			t.Errorf("%+v: frame %v reorder delay %v", test.gop, frame, reorder)
+		}
+	}
+}
@@ -0,0 +1,441 @@
+package h264parser
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/deepch/vdk/utils/bits"
+)
+
+// bitReader is a bits.GolombBitReader over an RBSP which knows its
+// position, which more_rbsp_data needs.
+type bitReader struct {
+	bits.GolombBitReader
+	rd   *bytes.Reader
+	size int
+	stop int // position of rbsp_stop_one_bit
+}
+
+func newBitReader(b []byte) *bitReader {
+	rd := bytes.NewReader(b)
+	self := &bitReader{GolombBitReader: bits.GolombBitReader{R: rd}, rd: rd, size: len(b), stop: len(b) * 8}
+	for i := len(b) - 1; i >= 0; i-- {
+		if b[i] != 0 {
+			n := 0
+			for b[i]>>uint(n)&1 == 0 {
+				n++
+			}
+			self.stop = i*8 + 7 - n
+			break
+		}
+	}
+	return self
+}
+
+// pos is the position of the next bit in bits.
+func (self *bitReader) pos() int {
+	return (self.size-self.rd.Len())*8 - self.BitsLeft()
+}
+
+func (self *bitReader) moreData() bool {
+	return self.pos() < self.stop
+}
+
+// skipBytes skips n bytes from the next byte boundary.
+func (self *bitReader) skipBytes(n int) (err error) {
+	self.Align()
+	if self.rd.Len() < n {
+		return io.EOF
+	}
+	_, err = self.rd.Seek(int64(n), io.SeekCurrent)
+	return
+}
+
+// Macroblock sums up a macroblock as ParseSliceData reads it, without
+// reconstructing it.
+type Macroblock struct {
+	Addr   int
+	Skip   bool
+	Intra  bool
+	Type   uint // mb_type as coded, P slices number intra types from 5
+	CBP    uint // coded_block_pattern, luma in the low 4 bits then chroma
+	Parts  int  // motion partitions, from 1 for 16x16 to 16 for 4x4, 0 if intra
+	Motion int  // sum of the absolute motion vector differences, in quarter samples
+	Coeffs int  // non zero coefficients
+	Bits   int
+}
+
+var (
+	intraCBP = [48]uint8{
+		47, 31, 15, 0, 23, 27, 29, 30, 7, 11, 13, 14, 39, 43, 45, 46,
+		16, 3, 5, 10, 12, 19, 21, 26, 28, 35, 37, 42, 44, 1, 2, 4,
+		8, 17, 18, 20, 24, 6, 9, 22, 25, 32, 33, 34, 36, 40, 38, 41,
+	}
+	interCBP = [48]uint8{
+		0, 16, 1, 2, 4, 8, 32, 3, 5, 10, 12, 15, 47, 7, 11, 13,
+		14, 6, 9, 31, 35, 37, 42, 44, 33, 34, 36, 40, 39, 43, 45, 46,
+		17, 18, 20, 24, 19, 21, 26, 28, 23, 27, 29, 30, 22, 25, 38, 41,
+	}
+	subMbParts = [4]int{1, 2, 2, 4}
+)
+
+// ParseSliceData reads a slice macroblock by macroblock, which tells where
+// the encoder found the picture changed without decoding it. Only CAVLC I
+// and P slices of progressive 8 bit 4:2:0 streams are supported, as those
+// of the baseline profile.
+func ParseSliceData(nalu []byte, sps SPSInfo, pps PPSInfo) (h SliceHeader, mbs []Macroblock, err error) {
+	if len(nalu) <= 1 || !IsDataNALU(nalu) {
+		err = fmt.Errorf("h264parser: nalu has no slice header")
+		return
+	}
+	if pps.EntropyCodingMode != 0 || pps.NumSliceGroups > 1 || sps.FrameMbsOnly == 0 ||
+		sps.ChromaFormatIdc != 1 || sps.ProfileIdc >= 100 {
+		err = fmt.Errorf("h264parser: slice data of profile %d not supported", sps.ProfileIdc)
+		return
+	}
+	r := newBitReader(EBSPToRBSP(nalu[1:]))
+	var sliceType, numRefIdxL0 uint
+	if h, sliceType, numRefIdxL0, err = parseSliceHeader(r, nalu[0], sps, pps); err != nil {
+		return
+	}
+	if h.Type == SLICE_B || sliceType%5 > 2 {
+		err = fmt.Errorf("h264parser: slice_type=%d not supported", sliceType)
+		return
+	}
+	if pps.DeblockingFilterControlPresent != 0 {
+		var idc uint
+		if idc, err = r.ReadExponentialGolombCode(); err != nil {
+			return
+		}
+		if idc != 1 {
+			// slice_alpha_c0_offset_div2, slice_beta_offset_div2
+			if err = readUEs(r, 2); err != nil {
+				return
+			}
+		}
+	}
+
+	total := int(sps.MbWidth * sps.MbHeight)
+	addr := int(h.FirstMb)
+	if addr >= total {
+		err = fmt.Errorf("h264parser: first_mb_in_slice=%d invalid", addr)
+		return
+	}
+	s := &cavlcSlice{
+		r:      r,
+		width:  int(sps.MbWidth),
+		first:  addr,
+		totals: make([]uint8, (total-addr)*24),
+	}
+	for more := true; more; {
+		if h.Type == SLICE_P {
+			var run uint
+			if run, err = r.ReadExponentialGolombCode(); err != nil {
+				return
+			}
+			if int(run) > total-addr {
+				err = fmt.Errorf("h264parser: mb_skip_run=%d invalid", run)
+				return
+			}
+			for i := 0; i < int(run); i++ {
+				mbs = append(mbs, Macroblock{Addr: addr, Skip: true})
+				addr++
+			}
+			if run > 0 {
+				more = r.moreData()
+			}
+		}
+		if more {
+			if addr >= total {
+				err = fmt.Errorf("h264parser: slice data past the last macroblock")
+				return
+			}
+			var mb Macroblock
+			if mb, err = s.macroblock(addr, h.Type, numRefIdxL0); err != nil {
+				return
+			}
+			mbs = append(mbs, mb)
+			addr++
+			more = r.moreData()
+		}
+	}
+	return
+}
+
+// cavlcSlice keeps the number of coefficients of the 4x4 blocks of the
+// macroblocks read, the neighbours of a block tell how it is coded.
+type cavlcSlice struct {
+	r      *bitReader
+	width  int
+	first  int
+	totals []uint8 // per macroblock from first, 16 luma then 4 Cb and 4 Cr blocks
+}
+
+func (self *cavlcSlice) macroblock(addr int, sliceType SliceType, numRefIdxL0 uint) (mb Macroblock, err error) {
+	r := self.r
+	start := r.pos()
+	mb.Addr = addr
+	defer func() {
+		mb.Bits = r.pos() - start
+	}()
+
+	var mbType uint
+	if mbType, err = r.ReadExponentialGolombCode(); err != nil {
+		return
+	}
+	mb.Type = mbType
+	if sliceType == SLICE_P {
+		if mbType < 5 {
+			return self.interMacroblock(mb, mbType, numRefIdxL0)
+		}
+		mbType -= 5
+	}
+	mb.Intra = true
+	var cbp uint
+	switch {
+	case mbType == 0:
+		// I_NxN
+		for i := 0; i < 16; i++ {
+			var flag uint
+			if flag, err = r.ReadBit(); err != nil {
+				return
+			}
+			if flag == 0 {
+				// rem_intra4x4_pred_mode
+				if _, err = r.ReadBits(3); err != nil {
+					return
+				}
+			}
+		}
+		// intra_chroma_pred_mode
+		if _, err = r.ReadExponentialGolombCode(); err != nil {
+			return
+		}
+		if cbp, err = r.ReadExponentialGolombCode(); err != nil {
+			return
+		}
+		if cbp >= 48 {
+			err = fmt.Errorf("h264parser: coded_block_pattern=%d invalid", cbp)
+			return
+		}
+		cbp = uint(intraCBP[cbp])
+		mb.CBP = cbp
+
+	case mbType <= 24:
+		// I_16x16, coded_block_pattern is part of the type
+		if _, err = r.ReadExponentialGolombCode(); err != nil {
+			return
+		}
+		t := mbType - 1
+		cbp = t / 4 % 3 << 4
+		if t >= 12 {
+			cbp |= 15
+		}
+		mb.CBP = cbp
+		err = self.residual(&mb, cbp, true)
+		return
+
+	case mbType == 25:
+		// I_PCM, 384 samples of 8 bits from the next byte
+		if err = r.skipBytes(384); err != nil {
+			return
+		}
+		totals := self.totals[(addr-self.first)*24:][:24]
+		for i := range totals {
+			totals[i] = 16
+		}
+		return
+
+	default:
+		err = fmt.Errorf("h264parser: mb_type=%d invalid", mbType)
+		return
+	}
+	err = self.residual(&mb, cbp, false)
+	return
+}
+
+func (self *cavlcSlice) interMacroblock(mb Macroblock, mbType uint, numRefIdxL0 uint) (Macroblock, error) {
+	r := self.r
+	readRefIdx := func(n int) (err error) {
+		for i := 0; i < n && numRefIdxL0 > 1; i++ {
+			// ref_idx_l0 is te(v), a single bit when there are two references
+			if numRefIdxL0 == 2 {
+				_, err = r.ReadBit()
+			} else {
+				_, err = r.ReadExponentialGolombCode()
+			}
+			if err != nil {
+				return
+			}
+		}
+		return
+	}
+	readMvds := func(n int) (err error) {
+		for i := 0; i < 2*n; i++ {
+			var mvd uint
+			if mvd, err = r.ReadSE(); err != nil {
+				return
+			}
+			if v := int(mvd); v < 0 {
+				mb.Motion -= v
+			} else {
+				mb.Motion += v
+			}
+		}
+		mb.Parts += n
+		return
+	}
+
+	var err error
+	switch mbType {
+	case 0, 1, 2:
+		// P_L0_16x16, P_L0_L0_16x8, P_L0_L0_8x16
+		n := 1
+		if mbType > 0 {
+			n = 2
+		}
+		if err = readRefIdx(n); err != nil {
+			return mb, err
+		}
+		if err = readMvds(n); err != nil {
+			return mb, err
+		}
+	default:
+		// P_8x8, P_8x8ref0
+		var subTypes [4]uint
+		for i := range subTypes {
+			if subTypes[i], err = r.ReadExponentialGolombCode(); err != nil {
+				return mb, err
+			}
+			if subTypes[i] > 3 {
+				return mb, fmt.Errorf("h264parser: sub_mb_type=%d invalid", subTypes[i])
+			}
+		}
+		if mbType == 3 {
+			if err = readRefIdx(4); err != nil {
+				return mb, err
+			}
+		}
+		for _, t := range subTypes {
+			if err = readMvds(subMbParts[t]); err != nil {
+				return mb, err
+			}
+		}
+	}
+
+	var cbp uint
+	if cbp, err = r.ReadExponentialGolombCode(); err != nil {
+		return mb, err
+	}
+	if cbp >= 48 {
+		return mb, fmt.Errorf("h264parser: coded_block_pattern=%d invalid", cbp)
+	}
+	mb.CBP = uint(interCBP[cbp])
+	err = self.residual(&mb, mb.CBP, false)
+	return mb, err
+}
+
+// residual reads mb_qp_delta and the coefficients, Intra16x16DCLevel first
+// for I_16x16 macroblocks.
+func (self *cavlcSlice) residual(mb *Macroblock, cbp uint, intra16x16 bool) (err error) {
+	r := self.r
+	luma, chroma := cbp&15, cbp>>4
+	if luma == 0 && chroma == 0 && !intra16x16 {
+		return
+	}
+	// mb_qp_delta
+	if _, err = r.ReadSE(); err != nil {
+		return
+	}
+	totals := self.totals[(mb.Addr-self.first)*24:][:24]
+	var n int
+
+	maxCoeff := 16
+	if intra16x16 {
+		if n, err = self.block(self.nC(mb.Addr, 0, 0, 0), 16); err != nil {
+			return
+		}
+		mb.Coeffs += n
+		maxCoeff = 15
+	}
+	for blk := 0; blk < 16; blk++ {
+		if luma>>uint(blk/4)&1 == 0 {
+			continue
+		}
+		x, y := blk/4%2*2+blk%2, blk/8*2+blk%4/2
+		if n, err = self.block(self.nC(mb.Addr, 0, x, y), maxCoeff); err != nil {
+			return
+		}
+		totals[y*4+x] = uint8(n)
+		mb.Coeffs += n
+	}
+
+	if chroma == 0 {
+		return
+	}
+	for plane := 0; plane < 2; plane++ {
+		if n, err = self.block(-1, 4); err != nil {
+			return
+		}
+		mb.Coeffs += n
+	}
+	if chroma < 2 {
+		return
+	}
+	for plane := 1; plane <= 2; plane++ {
+		for blk := 0; blk < 4; blk++ {
+			x, y := blk%2, blk/2
+			if n, err = self.block(self.nC(mb.Addr, plane, x, y), 15); err != nil {
+				return
+			}
+			totals[12+plane*4+y*2+x] = uint8(n)
+			mb.Coeffs += n
+		}
+	}
+	return
+}
+
+// total returns the number of coefficients of a block of plane, 0 for luma
+// then Cb and Cr, with x and y possibly in the macroblock on the left or
+// above. ok is false if that one is not in the slice.
+func (self *cavlcSlice) total(addr, plane, x, y int) (n int, ok bool) {
+	size := 4
+	if plane > 0 {
+		size = 2
+	}
+	if x < 0 {
+		if addr%self.width == 0 {
+			return
+		}
+		addr--
+		x += size
+	}
+	if y < 0 {
+		addr -= self.width
+		y += size
+	}
+	if addr < self.first {
+		return
+	}
+	i := y*4 + x
+	if plane > 0 {
+		i = 12 + plane*4 + y*2 + x
+	}
+	return int(self.totals[(addr-self.first)*24+i]), true
+}
+
+// nC picks the coeff_token table of a block from its neighbours.
+func (self *cavlcSlice) nC(addr, plane, x, y int) int {
+	a, okA := self.total(addr, plane, x-1, y)
+	b, okB := self.total(addr, plane, x, y-1)
+	switch {
+	case okA && okB:
+		return (a + b + 1) >> 1
+	case okA:
+		return a
+	case okB:
+		return b
+	}
+	return 0
+}
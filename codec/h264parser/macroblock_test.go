@@ -0,0 +1,79 @@
+package h264parser
+
+import (
+	"testing"
+)
+
+// rbsp ends the slice data with rbsp_trailing_bits.
+func (self *golombWriter) rbsp() []byte {
+	self.u(1, 1)
+	self.w.FlushBits()
+	return RBSPToEBSP(self.buf.Bytes())
+}
+
+func TestParseSliceData(t *testing.T) {
+	// baseline, 2x1 macroblocks
+	sps := newGolombWriter(0x67).u(66, 8).u(0, 8).u(30, 8).ue(0).
+		ue(0).ue(0).ue(2).ue(1).u(0, 1).ue(1).ue(0).u(1, 1).u(1, 1).u(0, 1).u(0, 1).bytes()
+	pps := newGolombWriter(0x68).ue(0).ue(0).u(0, 1).u(0, 1).ue(0).
+		ue(0).ue(0).u(0, 1).u(0, 2).se(0).se(0).se(0).u(1, 1).u(0, 1).u(0, 1).bytes()
+	spsInfo, err := ParseSPS(sps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ppsInfo, err := ParsePPS(pps)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	idr := newGolombWriter(0x65).ue(0).ue(7).ue(0).u(0, 4).ue(0).u(0, 6).u(0, 2).se(0).
+		ue(1). // disable_deblocking_filter_idc
+		// I_16x16_0_0_0, one DC coefficient
+		ue(1).ue(0).se(0).u(1, 2).u(0, 1).u(1, 1).
+		// I_NxN, luma 8x8 block 0 coded, two coefficients in its first 4x4
+		ue(0).u(0xffff, 16).ue(0).ue(29).se(0).
+		u(1, 3).u(0, 2).u(7, 3). // nC 0, two trailing ones, no zeros
+		u(3, 2).                 // nC 2, none
+		u(1, 1).u(1, 1).         // nC 1 and 0, none
+		rbsp()
+	p := newGolombWriter(0x41).ue(0).ue(5).ue(0).u(1, 4).u(2, 6).
+		u(0, 1).u(0, 1).u(0, 1).se(0).ue(1).
+		ue(1). // mb_skip_run
+		// P_L0_16x16, chroma DC coded, one coefficient in Cb
+		ue(0).se(3).se(-2).ue(1).se(0).
+		u(1, 1).u(0, 1).u(1, 1). // Cb
+		u(1, 2).                 // Cr
+		rbsp()
+
+	cases := []struct {
+		name string
+		nalu []byte
+		typ  SliceType
+		want []Macroblock
+	}{
+		{"I", idr, SLICE_I, []Macroblock{
+			{Addr: 0, Intra: true, Type: 1, CBP: 0, Coeffs: 1},
+			{Addr: 1, Intra: true, Type: 0, CBP: 1, Coeffs: 2},
+		}},
+		{"P", p, SLICE_P, []Macroblock{
+			{Addr: 0, Skip: true},
+			{Addr: 1, Type: 0, CBP: 16, Coeffs: 1, Parts: 1, Motion: 5},
+		}},
+	}
+	for _, c := range cases {
+		h, mbs, err := ParseSliceData(c.nalu, spsInfo, ppsInfo)
+		if err != nil {
+			t.Fatalf("%s: %s", c.name, err)
+		}
+		if h.Type != c.typ || len(mbs) != len(c.want) {
+			t.Fatalf("%s: slice %s with %d macroblocks", c.name, h.Type, len(mbs))
+		}
+		for i, want := range c.want {
+			got := mbs[i]
+			got.Bits = 0
+			if got != want {
+				t.Errorf("%s: macroblock %d is %+v, want %+v", c.name, i, got, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package h264parser
+
+import (
+	"github.com/deepch/vdk/utils/bits/pio"
+)
+
+// NALU is a NAL unit within a packet, a sub-slice of the packet data.
+type NALU []byte
+
+func (self NALU) Type() int {
+	if len(self) == 0 {
+		return 0
+	}
+	return int(self[0] & 0x1f)
+}
+
+func (self NALU) RefIdc() int {
+	if len(self) == 0 {
+		return 0
+	}
+	return int(self[0] >> 5 & 3)
+}
+
+func (self NALU) Size() int {
+	return len(self)
+}
+
+// NALUReader walks the NAL units of a packet as SplitNALUs splits them, but
+// without allocating, so filters looking at every packet do not copy it.
+//
+//	r := h264parser.NewNALUReader(pkt.Data)
+//	for nalu, ok := r.Next(); ok; nalu, ok = r.Next() {
+//	}
+type NALUReader struct {
+	b    []byte
+	typ  int
+	pos  int
+	code int // start code length at pos, zero at the end
+}
+
+func NewNALUReader(b []byte) (self NALUReader) {
+	self.b = b
+	switch {
+	case len(b) < 4:
+		self.typ = NALU_RAW
+	case isAVCC(b):
+		self.typ = NALU_AVCC
+	case pio.U24BE(b) == 1:
+		self.typ = NALU_ANNEXB
+		self.code = 3
+	case pio.U32BE(b) == 1:
+		self.typ = NALU_ANNEXB
+		self.code = 4
+	default:
+		self.typ = NALU_RAW
+	}
+	return
+}
+
+// Format is NALU_RAW, NALU_AVCC or NALU_ANNEXB.
+func (self NALUReader) Format() int {
+	return self.typ
+}
+
+// Next returns the next NAL unit, ok is false past the last one.
+func (self *NALUReader) Next() (nalu NALU, ok bool) {
+	switch self.typ {
+	case NALU_RAW:
+		if self.pos == 0 {
+			self.pos = len(self.b) + 1
+			return NALU(self.b), true
+		}
+
+	case NALU_AVCC:
+		if self.pos+4 > len(self.b) {
+			return
+		}
+		n := int(pio.U32BE(self.b[self.pos:]))
+		start := self.pos + 4
+		if n > len(self.b)-start {
+			return
+		}
+		self.pos = start + n
+		return NALU(self.b[start:self.pos]), true
+
+	case NALU_ANNEXB:
+		for self.code != 0 {
+			start := self.pos + self.code
+			if start == len(self.b) {
+				self.pos, self.code = start, 0
+				return
+			}
+			self.pos, self.code = nextStartCode(self.b, start)
+			if start != self.pos {
+				return NALU(self.b[start:self.pos]), true
+			}
+		}
+	}
+	return
+}
+
+// AppendNALU appends nalu to b framed for format, with a 4 byte size for
+// NALU_AVCC and a 4 byte start code otherwise, so that filters rewriting the
+// NAL units of a packet keep its framing.
+func AppendNALU(b []byte, nalu []byte, format int) []byte {
+	if format == NALU_AVCC {
+		b = append(b, 0, 0, 0, 0)
+		pio.PutU32BE(b[len(b)-4:], uint32(len(nalu)))
+	} else {
+		b = append(b, 0, 0, 0, 1)
+	}
+	return append(b, nalu...)
+}
+
+// isAVCC tells if b is made of length prefixed NAL units exactly.
+func isAVCC(b []byte) bool {
+	n := pio.U32BE(b)
+	if n > uint32(len(b)) {
+		return false
+	}
+	b = b[4:]
+	for n <= uint32(len(b)) {
+		b = b[n:]
+		if len(b) < 4 {
+			break
+		}
+		n = pio.U32BE(b)
+		b = b[4:]
+	}
+	return len(b) == 0
+}
+
+// nextStartCode finds the first start code from pos, returning len(b) if
+// there is none.
+func nextStartCode(b []byte, pos int) (int, int) {
+	for ; pos < len(b); pos++ {
+		if pos+2 < len(b) && b[pos] == 0 {
+			switch pio.U24BE(b[pos:]) {
+			case 0:
+				if pos+3 < len(b) && b[pos+3] == 1 {
+					return pos, 4
+				}
+			case 1:
+				return pos, 3
+			}
+		}
+	}
+	return len(b), 0
+}
@@ -13,10 +13,17 @@ import (
 )
 
 const (
-	NALU_SEI = 6
-	NALU_SPS = 7
-	NALU_PPS = 8
-	NALU_AUD = 9
+	NALU_IDR_SLICE = 5
+	NALU_SEI       = 6
+	NALU_SPS       = 7
+	NALU_PPS       = 8
+	NALU_AUD       = 9
+
+	// SVC and MVC
+	NALU_PREFIX     = 14
+	NALU_SUBSET_SPS = 15
+	NALU_SLICE_EXT  = 20
+	NALU_DEPTH_EXT  = 21
 )
 
 func IsDataNALU(b []byte) bool {
@@ -24,6 +31,79 @@ func IsDataNALU(b []byte) bool {
 	return typ >= 1 && typ <= 5
 }
 
+// IsLayerNALU tells whether b belongs to an SVC or MVC enhancement layer,
+// NALUs a decoder of the base layer has to skip.
+func IsLayerNALU(b []byte) bool {
+	switch b[0] & 0x1f {
+	case NALU_PREFIX, NALU_SUBSET_SPS, NALU_SLICE_EXT, NALU_DEPTH_EXT:
+		return true
+	}
+	return false
+}
+
+// BaseLayer strips the SVC and MVC NALUs from the frame in b, AVCC or
+// Annex B, and returns b itself when it has none.
+func BaseLayer(b []byte) []byte {
+	r := NewNALUReader(b)
+	if r.Format() == NALU_RAW {
+		if len(b) > 0 && IsLayerNALU(b) {
+			return nil
+		}
+		return b
+	}
+	layers := false
+	for nalu, ok := r.Next(); ok; nalu, ok = r.Next() {
+		if len(nalu) > 0 && IsLayerNALU(nalu) {
+			layers = true
+			break
+		}
+	}
+	if !layers {
+		return b
+	}
+	out := make([]byte, 0, len(b))
+	r = NewNALUReader(b)
+	for nalu, ok := r.Next(); ok; nalu, ok = r.Next() {
+		if len(nalu) == 0 || IsLayerNALU(nalu) {
+			continue
+		}
+		out = AppendNALU(out, nalu, r.Format())
+	}
+	return out
+}
+
+// FrameFlags tells from the nal_ref_idc of its slices whether the frame in
+// b, AVCC or Annex B, is a reference one or can be dropped, and whether a
+// recovery point SEI makes it a random access point.
+func FrameFlags(b []byte) (flags av.PacketFlags) {
+	nalus, _ := SplitNALUs(b)
+	idr, recovery := false, false
+	for _, nalu := range nalus {
+		if len(nalu) == 0 {
+			continue
+		}
+		switch typ := nalu[0] & 0x1f; {
+		case typ == NALU_SEI:
+			if !recovery {
+				_, recovery = recoveryPoint(nalu)
+			}
+		case IsDataNALU(nalu):
+			if typ == NALU_IDR_SLICE {
+				idr = true
+			}
+			if nalu[0]&0x60 != 0 {
+				flags = av.PacketReference
+			} else if flags == 0 {
+				flags = av.PacketDisposable
+			}
+		}
+	}
+	if recovery && !idr {
+		flags |= av.PacketRandomAccess
+	}
+	return
+}
+
 /*
 From: http://stackoverflow.com/questions/24884827/possible-locations-for-sequence-picture-parameter-sets-for-h-264-stream
 
@@ -313,28 +393,98 @@ type SPSInfo struct {
 	Width  uint
 	Height uint
 	FPS    uint
+
+	VideoFullRange          uint
+	ColourPrimaries         uint
+	TransferCharacteristics uint
+	MatrixCoefficients      uint
+
+	ChromaFormatIdc      uint
+	SeparateColourPlane  uint
+	Log2MaxFrameNum      uint
+	FrameMbsOnly         uint
+	MbAdaptiveFrameField uint
+
+	PicOrderCntType         uint
+	Log2MaxPicOrderCntLsb   uint
+	DeltaPicOrderAlwaysZero uint
+
+	// pic_order_cnt_type 1
+	OffsetForNonRefPic        int
+	OffsetForTopToBottomField int
+	OffsetForRefFrame         []int
+
+	BitstreamRestriction uint
+	MaxNumReorderFrames  uint
+	MaxDecFrameBuffering uint
 }
 
 func RemoveH264orH265EmulationBytes(b []byte) []byte {
-	j := 0
-	r := make([]byte, len(b))
-	for i := 0; (i < len(b)) && (j < len(b)); {
-		if i+2 < len(b) &&
-			b[i] == 0 && b[i+1] == 0 && b[i+2] == 3 {
-			r[j] = 0
-			r[j+1] = 0
-			j = j + 2
-			i = i + 3
+	return EBSPToRBSP(b)
+}
+
+// EBSPToRBSP removes emulation prevention bytes (the 0x03 in 0x000003) from a
+// NALU payload. b is returned as is when it contains none.
+func EBSPToRBSP(b []byte) []byte {
+	i := bytes.Index(b, []byte{0, 0, 3})
+	if i < 0 {
+		return b
+	}
+	r := make([]byte, 0, len(b))
+	zeros := 0
+	for _, c := range b {
+		if zeros >= 2 && c == 3 {
+			zeros = 0
+			continue
+		}
+		if c == 0 {
+			zeros++
 		} else {
-			r[j] = b[i]
-			j = j + 1
-			i = i + 1
+			zeros = 0
 		}
+		r = append(r, c)
 	}
-	return r[:j]
+	return r
 }
+
+// RBSPToEBSP inserts emulation prevention bytes so that no 0x000000-0x000003
+// sequence appears in the NALU payload and it does not end in 0x0000. b is
+// returned as is when nothing needs escaping.
+func RBSPToEBSP(b []byte) []byte {
+	var r []byte
+	zeros := 0
+	for i, c := range b {
+		if zeros >= 2 && c <= 3 {
+			if r == nil {
+				r = make([]byte, i, len(b)+len(b)/64+2)
+				copy(r, b[:i])
+			}
+			r = append(r, 3)
+			zeros = 0
+		}
+		if c == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+		if r != nil {
+			r = append(r, c)
+		}
+	}
+	if zeros >= 2 {
+		if r == nil {
+			r = append(make([]byte, 0, len(b)+1), b...)
+		}
+		r = append(r, 3)
+	}
+	if r == nil {
+		return b
+	}
+	return r
+}
+
 func ParseSPS(data []byte) (s SPSInfo, err error) {
-	data = RemoveH264orH265EmulationBytes(data)
+	data = EBSPToRBSP(data)
 	r := &bits.GolombBitReader{R: bytes.NewReader(data)}
 
 	if _, err = r.ReadBits(8); err != nil {
@@ -361,19 +511,19 @@ func ParseSPS(data []byte) (s SPSInfo, err error) {
 		return
 	}
 
+	s.ChromaFormatIdc = 1
 	if s.ProfileIdc == 100 || s.ProfileIdc == 110 ||
 		s.ProfileIdc == 122 || s.ProfileIdc == 244 ||
 		s.ProfileIdc == 44 || s.ProfileIdc == 83 ||
 		s.ProfileIdc == 86 || s.ProfileIdc == 118 {
 
-		var chroma_format_idc uint
-		if chroma_format_idc, err = r.ReadExponentialGolombCode(); err != nil {
+		if s.ChromaFormatIdc, err = r.ReadExponentialGolombCode(); err != nil {
 			return
 		}
 
-		if chroma_format_idc == 3 {
-			// residual_colour_transform_flag
-			if _, err = r.ReadBit(); err != nil {
+		if s.ChromaFormatIdc == 3 {
+			// separate_colour_plane_flag
+			if s.SeparateColourPlane, err = r.ReadBit(); err != nil {
 				return
 			}
 		}
@@ -429,40 +579,46 @@ func ParseSPS(data []byte) (s SPSInfo, err error) {
 	}
 
 	// log2_max_frame_num_minus4
-	if _, err = r.ReadExponentialGolombCode(); err != nil {
+	if s.Log2MaxFrameNum, err = r.ReadExponentialGolombCode(); err != nil {
 		return
 	}
+	s.Log2MaxFrameNum += 4
 
-	var pic_order_cnt_type uint
-	if pic_order_cnt_type, err = r.ReadExponentialGolombCode(); err != nil {
+	if s.PicOrderCntType, err = r.ReadExponentialGolombCode(); err != nil {
 		return
 	}
-	if pic_order_cnt_type == 0 {
+	if s.PicOrderCntType == 0 {
 		// log2_max_pic_order_cnt_lsb_minus4
-		if _, err = r.ReadExponentialGolombCode(); err != nil {
+		if s.Log2MaxPicOrderCntLsb, err = r.ReadExponentialGolombCode(); err != nil {
 			return
 		}
-	} else if pic_order_cnt_type == 1 {
-		// delta_pic_order_always_zero_flag
-		if _, err = r.ReadBit(); err != nil {
+		s.Log2MaxPicOrderCntLsb += 4
+	} else if s.PicOrderCntType == 1 {
+		if s.DeltaPicOrderAlwaysZero, err = r.ReadBit(); err != nil {
 			return
 		}
-		// offset_for_non_ref_pic
-		if _, err = r.ReadSE(); err != nil {
+		var offset uint
+		if offset, err = r.ReadSE(); err != nil {
 			return
 		}
-		// offset_for_top_to_bottom_field
-		if _, err = r.ReadSE(); err != nil {
+		s.OffsetForNonRefPic = int(offset)
+		if offset, err = r.ReadSE(); err != nil {
 			return
 		}
+		s.OffsetForTopToBottomField = int(offset)
 		var num_ref_frames_in_pic_order_cnt_cycle uint
 		if num_ref_frames_in_pic_order_cnt_cycle, err = r.ReadExponentialGolombCode(); err != nil {
 			return
 		}
+		if num_ref_frames_in_pic_order_cnt_cycle > 255 {
+			err = fmt.Errorf("h264parser: num_ref_frames_in_pic_order_cnt_cycle=%d invalid", num_ref_frames_in_pic_order_cnt_cycle)
+			return
+		}
 		for i := uint(0); i < num_ref_frames_in_pic_order_cnt_cycle; i++ {
-			if _, err = r.ReadSE(); err != nil {
+			if offset, err = r.ReadSE(); err != nil {
 				return
 			}
+			s.OffsetForRefFrame = append(s.OffsetForRefFrame, int(offset))
 		}
 	}
 
@@ -490,9 +646,9 @@ func ParseSPS(data []byte) (s SPSInfo, err error) {
 	if frame_mbs_only_flag, err = r.ReadBit(); err != nil {
 		return
 	}
+	s.FrameMbsOnly = frame_mbs_only_flag
 	if frame_mbs_only_flag == 0 {
-		// mb_adaptive_frame_field_flag
-		if _, err = r.ReadBit(); err != nil {
+		if s.MbAdaptiveFrameField, err = r.ReadBit(); err != nil {
 			return
 		}
 	}
@@ -569,37 +725,32 @@ func ParseSPS(data []byte) (s SPSInfo, err error) {
 			_ = overscan_appropriate_flagu
 		}
 		video_signal_type_present_flag, err := r.ReadBit()
+		if err != nil {
+			return s, err
+		}
 		if video_signal_type_present_flag != 0 {
 			video_format, err := r.ReadBits(3)
 			if err != nil {
 				return s, err
 			}
 			_ = video_format
-			video_full_range_flag, err := r.ReadBit()
-			if err != nil {
+			if s.VideoFullRange, err = r.ReadBit(); err != nil {
 				return s, err
 			}
-			_ = video_full_range_flag
 			colour_description_present_flag, err := r.ReadBit()
 			if err != nil {
 				return s, err
 			}
 			if colour_description_present_flag != 0 {
-				colour_primaries, err := r.ReadBits(8)
-				if err != nil {
+				if s.ColourPrimaries, err = r.ReadBits(8); err != nil {
 					return s, err
 				}
-				_ = colour_primaries
-				transfer_characteristics, err := r.ReadBits(8)
-				if err != nil {
+				if s.TransferCharacteristics, err = r.ReadBits(8); err != nil {
 					return s, err
 				}
-				_ = transfer_characteristics
-				matrix_coefficients, err := r.ReadBits(8)
-				if err != nil {
+				if s.MatrixCoefficients, err = r.ReadBits(8); err != nil {
 					return s, err
 				}
-				_ = matrix_coefficients
 			}
 		}
 		chroma_loc_info_present_flag, err := r.ReadBit()
@@ -646,14 +797,147 @@ func ParseSPS(data []byte) (s SPSInfo, err error) {
 				//self.FPS = self.FPS / 2
 			}
 		}
+
+		// everything after the timing info is optional, a truncated or oddly
+		// padded tail must not fail an SPS whose size and rate are known
+		s.BitstreamRestriction, s.MaxNumReorderFrames, s.MaxDecFrameBuffering, err = parseVUITail(r)
+		if err != nil {
+			s.BitstreamRestriction, s.MaxNumReorderFrames, s.MaxDecFrameBuffering = 0, 0, 0
+			err = nil
+		}
+	}
+	return
+}
+
+func parseVUITail(r *bits.GolombBitReader) (restriction, maxNumReorderFrames, maxDecFrameBuffering uint, err error) {
+	var nalHRD, vclHRD uint
+	if nalHRD, err = r.ReadBit(); err != nil {
+		return
+	}
+	if nalHRD != 0 {
+		if err = skipHRDParameters(r); err != nil {
+			return
+		}
+	}
+	if vclHRD, err = r.ReadBit(); err != nil {
+		return
+	}
+	if vclHRD != 0 {
+		if err = skipHRDParameters(r); err != nil {
+			return
+		}
+	}
+	if nalHRD != 0 || vclHRD != 0 {
+		// low_delay_hrd_flag
+		if _, err = r.ReadBit(); err != nil {
+			return
+		}
+	}
+	// pic_struct_present_flag
+	if _, err = r.ReadBit(); err != nil {
+		return
+	}
+	if restriction, err = r.ReadBit(); err != nil {
+		return
+	}
+	if restriction != 0 {
+		// motion_vectors_over_pic_boundaries_flag
+		if _, err = r.ReadBit(); err != nil {
+			return
+		}
+		// max_bytes_per_pic_denom, max_bits_per_mb_denom,
+		// log2_max_mv_length_horizontal, log2_max_mv_length_vertical
+		for i := 0; i < 4; i++ {
+			if _, err = r.ReadExponentialGolombCode(); err != nil {
+				return
+			}
+		}
+		if maxNumReorderFrames, err = r.ReadExponentialGolombCode(); err != nil {
+			return
+		}
+		if maxDecFrameBuffering, err = r.ReadExponentialGolombCode(); err != nil {
+			return
+		}
 	}
 	return
 }
 
+func skipHRDParameters(r *bits.GolombBitReader) (err error) {
+	var cpb_cnt_minus1 uint
+	if cpb_cnt_minus1, err = r.ReadExponentialGolombCode(); err != nil {
+		return
+	}
+	// bit_rate_scale, cpb_size_scale
+	if _, err = r.ReadBits(8); err != nil {
+		return
+	}
+	for i := uint(0); i <= cpb_cnt_minus1; i++ {
+		// bit_rate_value_minus1, cpb_size_value_minus1
+		if _, err = r.ReadExponentialGolombCode(); err != nil {
+			return
+		}
+		if _, err = r.ReadExponentialGolombCode(); err != nil {
+			return
+		}
+		// cbr_flag
+		if _, err = r.ReadBit(); err != nil {
+			return
+		}
+	}
+	// initial_cpb_removal_delay_length_minus1, cpb_removal_delay_length_minus1,
+	// dpb_output_delay_length_minus1, time_offset_length
+	_, err = r.ReadBits(20)
+	return
+}
+
+// MaxDpbFrames returns the DPB size implied by the level (Table A-1).
+func (self SPSInfo) MaxDpbFrames() uint {
+	var maxDpbMbs uint
+	switch {
+	case self.LevelIdc <= 11 && self.ConstraintSetFlag&0x04 != 0, self.LevelIdc <= 10, self.LevelIdc == 9:
+		maxDpbMbs = 396
+	case self.LevelIdc <= 11:
+		maxDpbMbs = 900
+	case self.LevelIdc <= 20:
+		maxDpbMbs = 2376
+	case self.LevelIdc <= 21:
+		maxDpbMbs = 4752
+	case self.LevelIdc <= 30:
+		maxDpbMbs = 8100
+	case self.LevelIdc <= 31:
+		maxDpbMbs = 18000
+	case self.LevelIdc <= 32:
+		maxDpbMbs = 20480
+	case self.LevelIdc <= 41:
+		maxDpbMbs = 32768
+	case self.LevelIdc <= 42:
+		maxDpbMbs = 34816
+	case self.LevelIdc <= 50:
+		maxDpbMbs = 110400
+	case self.LevelIdc <= 52:
+		maxDpbMbs = 184320
+	default:
+		maxDpbMbs = 696320
+	}
+	mbs := self.MbWidth * self.MbHeight * (2 - self.FrameMbsOnly)
+	if mbs == 0 {
+		return 16
+	}
+	if n := maxDpbMbs / mbs; n < 16 {
+		return n
+	}
+	return 16
+}
+
 type CodecData struct {
 	Record     []byte
 	RecordInfo AVCDecoderConfRecord
 	SPSInfo    SPSInfo
+
+	MasteringDisplay *av.MasteringDisplay
+	ContentLight     *av.ContentLightLevel
+	Rotation         int // clockwise display rotation in degrees
+	Fields           av.FieldOrder
 }
 
 func (self CodecData) Type() av.CodecType {
@@ -692,6 +976,54 @@ func (self CodecData) FPS() int {
 	return int(self.SPSInfo.FPS)
 }
 
+func (self CodecData) ColorInfo() (info av.ColorInfo) {
+	info.Primaries = uint8(self.SPSInfo.ColourPrimaries)
+	info.Transfer = uint8(self.SPSInfo.TransferCharacteristics)
+	info.Matrix = uint8(self.SPSInfo.MatrixCoefficients)
+	info.FullRange = self.SPSInfo.VideoFullRange != 0
+	info.MasteringDisplay = self.MasteringDisplay
+	info.ContentLight = self.ContentLight
+	return
+}
+
+func (self CodecData) DisplayRotation() int {
+	return self.Rotation
+}
+
+// ReorderDepth returns how many frames may precede a frame in decode order
+// and follow it in presentation order.
+func (self CodecData) ReorderDepth() int {
+	info := self.SPSInfo
+	if info.BitstreamRestriction != 0 {
+		return int(info.MaxNumReorderFrames)
+	}
+	switch info.ProfileIdc {
+	case 66:
+		return 0
+	case 44, 86, 100, 110, 122, 244:
+		// intra profiles
+		if info.ConstraintSetFlag&0x04 != 0 {
+			return 0
+		}
+	}
+	return int(info.MaxDpbFrames())
+}
+
+// Interlaced reports whether the SPS allows field or MBAFF coding.
+func (self CodecData) Interlaced() bool {
+	return self.SPSInfo.FrameMbsOnly == 0
+}
+
+func (self CodecData) FieldOrder() av.FieldOrder {
+	if self.Fields != av.FieldOrderUnknown {
+		return self.Fields
+	}
+	if !self.Interlaced() {
+		return av.FieldOrderProgressive
+	}
+	return av.FieldOrderUnknown
+}
+
 func (self CodecData) Resolution() string {
 	return fmt.Sprintf("%vx%v", self.Width(), self.Height())
 }
@@ -920,3 +1252,52 @@ func ParseSliceHeaderFromNALU(packet []byte) (sliceType SliceType, err error) {
 
 	return
 }
+
+type SliceFieldInfo struct {
+	FirstMb     uint
+	FieldPic    bool
+	BottomField bool
+}
+
+// ParseSliceFieldInfo reads a slice header up to bottom_field_flag.
+func ParseSliceFieldInfo(nalu []byte, sps SPSInfo) (info SliceFieldInfo, err error) {
+	if len(nalu) <= 1 || !IsDataNALU(nalu) {
+		err = fmt.Errorf("h264parser: nalu has no slice header")
+		return
+	}
+	r := &bits.GolombBitReader{R: bytes.NewReader(EBSPToRBSP(nalu[1:]))}
+	if info.FirstMb, err = r.ReadExponentialGolombCode(); err != nil {
+		return
+	}
+	// slice_type, pic_parameter_set_id
+	if _, err = r.ReadExponentialGolombCode(); err != nil {
+		return
+	}
+	if _, err = r.ReadExponentialGolombCode(); err != nil {
+		return
+	}
+	if sps.SeparateColourPlane != 0 {
+		if _, err = r.ReadBits(2); err != nil {
+			return
+		}
+	}
+	// frame_num
+	if _, err = r.ReadBits(int(sps.Log2MaxFrameNum)); err != nil {
+		return
+	}
+	if sps.FrameMbsOnly != 0 {
+		return
+	}
+	var flag uint
+	if flag, err = r.ReadBit(); err != nil {
+		return
+	}
+	if flag != 0 {
+		info.FieldPic = true
+		if flag, err = r.ReadBit(); err != nil {
+			return
+		}
+		info.BottomField = flag != 0
+	}
+	return
+}
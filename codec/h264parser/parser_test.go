@@ -1,23 +1,173 @@
-
 package h264parser
 
 import (
-	"testing"
+	"bytes"
 	"encoding/hex"
+	"testing"
 )
 
 func TestParser(t *testing.T) {
-	var ok bool
+	var typ int
 	var nalus [][]byte
 
 	annexbFrame, _ := hex.DecodeString("00000001223322330000000122332233223300000133000001000001")
-	nalus, ok = SplitNALUs(annexbFrame)
-	t.Log(ok, len(nalus))
+	nalus, typ = SplitNALUs(annexbFrame)
+	t.Log(typ, len(nalus))
 
 	avccFrame, _ := hex.DecodeString(
 		"00000008aabbccaabbccaabb00000001aa",
 	)
-	nalus, ok = SplitNALUs(avccFrame)
-	t.Log(ok, len(nalus))
+	nalus, typ = SplitNALUs(avccFrame)
+	t.Log(typ, len(nalus))
+}
+
+func TestEBSP(t *testing.T) {
+	cases := []struct {
+		rbsp, ebsp string
+	}{
+		{"", ""},
+		{"aabbcc", "aabbcc"},
+		{"0000", "000003"},
+		{"000000", "00000300"},
+		{"000001", "00000301"},
+		{"000002", "00000302"},
+		{"000003", "00000303"},
+		{"000004", "000004"},
+		{"0000000000", "00000300000300"},
+		{"00000100000200", "000003010000030200"},
+		{"11000022000033", "11000022000033"},
+		{"640028ac000003000100", "640028ac00000303000100"},
+	}
+	for _, c := range cases {
+		rbsp, _ := hex.DecodeString(c.rbsp)
+		ebsp, _ := hex.DecodeString(c.ebsp)
+		if got := RBSPToEBSP(rbsp); !bytes.Equal(got, ebsp) {
+			t.Errorf("RBSPToEBSP(%s) = %x, want %s", c.rbsp, got, c.ebsp)
+		}
+		if got := EBSPToRBSP(ebsp); !bytes.Equal(got, rbsp) {
+			t.Errorf("EBSPToRBSP(%s) = %x, want %s", c.ebsp, got, c.rbsp)
+		}
+	}
+}
+
+func TestEBSPRoundTrip(t *testing.T) {
+	b := make([]byte, 4096)
+	for i := range b {
+		b[i] = byte(i*7) % 5
+	}
+	ebsp := RBSPToEBSP(b)
+	for i := 0; i+2 < len(ebsp); i++ {
+		if ebsp[i] == 0 && ebsp[i+1] == 0 && ebsp[i+2] < 3 {
+			t.Fatalf("start code emulation at %d", i)
+		}
+	}
+	if got := EBSPToRBSP(ebsp); !bytes.Equal(got, b) {
+		t.Fatalf("round trip mismatch")
+	}
 }
 
+func TestEBSPNoCopy(t *testing.T) {
+	b := []byte{1, 2, 3, 0, 0, 4}
+	if got := EBSPToRBSP(b); &got[0] != &b[0] {
+		t.Errorf("EBSPToRBSP copied input without emulation bytes")
+	}
+	if got := RBSPToEBSP(b); &got[0] != &b[0] {
+		t.Errorf("RBSPToEBSP copied input without start code emulation")
+	}
+}
+
+func TestParseSPSTruncatedVUI(t *testing.T) {
+	full, _ := hex.DecodeString("6742001eda0507e8400000004000000ca36822116480")
+	s, err := ParseSPS(full)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.BitstreamRestriction != 1 || s.MaxNumReorderFrames != 2 || s.MaxDecFrameBuffering != 3 {
+		t.Fatalf("restriction=%d reorder=%d dpb=%d", s.BitstreamRestriction, s.MaxNumReorderFrames, s.MaxDecFrameBuffering)
+	}
+
+	// cut inside bitstream_restriction
+	truncated, _ := hex.DecodeString("6742001eda0507e8400000004000000ca3")
+	if s, err = ParseSPS(truncated); err != nil {
+		t.Fatal(err)
+	}
+	if s.Width != 320 || s.Height != 240 || s.FPS != 25 {
+		t.Fatalf("got %dx%d@%d", s.Width, s.Height, s.FPS)
+	}
+	if s.BitstreamRestriction != 0 || s.MaxNumReorderFrames != 0 {
+		t.Fatalf("partial restriction kept: %d %d", s.BitstreamRestriction, s.MaxNumReorderFrames)
+	}
+}
+
+func TestBaseLayer(t *testing.T) {
+	sps := []byte{0x67, 0x42, 0x00, 0x1e}
+	pps := []byte{0x68, 0xce, 0x3c, 0x80}
+	idr := []byte{0x65, 0x88, 0x84}
+	slice := []byte{0x41, 0x9a, 0x02}
+	prefix := []byte{0x6e, 0xc0, 0x80, 0x0f}      // 14, before each base layer slice
+	subsetSPS := []byte{0x6f, 0x53, 0x00, 0x1e}   // 15
+	sliceExt := []byte{0x74, 0x80, 0x80, 0x0f, 1} // 20, the enhancement layer slice
+	depthExt := []byte{0x75, 0x80, 0x80, 0x0f, 1} // 21
+	avcc := func(nalus ...[]byte) (b []byte) {
+		for _, nalu := range nalus {
+			b = AppendNALU(b, nalu, NALU_AVCC)
+		}
+		return
+	}
+	annexb := func(nalus ...[]byte) (b []byte) {
+		for _, nalu := range nalus {
+			b = append(append(b, 0, 0, 1), nalu...)
+		}
+		return
+	}
+	annexb4 := func(nalus ...[]byte) (b []byte) {
+		for _, nalu := range nalus {
+			b = AppendNALU(b, nalu, NALU_ANNEXB)
+		}
+		return
+	}
+
+	for _, test := range []struct {
+		name string
+		in   []byte
+		want []byte // nil for a frame left empty
+		same bool   // the frame itself is returned
+	}{
+		{"svc key frame", avcc(sps, subsetSPS, pps, prefix, idr, sliceExt), avcc(sps, pps, idr), false},
+		{"svc frame", avcc(prefix, slice, sliceExt, sliceExt), avcc(slice), false},
+		{"mvc annexb", annexb(prefix, idr, subsetSPS, sliceExt), annexb4(idr), false},
+		{"3d depth", annexb(slice, depthExt), annexb4(slice), false},
+		{"enhancement only", avcc(prefix, sliceExt), nil, false},
+		{"avc avcc", avcc(sps, pps, idr), avcc(sps, pps, idr), true},
+		{"avc annexb", annexb(slice), annexb(slice), true},
+		{"raw slice", slice, slice, true},
+		{"raw extension", sliceExt, nil, false},
+		{"empty", []byte{}, []byte{}, true},
+	} {
+		got := BaseLayer(test.in)
+		if !bytes.Equal(got, test.want) || test.want == nil && len(got) != 0 {
+			t.Errorf("%s: %x, want %x", test.name, got, test.want)
+		}
+		if test.same && len(got) > 0 && &got[0] != &test.in[0] {
+			t.Errorf("%s: copied a frame without layers", test.name)
+		}
+	}
+
+	for _, test := range []struct {
+		nalu  []byte
+		layer bool
+	}{
+		{sps, false},
+		{pps, false},
+		{idr, false},
+		{slice, false},
+		{prefix, true},
+		{subsetSPS, true},
+		{sliceExt, true},
+		{depthExt, true},
+	} {
+		if IsLayerNALU(test.nalu) != test.layer {
+			t.Errorf("type %d: layer %v", test.nalu[0]&0x1f, !test.layer)
+		}
+	}
+}
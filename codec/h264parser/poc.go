@@ -0,0 +1,221 @@
+package h264parser
+
+import (
+	"time"
+
+	"github.com/deepch/vdk/av"
+)
+
+// POCAnalyzer rebuilds the composition time of packets from the picture
+// order count of their slices, for streams out of containers which do not
+// carry it, such as AVI or Annex B dumps. Packets go in and come out in
+// decode order, held back by up to Depth frames.
+//
+// Frames leave the reorder window in POC order as a decoder would output
+// them, the k-th frame output is presented at the time of the frame decoded
+// Depth frames after the k-th, so composition times are never negative.
+type POCAnalyzer struct {
+	Depth int
+
+	sps SPSInfo
+	pps map[uint]PPSInfo
+
+	prevMsb, prevLsb                 int
+	prevFrameNum, prevFrameNumOffset int
+	base, maxKey                     int
+
+	pending []pocFrame
+	last    time.Duration
+	lastDur time.Duration
+}
+
+type pocFrame struct {
+	pkt  av.Packet
+	key  int
+	done bool
+}
+
+func NewPOCAnalyzer(codec CodecData) *POCAnalyzer {
+	self := &POCAnalyzer{
+		Depth: codec.ReorderDepth(),
+		sps:   codec.SPSInfo,
+		pps:   map[uint]PPSInfo{},
+	}
+	if pps, err := ParsePPS(codec.PPS()); err == nil {
+		self.pps[pps.Id] = pps
+	}
+	return self
+}
+
+// key orders pkt for output. POCs restart at every IDR so they are put past
+// the frames before it. A frame without POC, as with an unparsable slice, is
+// output in decode order.
+func (self *POCAnalyzer) key(pkt av.Packet) int {
+	r := NewNALUReader(pkt.Data)
+	for nalu, ok := r.Next(); ok; nalu, ok = r.Next() {
+		switch nalu.Type() {
+		case NALU_SPS:
+			if sps, err := ParseSPS(nalu); err == nil {
+				self.sps = sps
+			}
+		case NALU_PPS:
+			if pps, err := ParsePPS(nalu); err == nil {
+				self.pps[pps.Id] = pps
+			}
+		default:
+			if len(nalu) == 0 || !IsDataNALU(nalu) {
+				continue
+			}
+			if nalu.Type() == NALU_IDR_SLICE {
+				self.base = self.maxKey + 1
+				self.prevMsb, self.prevLsb = 0, 0
+				self.prevFrameNum, self.prevFrameNumOffset = 0, 0
+			}
+			return self.sliceKey(nalu)
+		}
+	}
+	return self.maxKey + 1
+}
+
+func (self *POCAnalyzer) sliceKey(nalu NALU) int {
+	id, err := SlicePPSId(nalu)
+	if err != nil {
+		return self.maxKey + 1
+	}
+	pps, ok := self.pps[id]
+	if !ok {
+		return self.maxKey + 1
+	}
+	h, err := ParseSliceHeader(nalu, self.sps, pps)
+	if err != nil {
+		return self.maxKey + 1
+	}
+	switch self.sps.PicOrderCntType {
+	case 0:
+		max := 1 << self.sps.Log2MaxPicOrderCntLsb
+		lsb := int(h.PicOrderCnt)
+		msb := self.prevMsb
+		if lsb < self.prevLsb && self.prevLsb-lsb >= max/2 {
+			msb += max
+		} else if lsb > self.prevLsb && lsb-self.prevLsb > max/2 {
+			msb -= max
+		}
+		if nalu.RefIdc() != 0 {
+			self.prevMsb, self.prevLsb = msb, lsb
+		}
+		return self.base + msb + lsb
+	default:
+		frameNum := int(h.FrameNum)
+		offset := self.prevFrameNumOffset
+		if self.prevFrameNum > frameNum {
+			offset += 1 << self.sps.Log2MaxFrameNum
+		}
+		self.prevFrameNum, self.prevFrameNumOffset = frameNum, offset
+		if self.sps.PicOrderCntType == 1 {
+			return self.base + self.poc1(offset+frameNum, nalu.RefIdc() != 0, h)
+		}
+		// type 2 follows decode order, a non reference frame before the
+		// next reference one
+		poc := 2 * (offset + frameNum)
+		if nalu.RefIdc() == 0 {
+			poc--
+		}
+		return self.base + poc
+	}
+}
+
+// poc1 derives the POC of a frame with pic_order_cnt_type 1, expected from
+// the cycle of reference frame offsets in the SPS and corrected by the
+// slice, absFrameNum being its frame_num counted from the IDR.
+func (self *POCAnalyzer) poc1(absFrameNum int, ref bool, h SliceHeader) int {
+	sps := self.sps
+	n := len(sps.OffsetForRefFrame)
+	if n == 0 {
+		absFrameNum = 0
+	}
+	if !ref && absFrameNum > 0 {
+		absFrameNum--
+	}
+	expected := 0
+	if absFrameNum > 0 {
+		cycle := 0
+		for _, offset := range sps.OffsetForRefFrame {
+			cycle += offset
+		}
+		expected = (absFrameNum - 1) / n * cycle
+		for i := 0; i <= (absFrameNum-1)%n; i++ {
+			expected += sps.OffsetForRefFrame[i]
+		}
+	}
+	if !ref {
+		expected += sps.OffsetForNonRefPic
+	}
+	top := expected + h.DeltaPicOrderCnt[0]
+	bottom := top + sps.OffsetForTopToBottomField + h.DeltaPicOrderCnt[1]
+	if bottom < top {
+		return bottom
+	}
+	return top
+}
+
+// Push adds the next video packet and returns those whose composition time
+// is now known.
+func (self *POCAnalyzer) Push(pkt av.Packet) (out []av.Packet) {
+	key := self.key(pkt)
+	if key > self.maxKey {
+		self.maxKey = key
+	}
+	if len(self.pending) > 0 {
+		self.lastDur = pkt.Time - self.last
+	}
+	self.last = pkt.Time
+	self.pending = append(self.pending, pocFrame{pkt: pkt, key: key})
+
+	for self.waiting() > self.Depth {
+		self.bump(pkt.Time)
+	}
+	return self.release()
+}
+
+// Flush returns the packets held back, at end of stream, the last ones
+// presented a frame apart after the last packet.
+func (self *POCAnalyzer) Flush() (out []av.Packet) {
+	tm := self.last
+	for self.waiting() > 0 {
+		tm += self.lastDur
+		self.bump(tm)
+	}
+	return self.release()
+}
+
+func (self *POCAnalyzer) waiting() (n int) {
+	for _, frame := range self.pending {
+		if !frame.done {
+			n++
+		}
+	}
+	return
+}
+
+// bump outputs the waiting frame of lowest POC, presented at tm.
+func (self *POCAnalyzer) bump(tm time.Duration) {
+	min := -1
+	for i, frame := range self.pending {
+		if !frame.done && (min < 0 || frame.key < self.pending[min].key) {
+			min = i
+		}
+	}
+	frame := &self.pending[min]
+	frame.pkt.CompositionTime = tm - frame.pkt.Time
+	frame.done = true
+}
+
+func (self *POCAnalyzer) release() (out []av.Packet) {
+	n := 0
+	for n < len(self.pending) && self.pending[n].done {
+		out = append(out, self.pending[n].pkt)
+		n++
+	}
+	self.pending = append(self.pending[:0], self.pending[n:]...)
+	return
+}
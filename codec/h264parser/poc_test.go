@@ -0,0 +1,159 @@
+package h264parser
+
+import (
+	"testing"
+	"time"
+
+	"github.com/deepch/vdk/av"
+)
+
+// pocSPS is a 32x32 main profile SPS with 4 bit frame_num, a 6 bit POC lsb
+// for pic_order_cnt_type 0, and for type 1 a cycle of one reference frame
+// 3 frames apart with B frames 2 frames before the next reference.
+func pocSPS(pocType uint) []byte {
+	w := newGolombWriter(0x67).u(77, 8).u(0, 8).u(30, 8).ue(0).ue(0).ue(pocType)
+	switch pocType {
+	case 0:
+		w.ue(2)
+	case 1:
+		w.u(0, 1).se(-4).se(0).ue(1).se(6)
+	}
+	return w.ue(2).u(0, 1).ue(1).ue(1).u(1, 1).u(1, 1).u(0, 1).u(0, 1).rbsp()
+}
+
+func pocPPS() []byte {
+	return newGolombWriter(0x68).ue(0).ue(0).u(0, 1).u(0, 1).ue(0).ue(0).ue(0).u(0, 1).u(0, 2).
+		se(0).se(0).se(0).u(1, 1).u(0, 1).u(0, 1).rbsp()
+}
+
+type pocFrameInfo struct {
+	typ      byte // I for IDR, P, B, or p and b for non reference frames
+	display  int  // from the first frame of the stream
+	frameNum uint
+	lsb      uint // pic_order_cnt_lsb of type 0
+	delta    int  // delta_pic_order_cnt[0] of type 1
+}
+
+func pocSlice(pocType uint, f pocFrameInfo) []byte {
+	header := map[byte]byte{'I': 0x65, 'P': 0x41, 'B': 0x21, 'p': 0x01, 'b': 0x01}[f.typ]
+	sliceType := map[byte]uint{'I': 7, 'P': 5, 'B': 6, 'p': 5, 'b': 6}[f.typ]
+	w := newGolombWriter(header).ue(0).ue(sliceType).ue(0).u(f.frameNum%16, 4)
+	if f.typ == 'I' {
+		w.ue(0)
+	}
+	switch pocType {
+	case 0:
+		w.u(f.lsb%64, 6)
+	case 1:
+		w.se(f.delta)
+	}
+	switch f.typ {
+	case 'I':
+		w.u(0, 2)
+	case 'P', 'p':
+		w.u(0, 1).u(0, 1)
+	case 'B', 'b':
+		w.u(1, 1).u(0, 1).u(0, 1).u(0, 1)
+	}
+	if f.typ == 'P' || f.typ == 'B' {
+		w.u(0, 1)
+	}
+	return w.se(0).rbsp()
+}
+
+// ibbpGOP is a closed GOP of an I frame then groups of P b b, in decode
+// order, from display index start.
+func ibbpGOP(start, groups int) (frames []pocFrameInfo) {
+	frames = append(frames, pocFrameInfo{typ: 'I', display: start})
+	for j := 1; j <= groups; j++ {
+		p := 3 * j
+		frames = append(frames,
+			pocFrameInfo{typ: 'P', display: start + p, frameNum: uint(j), lsb: uint(2 * p)},
+			pocFrameInfo{typ: 'b', display: start + p - 2, frameNum: uint(j + 1), lsb: uint(2 * (p - 2))},
+			pocFrameInfo{typ: 'b', display: start + p - 1, frameNum: uint(j + 1), lsb: uint(2 * (p - 1)), delta: 2},
+		)
+	}
+	return
+}
+
+// ipGOP alternates reference and non reference P frames.
+func ipGOP(start, n int) (frames []pocFrameInfo) {
+	frames = append(frames, pocFrameInfo{typ: 'I', display: start})
+	for i := 1; i < n; i++ {
+		f := pocFrameInfo{typ: 'P', display: start + i, frameNum: uint((i + 2) / 2), lsb: uint(2 * i)}
+		if i%2 == 0 {
+			f.typ = 'p'
+		}
+		frames = append(frames, f)
+	}
+	return
+}
+
+func TestPOCAnalyzer(t *testing.T) {
+	const frame = 40 * time.Millisecond
+	for _, test := range []struct {
+		name    string
+		pocType uint
+		depth   int
+		frames  []pocFrameInfo
+	}{
+		// 61 frames wrap the POC lsb and the frame_num
+		{"type 0 ibbp", 0, 1, append(ibbpGOP(0, 20), ibbpGOP(61, 3)...)},
+		{"type 0 ibbp deeper", 0, 2, append(ibbpGOP(0, 20), ibbpGOP(61, 3)...)},
+		{"type 1 ibbp", 1, 1, append(ibbpGOP(0, 20), ibbpGOP(61, 3)...)},
+		{"type 1 ibbp deeper", 1, 2, append(ibbpGOP(0, 20), ibbpGOP(61, 3)...)},
+		{"type 0 ip", 0, 1, append(ipGOP(0, 40), ipGOP(40, 5)...)},
+		{"type 2 ip", 2, 1, append(ipGOP(0, 40), ipGOP(40, 5)...)},
+		{"type 2 ip deeper", 2, 3, append(ipGOP(0, 40), ipGOP(40, 5)...)},
+	} {
+		codec, err := NewCodecDataFromSPSAndPPS(pocSPS(test.pocType), pocPPS())
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if codec.SPSInfo.PicOrderCntType != test.pocType {
+			t.Fatalf("%s: pic_order_cnt_type %d", test.name, codec.SPSInfo.PicOrderCntType)
+		}
+		analyzer := NewPOCAnalyzer(codec)
+		analyzer.Depth = test.depth
+		var out []av.Packet
+		for i, f := range test.frames {
+			pkt := av.Packet{
+				IsKeyFrame:      f.typ == 'I',
+				Time:            time.Duration(i) * frame,
+				CompositionTime: -time.Hour, // overwritten
+				Data:            AppendNALU(nil, pocSlice(test.pocType, f), NALU_AVCC),
+			}
+			out = append(out, analyzer.Push(pkt)...)
+		}
+		out = append(out, analyzer.Flush()...)
+
+		// the frame of display index d is presented at the time of the frame
+		// decoded depth frames after the d-th
+		if len(out) != len(test.frames) {
+			t.Fatalf("%s: %d packets out of %d", test.name, len(out), len(test.frames))
+		}
+		for i, pkt := range out {
+			f := test.frames[i]
+			if pkt.Time != time.Duration(i)*frame {
+				t.Fatalf("%s: packet %d out of decode order", test.name, i)
+			}
+			if want := time.Duration(f.display+test.depth-i) * frame; pkt.CompositionTime != want {
+				t.Errorf("%s: frame %d %c%d composition time %v, want %v", test.name, i, f.typ, f.display, pkt.CompositionTime, want)
+			}
+		}
+	}
+}
+
+func TestParseSPSPOCType1(t *testing.T) {
+	sps, err := ParseSPS(pocSPS(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sps.PicOrderCntType != 1 || sps.DeltaPicOrderAlwaysZero != 0 || sps.OffsetForNonRefPic != -4 ||
+		sps.OffsetForTopToBottomField != 0 || len(sps.OffsetForRefFrame) != 1 || sps.OffsetForRefFrame[0] != 6 {
+		t.Errorf("sps %+v", sps)
+	}
+	if sps.Width != 32 || sps.Height != 32 {
+		t.Errorf("size %dx%d", sps.Width, sps.Height)
+	}
+}
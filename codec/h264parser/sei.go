@@ -0,0 +1,245 @@
+package h264parser
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/deepch/vdk/av"
+	"github.com/deepch/vdk/utils/bits"
+	"github.com/deepch/vdk/utils/bits/pio"
+)
+
+const (
+	SEI_USER_DATA_UNREGISTERED          = 5
+	SEI_RECOVERY_POINT                  = 6
+	SEI_DISPLAY_ORIENTATION             = 47
+	SEI_MASTERING_DISPLAY_COLOUR_VOLUME = 137
+	SEI_CONTENT_LIGHT_LEVEL_INFO        = 144
+)
+
+type SEIMessage struct {
+	PayloadType int
+	Payload     []byte
+}
+
+// ParseSEIRBSP splits an SEI RBSP (NAL header and emulation bytes removed)
+// into its sei_message()s. The syntax is shared by H.264 and H.265.
+func ParseSEIRBSP(rbsp []byte) (msgs []SEIMessage, err error) {
+	n := 0
+	for n < len(rbsp) && !(len(rbsp)-n == 1 && rbsp[n] == 0x80) {
+		var typ, size int
+		for n < len(rbsp) && rbsp[n] == 0xff {
+			typ += 255
+			n++
+		}
+		if n >= len(rbsp) {
+			err = fmt.Errorf("h264parser: SEI payload type truncated")
+			return
+		}
+		typ += int(rbsp[n])
+		n++
+		for n < len(rbsp) && rbsp[n] == 0xff {
+			size += 255
+			n++
+		}
+		if n >= len(rbsp) {
+			err = fmt.Errorf("h264parser: SEI payload size truncated")
+			return
+		}
+		size += int(rbsp[n])
+		n++
+		if n+size > len(rbsp) {
+			err = fmt.Errorf("h264parser: SEI payload size=%d invalid", size)
+			return
+		}
+		msgs = append(msgs, SEIMessage{PayloadType: typ, Payload: rbsp[n : n+size]})
+		n += size
+	}
+	return
+}
+
+// MarshalSEIRBSP is the inverse of ParseSEIRBSP, rbsp_trailing_bits included.
+func MarshalSEIRBSP(msgs []SEIMessage) (b []byte) {
+	putValue := func(v int) {
+		for ; v >= 255; v -= 255 {
+			b = append(b, 0xff)
+		}
+		b = append(b, byte(v))
+	}
+	for _, msg := range msgs {
+		putValue(msg.PayloadType)
+		putValue(len(msg.Payload))
+		b = append(b, msg.Payload...)
+	}
+	b = append(b, 0x80)
+	return
+}
+
+// BuildSEI returns a SEI NALU, header and emulation prevention included.
+func BuildSEI(msgs []SEIMessage) []byte {
+	return append([]byte{NALU_SEI}, RBSPToEBSP(MarshalSEIRBSP(msgs))...)
+}
+
+func ParseSEI(nalu []byte) (msgs []SEIMessage, err error) {
+	if len(nalu) < 1 || nalu[0]&0x1f != NALU_SEI {
+		err = fmt.Errorf("h264parser: not a SEI NALU")
+		return
+	}
+	return ParseSEIRBSP(EBSPToRBSP(nalu[1:]))
+}
+
+func ParseMasteringDisplay(b []byte) (md av.MasteringDisplay, err error) {
+	if len(b) < 24 {
+		err = fmt.Errorf("h264parser: mastering display SEI too short")
+		return
+	}
+	for i := range md.DisplayPrimaries {
+		md.DisplayPrimaries[i][0] = pio.U16BE(b[i*4:])
+		md.DisplayPrimaries[i][1] = pio.U16BE(b[i*4+2:])
+	}
+	md.WhitePoint[0] = pio.U16BE(b[12:])
+	md.WhitePoint[1] = pio.U16BE(b[14:])
+	md.MaxLuminance = pio.U32BE(b[16:])
+	md.MinLuminance = pio.U32BE(b[20:])
+	return
+}
+
+func ParseContentLightLevel(b []byte) (cl av.ContentLightLevel, err error) {
+	if len(b) < 4 {
+		err = fmt.Errorf("h264parser: content light level SEI too short")
+		return
+	}
+	cl.MaxCLL = pio.U16BE(b[0:])
+	cl.MaxFALL = pio.U16BE(b[2:])
+	return
+}
+
+// ParseHDRFromSEIMessages extracts HDR metadata, nil if a message is absent.
+func ParseHDRFromSEIMessages(msgs []SEIMessage) (md *av.MasteringDisplay, cl *av.ContentLightLevel) {
+	for _, msg := range msgs {
+		switch msg.PayloadType {
+		case SEI_MASTERING_DISPLAY_COLOUR_VOLUME:
+			if v, err := ParseMasteringDisplay(msg.Payload); err == nil {
+				md = &v
+			}
+		case SEI_CONTENT_LIGHT_LEVEL_INFO:
+			if v, err := ParseContentLightLevel(msg.Payload); err == nil {
+				cl = &v
+			}
+		}
+	}
+	return
+}
+
+// ParseDisplayOrientation returns the clockwise display rotation in degrees,
+// ok is false when the message cancels a previous orientation.
+func ParseDisplayOrientation(b []byte) (rotation int, ok bool, err error) {
+	r := &bits.GolombBitReader{R: bytes.NewReader(b)}
+	var cancel, anticlockwise uint
+	if cancel, err = r.ReadBit(); err != nil {
+		return
+	}
+	if cancel != 0 {
+		return
+	}
+	// hor_flip, ver_flip
+	if _, err = r.ReadBits(2); err != nil {
+		return
+	}
+	if anticlockwise, err = r.ReadBits(16); err != nil {
+		return
+	}
+	rotation = (360 - int(anticlockwise)*360/65536) % 360
+	ok = true
+	return
+}
+
+// UpdateFromSEI fills the HDR metadata and display rotation from a SEI
+// NALU, returns true if anything was found.
+func (self *CodecData) UpdateFromSEI(nalu []byte) (found bool) {
+	msgs, err := ParseSEI(nalu)
+	if err != nil {
+		return false
+	}
+	md, cl := ParseHDRFromSEIMessages(msgs)
+	if md != nil {
+		self.MasteringDisplay = md
+		found = true
+	}
+	if cl != nil {
+		self.ContentLight = cl
+		found = true
+	}
+	if rotation, ok := ParseRotationFromSEIMessages(msgs); ok {
+		self.Rotation = rotation
+		found = true
+	}
+	return
+}
+
+func ParseRotationFromSEIMessages(msgs []SEIMessage) (rotation int, ok bool) {
+	for _, msg := range msgs {
+		if msg.PayloadType == SEI_DISPLAY_ORIENTATION {
+			if v, valid, err := ParseDisplayOrientation(msg.Payload); err == nil && valid {
+				rotation, ok = v, true
+			}
+		}
+	}
+	return
+}
+
+// RecoveryPoint tells that decoding from its frame gives correct pictures
+// after FrameCnt frames, an entry point for streams refreshed gradually
+// instead of by IDR frames.
+type RecoveryPoint struct {
+	FrameCnt   int
+	ExactMatch bool
+	BrokenLink bool
+}
+
+func ParseRecoveryPoint(b []byte) (rp RecoveryPoint, err error) {
+	r := &bits.GolombBitReader{R: bytes.NewReader(b)}
+	var cnt, flags uint
+	if cnt, err = r.ReadExponentialGolombCode(); err != nil {
+		return
+	}
+	if flags, err = r.ReadBits(2); err != nil {
+		return
+	}
+	rp.FrameCnt = int(cnt)
+	rp.ExactMatch = flags&2 != 0
+	rp.BrokenLink = flags&1 != 0
+	return
+}
+
+// FindRecoveryPoint looks for a recovery point SEI in the frame b, AVCC or
+// Annex B.
+func FindRecoveryPoint(b []byte) (rp RecoveryPoint, ok bool) {
+	nalus, _ := SplitNALUs(b)
+	for _, nalu := range nalus {
+		if rp, ok = recoveryPoint(nalu); ok {
+			return
+		}
+	}
+	return
+}
+
+func recoveryPoint(nalu []byte) (rp RecoveryPoint, ok bool) {
+	if len(nalu) == 0 || nalu[0]&0x1f != NALU_SEI {
+		return
+	}
+	msgs, err := ParseSEI(nalu)
+	if err != nil {
+		return
+	}
+	for _, msg := range msgs {
+		if msg.PayloadType != SEI_RECOVERY_POINT {
+			continue
+		}
+		if rp, err = ParseRecoveryPoint(msg.Payload); err == nil {
+			ok = true
+			return
+		}
+	}
+	return
+}
@@ -0,0 +1,82 @@
+package h264parser
+
+import (
+	"testing"
+
+	"github.com/deepch/vdk/av"
+)
+
+func TestParseRecoveryPoint(t *testing.T) {
+	for _, test := range []struct {
+		payload []byte
+		want    RecoveryPoint
+		err     bool
+	}{
+		{[]byte{0xc4}, RecoveryPoint{FrameCnt: 0, ExactMatch: true}, false},
+		{[]byte{0x22, 0x40}, RecoveryPoint{FrameCnt: 3, BrokenLink: true}, false},
+		{[]byte{0x0f, 0xf2}, RecoveryPoint{FrameCnt: 30, ExactMatch: true, BrokenLink: true}, false},
+		{[]byte{0x00, 0x00, 0x80, 0x00, 0x44}, RecoveryPoint{FrameCnt: 65535, ExactMatch: true}, false},
+		{nil, RecoveryPoint{}, true},
+		{[]byte{0x00}, RecoveryPoint{}, true},
+		{[]byte{0x80}, RecoveryPoint{}, false},
+		{[]byte{0x01}, RecoveryPoint{}, true}, // count cut
+	} {
+		rp, err := ParseRecoveryPoint(test.payload)
+		if test.err {
+			if err == nil {
+				t.Errorf("%x: no error", test.payload)
+			}
+			continue
+		}
+		if err != nil || rp != test.want {
+			t.Errorf("%x: %+v %v, want %+v", test.payload, rp, err, test.want)
+		}
+	}
+}
+
+func TestFindRecoveryPoint(t *testing.T) {
+	// user data with a start code, escaped in the NALU
+	userData := SEIMessage{PayloadType: SEI_USER_DATA_UNREGISTERED, Payload: []byte{
+		0xdc, 0x45, 0xe9, 0xbd, 0xe6, 0xd9, 0x48, 0xb7, 0x96, 0x2c, 0xd8, 0x20, 0xd9, 0x23, 0xee, 0xef, 0, 0, 1, 0}}
+	recovery := SEIMessage{PayloadType: SEI_RECOVERY_POINT, Payload: []byte{0x22, 0x40}}
+	sei := BuildSEI([]SEIMessage{userData, recovery})
+	idr := []byte{0x65, 0x88, 0x84}
+	slice := []byte{0x41, 0x9a, 0x02}
+	disposable := []byte{0x01, 0x9e, 0x02}
+	avcc := func(nalus ...[]byte) (b []byte) {
+		for _, nalu := range nalus {
+			b = AppendNALU(b, nalu, NALU_AVCC)
+		}
+		return
+	}
+	annexb := func(nalus ...[]byte) (b []byte) {
+		for _, nalu := range nalus {
+			b = AppendNALU(b, nalu, NALU_ANNEXB)
+		}
+		return
+	}
+
+	for _, test := range []struct {
+		name  string
+		frame []byte
+		ok    bool
+		flags av.PacketFlags
+	}{
+		{"avcc", avcc(sei, slice), true, av.PacketReference | av.PacketRandomAccess},
+		{"annexb", annexb(sei, slice), true, av.PacketReference | av.PacketRandomAccess},
+		{"disposable", avcc(sei, disposable), true, av.PacketDisposable | av.PacketRandomAccess},
+		{"idr", avcc(sei, idr), true, av.PacketReference},
+		{"no sei", avcc(slice), false, av.PacketReference},
+		{"other sei", avcc(BuildSEI([]SEIMessage{userData}), slice), false, av.PacketReference},
+		{"corrupt sei", avcc([]byte{NALU_SEI, 6, 9, 0xc4}, slice), false, av.PacketReference},
+		{"bad recovery point", avcc(BuildSEI([]SEIMessage{{PayloadType: SEI_RECOVERY_POINT}}), slice), false, av.PacketReference},
+	} {
+		rp, ok := FindRecoveryPoint(test.frame)
+		if ok != test.ok || ok && rp != (RecoveryPoint{FrameCnt: 3, BrokenLink: true}) {
+			t.Errorf("%s: recovery point %+v %v", test.name, rp, ok)
+		}
+		if flags := FrameFlags(test.frame); flags != test.flags {
+			t.Errorf("%s: flags %v, want %v", test.name, flags, test.flags)
+		}
+	}
+}
@@ -0,0 +1,431 @@
+package h264parser
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/deepch/vdk/utils/bits"
+)
+
+type PPSInfo struct {
+	Id    uint
+	SPSId uint
+
+	EntropyCodingMode                 uint
+	BottomFieldPicOrderInFramePresent uint
+	NumSliceGroups                    uint
+
+	NumRefIdxL0DefaultActive uint
+	NumRefIdxL1DefaultActive uint
+	WeightedPred             uint
+	WeightedBipredIdc        uint
+	PicInitQP                int
+	ChromaQPIndexOffset      int
+
+	DeblockingFilterControlPresent uint
+	RedundantPicCntPresent         uint
+}
+
+func ParsePPS(data []byte) (s PPSInfo, err error) {
+	if len(data) < 2 {
+		err = fmt.Errorf("h264parser: pps too short")
+		return
+	}
+	r := &bits.GolombBitReader{R: bytes.NewReader(EBSPToRBSP(data[1:]))}
+
+	if s.Id, err = r.ReadExponentialGolombCode(); err != nil {
+		return
+	}
+	if s.SPSId, err = r.ReadExponentialGolombCode(); err != nil {
+		return
+	}
+	if s.EntropyCodingMode, err = r.ReadBit(); err != nil {
+		return
+	}
+	if s.BottomFieldPicOrderInFramePresent, err = r.ReadBit(); err != nil {
+		return
+	}
+	if s.NumSliceGroups, err = r.ReadExponentialGolombCode(); err != nil {
+		return
+	}
+	s.NumSliceGroups++
+
+	if s.NumSliceGroups > 1 {
+		var mapType uint
+		if mapType, err = r.ReadExponentialGolombCode(); err != nil {
+			return
+		}
+		switch mapType {
+		case 0:
+			// run_length_minus1
+			for i := uint(0); i < s.NumSliceGroups; i++ {
+				if _, err = r.ReadExponentialGolombCode(); err != nil {
+					return
+				}
+			}
+		case 2:
+			// top_left, bottom_right
+			for i := uint(0); i < 2*(s.NumSliceGroups-1); i++ {
+				if _, err = r.ReadExponentialGolombCode(); err != nil {
+					return
+				}
+			}
+		case 3, 4, 5:
+			// slice_group_change_direction_flag, slice_group_change_rate_minus1
+			if _, err = r.ReadBit(); err != nil {
+				return
+			}
+			if _, err = r.ReadExponentialGolombCode(); err != nil {
+				return
+			}
+		case 6:
+			var n uint
+			if n, err = r.ReadExponentialGolombCode(); err != nil {
+				return
+			}
+			size := 0
+			for 1<<uint(size) < s.NumSliceGroups {
+				size++
+			}
+			// slice_group_id
+			for i := uint(0); i <= n; i++ {
+				if _, err = r.ReadBits(size); err != nil {
+					return
+				}
+			}
+		}
+	}
+
+	if s.NumRefIdxL0DefaultActive, err = r.ReadExponentialGolombCode(); err != nil {
+		return
+	}
+	s.NumRefIdxL0DefaultActive++
+	if s.NumRefIdxL1DefaultActive, err = r.ReadExponentialGolombCode(); err != nil {
+		return
+	}
+	s.NumRefIdxL1DefaultActive++
+	if s.WeightedPred, err = r.ReadBit(); err != nil {
+		return
+	}
+	if s.WeightedBipredIdc, err = r.ReadBits(2); err != nil {
+		return
+	}
+
+	var u uint
+	// pic_init_qp_minus26
+	if u, err = r.ReadSE(); err != nil {
+		return
+	}
+	s.PicInitQP = 26 + int(u)
+	// pic_init_qs_minus26
+	if _, err = r.ReadSE(); err != nil {
+		return
+	}
+	if u, err = r.ReadSE(); err != nil {
+		return
+	}
+	s.ChromaQPIndexOffset = int(u)
+	if s.DeblockingFilterControlPresent, err = r.ReadBit(); err != nil {
+		return
+	}
+	// constrained_intra_pred_flag
+	if _, err = r.ReadBit(); err != nil {
+		return
+	}
+	if s.RedundantPicCntPresent, err = r.ReadBit(); err != nil {
+		return
+	}
+	return
+}
+
+// SliceHeader is the part of a slice header read by ParseSliceHeader, up
+// to slice_qp_delta.
+type SliceHeader struct {
+	FirstMb          uint
+	Type             SliceType
+	PPSId            uint
+	FrameNum         uint
+	FieldPic         bool
+	BottomField      bool
+	IdrPicId         uint
+	PicOrderCnt      uint   // pic_order_cnt_lsb
+	DeltaPicOrderCnt [2]int // delta_pic_order_cnt of pic_order_cnt_type 1
+	QP               int    // slice QP, pic_init_qp plus slice_qp_delta
+}
+
+func readUEs(r *bitReader, n uint) (err error) {
+	for i := uint(0); i < n; i++ {
+		if _, err = r.ReadExponentialGolombCode(); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// SlicePPSId returns the pic_parameter_set_id of a slice, which picks the
+// parameter sets ParseSliceHeader needs.
+func SlicePPSId(nalu []byte) (id uint, err error) {
+	if len(nalu) <= 1 || !IsDataNALU(nalu) {
+		err = fmt.Errorf("h264parser: nalu has no slice header")
+		return
+	}
+	r := newBitReader(EBSPToRBSP(nalu[1:]))
+	// first_mb_in_slice, slice_type
+	if err = readUEs(r, 2); err != nil {
+		return
+	}
+	id, err = r.ReadExponentialGolombCode()
+	return
+}
+
+// ParseSliceHeader reads a slice header with the parameter sets it refers
+// to, which gives the QP of the slice without decoding it.
+func ParseSliceHeader(nalu []byte, sps SPSInfo, pps PPSInfo) (h SliceHeader, err error) {
+	if len(nalu) <= 1 || !IsDataNALU(nalu) {
+		err = fmt.Errorf("h264parser: nalu has no slice header")
+		return
+	}
+	h, _, _, err = parseSliceHeader(newBitReader(EBSPToRBSP(nalu[1:])), nalu[0], sps, pps)
+	return
+}
+
+// parseSliceHeader also returns slice_type as coded and the number of
+// active references of list 0, which the slice data needs.
+func parseSliceHeader(r *bitReader, header byte, sps SPSInfo, pps PPSInfo) (h SliceHeader, sliceType uint, numRefIdxL0 uint, err error) {
+	typ := header & 0x1f
+	refIdc := header >> 5 & 3
+
+	if h.FirstMb, err = r.ReadExponentialGolombCode(); err != nil {
+		return
+	}
+	if sliceType, err = r.ReadExponentialGolombCode(); err != nil {
+		return
+	}
+	switch sliceType % 5 {
+	case 0, 3:
+		h.Type = SLICE_P
+	case 1:
+		h.Type = SLICE_B
+	case 2, 4:
+		h.Type = SLICE_I
+	}
+	if sliceType > 9 {
+		err = fmt.Errorf("h264parser: slice_type=%d invalid", sliceType)
+		return
+	}
+	// I and SI slices have no reference lists
+	intra := sliceType%5 == 2 || sliceType%5 == 4
+
+	if h.PPSId, err = r.ReadExponentialGolombCode(); err != nil {
+		return
+	}
+	if sps.SeparateColourPlane != 0 {
+		if _, err = r.ReadBits(2); err != nil {
+			return
+		}
+	}
+	if h.FrameNum, err = r.ReadBits(int(sps.Log2MaxFrameNum)); err != nil {
+		return
+	}
+	var flag uint
+	if sps.FrameMbsOnly == 0 {
+		if flag, err = r.ReadBit(); err != nil {
+			return
+		}
+		if flag != 0 {
+			h.FieldPic = true
+			if flag, err = r.ReadBit(); err != nil {
+				return
+			}
+			h.BottomField = flag != 0
+		}
+	}
+	if typ == NALU_IDR_SLICE {
+		if h.IdrPicId, err = r.ReadExponentialGolombCode(); err != nil {
+			return
+		}
+	}
+	if sps.PicOrderCntType == 0 {
+		if h.PicOrderCnt, err = r.ReadBits(int(sps.Log2MaxPicOrderCntLsb)); err != nil {
+			return
+		}
+		if pps.BottomFieldPicOrderInFramePresent != 0 && !h.FieldPic {
+			if _, err = r.ReadSE(); err != nil {
+				return
+			}
+		}
+	}
+	if sps.PicOrderCntType == 1 && sps.DeltaPicOrderAlwaysZero == 0 {
+		n := 1
+		if pps.BottomFieldPicOrderInFramePresent != 0 && !h.FieldPic {
+			n = 2
+		}
+		for i := 0; i < n; i++ {
+			var delta uint
+			if delta, err = r.ReadSE(); err != nil {
+				return
+			}
+			h.DeltaPicOrderCnt[i] = int(delta)
+		}
+	}
+	if pps.RedundantPicCntPresent != 0 {
+		if _, err = r.ReadExponentialGolombCode(); err != nil {
+			return
+		}
+	}
+	if h.Type == SLICE_B {
+		// direct_spatial_mv_pred_flag
+		if _, err = r.ReadBit(); err != nil {
+			return
+		}
+	}
+
+	numRefIdxL0 = pps.NumRefIdxL0DefaultActive
+	numRefIdxL1 := pps.NumRefIdxL1DefaultActive
+	if !intra {
+		// num_ref_idx_active_override_flag
+		if flag, err = r.ReadBit(); err != nil {
+			return
+		}
+		if flag != 0 {
+			if numRefIdxL0, err = r.ReadExponentialGolombCode(); err != nil {
+				return
+			}
+			numRefIdxL0++
+			if h.Type == SLICE_B {
+				if numRefIdxL1, err = r.ReadExponentialGolombCode(); err != nil {
+					return
+				}
+				numRefIdxL1++
+			}
+		}
+	}
+
+	// ref_pic_list_modification
+	lists := 0
+	if !intra {
+		lists = 1
+		if h.Type == SLICE_B {
+			lists = 2
+		}
+	}
+	for i := 0; i < lists; i++ {
+		if flag, err = r.ReadBit(); err != nil {
+			return
+		}
+		for flag != 0 {
+			var idc uint
+			if idc, err = r.ReadExponentialGolombCode(); err != nil {
+				return
+			}
+			if idc == 3 {
+				break
+			}
+			if idc > 5 {
+				err = fmt.Errorf("h264parser: modification_of_pic_nums_idc=%d invalid", idc)
+				return
+			}
+			if _, err = r.ReadExponentialGolombCode(); err != nil {
+				return
+			}
+		}
+	}
+
+	if (pps.WeightedPred != 0 && h.Type == SLICE_P) || (pps.WeightedBipredIdc == 1 && h.Type == SLICE_B) {
+		if err = skipPredWeightTable(r, sps, h.Type, numRefIdxL0, numRefIdxL1); err != nil {
+			return
+		}
+	}
+
+	if refIdc != 0 {
+		// dec_ref_pic_marking
+		if typ == NALU_IDR_SLICE {
+			// no_output_of_prior_pics_flag, long_term_reference_flag
+			if _, err = r.ReadBits(2); err != nil {
+				return
+			}
+		} else {
+			if flag, err = r.ReadBit(); err != nil {
+				return
+			}
+			for flag != 0 {
+				var op uint
+				if op, err = r.ReadExponentialGolombCode(); err != nil {
+					return
+				}
+				if op == 0 {
+					break
+				}
+				if op > 6 {
+					err = fmt.Errorf("h264parser: memory_management_control_operation=%d invalid", op)
+					return
+				}
+				n := uint(1)
+				if op == 3 {
+					n = 2
+				} else if op == 5 {
+					n = 0
+				}
+				if err = readUEs(r, n); err != nil {
+					return
+				}
+			}
+		}
+	}
+
+	if pps.EntropyCodingMode != 0 && !intra {
+		// cabac_init_idc
+		if _, err = r.ReadExponentialGolombCode(); err != nil {
+			return
+		}
+	}
+
+	var delta uint
+	if delta, err = r.ReadSE(); err != nil {
+		return
+	}
+	h.QP = pps.PicInitQP + int(delta)
+	return
+}
+
+func skipPredWeightTable(r *bitReader, sps SPSInfo, sliceType SliceType, numRefIdxL0, numRefIdxL1 uint) (err error) {
+	chroma := sps.ChromaFormatIdc != 0 && sps.SeparateColourPlane == 0
+	// luma_log2_weight_denom
+	if _, err = r.ReadExponentialGolombCode(); err != nil {
+		return
+	}
+	if chroma {
+		if _, err = r.ReadExponentialGolombCode(); err != nil {
+			return
+		}
+	}
+	counts := []uint{numRefIdxL0}
+	if sliceType == SLICE_B {
+		counts = append(counts, numRefIdxL1)
+	}
+	var flag uint
+	for _, count := range counts {
+		for i := uint(0); i < count; i++ {
+			// luma weight and offset
+			if flag, err = r.ReadBit(); err != nil {
+				return
+			}
+			if flag != 0 {
+				if err = readUEs(r, 2); err != nil {
+					return
+				}
+			}
+			if chroma {
+				if flag, err = r.ReadBit(); err != nil {
+					return
+				}
+				if flag != 0 {
+					if err = readUEs(r, 4); err != nil {
+						return
+					}
+				}
+			}
+		}
+	}
+	return
+}
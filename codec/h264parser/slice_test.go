@@ -0,0 +1,95 @@
+package h264parser
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/deepch/vdk/utils/bits"
+)
+
+type golombWriter struct {
+	buf bytes.Buffer
+	w   *bits.Writer
+}
+
+func newGolombWriter(header byte) *golombWriter {
+	self := &golombWriter{}
+	self.buf.WriteByte(header)
+	self.w = &bits.Writer{W: &self.buf}
+	return self
+}
+
+func (self *golombWriter) u(v uint, n int) *golombWriter {
+	self.w.WriteBits(v, n)
+	return self
+}
+
+func (self *golombWriter) ue(v uint) *golombWriter {
+	n := 0
+	for (v+1)>>uint(n+1) != 0 {
+		n++
+	}
+	return self.u(0, n).u(v+1, n+1)
+}
+
+func (self *golombWriter) se(v int) *golombWriter {
+	if v > 0 {
+		return self.ue(uint(2*v - 1))
+	}
+	return self.ue(uint(-2 * v))
+}
+
+func (self *golombWriter) bytes() []byte {
+	// rbsp_stop_one_bit and padding, with data after it
+	self.u(1, 1).u(0xffff, 16)
+	self.w.FlushBits()
+	return RBSPToEBSP(self.buf.Bytes())
+}
+
+func TestParseSliceHeaderQP(t *testing.T) {
+	sps := newGolombWriter(0x67).u(100, 8).u(0, 8).u(40, 8).ue(0).
+		ue(1).ue(0).ue(0).u(0, 1).u(0, 1). // chroma 4:2:0, 8 bits, no scaling
+		ue(0).ue(0).ue(2).                 // frame_num 4 bits, poc type 0 with 6 bits
+		ue(1).u(0, 1).ue(19).ue(14).u(1, 1).u(1, 1).u(0, 1).u(0, 1).bytes()
+	pps := newGolombWriter(0x68).ue(0).ue(0).u(1, 1).u(0, 1).ue(0).
+		ue(0).ue(0).u(1, 1).u(0, 2). // weighted prediction for P slices
+		se(-2).se(0).se(0).u(1, 1).u(0, 1).u(0, 1).bytes()
+	idr := newGolombWriter(0x65).ue(0).ue(7).ue(0).u(0, 4).ue(0).u(0, 6).
+		u(0, 2). // dec_ref_pic_marking
+		se(3).bytes()
+	p := newGolombWriter(0x41).ue(0).ue(5).ue(0).u(1, 4).u(2, 6).
+		u(1, 1).ue(1).                                                                    // two references
+		u(1, 1).ue(0).ue(0).ue(3).                                                        // ref_pic_list_modification
+		ue(5).ue(5).u(1, 1).se(1).se(0).u(0, 1).u(0, 1).u(1, 1).se(1).se(0).se(-1).se(0). // pred_weight_table
+		u(1, 1).ue(1).ue(0).ue(0).                                                        // adaptive_ref_pic_marking
+		ue(1).se(-4).bytes()
+
+	spsInfo, err := ParseSPS(sps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ppsInfo, err := ParsePPS(pps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if spsInfo.Width != 320 || ppsInfo.PicInitQP != 24 {
+		t.Fatalf("width=%d pic_init_qp=%d", spsInfo.Width, ppsInfo.PicInitQP)
+	}
+	cases := []struct {
+		nalu []byte
+		typ  SliceType
+		qp   int
+	}{
+		{idr, SLICE_I, 27},
+		{p, SLICE_P, 20},
+	}
+	for _, c := range cases {
+		h, err := ParseSliceHeader(c.nalu, spsInfo, ppsInfo)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if h.Type != c.typ || h.QP != c.qp {
+			t.Errorf("slice %s qp %d, want %s qp %d", h.Type, h.QP, c.typ, c.qp)
+		}
+	}
+}
@@ -0,0 +1,10 @@
+package h265parser
+
+import (
+	"github.com/deepch/vdk/codec/h264parser"
+)
+
+// NewGOPAnalyzer returns the GOP analyzer of an H.265 stream.
+func NewGOPAnalyzer() *h264parser.GOPAnalyzer {
+	return &h264parser.GOPAnalyzer{FrameFlags: FrameFlags}
+}
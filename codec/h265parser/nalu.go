@@ -0,0 +1,56 @@
+package h265parser
+
+import (
+	"github.com/deepch/vdk/codec/h264parser"
+)
+
+// NALU is a NAL unit within a packet, a sub-slice of the packet data.
+type NALU []byte
+
+func (self NALU) Type() int {
+	if len(self) == 0 {
+		return 0
+	}
+	return int(self[0] >> 1 & 0x3f)
+}
+
+func (self NALU) LayerID() int {
+	if len(self) < 2 {
+		return 0
+	}
+	return int(self[0]&1)<<5 | int(self[1]>>3)
+}
+
+func (self NALU) TemporalID() int {
+	if len(self) < 2 || self[1]&7 == 0 {
+		return 0
+	}
+	return int(self[1]&7) - 1
+}
+
+func (self NALU) Size() int {
+	return len(self)
+}
+
+// NALUReader walks the NAL units of a packet without allocating, it splits
+// them as h264parser.NALUReader does.
+type NALUReader struct {
+	r h264parser.NALUReader
+}
+
+func NewNALUReader(b []byte) NALUReader {
+	return NALUReader{r: h264parser.NewNALUReader(b)}
+}
+
+// Format is NALU_RAW, NALU_AVCC or NALU_ANNEXB.
+func (self NALUReader) Format() int {
+	return self.r.Format()
+}
+
+// Next returns the next NAL unit, ok is false past the last one.
+func (self *NALUReader) Next() (nalu NALU, ok bool) {
+	var b h264parser.NALU
+	b, ok = self.r.Next()
+	nalu = NALU(b)
+	return
+}
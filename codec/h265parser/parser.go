@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/deepch/vdk/av"
+	"github.com/deepch/vdk/codec/h264parser"
 	"github.com/deepch/vdk/utils/bits"
 	"github.com/deepch/vdk/utils/bits/pio"
 )
@@ -36,6 +37,12 @@ type SPSInfo struct {
 	generalConstraintIndicatorFlags  uint64
 	generalLevelIDC                  uint
 	fps                              uint
+	VideoFullRange                   uint
+	ColourPrimaries                  uint
+	TransferCharacteristics          uint
+	MatrixCoefficients               uint
+	MaxDecPicBuffering               uint
+	MaxNumReorderPics                uint
 }
 
 const (
@@ -122,6 +129,41 @@ func IsDataNALU(b []byte) bool {
 	return typ >= 1 && typ <= 5
 }
 
+// FrameFlags tells from the type of its slices whether the frame in b,
+// AVCC or Annex B, is a reference one or a sub-layer non-reference one,
+// such as TRAIL_N, which can be dropped. CRA and BLA frames, and frames
+// with a recovery point SEI, are random access points.
+func FrameFlags(b []byte) (flags av.PacketFlags) {
+	nalus, _ := SplitNALUs(b)
+	for _, nalu := range nalus {
+		if len(nalu) < 2 {
+			continue
+		}
+		typ := (nalu[0] >> 1) & 0x3f
+		switch {
+		case typ == NAL_UNIT_PREFIX_SEI:
+			if hasRecoveryPoint(nalu) {
+				flags |= av.PacketRandomAccess
+			}
+			continue
+		case typ > NAL_UNIT_RESERVED_IRAP_VCL23:
+			continue
+		case typ > NAL_UNIT_RESERVED_VCL_R15:
+			if typ != NAL_UNIT_CODED_SLICE_IDR_W_RADL && typ != NAL_UNIT_CODED_SLICE_IDR_N_LP {
+				flags |= av.PacketRandomAccess
+			} else {
+				flags &^= av.PacketRandomAccess
+			}
+			return flags&^av.PacketDisposable | av.PacketReference
+		case typ%2 == 1:
+			flags = flags&^av.PacketDisposable | av.PacketReference
+		case flags&av.PacketReference == 0:
+			flags |= av.PacketDisposable
+		}
+	}
+	return
+}
+
 var StartCodeBytes = []byte{0, 0, 1}
 var AUDBytes = []byte{0, 0, 0, 1, 0x9, 0xf0, 0, 0, 0, 1} // AUD
 
@@ -136,76 +178,10 @@ const (
 	NALU_ANNEXB
 )
 
+// SplitNALUs splits b as h264parser.SplitNALUs does, the framing is the
+// same for both codecs.
 func SplitNALUs(b []byte) (nalus [][]byte, typ int) {
-	if len(b) < 4 {
-		return [][]byte{b}, NALU_RAW
-	}
-	val3 := pio.U24BE(b)
-	val4 := pio.U32BE(b)
-	if val4 <= uint32(len(b)) {
-		_val4 := val4
-		_b := b[4:]
-		nalus := [][]byte{}
-		for {
-			nalus = append(nalus, _b[:_val4])
-			_b = _b[_val4:]
-			if len(_b) < 4 {
-				break
-			}
-			_val4 = pio.U32BE(_b)
-			_b = _b[4:]
-			if _val4 > uint32(len(_b)) {
-				break
-			}
-		}
-		if len(_b) == 0 {
-			return nalus, NALU_AVCC
-		}
-	}
-	if val3 == 1 || val4 == 1 {
-		_val3 := val3
-		_val4 := val4
-		start := 0
-		pos := 0
-		for {
-			if start != pos {
-				nalus = append(nalus, b[start:pos])
-			}
-			if _val3 == 1 {
-				pos += 3
-			} else if _val4 == 1 {
-				pos += 4
-			}
-			start = pos
-			if start == len(b) {
-				break
-			}
-			_val3 = 0
-			_val4 = 0
-			for pos < len(b) {
-				if pos+2 < len(b) && b[pos] == 0 {
-					_val3 = pio.U24BE(b[pos:])
-					if _val3 == 0 {
-						if pos+3 < len(b) {
-							_val4 = uint32(b[pos+3])
-							if _val4 == 1 {
-								break
-							}
-						}
-					} else if _val3 == 1 {
-						break
-					}
-					pos++
-				} else {
-					pos++
-				}
-			}
-		}
-		typ = NALU_ANNEXB
-		return
-	}
-
-	return [][]byte{b}, NALU_RAW
+	return h264parser.SplitNALUs(b)
 }
 
 func ParseSPS(sps []byte) (ctx SPSInfo, err error) {
@@ -283,7 +259,7 @@ func ParseSPS(sps []byte) (ctx SPSInfo, err error) {
 	}
 	ctx.bitDepthChromaMinus8 = uint(bdcm8)
 
-	_, err = br.ReadExponentialGolombCode()
+	log2MaxPicOrderCntLsbMinus4, err := br.ReadExponentialGolombCode()
 	if err != nil {
 		return
 	}
@@ -297,11 +273,13 @@ func ParseSPS(sps []byte) (ctx SPSInfo, err error) {
 	} else {
 		i = spsMaxSubLayersMinus1
 	}
+	// the highest sub-layer values apply to the full stream
 	for ; i <= spsMaxSubLayersMinus1; i++ {
-		if _, err = br.ReadExponentialGolombCode(); err != nil {
+		if ctx.MaxDecPicBuffering, err = br.ReadExponentialGolombCode(); err != nil {
 			return
 		}
-		if _, err = br.ReadExponentialGolombCode(); err != nil {
+		ctx.MaxDecPicBuffering++
+		if ctx.MaxNumReorderPics, err = br.ReadExponentialGolombCode(); err != nil {
 			return
 		}
 		if _, err = br.ReadExponentialGolombCode(); err != nil {
@@ -327,6 +305,272 @@ func ParseSPS(sps []byte) (ctx SPSInfo, err error) {
 	if _, err = br.ReadExponentialGolombCode(); err != nil {
 		return
 	}
+	// the VUI is optional information, a truncated or unusual tail must not fail the SPS
+	parseSPSTail(br, &ctx, log2MaxPicOrderCntLsbMinus4+4)
+	return
+}
+
+func parseSPSTail(br *bits.GolombBitReader, ctx *SPSInfo, log2MaxPicOrderCntLsb uint) (err error) {
+	var flag uint
+	// scaling_list_enabled_flag
+	if flag, err = br.ReadBit(); err != nil {
+		return
+	}
+	if flag != 0 {
+		// sps_scaling_list_data_present_flag
+		if flag, err = br.ReadBit(); err != nil {
+			return
+		}
+		if flag != 0 {
+			if err = skipScalingListData(br); err != nil {
+				return
+			}
+		}
+	}
+	// amp_enabled_flag, sample_adaptive_offset_enabled_flag
+	if _, err = br.ReadBits(2); err != nil {
+		return
+	}
+	// pcm_enabled_flag
+	if flag, err = br.ReadBit(); err != nil {
+		return
+	}
+	if flag != 0 {
+		if _, err = br.ReadBits(8); err != nil {
+			return
+		}
+		if _, err = br.ReadExponentialGolombCode(); err != nil {
+			return
+		}
+		if _, err = br.ReadExponentialGolombCode(); err != nil {
+			return
+		}
+		if _, err = br.ReadBit(); err != nil {
+			return
+		}
+	}
+	var numShortTermRefPicSets uint
+	if numShortTermRefPicSets, err = br.ReadExponentialGolombCode(); err != nil {
+		return
+	}
+	numDeltaPocs := make([]uint, numShortTermRefPicSets)
+	for i := uint(0); i < numShortTermRefPicSets; i++ {
+		if numDeltaPocs[i], err = skipShortTermRefPicSet(br, i, numDeltaPocs); err != nil {
+			return
+		}
+	}
+	// long_term_ref_pics_present_flag
+	if flag, err = br.ReadBit(); err != nil {
+		return
+	}
+	if flag != 0 {
+		var num uint
+		if num, err = br.ReadExponentialGolombCode(); err != nil {
+			return
+		}
+		for i := uint(0); i < num; i++ {
+			if _, err = br.ReadBits(int(log2MaxPicOrderCntLsb) + 1); err != nil {
+				return
+			}
+		}
+	}
+	// sps_temporal_mvp_enabled_flag, strong_intra_smoothing_enabled_flag
+	if _, err = br.ReadBits(2); err != nil {
+		return
+	}
+	// vui_parameters_present_flag
+	if flag, err = br.ReadBit(); err != nil {
+		return
+	}
+	if flag != 0 {
+		err = parseVUI(br, ctx)
+	}
+	return
+}
+
+func skipScalingListData(br *bits.GolombBitReader) (err error) {
+	for sizeId := 0; sizeId < 4; sizeId++ {
+		step := 1
+		if sizeId == 3 {
+			step = 3
+		}
+		for matrixId := 0; matrixId < 6; matrixId += step {
+			var predModeFlag uint
+			if predModeFlag, err = br.ReadBit(); err != nil {
+				return
+			}
+			if predModeFlag == 0 {
+				if _, err = br.ReadExponentialGolombCode(); err != nil {
+					return
+				}
+				continue
+			}
+			coefNum := 1 << uint(4+(sizeId<<1))
+			if coefNum > 64 {
+				coefNum = 64
+			}
+			if sizeId > 1 {
+				if _, err = br.ReadSE(); err != nil {
+					return
+				}
+			}
+			for i := 0; i < coefNum; i++ {
+				if _, err = br.ReadSE(); err != nil {
+					return
+				}
+			}
+		}
+	}
+	return
+}
+
+func skipShortTermRefPicSet(br *bits.GolombBitReader, idx uint, numDeltaPocs []uint) (num uint, err error) {
+	var interRefPicSetPredictionFlag uint
+	if idx != 0 {
+		if interRefPicSetPredictionFlag, err = br.ReadBit(); err != nil {
+			return
+		}
+	}
+	if interRefPicSetPredictionFlag != 0 {
+		// delta_rps_sign, abs_delta_rps_minus1
+		if _, err = br.ReadBit(); err != nil {
+			return
+		}
+		if _, err = br.ReadExponentialGolombCode(); err != nil {
+			return
+		}
+		for j := uint(0); j <= numDeltaPocs[idx-1]; j++ {
+			var usedByCurrPicFlag, useDeltaFlag uint
+			if usedByCurrPicFlag, err = br.ReadBit(); err != nil {
+				return
+			}
+			useDeltaFlag = 1
+			if usedByCurrPicFlag == 0 {
+				if useDeltaFlag, err = br.ReadBit(); err != nil {
+					return
+				}
+			}
+			if useDeltaFlag != 0 {
+				num++
+			}
+		}
+		return
+	}
+	var numNegativePics, numPositivePics uint
+	if numNegativePics, err = br.ReadExponentialGolombCode(); err != nil {
+		return
+	}
+	if numPositivePics, err = br.ReadExponentialGolombCode(); err != nil {
+		return
+	}
+	for i := uint(0); i < numNegativePics+numPositivePics; i++ {
+		if _, err = br.ReadExponentialGolombCode(); err != nil {
+			return
+		}
+		if _, err = br.ReadBit(); err != nil {
+			return
+		}
+	}
+	num = numNegativePics + numPositivePics
+	return
+}
+
+func parseVUI(br *bits.GolombBitReader, ctx *SPSInfo) (err error) {
+	var flag uint
+	// aspect_ratio_info_present_flag
+	if flag, err = br.ReadBit(); err != nil {
+		return
+	}
+	if flag != 0 {
+		var aspectRatioIdc uint
+		if aspectRatioIdc, err = br.ReadBits(8); err != nil {
+			return
+		}
+		if aspectRatioIdc == 255 {
+			if _, err = br.ReadBits(32); err != nil {
+				return
+			}
+		}
+	}
+	// overscan_info_present_flag
+	if flag, err = br.ReadBit(); err != nil {
+		return
+	}
+	if flag != 0 {
+		if _, err = br.ReadBit(); err != nil {
+			return
+		}
+	}
+	// video_signal_type_present_flag
+	if flag, err = br.ReadBit(); err != nil {
+		return
+	}
+	if flag != 0 {
+		if _, err = br.ReadBits(3); err != nil {
+			return
+		}
+		if ctx.VideoFullRange, err = br.ReadBit(); err != nil {
+			return
+		}
+		// colour_description_present_flag
+		if flag, err = br.ReadBit(); err != nil {
+			return
+		}
+		if flag != 0 {
+			if ctx.ColourPrimaries, err = br.ReadBits(8); err != nil {
+				return
+			}
+			if ctx.TransferCharacteristics, err = br.ReadBits(8); err != nil {
+				return
+			}
+			if ctx.MatrixCoefficients, err = br.ReadBits(8); err != nil {
+				return
+			}
+		}
+	}
+	// chroma_loc_info_present_flag
+	if flag, err = br.ReadBit(); err != nil {
+		return
+	}
+	if flag != 0 {
+		if _, err = br.ReadExponentialGolombCode(); err != nil {
+			return
+		}
+		if _, err = br.ReadExponentialGolombCode(); err != nil {
+			return
+		}
+	}
+	// neutral_chroma_indication_flag, field_seq_flag, frame_field_info_present_flag
+	if _, err = br.ReadBits(3); err != nil {
+		return
+	}
+	// default_display_window_flag
+	if flag, err = br.ReadBit(); err != nil {
+		return
+	}
+	if flag != 0 {
+		for i := 0; i < 4; i++ {
+			if _, err = br.ReadExponentialGolombCode(); err != nil {
+				return
+			}
+		}
+	}
+	// vui_timing_info_present_flag
+	if flag, err = br.ReadBit(); err != nil {
+		return
+	}
+	if flag != 0 {
+		var numUnitsInTick, timeScale uint32
+		if numUnitsInTick, err = br.ReadBits32(32); err != nil {
+			return
+		}
+		if timeScale, err = br.ReadBits32(32); err != nil {
+			return
+		}
+		if numUnitsInTick != 0 {
+			ctx.fps = uint(timeScale / numUnitsInTick)
+		}
+	}
 	return
 }
 
@@ -417,13 +661,17 @@ func updatePTL(ctx, ptl *SPSInfo) {
 }
 
 func nal2rbsp(nal []byte) []byte {
-	return bytes.Replace(nal, []byte{0x0, 0x0, 0x3}, []byte{0x0, 0x0}, -1)
+	return h264parser.EBSPToRBSP(nal)
 }
 
 type CodecData struct {
 	Record     []byte
 	RecordInfo AVCDecoderConfRecord
 	SPSInfo    SPSInfo
+
+	MasteringDisplay *av.MasteringDisplay
+	ContentLight     *av.ContentLightLevel
+	Rotation         int // clockwise display rotation in degrees
 }
 
 func (self CodecData) Type() av.CodecType {
@@ -458,6 +706,26 @@ func (self CodecData) FPS() int {
 	return int(self.SPSInfo.fps)
 }
 
+func (self CodecData) ColorInfo() (info av.ColorInfo) {
+	info.Primaries = uint8(self.SPSInfo.ColourPrimaries)
+	info.Transfer = uint8(self.SPSInfo.TransferCharacteristics)
+	info.Matrix = uint8(self.SPSInfo.MatrixCoefficients)
+	info.FullRange = self.SPSInfo.VideoFullRange != 0
+	info.MasteringDisplay = self.MasteringDisplay
+	info.ContentLight = self.ContentLight
+	return
+}
+
+func (self CodecData) DisplayRotation() int {
+	return self.Rotation
+}
+
+// ReorderDepth returns how many pictures may precede a picture in decode
+// order and follow it in presentation order.
+func (self CodecData) ReorderDepth() int {
+	return int(self.SPSInfo.MaxNumReorderPics)
+}
+
 func (self CodecData) Resolution() string {
 	return fmt.Sprintf("%vx%v", self.Width(), self.Height())
 }
@@ -0,0 +1,61 @@
+package h265parser
+
+import (
+	"fmt"
+
+	"github.com/deepch/vdk/codec/h264parser"
+)
+
+func ParseSEI(nalu []byte) (msgs []h264parser.SEIMessage, err error) {
+	if len(nalu) < 2 {
+		err = ErrorH265IncorectUnitSize
+		return
+	}
+	typ := (nalu[0] >> 1) & 0x3f
+	if typ != NAL_UNIT_PREFIX_SEI && typ != NAL_UNIT_SUFFIX_SEI {
+		err = fmt.Errorf("h265parser: not a SEI NALU")
+		return
+	}
+	return h264parser.ParseSEIRBSP(nal2rbsp(nalu[2:]))
+}
+
+func hasRecoveryPoint(nalu []byte) bool {
+	msgs, err := ParseSEI(nalu)
+	if err != nil {
+		return false
+	}
+	for _, msg := range msgs {
+		if msg.PayloadType == h264parser.SEI_RECOVERY_POINT {
+			return true
+		}
+	}
+	return false
+}
+
+// BuildSEI returns a prefix SEI NALU, header and emulation prevention included.
+func BuildSEI(msgs []h264parser.SEIMessage) []byte {
+	return append([]byte{NAL_UNIT_PREFIX_SEI << 1, 1}, h264parser.RBSPToEBSP(h264parser.MarshalSEIRBSP(msgs))...)
+}
+
+// UpdateFromSEI fills the HDR metadata and display rotation from a SEI
+// NALU, returns true if anything was found.
+func (self *CodecData) UpdateFromSEI(nalu []byte) (found bool) {
+	msgs, err := ParseSEI(nalu)
+	if err != nil {
+		return false
+	}
+	md, cl := h264parser.ParseHDRFromSEIMessages(msgs)
+	if md != nil {
+		self.MasteringDisplay = md
+		found = true
+	}
+	if cl != nil {
+		self.ContentLight = cl
+		found = true
+	}
+	if rotation, ok := h264parser.ParseRotationFromSEIMessages(msgs); ok {
+		self.Rotation = rotation
+		found = true
+	}
+	return
+}
@@ -0,0 +1,179 @@
+// Package onvif parses the XML documents of ONVIF metadata streams, the
+// objects seen by the camera analytics and its event notifications.
+package onvif
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/deepch/vdk/av"
+)
+
+// CodecData of a metadata stream, its packets are whole XML documents.
+type CodecData struct{}
+
+func (self CodecData) Type() av.CodecType {
+	return av.ONVIF_METADATA
+}
+
+// Metadata is one MetadataStream document.
+type Metadata struct {
+	Frames        []Frame
+	Notifications []Notification
+}
+
+// Frame lists the objects of the scene at Time.
+type Frame struct {
+	Time    time.Time
+	Objects []Object
+}
+
+// Rect and Point are in the coordinates of the camera, normalized from -1
+// to 1 by most of them, after the Transformation of the frame if any. They
+// are zero for an object without shape.
+type Rect struct {
+	Left, Top, Right, Bottom float64
+}
+
+type Point struct {
+	X, Y float64
+}
+
+type Class struct {
+	Type       string
+	Likelihood float64
+}
+
+type Object struct {
+	ID              int
+	BoundingBox     Rect
+	CenterOfGravity Point
+	Classes         []Class
+}
+
+// Notification is an event, such as
+// tns1:RuleEngine/CellMotionDetector/Motion with IsMotion in Data.
+type Notification struct {
+	Topic     string
+	Time      time.Time
+	Operation string // Initialized, Changed or Deleted
+	Source    map[string]string
+	Data      map[string]string
+}
+
+type xmlVector struct {
+	X float64 `xml:"x,attr"`
+	Y float64 `xml:"y,attr"`
+}
+
+type xmlSimpleItem struct {
+	Name  string `xml:"Name,attr"`
+	Value string `xml:"Value,attr"`
+}
+
+type xmlMetadata struct {
+	Frames []struct {
+		UtcTime        string `xml:"UtcTime,attr"`
+		Transformation *struct {
+			Translate *xmlVector `xml:"Translate"`
+			Scale     *xmlVector `xml:"Scale"`
+		} `xml:"Transformation"`
+		Objects []struct {
+			ObjectId int `xml:"ObjectId,attr"`
+			Shape    *struct {
+				BoundingBox struct {
+					Left   float64 `xml:"left,attr"`
+					Top    float64 `xml:"top,attr"`
+					Right  float64 `xml:"right,attr"`
+					Bottom float64 `xml:"bottom,attr"`
+				} `xml:"BoundingBox"`
+				CenterOfGravity xmlVector `xml:"CenterOfGravity"`
+			} `xml:"Appearance>Shape"`
+			Class struct {
+				// ONVIF 1.0 candidates and 2.0 types with a likelihood
+				Candidates []struct {
+					Type       string  `xml:"Type"`
+					Likelihood float64 `xml:"Likelihood"`
+				} `xml:"ClassCandidate"`
+				Types []struct {
+					Type       string  `xml:",chardata"`
+					Likelihood float64 `xml:"Likelihood,attr"`
+				} `xml:"Type"`
+			} `xml:"Appearance>Class"`
+		} `xml:"Object"`
+	} `xml:"VideoAnalytics>Frame"`
+	Notifications []struct {
+		Topic   string `xml:"Topic"`
+		Message struct {
+			UtcTime           string          `xml:"UtcTime,attr"`
+			PropertyOperation string          `xml:"PropertyOperation,attr"`
+			Source            []xmlSimpleItem `xml:"Source>SimpleItem"`
+			Data              []xmlSimpleItem `xml:"Data>SimpleItem"`
+		} `xml:"Message>Message"`
+	} `xml:"Event>NotificationMessage"`
+}
+
+func parseTime(s string) (tm time.Time) {
+	tm, _ = time.Parse(time.RFC3339Nano, strings.TrimSpace(s))
+	return
+}
+
+func simpleItems(items []xmlSimpleItem) (m map[string]string) {
+	if len(items) == 0 {
+		return
+	}
+	m = map[string]string{}
+	for _, item := range items {
+		m[item.Name] = item.Value
+	}
+	return
+}
+
+// Parse reads a MetadataStream document.
+func Parse(b []byte) (metadata Metadata, err error) {
+	var doc xmlMetadata
+	if err = xml.Unmarshal(b, &doc); err != nil {
+		err = fmt.Errorf("onvif: metadata invalid: %s", err)
+		return
+	}
+	for _, f := range doc.Frames {
+		frame := Frame{Time: parseTime(f.UtcTime)}
+		tx, ty, sx, sy := 0.0, 0.0, 1.0, 1.0
+		if t := f.Transformation; t != nil {
+			if t.Translate != nil {
+				tx, ty = t.Translate.X, t.Translate.Y
+			}
+			if t.Scale != nil {
+				sx, sy = t.Scale.X, t.Scale.Y
+			}
+		}
+		for _, o := range f.Objects {
+			object := Object{ID: o.ObjectId}
+			if shape := o.Shape; shape != nil {
+				box := shape.BoundingBox
+				object.BoundingBox = Rect{box.Left*sx + tx, box.Top*sy + ty, box.Right*sx + tx, box.Bottom*sy + ty}
+				object.CenterOfGravity = Point{shape.CenterOfGravity.X*sx + tx, shape.CenterOfGravity.Y*sy + ty}
+			}
+			for _, c := range o.Class.Candidates {
+				object.Classes = append(object.Classes, Class{Type: strings.TrimSpace(c.Type), Likelihood: c.Likelihood})
+			}
+			for _, c := range o.Class.Types {
+				object.Classes = append(object.Classes, Class{Type: strings.TrimSpace(c.Type), Likelihood: c.Likelihood})
+			}
+			frame.Objects = append(frame.Objects, object)
+		}
+		metadata.Frames = append(metadata.Frames, frame)
+	}
+	for _, n := range doc.Notifications {
+		metadata.Notifications = append(metadata.Notifications, Notification{
+			Topic:     strings.TrimSpace(n.Topic),
+			Time:      parseTime(n.Message.UtcTime),
+			Operation: n.Message.PropertyOperation,
+			Source:    simpleItems(n.Message.Source),
+			Data:      simpleItems(n.Message.Data),
+		})
+	}
+	return
+}
@@ -0,0 +1,189 @@
+package onvif
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// an ONVIF 1.0 scene description in pixels, as sent by Axis cameras
+const sceneV1 = `<?xml version="1.0" encoding="UTF-8"?>
+<tt:MetadataStream xmlns:tt="http://www.onvif.org/ver10/schema">
+<tt:VideoAnalytics>
+<tt:Frame UtcTime="2023-05-04T10:11:12.345Z">
+<tt:Transformation>
+<tt:Translate x="-1.0" y="1.0"/>
+<tt:Scale x="0.003125" y="-0.00416667"/>
+</tt:Transformation>
+<tt:Object ObjectId="12">
+<tt:Appearance>
+<tt:Shape>
+<tt:BoundingBox left="160.0" top="120.0" right="320.0" bottom="360.0"/>
+<tt:CenterOfGravity x="240.0" y="240.0"/>
+</tt:Shape>
+<tt:Class>
+<tt:ClassCandidate>
+<tt:Type>Human</tt:Type>
+<tt:Likelihood>0.8</tt:Likelihood>
+</tt:ClassCandidate>
+<tt:ClassCandidate>
+<tt:Type>Vehical</tt:Type>
+<tt:Likelihood>0.2</tt:Likelihood>
+</tt:ClassCandidate>
+</tt:Class>
+</tt:Appearance>
+</tt:Object>
+<tt:Object ObjectId="13"/>
+</tt:Frame>
+</tt:VideoAnalytics>
+</tt:MetadataStream>`
+
+// an ONVIF 2.x scene description, normalized, as sent by Hikvision cameras
+const sceneV2 = `<?xml version="1.0" encoding="UTF-8"?>
+<tt:MetadataStream xmlns:tt="http://www.onvif.org/ver10/schema" xmlns:fc="http://www.onvif.org/ver20/analytics/humanface">
+<tt:VideoAnalytics>
+<tt:Frame UtcTime="2023-05-04T10:11:13Z">
+<tt:Object ObjectId="7">
+<tt:Appearance>
+<tt:Shape>
+<tt:BoundingBox left="-0.5" top="0.5" right="0.25" bottom="-0.25"/>
+<tt:CenterOfGravity x="-0.125" y="0.125"/>
+</tt:Shape>
+<tt:Class>
+<tt:Type Likelihood="0.93"> Vehicle </tt:Type>
+<tt:Type Likelihood="0.4">LicensePlate</tt:Type>
+</tt:Class>
+</tt:Appearance>
+</tt:Object>
+</tt:Frame>
+<tt:Frame UtcTime="2023-05-04T10:11:13.5Z"></tt:Frame>
+</tt:VideoAnalytics>
+</tt:MetadataStream>`
+
+// a motion event, as sent by most cameras
+const motionEvent = `<?xml version="1.0" encoding="UTF-8"?>
+<tt:MetadataStream xmlns:tt="http://www.onvif.org/ver10/schema" xmlns:wsnt="http://docs.oasis-open.org/wsn/b-2" xmlns:tns1="http://www.onvif.org/ver10/topics">
+<tt:Event>
+<wsnt:NotificationMessage>
+<wsnt:Topic Dialect="http://www.onvif.org/ver10/tev/topicExpression/ConcreteSet">
+tns1:RuleEngine/CellMotionDetector/Motion
+</wsnt:Topic>
+<wsnt:Message>
+<tt:Message UtcTime="2023-05-04T10:11:14.000Z" PropertyOperation="Changed">
+<tt:Source>
+<tt:SimpleItem Name="VideoSourceConfigurationToken" Value="VideoSourceToken"/>
+<tt:SimpleItem Name="VideoAnalyticsConfigurationToken" Value="VideoAnalyticsToken"/>
+<tt:SimpleItem Name="Rule" Value="MyMotionDetectorRule"/>
+</tt:Source>
+<tt:Data>
+<tt:SimpleItem Name="IsMotion" Value="true"/>
+</tt:Data>
+</tt:Message>
+</wsnt:Message>
+</wsnt:NotificationMessage>
+<wsnt:NotificationMessage>
+<wsnt:Topic Dialect="http://www.onvif.org/ver10/tev/topicExpression/ConcreteSet">tns1:Device/Trigger/DigitalInput</wsnt:Topic>
+<wsnt:Message>
+<tt:Message UtcTime="2023-05-04T10:11:15Z" PropertyOperation="Initialized">
+<tt:Data>
+<tt:SimpleItem Name="LogicalState" Value="false"/>
+</tt:Data>
+</tt:Message>
+</wsnt:Message>
+</wsnt:NotificationMessage>
+</tt:Event>
+</tt:MetadataStream>`
+
+func TestParse(t *testing.T) {
+	at := func(s string) time.Time {
+		tm, _ := time.Parse(time.RFC3339Nano, s)
+		return tm
+	}
+	for _, test := range []struct {
+		name string
+		doc  string
+		want Metadata
+	}{
+		{"onvif 1.0 scene", sceneV1, Metadata{Frames: []Frame{{
+			Time: at("2023-05-04T10:11:12.345Z"),
+			Objects: []Object{
+				{
+					ID:              12,
+					BoundingBox:     Rect{-0.5, 0.5, 0, -0.5},
+					CenterOfGravity: Point{-0.25, 0},
+					Classes:         []Class{{"Human", 0.8}, {"Vehical", 0.2}},
+				},
+				{ID: 13},
+			},
+		}}}},
+		{"onvif 2.x scene", sceneV2, Metadata{Frames: []Frame{
+			{
+				Time: at("2023-05-04T10:11:13Z"),
+				Objects: []Object{{
+					ID:              7,
+					BoundingBox:     Rect{-0.5, 0.5, 0.25, -0.25},
+					CenterOfGravity: Point{-0.125, 0.125},
+					Classes:         []Class{{"Vehicle", 0.93}, {"LicensePlate", 0.4}},
+				}},
+			},
+			{Time: at("2023-05-04T10:11:13.5Z")},
+		}}},
+		{"events", motionEvent, Metadata{Notifications: []Notification{
+			{
+				Topic:     "tns1:RuleEngine/CellMotionDetector/Motion",
+				Time:      at("2023-05-04T10:11:14Z"),
+				Operation: "Changed",
+				Source: map[string]string{
+					"VideoSourceConfigurationToken":    "VideoSourceToken",
+					"VideoAnalyticsConfigurationToken": "VideoAnalyticsToken",
+					"Rule":                             "MyMotionDetectorRule",
+				},
+				Data: map[string]string{"IsMotion": "true"},
+			},
+			{
+				Topic:     "tns1:Device/Trigger/DigitalInput",
+				Time:      at("2023-05-04T10:11:15Z"),
+				Operation: "Initialized",
+				Data:      map[string]string{"LogicalState": "false"},
+			},
+		}}},
+	} {
+		metadata, err := Parse([]byte(test.doc))
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if !metadataEqual(metadata, test.want) {
+			t.Errorf("%s: parsed\n%+v\nwant\n%+v", test.name, metadata, test.want)
+		}
+	}
+
+	if _, err := Parse([]byte(sceneV1[:200])); err == nil {
+		t.Error("truncated document parsed")
+	}
+}
+
+// metadataEqual compares coordinates to a precision below what cameras
+// send, the scale of sceneV1 is rounded.
+func metadataEqual(a, b Metadata) bool {
+	near := func(x, y float64) bool { return x-y < 1e-5 && y-x < 1e-5 }
+	if len(a.Frames) != len(b.Frames) || !reflect.DeepEqual(a.Notifications, b.Notifications) {
+		return false
+	}
+	for i, fa := range a.Frames {
+		fb := b.Frames[i]
+		if !fa.Time.Equal(fb.Time) || len(fa.Objects) != len(fb.Objects) {
+			return false
+		}
+		for j, oa := range fa.Objects {
+			ob := fb.Objects[j]
+			ra, rb := oa.BoundingBox, ob.BoundingBox
+			if oa.ID != ob.ID || !reflect.DeepEqual(oa.Classes, ob.Classes) ||
+				!near(ra.Left, rb.Left) || !near(ra.Top, rb.Top) || !near(ra.Right, rb.Right) || !near(ra.Bottom, rb.Bottom) ||
+				!near(oa.CenterOfGravity.X, ob.CenterOfGravity.X) || !near(oa.CenterOfGravity.Y, ob.CenterOfGravity.Y) {
+				return false
+			}
+		}
+	}
+	return true
+}
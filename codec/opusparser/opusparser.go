@@ -9,6 +9,7 @@ import (
 
 type CodecData struct {
 	Channels int
+	PreSkip  int // encoder priming samples at 48kHz, none for RTP and WebRTC sources
 }
 
 func NewCodecData(channels int) *CodecData {
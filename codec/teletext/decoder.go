@@ -0,0 +1,152 @@
+package teletext
+
+import (
+	"fmt"
+	"math/bits"
+	"strings"
+	"time"
+)
+
+// Data unit ids of EN 300 472 carrying teletext packets.
+const (
+	DataUnitNonSubtitle = 0x02
+	DataUnitSubtitle    = 0x03
+)
+
+const (
+	startBox = 0x0b
+	endBox   = 0x0a
+)
+
+// Text is the content of a page once fully transmitted, shown from Time
+// until the next Text of the same page.
+type Text struct {
+	Time time.Duration
+	Text string
+}
+
+// Decoder rebuilds the rows of one page out of the PES payloads of a
+// teletext stream. National option characters are decoded as plain ASCII.
+type Decoder struct {
+	Page int // as displayed, such as 888
+
+	open     bool
+	start    time.Duration
+	subtitle bool
+	serial   bool
+	rows     [24]string
+}
+
+func NewDecoder(page int) *Decoder {
+	return &Decoder{Page: page}
+}
+
+func unham84(b byte) byte {
+	return b>>1&1 | b>>2&2 | b>>3&4 | b>>4&8
+}
+
+// Decode feeds the PES payload of a packet and returns the pages it
+// completed, usually none or one.
+func (self *Decoder) Decode(data []byte, tm time.Duration) (texts []Text, err error) {
+	if len(data) < 1 {
+		return
+	}
+	if data[0] < 0x10 || data[0] > 0x1f {
+		err = fmt.Errorf("teletext: invalid data identifier 0x%02x", data[0])
+		return
+	}
+	for b := data[1:]; len(b) >= 2; {
+		id, n := b[0], int(b[1])
+		if len(b) < 2+n {
+			err = fmt.Errorf("teletext: data unit truncated")
+			return
+		}
+		if (id == DataUnitNonSubtitle || id == DataUnitSubtitle) && n == 44 {
+			texts = append(texts, self.packet(b[2:2+n], tm)...)
+		}
+		b = b[2+n:]
+	}
+	return
+}
+
+func (self *Decoder) packet(unit []byte, tm time.Duration) (texts []Text) {
+	// unit[0] is field parity and line offset, unit[1] the framing code
+	var p [42]byte
+	for i := range p {
+		p[i] = bits.Reverse8(unit[2+i])
+	}
+	addr := unham84(p[0]) | unham84(p[1])<<4
+	magazine := int(addr & 7)
+	if magazine == 0 {
+		magazine = 8
+	}
+	row := int(addr >> 3)
+	data := p[2:]
+
+	if row == 0 {
+		page := int(unham84(data[1]))*10 + int(unham84(data[0]))
+		if unham84(data[1]) > 9 || unham84(data[0]) > 9 {
+			// time filling header or non decimal page
+			page = -1
+		}
+		if self.open && (self.serial || magazine == self.Page/100) {
+			texts = append(texts, self.flush())
+		}
+		if page >= 0 && magazine*100+page == self.Page {
+			self.open = true
+			self.start = tm
+			self.subtitle = unham84(data[5])&8 != 0
+			self.serial = unham84(data[7])&1 != 0
+			if unham84(data[3])&8 != 0 {
+				self.rows = [24]string{}
+			}
+		}
+		return
+	}
+	if self.open && row < 24 && magazine == self.Page/100 {
+		self.rows[row] = self.text(data)
+	}
+	return
+}
+
+func (self *Decoder) text(data []byte) string {
+	var sb strings.Builder
+	boxed := !self.subtitle
+	for _, b := range data {
+		c := b & 0x7f
+		switch {
+		case c == startBox:
+			boxed = true
+			sb.WriteByte(' ')
+		case c == endBox:
+			if self.subtitle {
+				boxed = false
+			}
+			sb.WriteByte(' ')
+		case !boxed, c < 0x20, c == 0x7f:
+			sb.WriteByte(' ')
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	return strings.Join(strings.Fields(sb.String()), " ")
+}
+
+func (self *Decoder) flush() (text Text) {
+	var lines []string
+	for _, row := range self.rows[1:] {
+		if row != "" {
+			lines = append(lines, row)
+		}
+	}
+	self.open = false
+	return Text{Time: self.start, Text: strings.Join(lines, "\n")}
+}
+
+// Flush returns the page being transmitted, if any, at the end of the stream.
+func (self *Decoder) Flush() (texts []Text) {
+	if self.open {
+		texts = append(texts, self.flush())
+	}
+	return
+}
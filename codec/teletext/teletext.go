@@ -0,0 +1,79 @@
+// Package teletext holds the codec data of DVB teletext streams (EN 300 472),
+// which are carried as is, and decodes the text of their pages.
+package teletext
+
+import (
+	"fmt"
+
+	"github.com/deepch/vdk/av"
+)
+
+// Page types of the teletext descriptor.
+const (
+	PageInitial                 = 0x01
+	PageSubtitle                = 0x02
+	PageAdditionalInfo          = 0x03
+	PageProgrammeSchedule       = 0x04
+	PageHearingImpairedSubtitle = 0x05
+)
+
+// Page is an entry of the teletext descriptor.
+type Page struct {
+	Language string
+	Type     uint8
+	Magazine uint8 // 1 to 8
+	Page     uint8 // BCD, 0x88 for page 888
+}
+
+// Number returns the page as usually displayed, such as 888.
+func (self Page) Number() int {
+	return int(self.Magazine)*100 + int(self.Page>>4)*10 + int(self.Page&0xf)
+}
+
+// CodecData keeps the teletext descriptor of the stream so it can be
+// written back when remuxing.
+type CodecData struct {
+	Descriptor []byte
+}
+
+func NewCodecData(descriptor []byte) CodecData {
+	return CodecData{Descriptor: append([]byte(nil), descriptor...)}
+}
+
+// NewCodecDataFromPages builds the descriptor from pages.
+func NewCodecDataFromPages(pages []Page) (self CodecData, err error) {
+	for _, page := range pages {
+		if len(page.Language) != 3 {
+			err = fmt.Errorf("teletext: language `%s` is not an ISO 639 code", page.Language)
+			return
+		}
+		magazine := page.Magazine
+		if magazine == 8 {
+			magazine = 0
+		}
+		self.Descriptor = append(self.Descriptor, page.Language...)
+		self.Descriptor = append(self.Descriptor, page.Type<<3|magazine&7, page.Page)
+	}
+	return
+}
+
+func (self CodecData) Type() av.CodecType {
+	return av.DVB_TELETEXT
+}
+
+func (self CodecData) Pages() (pages []Page) {
+	b := self.Descriptor
+	for ; len(b) >= 5; b = b[5:] {
+		page := Page{
+			Language: string(b[:3]),
+			Type:     b[3] >> 3,
+			Magazine: b[3] & 7,
+			Page:     b[4],
+		}
+		if page.Magazine == 0 {
+			page.Magazine = 8
+		}
+		pages = append(pages, page)
+	}
+	return
+}
@@ -0,0 +1,246 @@
+// Package vp9parser reads the VP9 frame headers that tell a stream's size
+// and format, and builds the VP codec configuration record of MP4 and
+// WebM.
+package vp9parser
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/deepch/vdk/av"
+	"github.com/deepch/vdk/utils/bits"
+)
+
+// VPCodecConfRecord is the content of a vpcC box, version 1.
+type VPCodecConfRecord struct {
+	Profile                 uint8
+	Level                   uint8
+	BitDepth                uint8
+	ChromaSubsampling       uint8 // 0 and 1 are 4:2:0, 2 is 4:2:2, 3 is 4:4:4
+	VideoFullRange          bool
+	ColourPrimaries         uint8
+	TransferCharacteristics uint8
+	MatrixCoefficients      uint8
+}
+
+func (self VPCodecConfRecord) Len() int {
+	return 8
+}
+
+func (self VPCodecConfRecord) Marshal(b []byte) (n int) {
+	b[0] = self.Profile
+	b[1] = self.Level
+	b[2] = self.BitDepth<<4 | self.ChromaSubsampling<<1
+	if self.VideoFullRange {
+		b[2] |= 1
+	}
+	b[3] = self.ColourPrimaries
+	b[4] = self.TransferCharacteristics
+	b[5] = self.MatrixCoefficients
+	b[6], b[7] = 0, 0 // no codec initialization data
+	return 8
+}
+
+func (self *VPCodecConfRecord) Unmarshal(b []byte) (n int, err error) {
+	if len(b) < 8 {
+		err = fmt.Errorf("vp9parser: vpcC too short")
+		return
+	}
+	self.Profile = b[0]
+	self.Level = b[1]
+	self.BitDepth = b[2] >> 4
+	self.ChromaSubsampling = b[2] >> 1 & 7
+	self.VideoFullRange = b[2]&1 != 0
+	self.ColourPrimaries = b[3]
+	self.TransferCharacteristics = b[4]
+	self.MatrixCoefficients = b[5]
+	n = 8 + (int(b[6])<<8 | int(b[7]))
+	return
+}
+
+// FrameInfo is what the uncompressed header of a key frame tells.
+type FrameInfo struct {
+	Profile      int
+	BitDepth     int
+	ColorSpace   int // 7 is RGB
+	FullRange    bool
+	SubsamplingX bool
+	SubsamplingY bool
+	Width        int
+	Height       int
+}
+
+const colorSpaceRGB = 7
+
+// ParseKeyFrame reads the uncompressed header of a key frame, the first
+// frame of a superframe.
+func ParseKeyFrame(frame []byte) (info FrameInfo, err error) {
+	r := &bits.GolombBitReader{R: bytes.NewReader(frame)}
+	var v uint
+	if v, err = r.ReadBits(2); err != nil {
+		return
+	}
+	if v != 2 {
+		err = fmt.Errorf("vp9parser: frame marker invalid")
+		return
+	}
+	var lo, hi uint
+	if lo, err = r.ReadBit(); err != nil {
+		return
+	}
+	if hi, err = r.ReadBit(); err != nil {
+		return
+	}
+	info.Profile = int(hi<<1 | lo)
+	if info.Profile == 3 {
+		if _, err = r.ReadBit(); err != nil {
+			return
+		}
+	}
+	// show_existing_frame, frame_type, show_frame, error_resilient_mode
+	if v, err = r.ReadBits(4); err != nil {
+		return
+	}
+	if v&8 != 0 || v&4 != 0 {
+		err = fmt.Errorf("vp9parser: not a key frame")
+		return
+	}
+	if v, err = r.ReadBits(24); err != nil {
+		return
+	}
+	if v != 0x498342 {
+		err = fmt.Errorf("vp9parser: frame sync code invalid")
+		return
+	}
+
+	info.BitDepth = 8
+	if info.Profile >= 2 {
+		if v, err = r.ReadBit(); err != nil {
+			return
+		}
+		info.BitDepth = 10 + 2*int(v)
+	}
+	if v, err = r.ReadBits(3); err != nil {
+		return
+	}
+	info.ColorSpace = int(v)
+	if info.ColorSpace != colorSpaceRGB {
+		if v, err = r.ReadBit(); err != nil {
+			return
+		}
+		info.FullRange = v != 0
+		info.SubsamplingX, info.SubsamplingY = true, true
+		if info.Profile == 1 || info.Profile == 3 {
+			if v, err = r.ReadBits(3); err != nil {
+				return
+			}
+			info.SubsamplingX, info.SubsamplingY = v&4 != 0, v&2 != 0
+		}
+	} else {
+		info.FullRange = true
+		if info.Profile == 1 || info.Profile == 3 {
+			if _, err = r.ReadBit(); err != nil {
+				return
+			}
+		}
+	}
+
+	if v, err = r.ReadBits(16); err != nil {
+		return
+	}
+	info.Width = int(v) + 1
+	if v, err = r.ReadBits(16); err != nil {
+		return
+	}
+	info.Height = int(v) + 1
+	return
+}
+
+// levels by the largest picture, from section A of the VP9 levels.
+var levels = []struct {
+	level uint8
+	size  int
+}{
+	{10, 36864}, {11, 73728}, {20, 122880}, {21, 245760}, {30, 552960},
+	{31, 983040}, {40, 2228224}, {50, 8912896}, {60, 35651584},
+}
+
+type CodecData struct {
+	Record VPCodecConfRecord
+	width  int
+	height int
+}
+
+func (self CodecData) Type() av.CodecType {
+	return av.VP9
+}
+
+func (self CodecData) Width() int {
+	return self.width
+}
+
+func (self CodecData) Height() int {
+	return self.height
+}
+
+// VPCodecConfRecordBytes returns the content of the vpcC box.
+func (self CodecData) VPCodecConfRecordBytes() []byte {
+	b := make([]byte, self.Record.Len())
+	self.Record.Marshal(b)
+	return b
+}
+
+// NewCodecDataFromVPCodecConfRecord makes the codec of a vpcC box content,
+// the size coming from the sample entry.
+func NewCodecDataFromVPCodecConfRecord(record []byte, width, height int) (self CodecData, err error) {
+	if _, err = self.Record.Unmarshal(record); err != nil {
+		return
+	}
+	self.width, self.height = width, height
+	return
+}
+
+// NewCodecDataFromKeyFrame makes the codec of the stream starting with
+// frame, as for a stream received over RTP.
+func NewCodecDataFromKeyFrame(frame []byte) (self CodecData, err error) {
+	var info FrameInfo
+	if info, err = ParseKeyFrame(frame); err != nil {
+		return
+	}
+	self.width, self.height = info.Width, info.Height
+	record := VPCodecConfRecord{
+		Profile:                 uint8(info.Profile),
+		BitDepth:                uint8(info.BitDepth),
+		VideoFullRange:          info.FullRange,
+		ColourPrimaries:         2, // unspecified
+		TransferCharacteristics: 2,
+		MatrixCoefficients:      2,
+	}
+	switch {
+	case info.SubsamplingX && info.SubsamplingY:
+		record.ChromaSubsampling = 1
+	case info.SubsamplingX:
+		record.ChromaSubsampling = 2
+	default:
+		record.ChromaSubsampling = 3
+	}
+	switch info.ColorSpace {
+	case 1, 3: // BT.601, SMPTE 170
+		record.ColourPrimaries, record.TransferCharacteristics, record.MatrixCoefficients = 6, 6, 6
+	case 2:
+		record.ColourPrimaries, record.TransferCharacteristics, record.MatrixCoefficients = 1, 1, 1
+	case 5:
+		record.ColourPrimaries, record.TransferCharacteristics, record.MatrixCoefficients = 9, 14, 9
+	case colorSpaceRGB:
+		record.MatrixCoefficients = 0
+	}
+	record.Level = levels[len(levels)-1].level
+	for _, l := range levels {
+		if info.Width*info.Height <= l.size {
+			record.Level = l.level
+			break
+		}
+	}
+	self.Record = record
+	return
+}
@@ -0,0 +1,235 @@
+package events
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const DefaultRetryInterval = 5 * time.Second
+
+// Client reads the event stream of a camera over HTTP and publishes its
+// events on Bus. Use NewHikvision or NewDahua.
+type Client struct {
+	Name          string // Source of the events, the host if empty
+	Bus           *Bus
+	HTTPClient    *http.Client
+	RetryInterval time.Duration // DefaultRetryInterval if zero
+	HoldTime      time.Duration // Hikvision events not repeated for it stop, DefaultHoldTime if zero
+	OnError       func(err error)
+
+	vendor string
+	url    *url.URL
+	path   string
+	parse  func(part []byte, now time.Time) []Event
+}
+
+func newClient(rawURL string, bus *Bus) (self *Client, err error) {
+	self = &Client{Bus: bus}
+	if self.url, err = url.Parse(rawURL); err != nil {
+		return
+	}
+	if self.url.Scheme == "" {
+		err = fmt.Errorf("events: url `%s` has no scheme", rawURL)
+	}
+	return
+}
+
+func (self *Client) source() string {
+	if self.Name != "" {
+		return self.Name
+	}
+	return self.url.Host
+}
+
+// Run reads events until ctx is done, reconnecting when the stream fails.
+func (self *Client) Run(ctx context.Context) error {
+	retry := self.RetryInterval
+	if retry == 0 {
+		retry = DefaultRetryInterval
+	}
+	for {
+		err := self.read(ctx)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil && self.OnError != nil {
+			self.OnError(err)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(retry):
+		}
+	}
+}
+
+func (self *Client) read(ctx context.Context) (err error) {
+	var resp *http.Response
+	if resp, err = self.get(ctx); err != nil {
+		return
+	}
+	defer resp.Body.Close()
+
+	var params map[string]string
+	if _, params, err = mime.ParseMediaType(resp.Header.Get("Content-Type")); err != nil {
+		return
+	}
+	boundary := strings.TrimPrefix(params["boundary"], "--")
+	if boundary == "" {
+		err = fmt.Errorf("events: %s: no multipart boundary", self.source())
+		return
+	}
+	r := multipart.NewReader(resp.Body, boundary)
+	for {
+		var part *multipart.Part
+		if part, err = r.NextPart(); err != nil {
+			if err == io.EOF {
+				err = fmt.Errorf("events: %s: stream closed", self.source())
+			}
+			return
+		}
+		typ := part.Header.Get("Content-Type")
+		if strings.HasPrefix(typ, "image/") {
+			part.Close()
+			continue
+		}
+		var body []byte
+		if body, err = io.ReadAll(io.LimitReader(part, 1<<20)); err != nil {
+			return
+		}
+		for _, event := range self.parse(body, time.Now()) {
+			event.Source = self.source()
+			event.Vendor = self.vendor
+			if self.Bus != nil {
+				self.Bus.Publish(event)
+			}
+		}
+	}
+}
+
+func (self *Client) get(ctx context.Context) (resp *http.Response, err error) {
+	client := self.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	u := *self.url
+	u.User = nil
+	u.Path, u.RawQuery = splitPath(self.path)
+	user := self.url.User.Username()
+	pass, _ := self.url.User.Password()
+
+	var authorization string
+	for try := 0; try < 2; try++ {
+		var req *http.Request
+		if req, err = http.NewRequestWithContext(ctx, "GET", u.String(), nil); err != nil {
+			return
+		}
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		} else if user != "" {
+			req.SetBasicAuth(user, pass)
+		}
+		if resp, err = client.Do(req); err != nil {
+			return
+		}
+		if resp.StatusCode == http.StatusUnauthorized && authorization == "" && user != "" {
+			challenge := resp.Header.Get("WWW-Authenticate")
+			resp.Body.Close()
+			if !strings.HasPrefix(challenge, "Digest ") {
+				break
+			}
+			authorization = digestAuthorization(challenge, "GET", u.RequestURI(), user, pass)
+			continue
+		}
+		break
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		err = fmt.Errorf("events: %s: %s", self.source(), resp.Status)
+		resp = nil
+	}
+	return
+}
+
+func splitPath(path string) (string, string) {
+	if i := strings.IndexByte(path, '?'); i >= 0 {
+		return path[:i], path[i+1:]
+	}
+	return path, ""
+}
+
+// splitQuoted splits s on the commas outside of double quotes, as in
+// qop="auth,auth-int".
+func splitQuoted(s string) (fields []string) {
+	quoted := false
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '"':
+			quoted = !quoted
+		case ',':
+			if !quoted {
+				fields = append(fields, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(fields, s[start:])
+}
+
+func digestParams(challenge string) map[string]string {
+	params := map[string]string{}
+	for _, field := range splitQuoted(strings.TrimPrefix(challenge, "Digest ")) {
+		if k, v, ok := strings.Cut(strings.TrimSpace(field), "="); ok {
+			params[strings.ToLower(k)] = strings.Trim(v, `"`)
+		}
+	}
+	return params
+}
+
+func md5hex(s string) string {
+	sum := md5.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// digestAuthorization answers an RFC 2617 challenge, with qop auth when
+// offered.
+func digestAuthorization(challenge, method, uri, user, pass string) string {
+	params := digestParams(challenge)
+	realm, nonce := params["realm"], params["nonce"]
+	ha1 := md5hex(user + ":" + realm + ":" + pass)
+	ha2 := md5hex(method + ":" + uri)
+	header := fmt.Sprintf(`Digest username="%s", realm="%s", nonce="%s", uri="%s"`, user, realm, nonce, uri)
+	qop := ""
+	for _, q := range strings.Split(params["qop"], ",") {
+		if strings.TrimSpace(q) == "auth" {
+			qop = "auth"
+		}
+	}
+	if qop != "" {
+		b := make([]byte, 8)
+		rand.Read(b)
+		cnonce := hex.EncodeToString(b)
+		response := md5hex(ha1 + ":" + nonce + ":00000001:" + cnonce + ":auth:" + ha2)
+		header += fmt.Sprintf(`, qop=auth, nc=00000001, cnonce="%s", response="%s"`, cnonce, response)
+	} else {
+		header += fmt.Sprintf(`, response="%s"`, md5hex(ha1+":"+nonce+":"+ha2))
+	}
+	if opaque, ok := params["opaque"]; ok {
+		header += fmt.Sprintf(`, opaque="%s"`, opaque)
+	}
+	if algorithm, ok := params["algorithm"]; ok {
+		header += fmt.Sprintf(`, algorithm=%s`, algorithm)
+	}
+	return header
+}
@@ -0,0 +1,124 @@
+package events
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDigestParams(t *testing.T) {
+	for _, test := range []struct {
+		challenge string
+		want      map[string]string
+	}{
+		{`Digest realm="IP Camera(C2358)", nonce="6d6b8c3e2f", qop="auth"`,
+			map[string]string{"realm": "IP Camera(C2358)", "nonce": "6d6b8c3e2f", "qop": "auth"}},
+		{`Digest qop="auth,auth-int", realm="Login to 4K01234PAZ", nonce="2068151452", opaque="a,b", algorithm=MD5`,
+			map[string]string{"qop": "auth,auth-int", "realm": "Login to 4K01234PAZ", "nonce": "2068151452", "opaque": "a,b", "algorithm": "MD5"}},
+		{`Digest realm="a, b",nonce="n"`, map[string]string{"realm": "a, b", "nonce": "n"}},
+		{`Digest`, map[string]string{}},
+	} {
+		params := digestParams(test.challenge)
+		if fmt.Sprint(params) != fmt.Sprint(test.want) {
+			t.Errorf("%s: %v", test.challenge, params)
+		}
+	}
+}
+
+func TestDigestAuthorization(t *testing.T) {
+	for _, test := range []struct {
+		challenge string
+		qop       bool
+	}{
+		{`Digest realm="r", nonce="n", qop="auth-int,auth", opaque="o,p"`, true},
+		{`Digest realm="r", nonce="n", qop="auth-int"`, false},
+		{`Digest realm="r", nonce="n"`, false},
+	} {
+		header := digestAuthorization(test.challenge, "GET", "/events", "admin", "pass")
+		params := digestParams(header)
+		if params["username"] != "admin" || params["realm"] != "r" || params["nonce"] != "n" || params["uri"] != "/events" {
+			t.Errorf("%s: %s", test.challenge, header)
+		}
+		ha1, ha2 := md5hex("admin:r:pass"), md5hex("GET:/events")
+		want := md5hex(ha1 + ":n:" + ha2)
+		if test.qop {
+			want = md5hex(ha1 + ":n:" + params["nc"] + ":" + params["cnonce"] + ":auth:" + ha2)
+			if params["qop"] != "auth" || params["opaque"] != "o,p" {
+				t.Errorf("%s: %s", test.challenge, header)
+			}
+		}
+		if params["response"] != want {
+			t.Errorf("%s: response %s, want %s", test.challenge, params["response"], want)
+		}
+	}
+}
+
+func TestClientRead(t *testing.T) {
+	const challenge = `Digest realm="Login to 4K01234PAZ", qop="auth", nonce="2068151452", opaque="x,y"`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/cgi-bin/eventManager.cgi" || r.URL.Query().Get("action") != "attach" {
+			http.NotFound(w, r)
+			return
+		}
+		params := digestParams(r.Header.Get("Authorization"))
+		ha1, ha2 := md5hex("admin:Login to 4K01234PAZ:pass"), md5hex("GET:"+params["uri"])
+		if params["response"] != md5hex(ha1+":2068151452:"+params["nc"]+":"+params["cnonce"]+":auth:"+ha2) ||
+			params["uri"] != r.URL.RequestURI() || params["opaque"] != "x,y" {
+			w.Header().Set("WWW-Authenticate", challenge)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "multipart/x-mixed-replace; boundary=myboundary")
+		for _, part := range []struct{ typ, body string }{
+			{"text/plain", "Heartbeat\r\n"},
+			{"image/jpeg", "\xff\xd8\xff\xd9"},
+			{"text/plain", "Code=VideoMotion;action=Start;index=0\r\nCode=VideoMotion;action=Stop;index=0\r\n"},
+		} {
+			fmt.Fprintf(w, "--myboundary\r\nContent-Type: %s\r\nContent-Length: %d\r\n\r\n%s\r\n", part.typ, len(part.body), part.body)
+		}
+		fmt.Fprint(w, "--myboundary--\r\n")
+	}))
+	defer server.Close()
+
+	bus := NewBus()
+	events, cancel := bus.Subscribe(10)
+	defer cancel()
+	for _, test := range []struct {
+		url string
+		err string
+	}{
+		{"http://admin:pass@" + server.Listener.Addr().String(), "stream closed"},
+		{"http://admin:wrong@" + server.Listener.Addr().String(), "401"},
+	} {
+		client, err := NewDahua(test.url, bus)
+		if err != nil {
+			t.Fatal(err)
+		}
+		client.Name = "door"
+		ctx, done := context.WithTimeout(context.Background(), 5*time.Second)
+		err = client.read(ctx)
+		done()
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: %v", test.url, err)
+		}
+	}
+	for _, state := range []State{Start, Stop} {
+		select {
+		case event := <-events:
+			if event.Source != "door" || event.Vendor != "dahua" || event.Type != Motion || event.State != state {
+				t.Errorf("event %+v", event)
+			}
+		default:
+			t.Fatalf("no %v event", state)
+		}
+	}
+	select {
+	case event := <-events:
+		t.Errorf("extra event %+v", event)
+	default:
+	}
+}
@@ -0,0 +1,48 @@
+package events
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseDahua(t *testing.T) {
+	now := time.Date(2021, 3, 4, 10, 15, 30, 0, time.UTC)
+	for _, test := range []struct {
+		name string
+		body string
+		want []Event
+	}{
+		{"motion start", "Code=VideoMotion;action=Start;index=0\r\n",
+			[]Event{{Type: Motion, Code: "VideoMotion", Channel: 0, State: Start}}},
+		{"several", "Code=VideoMotion;action=Stop;index=1\r\nCode=AlarmLocal;action=Start;index=3\r\n",
+			[]Event{
+				{Type: Motion, Code: "VideoMotion", Channel: 1, State: Stop},
+				{Type: Input, Code: "AlarmLocal", Channel: 3, State: Start},
+			}},
+		{"heartbeat", "Heartbeat\r\n", nil},
+		{"empty", "", nil},
+		{"pulse", "Code=NTPAdjustTime;action=Pulse;index=0\r\n",
+			[]Event{{Type: Other, Code: "NTPAdjustTime", State: Pulse}}},
+		{"data", "Code=CrossLineDetection;action=Start;index=2;data={\n" +
+			"   \"Class\" : \"Normal\",\n" +
+			"   \"Direction\" : \"LeftToRight\",\n" +
+			"   \"Name\" : \"Rule1;2\"\n" +
+			"}\r\n",
+			[]Event{{Type: LineCrossing, Code: "CrossLineDetection", Channel: 2, State: Start}}},
+		{"bad index", "Code=VideoBlind;action=Start;index=x\r\n",
+			[]Event{{Type: Tamper, Code: "VideoBlind", State: Start}}},
+	} {
+		events := parseDahua([]byte(test.body), now)
+		if len(events) != len(test.want) {
+			t.Errorf("%s: %d events, want %d", test.name, len(events), len(test.want))
+			continue
+		}
+		for i, event := range events {
+			want := test.want[i]
+			if event.Type != want.Type || event.Code != want.Code || event.Channel != want.Channel ||
+				event.State != want.State || !event.Time.Equal(now) || len(event.Raw) == 0 {
+				t.Errorf("%s: event %d %+v, want %+v", test.name, i, event, want)
+			}
+		}
+	}
+}
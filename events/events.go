@@ -0,0 +1,94 @@
+// Package events normalizes the events pushed by cameras, motion or line
+// crossing, so they can drive recording whatever the brand.
+package events
+
+import (
+	"sync"
+	"time"
+)
+
+// Normalized event types.
+const (
+	Motion       = "motion"
+	LineCrossing = "line_crossing"
+	Intrusion    = "intrusion"
+	VideoLoss    = "video_loss"
+	Tamper       = "tamper"
+	Input        = "input"
+	Other        = "other"
+)
+
+type State int
+
+const (
+	Start State = iota
+	Stop
+	Pulse // an instant event, without a stop
+)
+
+func (self State) String() string {
+	switch self {
+	case Start:
+		return "start"
+	case Stop:
+		return "stop"
+	case Pulse:
+		return "pulse"
+	}
+	return ""
+}
+
+type Event struct {
+	Source  string // the client Name
+	Vendor  string
+	Type    string
+	Code    string // the vendor event code
+	Channel int    // from 0
+	State   State
+	Time    time.Time
+	Raw     []byte
+}
+
+// Bus fans events out to subscribers, a subscriber too slow to keep up
+// misses events rather than blocking the clients.
+type Bus struct {
+	lock sync.Mutex
+	subs map[int]chan Event
+	next int
+}
+
+func NewBus() *Bus {
+	return &Bus{subs: map[int]chan Event{}}
+}
+
+// Subscribe returns a channel of buffer events and the func ending the
+// subscription, which closes the channel.
+func (self *Bus) Subscribe(buffer int) (events <-chan Event, cancel func()) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	id := self.next
+	self.next++
+	ch := make(chan Event, buffer)
+	self.subs[id] = ch
+	events = ch
+	cancel = func() {
+		self.lock.Lock()
+		defer self.lock.Unlock()
+		if _, ok := self.subs[id]; ok {
+			delete(self.subs, id)
+			close(ch)
+		}
+	}
+	return
+}
+
+func (self *Bus) Publish(event Event) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	for _, ch := range self.subs {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
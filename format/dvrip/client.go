@@ -10,6 +10,7 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/deepch/vdk/av"
@@ -37,6 +38,18 @@ type Client struct {
 	options             ClientOptions
 	sps                 []byte
 	pps                 []byte
+	Alarms              chan Alarm
+	sendLock            sync.Mutex
+	commandLock         sync.Mutex
+	lock                sync.Mutex
+	monitoring          bool
+	waiting             requestCode
+	responses           chan response
+}
+
+type response struct {
+	payload *Payload
+	body    []byte
 }
 
 type ClientOptions struct {
@@ -52,6 +65,8 @@ func Dial(options ClientOptions) (*Client, error) {
 	client := &Client{
 		Signals:             make(chan int, 100),
 		OutgoingPacketQueue: make(chan *av.Packet, 3000),
+		Alarms:              make(chan Alarm, 100),
+		responses:           make(chan response, 1),
 		options:             options,
 	}
 	err := client.parseURL(html.UnescapeString(client.options.URL))
@@ -130,10 +145,24 @@ func (client *Client) Monitor() {
 			},
 		},
 	})
+	// under the command lock so no Command reads the replies itself once
+	// Monitor does
+	client.commandLock.Lock()
 	err = client.send(1410, payload)
+	if err == nil {
+		client.lock.Lock()
+		client.monitoring = true
+		client.lock.Unlock()
+	}
+	client.commandLock.Unlock()
 	if err != nil {
 		return
 	}
+	defer func() {
+		client.lock.Lock()
+		client.monitoring = false
+		client.lock.Unlock()
+	}()
 	var length uint32 = 0
 	var dataType uint32
 	timer := time.Now()
@@ -146,10 +175,13 @@ func (client *Client) Monitor() {
 			}
 			timer = time.Now()
 		}
-		_, body, err := client.recv(false)
+		p, body, err := client.recv(false)
 		if err != nil {
 			return
 		}
+		if client.dispatch(p, body) {
+			continue
+		}
 		buf := bytes.NewReader(body)
 		err = binary.Read(buf, binary.BigEndian, &dataType)
 		if err != nil {
@@ -282,9 +314,118 @@ func (client *Client) Monitor() {
 	}
 }
 
+// dispatch hands the messages read by Monitor which are not media, the
+// reply to a pending Command or an alarm, and tells if it took the message.
+func (client *Client) dispatch(p *Payload, body []byte) bool {
+	code := requestCode(p.MsgID)
+	if code == codeAlarmInfo {
+		client.handleAlarm(trimText(body))
+		return true
+	}
+	// under the lock so a Command timing out cannot leave the reply for
+	// the next one
+	client.lock.Lock()
+	defer client.lock.Unlock()
+	if client.waiting == 0 || code != client.waiting {
+		return false
+	}
+	client.waiting = 0
+	select {
+	case client.responses <- response{payload: p, body: trimText(body)}:
+	default:
+	}
+	return true
+}
+
+func (client *Client) handleAlarm(body []byte) {
+	msg := struct {
+		AlarmInfo alarmInfo `json:"AlarmInfo"`
+	}{}
+	if err := json.Unmarshal(body, &msg); err != nil {
+		return
+	}
+	alarm := Alarm{
+		Channel: msg.AlarmInfo.Channel,
+		Event:   msg.AlarmInfo.Event,
+		Status:  msg.AlarmInfo.Status,
+	}
+	alarm.StartTime, _ = time.ParseInLocation(timeLayout, msg.AlarmInfo.StartTime, time.Local)
+	select {
+	case client.Alarms <- alarm:
+	default:
+	}
+}
+
+//SetTime func
 func (client *Client) SetTime() error {
-	_, _, err := client.Command(codeOPTimeSetting, time.Now().Format("2006-01-02 15:04:05"))
-	return err
+	return client.SetTimeAt(time.Now())
+}
+
+// SetTimeAt sets the device clock, in the local time of the device.
+func (client *Client) SetTimeAt(tm time.Time) error {
+	_, body, err := client.Command(codeOPTimeSetting, tm.Format(timeLayout))
+	if err != nil {
+		return err
+	}
+	return checkRet(body)
+}
+
+// GetTime returns the device clock, read as local time.
+func (client *Client) GetTime() (time.Time, error) {
+	var value string
+	if err := client.query(codeOPTimeQuery, &value); err != nil {
+		return time.Time{}, err
+	}
+	return time.ParseInLocation(timeLayout, value, time.Local)
+}
+
+func (client *Client) GetSystemInfo() (info SystemInfo, err error) {
+	err = client.query(codeSystemInfo, &info)
+	return
+}
+
+// SubscribeAlarms asks the device to push its alarms, which are sent to
+// Alarms while Monitor runs.
+func (client *Client) SubscribeAlarms() error {
+	_, body, err := client.Command(codeAlarmSet, nil)
+	if err != nil {
+		return err
+	}
+	return checkRet(body)
+}
+
+// query runs a command without parameters and decodes the member of the
+// reply named after it into v.
+func (client *Client) query(command requestCode, v interface{}) error {
+	_, body, err := client.Command(command, nil)
+	if err != nil {
+		return err
+	}
+	if err = checkRet(body); err != nil {
+		return err
+	}
+	reply := map[string]json.RawMessage{}
+	if err = json.Unmarshal(body, &reply); err != nil {
+		return err
+	}
+	value, ok := reply[requestCodes[command]]
+	if !ok {
+		return fmt.Errorf("dvrip: %s missing from reply", requestCodes[command])
+	}
+	return json.Unmarshal(value, v)
+}
+
+func checkRet(body []byte) error {
+	res := struct {
+		Ret int `json:"Ret"`
+	}{}
+	if err := json.Unmarshal(body, &res); err != nil {
+		return err
+	}
+	if statusCode(res.Ret) != statusOK {
+		return fmt.Errorf("unexpected status code: %v - %v", res.Ret, statusCodes[statusCode(res.Ret)])
+	}
+	return nil
 }
 func (client *Client) Login() error {
 	body, err := json.Marshal(map[string]string{
@@ -321,26 +462,55 @@ func (client *Client) Login() error {
 	return nil
 }
 
-//Command func
+//Command func, the reply is read directly or, while Monitor runs on the
+//same session, handed over by it.
 func (client *Client) Command(command requestCode, data interface{}) (*Payload, []byte, error) {
-	params, err := json.Marshal(map[string]interface{}{
-		"Name":                requestCodes[command],
-		"SessionID":           fmt.Sprintf("0x%08X", client.session),
-		requestCodes[command]: data,
-	})
+	request := map[string]interface{}{
+		"Name":      requestCodes[command],
+		"SessionID": fmt.Sprintf("0x%08X", client.session),
+	}
+	if data != nil {
+		request[requestCodes[command]] = data
+	}
+	params, err := json.Marshal(request)
 	if err != nil {
 		return nil, nil, err
 	}
+	client.commandLock.Lock()
+	defer client.commandLock.Unlock()
+	client.lock.Lock()
+	monitoring := client.monitoring
+	if monitoring {
+		client.waiting = command + 1
+	}
+	client.lock.Unlock()
 	err = client.send(command, params)
 	if err != nil {
 		return nil, nil, err
 	}
-	resp, body, err := client.recv(true)
-	return resp, body, err
+	if !monitoring {
+		return client.recv(true)
+	}
+	select {
+	case resp := <-client.responses:
+		return resp.payload, resp.body, nil
+	case <-time.After(5 * time.Second):
+		client.lock.Lock()
+		client.waiting = 0
+		// a reply dispatched while timing out
+		select {
+		case <-client.responses:
+		default:
+		}
+		client.lock.Unlock()
+		return nil, nil, fmt.Errorf("dvrip: %s timed out", requestCodes[command])
+	}
 }
 
 //send func
 func (client *Client) send(msgID requestCode, data []byte) error {
+	client.sendLock.Lock()
+	defer client.sendLock.Unlock()
 	var buf bytes.Buffer
 	if err := binary.Write(&buf, binary.LittleEndian, Payload{
 		Head:           255,
@@ -376,10 +546,13 @@ func (client *Client) send(msgID requestCode, data []byte) error {
 func (client *Client) recvSize(buffer *bytes.Buffer, size uint32) ([]byte, error) {
 	all := uint32(0)
 	for {
-		_, body, err := client.recv(false)
+		p, body, err := client.recv(false)
 		if err != nil {
 			return nil, err
 		}
+		if client.dispatch(p, body) {
+			continue
+		}
 		all += uint32(len(body))
 		buffer.Write(body)
 		if all == size {
@@ -416,12 +589,19 @@ func (client *Client) recv(text bool) (*Payload, []byte, error) {
 	if err != nil {
 		return nil, nil, err
 	}
-	if text && len(body) > 2 && bytes.Compare(body[len(body)-2:], []byte{10, 0}) == 0 {
-		body = body[:len(body)-2]
+	if text {
+		body = trimText(body)
 	}
 	return &p, body, nil
 }
 
+func trimText(body []byte) []byte {
+	if len(body) > 2 && bytes.Compare(body[len(body)-2:], []byte{10, 0}) == 0 {
+		body = body[:len(body)-2]
+	}
+	return body
+}
+
 //parseURL func
 func (client *Client) parseURL(rawURL string) error {
 	l, err := url.Parse(rawURL)
@@ -107,6 +107,46 @@ var statusCodes = map[statusCode]string{
 var requestCodes = map[requestCode]string{
 	codeOPMonitor:     "OPMonitor",
 	codeOPTimeSetting: "OPTimeSetting",
+	codeOPTimeQuery:   "OPTimeQuery",
+	codeSystemInfo:    "SystemInfo",
+	codeAlarmSet:      "AlarmSet",
+}
+
+const timeLayout = "2006-01-02 15:04:05"
+
+type SystemInfo struct {
+	AlarmInChannel  int    `json:"AlarmInChannel"`
+	AlarmOutChannel int    `json:"AlarmOutChannel"`
+	AudioInChannel  int    `json:"AudioInChannel"`
+	BuildTime       string `json:"BuildTime"`
+	DeviceRunTime   string `json:"DeviceRunTime"`
+	DigChannel      int    `json:"DigChannel"`
+	ExtraChannel    int    `json:"ExtraChannel"`
+	HardWare        string `json:"HardWare"`
+	HardWareVersion string `json:"HardWareVersion"`
+	SerialNo        string `json:"SerialNo"`
+	SoftWareVersion string `json:"SoftWareVersion"`
+	TalkInChannel   int    `json:"TalkInChannel"`
+	TalkOutChannel  int    `json:"TalkOutChannel"`
+	VideoInChannel  int    `json:"VideoInChannel"`
+	VideoOutChannel int    `json:"VideoOutChannel"`
+}
+
+// Alarm is an event pushed by the device once SubscribeAlarms was called,
+// Event is such as VideoMotion, VideoBlind, VideoLoss or LocalAlarm and
+// Status is Start or Stop.
+type Alarm struct {
+	Channel   int
+	Event     string
+	Status    string
+	StartTime time.Time
+}
+
+type alarmInfo struct {
+	Channel   int    `json:"Channel"`
+	Event     string `json:"Event"`
+	StartTime string `json:"StartTime"`
+	Status    string `json:"Status"`
 }
 
 type MetaInfo struct {
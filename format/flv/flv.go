@@ -4,9 +4,11 @@ import (
 	"bufio"
 	"fmt"
 	"io"
+	"time"
 
 	"github.com/deepch/vdk/av"
 	"github.com/deepch/vdk/av/avutil"
+	"github.com/deepch/vdk/av/pktque"
 	"github.com/deepch/vdk/codec"
 	"github.com/deepch/vdk/codec/aacparser"
 	"github.com/deepch/vdk/codec/fake"
@@ -70,11 +72,16 @@ type Prober struct {
 	PushedCount                    int
 	Streams                        []av.CodecData
 	CachedPkts                     []av.Packet
+	ADTS                           bool // AAC without sequence header, its frames keep the ADTS header
 }
 
 func (self *Prober) CacheTag(_tag flvio.Tag, timestamp int32) {
+	n := len(self.CachedPkts)
 	pkt, _ := self.TagToPacket(_tag, timestamp)
-	self.CachedPkts = append(self.CachedPkts, pkt)
+	// TagToPacket queued the frames following the first of an ADTS tag
+	self.CachedPkts = append(self.CachedPkts, av.Packet{})
+	copy(self.CachedPkts[n+1:], self.CachedPkts[n:])
+	self.CachedPkts[n] = pkt
 }
 
 func (self *Prober) PushTag(tag flvio.Tag, timestamp int32) (err error) {
@@ -123,6 +130,16 @@ func (self *Prober) PushTag(tag flvio.Tag, timestamp int32) (err error) {
 				}
 
 			case flvio.AAC_RAW:
+				if !self.GotAudio {
+					if config, _, err := aacparser.SplitADTS(tag.Data); err == nil {
+						if stream, err := aacparser.NewCodecDataFromMPEG4AudioConfig(config); err == nil {
+							self.AudioStreamIdx = len(self.Streams)
+							self.Streams = append(self.Streams, stream)
+							self.GotAudio = true
+							self.ADTS = true
+						}
+					}
+				}
 				self.CacheTag(tag, timestamp)
 			}
 
@@ -178,6 +195,7 @@ func (self *Prober) TagToPacket(tag flvio.Tag, timestamp int32) (pkt av.Packet,
 			pkt.Data = tag.Data
 			pkt.CompositionTime = flvio.TsToTime(tag.CompositionTime)
 			pkt.IsKeyFrame = tag.FrameType == flvio.FRAME_KEY
+			pkt.Flags = h264parser.FrameFlags(tag.Data)
 		}
 
 	case flvio.TAG_AUDIO:
@@ -188,6 +206,9 @@ func (self *Prober) TagToPacket(tag flvio.Tag, timestamp int32) (pkt av.Packet,
 			case flvio.AAC_RAW:
 				ok = true
 				pkt.Data = tag.Data
+				if self.ADTS {
+					self.splitADTS(&pkt, timestamp)
+				}
 			}
 
 		case flvio.SOUND_SPEEX:
@@ -204,6 +225,25 @@ func (self *Prober) TagToPacket(tag flvio.Tag, timestamp int32) (pkt av.Packet,
 	return
 }
 
+// splitADTS strips the ADTS headers of pkt and queues the frames after
+// the first one.
+func (self *Prober) splitADTS(pkt *av.Packet, timestamp int32) {
+	_, frames, err := aacparser.SplitADTS(pkt.Data)
+	if err != nil {
+		return
+	}
+	pkt.Data = frames[0]
+	codec := self.Streams[self.AudioStreamIdx].(aacparser.CodecData)
+	dur, _ := codec.PacketDuration(nil)
+	for i, frame := range frames[1:] {
+		self.CachedPkts = append(self.CachedPkts, av.Packet{
+			Idx:  pkt.Idx,
+			Data: frame,
+			Time: flvio.TsToTime(timestamp) + dur*time.Duration(i+1),
+		})
+	}
+}
+
 func (self *Prober) Empty() bool {
 	return len(self.CachedPkts) == 0
 }
@@ -417,18 +457,98 @@ func (self *Muxer) WriteTrailer() (err error) {
 type Demuxer struct {
 	prober *Prober
 	bufr   *bufio.Reader
+	cr     *countReader
 	b      []byte
 	stage  int
+	tagpos int64 // offset of the last tag read
+
+	// AudioClock selects whether audio is timed by the tag timestamps or by
+	// its sample count, drift between the two goes to OnAudioDrift.
+	AudioClock    pktque.AudioClockMode
+	MaxAudioDrift time.Duration
+	OnAudioDrift  func(drift time.Duration)
+	aclock        *pktque.AudioClock
+
+	packets, bytes []int64 // read of each stream
+}
+
+type countReader struct {
+	r io.Reader
+	n int64
+}
+
+func (self *countReader) Read(b []byte) (n int, err error) {
+	n, err = self.r.Read(b)
+	self.n += int64(n)
+	return
 }
 
 func NewDemuxer(r io.Reader) *Demuxer {
+	cr := &countReader{r: r}
 	return &Demuxer{
-		bufr:   bufio.NewReaderSize(r, pio.RecommendBufioSize),
+		bufr:   bufio.NewReaderSize(cr, pio.RecommendBufioSize),
+		cr:     cr,
 		prober: &Prober{},
 		b:      make([]byte, 256),
 	}
 }
 
+func (self *Demuxer) offset() int64 {
+	return self.cr.n - int64(self.bufr.Buffered())
+}
+
+func (self *Demuxer) readTag() (tag flvio.Tag, timestamp int32, err error) {
+	self.tagpos = self.offset()
+	return flvio.ReadTag(self.bufr, self.b)
+}
+
+// Resync skips from the tag which failed to the next plausible tag header,
+// one with the type of a tag, a zero stream id and, when it fits in the
+// buffer, a matching previous tag size after its data. A file header which
+// failed is skipped the same way.
+func (self *Demuxer) Resync() (offset int64, length int64, err error) {
+	offset = self.tagpos
+	if self.stage == 0 {
+		offset = 0
+		self.stage++
+	}
+	for {
+		var b []byte
+		if b, err = self.bufr.Peek(flvio.TagHeaderLength); err != nil {
+			self.bufr.Discard(len(b))
+			length = self.offset() - offset
+			err = io.EOF
+			return
+		}
+		if self.plausibleTag(b) {
+			break
+		}
+		self.bufr.Discard(1)
+	}
+	length = self.offset() - offset
+	return
+}
+
+func (self *Demuxer) plausibleTag(b []byte) bool {
+	switch b[0] {
+	case flvio.TAG_AUDIO, flvio.TAG_VIDEO, flvio.TAG_SCRIPTDATA:
+	default:
+		return false
+	}
+	datalen := int(pio.U24BE(b[1:4]))
+	if datalen == 0 || pio.U24BE(b[8:11]) != 0 {
+		return false
+	}
+	n := flvio.TagHeaderLength + datalen + 4
+	if n > self.bufr.Size() {
+		return true
+	}
+	if b, err := self.bufr.Peek(n); err == nil {
+		return int(pio.U32BE(b[n-4:])) == flvio.TagHeaderLength+datalen
+	}
+	return true
+}
+
 func (self *Demuxer) prepare() (err error) {
 	for self.stage < 2 {
 		switch self.stage {
@@ -456,7 +576,7 @@ func (self *Demuxer) prepare() (err error) {
 			for !self.prober.Probed() {
 				var tag flvio.Tag
 				var timestamp int32
-				if tag, timestamp, err = flvio.ReadTag(self.bufr, self.b); err != nil {
+				if tag, timestamp, err = self.readTag(); err != nil {
 					return
 				}
 				if err = self.prober.PushTag(tag, timestamp); err != nil {
@@ -478,24 +598,41 @@ func (self *Demuxer) Streams() (streams []av.CodecData, err error) {
 }
 
 func (self *Demuxer) ReadPacket() (pkt av.Packet, err error) {
+	if pkt, err = self.readPacket(); err != nil {
+		return
+	}
+	if n := len(self.prober.Streams); len(self.packets) < n {
+		self.packets = append(self.packets, make([]int64, n-len(self.packets))...)
+		self.bytes = append(self.bytes, make([]int64, n-len(self.bytes))...)
+	}
+	if int(pkt.Idx) < len(self.packets) {
+		self.packets[pkt.Idx]++
+		self.bytes[pkt.Idx] += int64(len(pkt.Data))
+	}
+	return
+}
+
+func (self *Demuxer) readPacket() (pkt av.Packet, err error) {
 	if err = self.prepare(); err != nil {
 		return
 	}
 
 	if !self.prober.Empty() {
 		pkt = self.prober.PopPacket()
+		self.timeAudio(&pkt)
 		return
 	}
 
 	for {
 		var tag flvio.Tag
 		var timestamp int32
-		if tag, timestamp, err = flvio.ReadTag(self.bufr, self.b); err != nil {
+		if tag, timestamp, err = self.readTag(); err != nil {
 			return
 		}
 
 		var ok bool
 		if pkt, ok = self.prober.TagToPacket(tag, timestamp); ok {
+			self.timeAudio(&pkt)
 			return
 		}
 	}
@@ -503,6 +640,97 @@ func (self *Demuxer) ReadPacket() (pkt av.Packet, err error) {
 	return
 }
 
+// timeAudio sets audio durations from the frame sample count.
+func (self *Demuxer) timeAudio(pkt *av.Packet) {
+	if int(pkt.Idx) >= len(self.prober.Streams) {
+		return
+	}
+	codec, ok := self.prober.Streams[pkt.Idx].(av.AudioCodecData)
+	if !ok {
+		return
+	}
+	dur, err := codec.PacketDuration(pkt.Data)
+	if err != nil {
+		return
+	}
+	pkt.Time, _ = self.audioClock().Next(pkt.Time, dur)
+	pkt.Duration = dur
+}
+
+func (self *Demuxer) audioClock() *pktque.AudioClock {
+	if self.aclock == nil {
+		self.aclock = &pktque.AudioClock{
+			Mode:     self.AudioClock,
+			MaxDrift: self.MaxAudioDrift,
+			OnDrift:  self.OnAudioDrift,
+		}
+	}
+	return self.aclock
+}
+
+// State is the read position of a Demuxer, to resume reading the same file
+// with another demuxer, such as after a restart. FLV has no index, the
+// offset of the next tag is the checkpoint and the tags before it are not
+// read again.
+type State struct {
+	Offset  int64   // of the next tag
+	Packets []int64 // read of each stream
+	Bytes   []int64 // of the packets read of each stream
+	// AudioTime is the time of the next audio packet by the sample count,
+	// when AudioTimed, so that AudioClock goes on from it.
+	AudioTime  time.Duration
+	AudioTimed bool
+}
+
+// SaveState returns the position, after the packets cached while probing
+// or split from the last tag have all been read.
+func (self *Demuxer) SaveState() (state State, err error) {
+	if err = self.prepare(); err != nil {
+		return
+	}
+	if !self.prober.Empty() {
+		err = fmt.Errorf("flv: packets of read tags pending")
+		return
+	}
+	state.Offset = self.offset()
+	state.Packets = append([]int64(nil), self.packets...)
+	state.Bytes = append([]int64(nil), self.bytes...)
+	if self.aclock != nil {
+		state.AudioTime, state.AudioTimed = self.aclock.Expected()
+	}
+	return
+}
+
+// RestoreState needs an io.Seeker source.
+func (self *Demuxer) RestoreState(state State) (err error) {
+	if err = self.prepare(); err != nil {
+		return
+	}
+	if len(state.Packets) > len(self.prober.Streams) || len(state.Bytes) != len(state.Packets) {
+		err = fmt.Errorf("flv: state of %d streams for %d", len(state.Packets), len(self.prober.Streams))
+		return
+	}
+	seeker, ok := self.cr.r.(io.Seeker)
+	if !ok {
+		err = fmt.Errorf("flv: RestoreState needs an io.Seeker source")
+		return
+	}
+	if _, err = seeker.Seek(state.Offset, io.SeekStart); err != nil {
+		return
+	}
+	self.cr.n = state.Offset
+	self.bufr.Reset(self.cr)
+	self.prober.CachedPkts = nil
+	self.tagpos = state.Offset
+	self.packets = append([]int64(nil), state.Packets...)
+	self.bytes = append([]int64(nil), state.Bytes...)
+	self.aclock = nil
+	if state.AudioTimed {
+		self.audioClock().Resume(state.AudioTime)
+	}
+	return
+}
+
 func Handler(h *avutil.RegisterHandler) {
 	h.Probe = func(b []byte) bool {
 		return b[0] == 'F' && b[1] == 'L' && b[2] == 'V'
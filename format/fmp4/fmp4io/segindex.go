@@ -129,16 +129,16 @@ func (s *SegmentIndex) Unmarshal(b []byte, offset int) (n int, err error) {
 		if refSize&(1<<31) != 0 {
 			ref.ReferencesBox = true
 		}
-		ref.ReferencedSize = refSize &^ ((1 << 31) - 1)
+		ref.ReferencedSize = refSize &^ (1 << 31)
 		ref.SubsegmentDuration = pio.U32BE(b[n:])
 		n += 4
-		sapDelta := pio.U32BE(b[:n])
+		sapDelta := pio.U32BE(b[n:])
 		n += 4
 		if sapDelta&(1<<31) != 0 {
 			ref.StartsWithSAP = true
 		}
 		ref.SAPType = uint8(0x7 & (sapDelta >> 28))
-		ref.SAPDeltaTime = sapDelta &^ ((1 << 28) - 1)
+		ref.SAPDeltaTime = sapDelta & ((1 << 28) - 1)
 	}
 	return
 }
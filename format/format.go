@@ -4,7 +4,9 @@ import (
 	"github.com/deepch/vdk/av/avutil"
 	"github.com/deepch/vdk/format/aac"
 	"github.com/deepch/vdk/format/flv"
+	"github.com/deepch/vdk/format/mem"
 	"github.com/deepch/vdk/format/mp4"
+	"github.com/deepch/vdk/format/null"
 	"github.com/deepch/vdk/format/rtmp"
 	"github.com/deepch/vdk/format/rtsp"
 	"github.com/deepch/vdk/format/ts"
@@ -17,4 +19,6 @@ func RegisterAll() {
 	avutil.DefaultHandlers.Add(rtsp.Handler)
 	avutil.DefaultHandlers.Add(flv.Handler)
 	avutil.DefaultHandlers.Add(aac.Handler)
+	avutil.DefaultHandlers.Add(null.Handler)
+	avutil.DefaultHandlers.Add(mem.Handler)
 }
@@ -1 +1,170 @@
+// Package hls selects the streams of an HLS rendition and writes the master
+// playlist grouping the renditions together.
 package hls
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/deepch/vdk/av"
+)
+
+// Selector demuxes a subset of the streams of Demuxer, renumbering them from
+// zero. Timestamps are passed through untouched so a rendition cut from a
+// muxed source stays aligned with the other variants.
+type Selector struct {
+	Demuxer av.Demuxer
+	Keep    []int
+	remap   map[int8]int8
+}
+
+// NewSelector keeps the streams of src listed in keep, in that order.
+func NewSelector(src av.Demuxer, keep ...int) *Selector {
+	return &Selector{Demuxer: src, Keep: keep}
+}
+
+// AudioOnly keeps every audio stream of src.
+func AudioOnly(src av.Demuxer) (self *Selector, err error) {
+	var streams []av.CodecData
+	if streams, err = src.Streams(); err != nil {
+		return
+	}
+	self = &Selector{Demuxer: src}
+	for i, stream := range streams {
+		if stream.Type().IsAudio() {
+			self.Keep = append(self.Keep, i)
+		}
+	}
+	if len(self.Keep) == 0 {
+		err = fmt.Errorf("hls: no audio stream")
+		return
+	}
+	return
+}
+
+func (self *Selector) Streams() (streams []av.CodecData, err error) {
+	var all []av.CodecData
+	if all, err = self.Demuxer.Streams(); err != nil {
+		return
+	}
+	self.remap = map[int8]int8{}
+	for i, idx := range self.Keep {
+		if idx < 0 || idx >= len(all) {
+			err = fmt.Errorf("hls: stream %d out of range", idx)
+			return
+		}
+		self.remap[int8(idx)] = int8(i)
+		streams = append(streams, all[idx])
+	}
+	return
+}
+
+func (self *Selector) ReadPacket() (pkt av.Packet, err error) {
+	if self.remap == nil {
+		if _, err = self.Streams(); err != nil {
+			return
+		}
+	}
+	for {
+		if pkt, err = self.Demuxer.ReadPacket(); err != nil {
+			return
+		}
+		if idx, ok := self.remap[pkt.Idx]; ok {
+			pkt.Idx = idx
+			return
+		}
+	}
+}
+
+// Codecs returns the CODECS attribute value for streams, skipping codecs
+// without an RFC 6381 tag.
+func Codecs(streams []av.CodecData) string {
+	var tags []string
+	for _, stream := range streams {
+		if tagger, ok := stream.(interface{ Tag() string }); ok {
+			tags = append(tags, tagger.Tag())
+		}
+	}
+	return strings.Join(tags, ",")
+}
+
+// Rendition is an EXT-X-MEDIA entry. Type defaults to AUDIO.
+type Rendition struct {
+	Type       string
+	GroupID    string
+	Name       string
+	Language   string
+	URI        string
+	Default    bool
+	AutoSelect bool
+}
+
+// Variant is an EXT-X-STREAM-INF entry. Audio names the rendition group
+// played along with it; an audio-only fallback variant leaves Resolution
+// empty and lists only the audio codecs. IFrameURI, if set, adds an
+// EXT-X-I-FRAME-STREAM-INF pointing at the trick-play playlist of the variant.
+type Variant struct {
+	URI        string
+	Bandwidth  int
+	Codecs     string
+	Resolution string
+	Audio      string
+	IFrameURI  string
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "YES"
+	}
+	return "NO"
+}
+
+// WriteMasterPlaylist writes a master playlist listing renditions then variants.
+func WriteMasterPlaylist(w io.Writer, renditions []Rendition, variants []Variant) (err error) {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "#EXTM3U\n#EXT-X-VERSION:7\n#EXT-X-INDEPENDENT-SEGMENTS\n")
+	for _, r := range renditions {
+		typ := r.Type
+		if typ == "" {
+			typ = "AUDIO"
+		}
+		fmt.Fprintf(bw, "#EXT-X-MEDIA:TYPE=%s,GROUP-ID=\"%s\",NAME=\"%s\"", typ, r.GroupID, r.Name)
+		if r.Language != "" {
+			fmt.Fprintf(bw, ",LANGUAGE=\"%s\"", r.Language)
+		}
+		fmt.Fprintf(bw, ",DEFAULT=%s,AUTOSELECT=%s", yesNo(r.Default), yesNo(r.AutoSelect || r.Default))
+		if r.URI != "" {
+			fmt.Fprintf(bw, ",URI=\"%s\"", r.URI)
+		}
+		fmt.Fprintf(bw, "\n")
+	}
+	for _, v := range variants {
+		fmt.Fprintf(bw, "#EXT-X-STREAM-INF:BANDWIDTH=%d", v.Bandwidth)
+		if v.Codecs != "" {
+			fmt.Fprintf(bw, ",CODECS=\"%s\"", v.Codecs)
+		}
+		if v.Resolution != "" {
+			fmt.Fprintf(bw, ",RESOLUTION=%s", v.Resolution)
+		}
+		if v.Audio != "" {
+			fmt.Fprintf(bw, ",AUDIO=\"%s\"", v.Audio)
+		}
+		fmt.Fprintf(bw, "\n%s\n", v.URI)
+	}
+	for _, v := range variants {
+		if v.IFrameURI == "" {
+			continue
+		}
+		fmt.Fprintf(bw, "#EXT-X-I-FRAME-STREAM-INF:BANDWIDTH=%d", v.Bandwidth)
+		if v.Codecs != "" {
+			fmt.Fprintf(bw, ",CODECS=\"%s\"", v.Codecs)
+		}
+		if v.Resolution != "" {
+			fmt.Fprintf(bw, ",RESOLUTION=%s", v.Resolution)
+		}
+		fmt.Fprintf(bw, ",URI=\"%s\"\n", v.IFrameURI)
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,283 @@
+// Package mem wires a muxer to a demuxer in memory, with an optional
+// latency and packet loss, for integration tests of filters and pipelines.
+// Through avutil, the muxer created for mem://name feeds the demuxer opened
+// for the same name, in either order.
+package mem
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/deepch/vdk/av"
+	"github.com/deepch/vdk/av/avutil"
+)
+
+const DefaultBuffer = 256
+
+// Options can be given in the query of the URI that makes the pipe, as in
+// mem://test?latency=50ms&loss=0.1&buffer=16&seed=1.
+type Options struct {
+	Latency time.Duration // added to each packet
+	Loss    float64       // probability a packet is dropped, from 0 to 1
+	Buffer  int           // packets written ahead of the reader, DefaultBuffer if zero
+	Seed    int64         // of the packet loss
+}
+
+type entry struct {
+	pkt av.Packet
+	at  time.Time
+}
+
+type pipe struct {
+	name    string
+	opts    Options
+	rand    *rand.Rand
+	streams []av.CodecData
+	ready   chan struct{}
+	pkts    chan entry
+	trailer chan struct{}
+	done    chan struct{}
+
+	headerOnce  sync.Once
+	trailerOnce sync.Once
+	closeOnce   sync.Once
+}
+
+// Pipe returns the two ends of a new pipe.
+func Pipe(opts Options) (*Muxer, *Demuxer) {
+	p := newPipe(opts)
+	return &Muxer{p}, &Demuxer{p}
+}
+
+func newPipe(opts Options) *pipe {
+	if opts.Buffer <= 0 {
+		opts.Buffer = DefaultBuffer
+	}
+	return &pipe{
+		opts:    opts,
+		rand:    rand.New(rand.NewSource(opts.Seed)),
+		ready:   make(chan struct{}),
+		pkts:    make(chan entry, opts.Buffer),
+		trailer: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+}
+
+// Muxer is the writing end, closing it ends the stream of the Demuxer.
+type Muxer struct {
+	p *pipe
+}
+
+func (self *Muxer) WriteHeader(streams []av.CodecData) (err error) {
+	self.p.headerOnce.Do(func() {
+		self.p.streams = streams
+		close(self.p.ready)
+	})
+	return
+}
+
+func (self *Muxer) WritePacket(pkt av.Packet) (err error) {
+	p := self.p
+	select {
+	case <-p.trailer:
+		err = io.ErrClosedPipe
+		return
+	default:
+	}
+	if p.opts.Loss > 0 && p.rand.Float64() < p.opts.Loss {
+		return
+	}
+	pkt.Data = append([]byte(nil), pkt.Data...)
+	select {
+	case p.pkts <- entry{pkt: pkt, at: time.Now().Add(p.opts.Latency)}:
+	case <-p.trailer:
+		err = io.ErrClosedPipe
+	case <-p.done:
+		err = io.ErrClosedPipe
+	}
+	return
+}
+
+// WriteTrailer ends the stream, the packets written before it are still
+// read, the writes after it fail with io.ErrClosedPipe.
+func (self *Muxer) WriteTrailer() (err error) {
+	self.p.trailerOnce.Do(func() {
+		close(self.p.trailer)
+	})
+	return
+}
+
+func (self *Muxer) Close() error {
+	self.p.detach()
+	return self.WriteTrailer()
+}
+
+// Demuxer is the reading end, closing it fails the writes of the Muxer.
+type Demuxer struct {
+	p *pipe
+}
+
+// Streams waits for the header of the Muxer, io.EOF if it ended without
+// one.
+func (self *Demuxer) Streams() (streams []av.CodecData, err error) {
+	select {
+	case <-self.p.ready:
+		streams = self.p.streams
+	case <-self.p.trailer:
+		select {
+		case <-self.p.ready:
+			streams = self.p.streams
+		default:
+			err = io.EOF
+		}
+	case <-self.p.done:
+		err = io.ErrClosedPipe
+	}
+	return
+}
+
+func (self *Demuxer) ReadPacket() (pkt av.Packet, err error) {
+	p := self.p
+	// packets still buffered are not read once closed
+	select {
+	case <-p.done:
+		err = io.ErrClosedPipe
+		return
+	default:
+	}
+	var e entry
+	select {
+	case e = <-p.pkts:
+	case <-p.trailer:
+		select {
+		case e = <-p.pkts:
+		default:
+			err = io.EOF
+			return
+		}
+	case <-p.done:
+		err = io.ErrClosedPipe
+		return
+	}
+	if d := time.Until(e.at); d > 0 {
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-p.done:
+			err = io.ErrClosedPipe
+			return
+		}
+	}
+	pkt = e.pkt
+	return
+}
+
+func (self *Demuxer) Close() error {
+	self.p.detach()
+	self.p.closeOnce.Do(func() {
+		close(self.p.done)
+	})
+	return nil
+}
+
+func ParseOptions(query url.Values) (opts Options, err error) {
+	if s := query.Get("latency"); s != "" {
+		if opts.Latency, err = time.ParseDuration(s); err != nil {
+			err = fmt.Errorf("mem: latency %q invalid", s)
+			return
+		}
+	}
+	if s := query.Get("loss"); s != "" {
+		if opts.Loss, err = strconv.ParseFloat(s, 64); err != nil || opts.Loss < 0 || opts.Loss > 1 {
+			err = fmt.Errorf("mem: loss %q invalid", s)
+			return
+		}
+	}
+	if s := query.Get("buffer"); s != "" {
+		if opts.Buffer, err = strconv.Atoi(s); err != nil {
+			err = fmt.Errorf("mem: buffer %q invalid", s)
+			return
+		}
+	}
+	if s := query.Get("seed"); s != "" {
+		if opts.Seed, err = strconv.ParseInt(s, 10, 64); err != nil {
+			err = fmt.Errorf("mem: seed %q invalid", s)
+			return
+		}
+	}
+	return
+}
+
+// pending holds the pipes with one end taken, until the other is.
+var pending = struct {
+	sync.Mutex
+	pipes map[string]*pipe
+}{pipes: map[string]*pipe{}}
+
+// attach returns the pipe of uri, made with the options of the first end
+// taken.
+func attach(uri string) (p *pipe, err error) {
+	var u *url.URL
+	if u, err = url.Parse(uri); err != nil {
+		return
+	}
+	name := u.Host + u.Path
+	pending.Lock()
+	defer pending.Unlock()
+	if p = pending.pipes[name]; p != nil {
+		delete(pending.pipes, name)
+		return
+	}
+	var opts Options
+	if opts, err = ParseOptions(u.Query()); err != nil {
+		return
+	}
+	p = newPipe(opts)
+	p.name = name
+	pending.pipes[name] = p
+	return
+}
+
+// detach drops the pipe closed before its other end was taken.
+func (self *pipe) detach() {
+	pending.Lock()
+	if pending.pipes[self.name] == self {
+		delete(pending.pipes, self.name)
+	}
+	pending.Unlock()
+}
+
+func Handler(h *avutil.RegisterHandler) {
+	h.UrlMuxer = func(uri string) (ok bool, muxer av.MuxCloser, err error) {
+		if !strings.HasPrefix(uri, "mem://") {
+			return
+		}
+		ok = true
+		var p *pipe
+		if p, err = attach(uri); err != nil {
+			return
+		}
+		muxer = &Muxer{p}
+		return
+	}
+
+	h.UrlDemuxer = func(uri string) (ok bool, demuxer av.DemuxCloser, err error) {
+		if !strings.HasPrefix(uri, "mem://") {
+			return
+		}
+		ok = true
+		var p *pipe
+		if p, err = attach(uri); err != nil {
+			return
+		}
+		demuxer = &Demuxer{p}
+		return
+	}
+}
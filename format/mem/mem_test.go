@@ -0,0 +1,228 @@
+package mem
+
+import (
+	"io"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/deepch/vdk/av"
+	"github.com/deepch/vdk/av/avutil"
+	"github.com/deepch/vdk/codec"
+)
+
+var testStreams = []av.CodecData{codec.NewPCMMulawCodecData()}
+
+func TestReadAfterTrailer(t *testing.T) {
+	muxer, demuxer := Pipe(Options{})
+	if err := muxer.WriteHeader(testStreams); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := muxer.WritePacket(av.Packet{Data: []byte{byte(i)}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	muxer.WriteTrailer()
+	if err := muxer.WritePacket(av.Packet{}); err != io.ErrClosedPipe {
+		t.Errorf("write after the trailer: %v", err)
+	}
+	// the packets written before the trailer are still read
+	for i := 0; i < 3; i++ {
+		pkt, err := demuxer.ReadPacket()
+		if err != nil || pkt.Data[0] != byte(i) {
+			t.Fatalf("packet %d: %v %v", i, pkt.Data, err)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := demuxer.ReadPacket(); err != io.EOF {
+			t.Errorf("read %d after the end: %v", i, err)
+		}
+	}
+	if streams, err := demuxer.Streams(); err != nil || len(streams) != 1 {
+		t.Errorf("streams after the end: %v %v", streams, err)
+	}
+
+	// a trailer with no header
+	muxer, demuxer = Pipe(Options{})
+	muxer.WriteTrailer()
+	if _, err := demuxer.Streams(); err != io.EOF {
+		t.Errorf("streams of no header: %v", err)
+	}
+}
+
+func TestClose(t *testing.T) {
+	// closing the demuxer fails the writes, also one blocked on a full buffer
+	muxer, demuxer := Pipe(Options{Buffer: 1})
+	muxer.WriteHeader(testStreams)
+	muxer.WritePacket(av.Packet{})
+	errc := make(chan error)
+	go func() {
+		errc <- muxer.WritePacket(av.Packet{})
+	}()
+	time.Sleep(10 * time.Millisecond)
+	demuxer.Close()
+	select {
+	case err := <-errc:
+		if err != io.ErrClosedPipe {
+			t.Errorf("blocked write: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("blocked write not released")
+	}
+	if _, err := demuxer.ReadPacket(); err != io.ErrClosedPipe {
+		t.Errorf("read after close: %v", err)
+	}
+	demuxer.Close()
+
+	// closing the muxer ends the stream after the packets written
+	muxer, demuxer = Pipe(Options{})
+	muxer.WriteHeader(testStreams)
+	muxer.WritePacket(av.Packet{})
+	muxer.Close()
+	muxer.Close()
+	if _, err := demuxer.ReadPacket(); err != nil {
+		t.Errorf("read before the end: %v", err)
+	}
+	if _, err := demuxer.ReadPacket(); err != io.EOF {
+		t.Errorf("read at the end: %v", err)
+	}
+
+	// a blocked read is released by either end
+	for _, end := range []string{"muxer", "demuxer"} {
+		muxer, demuxer = Pipe(Options{})
+		errc := make(chan error)
+		go func() {
+			_, err := demuxer.ReadPacket()
+			errc <- err
+		}()
+		time.Sleep(10 * time.Millisecond)
+		if end == "muxer" {
+			muxer.Close()
+		} else {
+			demuxer.Close()
+		}
+		select {
+		case err := <-errc:
+			if err == nil {
+				t.Errorf("%s close: read a packet", end)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%s close: blocked read not released", end)
+		}
+	}
+}
+
+func TestParseOptions(t *testing.T) {
+	for _, test := range []struct {
+		query string
+		want  Options
+		err   bool
+	}{
+		{"", Options{}, false},
+		{"latency=50ms&loss=0.1&buffer=16&seed=1", Options{Latency: 50 * time.Millisecond, Loss: 0.1, Buffer: 16, Seed: 1}, false},
+		{"loss=1", Options{Loss: 1}, false},
+		{"latency=50", Options{}, true},
+		{"loss=1.5", Options{}, true},
+		{"loss=-0.1", Options{}, true},
+		{"loss=x", Options{}, true},
+		{"buffer=many", Options{}, true},
+		{"seed=0.5", Options{}, true},
+	} {
+		query, _ := url.ParseQuery(test.query)
+		opts, err := ParseOptions(query)
+		if (err != nil) != test.err || !test.err && opts != test.want {
+			t.Errorf("%q: %+v %v", test.query, opts, err)
+		}
+	}
+}
+
+func TestLatencyAndLoss(t *testing.T) {
+	muxer, demuxer := Pipe(Options{Latency: 30 * time.Millisecond})
+	muxer.WriteHeader(testStreams)
+	start := time.Now()
+	muxer.WritePacket(av.Packet{})
+	if _, err := demuxer.ReadPacket(); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d < 30*time.Millisecond {
+		t.Errorf("packet read after %v", d)
+	}
+
+	// the same seed drops the same packets
+	var kept [2][]byte
+	for run := range kept {
+		muxer, demuxer := Pipe(Options{Loss: 0.5, Seed: 7})
+		muxer.WriteHeader(testStreams)
+		for i := 0; i < 100; i++ {
+			muxer.WritePacket(av.Packet{Data: []byte{byte(i)}})
+		}
+		muxer.WriteTrailer()
+		for {
+			pkt, err := demuxer.ReadPacket()
+			if err != nil {
+				break
+			}
+			kept[run] = append(kept[run], pkt.Data[0])
+		}
+	}
+	if n := len(kept[0]); n < 25 || n > 75 || string(kept[0]) != string(kept[1]) {
+		t.Errorf("kept %v then %v", kept[0], kept[1])
+	}
+}
+
+func TestAttach(t *testing.T) {
+	handlers := &avutil.Handlers{}
+	handlers.Add(Handler)
+	for _, order := range []string{"muxer first", "demuxer first"} {
+		uri := "mem://attach-" + order[:5]
+		var muxer av.MuxCloser
+		var demuxer av.DemuxCloser
+		var err error
+		if order == "muxer first" {
+			if muxer, err = handlers.Create(uri + "?buffer=2"); err != nil {
+				t.Fatal(err)
+			}
+			// the options are those of the first end
+			if demuxer, err = handlers.Open(uri + "?buffer=bad"); err != nil {
+				t.Fatal(err)
+			}
+		} else {
+			if demuxer, err = handlers.Open(uri + "?buffer=2"); err != nil {
+				t.Fatal(err)
+			}
+			if muxer, err = handlers.Create(uri); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if cap(muxer.(*Muxer).p.pkts) != 2 || muxer.(*Muxer).p != demuxer.(*Demuxer).p {
+			t.Fatalf("%s: ends of different pipes", order)
+		}
+		muxer.WriteHeader(testStreams)
+		muxer.WritePacket(av.Packet{Data: []byte{1}})
+		muxer.Close()
+		if pkt, err := demuxer.ReadPacket(); err != nil || pkt.Data[0] != 1 {
+			t.Errorf("%s: read %v %v", order, pkt.Data, err)
+		}
+		demuxer.Close()
+	}
+
+	// an end closed before the other is taken leaves no pipe behind
+	muxer, err := handlers.Create("mem://detach")
+	if err != nil {
+		t.Fatal(err)
+	}
+	muxer.Close()
+	demuxer, err := handlers.Open("mem://detach")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if demuxer.(*Demuxer).p == muxer.(*Muxer).p {
+		t.Error("attached to a closed pipe")
+	}
+	demuxer.Close()
+
+	if _, err = handlers.Open("mem://bad?loss=2"); err == nil {
+		t.Error("bad options accepted")
+	}
+}
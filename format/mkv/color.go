@@ -0,0 +1,195 @@
+package mkv
+
+import (
+	"encoding/binary"
+	"math"
+
+	"github.com/deepch/vdk/av"
+	"github.com/deepch/vdk/codec/h264parser"
+	"github.com/deepch/vdk/format/mkv/mkvio"
+)
+
+// Matroska Colour uses the ISO/IEC 23091-2 code points of av.ColorInfo,
+// chromaticities are CIE 1931 coordinates and luminances cd/m2.
+const (
+	chromaticityUnit = 50000 // of av.MasteringDisplay, 0.00002
+	luminanceUnit    = 10000 // 0.0001 cd/m2
+	rangeFull        = 2
+)
+
+// FlagInterlaced and FieldOrder values of the Video element.
+const (
+	flagInterlaced      = 1
+	flagProgressive     = 2
+	fieldOrderTop       = 1
+	fieldOrderBottom    = 6
+	fieldOrderBottomSwp = 9
+	fieldOrderTopSwp    = 14
+)
+
+func floatContent(el mkvio.Element) float64 {
+	switch len(el.Content) {
+	case 4:
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(el.Content)))
+	case 8:
+		return math.Float64frombits(binary.BigEndian.Uint64(el.Content))
+	}
+	return 0
+}
+
+// parseColour keeps a Colour element of the video track.
+func (self *Demuxer) parseColour(el mkvio.Element) {
+	info := &self.color
+	md := func() *av.MasteringDisplay {
+		if info.MasteringDisplay == nil {
+			info.MasteringDisplay = &av.MasteringDisplay{}
+		}
+		return info.MasteringDisplay
+	}
+	cl := func() *av.ContentLightLevel {
+		if info.ContentLight == nil {
+			info.ContentLight = &av.ContentLightLevel{}
+		}
+		return info.ContentLight
+	}
+	chromaticity := func() uint16 {
+		return uint16(math.Round(floatContent(el) * chromaticityUnit))
+	}
+	switch el.ID {
+	case mkvio.ElementPrimaries.ID:
+		info.Primaries = uint8(uintContent(el))
+	case mkvio.ElementTransferCharacteristics.ID:
+		info.Transfer = uint8(uintContent(el))
+	case mkvio.ElementMatrixCoefficients.ID:
+		info.Matrix = uint8(uintContent(el))
+	case mkvio.ElementRange.ID:
+		info.FullRange = uintContent(el) == rangeFull
+	case mkvio.ElementMaxCLL.ID:
+		cl().MaxCLL = uint16(uintContent(el))
+	case mkvio.ElementMaxFALL.ID:
+		cl().MaxFALL = uint16(uintContent(el))
+	case mkvio.ElementPrimaryGChromaticityX.ID:
+		md().DisplayPrimaries[0][0] = chromaticity()
+	case mkvio.ElementPrimaryGChromaticityY.ID:
+		md().DisplayPrimaries[0][1] = chromaticity()
+	case mkvio.ElementPrimaryBChromaticityX.ID:
+		md().DisplayPrimaries[1][0] = chromaticity()
+	case mkvio.ElementPrimaryBChromaticityY.ID:
+		md().DisplayPrimaries[1][1] = chromaticity()
+	case mkvio.ElementPrimaryRChromaticityX.ID:
+		md().DisplayPrimaries[2][0] = chromaticity()
+	case mkvio.ElementPrimaryRChromaticityY.ID:
+		md().DisplayPrimaries[2][1] = chromaticity()
+	case mkvio.ElementWhitePointChromaticityX.ID:
+		md().WhitePoint[0] = chromaticity()
+	case mkvio.ElementWhitePointChromaticityY.ID:
+		md().WhitePoint[1] = chromaticity()
+	case mkvio.ElementLuminanceMax.ID:
+		md().MaxLuminance = uint32(math.Round(floatContent(el) * luminanceUnit))
+	case mkvio.ElementLuminanceMin.ID:
+		md().MinLuminance = uint32(math.Round(floatContent(el) * luminanceUnit))
+	case mkvio.ElementFlagInterlaced.ID:
+		if uintContent(el) == flagProgressive {
+			self.fields = av.FieldOrderProgressive
+		}
+	case mkvio.ElementFieldOrder.ID:
+		switch uintContent(el) {
+		case fieldOrderTop, fieldOrderTopSwp:
+			self.fields = av.FieldOrderTopFirst
+		case fieldOrderBottom, fieldOrderBottomSwp:
+			self.fields = av.FieldOrderBottomFirst
+		}
+	}
+}
+
+// withContainerColor lets the Colour element and the field order of the
+// Video element fill in what the parameter sets do not carry.
+func withContainerColor(codec av.CodecData, info av.ColorInfo, fields av.FieldOrder) av.CodecData {
+	switch codec := codec.(type) {
+	case h264parser.CodecData:
+		if codec.Fields == av.FieldOrderUnknown {
+			codec.Fields = fields
+		}
+		if codec.SPSInfo.ColourPrimaries == 0 && info.HasColorDescription() {
+			codec.SPSInfo.ColourPrimaries = uint(info.Primaries)
+			codec.SPSInfo.TransferCharacteristics = uint(info.Transfer)
+			codec.SPSInfo.MatrixCoefficients = uint(info.Matrix)
+			if info.FullRange {
+				codec.SPSInfo.VideoFullRange = 1
+			}
+		}
+		if codec.MasteringDisplay == nil {
+			codec.MasteringDisplay = info.MasteringDisplay
+		}
+		if codec.ContentLight == nil {
+			codec.ContentLight = info.ContentLight
+		}
+		return codec
+	}
+	return codec
+}
+
+// MarshalColour returns the Colour element of info, to be written in the
+// Video element of a track, nil if info signals nothing.
+func MarshalColour(info av.ColorInfo) []byte {
+	var children [][]byte
+	if info.HasColorDescription() {
+		colourRange := uint64(1)
+		if info.FullRange {
+			colourRange = rangeFull
+		}
+		children = append(children,
+			mkvio.MarshalUint(mkvio.ElementMatrixCoefficients, uint64(info.Matrix)),
+			mkvio.MarshalUint(mkvio.ElementRange, colourRange),
+			mkvio.MarshalUint(mkvio.ElementTransferCharacteristics, uint64(info.Transfer)),
+			mkvio.MarshalUint(mkvio.ElementPrimaries, uint64(info.Primaries)),
+		)
+	}
+	if cl := info.ContentLight; cl != nil {
+		children = append(children,
+			mkvio.MarshalUint(mkvio.ElementMaxCLL, uint64(cl.MaxCLL)),
+			mkvio.MarshalUint(mkvio.ElementMaxFALL, uint64(cl.MaxFALL)),
+		)
+	}
+	if md := info.MasteringDisplay; md != nil {
+		chromaticity := func(reg mkvio.ElementRegister, v uint16) []byte {
+			return mkvio.MarshalFloat(reg, float64(v)/chromaticityUnit)
+		}
+		children = append(children, mkvio.Marshal(mkvio.ElementMasteringMetadata,
+			chromaticity(mkvio.ElementPrimaryRChromaticityX, md.DisplayPrimaries[2][0]),
+			chromaticity(mkvio.ElementPrimaryRChromaticityY, md.DisplayPrimaries[2][1]),
+			chromaticity(mkvio.ElementPrimaryGChromaticityX, md.DisplayPrimaries[0][0]),
+			chromaticity(mkvio.ElementPrimaryGChromaticityY, md.DisplayPrimaries[0][1]),
+			chromaticity(mkvio.ElementPrimaryBChromaticityX, md.DisplayPrimaries[1][0]),
+			chromaticity(mkvio.ElementPrimaryBChromaticityY, md.DisplayPrimaries[1][1]),
+			chromaticity(mkvio.ElementWhitePointChromaticityX, md.WhitePoint[0]),
+			chromaticity(mkvio.ElementWhitePointChromaticityY, md.WhitePoint[1]),
+			mkvio.MarshalFloat(mkvio.ElementLuminanceMax, float64(md.MaxLuminance)/luminanceUnit),
+			mkvio.MarshalFloat(mkvio.ElementLuminanceMin, float64(md.MinLuminance)/luminanceUnit),
+		))
+	}
+	if len(children) == 0 {
+		return nil
+	}
+	return mkvio.Marshal(mkvio.ElementColour, children...)
+}
+
+// MarshalFieldOrder returns the FlagInterlaced and FieldOrder elements of
+// order, to be written in the Video element of a track, nil if unknown.
+func MarshalFieldOrder(order av.FieldOrder) [][]byte {
+	switch order {
+	case av.FieldOrderProgressive:
+		return [][]byte{mkvio.MarshalUint(mkvio.ElementFlagInterlaced, flagProgressive)}
+	case av.FieldOrderTopFirst:
+		return [][]byte{
+			mkvio.MarshalUint(mkvio.ElementFlagInterlaced, flagInterlaced),
+			mkvio.MarshalUint(mkvio.ElementFieldOrder, fieldOrderTop),
+		}
+	case av.FieldOrderBottomFirst:
+		return [][]byte{
+			mkvio.MarshalUint(mkvio.ElementFlagInterlaced, flagInterlaced),
+			mkvio.MarshalUint(mkvio.ElementFieldOrder, fieldOrderBottom),
+		}
+	}
+	return nil
+}
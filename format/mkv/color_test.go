@@ -0,0 +1,54 @@
+package mkv
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/deepch/vdk/av"
+	"github.com/deepch/vdk/format/mkv/mkvio"
+)
+
+func TestColourRoundTrip(t *testing.T) {
+	info := av.ColorInfo{
+		Primaries: 9,
+		Transfer:  16,
+		Matrix:    9,
+		MasteringDisplay: &av.MasteringDisplay{
+			DisplayPrimaries: [3][2]uint16{{8500, 39850}, {6550, 2300}, {35400, 14600}},
+			WhitePoint:       [2]uint16{15635, 16450},
+			MaxLuminance:     10000000,
+			MinLuminance:     50,
+		},
+		ContentLight: &av.ContentLightLevel{MaxCLL: 1000, MaxFALL: 400},
+	}
+	demuxer := &Demuxer{r: mkvio.InitDocument(bytes.NewReader(MarshalColour(info)))}
+	for {
+		if _, err := demuxer.parseElement(); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !reflect.DeepEqual(demuxer.color, info) {
+		t.Errorf("got %+v, want %+v", demuxer.color, info)
+	}
+}
+
+func TestFieldOrderRoundTrip(t *testing.T) {
+	for _, order := range []av.FieldOrder{av.FieldOrderProgressive, av.FieldOrderTopFirst, av.FieldOrderBottomFirst} {
+		video := mkvio.Marshal(mkvio.ElementVideo, MarshalFieldOrder(order)...)
+		demuxer := &Demuxer{r: mkvio.InitDocument(bytes.NewReader(video))}
+		for {
+			if _, err := demuxer.parseElement(); err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatal(err)
+			}
+		}
+		if demuxer.fields != order {
+			t.Errorf("got %v, want %v", demuxer.fields, order)
+		}
+	}
+}
@@ -12,7 +12,16 @@ import (
 )
 
 type Demuxer struct {
+	// Chapters, Tags and Attachments are filled in as the demuxer reads past
+	// them. Files written live usually have them before the first cluster,
+	// finalized ones may keep tags at the end.
+	Chapters    []Chapter
+	Tags        []Tag
+	Attachments []Attachment
+
 	r       *mkvio.Document
+	color   av.ColorInfo
+	fields  av.FieldOrder
 	pkts    []av.Packet
 	sps     []byte
 	pps     []byte
@@ -52,14 +61,23 @@ func (self *Demuxer) Streams() (streams []av.CodecData, err error) {
 func (self *Demuxer) probe() (err error) {
 	if self.stage == 0 {
 
-		var el *mkvio.Element
-		el, err = self.r.GetVideoCodec()
-		if err != nil {
-			return
+		// the Colour and field order of the track may follow its
+		// CodecPrivate, read the tracks up to the first cluster
+		var el, private mkvio.Element
+		for {
+			if el, err = self.parseElement(); err != nil {
+				return
+			}
+			if el.ElementRegister.ID == mkvio.ElementCodecPrivate.ID && private.Content == nil {
+				private = el
+			}
+			if el.ElementRegister.ID == mkvio.ElementCluster.ID {
+				break
+			}
 		}
 
-		if el.ElementRegister.ID == mkvio.ElementCodecPrivate.ID {
-			payload := el.Content[6:]
+		if private.Content != nil {
+			payload := private.Content[6:]
 			var reader int
 			for pos := 0; pos < len(payload); pos = reader {
 				lens := int(binary.BigEndian.Uint16(payload[reader:]))
@@ -88,7 +106,7 @@ func (self *Demuxer) probe() (err error) {
 			stream := &Stream{}
 			stream.idx = 0
 			stream.demuxer = self
-			stream.CodecData = codec
+			stream.CodecData = withContainerColor(codec, self.color, self.fields)
 			self.streams = append(self.streams, stream)
 
 		}
@@ -102,7 +120,7 @@ func (self *Demuxer) ReadPacket() (pkt av.Packet, err error) {
 	var el mkvio.Element
 
 	for {
-		el, err = self.r.ParseElement()
+		el, err = self.parseElement()
 		if err != nil {
 			return
 		}
@@ -13,7 +13,8 @@ func Handler(h *avutil.RegisterHandler) {
 	h.Ext = ".mkv"
 
 	h.Probe = func(b []byte) bool {
-		return b[0] == 0x47 && b[188] == 0x47
+		// EBML header
+		return b[0] == 0x1a && b[1] == 0x45 && b[2] == 0xdf && b[3] == 0xa3
 	}
 
 	h.ReaderDemuxer = func(r io.Reader) av.Demuxer {
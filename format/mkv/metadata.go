@@ -0,0 +1,133 @@
+package mkv
+
+import (
+	"time"
+
+	"github.com/deepch/vdk/format/mkv/mkvio"
+)
+
+// Chapter is a ChapterAtom, Title taken from its first ChapterDisplay.
+type Chapter struct {
+	Start time.Duration
+	End   time.Duration
+	Title string
+}
+
+// Tag is a SimpleTag, nested tags are listed after their parent.
+type Tag struct {
+	Name     string
+	Language string
+	Value    string
+	Binary   []byte
+}
+
+// Attachment is an AttachedFile, such as a font or cover art.
+type Attachment struct {
+	Name        string
+	MimeType    string
+	Description string
+	Data        []byte
+}
+
+func uintContent(el mkvio.Element) (v uint64) {
+	for _, b := range el.Content {
+		v = v<<8 | uint64(b)
+	}
+	return
+}
+
+func stringContent(el mkvio.Element) string {
+	b := el.Content
+	for len(b) > 0 && b[len(b)-1] == 0 {
+		b = b[:len(b)-1]
+	}
+	return string(b)
+}
+
+// parseElement reads the next element, keeping the chapters, tags,
+// attachments and video colour met on the way.
+func (self *Demuxer) parseElement() (el mkvio.Element, err error) {
+	if el, err = self.r.ParseElement(); err != nil {
+		return
+	}
+	switch el.ID {
+	case mkvio.ElementChapterAtom.ID:
+		self.Chapters = append(self.Chapters, Chapter{})
+	case mkvio.ElementChapterTimeStart.ID, mkvio.ElementChapterTimeEnd.ID, mkvio.ElementChapString.ID:
+		if len(self.Chapters) == 0 {
+			break
+		}
+		chapter := &self.Chapters[len(self.Chapters)-1]
+		switch el.ID {
+		case mkvio.ElementChapterTimeStart.ID:
+			chapter.Start = time.Duration(uintContent(el))
+		case mkvio.ElementChapterTimeEnd.ID:
+			chapter.End = time.Duration(uintContent(el))
+		default:
+			if chapter.Title == "" {
+				chapter.Title = stringContent(el)
+			}
+		}
+
+	case mkvio.ElementSimpleTag.ID:
+		self.Tags = append(self.Tags, Tag{})
+	case mkvio.ElementTagName.ID, mkvio.ElementTagLanguage.ID, mkvio.ElementTagString.ID, mkvio.ElementTagBinary.ID:
+		if len(self.Tags) == 0 {
+			break
+		}
+		tag := &self.Tags[len(self.Tags)-1]
+		switch el.ID {
+		case mkvio.ElementTagName.ID:
+			tag.Name = stringContent(el)
+		case mkvio.ElementTagLanguage.ID:
+			tag.Language = stringContent(el)
+		case mkvio.ElementTagString.ID:
+			tag.Value = stringContent(el)
+		default:
+			tag.Binary = el.Content
+		}
+
+	case mkvio.ElementAttachedFile.ID:
+		self.Attachments = append(self.Attachments, Attachment{})
+	case mkvio.ElementFileName.ID, mkvio.ElementFileMimeType.ID, mkvio.ElementFileDescription.ID, mkvio.ElementFileData.ID:
+		if len(self.Attachments) == 0 {
+			break
+		}
+		attachment := &self.Attachments[len(self.Attachments)-1]
+		switch el.ID {
+		case mkvio.ElementFileName.ID:
+			attachment.Name = stringContent(el)
+		case mkvio.ElementFileMimeType.ID:
+			attachment.MimeType = stringContent(el)
+		case mkvio.ElementFileDescription.ID:
+			attachment.Description = stringContent(el)
+		default:
+			attachment.Data = el.Content
+		}
+
+	default:
+		self.parseColour(el)
+	}
+	return
+}
+
+// MarshalChapters returns the Chapters element listing chapters in a single
+// edition, to be written in the segment before the first cluster.
+func MarshalChapters(chapters []Chapter) []byte {
+	var atoms [][]byte
+	for i, chapter := range chapters {
+		atom := [][]byte{
+			mkvio.MarshalUint(mkvio.ElementChapterUID, uint64(i+1)),
+			mkvio.MarshalUint(mkvio.ElementChapterTimeStart, uint64(chapter.Start)),
+		}
+		if chapter.End > chapter.Start {
+			atom = append(atom, mkvio.MarshalUint(mkvio.ElementChapterTimeEnd, uint64(chapter.End)))
+		}
+		atom = append(atom, mkvio.Marshal(mkvio.ElementChapterDisplay,
+			mkvio.MarshalString(mkvio.ElementChapString, chapter.Title),
+			mkvio.MarshalString(mkvio.ElementChapLanguage, "und"),
+		))
+		atoms = append(atoms, mkvio.Marshal(mkvio.ElementChapterAtom, atom...))
+	}
+	return mkvio.Marshal(mkvio.ElementChapters, mkvio.Marshal(mkvio.ElementEditionEntry, atoms...))
+}
@@ -99,6 +99,7 @@ var (
 	ElementTrackTranslateTrackID       = ElementRegister{0x66a5, ElementTypeBinary, "TrackTranslateTrackID"}
 	ElementVideo                       = ElementRegister{0xe0, ElementTypeMaster, "Video"}
 	ElementFlagInterlaced              = ElementRegister{0x9a, ElementTypeUint, "FlagInterlaced"}
+	ElementFieldOrder                  = ElementRegister{0x9d, ElementTypeUint, "FieldOrder"}
 	ElementStereoMode                  = ElementRegister{0x53b8, ElementTypeUint, "StereoMode"}
 	ElementAlphaMode                   = ElementRegister{0x53c0, ElementTypeUint, "AlphaMode"}
 	ElementPixelWidth                  = ElementRegister{0xb0, ElementTypeUint, "PixelWidth"}
@@ -112,6 +113,24 @@ var (
 	ElementDisplayUint                 = ElementRegister{0x54b2, ElementTypeUint, "DisplayUint"}
 	ElementAspectRatioType             = ElementRegister{0x54b3, ElementTypeUint, "AspectRatioType"}
 	ElementColourSpace                 = ElementRegister{0x2eb524, ElementTypeBinary, "ColourSpace"}
+	ElementColour                      = ElementRegister{0x55b0, ElementTypeMaster, "Colour"}
+	ElementMatrixCoefficients          = ElementRegister{0x55b1, ElementTypeUint, "MatrixCoefficients"}
+	ElementRange                       = ElementRegister{0x55b9, ElementTypeUint, "Range"}
+	ElementTransferCharacteristics     = ElementRegister{0x55ba, ElementTypeUint, "TransferCharacteristics"}
+	ElementPrimaries                   = ElementRegister{0x55bb, ElementTypeUint, "Primaries"}
+	ElementMaxCLL                      = ElementRegister{0x55bc, ElementTypeUint, "MaxCLL"}
+	ElementMaxFALL                     = ElementRegister{0x55bd, ElementTypeUint, "MaxFALL"}
+	ElementMasteringMetadata           = ElementRegister{0x55d0, ElementTypeMaster, "MasteringMetadata"}
+	ElementPrimaryRChromaticityX       = ElementRegister{0x55d1, ElementTypeFloat, "PrimaryRChromaticityX"}
+	ElementPrimaryRChromaticityY       = ElementRegister{0x55d2, ElementTypeFloat, "PrimaryRChromaticityY"}
+	ElementPrimaryGChromaticityX       = ElementRegister{0x55d3, ElementTypeFloat, "PrimaryGChromaticityX"}
+	ElementPrimaryGChromaticityY       = ElementRegister{0x55d4, ElementTypeFloat, "PrimaryGChromaticityY"}
+	ElementPrimaryBChromaticityX       = ElementRegister{0x55d5, ElementTypeFloat, "PrimaryBChromaticityX"}
+	ElementPrimaryBChromaticityY       = ElementRegister{0x55d6, ElementTypeFloat, "PrimaryBChromaticityY"}
+	ElementWhitePointChromaticityX     = ElementRegister{0x55d7, ElementTypeFloat, "WhitePointChromaticityX"}
+	ElementWhitePointChromaticityY     = ElementRegister{0x55d8, ElementTypeFloat, "WhitePointChromaticityY"}
+	ElementLuminanceMax                = ElementRegister{0x55d9, ElementTypeFloat, "LuminanceMax"}
+	ElementLuminanceMin                = ElementRegister{0x55da, ElementTypeFloat, "LuminanceMin"}
 	ElementAudio                       = ElementRegister{0xe1, ElementTypeMaster, "Audio"}
 	ElementSamplingFrequency           = ElementRegister{0xb5, ElementTypeFloat, "SamplingFrequency"}
 	ElementOutputSamplingFrequency     = ElementRegister{0x78b5, ElementTypeFloat, "OutputSamplingFrequency"}
@@ -186,8 +205,18 @@ var (
 	ElementChapProcessCommand          = ElementRegister{0x6911, ElementTypeMaster, "ChapProcessCommand"}
 	ElementChapProcessTime             = ElementRegister{0x6922, ElementTypeUint, "ChapProcessTime"}
 	ElementChapProcessData             = ElementRegister{0x6933, ElementTypeBinary, "ChapProcessData"}
-
-	// TODO: Add tags
+	ElementTags                        = ElementRegister{0x1254c367, ElementTypeMaster, "Tags"}
+	ElementTag                         = ElementRegister{0x7373, ElementTypeMaster, "Tag"}
+	ElementTargets                     = ElementRegister{0x63c0, ElementTypeMaster, "Targets"}
+	ElementTargetTypeValue             = ElementRegister{0x68ca, ElementTypeUint, "TargetTypeValue"}
+	ElementTargetType                  = ElementRegister{0x63ca, ElementTypeString, "TargetType"}
+	ElementTagTrackUID                 = ElementRegister{0x63c5, ElementTypeUint, "TagTrackUID"}
+	ElementSimpleTag                   = ElementRegister{0x67c8, ElementTypeMaster, "SimpleTag"}
+	ElementTagName                     = ElementRegister{0x45a3, ElementTypeUnicode, "TagName"}
+	ElementTagLanguage                 = ElementRegister{0x447a, ElementTypeString, "TagLanguage"}
+	ElementTagDefault                  = ElementRegister{0x4484, ElementTypeUint, "TagDefault"}
+	ElementTagString                   = ElementRegister{0x4487, ElementTypeUnicode, "TagString"}
+	ElementTagBinary                   = ElementRegister{0x4485, ElementTypeBinary, "TagBinary"}
 )
 
 // GetElementRegister returns the infos concerning the provided element ID
@@ -301,6 +330,8 @@ func GetElementRegister(id uint32) ElementRegister {
 		return ElementVideo
 	case ElementFlagInterlaced.ID:
 		return ElementFlagInterlaced
+	case ElementFieldOrder.ID:
+		return ElementFieldOrder
 	case ElementStereoMode.ID:
 		return ElementStereoMode
 	case ElementAlphaMode.ID:
@@ -325,6 +356,42 @@ func GetElementRegister(id uint32) ElementRegister {
 		return ElementDisplayUint
 	case ElementAspectRatioType.ID:
 		return ElementAspectRatioType
+	case ElementColour.ID:
+		return ElementColour
+	case ElementMatrixCoefficients.ID:
+		return ElementMatrixCoefficients
+	case ElementRange.ID:
+		return ElementRange
+	case ElementTransferCharacteristics.ID:
+		return ElementTransferCharacteristics
+	case ElementPrimaries.ID:
+		return ElementPrimaries
+	case ElementMaxCLL.ID:
+		return ElementMaxCLL
+	case ElementMaxFALL.ID:
+		return ElementMaxFALL
+	case ElementMasteringMetadata.ID:
+		return ElementMasteringMetadata
+	case ElementPrimaryRChromaticityX.ID:
+		return ElementPrimaryRChromaticityX
+	case ElementPrimaryRChromaticityY.ID:
+		return ElementPrimaryRChromaticityY
+	case ElementPrimaryGChromaticityX.ID:
+		return ElementPrimaryGChromaticityX
+	case ElementPrimaryGChromaticityY.ID:
+		return ElementPrimaryGChromaticityY
+	case ElementPrimaryBChromaticityX.ID:
+		return ElementPrimaryBChromaticityX
+	case ElementPrimaryBChromaticityY.ID:
+		return ElementPrimaryBChromaticityY
+	case ElementWhitePointChromaticityX.ID:
+		return ElementWhitePointChromaticityX
+	case ElementWhitePointChromaticityY.ID:
+		return ElementWhitePointChromaticityY
+	case ElementLuminanceMax.ID:
+		return ElementLuminanceMax
+	case ElementLuminanceMin.ID:
+		return ElementLuminanceMin
 	case ElementAudio.ID:
 		return ElementAudio
 	case ElementSamplingFrequency.ID:
@@ -351,6 +418,62 @@ func GetElementRegister(id uint32) ElementRegister {
 		return ElementContentEncAlgo
 	case ElementContentEncKeyID.ID:
 		return ElementContentEncKeyID
+	case ElementAttachments.ID:
+		return ElementAttachments
+	case ElementAttachedFile.ID:
+		return ElementAttachedFile
+	case ElementFileDescription.ID:
+		return ElementFileDescription
+	case ElementFileName.ID:
+		return ElementFileName
+	case ElementFileMimeType.ID:
+		return ElementFileMimeType
+	case ElementFileData.ID:
+		return ElementFileData
+	case ElementFileUID.ID:
+		return ElementFileUID
+	case ElementChapters.ID:
+		return ElementChapters
+	case ElementEditionEntry.ID:
+		return ElementEditionEntry
+	case ElementChapterAtom.ID:
+		return ElementChapterAtom
+	case ElementChapterUID.ID:
+		return ElementChapterUID
+	case ElementChapterTimeStart.ID:
+		return ElementChapterTimeStart
+	case ElementChapterTimeEnd.ID:
+		return ElementChapterTimeEnd
+	case ElementChapterDisplay.ID:
+		return ElementChapterDisplay
+	case ElementChapString.ID:
+		return ElementChapString
+	case ElementChapLanguage.ID:
+		return ElementChapLanguage
+	case ElementTags.ID:
+		return ElementTags
+	case ElementTag.ID:
+		return ElementTag
+	case ElementTargets.ID:
+		return ElementTargets
+	case ElementTargetTypeValue.ID:
+		return ElementTargetTypeValue
+	case ElementTargetType.ID:
+		return ElementTargetType
+	case ElementTagTrackUID.ID:
+		return ElementTagTrackUID
+	case ElementSimpleTag.ID:
+		return ElementSimpleTag
+	case ElementTagName.ID:
+		return ElementTagName
+	case ElementTagLanguage.ID:
+		return ElementTagLanguage
+	case ElementTagDefault.ID:
+		return ElementTagDefault
+	case ElementTagString.ID:
+		return ElementTagString
+	case ElementTagBinary.ID:
+		return ElementTagBinary
 	case ElementUnknown.ID:
 		return ElementUnknown
 	default:
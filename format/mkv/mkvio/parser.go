@@ -10,6 +10,10 @@ var (
 	ErrUnexpectedEOF = errors.New("Unexpected EOF")
 )
 
+// SizeUnknown is the size of elements written live, such as the segment and
+// clusters of a recording that was never finalized.
+const SizeUnknown = ^uint64(0)
+
 // InitDocument creates a MKV/WebM document containing the file data
 // It does not do any parsing
 func InitDocument(r io.Reader) *Document {
@@ -55,6 +59,7 @@ func (doc *Document) GetVideoCodec() (*Element, error) {
 
 // ParseElement parses an EBML element starting at the document's current cursor position.
 // Because of its nature, it does not set the elements's parent or level.
+// Size is SizeUnknown for a master element written live.
 func (doc *Document) ParseElement() (Element, error) {
 	var el Element
 
@@ -74,7 +79,9 @@ func (doc *Document) ParseElement() (Element, error) {
 	el.Name = reg.Name
 	el.Size = size
 
-	if el.Type != ElementTypeMaster {
+	// only master elements may have an unknown size, so read into the
+	// children of one even if its id is not registered
+	if el.Type != ElementTypeMaster && size != SizeUnknown {
 		d, err := doc.GetElementContent(&el)
 		if err != nil {
 			return el, err
@@ -195,6 +202,9 @@ func (doc *Document) GetElementSize(el *Element) (uint64, error) {
 
 	bb[0] &= mask
 	v := pack(int(length), bb)
+	if v == 1<<(7*length)-1 {
+		return SizeUnknown, nil
+	}
 
 	return v, nil
 }
@@ -0,0 +1,58 @@
+package mkvio
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// Marshal returns the element reg holding content, the marshaled children
+// of a master element or its value.
+func Marshal(reg ElementRegister, content ...[]byte) (b []byte) {
+	var size int
+	for _, c := range content {
+		size += len(c)
+	}
+	for n := 4; n > 0; n-- {
+		if id := byte(reg.ID >> uint(8*(n-1))); id != 0 || n == 1 {
+			b = append(b, id)
+		}
+	}
+	length := 1
+	for length < 8 && uint64(size) >= 1<<(7*uint(length))-1 {
+		length++
+	}
+	for i := length - 1; i >= 0; i-- {
+		v := byte(uint64(size) >> uint(8*i))
+		if i == length-1 {
+			v |= 0x80 >> uint(length-1)
+		}
+		b = append(b, v)
+	}
+	for _, c := range content {
+		b = append(b, c...)
+	}
+	return
+}
+
+// MarshalUint returns the unsigned integer element reg.
+func MarshalUint(reg ElementRegister, v uint64) []byte {
+	var c []byte
+	for n := 8; n > 0; n-- {
+		if x := byte(v >> uint(8*(n-1))); x != 0 || len(c) > 0 || n == 1 {
+			c = append(c, x)
+		}
+	}
+	return Marshal(reg, c)
+}
+
+// MarshalString returns the string or UTF-8 element reg.
+func MarshalString(reg ElementRegister, s string) []byte {
+	return Marshal(reg, []byte(s))
+}
+
+// MarshalFloat returns the 8 byte float element reg.
+func MarshalFloat(reg ElementRegister, v float64) []byte {
+	c := make([]byte, 8)
+	binary.BigEndian.PutUint64(c, math.Float64bits(v))
+	return Marshal(reg, c)
+}
@@ -0,0 +1,255 @@
+package mp4
+
+import (
+	"bytes"
+	"io"
+	"time"
+
+	"github.com/deepch/vdk/av"
+	"github.com/deepch/vdk/format/mp4/mp4io"
+	"github.com/deepch/vdk/utils/bits/pio"
+)
+
+// Chapter is a named point of the timeline shown by players in their seek
+// UI. A zero End lasts until the next chapter or the end of the movie, an
+// untitled chapter is read back as a gap.
+type Chapter struct {
+	Start time.Duration
+	End   time.Duration
+	Title string
+}
+
+const chapterTimeScale = 1000
+
+// SetChapters adds a QuickTime chapter track, referenced by every track, to
+// the file written by WriteTrailer.
+func (self *Muxer) SetChapters(chapters []Chapter) {
+	self.chapters = chapters
+}
+
+// writeChapterTrack writes the chapter samples at the end of mdat and
+// returns their track, ending at movie duration dur.
+func (self *Muxer) writeChapterTrack(trackId int32, dur time.Duration, movieTimeScale int64) (track *mp4io.Track, err error) {
+	// samples start at zero and follow each other, untitled ones fill the
+	// gaps before the first chapter and after those with an End
+	var chapters []Chapter
+	var pos time.Duration
+	for i, chapter := range self.chapters {
+		if chapter.Start > pos {
+			chapters = append(chapters, Chapter{Start: pos})
+		}
+		chapters = append(chapters, chapter)
+		switch {
+		case chapter.End > chapter.Start:
+			pos = chapter.End
+		case i+1 < len(self.chapters):
+			pos = self.chapters[i+1].Start
+		default:
+			pos = dur
+		}
+	}
+	if len(chapters) > 0 && pos < dur {
+		chapters = append(chapters, Chapter{Start: pos})
+	}
+	sample := &mp4io.SampleTable{
+		SampleDesc:   &mp4io.SampleDesc{Unknowns: []mp4io.Atom{tx3gAtom()}},
+		TimeToSample: &mp4io.TimeToSample{},
+		SampleToChunk: &mp4io.SampleToChunk{
+			Entries: []mp4io.SampleToChunkEntry{{FirstChunk: 1, SampleDescId: 1, SamplesPerChunk: 1}},
+		},
+		SampleSize:  &mp4io.SampleSize{},
+		ChunkOffset: &mp4io.ChunkOffset{},
+	}
+	var total int64
+	for i, chapter := range chapters {
+		end := dur
+		if i+1 < len(chapters) {
+			end = chapters[i+1].Start
+		}
+		duration := timeToTs(end, chapterTimeScale) - total
+		if duration < 0 {
+			duration = 0
+		}
+		total += duration
+		// a text sample is the length prefixed string and its encoding
+		data := make([]byte, 2, 2+len(chapter.Title)+12)
+		pio.PutU16BE(data, uint16(len(chapter.Title)))
+		data = append(data, chapter.Title...)
+		data = append(data, box("encd", []byte{0, 0, 1, 0})...)
+		if _, err = self.bufw.Write(data); err != nil {
+			return
+		}
+		sample.TimeToSample.Entries = append(sample.TimeToSample.Entries, mp4io.TimeToSampleEntry{Count: 1, Duration: uint32(duration)})
+		sample.ChunkOffset.Entries = append(sample.ChunkOffset.Entries, uint64(self.wpos))
+		sample.SampleSize.Entries = append(sample.SampleSize.Entries, uint32(len(data)))
+		self.wpos += int64(len(data))
+	}
+	track = &mp4io.Track{
+		Header: &mp4io.TrackHeader{
+			TrackId:  trackId,
+			Flags:    0x0002, // Track in movie, not enabled
+			Duration: int32(timeToTs(tsToTime(total, chapterTimeScale), movieTimeScale)),
+			Matrix:   [9]int32{0x10000, 0, 0, 0, 0x10000, 0, 0, 0, 0x40000000},
+		},
+		Media: &mp4io.Media{
+			Header: &mp4io.MediaHeader{
+				TimeScale: chapterTimeScale,
+				Duration:  int32(total),
+				Language:  21956,
+			},
+			Handler: &mp4io.HandlerRefer{
+				SubType: [4]byte{'t', 'e', 'x', 't'},
+				Name:    handlerNameBytes("Chapter Handler"),
+			},
+			Info: &mp4io.MediaInfo{
+				Sample: sample,
+				Data: &mp4io.DataInfo{
+					Refer: &mp4io.DataRefer{
+						Url: &mp4io.DataReferUrl{
+							Flags: 0x000001, // Self reference
+						},
+					},
+				},
+				Unknowns: []mp4io.Atom{&mp4io.Dummy{Tag_: mp4io.StringToTag("nmhd"), Data: box("nmhd", []byte{0, 0, 0, 0})}},
+			},
+		},
+	}
+	return
+}
+
+// tx3gAtom returns the 3GPP timed text sample entry of the chapter track.
+func tx3gAtom() mp4io.Atom {
+	data := box("tx3g",
+		[]byte{0, 0, 0, 0, 0, 0, 0, 1}, // reserved, data reference index
+		[]byte{0, 0, 0, 0, 1, 0xff},    // display flags, justification
+		[]byte{0, 0, 0, 0},             // background color
+		make([]byte, 8),                // text box
+		[]byte{0, 0, 0, 0, 0, 1, 0, 0x12, 0xff, 0xff, 0xff, 0xff}, // style record
+		box("ftab", []byte{0, 1, 0, 1, 5}, []byte("Serif")),
+	)
+	return &mp4io.Dummy{Tag_: mp4io.StringToTag("tx3g"), Data: data}
+}
+
+func chapterRefAtom(trackId int32) mp4io.Atom {
+	id := make([]byte, 4)
+	pio.PutU32BE(id, uint32(trackId))
+	return &mp4io.Dummy{Tag_: mp4io.StringToTag("tref"), Data: box("tref", box("chap", id))}
+}
+
+// Chapters returns the chapters of the QuickTime chapter track, or of the
+// Nero chpl box some muxers write instead.
+func (self *Demuxer) Chapters() (chapters []Chapter, err error) {
+	if err = self.probe(); err != nil {
+		return
+	}
+	if track := self.chapterTrack(); track != nil {
+		return self.readChapterTrack(track)
+	}
+	chapters = parseChpl(self.movieAtom.Unknowns)
+	return
+}
+
+func (self *Demuxer) chapterTrack() *mp4io.Track {
+	for _, track := range self.movieAtom.Tracks {
+		for _, atom := range track.Unknowns {
+			dummy, ok := atom.(*mp4io.Dummy)
+			if !ok || dummy.Tag_ != mp4io.StringToTag("tref") {
+				continue
+			}
+			b := dummy.Data
+			i := bytes.Index(b, []byte("chap"))
+			if i < 4 || len(b) < i+8 {
+				continue
+			}
+			id := int32(pio.U32BE(b[i+4:]))
+			for _, chapter := range self.movieAtom.Tracks {
+				if chapter.Header != nil && chapter.Header.TrackId == id {
+					return chapter
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (self *Demuxer) readChapterTrack(track *mp4io.Track) (chapters []Chapter, err error) {
+	if track.Media == nil || track.Media.Header == nil || track.Media.Info == nil || track.Media.Info.Sample == nil {
+		return
+	}
+	stream := &Stream{
+		trackAtom: track,
+		demuxer:   self,
+		sample:    track.Media.Info.Sample,
+		timeScale: int64(track.Media.Header.TimeScale),
+	}
+	if stream.timeScale <= 0 || stream.sample.SampleSize == nil || stream.sample.ChunkOffset == nil ||
+		stream.sample.SampleToChunk == nil || stream.sample.TimeToSample == nil {
+		return
+	}
+	for {
+		chapter := Chapter{Start: stream.sampleTime()}
+		var pkt av.Packet
+		if pkt, err = stream.readPacket(); err != nil {
+			if err == io.EOF {
+				err = nil
+			}
+			break
+		}
+		chapter.End = stream.sampleTime()
+		if len(pkt.Data) >= 2 {
+			if n := int(pio.U16BE(pkt.Data)); n <= len(pkt.Data)-2 {
+				chapter.Title = string(pkt.Data[2 : 2+n])
+			}
+		}
+		if chapter.Title == "" {
+			// filler before the first chapter or between chapters
+			continue
+		}
+		chapters = append(chapters, chapter)
+	}
+	return
+}
+
+// parseChpl reads the Nero chapter list of a udta box, starts are in 100ns
+// units.
+func parseChpl(atoms []mp4io.Atom) (chapters []Chapter) {
+	for _, atom := range atoms {
+		dummy, ok := atom.(*mp4io.Dummy)
+		if !ok || dummy.Tag_ != mp4io.StringToTag("udta") {
+			continue
+		}
+		b := dummy.Data
+		i := bytes.Index(b, []byte("chpl"))
+		if i < 4 {
+			continue
+		}
+		b = b[i+4:]
+		if len(b) < 5 {
+			continue
+		}
+		version := b[0]
+		b = b[4:]
+		if version == 1 {
+			if len(b) < 4 {
+				continue
+			}
+			b = b[4:]
+		}
+		if len(b) < 1 {
+			continue
+		}
+		count := int(b[0])
+		b = b[1:]
+		for ; count > 0 && len(b) >= 9; count-- {
+			start := time.Duration(pio.U64BE(b)) * 100
+			n := int(b[8])
+			if len(b) < 9+n {
+				break
+			}
+			chapters = append(chapters, Chapter{Start: start, Title: string(b[9 : 9+n])})
+			b = b[9+n:]
+		}
+		return
+	}
+	return
+}
@@ -0,0 +1,114 @@
+package mp4
+
+import (
+	"fmt"
+
+	"github.com/deepch/vdk/av"
+	"github.com/deepch/vdk/codec/av1parser"
+	"github.com/deepch/vdk/codec/opusparser"
+	"github.com/deepch/vdk/codec/vp9parser"
+	"github.com/deepch/vdk/format/mp4/mp4io"
+)
+
+// opusChannels is the channel count of an Opus stream, dOps without a
+// channel mapping table only has mono and stereo.
+func opusChannels(stream av.CodecData) (channels int, err error) {
+	switch codec := stream.(type) {
+	case opusparser.CodecData:
+		channels = codec.Channels
+	case *opusparser.CodecData:
+		channels = codec.Channels
+	default:
+		channels = stream.(av.AudioCodecData).ChannelLayout().Count()
+	}
+	if channels != 1 && channels != 2 {
+		err = fmt.Errorf("mp4: opus of %d channels is not supported", channels)
+	}
+	return
+}
+
+// opusPreSkip is the pre-skip of an Opus stream, players drop these samples
+// from the start.
+func opusPreSkip(stream av.CodecData) int {
+	switch codec := stream.(type) {
+	case opusparser.CodecData:
+		return codec.PreSkip
+	case *opusparser.CodecData:
+		return codec.PreSkip
+	}
+	return 0
+}
+
+func opusDesc(stream av.CodecData) (desc *mp4io.OpusDesc, err error) {
+	var channels int
+	if channels, err = opusChannels(stream); err != nil {
+		return
+	}
+	desc = &mp4io.OpusDesc{
+		DataRefIdx:       1,
+		NumberOfChannels: int16(channels),
+		SampleSize:       16,
+		SampleRate:       48000,
+		Conf: &mp4io.OpusConf{
+			OutputChannelCount: uint8(channels),
+			PreSkip:            uint16(opusPreSkip(stream)),
+			InputSampleRate:    48000,
+		},
+	}
+	return
+}
+
+// videoDesc is the vp09 or av01 sample entry of stream, only the codec data
+// of vp9parser and av1parser has the configuration record it needs.
+func videoDesc(stream av.CodecData) (desc *mp4io.VideoDesc, err error) {
+	var tag mp4io.Tag
+	var conf mp4io.Atom
+	switch codec := stream.(type) {
+	case vp9parser.CodecData:
+		tag = mp4io.VP09
+		// vpcC is a full box of version 1
+		conf = mp4io.NewDummy(mp4io.VPCC, append([]byte{1, 0, 0, 0}, codec.VPCodecConfRecordBytes()...))
+	case av1parser.CodecData:
+		tag = mp4io.AV01
+		conf = mp4io.NewDummy(mp4io.AV1C, codec.AV1CodecConfRecordBytes())
+	default:
+		err = fmt.Errorf("mp4: %v codec data %T is not supported", stream.Type(), stream)
+		return
+	}
+	video := stream.(av.VideoCodecData)
+	desc = &mp4io.VideoDesc{
+		Tag_:                 tag,
+		DataRefIdx:           1,
+		HorizontalResolution: 72,
+		VorizontalResolution: 72,
+		Width:                int16(video.Width()),
+		Height:               int16(video.Height()),
+		FrameCount:           1,
+		Depth:                24,
+		ColorTableId:         -1,
+		Unknowns:             []mp4io.Atom{conf},
+	}
+	return
+}
+
+// videoCodecData is the codec of a vp09 or av01 sample entry.
+func videoCodecData(desc *mp4io.VideoDesc) (stream av.CodecData, err error) {
+	switch desc.Tag_ {
+	case mp4io.VP09:
+		conf := desc.ConfData(mp4io.VPCC)
+		if len(conf) < 4 {
+			err = fmt.Errorf("mp4: vpcC not found")
+			return
+		}
+		return vp9parser.NewCodecDataFromVPCodecConfRecord(conf[4:], int(desc.Width), int(desc.Height))
+	case mp4io.AV01:
+		conf := desc.ConfData(mp4io.AV1C)
+		if conf == nil {
+			err = fmt.Errorf("mp4: av1C not found")
+			return
+		}
+		return av1parser.NewCodecDataFromAV1CodecConfRecord(conf)
+	}
+	err = fmt.Errorf("mp4: %s is not supported", desc.Tag_)
+	return
+}
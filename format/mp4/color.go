@@ -0,0 +1,95 @@
+package mp4
+
+import (
+	"github.com/deepch/vdk/av"
+	"github.com/deepch/vdk/codec/h264parser"
+	"github.com/deepch/vdk/codec/h265parser"
+	"github.com/deepch/vdk/format/mp4/mp4io"
+)
+
+func colorAtoms(info av.ColorInfo) (atoms []mp4io.Atom) {
+	if info.HasColorDescription() {
+		atoms = append(atoms, &mp4io.ColourInfo{
+			ColourType: mp4io.ColourTypeNCLX,
+			Primaries:  uint16(info.Primaries),
+			Transfer:   uint16(info.Transfer),
+			Matrix:     uint16(info.Matrix),
+			FullRange:  info.FullRange,
+		})
+	}
+	if md := info.MasteringDisplay; md != nil {
+		atoms = append(atoms, &mp4io.MasteringDisplayColour{
+			DisplayPrimaries: md.DisplayPrimaries,
+			WhitePoint:       md.WhitePoint,
+			MaxLuminance:     md.MaxLuminance,
+			MinLuminance:     md.MinLuminance,
+		})
+	}
+	if cl := info.ContentLight; cl != nil {
+		atoms = append(atoms, &mp4io.ContentLightLevel{
+			MaxCLL:  cl.MaxCLL,
+			MaxFALL: cl.MaxFALL,
+		})
+	}
+	return
+}
+
+func colorInfoFromAtoms(atoms []mp4io.Atom) (info av.ColorInfo) {
+	for _, atom := range atoms {
+		switch atom := mp4io.ParseUnknownAtom(atom).(type) {
+		case *mp4io.ColourInfo:
+			if atom.ColourType == mp4io.ColourTypeNCLX {
+				info.Primaries = uint8(atom.Primaries)
+				info.Transfer = uint8(atom.Transfer)
+				info.Matrix = uint8(atom.Matrix)
+				info.FullRange = atom.FullRange
+			}
+		case *mp4io.MasteringDisplayColour:
+			info.MasteringDisplay = &av.MasteringDisplay{
+				DisplayPrimaries: atom.DisplayPrimaries,
+				WhitePoint:       atom.WhitePoint,
+				MaxLuminance:     atom.MaxLuminance,
+				MinLuminance:     atom.MinLuminance,
+			}
+		case *mp4io.ContentLightLevel:
+			info.ContentLight = &av.ContentLightLevel{
+				MaxCLL:  atom.MaxCLL,
+				MaxFALL: atom.MaxFALL,
+			}
+		}
+	}
+	return
+}
+
+// withContainerColor lets the sample entry boxes fill in what the parameter
+// sets do not carry.
+func withContainerColor(codec av.CodecData, atoms []mp4io.Atom) av.CodecData {
+	info := colorInfoFromAtoms(atoms)
+	switch codec := codec.(type) {
+	case h264parser.CodecData:
+		if codec.SPSInfo.ColourPrimaries == 0 && info.HasColorDescription() {
+			codec.SPSInfo.ColourPrimaries = uint(info.Primaries)
+			codec.SPSInfo.TransferCharacteristics = uint(info.Transfer)
+			codec.SPSInfo.MatrixCoefficients = uint(info.Matrix)
+			if info.FullRange {
+				codec.SPSInfo.VideoFullRange = 1
+			}
+		}
+		codec.MasteringDisplay = info.MasteringDisplay
+		codec.ContentLight = info.ContentLight
+		return codec
+	case h265parser.CodecData:
+		if codec.SPSInfo.ColourPrimaries == 0 && info.HasColorDescription() {
+			codec.SPSInfo.ColourPrimaries = uint(info.Primaries)
+			codec.SPSInfo.TransferCharacteristics = uint(info.Transfer)
+			codec.SPSInfo.MatrixCoefficients = uint(info.Matrix)
+			if info.FullRange {
+				codec.SPSInfo.VideoFullRange = 1
+			}
+		}
+		codec.MasteringDisplay = info.MasteringDisplay
+		codec.ContentLight = info.ContentLight
+		return codec
+	}
+	return codec
+}
@@ -4,18 +4,31 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"os"
+	"sort"
 	"time"
 
 	"github.com/deepch/vdk/av"
 	"github.com/deepch/vdk/codec/aacparser"
 	"github.com/deepch/vdk/codec/h264parser"
+	"github.com/deepch/vdk/codec/h265parser"
+	"github.com/deepch/vdk/codec/opusparser"
 	"github.com/deepch/vdk/format/mp4/mp4io"
 )
 
 type Demuxer struct {
 	r         io.ReadSeeker
+	ra        io.ReaderAt
+	size      int64
 	streams   []*Stream
 	movieAtom *mp4io.Movie
+	pending   []av.Packet // frames of ADTS samples
+
+	packets, bytes []int64 // read of each stream
+
+	// OnUnknown tells the boxes skipped as unknown, by path such as
+	// moov/udta, when the file is probed.
+	OnUnknown func(id string, offset int64, size int64)
 }
 
 func NewDemuxer(r io.ReadSeeker) *Demuxer {
@@ -24,6 +37,68 @@ func NewDemuxer(r io.ReadSeeker) *Demuxer {
 	}
 }
 
+// NewDemuxerAt reads from r through its own position, so several demuxers
+// can share one file handle and play concurrently.
+func NewDemuxerAt(r io.ReaderAt, size int64) *Demuxer {
+	self := NewDemuxer(io.NewSectionReader(r, 0, size))
+	self.ra = r
+	self.size = size
+	return self
+}
+
+// Fork returns a demuxer sharing the parsed moov and sample tables, read
+// only, with its own position, starting at the position of self with its
+// options and counters. The source must be an io.ReaderAt, as given to
+// NewDemuxerAt, or an *os.File or other one telling its size.
+func (self *Demuxer) Fork() (fork *Demuxer, err error) {
+	if err = self.probe(); err != nil {
+		return
+	}
+	if self.ra == nil {
+		ra, ok := self.r.(io.ReaderAt)
+		if !ok {
+			err = fmt.Errorf("mp4: Fork needs an io.ReaderAt source")
+			return
+		}
+		var size int64
+		if size, err = sourceSize(self.r); err != nil {
+			return
+		}
+		self.ra, self.size = ra, size
+	}
+	fork = NewDemuxerAt(self.ra, self.size)
+	fork.movieAtom = self.movieAtom
+	fork.pending = append([]av.Packet(nil), self.pending...)
+	fork.packets = append([]int64(nil), self.packets...)
+	fork.bytes = append([]int64(nil), self.bytes...)
+	fork.OnUnknown = self.OnUnknown
+	fork.streams = make([]*Stream, len(self.streams))
+	for i, stream := range self.streams {
+		forked := *stream
+		forked.demuxer = fork
+		fork.streams[i] = &forked
+	}
+	return
+}
+
+// sourceSize tells the size of r without moving its position, which the
+// demuxer sharing it may rely on.
+func sourceSize(r io.Reader) (size int64, err error) {
+	switch r := r.(type) {
+	case interface{ Size() int64 }:
+		size = r.Size()
+	case interface{ Stat() (os.FileInfo, error) }:
+		var fi os.FileInfo
+		if fi, err = r.Stat(); err != nil {
+			return
+		}
+		size = fi.Size()
+	default:
+		err = fmt.Errorf("mp4: Fork needs a source telling its size")
+	}
+	return
+}
+
 func (self *Demuxer) Streams() (streams []av.CodecData, err error) {
 	if err = self.probe(); err != nil {
 		return
@@ -44,6 +119,27 @@ func (self *Demuxer) readat(pos int64, b []byte) (err error) {
 	return
 }
 
+func (self *Demuxer) reportUnknown(atoms []mp4io.Atom, path string) {
+	for _, atom := range atoms {
+		name := path + atom.Tag().String()
+		if _, ok := atom.(*mp4io.Dummy); !ok {
+			self.reportUnknown(atom.Children(), name+"/")
+			continue
+		}
+		if path == "" {
+			switch name {
+			case "ftyp", "mdat", "free", "skip", "wide":
+				continue
+			}
+		}
+		if mp4io.ParseUnknownAtom(atom) != atom {
+			continue
+		}
+		offset, size := atom.Pos()
+		self.OnUnknown(name, int64(offset), int64(size))
+	}
+}
+
 func (self *Demuxer) probe() (err error) {
 	if self.movieAtom != nil {
 		return
@@ -58,6 +154,9 @@ func (self *Demuxer) probe() (err error) {
 	if _, err = self.r.Seek(0, 0); err != nil {
 		return
 	}
+	if self.OnUnknown != nil {
+		self.reportUnknown(atoms, "")
+	}
 
 	for _, atom := range atoms {
 		if atom.Tag() == mp4io.MOOV {
@@ -89,14 +188,65 @@ func (self *Demuxer) probe() (err error) {
 			if stream.CodecData, err = h264parser.NewCodecDataFromAVCDecoderConfRecord(avc1.Data); err != nil {
 				return
 			}
+			if desc := stream.sample.SampleDesc; desc != nil && desc.AVC1Desc != nil {
+				stream.CodecData = withContainerColor(stream.CodecData, desc.AVC1Desc.Unknowns)
+				stream.CodecData = withFieldOrder(stream.CodecData.(h264parser.CodecData), desc.AVC1Desc.Unknowns)
+			}
+			stream.CodecData = withTrackRotation(stream.CodecData, atrack.Header)
+			self.streams = append(self.streams, stream)
+		} else if hv1 := atrack.GetHV1Conf(); hv1 != nil {
+			if stream.CodecData, err = h265parser.NewCodecDataFromAVCDecoderConfRecord(hv1.Data); err != nil {
+				return
+			}
+			if desc := stream.sample.SampleDesc; desc != nil && desc.HV1Desc != nil {
+				stream.CodecData = withContainerColor(stream.CodecData, desc.HV1Desc.Unknowns)
+			}
+			stream.CodecData = withTrackRotation(stream.CodecData, atrack.Header)
+			self.streams = append(self.streams, stream)
+		} else if esds := atrack.GetElemStreamDesc(); esds != nil || stream.sample.SampleDesc != nil && stream.sample.SampleDesc.MP4ADesc != nil {
+			var config []byte
+			if esds != nil {
+				config = esds.DecConfig
+			}
+			var codec aacparser.CodecData
+			if codec, err = aacparser.NewCodecDataFromMPEG4AudioConfigBytes(config); err != nil || !codec.Config.IsValid() {
+				if codec, stream.adts = stream.probeADTS(); !stream.adts {
+					if err == nil {
+						err = fmt.Errorf("mp4: stream[%d]: aac config not found", i)
+					}
+					return
+				}
+				err = nil
+			}
+			stream.CodecData = codec
+			self.streams = append(self.streams, stream)
+		} else if desc := stream.sample.SampleDesc; desc != nil && desc.FindPCMDesc() != nil {
+			if stream.CodecData, err = pcmCodecData(desc.FindPCMDesc()); err != nil {
+				return
+			}
 			self.streams = append(self.streams, stream)
-		} else if esds := atrack.GetElemStreamDesc(); esds != nil {
-			if stream.CodecData, err = aacparser.NewCodecDataFromMPEG4AudioConfigBytes(esds.DecConfig); err != nil {
+		} else if desc := stream.sample.SampleDesc; desc != nil && desc.FindOpusDesc() != nil {
+			opus := desc.FindOpusDesc()
+			codec := opusparser.CodecData{Channels: int(opus.NumberOfChannels)}
+			if opus.Conf != nil {
+				codec.PreSkip = int(opus.Conf.PreSkip)
+			}
+			stream.CodecData = codec
+			self.streams = append(self.streams, stream)
+		} else if desc := stream.sample.SampleDesc; desc != nil && (desc.FindVideoDesc(mp4io.VP09) != nil || desc.FindVideoDesc(mp4io.AV01) != nil) {
+			video := desc.FindVideoDesc(mp4io.VP09)
+			if video == nil {
+				video = desc.FindVideoDesc(mp4io.AV01)
+			}
+			if stream.CodecData, err = videoCodecData(video); err != nil {
 				return
 			}
 			self.streams = append(self.streams, stream)
 		}
 	}
+	for _, stream := range self.streams {
+		stream.fillEdits(moov)
+	}
 
 	self.movieAtom = moov
 	return
@@ -298,6 +448,19 @@ func (self *Stream) sampleCount() int {
 }
 
 func (self *Demuxer) ReadPacket() (pkt av.Packet, err error) {
+	if pkt, err = self.readPacket(); err != nil {
+		return
+	}
+	if n := len(self.streams); len(self.packets) < n {
+		self.packets = append(self.packets, make([]int64, n-len(self.packets))...)
+		self.bytes = append(self.bytes, make([]int64, n-len(self.bytes))...)
+	}
+	self.packets[pkt.Idx]++
+	self.bytes[pkt.Idx] += int64(len(pkt.Data))
+	return
+}
+
+func (self *Demuxer) readPacket() (pkt av.Packet, err error) {
 	if err = self.probe(); err != nil {
 		return
 	}
@@ -305,48 +468,100 @@ func (self *Demuxer) ReadPacket() (pkt av.Packet, err error) {
 		err = errors.New("mp4: no streams available while trying to read a packet")
 		return
 	}
+	if len(self.pending) > 0 {
+		pkt = self.pending[0]
+		self.pending = self.pending[1:]
+		return
+	}
 
 	var chosen *Stream
 	var chosenidx int
 	for i, stream := range self.streams {
-		if chosen == nil || stream.tsToTime(stream.dts) < chosen.tsToTime(chosen.dts) {
+		if chosen == nil || stream.sampleTime() < chosen.sampleTime() {
 			chosen = stream
 			chosenidx = i
 		}
 	}
 	if false {
-		fmt.Printf("ReadPacket: chosen index=%v time=%v\n", chosen.idx, chosen.tsToTime(chosen.dts))
+		fmt.Printf("ReadPacket: chosen index=%v time=%v\n", chosen.idx, chosen.sampleTime())
 	}
-	tm := chosen.tsToTime(chosen.dts)
+	tm := chosen.sampleTime()
 	if pkt, err = chosen.readPacket(); err != nil {
 		return
 	}
 	pkt.Time = tm
 	pkt.Idx = int8(chosenidx)
+	if chosen.adts {
+		pkt = self.splitADTS(chosen, pkt)
+	}
+	return
+}
+
+// probeADTS finds the config of an AAC track without one in the ADTS
+// header of its first sample, as left by muxers copying ADTS streams.
+func (self *Stream) probeADTS() (codec aacparser.CodecData, ok bool) {
+	probe := *self
+	pkt, err := probe.readPacket()
+	if err != nil {
+		return
+	}
+	config, _, err := aacparser.SplitADTS(pkt.Data)
+	if err != nil {
+		return
+	}
+	if codec, err = aacparser.NewCodecDataFromMPEG4AudioConfig(config); err != nil {
+		return
+	}
+	ok = true
 	return
 }
 
+// splitADTS strips the ADTS headers of pkt, a sample of several frames
+// gives a packet per frame.
+func (self *Demuxer) splitADTS(stream *Stream, pkt av.Packet) av.Packet {
+	_, frames, err := aacparser.SplitADTS(pkt.Data)
+	if err != nil {
+		return pkt
+	}
+	dur, _ := stream.CodecData.(aacparser.CodecData).PacketDuration(nil)
+	for i, frame := range frames[1:] {
+		next := pkt
+		next.Data = frame
+		next.Time = pkt.Time + dur*time.Duration(i+1)
+		self.pending = append(self.pending, next)
+	}
+	pkt.Data = frames[0]
+	return pkt
+}
+
 func (self *Demuxer) CurrentTime() (tm time.Duration) {
 	if len(self.streams) > 0 {
 		stream := self.streams[0]
-		tm = stream.tsToTime(stream.dts)
+		tm = stream.sampleTime()
 	}
 	return
 }
 
+// SeekToTime moves the read position to the sync sample of the video at or
+// before tm and the audio to the time of that sample, so packets read next
+// start decodable and in sync. Without video the audio goes to tm.
 func (self *Demuxer) SeekToTime(tm time.Duration) (err error) {
+	if err = self.probe(); err != nil {
+		return
+	}
+	self.pending = nil
 	for _, stream := range self.streams {
-		if stream.Type().IsVideo() {
+		if stream.Type().IsVideo() && stream.sampleCount() > 0 {
 			if err = stream.seekToTime(tm); err != nil {
 				return
 			}
-			tm = stream.tsToTime(stream.dts)
+			tm = stream.sampleTime()
 			break
 		}
 	}
 
 	for _, stream := range self.streams {
-		if !stream.Type().IsVideo() {
+		if !stream.Type().IsVideo() && stream.sampleCount() > 0 {
 			if err = stream.seekToTime(tm); err != nil {
 				return
 			}
@@ -385,7 +600,8 @@ func (self *Stream) readPacket() (pkt av.Packet, err error) {
 
 	//println("pts/dts", self.ptsEntryIndex, self.dtsEntryIndex)
 	if self.sample.CompositionOffset != nil && len(self.sample.CompositionOffset.Entries) > 0 {
-		cts := int64(self.sample.CompositionOffset.Entries[self.cttsEntryIndex].Offset)
+		// signed in version 1, some writers put negative offsets in version 0 too
+		cts := int64(int32(self.sample.CompositionOffset.Entries[self.cttsEntryIndex].Offset))
 		pkt.CompositionTime = self.tsToTime(cts)
 	}
 
@@ -395,7 +611,7 @@ func (self *Stream) readPacket() (pkt av.Packet, err error) {
 }
 
 func (self *Stream) seekToTime(tm time.Duration) (err error) {
-	index := self.timeToSampleIndex(tm)
+	index := self.timeToSampleIndex(tm + self.timeOffset)
 	if err = self.setSampleIndex(index); err != nil {
 		return
 	}
@@ -405,42 +621,103 @@ func (self *Stream) seekToTime(tm time.Duration) (err error) {
 	return
 }
 
+// timeToSampleIndex returns the sample at tm, or the sync sample before it
+// for video, the first or last sample out of the track.
 func (self *Stream) timeToSampleIndex(tm time.Duration) int {
 	targetTs := self.timeToTs(tm)
 	targetIndex := 0
 
 	startTs := int64(0)
-	endTs := int64(0)
 	startIndex := 0
-	endIndex := 0
 	found := false
 	for _, entry := range self.sample.TimeToSample.Entries {
-		endTs = startTs + int64(entry.Count*entry.Duration)
-		endIndex = startIndex + int(entry.Count)
+		endTs := startTs + int64(entry.Count)*int64(entry.Duration)
 		if targetTs >= startTs && targetTs < endTs {
 			targetIndex = startIndex + int((targetTs-startTs)/int64(entry.Duration))
 			found = true
+			break
 		}
 		startTs = endTs
-		startIndex = endIndex
+		startIndex += int(entry.Count)
 	}
-	if !found {
-		if targetTs < 0 {
-			targetIndex = 0
-		} else {
-			targetIndex = endIndex - 1
-		}
+	if !found && targetTs > 0 && startIndex > 0 {
+		targetIndex = startIndex - 1
 	}
 
 	if self.sample.SyncSample != nil {
+		// sync samples are numbered from 1, in increasing order
 		entries := self.sample.SyncSample.Entries
-		for i := len(entries) - 1; i >= 0; i-- {
-			if entries[i]-1 < uint32(targetIndex) {
-				targetIndex = int(entries[i] - 1)
-				break
-			}
+		i := sort.Search(len(entries), func(i int) bool {
+			return int(entries[i]-1) > targetIndex
+		})
+		if i > 0 {
+			targetIndex = int(entries[i-1] - 1)
+		} else if len(entries) > 0 {
+			targetIndex = int(entries[0] - 1)
 		}
 	}
 
 	return targetIndex
 }
+
+// State is the read position of a Demuxer, to resume reading the same file
+// with another demuxer, such as after a restart. It is a position only, it
+// holds no index: the demuxer restoring it reads and parses the sample
+// tables of the moov box again, as on any open, and skips only the samples
+// before the position.
+type State struct {
+	Samples []int   // index of the next sample of each stream
+	Packets []int64 // read of each stream, more than Samples for ADTS ones
+	Bytes   []int64 // of the packets read of each stream
+}
+
+// SaveState returns the position, after the ADTS frames of the last
+// sample read have all been read.
+func (self *Demuxer) SaveState() (state State, err error) {
+	if err = self.probe(); err != nil {
+		return
+	}
+	if len(self.pending) > 0 {
+		err = fmt.Errorf("mp4: packets of the last sample pending")
+		return
+	}
+	for _, stream := range self.streams {
+		state.Samples = append(state.Samples, stream.sampleIndex)
+	}
+	state.Packets = append([]int64(nil), self.packets...)
+	state.Bytes = append([]int64(nil), self.bytes...)
+	return
+}
+
+// RestoreState moves to the position of state, after probing the file.
+func (self *Demuxer) RestoreState(state State) (err error) {
+	if err = self.probe(); err != nil {
+		return
+	}
+	if len(state.Samples) != len(self.streams) || len(state.Packets) > len(self.streams) || len(state.Bytes) != len(state.Packets) {
+		err = fmt.Errorf("mp4: state of %d streams for %d", len(state.Samples), len(self.streams))
+		return
+	}
+	self.pending = nil
+	self.packets = append([]int64(nil), state.Packets...)
+	self.bytes = append([]int64(nil), state.Bytes...)
+	for i, stream := range self.streams {
+		index, count := state.Samples[i], stream.sampleCount()
+		if index < 0 || index > count {
+			err = fmt.Errorf("mp4: stream[%d]: state sample %d out of %d", i, index, count)
+			return
+		}
+		if index < count {
+			err = stream.setSampleIndex(index)
+		} else if count > 0 {
+			// past the last sample, as when it was read
+			if err = stream.setSampleIndex(count - 1); err == nil {
+				stream.incSampleIndex()
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+	return
+}
@@ -0,0 +1,201 @@
+package mp4
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"github.com/deepch/vdk/codec/aacparser"
+	"github.com/deepch/vdk/format/mp4/mp4io"
+	"github.com/deepch/vdk/utils/bits/pio"
+)
+
+// The edit list of a track carries the AAC encoder delay, which players trim
+// on playback, the composition offset of the first video frame and the time
+// range of segments cut by SetTimeRange. The AAC delay is also written as the
+// iTunSMPB tag read by Apple players.
+
+// aacPriming is the encoder delay of an AAC track in samples, zero for the
+// other tracks.
+func (self *Stream) aacPriming() (priming int) {
+	if codec, ok := self.CodecData.(aacparser.CodecData); ok && codec.Priming > 0 && codec.SampleRate() > 0 {
+		priming = codec.Priming
+	}
+	return
+}
+
+// fillEditList starts the presentation after the priming samples and at
+// the first composition time of video, delays tracks starting after the
+// first packet of the movie, and keeps the track within the time range of
+// the muxer, audio frames straddling a cut are trimmed rather than dropped.
+func (self *Stream) fillEditList(movieTimeScale int64) {
+	var trim int64
+	if priming := self.aacPriming(); priming > 0 {
+		// rounded, as the time scale of the track may not be the sample rate
+		sampleRate := int64(self.CodecData.(aacparser.CodecData).SampleRate())
+		trim = (int64(priming)*self.timeScale + sampleRate/2) / sampleRate
+	}
+	var delay time.Duration
+	ranged := self.muxer.timeRange
+	start := self.muxer.firstTime()
+	if ranged {
+		start = self.muxer.startTime
+	}
+	if d := self.firstTime - start; d > 0 {
+		delay = d
+	} else {
+		trim += self.timeToTs(-d)
+	}
+	if trim >= self.duration {
+		return
+	}
+	dur := self.tsToTime(self.duration - trim)
+	if ranged && self.muxer.endTime > self.muxer.startTime {
+		if max := self.muxer.endTime - self.muxer.startTime - delay; dur > max {
+			dur = max
+		}
+	}
+	cts := self.firstCompositionOffset()
+	if dur <= 0 || (delay == 0 && trim == 0 && cts == 0 && dur == self.tsToTime(self.duration)) {
+		return
+	}
+	elst := &mp4io.EditList{}
+	if delay > 0 {
+		elst.Entries = append(elst.Entries, mp4io.EditListEntry{
+			SegmentDuration: timeToTs(delay, movieTimeScale), MediaTime: -1, MediaRateInteger: 1,
+		})
+	}
+	elst.Entries = append(elst.Entries, mp4io.EditListEntry{
+		SegmentDuration: timeToTs(dur, movieTimeScale), MediaTime: trim + cts, MediaRateInteger: 1,
+	})
+	self.trackAtom.Header.Duration = int32(timeToTs(delay+dur, movieTimeScale))
+	self.trackAtom.Unknowns = append(self.trackAtom.Unknowns, &mp4io.Edit{List: elst})
+}
+
+// firstCompositionOffset is the composition offset of the first sample,
+// zero without B-frames or with negative offsets.
+func (self *Stream) firstCompositionOffset() (cts int64) {
+	if table := self.sample.CompositionOffset; table != nil && len(table.Entries) > 0 {
+		if cts = int64(int32(table.Entries[0].Offset)); cts < 0 {
+			cts = 0
+		}
+	}
+	return
+}
+
+func box(tag string, payload ...[]byte) []byte {
+	b := make([]byte, 8)
+	copy(b[4:], tag)
+	for _, p := range payload {
+		b = append(b, p...)
+	}
+	pio.PutU32BE(b, uint32(len(b)))
+	return b
+}
+
+// iTunSMPB returns the udta box holding the iTunSMPB tag of an AAC track
+// with priming samples, nil for the other tracks.
+func (self *Stream) iTunSMPB() mp4io.Atom {
+	priming := self.aacPriming()
+	if priming == 0 {
+		return nil
+	}
+	sampleRate := int64(self.CodecData.(aacparser.CodecData).SampleRate())
+	samples := self.duration*sampleRate/self.timeScale - int64(priming)
+	return iTunSMPBAtom(priming, 0, samples)
+}
+
+// iTunSMPBAtom returns the udta box holding the iTunSMPB tag.
+func iTunSMPBAtom(priming, padding int, samples int64) mp4io.Atom {
+	value := fmt.Sprintf(" 00000000 %08X %08X %016X", priming, padding, samples)
+	for i := 0; i < 8; i++ {
+		value += " 00000000"
+	}
+	fullbox := []byte{0, 0, 0, 0}
+	hdlr := box("hdlr", fullbox, []byte{0, 0, 0, 0}, []byte("mdirappl"), make([]byte, 9))
+	item := box("----",
+		box("mean", fullbox, []byte("com.apple.iTunes")),
+		box("name", fullbox, []byte("iTunSMPB")),
+		box("data", []byte{0, 0, 0, 1}, []byte{0, 0, 0, 0}, []byte(value)),
+	)
+	data := box("udta", box("meta", fullbox, hdlr, box("ilst", item)))
+	return &mp4io.Dummy{Tag_: mp4io.StringToTag("udta"), Data: data}
+}
+
+// parseITunSMPB finds the priming samples in the iTunSMPB tag of a udta box.
+func parseITunSMPB(atoms []mp4io.Atom) (priming int, ok bool) {
+	for _, atom := range atoms {
+		dummy, isDummy := atom.(*mp4io.Dummy)
+		if !isDummy || dummy.Tag_ != mp4io.StringToTag("udta") {
+			continue
+		}
+		b := dummy.Data
+		i := bytes.Index(b, []byte("iTunSMPB"))
+		if i < 0 {
+			continue
+		}
+		b = b[i:]
+		if i = bytes.Index(b, []byte("data")); i < 4 || len(b) < i+12 {
+			continue
+		}
+		size := int(pio.U32BE(b[i-4:]))
+		if size < 16 || len(b) < i-4+size {
+			continue
+		}
+		var zero, padding int
+		if _, err := fmt.Sscanf(string(b[i+12:i-4+size]), "%x %x %x", &zero, &priming, &padding); err != nil {
+			continue
+		}
+		ok = true
+		return
+	}
+	return
+}
+
+// fillEdits applies the start of the first edit of the track, packets are
+// shifted so the first presented sample is at the start of the movie, or
+// after the empty edits delaying the track. Of a video track the part of
+// the start compensating the composition offset of the first sample is
+// left to the composition times packets carry. The start of an AAC track is
+// read back as its priming, from the edit list or the iTunSMPB tag, and its
+// packets keep the times of the encoder output.
+func (self *Stream) fillEdits(moov *mp4io.Movie) {
+	if self.timeScale <= 0 {
+		return
+	}
+	codec, isAAC := self.CodecData.(aacparser.CodecData)
+	if isAAC && codec.SampleRate() <= 0 {
+		isAAC = false
+	}
+	movieTimeScale := int64(0)
+	if moov.Header != nil {
+		movieTimeScale = int64(moov.Header.TimeScale)
+	}
+	if elst := self.trackAtom.FindEditList(); elst != nil && movieTimeScale > 0 {
+		var delay time.Duration
+		for _, entry := range elst.Entries {
+			if entry.MediaTime == -1 {
+				delay += tsToTime(entry.SegmentDuration, movieTimeScale)
+				continue
+			}
+			start := entry.MediaTime
+			if isAAC {
+				codec.Priming = int((start*int64(codec.SampleRate()) + self.timeScale/2) / self.timeScale)
+				start = 0
+			} else if self.Type().IsVideo() {
+				if start -= self.firstCompositionOffset(); start < 0 {
+					start = 0
+				}
+			}
+			self.timeOffset = self.tsToTime(start) - delay
+			break
+		}
+	} else if !isAAC {
+		return
+	} else if priming, ok := parseITunSMPB(moov.Unknowns); ok {
+		codec.Priming = priming
+	}
+	if isAAC {
+		self.CodecData = codec
+	}
+}
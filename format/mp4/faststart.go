@@ -0,0 +1,122 @@
+package mp4
+
+import (
+	"io"
+
+	"github.com/deepch/vdk/format/mp4/mp4io"
+	"github.com/deepch/vdk/utils/bits/pio"
+)
+
+// SetFastStart puts the moov before the mdat, so browsers start playing a
+// progressive download at once. WriteHeader keeps reserve bytes for the
+// moov ahead of the mdat, a moov takes about 10 bytes by sample. When the
+// moov does not fit and the writer is an io.ReaderAt too, as an *os.File,
+// WriteTrailer moves the mdat forward to make room, otherwise the moov is
+// written at the end. A zero reserve always moves the mdat.
+func (self *Muxer) SetFastStart(reserve int) {
+	self.fastStart = true
+	self.reserve = int64(reserve)
+	if self.reserve > 0 && self.reserve < 8 {
+		self.reserve = 8
+	}
+}
+
+func freeAtom(b []byte) {
+	pio.PutU32BE(b, uint32(len(b)))
+	copy(b[4:], "free")
+}
+
+// writeReserve writes the free atom the moov replaces at the end, after
+// the ftyp.
+func (self *Muxer) writeReserve() (err error) {
+	if self.reserve == 0 {
+		return
+	}
+	b := make([]byte, self.reserve)
+	freeAtom(b)
+	if _, err = self.w.Write(b); err != nil {
+		return
+	}
+	self.wpos += self.reserve
+	return
+}
+
+// writeMoov writes moov, at the end of the file or in front for fast
+// start, the data written and flushed.
+func (self *Muxer) writeMoov(moov *mp4io.Movie) (err error) {
+	size := int64(moov.Len())
+	fits := size == self.reserve || size+8 <= self.reserve
+	r, readable := self.w.(io.ReaderAt)
+	if !self.fastStart || (!fits && !readable) {
+		if _, err = self.w.Seek(0, 2); err != nil {
+			return
+		}
+		b := make([]byte, size)
+		moov.Marshal(b)
+		_, err = self.w.Write(b)
+		return
+	}
+
+	var b []byte
+	if fits {
+		b = make([]byte, self.reserve)
+		if size < self.reserve {
+			freeAtom(b[size:])
+		}
+	} else {
+		var end int64
+		if end, err = self.w.Seek(0, 2); err != nil {
+			return
+		}
+		var delta int64
+		for delta != size-self.reserve {
+			shiftChunkOffsets(moov, size-self.reserve-delta)
+			delta = size - self.reserve
+			size = int64(moov.Len())
+		}
+		b = make([]byte, size)
+		if err = self.moveData(r, self.moovPos+self.reserve, end, delta); err != nil {
+			return
+		}
+	}
+	moov.Marshal(b)
+	if _, err = self.w.Seek(self.moovPos, 0); err != nil {
+		return
+	}
+	_, err = self.w.Write(b)
+	return
+}
+
+// moveData moves the bytes from start to end by delta forward, copying
+// from the end so the data is not overwritten before it is read.
+func (self *Muxer) moveData(r io.ReaderAt, start, end, delta int64) (err error) {
+	buf := make([]byte, 1<<20)
+	for pos := end; pos > start; {
+		n := int64(len(buf))
+		if pos-start < n {
+			n = pos - start
+		}
+		pos -= n
+		if _, err = r.ReadAt(buf[:n], pos); err != nil {
+			return
+		}
+		if _, err = self.w.Seek(pos+delta, 0); err != nil {
+			return
+		}
+		if _, err = self.w.Write(buf[:n]); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// shiftChunkOffsets moves the chunks by delta, the moov grows as offsets
+// switch to 64 bits so the caller checks its size again.
+func shiftChunkOffsets(moov *mp4io.Movie, delta int64) {
+	for _, track := range moov.Tracks {
+		table := track.Media.Info.Sample.ChunkOffset
+		for i := range table.Entries {
+			table.Entries[i] += uint64(delta)
+		}
+	}
+}
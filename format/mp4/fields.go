@@ -0,0 +1,35 @@
+package mp4
+
+import (
+	"github.com/deepch/vdk/av"
+	"github.com/deepch/vdk/codec/h264parser"
+	"github.com/deepch/vdk/format/mp4/mp4io"
+)
+
+func fieldAtoms(order av.FieldOrder) (atoms []mp4io.Atom) {
+	switch order {
+	case av.FieldOrderTopFirst:
+		atoms = append(atoms, &mp4io.FieldHandling{Fields: 2, Detail: mp4io.FIEL_TOP_FIRST})
+	case av.FieldOrderBottomFirst:
+		atoms = append(atoms, &mp4io.FieldHandling{Fields: 2, Detail: mp4io.FIEL_BOTTOM_FIRST})
+	}
+	return
+}
+
+func withFieldOrder(codec h264parser.CodecData, atoms []mp4io.Atom) h264parser.CodecData {
+	for _, atom := range atoms {
+		fiel, ok := mp4io.ParseUnknownAtom(atom).(*mp4io.FieldHandling)
+		if !ok {
+			continue
+		}
+		switch {
+		case fiel.Fields == 1:
+			codec.Fields = av.FieldOrderProgressive
+		case fiel.Detail == mp4io.FIEL_TOP_FIRST || fiel.Detail == 1:
+			codec.Fields = av.FieldOrderTopFirst
+		case fiel.Detail == mp4io.FIEL_BOTTOM_FIRST || fiel.Detail == 6:
+			codec.Fields = av.FieldOrderBottomFirst
+		}
+	}
+	return codec
+}
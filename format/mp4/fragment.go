@@ -0,0 +1,265 @@
+package mp4
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/deepch/vdk/av"
+	"github.com/deepch/vdk/format/fmp4/fmp4io"
+	"github.com/deepch/vdk/format/mp4/mp4io"
+	"github.com/deepch/vdk/utils/bits/pio"
+)
+
+// DefaultFragmentDuration is the fragment duration of audio only files.
+const DefaultFragmentDuration = time.Second
+
+// Fragment tells where a fragment of a fragmented file was written, to
+// index it for LL-HLS parts or byte-range requests.
+type Fragment struct {
+	Offset   int64 // from the start of the file, the init segment is before the first fragment
+	Size     int64
+	Start    time.Duration
+	Duration time.Duration
+	KeyFrame bool // starts with a video keyframe
+}
+
+type fragSample struct {
+	data  []byte
+	dur   uint32
+	cts   uint32
+	flags fmp4io.SampleFlags
+}
+
+// NewFragmentedMuxer returns a muxer writing a fragmented MP4: the ftyp and
+// moov of WriteHeader are the init segment, then a moof and mdat are
+// written and flushed at each video keyframe at least duration after the
+// start of the fragment, or every duration without video. A file cut short
+// by a crash is playable up to its last fragment, and the output can be
+// fed to MSE or served as CMAF segments as is. The writer needs no Seek.
+//
+// Chapters and SetTimeRange need the sample tables of a regular file and
+// are ignored.
+func NewFragmentedMuxer(w io.Writer, duration time.Duration) *Muxer {
+	return &Muxer{
+		bufw:             bufio.NewWriterSize(w, pio.RecommendBufioSize),
+		fragmented:       true,
+		fragmentDuration: duration,
+	}
+}
+
+func (self *Muxer) writeInitSegment() (err error) {
+	self.videoIdx = -1
+	moov := &mp4io.Movie{
+		Header: &mp4io.MovieHeader{
+			PreferredRate:   1,
+			PreferredVolume: 1,
+			Matrix:          [9]int32{0x10000, 0, 0, 0, 0x10000, 0, 0, 0, 0x40000000},
+			NextTrackId:     int32(len(self.streams) + 1),
+			TimeScale:       1000,
+		},
+		MovieExtend: &mp4io.MovieExtend{},
+	}
+	for i, stream := range self.streams {
+		if stream.Type().IsVideo() && self.videoIdx < 0 {
+			self.videoIdx = i
+		}
+		// samples are in the fragments, the tables stay empty
+		stream.sample.SampleToChunk.Entries = nil
+		stream.sample.SyncSample = nil
+		if err = stream.fillTrackAtom(); err != nil {
+			return
+		}
+		moov.Tracks = append(moov.Tracks, stream.trackAtom)
+		moov.MovieExtend.Tracks = append(moov.MovieExtend.Tracks, &mp4io.TrackExtend{
+			TrackId:              uint32(stream.trackAtom.Header.TrackId),
+			DefaultSampleDescIdx: 1,
+		})
+	}
+
+	ftyp := &mp4io.FileType{
+		MajorBrand:       0x69736f36,                       // iso6
+		CompatibleBrands: []uint32{0x69736f36, 0x6d703431}, // iso6 mp41
+	}
+	// CMAF tracks hold a single stream each
+	if len(self.streams) == 1 {
+		ftyp.CompatibleBrands = append(ftyp.CompatibleBrands, 0x636d6663) // cmfc
+	}
+	b := make([]byte, ftyp.Len()+moov.Len())
+	n := ftyp.Marshal(b)
+	moov.Marshal(b[n:])
+	if _, err = self.bufw.Write(b); err != nil {
+		return
+	}
+	self.wpos = int64(len(b))
+	err = self.bufw.Flush()
+	return
+}
+
+func (self *Muxer) writeFragmentPacket(stream *Stream, pkt av.Packet) (err error) {
+	if !self.fragStarted {
+		self.fragStarted = true
+		self.fragBase = pkt.Time
+		self.fragStart = pkt.Time
+	}
+	if stream.lastpkt == nil {
+		if pkt.Time > self.fragBase {
+			stream.fragEnd = stream.timeToTs(pkt.Time - self.fragBase)
+		}
+	} else if err = stream.addFragSample(*stream.lastpkt, stream.timeToTs(pkt.Time-self.fragBase)); err != nil {
+		return
+	}
+
+	var due bool
+	if self.videoIdx >= 0 {
+		due = int(pkt.Idx) == self.videoIdx && pkt.IsKeyFrame && pkt.Time-self.fragStart >= self.fragmentDuration
+	} else {
+		duration := self.fragmentDuration
+		if duration <= 0 {
+			duration = DefaultFragmentDuration
+		}
+		due = pkt.Time-self.fragStart >= duration
+	}
+	if due {
+		if err = self.writeFragment(); err != nil {
+			return
+		}
+		self.fragStart = pkt.Time
+	}
+	stream.lastpkt = &pkt
+	return
+}
+
+// addFragSample adds pkt, ending at end in the time scale of the stream, to
+// the fragment being gathered. Durations are taken from the times since the
+// first packet so they do not drift with rounding.
+func (self *Stream) addFragSample(pkt av.Packet, end int64) (err error) {
+	if end < self.fragEnd {
+		if self.muxer.NegativeTsMakeZero {
+			end = self.fragEnd
+		} else {
+			err = fmt.Errorf("mp4: stream#%d time=%v < lasttime=%v", pkt.Idx, pkt.Time, self.lastpkt.Time)
+			return
+		}
+	}
+	if len(self.fragSamples) == 0 {
+		self.fragTime = self.fragEnd
+	}
+	flags := fmp4io.SampleNoDependencies
+	if self.Type().IsVideo() && !pkt.IsKeyFrame {
+		flags = fmp4io.SampleNonKeyframe
+	}
+	self.fragDur = end - self.fragEnd
+	self.fragSamples = append(self.fragSamples, fragSample{
+		data:  pkt.Data,
+		dur:   uint32(self.fragDur),
+		cts:   uint32(self.timeToTs(pkt.CompositionTime)),
+		flags: flags,
+	})
+	self.fragEnd = end
+	return
+}
+
+// writeFragment writes the samples gathered as a moof with a traf by track
+// and a mdat, and flushes them.
+func (self *Muxer) writeFragment() (err error) {
+	self.fragSeq++
+	moof := &fmp4io.MovieFrag{Header: &fmp4io.MovieFragHeader{Seqnum: self.fragSeq}}
+	var streams []*Stream
+	for _, stream := range self.streams {
+		if len(stream.fragSamples) == 0 {
+			continue
+		}
+		run := &fmp4io.TrackFragRun{
+			Flags: fmp4io.TrackRunDataOffset | fmp4io.TrackRunSampleDuration | fmp4io.TrackRunSampleSize | fmp4io.TrackRunSampleFlags,
+		}
+		if stream.Type().IsVideo() {
+			run.Flags |= fmp4io.TrackRunSampleCTS
+		}
+		for _, sample := range stream.fragSamples {
+			run.Entries = append(run.Entries, fmp4io.TrackFragRunEntry{
+				Duration: sample.dur,
+				Size:     uint32(len(sample.data)),
+				Flags:    sample.flags,
+				CTS:      int32(sample.cts),
+			})
+		}
+		moof.Tracks = append(moof.Tracks, &fmp4io.TrackFrag{
+			Header: &fmp4io.TrackFragHeader{
+				Flags:   fmp4io.TrackFragDefaultBaseIsMOOF,
+				TrackID: uint32(stream.trackAtom.Header.TrackId),
+			},
+			DecodeTime: &fmp4io.TrackFragDecodeTime{Version: 1, Time: uint64(stream.fragTime)},
+			Run:        run,
+		})
+		streams = append(streams, stream)
+	}
+	if len(streams) == 0 {
+		self.fragSeq--
+		return
+	}
+
+	frag := Fragment{Offset: self.wpos}
+	offset := moof.Len() + 8
+	for i, stream := range streams {
+		moof.Tracks[i].Run.DataOffset = uint32(offset)
+		for _, sample := range stream.fragSamples {
+			offset += len(sample.data)
+		}
+	}
+	b := make([]byte, moof.Len()+8)
+	moof.Marshal(b)
+	pio.PutU32BE(b[len(b)-8:], uint32(offset-moof.Len()))
+	pio.PutU32BE(b[len(b)-4:], uint32(mp4io.MDAT))
+	if _, err = self.bufw.Write(b); err != nil {
+		return
+	}
+	for _, stream := range streams {
+		for _, sample := range stream.fragSamples {
+			if _, err = self.bufw.Write(sample.data); err != nil {
+				return
+			}
+		}
+	}
+	if err = self.bufw.Flush(); err != nil {
+		return
+	}
+	frag.Size = int64(offset)
+	self.wpos += frag.Size
+
+	// timed by the video track if it has samples
+	first := streams[0]
+	for _, stream := range streams {
+		if self.videoIdx >= 0 && self.streams[self.videoIdx] == stream {
+			first = stream
+			frag.KeyFrame = stream.fragSamples[0].flags == fmp4io.SampleNoDependencies
+		}
+	}
+	frag.Start = first.tsToTime(first.fragTime) + self.fragBase
+	frag.Duration = first.tsToTime(first.fragEnd - first.fragTime)
+	for _, stream := range streams {
+		stream.fragSamples = nil
+	}
+	if self.OnFragment != nil {
+		self.OnFragment(frag)
+	}
+	return
+}
+
+func (self *Muxer) writeFragmentTrailer() (err error) {
+	for _, stream := range self.streams {
+		if stream.lastpkt != nil {
+			pkt := *stream.lastpkt
+			dur := stream.timeToTs(stream.lastDuration(pkt))
+			if dur == 0 {
+				dur = stream.fragDur
+			}
+			if err = stream.addFragSample(pkt, stream.fragEnd+dur); err != nil {
+				return
+			}
+			stream.lastpkt = nil
+		}
+	}
+	return self.writeFragment()
+}
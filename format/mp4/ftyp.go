@@ -0,0 +1,65 @@
+package mp4
+
+import (
+	"github.com/deepch/vdk/av"
+	"github.com/deepch/vdk/format/mp4/mp4io"
+	"github.com/deepch/vdk/utils/bits/pio"
+)
+
+const (
+	brandISOM = 0x69736f6d
+	brandISO2 = 0x69736f32
+	brandISO4 = 0x69736f34
+	brandAVC1 = 0x61766331
+	brandAV01 = 0x61763031
+	brandMP41 = 0x6d703431
+)
+
+// isoBrandPos is where fileType puts iso2, a ctts of negative offsets
+// needs iso4 there, known once all packets are written.
+const isoBrandPos = 20
+
+func fileType(streams []*Stream) *mp4io.FileType {
+	ftyp := &mp4io.FileType{
+		MajorBrand:       brandISOM,
+		MinorVersion:     0x200,
+		CompatibleBrands: []uint32{brandISOM, brandISO2},
+	}
+	for _, stream := range streams {
+		switch stream.Type() {
+		case av.H264:
+			ftyp.CompatibleBrands = append(ftyp.CompatibleBrands, brandAVC1)
+		case av.AV1:
+			ftyp.CompatibleBrands = append(ftyp.CompatibleBrands, brandAV01)
+		}
+	}
+	ftyp.CompatibleBrands = append(ftyp.CompatibleBrands, brandMP41)
+	return ftyp
+}
+
+func (self *Muxer) writeFileType() (err error) {
+	ftyp := fileType(self.streams)
+	b := make([]byte, ftyp.Len())
+	ftyp.Marshal(b)
+	if _, err = self.w.Write(b); err != nil {
+		return
+	}
+	self.wpos += int64(len(b))
+	return
+}
+
+// writeISOBrand brands the file iso4 when a ctts has negative offsets.
+func (self *Muxer) writeISOBrand() (err error) {
+	for _, stream := range self.streams {
+		if ctts := stream.sample.CompositionOffset; ctts != nil && ctts.Version > 0 {
+			if _, err = self.w.Seek(isoBrandPos, 0); err != nil {
+				return
+			}
+			b := make([]byte, 4)
+			pio.PutU32BE(b, brandISO4)
+			_, err = self.w.Write(b)
+			return
+		}
+	}
+	return
+}
@@ -1,13 +1,14 @@
 package mp4
 
 import (
+	"fmt"
 	"io"
 
 	"github.com/deepch/vdk/av"
 	"github.com/deepch/vdk/av/avutil"
 )
 
-var CodecTypes = []av.CodecType{av.H264, av.AAC}
+var CodecTypes = []av.CodecType{av.H264, av.AAC, av.PCM, av.VP9, av.AV1, av.OPUS}
 
 func Handler(h *avutil.RegisterHandler) {
 	h.Ext = ".mp4"
@@ -21,7 +22,13 @@ func Handler(h *avutil.RegisterHandler) {
 	}
 
 	h.ReaderDemuxer = func(r io.Reader) av.Demuxer {
-		return NewDemuxer(r.(io.ReadSeeker))
+		// the moov may be at the end, a stream such as an HTTP body cannot
+		// be read, see avutil.Handlers.HTTPCache
+		rs, ok := r.(io.ReadSeeker)
+		if !ok {
+			return unseekableDemuxer{}
+		}
+		return NewDemuxer(rs)
 	}
 
 	h.WriterMuxer = func(w io.Writer) av.Muxer {
@@ -30,3 +37,15 @@ func Handler(h *avutil.RegisterHandler) {
 
 	h.CodecTypes = CodecTypes
 }
+
+var errUnseekable = fmt.Errorf("mp4: the source is not seekable")
+
+type unseekableDemuxer struct{}
+
+func (unseekableDemuxer) Streams() ([]av.CodecData, error) {
+	return nil, errUnseekable
+}
+
+func (unseekableDemuxer) ReadPacket() (av.Packet, error) {
+	return av.Packet{}, errUnseekable
+}
@@ -0,0 +1,306 @@
+package mp4
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/deepch/vdk/format/mp4/mp4io"
+	"github.com/deepch/vdk/utils/bits/pio"
+)
+
+// Metadata describes a movie from its headers and user data, without
+// reading samples.
+type Metadata struct {
+	CreateTime time.Time
+	ModifyTime time.Time
+	Duration   time.Duration
+	Tags       map[string]string // of moov/udta, by item name such as ©nam
+}
+
+// TrackInfo describes a track, including the ones not demuxed such as
+// chapter text.
+type TrackInfo struct {
+	TrackId     int
+	Stream      int    // index in Streams, -1 if not demuxed
+	Type        string // handler type such as vide, soun or text
+	HandlerName string
+	Language    string // ISO 639-2/T code, und if not set
+	CreateTime  time.Time
+	ModifyTime  time.Time
+	Name        string // track title, of trak/udta/name
+	Duration    time.Duration
+	Tags        map[string]string // of trak/udta
+}
+
+// TrackConfig labels a muxed track, empty fields keep the defaults of the
+// muxer.
+type TrackConfig struct {
+	Language    string // ISO 639-2/T code such as eng or fra
+	Name        string // track title shown by players
+	HandlerName string
+}
+
+// Validate checks the language code.
+func (self TrackConfig) Validate() (err error) {
+	if _, ok := packLanguage(self.Language); self.Language != "" && !ok {
+		err = fmt.Errorf("mp4: invalid language code %q", self.Language)
+	}
+	return
+}
+
+// Apply labels track, for muxers building their own tracks such as mp4f.
+func (self TrackConfig) Apply(track *mp4io.Track) {
+	if lang, ok := packLanguage(self.Language); ok && track.Media != nil && track.Media.Header != nil {
+		track.Media.Header.Language = lang
+	}
+	if self.HandlerName != "" && track.Media != nil && track.Media.Handler != nil {
+		track.Media.Handler.Name = handlerNameBytes(self.HandlerName)
+	}
+	if self.Name != "" {
+		udta := &mp4io.Dummy{Tag_: mp4io.StringToTag("udta"), Data: box("udta", box("name", []byte(self.Name)))}
+		for i, atom := range track.Unknowns {
+			if atom.Tag() == udta.Tag_ {
+				track.Unknowns = append(track.Unknowns[:i], track.Unknowns[i+1:]...)
+				break
+			}
+		}
+		track.Unknowns = append(track.Unknowns, udta)
+	}
+}
+
+// Metadata returns the creation times, duration and tags of the movie.
+func (self *Demuxer) Metadata() (md Metadata, err error) {
+	if err = self.probe(); err != nil {
+		return
+	}
+	if header := self.movieAtom.Header; header != nil {
+		md.CreateTime = movieTime(header.CreateTime)
+		md.ModifyTime = movieTime(header.ModifyTime)
+		if header.TimeScale > 0 {
+			md.Duration = tsToTime(int64(header.Duration), int64(header.TimeScale))
+		}
+	}
+	md.Tags = parseTags(self.movieAtom.Unknowns)
+	return
+}
+
+// TrackInfo returns the description of every track, in file order.
+func (self *Demuxer) TrackInfo() (tracks []TrackInfo, err error) {
+	if err = self.probe(); err != nil {
+		return
+	}
+	movieTimeScale := int64(0)
+	if self.movieAtom.Header != nil {
+		movieTimeScale = int64(self.movieAtom.Header.TimeScale)
+	}
+	for _, track := range self.movieAtom.Tracks {
+		info := TrackInfo{Stream: -1, Language: "und", Tags: parseTags(track.Unknowns)}
+		for i, stream := range self.streams {
+			if stream.trackAtom == track {
+				info.Stream = i
+			}
+		}
+		if header := track.Header; header != nil {
+			info.TrackId = int(header.TrackId)
+			info.CreateTime = movieTime(header.CreateTime)
+			info.ModifyTime = movieTime(header.ModifyTime)
+			if movieTimeScale > 0 {
+				info.Duration = tsToTime(int64(header.Duration), movieTimeScale)
+			}
+		}
+		if media := track.Media; media != nil {
+			if header := media.Header; header != nil {
+				info.Language = languageCode(header.Language)
+				if header.TimeScale > 0 && info.Duration == 0 {
+					info.Duration = tsToTime(int64(header.Duration), int64(header.TimeScale))
+				}
+			}
+			if handler := media.Handler; handler != nil {
+				info.Type = string(handler.SubType[:])
+				info.HandlerName = handlerName(handler.Name)
+			}
+		}
+		if info.Name = info.Tags["name"]; info.Name == "" {
+			info.Name = info.Tags["©nam"]
+		}
+		tracks = append(tracks, info)
+	}
+	return
+}
+
+var movieEpoch = time.Date(1904, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// movieTime is t, or the zero time if the header left it unset.
+func movieTime(t time.Time) time.Time {
+	if t.Equal(movieEpoch) {
+		return time.Time{}
+	}
+	return t
+}
+
+// packLanguage packs a three letter ISO 639-2/T code for a media header.
+func packLanguage(code string) (lang int16, ok bool) {
+	if len(code) != 3 {
+		return
+	}
+	for i := 0; i < 3; i++ {
+		c := code[i]
+		if c < 'a' || c > 'z' {
+			return
+		}
+		lang = lang<<5 | int16(c-0x60)
+	}
+	ok = true
+	return
+}
+
+// languageCode unpacks the ISO 639-2/T code of a media header, Macintosh
+// language codes are not mapped.
+func languageCode(lang int16) string {
+	if lang < 0x400 {
+		return "und"
+	}
+	return string([]byte{
+		byte(lang>>10&0x1f) + 0x60,
+		byte(lang>>5&0x1f) + 0x60,
+		byte(lang&0x1f) + 0x60,
+	})
+}
+
+// handlerNameBytes is the reserved fields and null terminated name
+// following the handler type.
+func handlerNameBytes(name string) []byte {
+	return append(append(make([]byte, 12), name...), 0)
+}
+
+// handlerName reads the null terminated name of mp4, or the counted string
+// of QuickTime, after the reserved fields. Older versions of this package
+// wrote the name over the reserved fields, which are only skipped if zero.
+func handlerName(b []byte) string {
+	if len(b) >= 12 && bytes.Count(b[:12], []byte{0}) == 12 {
+		b = b[12:]
+	}
+	if len(b) > 0 && int(b[0]) == len(b)-1 {
+		b = b[1:]
+	}
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
+
+// eachBox calls fn with the type and payload of the boxes in b.
+func eachBox(b []byte, fn func(tag string, payload []byte)) {
+	for len(b) >= 8 {
+		size, header := int64(pio.U32BE(b)), 8
+		switch size {
+		case 0:
+			size = int64(len(b))
+		case 1:
+			if len(b) < 16 {
+				return
+			}
+			size, header = int64(pio.U64BE(b[8:])), 16
+		}
+		if size < int64(header) || size > int64(len(b)) {
+			return
+		}
+		fn(latin1(b[4:8]), b[header:size])
+		b = b[size:]
+	}
+}
+
+// latin1 maps the bytes of a box type to runes, 0xa9 to © as in ©nam.
+func latin1(b []byte) string {
+	r := make([]rune, len(b))
+	for i, c := range b {
+		r[i] = rune(c)
+	}
+	return string(r)
+}
+
+// parseTags reads the iTunes items of udta/meta/ilst and the QuickTime
+// ©xxx strings of udta.
+func parseTags(atoms []mp4io.Atom) (tags map[string]string) {
+	tags = map[string]string{}
+	for _, atom := range atoms {
+		dummy, ok := atom.(*mp4io.Dummy)
+		if !ok || len(dummy.Data) < 8 {
+			continue
+		}
+		switch dummy.Tag_ {
+		case mp4io.StringToTag("udta"):
+			eachBox(dummy.Data[8:], func(tag string, payload []byte) {
+				if tag == "meta" {
+					parseMeta(payload, tags)
+				} else if tag == "name" {
+					tags[tag] = string(bytes.TrimRight(payload, "\x00"))
+				} else if strings.HasPrefix(tag, "©") && len(payload) >= 4 {
+					// counted string and language
+					if n := int(pio.U16BE(payload)); n <= len(payload)-4 {
+						tags[tag] = string(payload[4 : 4+n])
+					}
+				}
+			})
+		case mp4io.StringToTag("meta"):
+			parseMeta(dummy.Data[8:], tags)
+		}
+	}
+	return
+}
+
+func parseMeta(b []byte, tags map[string]string) {
+	if len(b) >= 4 && pio.U32BE(b) == 0 {
+		// version and flags, QuickTime meta boxes have none
+		b = b[4:]
+	}
+	eachBox(b, func(tag string, payload []byte) {
+		if tag != "ilst" {
+			return
+		}
+		eachBox(payload, func(item string, payload []byte) {
+			name := item
+			eachBox(payload, func(tag string, payload []byte) {
+				switch {
+				case tag == "name" && len(payload) >= 4:
+					name = string(payload[4:])
+				case tag == "data" && len(payload) >= 8:
+					if value, ok := itemValue(item, pio.U32BE(payload)&0xffffff, payload[8:]); ok {
+						tags[name] = value
+					}
+				}
+			})
+		})
+	})
+}
+
+// itemValue formats the data of an item of well known type, text,
+// integers and the track and disc numbers.
+func itemValue(item string, typ uint32, b []byte) (value string, ok bool) {
+	switch typ {
+	case 1: // UTF-8
+		return string(b), true
+	case 21: // signed integer
+		var v int64
+		switch len(b) {
+		case 1:
+			v = int64(int8(b[0]))
+		case 2:
+			v = int64(int16(pio.U16BE(b)))
+		case 4:
+			v = int64(int32(pio.U32BE(b)))
+		case 8:
+			v = int64(pio.U64BE(b))
+		default:
+			return
+		}
+		return fmt.Sprint(v), true
+	case 0:
+		if (item == "trkn" || item == "disk") && len(b) >= 6 {
+			return fmt.Sprintf("%d/%d", pio.U16BE(b[2:]), pio.U16BE(b[4:])), true
+		}
+	}
+	return
+}
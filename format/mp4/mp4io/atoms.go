@@ -123,10 +123,6 @@ func (self CompositionOffset) Tag() Tag {
 
 const STCO = Tag(0x7374636f)
 
-func (self ChunkOffset) Tag() Tag {
-	return STCO
-}
-
 const TRUN = Tag(0x7472756e)
 
 func (self TrackFragRun) Tag() Tag {
@@ -1677,7 +1673,7 @@ func (self *SampleTable) Unmarshal(b []byte, offset int) (n int, err error) {
 				}
 				self.SyncSample = atom
 			}
-		case STCO:
+		case STCO, CO64:
 			{
 				atom := &ChunkOffset{}
 				if _, err = atom.Unmarshal(b[n:n+size], offset+n); err != nil {
@@ -2864,73 +2860,6 @@ func (self SyncSample) Children() (r []Atom) {
 	return
 }
 
-type ChunkOffset struct {
-	Version uint8
-	Flags   uint32
-	Entries []uint32
-	AtomPos
-}
-
-func (self ChunkOffset) Marshal(b []byte) (n int) {
-	pio.PutU32BE(b[4:], uint32(STCO))
-	n += self.marshal(b[8:]) + 8
-	pio.PutU32BE(b[0:], uint32(n))
-	return
-}
-func (self ChunkOffset) marshal(b []byte) (n int) {
-	pio.PutU8(b[n:], self.Version)
-	n += 1
-	pio.PutU24BE(b[n:], self.Flags)
-	n += 3
-	pio.PutU32BE(b[n:], uint32(len(self.Entries)))
-	n += 4
-	for _, entry := range self.Entries {
-		pio.PutU32BE(b[n:], entry)
-		n += 4
-	}
-	return
-}
-func (self ChunkOffset) Len() (n int) {
-	n += 8
-	n += 1
-	n += 3
-	n += 4
-	n += 4 * len(self.Entries)
-	return
-}
-func (self *ChunkOffset) Unmarshal(b []byte, offset int) (n int, err error) {
-	(&self.AtomPos).setPos(offset, len(b))
-	n += 8
-	if len(b) < n+1 {
-		err = parseErr("Version", n+offset, err)
-		return
-	}
-	self.Version = pio.U8(b[n:])
-	n += 1
-	if len(b) < n+3 {
-		err = parseErr("Flags", n+offset, err)
-		return
-	}
-	self.Flags = pio.U24BE(b[n:])
-	n += 3
-	var _len_Entries uint32
-	_len_Entries = pio.U32BE(b[n:])
-	n += 4
-	self.Entries = make([]uint32, _len_Entries)
-	if len(b) < n+4*len(self.Entries) {
-		err = parseErr("uint32", n+offset, err)
-		return
-	}
-	for i := range self.Entries {
-		self.Entries[i] = pio.U32BE(b[n:])
-		n += 4
-	}
-	return
-}
-func (self ChunkOffset) Children() (r []Atom) {
-	return
-}
-
 type MovieFrag struct {
 	Header   *MovieFragHeader
 	Tracks   []*TrackFrag
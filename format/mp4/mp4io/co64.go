@@ -0,0 +1,119 @@
+package mp4io
+
+import (
+	"math"
+
+	"github.com/deepch/vdk/utils/bits/pio"
+)
+
+const CO64 = Tag(0x636f3634)
+
+// ChunkOffset is a stco box, or a co64 box once an offset does not fit in
+// 32 bits.
+type ChunkOffset struct {
+	Version uint8
+	Flags   uint32
+	Entries []uint64
+	AtomPos
+}
+
+func (self ChunkOffset) large() bool {
+	for _, entry := range self.Entries {
+		if entry > math.MaxUint32 {
+			return true
+		}
+	}
+	return false
+}
+
+func (self ChunkOffset) Tag() Tag {
+	if self.large() {
+		return CO64
+	}
+	return STCO
+}
+
+func (self ChunkOffset) Marshal(b []byte) (n int) {
+	large := self.large()
+	if large {
+		pio.PutU32BE(b[4:], uint32(CO64))
+	} else {
+		pio.PutU32BE(b[4:], uint32(STCO))
+	}
+	n += 8
+	pio.PutU8(b[n:], self.Version)
+	n += 1
+	pio.PutU24BE(b[n:], self.Flags)
+	n += 3
+	pio.PutU32BE(b[n:], uint32(len(self.Entries)))
+	n += 4
+	for _, entry := range self.Entries {
+		if large {
+			pio.PutU64BE(b[n:], entry)
+			n += 8
+		} else {
+			pio.PutU32BE(b[n:], uint32(entry))
+			n += 4
+		}
+	}
+	pio.PutU32BE(b[0:], uint32(n))
+	return
+}
+
+func (self ChunkOffset) Len() (n int) {
+	n += 8
+	n += 1
+	n += 3
+	n += 4
+	if self.large() {
+		n += 8 * len(self.Entries)
+	} else {
+		n += 4 * len(self.Entries)
+	}
+	return
+}
+
+func (self *ChunkOffset) Unmarshal(b []byte, offset int) (n int, err error) {
+	(&self.AtomPos).setPos(offset, len(b))
+	size := 4
+	if Tag(pio.U32BE(b[4:])) == CO64 {
+		size = 8
+	}
+	n += 8
+	if len(b) < n+1 {
+		err = parseErr("Version", n+offset, err)
+		return
+	}
+	self.Version = pio.U8(b[n:])
+	n += 1
+	if len(b) < n+3 {
+		err = parseErr("Flags", n+offset, err)
+		return
+	}
+	self.Flags = pio.U24BE(b[n:])
+	n += 3
+	if len(b) < n+4 {
+		err = parseErr("_len_Entries", n+offset, err)
+		return
+	}
+	count := int(pio.U32BE(b[n:]))
+	n += 4
+	if count < 0 || (len(b)-n)/size < count {
+		err = parseErr("Entries", n+offset, err)
+		return
+	}
+	self.Entries = make([]uint64, count)
+	for i := range self.Entries {
+		if size == 8 {
+			self.Entries[i] = pio.U64BE(b[n:])
+		} else {
+			self.Entries[i] = uint64(pio.U32BE(b[n:]))
+		}
+		n += size
+	}
+	return
+}
+
+func (self ChunkOffset) Children() (r []Atom) {
+	return
+}
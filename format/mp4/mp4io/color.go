@@ -0,0 +1,216 @@
+package mp4io
+
+import (
+	"github.com/deepch/vdk/utils/bits/pio"
+)
+
+const COLR = Tag(0x636f6c72)
+
+func (self ColourInfo) Tag() Tag {
+	return COLR
+}
+
+const MDCV = Tag(0x6d646376)
+
+func (self MasteringDisplayColour) Tag() Tag {
+	return MDCV
+}
+
+const CLLI = Tag(0x636c6c69)
+
+func (self ContentLightLevel) Tag() Tag {
+	return CLLI
+}
+
+const ColourTypeNCLX = Tag(0x6e636c78)
+
+// colr box, only the nclx colour type is interpreted.
+type ColourInfo struct {
+	ColourType Tag
+	Primaries  uint16
+	Transfer   uint16
+	Matrix     uint16
+	FullRange  bool
+	ICCProfile []byte
+	AtomPos
+}
+
+func (self ColourInfo) Marshal(b []byte) (n int) {
+	pio.PutU32BE(b[4:], uint32(COLR))
+	n += self.marshal(b[8:]) + 8
+	pio.PutU32BE(b[0:], uint32(n))
+	return
+}
+
+func (self ColourInfo) marshal(b []byte) (n int) {
+	pio.PutU32BE(b[n:], uint32(self.ColourType))
+	n += 4
+	if self.ColourType != ColourTypeNCLX {
+		copy(b[n:], self.ICCProfile)
+		n += len(self.ICCProfile)
+		return
+	}
+	pio.PutU16BE(b[n:], self.Primaries)
+	n += 2
+	pio.PutU16BE(b[n:], self.Transfer)
+	n += 2
+	pio.PutU16BE(b[n:], self.Matrix)
+	n += 2
+	if self.FullRange {
+		b[n] = 0x80
+	} else {
+		b[n] = 0
+	}
+	n += 1
+	return
+}
+
+func (self ColourInfo) Len() (n int) {
+	n += 8
+	n += 4
+	if self.ColourType != ColourTypeNCLX {
+		n += len(self.ICCProfile)
+		return
+	}
+	n += 7
+	return
+}
+
+func (self *ColourInfo) Unmarshal(b []byte, offset int) (n int, err error) {
+	(&self.AtomPos).setPos(offset, len(b))
+	n += 8
+	if len(b) < n+4 {
+		err = parseErr("ColourType", n+offset, err)
+		return
+	}
+	self.ColourType = Tag(pio.U32BE(b[n:]))
+	n += 4
+	if self.ColourType != ColourTypeNCLX {
+		self.ICCProfile = b[n:]
+		n += len(self.ICCProfile)
+		return
+	}
+	if len(b) < n+7 {
+		err = parseErr("nclx", n+offset, err)
+		return
+	}
+	self.Primaries = pio.U16BE(b[n:])
+	n += 2
+	self.Transfer = pio.U16BE(b[n:])
+	n += 2
+	self.Matrix = pio.U16BE(b[n:])
+	n += 2
+	self.FullRange = b[n]&0x80 != 0
+	n += 1
+	return
+}
+
+func (self ColourInfo) Children() (r []Atom) {
+	return
+}
+
+// mdcv box, SMPTE ST 2086 values in the same units as the H.264/H.265 SEI.
+type MasteringDisplayColour struct {
+	DisplayPrimaries [3][2]uint16
+	WhitePoint       [2]uint16
+	MaxLuminance     uint32
+	MinLuminance     uint32
+	AtomPos
+}
+
+func (self MasteringDisplayColour) Marshal(b []byte) (n int) {
+	pio.PutU32BE(b[4:], uint32(MDCV))
+	n += self.marshal(b[8:]) + 8
+	pio.PutU32BE(b[0:], uint32(n))
+	return
+}
+
+func (self MasteringDisplayColour) marshal(b []byte) (n int) {
+	for _, xy := range self.DisplayPrimaries {
+		pio.PutU16BE(b[n:], xy[0])
+		n += 2
+		pio.PutU16BE(b[n:], xy[1])
+		n += 2
+	}
+	pio.PutU16BE(b[n:], self.WhitePoint[0])
+	n += 2
+	pio.PutU16BE(b[n:], self.WhitePoint[1])
+	n += 2
+	pio.PutU32BE(b[n:], self.MaxLuminance)
+	n += 4
+	pio.PutU32BE(b[n:], self.MinLuminance)
+	n += 4
+	return
+}
+
+func (self MasteringDisplayColour) Len() (n int) {
+	return 8 + 24
+}
+
+func (self *MasteringDisplayColour) Unmarshal(b []byte, offset int) (n int, err error) {
+	(&self.AtomPos).setPos(offset, len(b))
+	n += 8
+	if len(b) < n+24 {
+		err = parseErr("mdcv", n+offset, err)
+		return
+	}
+	for i := range self.DisplayPrimaries {
+		self.DisplayPrimaries[i][0] = pio.U16BE(b[n:])
+		n += 2
+		self.DisplayPrimaries[i][1] = pio.U16BE(b[n:])
+		n += 2
+	}
+	self.WhitePoint[0] = pio.U16BE(b[n:])
+	n += 2
+	self.WhitePoint[1] = pio.U16BE(b[n:])
+	n += 2
+	self.MaxLuminance = pio.U32BE(b[n:])
+	n += 4
+	self.MinLuminance = pio.U32BE(b[n:])
+	n += 4
+	return
+}
+
+func (self MasteringDisplayColour) Children() (r []Atom) {
+	return
+}
+
+// clli box.
+type ContentLightLevel struct {
+	MaxCLL  uint16
+	MaxFALL uint16
+	AtomPos
+}
+
+func (self ContentLightLevel) Marshal(b []byte) (n int) {
+	pio.PutU32BE(b[4:], uint32(CLLI))
+	n += 8
+	pio.PutU16BE(b[n:], self.MaxCLL)
+	n += 2
+	pio.PutU16BE(b[n:], self.MaxFALL)
+	n += 2
+	pio.PutU32BE(b[0:], uint32(n))
+	return
+}
+
+func (self ContentLightLevel) Len() (n int) {
+	return 8 + 4
+}
+
+func (self *ContentLightLevel) Unmarshal(b []byte, offset int) (n int, err error) {
+	(&self.AtomPos).setPos(offset, len(b))
+	n += 8
+	if len(b) < n+4 {
+		err = parseErr("clli", n+offset, err)
+		return
+	}
+	self.MaxCLL = pio.U16BE(b[n:])
+	n += 2
+	self.MaxFALL = pio.U16BE(b[n:])
+	n += 2
+	return
+}
+
+func (self ContentLightLevel) Children() (r []Atom) {
+	return
+}
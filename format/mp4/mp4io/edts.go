@@ -0,0 +1,196 @@
+package mp4io
+
+import (
+	"github.com/deepch/vdk/utils/bits/pio"
+)
+
+const EDTS = Tag(0x65647473)
+
+const ELST = Tag(0x656c7374)
+
+func (self Edit) Tag() Tag {
+	return EDTS
+}
+
+func (self EditList) Tag() Tag {
+	return ELST
+}
+
+// edts box, only the edit list is kept.
+type Edit struct {
+	List *EditList
+	AtomPos
+}
+
+func (self Edit) Marshal(b []byte) (n int) {
+	pio.PutU32BE(b[4:], uint32(EDTS))
+	n += 8
+	if self.List != nil {
+		n += self.List.Marshal(b[n:])
+	}
+	pio.PutU32BE(b[0:], uint32(n))
+	return
+}
+
+func (self Edit) Len() (n int) {
+	n += 8
+	if self.List != nil {
+		n += self.List.Len()
+	}
+	return
+}
+
+func (self *Edit) Unmarshal(b []byte, offset int) (n int, err error) {
+	(&self.AtomPos).setPos(offset, len(b))
+	n += 8
+	for n+8 <= len(b) {
+		tag := Tag(pio.U32BE(b[n+4:]))
+		size := int(pio.U32BE(b[n:]))
+		if size < 8 || len(b) < n+size {
+			err = parseErr("TagSizeInvalid", n+offset, err)
+			return
+		}
+		if tag == ELST {
+			atom := &EditList{}
+			if _, err = atom.Unmarshal(b[n:n+size], offset+n); err != nil {
+				err = parseErr("elst", n+offset, err)
+				return
+			}
+			self.List = atom
+		}
+		n += size
+	}
+	return
+}
+
+func (self Edit) Children() (r []Atom) {
+	if self.List != nil {
+		r = append(r, self.List)
+	}
+	return
+}
+
+// EditListEntry maps SegmentDuration, in movie timescale, of the
+// presentation to the media starting at MediaTime, in media timescale. A
+// MediaTime of -1 is an empty edit which delays the track.
+type EditListEntry struct {
+	SegmentDuration   int64
+	MediaTime         int64
+	MediaRateInteger  int16
+	MediaRateFraction int16
+}
+
+type EditList struct {
+	Version uint8
+	Flags   uint32
+	Entries []EditListEntry
+	AtomPos
+}
+
+func (self EditList) Marshal(b []byte) (n int) {
+	pio.PutU32BE(b[4:], uint32(ELST))
+	n += 8
+	version := self.version()
+	pio.PutU8(b[n:], version)
+	n += 1
+	pio.PutU24BE(b[n:], self.Flags)
+	n += 3
+	pio.PutU32BE(b[n:], uint32(len(self.Entries)))
+	n += 4
+	for _, entry := range self.Entries {
+		if version == 1 {
+			pio.PutU64BE(b[n:], uint64(entry.SegmentDuration))
+			n += 8
+			pio.PutI64BE(b[n:], entry.MediaTime)
+			n += 8
+		} else {
+			pio.PutU32BE(b[n:], uint32(entry.SegmentDuration))
+			n += 4
+			pio.PutI32BE(b[n:], int32(entry.MediaTime))
+			n += 4
+		}
+		pio.PutI16BE(b[n:], entry.MediaRateInteger)
+		n += 2
+		pio.PutI16BE(b[n:], entry.MediaRateFraction)
+		n += 2
+	}
+	pio.PutU32BE(b[0:], uint32(n))
+	return
+}
+
+// version is raised to 1 when an entry does not fit 32 bits.
+func (self EditList) version() uint8 {
+	for _, entry := range self.Entries {
+		if entry.SegmentDuration > 0xffffffff || entry.MediaTime > 0x7fffffff || entry.MediaTime < -0x80000000 {
+			return 1
+		}
+	}
+	return self.Version
+}
+
+func (self EditList) Len() (n int) {
+	n += 8 + 8
+	if self.version() == 1 {
+		n += 20 * len(self.Entries)
+	} else {
+		n += 12 * len(self.Entries)
+	}
+	return
+}
+
+func (self *EditList) Unmarshal(b []byte, offset int) (n int, err error) {
+	(&self.AtomPos).setPos(offset, len(b))
+	n += 8
+	if len(b) < n+8 {
+		err = parseErr("elst", n+offset, err)
+		return
+	}
+	self.Version = pio.U8(b[n:])
+	n += 1
+	self.Flags = pio.U24BE(b[n:])
+	n += 3
+	count := int(pio.U32BE(b[n:]))
+	n += 4
+	size := 12
+	if self.Version == 1 {
+		size = 20
+	}
+	if count < 0 || len(b) < n+size*count {
+		err = parseErr("entries", n+offset, err)
+		return
+	}
+	self.Entries = make([]EditListEntry, count)
+	for i := range self.Entries {
+		entry := &self.Entries[i]
+		if self.Version == 1 {
+			entry.SegmentDuration = int64(pio.U64BE(b[n:]))
+			n += 8
+			entry.MediaTime = pio.I64BE(b[n:])
+			n += 8
+		} else {
+			entry.SegmentDuration = int64(pio.U32BE(b[n:]))
+			n += 4
+			entry.MediaTime = int64(pio.I32BE(b[n:]))
+			n += 4
+		}
+		entry.MediaRateInteger = pio.I16BE(b[n:])
+		n += 2
+		entry.MediaRateFraction = pio.I16BE(b[n:])
+		n += 2
+	}
+	return
+}
+
+func (self EditList) Children() (r []Atom) {
+	return
+}
+
+// FindEditList returns the edit list a track kept among its unknown boxes.
+func (self *Track) FindEditList() *EditList {
+	for _, atom := range self.Unknowns {
+		if edit, ok := ParseUnknownAtom(atom).(*Edit); ok {
+			return edit.List
+		}
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package mp4io
+
+import (
+	"github.com/deepch/vdk/utils/bits/pio"
+)
+
+const FIEL = Tag(0x6669656c)
+
+func (self FieldHandling) Tag() Tag {
+	return FIEL
+}
+
+const (
+	FIEL_TOP_FIRST    = 9
+	FIEL_BOTTOM_FIRST = 14
+)
+
+// fiel box, Fields is 1 for progressive and 2 for interlaced content.
+type FieldHandling struct {
+	Fields uint8
+	Detail uint8
+	AtomPos
+}
+
+func (self FieldHandling) Marshal(b []byte) (n int) {
+	pio.PutU32BE(b[4:], uint32(FIEL))
+	n += 8
+	b[n] = self.Fields
+	n++
+	b[n] = self.Detail
+	n++
+	pio.PutU32BE(b[0:], uint32(n))
+	return
+}
+
+func (self FieldHandling) Len() (n int) {
+	return 8 + 2
+}
+
+func (self *FieldHandling) Unmarshal(b []byte, offset int) (n int, err error) {
+	(&self.AtomPos).setPos(offset, len(b))
+	n += 8
+	if len(b) < n+2 {
+		err = parseErr("fiel", n+offset, err)
+		return
+	}
+	self.Fields = b[n]
+	n++
+	self.Detail = b[n]
+	n++
+	return
+}
+
+func (self FieldHandling) Children() (r []Atom) {
+	return
+}
@@ -219,12 +219,7 @@ func stss_SyncSample() {
 	slice(Entries, uint32)
 }
 
-func stco_ChunkOffset() {
-	uint8(Version)
-	uint24(Flags)
-	uint32(_len_Entries)
-	slice(Entries, uint32)
-}
+// stco and co64, ChunkOffset, are in co64.go
 
 func moof_MovieFrag() {
 	atom(Header, MovieFragHeader)
@@ -141,6 +141,40 @@ func (self *Dummy) Unmarshal(b []byte, offset int) (n int, err error) {
 	return
 }
 
+// ParseUnknownAtom decodes a box that a sample entry kept as Dummy into its
+// typed form when the tag is one handled outside the generated atoms.
+func ParseUnknownAtom(atom Atom) Atom {
+	dummy, ok := atom.(*Dummy)
+	if !ok {
+		return atom
+	}
+	var typed Atom
+	switch dummy.Tag_ {
+	case COLR:
+		typed = &ColourInfo{}
+	case MDCV:
+		typed = &MasteringDisplayColour{}
+	case CLLI:
+		typed = &ContentLightLevel{}
+	case FIEL:
+		typed = &FieldHandling{}
+	case EDTS:
+		typed = &Edit{}
+	case IPCM, FPCM:
+		typed = &PCMDesc{}
+	case OPUS:
+		typed = &OpusDesc{}
+	case VP09, AV01:
+		typed = &VideoDesc{}
+	default:
+		return atom
+	}
+	if _, err := typed.Unmarshal(dummy.Data, dummy.Offset); err != nil {
+		return atom
+	}
+	return typed
+}
+
 func StringToTag(tag string) Tag {
 	var b [4]byte
 	copy(b[:], []byte(tag))
@@ -376,6 +410,12 @@ func (self *ElemStreamDesc) parseDescHdr(b []byte, offset int) (n int, tag uint8
 	return
 }
 
+// maxAtomSize bounds the boxes read in memory, the moov of a day long
+// recording takes tens of megabytes.
+const maxAtomSize = 256 << 20
+
+// ReadFileAtoms reads the moov and moof boxes of a file and skips the
+// others, of 64 bits size or lasting to the end of the file as a mdat may.
 func ReadFileAtoms(r io.ReadSeeker) (atoms []Atom, err error) {
 	for {
 		offset, _ := r.Seek(0, 1)
@@ -386,12 +426,31 @@ func ReadFileAtoms(r io.ReadSeeker) (atoms []Atom, err error) {
 			}
 			return
 		}
-		size := pio.U32BE(taghdr[0:])
-		if size > 5242880 {
-			err = parseErr("len", 5242880, err)
-			return 
-		}
+		size := int64(pio.U32BE(taghdr[0:]))
 		tag := Tag(pio.U32BE(taghdr[4:]))
+		hdrlen := int64(8)
+		switch size {
+		case 0:
+			var end int64
+			if end, err = r.Seek(0, 2); err != nil {
+				return
+			}
+			size = end - offset
+			if _, err = r.Seek(offset+8, 0); err != nil {
+				return
+			}
+		case 1:
+			large := make([]byte, 8)
+			if _, err = io.ReadFull(r, large); err != nil {
+				return
+			}
+			size = int64(pio.U64BE(large))
+			hdrlen = 16
+		}
+		if size < hdrlen {
+			err = parseErr("len", int(offset), err)
+			return
+		}
 
 		var atom Atom
 		switch tag {
@@ -402,6 +461,10 @@ func ReadFileAtoms(r io.ReadSeeker) (atoms []Atom, err error) {
 		}
 
 		if atom != nil {
+			if size > maxAtomSize || hdrlen != 8 {
+				err = parseErr("len", int(offset), err)
+				return
+			}
 			b := make([]byte, int(size))
 			if _, err = io.ReadFull(r, b[8:]); err != nil {
 				return
@@ -414,7 +477,7 @@ func ReadFileAtoms(r io.ReadSeeker) (atoms []Atom, err error) {
 		} else {
 			dummy := &Dummy{Tag_: tag}
 			dummy.setPos(int(offset), int(size))
-			if _, err = r.Seek(int64(size)-8, 1); err != nil {
+			if _, err = r.Seek(offset+size, 0); err != nil {
 				return
 			}
 			atoms = append(atoms, dummy)
@@ -504,3 +567,9 @@ func (self *Track) GetElemStreamDesc() (esds *ElemStreamDesc) {
 	esds, _ = atom.(*ElemStreamDesc)
 	return
 }
+
+func (self *Track) GetHV1Conf() (conf *HV1Conf) {
+	atom := FindChildren(self, HVCC)
+	conf, _ = atom.(*HV1Conf)
+	return
+}
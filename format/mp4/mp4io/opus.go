@@ -0,0 +1,189 @@
+package mp4io
+
+import (
+	"github.com/deepch/vdk/utils/bits/pio"
+)
+
+// Opus sample entry of the Encapsulation of Opus in ISO Base Media File
+// Format.
+const OPUS = Tag(0x4f707573)
+
+const DOPS = Tag(0x644f7073)
+
+func (self OpusDesc) Tag() Tag {
+	return OPUS
+}
+
+func (self OpusConf) Tag() Tag {
+	return DOPS
+}
+
+// OpusDesc is an Opus audio sample entry, its sample rate always 48000.
+type OpusDesc struct {
+	DataRefIdx       int16
+	NumberOfChannels int16
+	SampleSize       int16
+	SampleRate       float64
+	Conf             *OpusConf
+	Unknowns         []Atom
+	AtomPos
+}
+
+func (self OpusDesc) Marshal(b []byte) (n int) {
+	pio.PutU32BE(b[4:], uint32(OPUS))
+	n += 8
+	n += 6
+	pio.PutI16BE(b[n:], self.DataRefIdx)
+	n += 2
+	n += 8
+	pio.PutI16BE(b[n:], self.NumberOfChannels)
+	n += 2
+	pio.PutI16BE(b[n:], self.SampleSize)
+	n += 2
+	n += 4
+	PutFixed32(b[n:], self.SampleRate)
+	n += 4
+	if self.Conf != nil {
+		n += self.Conf.Marshal(b[n:])
+	}
+	for _, atom := range self.Unknowns {
+		n += atom.Marshal(b[n:])
+	}
+	pio.PutU32BE(b[0:], uint32(n))
+	return
+}
+
+func (self OpusDesc) Len() (n int) {
+	n += 8 + 28
+	if self.Conf != nil {
+		n += self.Conf.Len()
+	}
+	for _, atom := range self.Unknowns {
+		n += atom.Len()
+	}
+	return
+}
+
+func (self *OpusDesc) Unmarshal(b []byte, offset int) (n int, err error) {
+	(&self.AtomPos).setPos(offset, len(b))
+	if len(b) < 8+28 {
+		err = parseErr("Opus", offset, err)
+		return
+	}
+	n += 8
+	n += 6
+	self.DataRefIdx = pio.I16BE(b[n:])
+	n += 2
+	n += 8
+	self.NumberOfChannels = pio.I16BE(b[n:])
+	n += 2
+	self.SampleSize = pio.I16BE(b[n:])
+	n += 2
+	n += 4
+	self.SampleRate = GetFixed32(b[n:])
+	n += 4
+	for n+8 <= len(b) {
+		tag := Tag(pio.U32BE(b[n+4:]))
+		size := int(pio.U32BE(b[n:]))
+		if size < 8 || len(b) < n+size {
+			err = parseErr("TagSizeInvalid", n+offset, err)
+			return
+		}
+		if tag == DOPS {
+			atom := &OpusConf{}
+			if _, err = atom.Unmarshal(b[n:n+size], offset+n); err != nil {
+				err = parseErr("dOps", n+offset, err)
+				return
+			}
+			self.Conf = atom
+		} else {
+			atom := &Dummy{Tag_: tag, Data: b[n : n+size]}
+			if _, err = atom.Unmarshal(b[n:n+size], offset+n); err != nil {
+				err = parseErr("", n+offset, err)
+				return
+			}
+			self.Unknowns = append(self.Unknowns, atom)
+		}
+		n += size
+	}
+	return
+}
+
+func (self OpusDesc) Children() (r []Atom) {
+	if self.Conf != nil {
+		r = append(r, self.Conf)
+	}
+	r = append(r, self.Unknowns...)
+	return
+}
+
+// OpusConf is the dOps box, the Opus identification header in big endian
+// and without channel mapping table, so for mono and stereo only.
+type OpusConf struct {
+	Version              uint8
+	OutputChannelCount   uint8
+	PreSkip              uint16
+	InputSampleRate      uint32
+	OutputGain           int16
+	ChannelMappingFamily uint8
+	AtomPos
+}
+
+func (self OpusConf) Marshal(b []byte) (n int) {
+	pio.PutU32BE(b[4:], uint32(DOPS))
+	n += 8
+	pio.PutU8(b[n:], self.Version)
+	n += 1
+	pio.PutU8(b[n:], self.OutputChannelCount)
+	n += 1
+	pio.PutU16BE(b[n:], self.PreSkip)
+	n += 2
+	pio.PutU32BE(b[n:], self.InputSampleRate)
+	n += 4
+	pio.PutI16BE(b[n:], self.OutputGain)
+	n += 2
+	pio.PutU8(b[n:], self.ChannelMappingFamily)
+	n += 1
+	pio.PutU32BE(b[0:], uint32(n))
+	return
+}
+
+func (self OpusConf) Len() (n int) {
+	return 8 + 11
+}
+
+func (self *OpusConf) Unmarshal(b []byte, offset int) (n int, err error) {
+	(&self.AtomPos).setPos(offset, len(b))
+	n += 8
+	if len(b) < n+11 {
+		err = parseErr("dOps", n+offset, err)
+		return
+	}
+	self.Version = pio.U8(b[n:])
+	n += 1
+	self.OutputChannelCount = pio.U8(b[n:])
+	n += 1
+	self.PreSkip = pio.U16BE(b[n:])
+	n += 2
+	self.InputSampleRate = pio.U32BE(b[n:])
+	n += 4
+	self.OutputGain = pio.I16BE(b[n:])
+	n += 2
+	self.ChannelMappingFamily = pio.U8(b[n:])
+	n += 1
+	return
+}
+
+func (self OpusConf) Children() (r []Atom) {
+	return
+}
+
+// FindOpusDesc returns the Opus sample entry kept among the unknown ones.
+func (self *SampleDesc) FindOpusDesc() *OpusDesc {
+	for _, atom := range self.Unknowns {
+		if desc, ok := ParseUnknownAtom(atom).(*OpusDesc); ok {
+			return desc
+		}
+	}
+	return nil
+}
@@ -0,0 +1,185 @@
+package mp4io
+
+import (
+	"github.com/deepch/vdk/utils/bits/pio"
+)
+
+// ISO/IEC 23003-5 sample entries of integer and floating point PCM.
+const IPCM = Tag(0x6970636d)
+
+const FPCM = Tag(0x6670636d)
+
+const PCMC = Tag(0x70636d43)
+
+func (self PCMDesc) Tag() Tag {
+	return self.Tag_
+}
+
+func (self PCMConf) Tag() Tag {
+	return PCMC
+}
+
+// PCMDesc is an ipcm or fpcm audio sample entry.
+type PCMDesc struct {
+	Tag_             Tag
+	DataRefIdx       int16
+	NumberOfChannels int16
+	SampleSize       int16
+	SampleRate       float64
+	Conf             *PCMConf
+	Unknowns         []Atom
+	AtomPos
+}
+
+func (self PCMDesc) Marshal(b []byte) (n int) {
+	pio.PutU32BE(b[4:], uint32(self.Tag_))
+	n += 8
+	n += 6
+	pio.PutI16BE(b[n:], self.DataRefIdx)
+	n += 2
+	n += 8
+	pio.PutI16BE(b[n:], self.NumberOfChannels)
+	n += 2
+	pio.PutI16BE(b[n:], self.SampleSize)
+	n += 2
+	n += 4
+	PutFixed32(b[n:], self.SampleRate)
+	n += 4
+	if self.Conf != nil {
+		n += self.Conf.Marshal(b[n:])
+	}
+	for _, atom := range self.Unknowns {
+		n += atom.Marshal(b[n:])
+	}
+	pio.PutU32BE(b[0:], uint32(n))
+	return
+}
+
+func (self PCMDesc) Len() (n int) {
+	n += 8 + 28
+	if self.Conf != nil {
+		n += self.Conf.Len()
+	}
+	for _, atom := range self.Unknowns {
+		n += atom.Len()
+	}
+	return
+}
+
+func (self *PCMDesc) Unmarshal(b []byte, offset int) (n int, err error) {
+	(&self.AtomPos).setPos(offset, len(b))
+	if len(b) < 8+28 {
+		err = parseErr("pcm", offset, err)
+		return
+	}
+	self.Tag_ = Tag(pio.U32BE(b[4:]))
+	n += 8
+	n += 6
+	self.DataRefIdx = pio.I16BE(b[n:])
+	n += 2
+	n += 8
+	self.NumberOfChannels = pio.I16BE(b[n:])
+	n += 2
+	self.SampleSize = pio.I16BE(b[n:])
+	n += 2
+	n += 4
+	self.SampleRate = GetFixed32(b[n:])
+	n += 4
+	for n+8 <= len(b) {
+		tag := Tag(pio.U32BE(b[n+4:]))
+		size := int(pio.U32BE(b[n:]))
+		if size < 8 || len(b) < n+size {
+			err = parseErr("TagSizeInvalid", n+offset, err)
+			return
+		}
+		if tag == PCMC {
+			atom := &PCMConf{}
+			if _, err = atom.Unmarshal(b[n:n+size], offset+n); err != nil {
+				err = parseErr("pcmC", n+offset, err)
+				return
+			}
+			self.Conf = atom
+		} else {
+			atom := &Dummy{Tag_: tag, Data: b[n : n+size]}
+			if _, err = atom.Unmarshal(b[n:n+size], offset+n); err != nil {
+				err = parseErr("", n+offset, err)
+				return
+			}
+			self.Unknowns = append(self.Unknowns, atom)
+		}
+		n += size
+	}
+	return
+}
+
+func (self PCMDesc) Children() (r []Atom) {
+	if self.Conf != nil {
+		r = append(r, self.Conf)
+	}
+	r = append(r, self.Unknowns...)
+	return
+}
+
+// PCMConf is the pcmC box, bit 0 of FormatFlags tells little endian.
+type PCMConf struct {
+	Version     uint8
+	Flags       uint32
+	FormatFlags uint8
+	SampleSize  uint8
+	AtomPos
+}
+
+func (self PCMConf) LittleEndian() bool {
+	return self.FormatFlags&1 != 0
+}
+
+func (self PCMConf) Marshal(b []byte) (n int) {
+	pio.PutU32BE(b[4:], uint32(PCMC))
+	n += 8
+	pio.PutU8(b[n:], self.Version)
+	n += 1
+	pio.PutU24BE(b[n:], self.Flags)
+	n += 3
+	pio.PutU8(b[n:], self.FormatFlags)
+	n += 1
+	pio.PutU8(b[n:], self.SampleSize)
+	n += 1
+	pio.PutU32BE(b[0:], uint32(n))
+	return
+}
+
+func (self PCMConf) Len() (n int) {
+	return 8 + 6
+}
+
+func (self *PCMConf) Unmarshal(b []byte, offset int) (n int, err error) {
+	(&self.AtomPos).setPos(offset, len(b))
+	n += 8
+	if len(b) < n+6 {
+		err = parseErr("pcmC", n+offset, err)
+		return
+	}
+	self.Version = pio.U8(b[n:])
+	n += 1
+	self.Flags = pio.U24BE(b[n:])
+	n += 3
+	self.FormatFlags = pio.U8(b[n:])
+	n += 1
+	self.SampleSize = pio.U8(b[n:])
+	n += 1
+	return
+}
+
+func (self PCMConf) Children() (r []Atom) {
+	return
+}
+
+// FindPCMDesc returns the PCM sample entry kept among the unknown ones.
+func (self *SampleDesc) FindPCMDesc() *PCMDesc {
+	for _, atom := range self.Unknowns {
+		if desc, ok := ParseUnknownAtom(atom).(*PCMDesc); ok {
+			return desc
+		}
+	}
+	return nil
+}
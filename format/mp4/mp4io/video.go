@@ -0,0 +1,157 @@
+package mp4io
+
+import (
+	"github.com/deepch/vdk/utils/bits/pio"
+)
+
+// Sample entries of VP9 and AV1, their configuration boxes kept among
+// Unknowns, see ConfData.
+const VP09 = Tag(0x76703039)
+
+const VPCC = Tag(0x76706343)
+
+const AV01 = Tag(0x61763031)
+
+const AV1C = Tag(0x61763143)
+
+func (self VideoDesc) Tag() Tag {
+	return self.Tag_
+}
+
+// VideoDesc is a visual sample entry of the codecs with no sample entry
+// of their own.
+type VideoDesc struct {
+	Tag_                 Tag
+	DataRefIdx           int16
+	Width                int16
+	Height               int16
+	HorizontalResolution float64
+	VorizontalResolution float64
+	FrameCount           int16
+	CompressorName       [32]byte
+	Depth                int16
+	ColorTableId         int16
+	Unknowns             []Atom
+	AtomPos
+}
+
+func (self VideoDesc) Marshal(b []byte) (n int) {
+	pio.PutU32BE(b[4:], uint32(self.Tag_))
+	n += 8
+	n += 6
+	pio.PutI16BE(b[n:], self.DataRefIdx)
+	n += 2
+	n += 16
+	pio.PutI16BE(b[n:], self.Width)
+	n += 2
+	pio.PutI16BE(b[n:], self.Height)
+	n += 2
+	PutFixed32(b[n:], self.HorizontalResolution)
+	n += 4
+	PutFixed32(b[n:], self.VorizontalResolution)
+	n += 4
+	n += 4
+	pio.PutI16BE(b[n:], self.FrameCount)
+	n += 2
+	copy(b[n:], self.CompressorName[:])
+	n += len(self.CompressorName[:])
+	pio.PutI16BE(b[n:], self.Depth)
+	n += 2
+	pio.PutI16BE(b[n:], self.ColorTableId)
+	n += 2
+	for _, atom := range self.Unknowns {
+		n += atom.Marshal(b[n:])
+	}
+	pio.PutU32BE(b[0:], uint32(n))
+	return
+}
+
+func (self VideoDesc) Len() (n int) {
+	n += 8 + 78
+	for _, atom := range self.Unknowns {
+		n += atom.Len()
+	}
+	return
+}
+
+func (self *VideoDesc) Unmarshal(b []byte, offset int) (n int, err error) {
+	(&self.AtomPos).setPos(offset, len(b))
+	if len(b) < 8+78 {
+		err = parseErr("VideoDesc", offset, err)
+		return
+	}
+	self.Tag_ = Tag(pio.U32BE(b[4:]))
+	n += 8
+	n += 6
+	self.DataRefIdx = pio.I16BE(b[n:])
+	n += 2
+	n += 16
+	self.Width = pio.I16BE(b[n:])
+	n += 2
+	self.Height = pio.I16BE(b[n:])
+	n += 2
+	self.HorizontalResolution = GetFixed32(b[n:])
+	n += 4
+	self.VorizontalResolution = GetFixed32(b[n:])
+	n += 4
+	n += 4
+	self.FrameCount = pio.I16BE(b[n:])
+	n += 2
+	copy(self.CompressorName[:], b[n:])
+	n += len(self.CompressorName[:])
+	self.Depth = pio.I16BE(b[n:])
+	n += 2
+	self.ColorTableId = pio.I16BE(b[n:])
+	n += 2
+	for n+8 <= len(b) {
+		tag := Tag(pio.U32BE(b[n+4:]))
+		size := int(pio.U32BE(b[n:]))
+		if size < 8 || len(b) < n+size {
+			err = parseErr("TagSizeInvalid", n+offset, err)
+			return
+		}
+		atom := &Dummy{Tag_: tag, Data: b[n : n+size]}
+		if _, err = atom.Unmarshal(b[n:n+size], offset+n); err != nil {
+			err = parseErr("", n+offset, err)
+			return
+		}
+		self.Unknowns = append(self.Unknowns, atom)
+		n += size
+	}
+	return
+}
+
+func (self VideoDesc) Children() (r []Atom) {
+	return self.Unknowns
+}
+
+// ConfData returns the content of the box tag in the sample entry, after
+// its header.
+func (self VideoDesc) ConfData(tag Tag) []byte {
+	for _, atom := range self.Unknowns {
+		if dummy, ok := atom.(*Dummy); ok && dummy.Tag_ == tag && len(dummy.Data) >= 8 {
+			return dummy.Data[8:]
+		}
+	}
+	return nil
+}
+
+// NewDummy makes the box tag holding data.
+func NewDummy(tag Tag, data []byte) *Dummy {
+	b := make([]byte, 8+len(data))
+	pio.PutU32BE(b, uint32(len(b)))
+	pio.PutU32BE(b[4:], uint32(tag))
+	copy(b[8:], data)
+	return &Dummy{Tag_: tag, Data: b}
+}
+
+// FindVideoDesc returns the visual sample entry tag kept among the unknown
+// ones.
+func (self *SampleDesc) FindVideoDesc(tag Tag) *VideoDesc {
+	for _, atom := range self.Unknowns {
+		if desc, ok := ParseUnknownAtom(atom).(*VideoDesc); ok && desc.Tag_ == tag {
+			return desc
+		}
+	}
+	return nil
+}
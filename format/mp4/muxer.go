@@ -5,11 +5,13 @@ import (
 	"fmt"
 	"github.com/deepch/vdk/av"
 	"github.com/deepch/vdk/codec/aacparser"
+	"github.com/deepch/vdk/codec/av1parser"
 	"github.com/deepch/vdk/codec/h264parser"
 	"github.com/deepch/vdk/codec/h265parser"
 	"github.com/deepch/vdk/format/mp4/mp4io"
 	"github.com/deepch/vdk/utils/bits/pio"
 	"io"
+	"math"
 	"time"
 )
 
@@ -19,6 +21,28 @@ type Muxer struct {
 	wpos               int64
 	streams            []*Stream
 	NegativeTsMakeZero bool
+
+	timeRange          bool
+	startTime, endTime time.Duration
+
+	chapters     []Chapter
+	trackConfigs map[int]TrackConfig
+
+	fastStart bool
+	reserve   int64
+	moovPos   int64
+	mdatPos   int64
+
+	// OnFragment is called once a fragment of a fragmented file is written.
+	OnFragment func(frag Fragment)
+
+	fragmented       bool
+	fragmentDuration time.Duration
+	videoIdx         int
+	fragStarted      bool
+	fragBase         time.Duration
+	fragStart        time.Duration
+	fragSeq          uint32
 }
 
 func NewMuxer(w io.WriteSeeker) *Muxer {
@@ -28,15 +52,69 @@ func NewMuxer(w io.WriteSeeker) *Muxer {
 	}
 }
 
+// SetTimeRange limits the presentation to packet times from start to end,
+// with edit lists. Tracks starting later are delayed and audio before start
+// or past end is trimmed on playback, so a segmenter can cut on a video
+// keyframe, write the audio frame straddling the cut to both segments and
+// have them play back to back without a gap. A zero end keeps everything
+// after start.
+func (self *Muxer) SetTimeRange(start, end time.Duration) {
+	self.timeRange = true
+	self.startTime, self.endTime = start, end
+}
+
+// SetTrackConfig sets the language and names of the track of stream idx.
+func (self *Muxer) SetTrackConfig(idx int, config TrackConfig) (err error) {
+	if err = config.Validate(); err != nil {
+		return
+	}
+	if self.trackConfigs == nil {
+		self.trackConfigs = map[int]TrackConfig{}
+	}
+	self.trackConfigs[idx] = config
+	return
+}
+
+// firstTime is the time of the first packet of the movie, tracks starting
+// after it are delayed with an edit list.
+func (self *Muxer) firstTime() (tm time.Duration) {
+	first := true
+	for _, stream := range self.streams {
+		if stream.lastpkt == nil && stream.sampleIndex == 0 {
+			continue
+		}
+		if first || stream.firstTime < tm {
+			tm = stream.firstTime
+			first = false
+		}
+	}
+	return
+}
+
 func (self *Muxer) newStream(codec av.CodecData) (err error) {
 	switch codec.Type() {
 	case av.H264, av.H265, av.AAC:
 
+	case av.VP9, av.AV1:
+		if _, err = videoDesc(codec); err != nil {
+			return
+		}
+
+	case av.OPUS:
+		if _, err = opusChannels(codec); err != nil {
+			return
+		}
+
+	case av.PCM:
+		if _, err = pcmDesc(codec.(av.AudioCodecData)); err != nil {
+			return
+		}
+
 	default:
 		err = fmt.Errorf("mp4: codec type=%v is not supported", codec.Type())
 		return
 	}
-	stream := &Stream{CodecData: codec}
+	stream := &Stream{CodecData: codec, idx: len(self.streams)}
 
 	stream.sample = &mp4io.SampleTable{
 		SampleDesc:   &mp4io.SampleDesc{},
@@ -85,9 +163,14 @@ func (self *Muxer) newStream(codec av.CodecData) (err error) {
 		stream.sample.SyncSample = &mp4io.SyncSample{}
 	case av.H265:
 		stream.sample.SyncSample = &mp4io.SyncSample{}
+	case av.VP9, av.AV1:
+		stream.sample.SyncSample = &mp4io.SyncSample{}
 	}
 
 	stream.timeScale = 90000
+	if codec.Type() == av.OPUS {
+		stream.timeScale = 48000
+	}
 	stream.muxer = self
 	self.streams = append(self.streams, stream)
 
@@ -110,16 +193,18 @@ func (self *Stream) fillTrackAtom() (err error) {
 			Depth:                24,
 			ColorTableId:         -1,
 			Conf:                 &mp4io.AVC1Conf{Data: codec.AVCDecoderConfRecordBytes()},
+			Unknowns:             append(colorAtoms(codec.ColorInfo()), fieldAtoms(codec.FieldOrder())...),
 		}
 		self.trackAtom.Media.Handler = &mp4io.HandlerRefer{
 			SubType: [4]byte{'v', 'i', 'd', 'e'},
-			Name:    []byte("Video Media Handler"),
+			Name:    handlerNameBytes("Video Media Handler"),
 		}
 		self.trackAtom.Media.Info.Video = &mp4io.VideoMediaInfo{
 			Flags: 0x000001,
 		}
 		self.trackAtom.Header.TrackWidth = float64(width)
 		self.trackAtom.Header.TrackHeight = float64(height)
+		self.trackAtom.Header.Matrix = rotationMatrix(codec.DisplayRotation(), width, height)
 	} else if self.Type() == av.H265 {
 		codec := self.CodecData.(h265parser.CodecData)
 		width, height := codec.Width(), codec.Height()
@@ -133,16 +218,34 @@ func (self *Stream) fillTrackAtom() (err error) {
 			Depth:                24,
 			ColorTableId:         -1,
 			Conf:                 &mp4io.HV1Conf{Data: codec.AVCDecoderConfRecordBytes()},
+			Unknowns:             colorAtoms(codec.ColorInfo()),
 		}
 		self.trackAtom.Media.Handler = &mp4io.HandlerRefer{
 			SubType: [4]byte{'v', 'i', 'd', 'e'},
-			Name:    []byte("Video Media Handler"),
+			Name:    handlerNameBytes("Video Media Handler"),
 		}
 		self.trackAtom.Media.Info.Video = &mp4io.VideoMediaInfo{
 			Flags: 0x000001,
 		}
 		self.trackAtom.Header.TrackWidth = float64(width)
 		self.trackAtom.Header.TrackHeight = float64(height)
+		self.trackAtom.Header.Matrix = rotationMatrix(codec.DisplayRotation(), width, height)
+	} else if self.Type() == av.VP9 || self.Type() == av.AV1 {
+		codec := self.CodecData.(av.VideoCodecData)
+		var desc *mp4io.VideoDesc
+		if desc, err = videoDesc(codec); err != nil {
+			return
+		}
+		self.sample.SampleDesc.Unknowns = []mp4io.Atom{desc}
+		self.trackAtom.Media.Handler = &mp4io.HandlerRefer{
+			SubType: [4]byte{'v', 'i', 'd', 'e'},
+			Name:    handlerNameBytes("Video Media Handler"),
+		}
+		self.trackAtom.Media.Info.Video = &mp4io.VideoMediaInfo{
+			Flags: 0x000001,
+		}
+		self.trackAtom.Header.TrackWidth = float64(codec.Width())
+		self.trackAtom.Header.TrackHeight = float64(codec.Height())
 	} else if self.Type() == av.AAC {
 		codec := self.CodecData.(aacparser.CodecData)
 		self.sample.SampleDesc.MP4ADesc = &mp4io.MP4ADesc{
@@ -158,14 +261,46 @@ func (self *Stream) fillTrackAtom() (err error) {
 		self.trackAtom.Header.AlternateGroup = 1
 		self.trackAtom.Media.Handler = &mp4io.HandlerRefer{
 			SubType: [4]byte{'s', 'o', 'u', 'n'},
-			Name:    []byte("Sound Handler"),
+			Name:    handlerNameBytes("Sound Handler"),
+		}
+		self.trackAtom.Media.Info.Sound = &mp4io.SoundMediaInfo{}
+
+	} else if self.Type() == av.OPUS {
+		var desc *mp4io.OpusDesc
+		if desc, err = opusDesc(self.CodecData); err != nil {
+			return
+		}
+		self.sample.SampleDesc.Unknowns = []mp4io.Atom{desc}
+		self.trackAtom.Header.Volume = 1
+		self.trackAtom.Header.AlternateGroup = 1
+		self.trackAtom.Media.Handler = &mp4io.HandlerRefer{
+			SubType: [4]byte{'s', 'o', 'u', 'n'},
+			Name:    handlerNameBytes("Sound Handler"),
+		}
+		self.trackAtom.Media.Info.Sound = &mp4io.SoundMediaInfo{}
+
+	} else if self.Type() == av.PCM {
+		var desc *mp4io.PCMDesc
+		if desc, err = pcmDesc(self.CodecData.(av.AudioCodecData)); err != nil {
+			return
+		}
+		self.sample.SampleDesc.Unknowns = []mp4io.Atom{desc}
+		self.trackAtom.Header.Volume = 1
+		self.trackAtom.Header.AlternateGroup = 1
+		self.trackAtom.Media.Handler = &mp4io.HandlerRefer{
+			SubType: [4]byte{'s', 'o', 'u', 'n'},
+			Name:    handlerNameBytes("Sound Handler"),
 		}
 		self.trackAtom.Media.Info.Sound = &mp4io.SoundMediaInfo{}
 
 	} else {
 		err = fmt.Errorf("mp4: codec type=%d invalid", self.Type())
+		return
 	}
 
+	if config, ok := self.muxer.trackConfigs[self.idx]; ok {
+		config.Apply(self.trackAtom)
+	}
 	return
 }
 
@@ -176,13 +311,29 @@ func (self *Muxer) WriteHeader(streams []av.CodecData) (err error) {
 			return
 		}
 	}
+	if self.fragmented {
+		return self.writeInitSegment()
+	}
 
-	taghdr := make([]byte, 8)
-	pio.PutU32BE(taghdr[4:], uint32(mp4io.MDAT))
+	if err = self.writeFileType(); err != nil {
+		return
+	}
+	self.moovPos = self.wpos
+	if self.fastStart {
+		if err = self.writeReserve(); err != nil {
+			return
+		}
+	}
+	// the wide box becomes the 64 bits size of a mdat past 4GB
+	taghdr := make([]byte, 16)
+	pio.PutU32BE(taghdr, 8)
+	copy(taghdr[4:], "wide")
+	pio.PutU32BE(taghdr[12:], uint32(mp4io.MDAT))
 	if _, err = self.w.Write(taghdr); err != nil {
 		return
 	}
-	self.wpos += 8
+	self.mdatPos = self.wpos + 8
+	self.wpos += 16
 
 	for _, stream := range self.streams {
 		if stream.Type().IsVideo() {
@@ -194,6 +345,17 @@ func (self *Muxer) WriteHeader(streams []av.CodecData) (err error) {
 
 func (self *Muxer) WritePacket(pkt av.Packet) (err error) {
 	stream := self.streams[pkt.Idx]
+	stream.scanSEI(pkt)
+	if stream.Type() == av.AV1 {
+		// samples are temporal units without temporal delimiters
+		pkt.Data = av1parser.RemoveTemporalDelimiters(pkt.Data)
+	}
+	if self.fragmented {
+		return self.writeFragmentPacket(stream, pkt)
+	}
+	if stream.lastpkt == nil && stream.sampleIndex == 0 {
+		stream.firstTime = pkt.Time
+	}
 	if stream.lastpkt != nil {
 		if err = stream.writePacket(*stream.lastpkt, pkt.Time-stream.lastpkt.Time); err != nil {
 			return
@@ -203,6 +365,32 @@ func (self *Muxer) WritePacket(pkt av.Packet) (err error) {
 	return
 }
 
+// scanSEI picks up HDR and display orientation SEI from the first video
+// keyframe, they are only known once the stream is running but go into the
+// sample entry and track header.
+func (self *Stream) scanSEI(pkt av.Packet) {
+	if !pkt.IsKeyFrame || self.seiScanned {
+		return
+	}
+	switch codec := self.CodecData.(type) {
+	case h264parser.CodecData:
+		nalus, _ := h264parser.SplitNALUs(pkt.Data)
+		for _, nalu := range nalus {
+			if len(nalu) > 0 && nalu[0]&0x1f == h264parser.NALU_SEI && codec.UpdateFromSEI(nalu) {
+				self.CodecData = codec
+			}
+		}
+	case h265parser.CodecData:
+		nalus, _ := h265parser.SplitNALUs(pkt.Data)
+		for _, nalu := range nalus {
+			if len(nalu) > 0 && (nalu[0]>>1)&0x3f == h265parser.NAL_UNIT_PREFIX_SEI && codec.UpdateFromSEI(nalu) {
+				self.CodecData = codec
+			}
+		}
+	}
+	self.seiScanned = true
+}
+
 func (self *Stream) writePacket(pkt av.Packet, rawdur time.Duration) (err error) {
 	if rawdur < 0 {
 		if self.muxer.NegativeTsMakeZero {
@@ -221,7 +409,11 @@ func (self *Stream) writePacket(pkt av.Packet, rawdur time.Duration) (err error)
 		self.sample.SyncSample.Entries = append(self.sample.SyncSample.Entries, uint32(self.sampleIndex+1))
 	}
 
-	duration := uint32(self.timeToTs(rawdur))
+	// from the first packet, durations rounded one by one would drift
+	var duration uint32
+	if end := self.timeToTs(pkt.Time - self.firstTime + rawdur); end > self.duration {
+		duration = uint32(end - self.duration)
+	}
 	if self.sttsEntry == nil || duration != self.sttsEntry.Duration {
 		self.sample.TimeToSample.Entries = append(self.sample.TimeToSample.Entries, mp4io.TimeToSampleEntry{Duration: duration})
 		self.sttsEntry = &self.sample.TimeToSample.Entries[len(self.sample.TimeToSample.Entries)-1]
@@ -229,9 +421,13 @@ func (self *Stream) writePacket(pkt av.Packet, rawdur time.Duration) (err error)
 	self.sttsEntry.Count++
 
 	if self.sample.CompositionOffset != nil {
-		offset := uint32(self.timeToTs(pkt.CompositionTime))
+		table := self.sample.CompositionOffset
+		cts := self.timeToTs(pkt.CompositionTime)
+		if cts < 0 {
+			table.Version = 1
+		}
+		offset := uint32(int32(cts))
 		if self.cttsEntry == nil || offset != self.cttsEntry.Offset {
-			table := self.sample.CompositionOffset
 			table.Entries = append(table.Entries, mp4io.CompositionOffsetEntry{Offset: offset})
 			self.cttsEntry = &table.Entries[len(table.Entries)-1]
 		}
@@ -240,17 +436,57 @@ func (self *Stream) writePacket(pkt av.Packet, rawdur time.Duration) (err error)
 
 	self.duration += int64(duration)
 	self.sampleIndex++
-	self.sample.ChunkOffset.Entries = append(self.sample.ChunkOffset.Entries, uint32(self.muxer.wpos))
+	self.sample.ChunkOffset.Entries = append(self.sample.ChunkOffset.Entries, uint64(self.muxer.wpos))
 	self.sample.SampleSize.Entries = append(self.sample.SampleSize.Entries, uint32(len(pkt.Data)))
 
 	self.muxer.wpos += int64(len(pkt.Data))
 	return
 }
 
+// lastDuration guesses the duration of the last packet, which has no next
+// packet to measure from, so the track does not end one frame short.
+func (self *Stream) lastDuration(pkt av.Packet) time.Duration {
+	if pkt.Duration > 0 {
+		return pkt.Duration
+	}
+	if codec, ok := self.CodecData.(av.AudioCodecData); ok {
+		if dur, err := codec.PacketDuration(pkt.Data); err == nil {
+			return dur
+		}
+	}
+	if self.sttsEntry != nil {
+		return self.tsToTime(int64(self.sttsEntry.Duration))
+	}
+	return 0
+}
+
+// writeMdatSize writes the size of the mdat, over the wide box ahead of
+// it when it does not fit in 32 bits.
+func (self *Muxer) writeMdatSize(size int64) (err error) {
+	if size <= math.MaxUint32 {
+		b := make([]byte, 4)
+		pio.PutU32BE(b, uint32(size))
+		_, err = self.w.Write(b)
+		return
+	}
+	if _, err = self.w.Seek(self.mdatPos-8, 0); err != nil {
+		return
+	}
+	b := make([]byte, 16)
+	pio.PutU32BE(b, 1)
+	pio.PutU32BE(b[4:], uint32(mp4io.MDAT))
+	pio.PutU64BE(b[8:], uint64(size+8))
+	_, err = self.w.Write(b)
+	return
+}
+
 func (self *Muxer) WriteTrailer() (err error) {
+	if self.fragmented {
+		return self.writeFragmentTrailer()
+	}
 	for _, stream := range self.streams {
 		if stream.lastpkt != nil {
-			if err = stream.writePacket(*stream.lastpkt, 0); err != nil {
+			if err = stream.writePacket(*stream.lastpkt, stream.lastDuration(*stream.lastpkt)); err != nil {
 				return
 			}
 			stream.lastpkt = nil
@@ -268,16 +504,37 @@ func (self *Muxer) WriteTrailer() (err error) {
 	maxDur := time.Duration(0)
 	timeScale := int64(10000)
 	for _, stream := range self.streams {
+		if ctts := stream.sample.CompositionOffset; ctts != nil && (len(ctts.Entries) == 0 || len(ctts.Entries) == 1 && ctts.Entries[0].Offset == 0) {
+			// no B-frames
+			stream.sample.CompositionOffset = nil
+		}
 		if err = stream.fillTrackAtom(); err != nil {
 			return
 		}
 		dur := stream.tsToTime(stream.duration)
 		stream.trackAtom.Header.Duration = int32(timeToTs(dur, timeScale))
+		stream.fillEditList(timeScale)
+		dur = tsToTime(int64(stream.trackAtom.Header.Duration), timeScale)
+		if atom := stream.iTunSMPB(); atom != nil && moov.Unknowns == nil {
+			moov.Unknowns = append(moov.Unknowns, atom)
+		}
 		if dur > maxDur {
 			maxDur = dur
 		}
 		moov.Tracks = append(moov.Tracks, stream.trackAtom)
 	}
+	if len(self.chapters) > 0 {
+		trackId := int32(len(moov.Tracks) + 1)
+		var track *mp4io.Track
+		if track, err = self.writeChapterTrack(trackId, maxDur, timeScale); err != nil {
+			return
+		}
+		for _, other := range moov.Tracks {
+			other.Unknowns = append(other.Unknowns, chapterRefAtom(trackId))
+		}
+		moov.Tracks = append(moov.Tracks, track)
+		moov.Header.NextTrackId = trackId + 1
+	}
 	moov.Header.TimeScale = int32(timeScale)
 	moov.Header.Duration = int32(timeToTs(maxDur, timeScale))
 
@@ -289,21 +546,17 @@ func (self *Muxer) WriteTrailer() (err error) {
 	if mdatsize, err = self.w.Seek(0, 1); err != nil {
 		return
 	}
-	if _, err = self.w.Seek(0, 0); err != nil {
+	if _, err = self.w.Seek(self.mdatPos, 0); err != nil {
 		return
 	}
-	taghdr := make([]byte, 4)
-	pio.PutU32BE(taghdr, uint32(mdatsize))
-	if _, err = self.w.Write(taghdr); err != nil {
+	if err = self.writeMdatSize(mdatsize - self.mdatPos); err != nil {
 		return
 	}
-
-	if _, err = self.w.Seek(0, 2); err != nil {
+	if err = self.writeISOBrand(); err != nil {
 		return
 	}
-	b := make([]byte, moov.Len())
-	moov.Marshal(b)
-	if _, err = self.w.Write(b); err != nil {
+
+	if err = self.writeMoov(moov); err != nil {
 		return
 	}
 
@@ -0,0 +1,89 @@
+package mp4
+
+import (
+	"fmt"
+
+	"github.com/deepch/vdk/av"
+	"github.com/deepch/vdk/codec"
+	"github.com/deepch/vdk/format/mp4/mp4io"
+)
+
+// pcmDesc describes interleaved PCM as an ipcm or fpcm sample entry, PCM
+// which does not say its byte order is little endian.
+func pcmDesc(stream av.AudioCodecData) (desc *mp4io.PCMDesc, err error) {
+	tag := mp4io.IPCM
+	switch stream.SampleFormat() {
+	case av.S16, av.S32:
+	case av.FLT, av.DBL:
+		tag = mp4io.FPCM
+	default:
+		err = fmt.Errorf("mp4: pcm sample format %v is not supported", stream.SampleFormat())
+		return
+	}
+	if stream.SampleRate() <= 0 || stream.SampleRate() > 0xffff || stream.ChannelLayout().Count() == 0 {
+		err = fmt.Errorf("mp4: pcm rate %d channels %d are not supported", stream.SampleRate(), stream.ChannelLayout().Count())
+		return
+	}
+	bigEndian := false
+	if pcm, ok := stream.(codec.LinearPCMCodecData); ok {
+		bigEndian = pcm.BigEndian
+	}
+	size := stream.SampleFormat().BytesPerSample() * 8
+	conf := &mp4io.PCMConf{SampleSize: uint8(size)}
+	if !bigEndian {
+		conf.FormatFlags = 1
+	}
+	desc = &mp4io.PCMDesc{
+		Tag_:             tag,
+		DataRefIdx:       1,
+		NumberOfChannels: int16(stream.ChannelLayout().Count()),
+		SampleSize:       int16(size),
+		SampleRate:       float64(stream.SampleRate()),
+		Conf:             conf,
+	}
+	return
+}
+
+// pcmLayouts are the default WAVE speaker layouts by channel count.
+var pcmLayouts = []av.ChannelLayout{
+	0,
+	av.CH_MONO,
+	av.CH_STEREO,
+	av.CH_SURROUND,
+	av.CH_STEREO | av.CH_BACK_LEFT | av.CH_BACK_RIGHT,
+	av.CH_SURROUND | av.CH_BACK_LEFT | av.CH_BACK_RIGHT,
+	av.CH_SURROUND | av.CH_LOW_FREQ | av.CH_BACK_LEFT | av.CH_BACK_RIGHT,
+	av.CH_SURROUND | av.CH_LOW_FREQ | av.CH_BACK_CENTER | av.CH_SIDE_LEFT | av.CH_SIDE_RIGHT,
+	av.CH_SURROUND | av.CH_LOW_FREQ | av.CH_BACK_LEFT | av.CH_BACK_RIGHT | av.CH_SIDE_LEFT | av.CH_SIDE_RIGHT,
+}
+
+// pcmCodecData is the codec of an ipcm or fpcm sample entry.
+func pcmCodecData(desc *mp4io.PCMDesc) (stream codec.LinearPCMCodecData, err error) {
+	size := int(desc.SampleSize)
+	bigEndian := true
+	if desc.Conf != nil {
+		size = int(desc.Conf.SampleSize)
+		bigEndian = !desc.Conf.LittleEndian()
+	}
+	var sampleFormat av.SampleFormat
+	switch {
+	case desc.Tag_ == mp4io.IPCM && size == 16:
+		sampleFormat = av.S16
+	case desc.Tag_ == mp4io.IPCM && size == 32:
+		sampleFormat = av.S32
+	case desc.Tag_ == mp4io.FPCM && size == 32:
+		sampleFormat = av.FLT
+	case desc.Tag_ == mp4io.FPCM && size == 64:
+		sampleFormat = av.DBL
+	default:
+		err = fmt.Errorf("mp4: %s of %d bits is not supported", desc.Tag_, size)
+		return
+	}
+	channels := int(desc.NumberOfChannels)
+	if channels <= 0 || channels >= len(pcmLayouts) {
+		err = fmt.Errorf("mp4: pcm of %d channels is not supported", channels)
+		return
+	}
+	stream = codec.NewLinearPCMCodecData(sampleFormat, int(desc.SampleRate), pcmLayouts[channels], bigEndian)
+	return
+}
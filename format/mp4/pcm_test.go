@@ -0,0 +1,44 @@
+package mp4
+
+import (
+	"testing"
+
+	"github.com/deepch/vdk/av"
+	"github.com/deepch/vdk/format/mp4/mp4io"
+)
+
+func TestPCMCodecDataLayout(t *testing.T) {
+	for _, test := range []struct {
+		channels int16
+		layout   av.ChannelLayout // 0 for an error
+	}{
+		{0, 0},
+		{1, av.CH_FRONT_CENTER},
+		{2, av.CH_FRONT_LEFT | av.CH_FRONT_RIGHT},
+		{3, av.CH_FRONT_LEFT | av.CH_FRONT_RIGHT | av.CH_FRONT_CENTER},
+		{4, av.CH_FRONT_LEFT | av.CH_FRONT_RIGHT | av.CH_BACK_LEFT | av.CH_BACK_RIGHT},
+		{6, av.CH_FRONT_LEFT | av.CH_FRONT_RIGHT | av.CH_FRONT_CENTER | av.CH_LOW_FREQ | av.CH_BACK_LEFT | av.CH_BACK_RIGHT},
+		{8, av.CH_FRONT_LEFT | av.CH_FRONT_RIGHT | av.CH_FRONT_CENTER | av.CH_LOW_FREQ | av.CH_BACK_LEFT | av.CH_BACK_RIGHT | av.CH_SIDE_LEFT | av.CH_SIDE_RIGHT},
+		{9, 0},
+	} {
+		desc := &mp4io.PCMDesc{Tag_: mp4io.IPCM, NumberOfChannels: test.channels, SampleSize: 16, SampleRate: 48000}
+		stream, err := pcmCodecData(desc)
+		if test.layout == 0 {
+			if err == nil {
+				t.Errorf("%d channels: no error", test.channels)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%d channels: %v", test.channels, err)
+		}
+		if stream.ChannelLayout() != test.layout {
+			t.Errorf("%d channels: layout 0x%x, want 0x%x", test.channels, stream.ChannelLayout(), test.layout)
+		}
+	}
+	for i, layout := range pcmLayouts {
+		if layout.Count() != i {
+			t.Errorf("layout for %d channels has %d", i, layout.Count())
+		}
+	}
+}
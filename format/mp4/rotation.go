@@ -0,0 +1,57 @@
+package mp4
+
+import (
+	"github.com/deepch/vdk/av"
+	"github.com/deepch/vdk/codec/h264parser"
+	"github.com/deepch/vdk/codec/h265parser"
+	"github.com/deepch/vdk/format/mp4/mp4io"
+)
+
+// rotationMatrix returns the tkhd matrix displaying a width x height picture
+// rotated clockwise, only multiples of 90 degrees are representable.
+func rotationMatrix(rotation int, width, height int) [9]int32 {
+	w, h := int32(width)<<16, int32(height)<<16
+	switch ((rotation%360+360)%360 + 45) / 90 % 4 {
+	case 1:
+		return [9]int32{0, 0x10000, 0, -0x10000, 0, 0, h, 0, 0x40000000}
+	case 2:
+		return [9]int32{-0x10000, 0, 0, 0, -0x10000, 0, w, h, 0x40000000}
+	case 3:
+		return [9]int32{0, -0x10000, 0, 0x10000, 0, 0, 0, w, 0x40000000}
+	}
+	return [9]int32{0x10000, 0, 0, 0, 0x10000, 0, 0, 0, 0x40000000}
+}
+
+// matrixRotation is the clockwise rotation described by a tkhd matrix.
+func matrixRotation(m [9]int32) int {
+	a, b, c, d := m[0], m[1], m[3], m[4]
+	switch {
+	case a == 0 && d == 0 && b > 0 && c < 0:
+		return 90
+	case a < 0 && d < 0 && b == 0 && c == 0:
+		return 180
+	case a == 0 && d == 0 && b < 0 && c > 0:
+		return 270
+	}
+	return 0
+}
+
+func withTrackRotation(codec av.CodecData, header *mp4io.TrackHeader) av.CodecData {
+	if header == nil {
+		return codec
+	}
+	rotation := matrixRotation(header.Matrix)
+	switch codec := codec.(type) {
+	case h264parser.CodecData:
+		if codec.Rotation == 0 {
+			codec.Rotation = rotation
+		}
+		return codec
+	case h265parser.CodecData:
+		if codec.Rotation == 0 {
+			codec.Rotation = rotation
+		}
+		return codec
+	}
+	return codec
+}
@@ -17,6 +17,11 @@ type Stream struct {
 
 	timeScale int64
 	duration  int64
+	// subtracted from sample times, set from the edit list
+	timeOffset time.Duration
+	firstTime  time.Duration
+	// samples are ADTS frames, stripped on read
+	adts bool
 
 	muxer   *Muxer
 	demuxer *Demuxer
@@ -40,6 +45,14 @@ type Stream struct {
 
 	sttsEntry *mp4io.TimeToSampleEntry
 	cttsEntry *mp4io.CompositionOffsetEntry
+
+	seiScanned bool
+
+	// fragmented files, times in the time scale from the first packet
+	fragSamples []fragSample
+	fragTime    int64 // start of the samples gathered
+	fragEnd     int64
+	fragDur     int64 // of the last sample
 }
 
 func timeToTs(tm time.Duration, timeScale int64) int64 {
@@ -57,3 +70,7 @@ func (self *Stream) timeToTs(tm time.Duration) int64 {
 func (self *Stream) tsToTime(ts int64) time.Duration {
 	return time.Duration(ts) * time.Second / time.Duration(self.timeScale)
 }
+
+func (self *Stream) sampleTime() time.Duration {
+	return self.tsToTime(self.dts) - self.timeOffset
+}
@@ -12,6 +12,7 @@ import (
 	"github.com/deepch/vdk/codec/h264parser"
 	"github.com/deepch/vdk/codec/h265parser"
 	"github.com/deepch/vdk/format/fmp4/fmp4io"
+	"github.com/deepch/vdk/format/mp4"
 	"github.com/deepch/vdk/format/mp4/mp4io"
 	"github.com/deepch/vdk/format/mp4f/mp4fio"
 	"github.com/deepch/vdk/utils/bits/pio"
@@ -24,6 +25,18 @@ type Muxer struct {
 	fragmentIndex int
 	streams       []*Stream
 	path          string
+
+	// SegmentIndex prefixes every fragment with a sidx box, for DASH
+	// SegmentBase and byte-range HLS out of a single file.
+	SegmentIndex bool
+	// KeepFragments records the fragments for Fragments and the playlists,
+	// SegmentIndex does too. MaxFragments bounds them to the last ones on
+	// live streams, zero keeps them all.
+	KeepFragments bool
+	MaxFragments  int
+	initSize      int64
+	fragments     []Fragment
+	trackConfigs  map[int]mp4.TrackConfig
 }
 
 func NewMuxer(w *os.File) *Muxer {
@@ -35,6 +48,19 @@ func (self *Muxer) SetPath(path string) {
 func (self *Muxer) SetMaxFrames(count int) {
 	self.maxFrames = count
 }
+
+// SetTrackConfig sets the language and names of the track of stream idx,
+// before GetInit.
+func (self *Muxer) SetTrackConfig(idx int, config mp4.TrackConfig) (err error) {
+	if err = config.Validate(); err != nil {
+		return
+	}
+	if self.trackConfigs == nil {
+		self.trackConfigs = map[int]mp4.TrackConfig{}
+	}
+	self.trackConfigs[idx] = config
+	return
+}
 func (self *Muxer) newStream(codec av.CodecData) (err error) {
 	switch codec.Type() {
 	case av.H264, av.H265, av.AAC:
@@ -42,7 +68,7 @@ func (self *Muxer) newStream(codec av.CodecData) (err error) {
 		err = fmt.Errorf("fmp4: codec type=%v is not supported", codec.Type())
 		return
 	}
-	stream := &Stream{CodecData: codec}
+	stream := &Stream{CodecData: codec, idx: len(self.streams)}
 
 	stream.sample = &mp4io.SampleTable{
 		SampleDesc:    &mp4io.SampleDesc{},
@@ -230,8 +256,12 @@ func (self *Stream) fillTrackAtom() (err error) {
 
 	} else {
 		err = fmt.Errorf("fmp4: codec type=%d invalid", self.Type())
+		return
 	}
 
+	if config, ok := self.muxer.trackConfigs[self.idx]; ok {
+		config.Apply(self.trackAtom)
+	}
 	return
 }
 
@@ -283,6 +313,12 @@ func (element *Muxer) GetInit(streams []av.CodecData) (string, []byte) {
 	file := make([]byte, moov.Len()+len(ftypeData))
 	copy(file, ftypeData)
 	moov.Marshal(file[len(ftypeData):])
+	element.initSize = int64(len(file))
+	element.wpos = element.initSize
+	element.fragments = nil
+	for _, stream := range element.streams {
+		stream.fragments, stream.keyFrameFragments = 0, 0
+	}
 	return meta, file
 }
 
@@ -347,6 +383,7 @@ func (element *Stream) writePacketV4(pkt av.Packet) error {
 	}
 	trackID := pkt.Idx + 1
 	if element.sampleIndex == 0 {
+		element.fragmentKeyFrame = pkt.IsKeyFrame
 		element.moof.Header = &mp4fio.MovieFragHeader{Seqnum: uint32(element.muxer.fragmentIndex + 1)}
 		element.moof.Tracks = []*mp4fio.TrackFrag{
 			&mp4fio.TrackFrag{
@@ -390,16 +427,11 @@ func (element *Stream) writePacketV3(pkt av.Packet, rawdur time.Duration, maxFra
 	var out []byte
 	var got bool
 	if element.sampleIndex > maxFrames && pkt.IsKeyFrame {
-		element.moof.Tracks[0].Run.DataOffset = uint32(element.moof.Len() + 8)
-		out = make([]byte, element.moof.Len()+len(element.buffer))
-		element.moof.Marshal(out)
-		pio.PutU32BE(element.buffer, uint32(len(element.buffer)))
-		copy(out[element.moof.Len():], element.buffer)
-		element.sampleIndex = 0
-		element.muxer.fragmentIndex++
+		out = element.finishFragment()
 		got = true
 	}
 	if element.sampleIndex == 0 {
+		element.fragmentKeyFrame = pkt.IsKeyFrame
 		element.moof.Header = &mp4fio.MovieFragHeader{Seqnum: uint32(element.muxer.fragmentIndex + 1)}
 		element.moof.Tracks = []*mp4fio.TrackFrag{
 			&mp4fio.TrackFrag{
@@ -433,16 +465,85 @@ func (element *Stream) writePacketV3(pkt av.Packet, rawdur time.Duration, maxFra
 	return got, out, nil
 }
 func (element *Muxer) Finalize() []byte {
-	stream := element.streams[0]
-	stream.moof.Tracks[0].Run.DataOffset = uint32(stream.moof.Len() + 8)
-	out := make([]byte, stream.moof.Len()+len(stream.buffer))
-	stream.moof.Marshal(out)
-	PutU32BE(stream.buffer, uint32(len(stream.buffer)))
-	copy(out[stream.moof.Len():], stream.buffer)
-	stream.sampleIndex = 0
-	stream.muxer.fragmentIndex++
-	return out
+	return element.streams[0].finishFragment()
+}
 
+// finishFragment returns the moof and mdat of the samples gathered so far,
+// behind a sidx if the muxer asks for one.
+func (element *Stream) finishFragment() (out []byte) {
+	muxer := element.muxer
+	track := element.moof.Tracks[0]
+	track.Run.DataOffset = uint32(element.moof.Len() + 8)
+	size := element.moof.Len() + len(element.buffer)
+
+	var duration, cts int64
+	for i, entry := range track.Run.Entries {
+		duration += int64(entry.Duration)
+		if i == 0 {
+			cts = int64(entry.Cts)
+		}
+	}
+	start := int64(track.DecodeTime.Time)
+
+	var sidx *fmp4io.SegmentIndex
+	if muxer.SegmentIndex {
+		sidx = &fmp4io.SegmentIndex{
+			FullAtom:    fmp4io.FullAtom{Version: 1},
+			ReferenceID: uint32(element.trackAtom.Header.TrackId),
+			TimeScale:   uint32(element.timeScale),
+			EarliestPTS: uint64(start + cts),
+			References: []fmp4io.SegmentReference{{
+				ReferencedSize:     uint32(size),
+				SubsegmentDuration: uint32(duration),
+				StartsWithSAP:      element.fragmentKeyFrame,
+				SAPType:            1,
+			}},
+		}
+		if !element.fragmentKeyFrame {
+			sidx.References[0].SAPType = 0
+		}
+	}
+
+	n := 0
+	if sidx != nil {
+		n = sidx.Len()
+	}
+	out = make([]byte, n+size)
+	if sidx != nil {
+		sidx.Marshal(out)
+	}
+	element.moof.Marshal(out[n:])
+	pio.PutU32BE(element.buffer, uint32(len(element.buffer)))
+	copy(out[n+element.moof.Len():], element.buffer)
+	element.sampleIndex = 0
+	muxer.fragmentIndex++
+
+	var keySize int64
+	if element.fragmentKeyFrame && len(track.Run.Entries) > 0 {
+		keySize = int64(n+element.moof.Len()+8) + int64(track.Run.Entries[0].Size)
+	}
+	if muxer.SegmentIndex || muxer.KeepFragments {
+		muxer.fragments = append(muxer.fragments, Fragment{
+			Idx:              element.idx,
+			Sequence:         element.fragments,
+			KeyFrameSequence: element.keyFrameFragments,
+			Offset:           muxer.wpos,
+			Size:             int64(len(out)),
+			Start:            element.tsToTime(start),
+			Duration:         element.tsToTime(duration),
+			KeyFrame:         element.fragmentKeyFrame,
+			KeyFrameSize:     keySize,
+		})
+		element.fragments++
+		if keySize > 0 {
+			element.keyFrameFragments++
+		}
+		if n := len(muxer.fragments) - muxer.MaxFragments; muxer.MaxFragments > 0 && n > 0 {
+			muxer.fragments = append(muxer.fragments[:0], muxer.fragments[n:]...)
+		}
+	}
+	muxer.wpos += int64(len(out))
+	return
 }
 
 // PutU32BE func
@@ -455,6 +556,7 @@ func PutU32BE(b []byte, v uint32) {
 func (element *Stream) writePacketV2(pkt av.Packet, rawdur time.Duration, maxFrames int) (bool, []byte, error) {
 	trackID := pkt.Idx + 1
 	if element.sampleIndex == 0 {
+		element.fragmentKeyFrame = pkt.IsKeyFrame
 		element.moof.Header = &mp4fio.MovieFragHeader{Seqnum: uint32(element.muxer.fragmentIndex + 1)}
 		element.moof.Tracks = []*mp4fio.TrackFrag{
 			&mp4fio.TrackFrag{
@@ -486,14 +588,7 @@ func (element *Stream) writePacketV2(pkt av.Packet, rawdur time.Duration, maxFra
 	element.sampleIndex++
 	element.dts += element.timeToTs(rawdur)
 	if element.sampleIndex > maxFrames { // Количество фреймов в пакете
-		element.moof.Tracks[0].Run.DataOffset = uint32(element.moof.Len() + 8)
-		file := make([]byte, element.moof.Len()+len(element.buffer))
-		element.moof.Marshal(file)
-		pio.PutU32BE(element.buffer, uint32(len(element.buffer)))
-		copy(file[element.moof.Len():], element.buffer)
-		element.sampleIndex = 0
-		element.muxer.fragmentIndex++
-		return true, file, nil
+		return true, element.finishFragment(), nil
 	}
 	return false, []byte{}, nil
 }
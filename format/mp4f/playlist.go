@@ -0,0 +1,158 @@
+package mp4f
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"time"
+
+	"github.com/deepch/vdk/format/fmp4/fmp4io"
+)
+
+// Fragment locates an emitted fragment in the single file made of the init
+// segment followed by every fragment in order.
+type Fragment struct {
+	Idx      int // stream index
+	Offset   int64
+	Size     int64
+	Start    time.Duration
+	Duration time.Duration
+	KeyFrame bool
+	// KeyFrameSize covers the fragment from Offset up to the end of its
+	// leading key frame, zero if it does not start with one.
+	KeyFrameSize int64
+	// Sequence numbers the fragments of a stream from zero, KeyFrameSequence
+	// the ones with a KeyFrameSize, so that playlists of the last fragments
+	// kept by MaxFragments give their media sequence.
+	Sequence         int
+	KeyFrameSequence int
+}
+
+// InitSize is the length of the init segment returned by GetInit.
+func (self *Muxer) InitSize() int64 {
+	return self.initSize
+}
+
+// Fragments returns the byte ranges of the fragments emitted since GetInit,
+// recorded with SegmentIndex or KeepFragments only.
+func (self *Muxer) Fragments() []Fragment {
+	return self.fragments
+}
+
+// WriteHLSPlaylist writes a byte-range media playlist serving the fragments
+// of stream idx out of the single file at uri, its media sequence is the one
+// of the first fragment. ended adds EXT-X-ENDLIST.
+func WriteHLSPlaylist(w io.Writer, uri string, initSize int64, fragments []Fragment, idx int, ended bool) (err error) {
+	target := 1
+	for _, frag := range fragments {
+		if d := int(math.Ceil(frag.Duration.Seconds())); frag.Idx == idx && d > target {
+			target = d
+		}
+	}
+	sequence := 0
+	for _, frag := range fragments {
+		if frag.Idx == idx {
+			sequence = frag.Sequence
+			break
+		}
+	}
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "#EXTM3U\n#EXT-X-VERSION:7\n#EXT-X-TARGETDURATION:%d\n", target)
+	fmt.Fprintf(bw, "#EXT-X-MEDIA-SEQUENCE:%d\n", sequence)
+	if ended {
+		fmt.Fprintf(bw, "#EXT-X-PLAYLIST-TYPE:VOD\n")
+	}
+	fmt.Fprintf(bw, "#EXT-X-MAP:URI=\"%s\",BYTERANGE=\"%d@0\"\n", uri, initSize)
+	for _, frag := range fragments {
+		if frag.Idx != idx {
+			continue
+		}
+		fmt.Fprintf(bw, "#EXTINF:%.3f,\n#EXT-X-BYTERANGE:%d@%d\n%s\n", frag.Duration.Seconds(), frag.Size, frag.Offset, uri)
+	}
+	if ended {
+		fmt.Fprintf(bw, "#EXT-X-ENDLIST\n")
+	}
+	return bw.Flush()
+}
+
+// WriteHLSIFramePlaylist writes an EXT-X-I-FRAMES-ONLY playlist for stream
+// idx. Each entry spans the leading key frame of a fragment and lasts until
+// the next key frame, its media sequence is the KeyFrameSequence of the
+// first one so that live playlists keep counting.
+func WriteHLSIFramePlaylist(w io.Writer, uri string, initSize int64, fragments []Fragment, idx int, ended bool) (err error) {
+	type iframe struct {
+		frag     Fragment
+		duration time.Duration
+	}
+	var iframes []iframe
+	for _, frag := range fragments {
+		if frag.Idx != idx {
+			continue
+		}
+		if frag.KeyFrameSize > 0 {
+			iframes = append(iframes, iframe{frag: frag})
+		}
+		if len(iframes) > 0 {
+			iframes[len(iframes)-1].duration += frag.Duration
+		}
+	}
+	target := 1
+	for _, f := range iframes {
+		if d := int(math.Ceil(f.duration.Seconds())); d > target {
+			target = d
+		}
+	}
+	sequence := 0
+	if len(iframes) > 0 {
+		sequence = iframes[0].frag.KeyFrameSequence
+	}
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "#EXTM3U\n#EXT-X-VERSION:7\n#EXT-X-TARGETDURATION:%d\n", target)
+	fmt.Fprintf(bw, "#EXT-X-MEDIA-SEQUENCE:%d\n", sequence)
+	if ended {
+		fmt.Fprintf(bw, "#EXT-X-PLAYLIST-TYPE:VOD\n")
+	}
+	fmt.Fprintf(bw, "#EXT-X-I-FRAMES-ONLY\n")
+	fmt.Fprintf(bw, "#EXT-X-MAP:URI=\"%s\",BYTERANGE=\"%d@0\"\n", uri, initSize)
+	for _, f := range iframes {
+		fmt.Fprintf(bw, "#EXTINF:%.3f,\n#EXT-X-BYTERANGE:%d@%d\n%s\n", f.duration.Seconds(), f.frag.KeyFrameSize, f.frag.Offset, uri)
+	}
+	if ended {
+		fmt.Fprintf(bw, "#EXT-X-ENDLIST\n")
+	}
+	return bw.Flush()
+}
+
+// SegmentIndexBox builds a sidx indexing the fragments of stream idx, for the
+// DASH SegmentBase indexRange of a single stream file. The fragments must
+// follow it directly.
+func SegmentIndexBox(fragments []Fragment, idx int, timeScale uint32) []byte {
+	sidx := fmp4io.SegmentIndex{
+		FullAtom:    fmp4io.FullAtom{Version: 1},
+		ReferenceID: uint32(idx + 1),
+		TimeScale:   timeScale,
+	}
+	first := true
+	for _, frag := range fragments {
+		if frag.Idx != idx {
+			continue
+		}
+		if first {
+			sidx.EarliestPTS = uint64(timeToTs(frag.Start, int64(timeScale)))
+			first = false
+		}
+		ref := fmp4io.SegmentReference{
+			ReferencedSize:     uint32(frag.Size),
+			SubsegmentDuration: uint32(timeToTs(frag.Duration, int64(timeScale))),
+			StartsWithSAP:      frag.KeyFrame,
+		}
+		if frag.KeyFrame {
+			ref.SAPType = 1
+		}
+		sidx.References = append(sidx.References, ref)
+	}
+	b := make([]byte, sidx.Len())
+	sidx.Marshal(b)
+	return b
+}
@@ -0,0 +1,49 @@
+package mp4f
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPlaylistMediaSequence(t *testing.T) {
+	// the fragments left by MaxFragments, from the sixth one, a key frame
+	// every other fragment
+	var fragments []Fragment
+	for i := 5; i < 11; i++ {
+		frag := Fragment{
+			Offset:           int64(i) * 1000,
+			Size:             1000,
+			Duration:         time.Second,
+			Sequence:         i,
+			KeyFrameSequence: i / 2,
+		}
+		if i%2 == 0 {
+			frag.KeyFrame = true
+			frag.KeyFrameSize = 400
+		}
+		fragments = append(fragments, frag)
+	}
+
+	for _, test := range []struct {
+		name  string
+		write func(*bytes.Buffer) error
+		want  string
+	}{
+		{"media", func(w *bytes.Buffer) error {
+			return WriteHLSPlaylist(w, "file.mp4", 100, fragments, 0, false)
+		}, "#EXT-X-MEDIA-SEQUENCE:5\n"},
+		{"i-frames", func(w *bytes.Buffer) error {
+			return WriteHLSIFramePlaylist(w, "file.mp4", 100, fragments, 0, false)
+		}, "#EXT-X-MEDIA-SEQUENCE:3\n"},
+	} {
+		w := &bytes.Buffer{}
+		if err := test.write(w); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(w.String(), test.want) {
+			t.Errorf("%s: no %q in\n%s", test.name, strings.TrimSpace(test.want), w)
+		}
+	}
+}
@@ -35,6 +35,9 @@ type Stream struct {
 	cttsEntry              *mp4io.CompositionOffsetEntry
 	moof                   mp4fio.MovieFrag
 	buffer                 []byte
+	fragmentKeyFrame       bool
+	fragments              int // recorded, for the sequence of the next
+	keyFrameFragments      int
 }
 
 func timeToTs(tm time.Duration, timeScale int64) int64 {
@@ -0,0 +1,203 @@
+// Package null has muxers that discard the packets, to benchmark ingest
+// and pipelines without the cost of a disk or network sink. Counter also
+// records the throughput and how late the packets come.
+package null
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/deepch/vdk/av"
+	"github.com/deepch/vdk/av/avutil"
+)
+
+// Muxer discards everything.
+type Muxer struct{}
+
+func (self Muxer) WriteHeader(streams []av.CodecData) error {
+	return nil
+}
+
+func (self Muxer) WritePacket(pkt av.Packet) error {
+	return nil
+}
+
+func (self Muxer) WriteTrailer() error {
+	return nil
+}
+
+func (self Muxer) Close() error {
+	return nil
+}
+
+type StreamStats struct {
+	Type      av.CodecType
+	Packets   int64
+	Bytes     int64
+	Keyframes int64
+	Duration  time.Duration // media time from the first packet to the end of the last
+}
+
+// Stats of the packets written so far. Latency is how much later than the
+// pace of its timestamp a packet came, the first one setting the pace: it
+// is negative for a source faster than real time.
+type Stats struct {
+	Streams    []StreamStats
+	Packets    int64
+	Bytes      int64
+	Start      time.Time // wall clock time of the first packet
+	Last       time.Time
+	MinLatency time.Duration
+	MaxLatency time.Duration
+	AvgLatency time.Duration
+	MaxGap     time.Duration // longest wall clock time between two packets
+}
+
+func (self Stats) Elapsed() time.Duration {
+	return self.Last.Sub(self.Start)
+}
+
+func (self Stats) PacketsPerSecond() float64 {
+	if elapsed := self.Elapsed(); elapsed > 0 {
+		return float64(self.Packets) / elapsed.Seconds()
+	}
+	return 0
+}
+
+func (self Stats) BitsPerSecond() float64 {
+	if elapsed := self.Elapsed(); elapsed > 0 {
+		return float64(self.Bytes*8) / elapsed.Seconds()
+	}
+	return 0
+}
+
+// Speed is media time over wall clock time, 1 for a live source.
+func (self Stats) Speed() float64 {
+	var media time.Duration
+	for _, stream := range self.Streams {
+		if stream.Duration > media {
+			media = stream.Duration
+		}
+	}
+	if elapsed := self.Elapsed(); elapsed > 0 {
+		return media.Seconds() / elapsed.Seconds()
+	}
+	return 0
+}
+
+// Counter discards the packets and counts them, Stats may be called while
+// it is written to.
+type Counter struct {
+	Now func() time.Time // time.Now if nil
+
+	lock       sync.Mutex
+	stats      Stats
+	first      []time.Duration
+	started    []bool
+	firstTime  time.Duration
+	based      bool
+	latencySum time.Duration
+}
+
+func NewCounter() *Counter {
+	return &Counter{}
+}
+
+func (self *Counter) now() time.Time {
+	if self.Now != nil {
+		return self.Now()
+	}
+	return time.Now()
+}
+
+func (self *Counter) WriteHeader(streams []av.CodecData) error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.stats = Stats{Streams: make([]StreamStats, len(streams))}
+	for i, stream := range streams {
+		self.stats.Streams[i].Type = stream.Type()
+	}
+	self.first = make([]time.Duration, len(streams))
+	self.started = make([]bool, len(streams))
+	self.based = false
+	self.latencySum = 0
+	return nil
+}
+
+func (self *Counter) WritePacket(pkt av.Packet) error {
+	now := self.now()
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	stats := &self.stats
+
+	if !self.based {
+		self.based = true
+		self.firstTime = pkt.Time
+		stats.Start = now
+	} else if gap := now.Sub(stats.Last); gap > stats.MaxGap {
+		stats.MaxGap = gap
+	}
+	stats.Last = now
+	stats.Packets++
+	stats.Bytes += int64(len(pkt.Data))
+
+	latency := now.Sub(stats.Start) - (pkt.Time - self.firstTime)
+	if stats.Packets == 1 || latency < stats.MinLatency {
+		stats.MinLatency = latency
+	}
+	if stats.Packets == 1 || latency > stats.MaxLatency {
+		stats.MaxLatency = latency
+	}
+	self.latencySum += latency
+	stats.AvgLatency = self.latencySum / time.Duration(stats.Packets)
+
+	if idx := int(pkt.Idx); idx >= 0 && idx < len(stats.Streams) {
+		stream := &stats.Streams[idx]
+		if !self.started[idx] {
+			self.started[idx] = true
+			self.first[idx] = pkt.Time
+		}
+		stream.Packets++
+		stream.Bytes += int64(len(pkt.Data))
+		if pkt.IsKeyFrame {
+			stream.Keyframes++
+		}
+		if end := pkt.Time + pkt.Duration - self.first[idx]; end > stream.Duration {
+			stream.Duration = end
+		}
+	}
+	return nil
+}
+
+func (self *Counter) WriteTrailer() error {
+	return nil
+}
+
+func (self *Counter) Close() error {
+	return nil
+}
+
+func (self *Counter) Stats() (stats Stats) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	stats = self.stats
+	stats.Streams = append([]StreamStats(nil), self.stats.Streams...)
+	return
+}
+
+// Handler creates a Muxer for "null:" and a Counter for "null:count".
+func Handler(h *avutil.RegisterHandler) {
+	h.UrlMuxer = func(uri string) (ok bool, muxer av.MuxCloser, err error) {
+		if !strings.HasPrefix(uri, "null:") {
+			return
+		}
+		ok = true
+		if uri == "null:count" {
+			muxer = NewCounter()
+		} else {
+			muxer = Muxer{}
+		}
+		return
+	}
+}
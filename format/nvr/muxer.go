@@ -46,6 +46,8 @@ type Muxer struct {
 	start, end                                                                  time.Time
 	pstart, pend                                                                time.Duration
 	started                                                                     bool
+	audio                                                                       []av.Packet
+	cutFloor                                                                    time.Duration
 	serverID, streamName, channelName, streamID, channelID, hostLong, hostShort string
 	handleFileChange                                                            func(bool, string, string, int64, time.Time, time.Time, time.Duration)
 }
@@ -115,6 +117,10 @@ func (m *Muxer) WritePacket(pkt av.Packet) (err error) {
 	}
 	if !m.started && pkt.IsKeyFrame {
 		m.started = true
+		if m.muxer != nil {
+			m.pstart = pkt.Time
+			m.muxer.SetTimeRange(m.pstart, 0)
+		}
 	}
 	if m.started {
 		switch m.format {
@@ -128,21 +134,70 @@ func (m *Muxer) WritePacket(pkt av.Packet) (err error) {
 	return
 }
 
+// writePacketMP4 cuts files on video keyframes. The audio frames past the
+// cut, which may come before the keyframe, go to both files, each trimmed to
+// its side of the cut with an edit list, so played back to back the files
+// have no audio gap.
 func (m *Muxer) writePacketMP4(pkt av.Packet) (err error) {
 	if pkt.IsKeyFrame && m.dur > time.Duration(m.limit)*time.Second {
+		m.muxer.SetTimeRange(m.pstart, pkt.Time)
 		m.pstart = pkt.Time
 		if err = m.OpenMP4(); err != nil {
 			return
 		}
+		m.muxer.SetTimeRange(m.pstart, 0)
 		m.dur = 0
-
+		for _, apkt := range m.audio {
+			if apkt.Time+apkt.Duration > m.pstart {
+				if err = m.muxer.WritePacket(apkt); err != nil {
+					return
+				}
+			}
+		}
+		m.audio = m.audio[:0]
 	}
+	m.keepAudio(pkt)
 	m.dur += pkt.Duration
 	m.pend = pkt.Time
 
 	return m.muxer.WritePacket(pkt)
 }
 
+// keepAudio keeps the audio packets which may end past the next cut. Video
+// comes in time order, so the cut is no earlier than the last video packet,
+// or than the last packet at all without a video stream.
+func (m *Muxer) keepAudio(pkt av.Packet) {
+	if int(pkt.Idx) >= len(m.gof.Streams) {
+		return
+	}
+	codec := m.gof.Streams[pkt.Idx]
+	if codec.Type().IsVideo() || !m.hasVideo() {
+		m.cutFloor = pkt.Time
+	}
+	if codec, ok := codec.(av.AudioCodecData); ok {
+		if pkt.Duration == 0 {
+			pkt.Duration, _ = codec.PacketDuration(pkt.Data)
+		}
+		m.audio = append(m.audio, pkt)
+	}
+	kept := m.audio[:0]
+	for _, apkt := range m.audio {
+		if apkt.Time+apkt.Duration > m.cutFloor {
+			kept = append(kept, apkt)
+		}
+	}
+	m.audio = kept
+}
+
+func (m *Muxer) hasVideo() bool {
+	for _, codec := range m.gof.Streams {
+		if codec.Type().IsVideo() {
+			return true
+		}
+	}
+	return false
+}
+
 func (m *Muxer) writePacketNVR(pkt av.Packet) (err error) {
 	if pkt.IsKeyFrame {
 		if len(m.gof.Packet) > 0 {
@@ -0,0 +1,648 @@
+package format
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/deepch/vdk/av"
+	"github.com/deepch/vdk/av/avutil"
+	"github.com/deepch/vdk/av/generator"
+	"github.com/deepch/vdk/av/pktque"
+	"github.com/deepch/vdk/codec/aacparser"
+	"github.com/deepch/vdk/codec/av1parser"
+	"github.com/deepch/vdk/codec/opusparser"
+	"github.com/deepch/vdk/codec/vp9parser"
+	"github.com/deepch/vdk/format/flv"
+	"github.com/deepch/vdk/format/flv/flvio"
+	"github.com/deepch/vdk/format/fmp4/fmp4io"
+	"github.com/deepch/vdk/format/mp4"
+	"github.com/deepch/vdk/format/mp4/mp4io"
+	"github.com/deepch/vdk/format/ts"
+	"github.com/deepch/vdk/utils/bits/pio"
+	"github.com/deepch/vdk/utils/rangecache"
+)
+
+func testPackets(t *testing.T, n int) (streams []av.CodecData, pkts []av.Packet) {
+	video, err := generator.NewVideo(generator.Bars(64, 48), 25, 25)
+	if err != nil {
+		t.Fatal(err)
+	}
+	audio, err := generator.NewAudio(av.AAC, 44100, av.CH_STEREO, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	demuxer := generator.New(video, audio)
+	if streams, err = demuxer.Streams(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < n; i++ {
+		pkt, err := demuxer.ReadPacket()
+		if err != nil {
+			t.Fatal(err)
+		}
+		pkts = append(pkts, pkt)
+	}
+	return
+}
+
+func TestRoundTrip(t *testing.T) {
+	RegisterAll()
+	streams, pkts := testPackets(t, 300)
+	for _, exts := range [][]string{
+		{".mp4"},
+		{".ts"},
+		{".flv"},
+		{".mp4", ".ts"},
+		{".ts", ".flv", ".mp4"},
+	} {
+		if err := avutil.RoundTrip(streams, pkts, 0, exts...); err != nil {
+			t.Errorf("%v: %v", exts, err)
+		}
+	}
+}
+
+// fragmentDemuxer reads back the packets of a fragmented mp4, which the mp4
+// demuxer does not.
+type fragmentDemuxer struct {
+	streams []av.CodecData
+	pkts    []av.Packet
+	err     error
+}
+
+func newFragmentDemuxer(r io.Reader) av.Demuxer {
+	self := &fragmentDemuxer{}
+	self.err = self.read(r)
+	return self
+}
+
+func (self *fragmentDemuxer) read(r io.Reader) (err error) {
+	var b []byte
+	if b, err = io.ReadAll(r); err != nil {
+		return
+	}
+	if self.streams, err = mp4.NewDemuxer(bytes.NewReader(b)).Streams(); err != nil {
+		return
+	}
+	var timeScales []int64
+	for pos := 0; pos < len(b); {
+		size := int(pio.U32BE(b[pos:]))
+		if size < 8 || pos+size > len(b) {
+			return fmt.Errorf("bad atom size %d at %d", size, pos)
+		}
+		atom := b[pos : pos+size]
+		switch string(atom[4:8]) {
+		case "moov":
+			moov := &mp4io.Movie{}
+			if _, err = moov.Unmarshal(atom, pos); err != nil {
+				return
+			}
+			for _, track := range moov.Tracks {
+				timeScales = append(timeScales, int64(track.Media.Header.TimeScale))
+			}
+		case "moof":
+			moof := &fmp4io.MovieFrag{}
+			if _, err = moof.Unmarshal(atom, pos); err != nil {
+				return
+			}
+			for _, traf := range moof.Tracks {
+				idx := int(traf.Header.TrackID) - 1
+				toTime := func(ts int64) time.Duration {
+					return time.Duration(ts) * time.Second / time.Duration(timeScales[idx])
+				}
+				ts := int64(traf.DecodeTime.Time)
+				data := pos + int(traf.Run.DataOffset)
+				for _, entry := range traf.Run.Entries {
+					self.pkts = append(self.pkts, av.Packet{
+						Idx:             int8(idx),
+						IsKeyFrame:      entry.Flags == fmp4io.SampleNoDependencies,
+						Time:            toTime(ts),
+						CompositionTime: toTime(int64(entry.CTS)),
+						Data:            b[data : data+int(entry.Size)],
+					})
+					ts += int64(entry.Duration)
+					data += int(entry.Size)
+				}
+			}
+		}
+		pos += size
+	}
+	return
+}
+
+func (self *fragmentDemuxer) Streams() ([]av.CodecData, error) {
+	return self.streams, self.err
+}
+
+func (self *fragmentDemuxer) ReadPacket() (pkt av.Packet, err error) {
+	if len(self.pkts) == 0 {
+		err = io.EOF
+		return
+	}
+	pkt, self.pkts = self.pkts[0], self.pkts[1:]
+	return
+}
+
+// streamsDemuxer keeps the streams read back by a round trip.
+type streamsDemuxer struct {
+	av.Demuxer
+	streams *[]av.CodecData
+}
+
+func (self streamsDemuxer) Streams() (streams []av.CodecData, err error) {
+	streams, err = self.Demuxer.Streams()
+	*self.streams = streams
+	return
+}
+
+func TestMP4RoundTrip(t *testing.T) {
+	streams, pkts := testPackets(t, 300)
+	// AAC priming, read back from the edit list
+	audio := streams[1].(aacparser.CodecData)
+	audio.Priming = aacparser.DefaultPriming
+	streams = append([]av.CodecData{streams[0], audio}, streams[2:]...)
+	// B-frames with negative composition offsets, in a version 1 ctts
+	frame := 40 * time.Millisecond
+	for i := range pkts {
+		if pkts[i].Idx == 0 && !pkts[i].IsKeyFrame {
+			pkts[i].CompositionTime = []time.Duration{0, frame, -frame}[i%3]
+		}
+	}
+
+	for _, test := range []struct {
+		name       string
+		newMuxer   func(io.WriteSeeker) av.Muxer
+		newDemuxer func(io.Reader) av.Demuxer
+	}{
+		{name: "moov at the end", newMuxer: func(w io.WriteSeeker) av.Muxer {
+			return mp4.NewMuxer(w)
+		}},
+		{name: "faststart", newMuxer: func(w io.WriteSeeker) av.Muxer {
+			muxer := mp4.NewMuxer(w)
+			muxer.SetFastStart(0)
+			return muxer
+		}},
+		{name: "faststart reserve", newMuxer: func(w io.WriteSeeker) av.Muxer {
+			muxer := mp4.NewMuxer(w)
+			muxer.SetFastStart(64 << 10)
+			return muxer
+		}},
+		{name: "faststart reserve too small", newMuxer: func(w io.WriteSeeker) av.Muxer {
+			muxer := mp4.NewMuxer(w)
+			muxer.SetFastStart(64)
+			return muxer
+		}},
+		{name: "fragments", newMuxer: func(w io.WriteSeeker) av.Muxer {
+			return mp4.NewFragmentedMuxer(w, time.Second)
+		}, newDemuxer: newFragmentDemuxer},
+	} {
+		var got []av.CodecData
+		handlers := &avutil.Handlers{}
+		handlers.Add(func(h *avutil.RegisterHandler) {
+			mp4.Handler(h)
+			h.WriterMuxer = func(w io.Writer) av.Muxer {
+				return test.newMuxer(w.(io.WriteSeeker))
+			}
+			newDemuxer := h.ReaderDemuxer
+			if test.newDemuxer != nil {
+				newDemuxer = test.newDemuxer
+			}
+			h.ReaderDemuxer = func(r io.Reader) av.Demuxer {
+				return streamsDemuxer{Demuxer: newDemuxer(r), streams: &got}
+			}
+		})
+		if err := handlers.RoundTrip(streams, pkts, 0, ".mp4"); err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if test.newDemuxer != nil {
+			continue
+		}
+		if priming := got[1].(aacparser.CodecData).Priming; priming != audio.Priming {
+			t.Errorf("%s: priming %d, want %d", test.name, priming, audio.Priming)
+		}
+	}
+}
+
+// codecPackets interleaves 2s of 25fps video of codec with 20ms Opus
+// frames, the frame data is only checked to come back the same.
+func codecPackets(video av.CodecData, frame func(i int) []byte) (streams []av.CodecData, pkts []av.Packet) {
+	streams = []av.CodecData{video, opusparser.CodecData{Channels: 2}}
+	for i := 0; i < 100; i++ {
+		tm := time.Duration(i) * 20 * time.Millisecond
+		if i%2 == 0 {
+			pkts = append(pkts, av.Packet{Idx: 0, IsKeyFrame: i%50 == 0, Time: tm, Data: frame(i)})
+		}
+		// 20ms CELT stereo frame
+		pkts = append(pkts, av.Packet{Idx: 1, Time: tm, Data: []byte{0xfc, byte(i), 0x01, 0x02}})
+	}
+	return
+}
+
+func TestMP4CodecsRoundTrip(t *testing.T) {
+	record := vp9parser.VPCodecConfRecord{Level: 10, BitDepth: 8, ChromaSubsampling: 1, ColourPrimaries: 2, TransferCharacteristics: 2, MatrixCoefficients: 2}
+	b := make([]byte, record.Len())
+	record.Marshal(b)
+	vp9, err := vp9parser.NewCodecDataFromVPCodecConfRecord(b, 64, 48)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// reduced still picture sequence header of 64x48 8 bit 4:2:0
+	av1, err := av1parser.NewCodecDataFromSequenceHeader([]byte{0x0a, 0x06, 0x08, 0x15, 0x7f, 0xbc, 0x00, 0x08})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		name  string
+		codec av.CodecData
+		frame func(i int) []byte
+	}{
+		{"vp9", vp9, func(i int) []byte { return []byte{0x82, 0x49, 0x83, byte(i)} }},
+		// a frame OBU with its size field
+		{"av1", av1, func(i int) []byte { return []byte{0x32, 0x02, byte(i), 0x00} }},
+	} {
+		streams, pkts := codecPackets(test.codec, test.frame)
+		var got []av.CodecData
+		handlers := &avutil.Handlers{}
+		handlers.Add(func(h *avutil.RegisterHandler) {
+			mp4.Handler(h)
+			newDemuxer := h.ReaderDemuxer
+			h.ReaderDemuxer = func(r io.Reader) av.Demuxer {
+				return streamsDemuxer{Demuxer: newDemuxer(r), streams: &got}
+			}
+		})
+		if err := handlers.RoundTrip(streams, pkts, 0, ".mp4"); err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if video := got[0].(av.VideoCodecData); video.Width() != 64 || video.Height() != 48 {
+			t.Errorf("%s: size %dx%d, want 64x48", test.name, video.Width(), video.Height())
+		}
+		if channels := got[1].(av.AudioCodecData).ChannelLayout().Count(); channels != 2 {
+			t.Errorf("%s: opus of %d channels, want 2", test.name, channels)
+		}
+	}
+}
+
+// foreignVP9 is VP9 codec data without a vpcC record.
+type foreignVP9 struct{}
+
+func (foreignVP9) Type() av.CodecType { return av.VP9 }
+
+func TestMP4RejectsForeignCodecData(t *testing.T) {
+	handlers := &avutil.Handlers{}
+	handlers.Add(mp4.Handler)
+	if err := handlers.RoundTrip([]av.CodecData{foreignVP9{}}, nil, 0, ".mp4"); err == nil {
+		t.Error("VP9 codec data without a vpcC record accepted")
+	}
+}
+
+func TestComparePackets(t *testing.T) {
+	streams, pkts := testPackets(t, 50)
+	got := append([]av.Packet{}, pkts...)
+	for i := range got {
+		if got[i].Idx == 0 && got[i].IsKeyFrame {
+			got[i].IsKeyFrame = false
+			break
+		}
+	}
+	if err := avutil.ComparePackets(streams, pkts, got, avutil.DefaultTimeTolerance); err == nil {
+		t.Error("keyframe change not found")
+	}
+}
+
+func writePackets(t *testing.T, muxer av.Muxer, streams []av.CodecData, pkts []av.Packet) {
+	if err := muxer.WriteHeader(streams); err != nil {
+		t.Fatal(err)
+	}
+	for _, pkt := range pkts {
+		if err := muxer.WritePacket(pkt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := muxer.WriteTrailer(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// stateDemuxer is a demuxer of a file, saving and restoring its read
+// position.
+type stateDemuxer struct {
+	av.Demuxer
+	save    func() (interface{}, error)
+	restore func(state interface{}) error
+}
+
+func TestStateRoundTrip(t *testing.T) {
+	streams, pkts := testPackets(t, 300)
+	for _, test := range []struct {
+		ext        string
+		newMuxer   func(w io.WriteSeeker) av.Muxer
+		newDemuxer func(r io.ReadSeeker) stateDemuxer
+	}{
+		{".mp4", func(w io.WriteSeeker) av.Muxer {
+			return mp4.NewMuxer(w)
+		}, func(r io.ReadSeeker) stateDemuxer {
+			demuxer := mp4.NewDemuxer(r)
+			return stateDemuxer{demuxer, func() (interface{}, error) {
+				return demuxer.SaveState()
+			}, func(state interface{}) error {
+				return demuxer.RestoreState(state.(mp4.State))
+			}}
+		}},
+		{".flv", func(w io.WriteSeeker) av.Muxer {
+			return flv.NewMuxer(w)
+		}, func(r io.ReadSeeker) stateDemuxer {
+			demuxer := flv.NewDemuxer(r)
+			demuxer.AudioClock = pktque.AudioClockSamples
+			return stateDemuxer{demuxer, func() (interface{}, error) {
+				return demuxer.SaveState()
+			}, func(state interface{}) error {
+				return demuxer.RestoreState(state.(flv.State))
+			}}
+		}},
+	} {
+		name := filepath.Join(t.TempDir(), "file"+test.ext)
+		file, err := os.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		writePackets(t, test.newMuxer(file), streams, pkts)
+		file.Close()
+
+		readAll := func(demuxer stateDemuxer) (pkts []av.Packet, state interface{}) {
+			for {
+				pkt, err := demuxer.ReadPacket()
+				if err == io.EOF {
+					break
+				} else if err != nil {
+					t.Fatalf("%s: %v", test.ext, err)
+				}
+				pkts = append(pkts, pkt)
+			}
+			if state, err = demuxer.save(); err != nil {
+				t.Fatalf("%s: %v", test.ext, err)
+			}
+			return
+		}
+
+		// the first demuxer saves its state part way and reads on, the second
+		// restores it and must read the same packets
+		first, _ := os.Open(name)
+		defer first.Close()
+		a := test.newDemuxer(first)
+		for i := 0; i < 101; i++ {
+			if _, err = a.ReadPacket(); err != nil {
+				t.Fatalf("%s: %v", test.ext, err)
+			}
+		}
+		state, err := a.save()
+		if err != nil {
+			t.Fatalf("%s: %v", test.ext, err)
+		}
+		want, wantState := readAll(a)
+
+		second, _ := os.Open(name)
+		defer second.Close()
+		b := test.newDemuxer(second)
+		if err = b.restore(state); err != nil {
+			t.Fatalf("%s: %v", test.ext, err)
+		}
+		got, gotState := readAll(b)
+		if len(want) == 0 {
+			t.Fatalf("%s: no packets after the saved state", test.ext)
+		}
+		if err = avutil.ComparePackets(streams, want, got, 0); err != nil {
+			t.Errorf("%s: %v", test.ext, err)
+		}
+		if !reflect.DeepEqual(gotState, wantState) {
+			t.Errorf("%s: state %+v, want %+v", test.ext, gotState, wantState)
+		}
+	}
+}
+
+func TestMP4Fork(t *testing.T) {
+	streams, pkts := testPackets(t, 300)
+	name := filepath.Join(t.TempDir(), "file.mp4")
+	file, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePackets(t, mp4.NewMuxer(file), streams, pkts)
+	file.Close()
+
+	if file, err = os.Open(name); err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	parent := mp4.NewDemuxer(file)
+	parent.OnUnknown = func(string, int64, int64) {}
+	for i := 0; i < 101; i++ {
+		if _, err = parent.ReadPacket(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pos, _ := file.Seek(0, io.SeekCurrent)
+	fork, err := parent.Fork()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if now, _ := file.Seek(0, io.SeekCurrent); now != pos {
+		t.Errorf("fork moved the source from %d to %d", pos, now)
+	}
+	if fork.OnUnknown == nil {
+		t.Error("OnUnknown not copied")
+	}
+
+	// both go on from the same position, each on its own
+	readAll := func(demuxer *mp4.Demuxer) (pkts []av.Packet, state mp4.State) {
+		for {
+			pkt, err := demuxer.ReadPacket()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			pkts = append(pkts, pkt)
+		}
+		if state, err = demuxer.SaveState(); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, wantState := readAll(parent)
+	got, gotState := readAll(fork)
+	if len(want) != len(pkts)-101 {
+		t.Fatalf("%d packets after the fork, want %d", len(want), len(pkts)-101)
+	}
+	if err = avutil.ComparePackets(streams, want, got, 0); err != nil {
+		t.Error(err)
+	}
+	if !reflect.DeepEqual(gotState, wantState) {
+		t.Errorf("fork state %+v, want %+v", gotState, wantState)
+	}
+}
+
+func TestOpenHTTPMP4(t *testing.T) {
+	streams, pkts := testPackets(t, 100)
+	name := filepath.Join(t.TempDir(), "file.mp4")
+	file, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePackets(t, mp4.NewMuxer(file), streams, pkts)
+	file.Close()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		http.ServeFile(w, req, name)
+	}))
+	defer server.Close()
+
+	handlers := &avutil.Handlers{}
+	handlers.Add(mp4.Handler)
+	// streamed, the moov at the end cannot be read
+	if demuxer, err := handlers.Open(server.URL + "/file.mp4"); err == nil {
+		if _, err = demuxer.Streams(); err == nil {
+			t.Error("streamed mp4 opened")
+		}
+		demuxer.Close()
+	}
+
+	if handlers.HTTPCache, err = rangecache.NewCache(t.TempDir(), 0); err != nil {
+		t.Fatal(err)
+	}
+	demuxer, err := handlers.Open(server.URL + "/file.mp4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer demuxer.Close()
+	var got []av.Packet
+	for {
+		pkt, err := demuxer.ReadPacket()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, pkt)
+	}
+	if err = avutil.ComparePackets(streams, pkts, got, time.Millisecond); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSalvageCorrupted(t *testing.T) {
+	streams, pkts := testPackets(t, 300)
+	for _, test := range []struct {
+		name string
+		ext  string
+		// corrupt damages a copy of the file, the bytes from to to must be
+		// skipped
+		corrupt func(b []byte) (damaged []byte, from, to int)
+		whole   bool // every packet is still read
+		tail    bool // the packets at the end are lost
+	}{
+		{"ts garbage", ".ts", func(b []byte) ([]byte, int, int) {
+			for i := 188*200 + 5; i < 188*210+20; i++ {
+				b[i] = 0xff
+			}
+			// the first packet keeps its header
+			return b, 188 * 201, 188 * 211
+		}, false, false},
+		{"ts cut", ".ts", func(b []byte) ([]byte, int, int) {
+			b = append(b[:188*200+100], b[188*230:]...)
+			return b, 188*200 + 188, 188*200 + 288
+		}, false, false},
+		{"ts truncated", ".ts", func(b []byte) ([]byte, int, int) {
+			b = b[:len(b)-100]
+			return b, len(b) / 188 * 188, len(b)
+		}, false, true},
+		{"flv garbage", ".flv", func(b []byte) ([]byte, int, int) {
+			for i := len(b) / 2; i < len(b)/2+3000; i++ {
+				b[i] = 0xee
+			}
+			return b, len(b)/2 + 3000, len(b)/2 + 3000
+		}, false, false},
+		{"flv header", ".flv", func(b []byte) ([]byte, int, int) {
+			b[0] = 'X'
+			return b, 0, flvio.FileHeaderLength
+		}, true, false},
+	} {
+		var out bytes.Buffer
+		var muxer av.Muxer
+		var newDemuxer func(r io.Reader) av.Demuxer
+		if test.ext == ".ts" {
+			muxer = ts.NewMuxer(&out)
+			newDemuxer = func(r io.Reader) av.Demuxer { return ts.NewDemuxer(r) }
+		} else {
+			muxer = flv.NewMuxer(&out)
+			newDemuxer = func(r io.Reader) av.Demuxer { return flv.NewDemuxer(r) }
+		}
+		writePackets(t, muxer, streams, pkts)
+		var clean, got []av.Packet
+		cleanResult, err := avutil.Salvage(&packetsMuxer{pkts: &clean}, newDemuxer(bytes.NewReader(out.Bytes())), avutil.SalvageOptions{})
+		if err != nil || cleanResult.Err != nil || len(cleanResult.Skipped) != 0 {
+			t.Fatalf("%s: clean file %+v: %v", test.name, cleanResult, err)
+		}
+
+		b, from, to := test.corrupt(append([]byte(nil), out.Bytes()...))
+		result, err := avutil.Salvage(&packetsMuxer{pkts: &got}, newDemuxer(bytes.NewReader(b)), avutil.SalvageOptions{})
+		if err != nil || result.Err != nil {
+			t.Errorf("%s: %v %v", test.name, err, result.Err)
+			continue
+		}
+		covered := false
+		for _, region := range result.Skipped {
+			if region.Offset <= int64(from) && region.Offset+region.Length >= int64(to) {
+				covered = true
+			}
+		}
+		if !covered {
+			t.Errorf("%s: skipped %+v, want %d to %d", test.name, result.Skipped, from, to)
+		}
+		if test.whole != (len(got) == len(clean)) || len(got) < len(clean)*3/4 {
+			t.Errorf("%s: %d of %d packets", test.name, len(got), len(clean))
+		}
+		// reading goes on after the damage
+		last, want := got[len(got)-1], clean[len(clean)-1]
+		if !test.tail && (last.Time != want.Time || !bytes.Equal(last.Data, want.Data)) {
+			t.Errorf("%s: last packet at %v, want %v", test.name, last.Time, want.Time)
+		}
+		breaks := 0
+		for _, pkt := range got {
+			if pkt.Flags.Has(av.PacketDiscontinuity) {
+				breaks++
+			}
+		}
+		// nothing is read after the damage at the end or before the
+		// damaged header
+		if want := !test.whole && !test.tail; (breaks > 0) != want {
+			t.Errorf("%s: %d discontinuities", test.name, breaks)
+		}
+	}
+}
+
+// packetsMuxer records the packets written.
+type packetsMuxer struct {
+	pkts *[]av.Packet
+}
+
+func (self *packetsMuxer) WriteHeader(streams []av.CodecData) error {
+	return nil
+}
+
+func (self *packetsMuxer) WritePacket(pkt av.Packet) error {
+	*self.pkts = append(*self.pkts, pkt)
+	return nil
+}
+
+func (self *packetsMuxer) WriteTrailer() error {
+	return nil
+}
@@ -59,6 +59,7 @@ type Server struct {
 	HandlePublish func(*Conn)
 	HandlePlay    func(*Conn)
 	HandleConn    func(*Conn)
+	ReadTimeout   time.Duration // of the accepted connections
 }
 
 func (self *Server) handleConn(conn *Conn) (err error) {
@@ -113,6 +114,7 @@ func (self *Server) ListenAndServe() (err error) {
 		}
 
 		conn := NewConn(netconn)
+		conn.ReadTimeout = self.ReadTimeout
 		conn.isserver = true
 		go func() {
 			err := self.handleConn(conn)
@@ -135,6 +137,7 @@ const (
 )
 
 type Conn struct {
+	ReadTimeout         time.Duration // deadline of each read, none if zero
 	chunkHeaderBuf      []byte
 	chunkHeaderBufExt   []byte
 	URL                 *url.URL
@@ -198,7 +201,7 @@ func NewConn(netconn net.Conn) *Conn {
 	conn.readcsmap = make(map[uint32]*chunkStream)
 	conn.readMaxChunkSize = 128
 	conn.writeMaxChunkSize = 128
-	conn.bufr = bufio.NewReaderSize(netconn, pio.RecommendBufioSize)
+	conn.bufr = bufio.NewReaderSize(timeoutReader{conn}, pio.RecommendBufioSize)
 	conn.bufw = bufio.NewWriterSize(netconn, pio.RecommendBufioSize)
 	conn.txrxcount = &txrxcount{ReadWriter: netconn}
 	conn.writebuf = make([]byte, 4096)
@@ -208,6 +211,18 @@ func NewConn(netconn net.Conn) *Conn {
 	return conn
 }
 
+// timeoutReader reads the connection with the ReadTimeout of conn.
+type timeoutReader struct {
+	conn *Conn
+}
+
+func (self timeoutReader) Read(b []byte) (int, error) {
+	if self.conn.ReadTimeout > 0 {
+		self.conn.netconn.SetReadDeadline(time.Now().Add(self.conn.ReadTimeout))
+	}
+	return self.conn.netconn.Read(b)
+}
+
 type chunkStream struct {
 	timenow     uint32
 	timedelta   uint32
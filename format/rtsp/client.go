@@ -699,6 +699,9 @@ func (self *Client) Describe() (streams []sdp.Media, err error) {
 
 	self.streams = []*Stream{}
 	for _, media := range medias {
+		if media.AVType != "audio" && media.AVType != "video" {
+			continue
+		}
 		stream := &Stream{Sdp: media, client: self}
 		if err = stream.makeCodecData(); err != nil && DebugRtsp {
 			fmt.Println("rtsp: makeCodecData error", err)
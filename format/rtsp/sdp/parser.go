@@ -31,6 +31,7 @@ type Media struct {
 	PayloadType        int
 	SizeLength         int
 	IndexLength        int
+	Direction          string // sendonly for an ONVIF audio backchannel
 }
 
 func Parse(content string) (sess Session, medias []Media) {
@@ -50,7 +51,7 @@ func Parse(content string) (sess Session, medias []Media) {
 			case "m":
 				if len(fields) > 0 {
 					switch fields[0] {
-					case "audio", "video":
+					case "audio", "video", "application":
 						medias = append(medias, Media{AVType: fields[0]})
 						media = &medias[len(medias)-1]
 						mfields := strings.Split(fields[1], " ")
@@ -74,6 +75,10 @@ func Parse(content string) (sess Session, medias []Media) {
 			case "a":
 				if media != nil {
 					for _, field := range fields {
+						switch field {
+						case "sendonly", "recvonly", "sendrecv", "inactive":
+							media.Direction = field
+						}
 						keyval := strings.SplitN(field, ":", 2)
 						if len(keyval) >= 2 {
 							key := keyval[0]
@@ -114,6 +119,8 @@ func Parse(content string) (sess Session, medias []Media) {
 								media.Type = av.PCM_ALAW
 							case "PCMU":
 								media.Type = av.PCM_MULAW
+							case "VND.ONVIF.METADATA":
+								media.Type = av.ONVIF_METADATA
 							}
 							if i, err := strconv.Atoi(keyval[1]); err == nil {
 								media.TimeScale = i
@@ -16,6 +16,7 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/deepch/vdk/av"
@@ -29,11 +30,13 @@ import (
 const (
 	SignalStreamRTPStop = iota
 	SignalCodecUpdate
+	SignalStreamStalled // sent before SignalStreamRTPStop
 )
 
 const (
-	VIDEO = "video"
-	AUDIO = "audio"
+	VIDEO       = "video"
+	AUDIO       = "audio"
+	APPLICATION = "application"
 )
 
 const (
@@ -43,11 +46,12 @@ const (
 )
 
 const (
-	DESCRIBE = "DESCRIBE"
-	OPTIONS  = "OPTIONS"
-	PLAY     = "PLAY"
-	SETUP    = "SETUP"
-	TEARDOWN = "TEARDOWN"
+	DESCRIBE      = "DESCRIBE"
+	OPTIONS       = "OPTIONS"
+	PLAY          = "PLAY"
+	SETUP         = "SETUP"
+	TEARDOWN      = "TEARDOWN"
+	GET_PARAMETER = "GET_PARAMETER"
 )
 
 type RTSPClient struct {
@@ -89,6 +93,10 @@ type RTSPClient struct {
 	PreAudioTS          int64
 	PreVideoTS          int64
 	PreSequenceNumber   int
+	videoLost           bool // the next video packet misses RTP packets
+	recoveryPoint       bool // a recovery point SEI came before the next video packet
+	preAudioSequence    int
+	preAudioDuration    time.Duration
 	FPS                 int
 	WaitCodec           bool
 	chTMP               int
@@ -96,6 +104,13 @@ type RTSPClient struct {
 	sequenceNumber      int
 	end                 int
 	offset              int
+	metadataID          int
+	metadataIDX         int8
+	metadata            []byte
+	metadataDrop        bool // the document went over MaxMetadataSize
+	backchannel         backchannel
+	writeLock           sync.Mutex
+	onClose             func()
 }
 
 type RTSPClientOptions struct {
@@ -106,9 +121,18 @@ type RTSPClientOptions struct {
 	DisableAudio       bool
 	OutgoingProxy      bool
 	InsecureSkipVerify bool
+	AudioGapMarkers    bool
+	StallTimeout       time.Duration // without media while the connection is alive, none if zero
+	Metadata           bool          // receive the ONVIF metadata stream as an av.ONVIF_METADATA stream
+	Backchannel        bool          // ask for the ONVIF audio backchannel, see WriteBackchannel
 }
 
 func Dial(options RTSPClientOptions) (*RTSPClient, error) {
+	return dial(options, nil)
+}
+
+// dial lets prepare set up the client before the first request.
+func dial(options RTSPClientOptions, prepare func(client *RTSPClient)) (*RTSPClient, error) {
 	client := &RTSPClient{
 		headers:             make(map[string]string),
 		Signals:             make(chan int, 100),
@@ -119,14 +143,23 @@ func Dial(options RTSPClientOptions) (*RTSPClient, error) {
 		audioID:             -2,
 		videoIDX:            -1,
 		audioIDX:            -2,
+		metadataID:          -3,
+		metadataIDX:         -3,
+		backchannel:         backchannel{id: -4},
 		options:             options,
 		AudioTimeScale:      8000,
 	}
 	client.headers["User-Agent"] = "Lavf58.76.100"
+	if options.Backchannel {
+		client.headers["Require"] = RequireBackchannel
+	}
 	err := client.parseURL(html.UnescapeString(client.options.URL))
 	if err != nil {
 		return nil, err
 	}
+	if prepare != nil {
+		prepare(client)
+	}
 	conn, err := net.DialTimeout("tcp", client.pURL.Host, client.options.DialTimeout)
 	if err != nil {
 		return nil, err
@@ -154,6 +187,11 @@ func Dial(options RTSPClientOptions) (*RTSPClient, error) {
 		return nil, err
 	}
 	for _, i2 := range client.mediaSDP {
+		if handled, err := client.setupONVIF(i2, nil); err != nil {
+			return nil, err
+		} else if handled {
+			continue
+		}
 		if (i2.AVType != VIDEO && i2.AVType != AUDIO) || (client.options.DisableAudio && i2.AVType == AUDIO) {
 			//TODO check it
 			if strings.Contains(string(client.SDPRaw), "LaunchDigital") {
@@ -165,77 +203,7 @@ func Dial(options RTSPClientOptions) (*RTSPClient, error) {
 		if err != nil {
 			return nil, err
 		}
-		if i2.AVType == VIDEO {
-			if i2.Type == av.H264 {
-				if len(i2.SpropParameterSets) > 1 {
-					if codecData, err := h264parser.NewCodecDataFromSPSAndPPS(i2.SpropParameterSets[0], i2.SpropParameterSets[1]); err == nil {
-						client.sps = i2.SpropParameterSets[0]
-						client.pps = i2.SpropParameterSets[1]
-						client.CodecData = append(client.CodecData, codecData)
-					}
-				} else {
-					client.CodecData = append(client.CodecData, h264parser.CodecData{})
-					client.WaitCodec = true
-				}
-				client.FPS = i2.FPS
-				client.videoCodec = av.H264
-			} else if i2.Type == av.H265 {
-				if len(i2.SpropVPS) > 1 && len(i2.SpropSPS) > 1 && len(i2.SpropPPS) > 1 {
-					if codecData, err := h265parser.NewCodecDataFromVPSAndSPSAndPPS(i2.SpropVPS, i2.SpropSPS, i2.SpropPPS); err == nil {
-						client.vps = i2.SpropVPS
-						client.sps = i2.SpropSPS
-						client.pps = i2.SpropPPS
-						client.CodecData = append(client.CodecData, codecData)
-					}
-				} else {
-					client.CodecData = append(client.CodecData, h265parser.CodecData{})
-				}
-				client.videoCodec = av.H265
-
-			} else {
-				client.Println("SDP Video Codec Type Not Supported", i2.Type)
-			}
-			client.videoIDX = int8(len(client.CodecData) - 1)
-			client.videoID = client.chTMP
-		}
-		if i2.AVType == AUDIO {
-			client.audioID = client.chTMP
-			var CodecData av.AudioCodecData
-			switch i2.Type {
-			case av.AAC:
-				CodecData, err = aacparser.NewCodecDataFromMPEG4AudioConfigBytes(i2.Config)
-				if err == nil {
-					client.Println("Audio AAC bad config")
-				}
-			case av.OPUS:
-				var cl av.ChannelLayout
-				switch i2.ChannelCount {
-				case 1:
-					cl = av.CH_MONO
-				case 2:
-					cl = av.CH_STEREO
-				default:
-					cl = av.CH_MONO
-				}
-				CodecData = codec.NewOpusCodecData(i2.TimeScale, cl)
-			case av.PCM_MULAW:
-				CodecData = codec.NewPCMMulawCodecData()
-			case av.PCM_ALAW:
-				CodecData = codec.NewPCMAlawCodecData()
-			case av.PCM:
-				CodecData = codec.NewPCMCodecData()
-			default:
-				client.Println("Audio Codec", i2.Type, "not supported")
-			}
-			if CodecData != nil {
-				client.CodecData = append(client.CodecData, CodecData)
-				client.audioIDX = int8(len(client.CodecData) - 1)
-				client.audioCodec = CodecData.Type()
-				if i2.TimeScale != 0 {
-					client.AudioTimeScale = int64(i2.TimeScale)
-				}
-			}
-		}
+		client.setupCodec(i2)
 		client.chTMP += 2
 	}
 	//test := map[string]string{"Scale": "1.000000", "Speed": "1.000000", "Range": "clock=20210929T210000Z-20210929T211000Z"}
@@ -258,10 +226,16 @@ func ReplayDial(options RTSPClientOptions, startTime string) (*RTSPClient, error
 		audioID:             -2,
 		videoIDX:            -1,
 		audioIDX:            -2,
+		metadataID:          -3,
+		metadataIDX:         -3,
+		backchannel:         backchannel{id: -4},
 		options:             options,
 		AudioTimeScale:      8000,
 	}
 	client.headers["User-Agent"] = "Lavf58.76.100"
+	if options.Backchannel {
+		client.headers["Require"] = RequireBackchannel
+	}
 	err := client.parseURL(html.UnescapeString(client.options.URL))
 	if err != nil {
 		return nil, err
@@ -293,6 +267,11 @@ func ReplayDial(options RTSPClientOptions, startTime string) (*RTSPClient, error
 		return nil, err
 	}
 	for _, i2 := range client.mediaSDP {
+		if handled, err := client.setupONVIF(i2, map[string]string{"Require": "onvif-replay"}); err != nil {
+			return nil, err
+		} else if handled {
+			continue
+		}
 		if (i2.AVType != VIDEO && i2.AVType != AUDIO) || (client.options.DisableAudio && i2.AVType == AUDIO) {
 			//TODO check it
 			if strings.Contains(string(client.SDPRaw), "LaunchDigital") {
@@ -305,77 +284,7 @@ func ReplayDial(options RTSPClientOptions, startTime string) (*RTSPClient, error
 		if err != nil {
 			return nil, err
 		}
-		if i2.AVType == VIDEO {
-			if i2.Type == av.H264 {
-				if len(i2.SpropParameterSets) > 1 {
-					if codecData, err := h264parser.NewCodecDataFromSPSAndPPS(i2.SpropParameterSets[0], i2.SpropParameterSets[1]); err == nil {
-						client.sps = i2.SpropParameterSets[0]
-						client.pps = i2.SpropParameterSets[1]
-						client.CodecData = append(client.CodecData, codecData)
-					}
-				} else {
-					client.CodecData = append(client.CodecData, h264parser.CodecData{})
-					client.WaitCodec = true
-				}
-				client.FPS = i2.FPS
-				client.videoCodec = av.H264
-			} else if i2.Type == av.H265 {
-				if len(i2.SpropVPS) > 1 && len(i2.SpropSPS) > 1 && len(i2.SpropPPS) > 1 {
-					if codecData, err := h265parser.NewCodecDataFromVPSAndSPSAndPPS(i2.SpropVPS, i2.SpropSPS, i2.SpropPPS); err == nil {
-						client.vps = i2.SpropVPS
-						client.sps = i2.SpropSPS
-						client.pps = i2.SpropPPS
-						client.CodecData = append(client.CodecData, codecData)
-					}
-				} else {
-					client.CodecData = append(client.CodecData, h265parser.CodecData{})
-				}
-				client.videoCodec = av.H265
-
-			} else {
-				client.Println("SDP Video Codec Type Not Supported", i2.Type)
-			}
-			client.videoIDX = int8(len(client.CodecData) - 1)
-			client.videoID = client.chTMP
-		}
-		if i2.AVType == AUDIO {
-			client.audioID = client.chTMP
-			var CodecData av.AudioCodecData
-			switch i2.Type {
-			case av.AAC:
-				CodecData, err = aacparser.NewCodecDataFromMPEG4AudioConfigBytes(i2.Config)
-				if err == nil {
-					client.Println("Audio AAC bad config")
-				}
-			case av.OPUS:
-				var cl av.ChannelLayout
-				switch i2.ChannelCount {
-				case 1:
-					cl = av.CH_MONO
-				case 2:
-					cl = av.CH_STEREO
-				default:
-					cl = av.CH_MONO
-				}
-				CodecData = codec.NewOpusCodecData(i2.TimeScale, cl)
-			case av.PCM_MULAW:
-				CodecData = codec.NewPCMMulawCodecData()
-			case av.PCM_ALAW:
-				CodecData = codec.NewPCMAlawCodecData()
-			case av.PCM:
-				CodecData = codec.NewPCMCodecData()
-			default:
-				client.Println("Audio Codec", i2.Type, "not supported")
-			}
-			if CodecData != nil {
-				client.CodecData = append(client.CodecData, CodecData)
-				client.audioIDX = int8(len(client.CodecData) - 1)
-				client.audioCodec = CodecData.Type()
-				if i2.TimeScale != 0 {
-					client.AudioTimeScale = int64(i2.TimeScale)
-				}
-			}
-		}
+		client.setupCodec(i2)
 		client.chTMP += 2
 	}
 	test := map[string]string{"Require": "onvif-replay", "Scale": "1.000000", "Speed": "1.000000", "Range": "clock=" + startTime + "-"}
@@ -387,6 +296,91 @@ func ReplayDial(options RTSPClientOptions, startTime string) (*RTSPClient, error
 	return client, nil
 }
 
+// setupCodec makes the codec of a video or audio track set up on channel
+// chTMP.
+func (client *RTSPClient) setupCodec(i2 sdp.Media) {
+	var err error
+	if i2.AVType == VIDEO {
+		if i2.Type == av.H264 {
+			if len(i2.SpropParameterSets) > 1 {
+				if codecData, err := h264parser.NewCodecDataFromSPSAndPPS(i2.SpropParameterSets[0], i2.SpropParameterSets[1]); err == nil {
+					client.sps = i2.SpropParameterSets[0]
+					client.pps = i2.SpropParameterSets[1]
+					client.CodecData = append(client.CodecData, codecData)
+				}
+			} else {
+				client.CodecData = append(client.CodecData, h264parser.CodecData{})
+				client.WaitCodec = true
+			}
+			client.FPS = i2.FPS
+			client.videoCodec = av.H264
+		} else if i2.Type == av.H265 {
+			if len(i2.SpropVPS) > 1 && len(i2.SpropSPS) > 1 && len(i2.SpropPPS) > 1 {
+				if codecData, err := h265parser.NewCodecDataFromVPSAndSPSAndPPS(i2.SpropVPS, i2.SpropSPS, i2.SpropPPS); err == nil {
+					client.vps = i2.SpropVPS
+					client.sps = i2.SpropSPS
+					client.pps = i2.SpropPPS
+					client.CodecData = append(client.CodecData, codecData)
+				}
+			} else {
+				client.CodecData = append(client.CodecData, h265parser.CodecData{})
+			}
+			client.videoCodec = av.H265
+
+		} else {
+			client.Println("SDP Video Codec Type Not Supported", i2.Type)
+		}
+		client.videoIDX = int8(len(client.CodecData) - 1)
+		client.videoID = client.chTMP
+	}
+	if i2.AVType == AUDIO {
+		client.audioID = client.chTMP
+		var CodecData av.AudioCodecData
+		switch i2.Type {
+		case av.AAC:
+			CodecData, err = aacparser.NewCodecDataFromMPEG4AudioConfigBytes(i2.Config)
+			if err == nil {
+				client.Println("Audio AAC bad config")
+			}
+		case av.OPUS:
+			var cl av.ChannelLayout
+			switch i2.ChannelCount {
+			case 1:
+				cl = av.CH_MONO
+			case 2:
+				cl = av.CH_STEREO
+			default:
+				cl = av.CH_MONO
+			}
+			CodecData = codec.NewOpusCodecData(i2.TimeScale, cl)
+		case av.PCM_MULAW:
+			CodecData = codec.NewPCMMulawCodecData()
+		case av.PCM_ALAW:
+			CodecData = codec.NewPCMAlawCodecData()
+		case av.PCM:
+			// L16 is big endian
+			rate, cl := i2.TimeScale, av.CH_MONO
+			if rate == 0 {
+				rate = 8000
+			}
+			if i2.ChannelCount == 2 {
+				cl = av.CH_STEREO
+			}
+			CodecData = codec.NewLinearPCMCodecData(av.S16, rate, cl, true)
+		default:
+			client.Println("Audio Codec", i2.Type, "not supported")
+		}
+		if CodecData != nil {
+			client.CodecData = append(client.CodecData, CodecData)
+			client.audioIDX = int8(len(client.CodecData) - 1)
+			client.audioCodec = CodecData.Type()
+			if i2.TimeScale != 0 {
+				client.AudioTimeScale = int64(i2.TimeScale)
+			}
+		}
+	}
+}
+
 func (client *RTSPClient) ControlTrack(track string) string {
 	if strings.Contains(track, "rtsp://") {
 		return track
@@ -402,6 +396,7 @@ func (client *RTSPClient) startStream() {
 		client.Signals <- SignalStreamRTPStop
 	}()
 	timer := time.Now()
+	media := time.Now()
 	oneb := make([]byte, 1)
 	header := make([]byte, 4)
 	var fixed bool
@@ -411,6 +406,11 @@ func (client *RTSPClient) startStream() {
 			client.Println("RTSP Client RTP SetDeadline", err)
 			return
 		}
+		if client.options.StallTimeout > 0 && time.Since(media) > client.options.StallTimeout {
+			client.Println("RTSP Client RTP stalled", time.Since(media))
+			client.Signals <- SignalStreamStalled
+			return
+		}
 		if int(time.Now().Sub(timer).Seconds()) > 25 {
 			err := client.request(OPTIONS, map[string]string{"Require": "implicit-play"}, client.control, false, true)
 			if err != nil {
@@ -449,6 +449,7 @@ func (client *RTSPClient) startStream() {
 			if client.options.OutgoingProxy {
 				if len(client.OutgoingProxyQueue) < 2000 {
 					client.OutgoingProxyQueue <- &content
+					media = time.Now()
 				} else {
 					client.Println("RTSP Client OutgoingProxy Chanel Full")
 					return
@@ -465,6 +466,7 @@ func (client *RTSPClient) startStream() {
 					return
 				}
 				client.OutgoingPacketQueue <- i2
+				media = time.Now()
 			}
 		case 0x52:
 			var responseTmp []byte
@@ -513,20 +515,27 @@ func (client *RTSPClient) request(method string, customHeaders map[string]string
 	}
 	if customHeaders != nil {
 		for k, v := range customHeaders {
+			if own, ok := client.headers[k]; ok {
+				v += ", " + own
+			}
 			builder.WriteString(fmt.Sprintf("%s: %s\r\n", k, v))
 		}
 	}
 	for k, v := range client.headers {
+		if _, ok := customHeaders[k]; ok {
+			continue
+		}
 		builder.WriteString(fmt.Sprintf("%s: %s\r\n", k, v))
 	}
 	builder.WriteString(fmt.Sprintf("\r\n"))
 	client.Println(builder.String())
 	s := builder.String()
+	client.writeLock.Lock()
 	_, err = client.connRW.WriteString(s)
-	if err != nil {
-		return
+	if err == nil {
+		err = client.connRW.Flush()
 	}
-	err = client.connRW.Flush()
+	client.writeLock.Unlock()
 	if err != nil {
 		return
 	}
@@ -652,6 +661,10 @@ func (client *RTSPClient) Close() {
 		err := client.conn.Close()
 		client.Println("RTSP Client Close", err)
 	}
+	if client.onClose != nil {
+		client.onClose()
+		client.onClose = nil
+	}
 }
 
 func (client *RTSPClient) parseURL(rawURL string) error {
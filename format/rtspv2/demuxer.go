@@ -61,6 +61,8 @@ func (client *RTSPClient) RTPDemuxer(payloadRAW *[]byte) ([]*av.Packet, bool) {
 		return client.handleVideo(content)
 	case client.audioID:
 		return client.handleAudio(content)
+	case client.metadataID:
+		return client.handleMetadata(content)
 	}
 	return nil, false
 }
@@ -79,6 +81,7 @@ func (client *RTSPClient) handleVideo(content []byte) ([]*av.Packet, bool) {
 	}
 	if client.PreSequenceNumber != 0 && client.sequenceNumber-client.PreSequenceNumber != 1 {
 		client.Println("drop packet", client.sequenceNumber-1)
+		client.videoLost = true
 	}
 	client.PreSequenceNumber = client.sequenceNumber
 	if client.BufferRtpPacket.Len() > 4048576 {
@@ -115,6 +118,8 @@ func (client *RTSPClient) handleH264Payload(content, nal []byte, retmap []*av.Pa
 		client.CodecUpdateSPS(nal)
 	case naluType == h264parser.NALU_PPS:
 		client.CodecUpdatePPS(nal)
+	case naluType == h264parser.NALU_SEI:
+		client.updateRecoveryPoint(nal)
 	case naluType == 24:
 		packet := nal[1:]
 		for len(packet) >= 2 {
@@ -130,6 +135,8 @@ func (client *RTSPClient) handleH264Payload(content, nal []byte, retmap []*av.Pa
 				client.CodecUpdateSPS(packet[2 : size+2])
 			case naluTypefs == h264parser.NALU_PPS:
 				client.CodecUpdatePPS(packet[2 : size+2])
+			case naluTypefs == h264parser.NALU_SEI:
+				client.updateRecoveryPoint(packet[2 : size+2])
 			}
 			packet = packet[size+2:]
 		}
@@ -165,7 +172,9 @@ func (client *RTSPClient) handleH264Payload(content, nal []byte, retmap []*av.Pa
 						}
 					}
 				}
-				retmap = client.appendVideoPacket(retmap, client.BufferRtpPacket.Bytes(), naluTypef == 5)
+				if !h264parser.IsLayerNALU(client.BufferRtpPacket.Bytes()) {
+					retmap = client.appendVideoPacket(retmap, client.BufferRtpPacket.Bytes(), naluTypef == 5)
+				}
 			}
 		}
 	default:
@@ -214,8 +223,21 @@ func (client *RTSPClient) handleAudio(content []byte) ([]*av.Packet, bool) {
 	if client.PreAudioTS == 0 {
 		client.PreAudioTS = client.timestamp
 	}
-	nalRaw, _ := h264parser.SplitNALUs(content[client.offset:client.end])
 	var retmap []*av.Packet
+	if gap := client.audioGap(); gap > 0 {
+		client.Println("audio gap", gap)
+		client.AudioTimeLine += gap
+		if client.options.AudioGapMarkers {
+			retmap = append(retmap, &av.Packet{
+				Idx:      client.audioIDX,
+				Flags:    av.PacketGap,
+				Duration: gap,
+				Time:     client.AudioTimeLine,
+			})
+		}
+	}
+	start := client.AudioTimeLine
+	nalRaw, _ := h264parser.SplitNALUs(content[client.offset:client.end])
 	for _, nal := range nalRaw {
 		var duration time.Duration
 		switch client.audioCodec {
@@ -245,13 +267,38 @@ func (client *RTSPClient) handleAudio(content []byte) ([]*av.Packet, bool) {
 			}
 		}
 	}
-	if len(retmap) > 0 {
+	if client.AudioTimeLine > start {
+		client.preAudioDuration = client.AudioTimeLine - start
 		client.PreAudioTS = client.timestamp
+	}
+	if len(retmap) > 0 {
 		return retmap, true
 	}
 	return nil, false
 }
 
+// audioGap returns the duration of audio lost between the previous and the
+// current RTP packet, zero if the sequence is contiguous or reordered.
+func (client *RTSPClient) audioGap() time.Duration {
+	lost := uint16(client.sequenceNumber-client.preAudioSequence) - 1
+	if client.preAudioDuration == 0 {
+		client.preAudioSequence = client.sequenceNumber
+		return 0
+	}
+	if lost >= 0x8000 {
+		return 0
+	}
+	client.preAudioSequence = client.sequenceNumber
+	if lost == 0 {
+		return 0
+	}
+	elapsed := time.Duration(uint32(client.timestamp-client.PreAudioTS)) * time.Second / time.Duration(client.AudioTimeScale)
+	if gap := elapsed - client.preAudioDuration; gap > 0 && gap <= time.Duration(lost+1)*client.preAudioDuration*2 {
+		return gap
+	}
+	return time.Duration(lost) * client.preAudioDuration
+}
+
 func (client *RTSPClient) appendAudioPacket(retmap []*av.Packet, nal []byte, duration time.Duration) []*av.Packet {
 	client.AudioTimeLine += duration
 	return append(retmap, &av.Packet{
@@ -265,12 +312,34 @@ func (client *RTSPClient) appendAudioPacket(retmap []*av.Packet, nal []byte, dur
 }
 
 func (client *RTSPClient) appendVideoPacket(retmap []*av.Packet, nal []byte, isKeyFrame bool) []*av.Packet {
-	return append(retmap, &av.Packet{
+	pkt := &av.Packet{
 		Data:            append(binSize(len(nal)), nal...),
 		CompositionTime: time.Duration(TimeDelay) * time.Millisecond,
 		Idx:             client.videoIDX,
 		IsKeyFrame:      isKeyFrame,
 		Duration:        time.Duration(float32(client.timestamp-client.PreVideoTS)/TimeBaseFactor) * time.Millisecond,
 		Time:            time.Duration(client.timestamp/TimeBaseFactor) * time.Millisecond,
-	})
+	}
+	if client.videoCodec == av.H265 {
+		pkt.Flags = h265parser.FrameFlags(pkt.Data)
+	} else {
+		pkt.Flags = h264parser.FrameFlags(pkt.Data)
+	}
+	if client.videoLost {
+		pkt.Flags |= av.PacketCorrupt
+		client.videoLost = false
+	}
+	if client.recoveryPoint && !isKeyFrame {
+		pkt.Flags |= av.PacketRandomAccess
+	}
+	client.recoveryPoint = false
+	return append(retmap, pkt)
+}
+
+// updateRecoveryPoint keeps the recovery point of a SEI for the next video
+// packet, since the SEI is not passed on.
+func (client *RTSPClient) updateRecoveryPoint(nal []byte) {
+	if _, ok := h264parser.FindRecoveryPoint(nal); ok {
+		client.recoveryPoint = true
+	}
 }
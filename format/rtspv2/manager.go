@@ -0,0 +1,192 @@
+package rtspv2
+
+import (
+	"encoding/base64"
+	"html"
+	"math/rand"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultMaxDialing = 2
+	DefaultMinBackoff = 2 * time.Second
+	DefaultMaxBackoff = time.Minute
+)
+
+// ConnManager dials the cameras of a supervisor running many of them. It
+// keeps the credentials of each camera, reuses the authentication
+// challenge of a host so requests are authenticated from the first one,
+// limits the connections to a host, and spreads the reconnects after a
+// network blip so cameras are not all hit at once.
+//
+// A camera waits a random delay up to MinBackoff before it reconnects,
+// and a backoff doubling up to MaxBackoff after failed attempts. Close the
+// clients returned by Dial, as that frees their connection slot.
+type ConnManager struct {
+	MaxDialing     int // handshakes at once per host, DefaultMaxDialing if zero
+	MaxConnections int // connections per host, dialing ones included, no limit if zero
+	MinBackoff     time.Duration
+	MaxBackoff     time.Duration
+
+	lock        sync.Mutex
+	cond        *sync.Cond
+	dial        func(options RTSPClientOptions, prepare func(client *RTSPClient)) (*RTSPClient, error)
+	credentials map[string]*url.Userinfo
+	cameras     map[string]*managedCamera
+	hosts       map[string]*managedHost
+}
+
+type managedCamera struct {
+	failures int
+	next     time.Time
+}
+
+type managedHost struct {
+	dialing int
+	open    int
+	realm   string
+	nonce   string
+	basic   bool
+}
+
+func NewConnManager() *ConnManager {
+	self := &ConnManager{
+		credentials: map[string]*url.Userinfo{},
+		cameras:     map[string]*managedCamera{},
+		hosts:       map[string]*managedHost{},
+		dial:        dial,
+	}
+	self.cond = sync.NewCond(&self.lock)
+	return self
+}
+
+// SetCredentials sets the user and password of a camera, they replace
+// those of its URL.
+func (self *ConnManager) SetCredentials(id string, username, password string) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.credentials[id] = url.UserPassword(username, password)
+}
+
+func (self *ConnManager) RemoveCredentials(id string) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	delete(self.credentials, id)
+}
+
+// Forget drops the reconnect state of a camera removed from the
+// supervisor.
+func (self *ConnManager) Forget(id string) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	delete(self.cameras, id)
+	delete(self.credentials, id)
+}
+
+func (self *ConnManager) maxDialing() int {
+	if self.MaxDialing > 0 {
+		return self.MaxDialing
+	}
+	return DefaultMaxDialing
+}
+
+// backoff returns a random delay up to the backoff after failures, at
+// least half of it once an attempt failed.
+func (self *ConnManager) backoff(failures int) time.Duration {
+	min, max := self.MinBackoff, self.MaxBackoff
+	if min <= 0 {
+		min = DefaultMinBackoff
+	}
+	if max <= 0 {
+		max = DefaultMaxBackoff
+	}
+	d := min
+	for i := 1; i < failures && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	if failures == 0 {
+		return time.Duration(rand.Int63n(int64(d)))
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// Dial connects to camera id, waiting for its reconnect delay and for a
+// free slot on its host.
+func (self *ConnManager) Dial(id string, options RTSPClientOptions) (client *RTSPClient, err error) {
+	var u *url.URL
+	if u, err = url.Parse(html.UnescapeString(options.URL)); err != nil {
+		return
+	}
+
+	self.lock.Lock()
+	camera := self.cameras[id]
+	if camera == nil {
+		camera = &managedCamera{}
+		self.cameras[id] = camera
+	}
+	if user := self.credentials[id]; user != nil {
+		u.User = user
+		options.URL = u.String()
+	}
+	wait := time.Until(camera.next)
+	self.lock.Unlock()
+	if wait > 0 {
+		time.Sleep(wait)
+	}
+
+	self.lock.Lock()
+	host := self.hosts[u.Host]
+	if host == nil {
+		host = &managedHost{}
+		self.hosts[u.Host] = host
+	}
+	for host.dialing >= self.maxDialing() || (self.MaxConnections > 0 && host.dialing+host.open >= self.MaxConnections) {
+		self.cond.Wait()
+	}
+	host.dialing++
+	realm, nonce, basic := host.realm, host.nonce, host.basic
+	self.lock.Unlock()
+
+	client, err = self.dial(options, func(client *RTSPClient) {
+		if client.username == "" {
+			return
+		}
+		if basic {
+			client.headers["Authorization"] = "Basic " + base64.StdEncoding.EncodeToString([]byte(client.username+":"+client.password))
+			client.clientBasic = true
+		} else if nonce != "" {
+			client.realm, client.nonce = realm, nonce
+			client.clientDigest = true
+		}
+	})
+
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	host.dialing--
+	self.cond.Broadcast()
+	if err != nil {
+		camera.failures++
+		camera.next = time.Now().Add(self.backoff(camera.failures))
+		return
+	}
+	camera.failures = 0
+	host.open++
+	if client.clientDigest {
+		host.realm, host.nonce, host.basic = client.realm, client.nonce, false
+	} else if client.clientBasic {
+		host.basic = true
+	}
+	client.onClose = func() {
+		self.lock.Lock()
+		defer self.lock.Unlock()
+		host.open--
+		camera.next = time.Now().Add(self.backoff(0))
+		self.cond.Broadcast()
+	}
+	return
+}
@@ -0,0 +1,153 @@
+package rtspv2
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"time"
+
+	"github.com/deepch/vdk/av"
+	"github.com/deepch/vdk/codec/onvif"
+	"github.com/deepch/vdk/format/rtsp/sdp"
+)
+
+const RequireBackchannel = "www.onvif.org/ver20/backchannel"
+
+// MaxMetadataSize drops a metadata document never ended by a marker bit.
+const MaxMetadataSize = 1 << 20
+
+// backchannel is the sendonly audio track of an ONVIF Profile T camera,
+// RTP packets written to it go to the camera speaker.
+type backchannel struct {
+	id          int
+	codec       av.CodecType
+	payloadType uint8
+	clockRate   uint32
+	seq         uint16
+	timestamp   uint32
+	ssrc        uint32
+}
+
+// setupONVIF sets up the metadata and the backchannel tracks when asked
+// for, it returns false for the other tracks.
+func (client *RTSPClient) setupONVIF(media sdp.Media, headers map[string]string) (handled bool, err error) {
+	switch {
+	case media.AVType == APPLICATION:
+		handled = true
+		if !client.options.Metadata || media.Type != av.ONVIF_METADATA || client.metadataID >= 0 {
+			return
+		}
+		if err = client.setupInterleaved(media, headers); err != nil {
+			return
+		}
+		client.CodecData = append(client.CodecData, onvif.CodecData{})
+		client.metadataIDX = int8(len(client.CodecData) - 1)
+		client.metadataID = client.chTMP
+		client.chTMP += 2
+	case client.isBackchannel(media) && client.backchannel.id < 0:
+		handled = true
+		if err = client.setupInterleaved(media, headers); err != nil {
+			return
+		}
+		client.backchannel = backchannel{
+			id:          client.chTMP,
+			codec:       media.Type,
+			payloadType: uint8(media.PayloadType),
+			clockRate:   uint32(media.TimeScale),
+			seq:         uint16(rand.Uint32()),
+			timestamp:   rand.Uint32(),
+			ssrc:        rand.Uint32(),
+		}
+		if client.backchannel.clockRate == 0 {
+			client.backchannel.clockRate = 8000
+		}
+		client.chTMP += 2
+	}
+	return
+}
+
+// isBackchannel reports whether media is the ONVIF audio backchannel. The
+// server only marks it sendonly once the Require header was sent, without it
+// sendonly is the normal direction of the camera audio.
+func (client *RTSPClient) isBackchannel(media sdp.Media) bool {
+	return client.options.Backchannel && media.AVType == AUDIO && media.Direction == "sendonly"
+}
+
+func (client *RTSPClient) setupInterleaved(media sdp.Media, headers map[string]string) error {
+	h := map[string]string{"Transport": "RTP/AVP/TCP;unicast;interleaved=" + strconv.Itoa(client.chTMP) + "-" + strconv.Itoa(client.chTMP+1)}
+	for k, v := range headers {
+		h[k] = v
+	}
+	return client.request(SETUP, h, client.ControlTrack(media.Control), false, false)
+}
+
+// handleMetadata collects the XML fragments of a document, the RTP marker
+// bit ends it. The rest of a document too big is dropped up to its marker.
+func (client *RTSPClient) handleMetadata(content []byte) ([]*av.Packet, bool) {
+	if !client.metadataDrop {
+		client.metadata = append(client.metadata, content[client.offset:client.end]...)
+	}
+	if len(client.metadata) > MaxMetadataSize {
+		client.Println("RTSP Client metadata too big")
+		client.metadata = nil
+		client.metadataDrop = true
+	}
+	if content[5]&0x80 == 0 {
+		return nil, false
+	}
+	if client.metadataDrop {
+		client.metadataDrop = false
+		return nil, false
+	}
+	pkt := &av.Packet{
+		Idx:        client.metadataIDX,
+		IsKeyFrame: true,
+		Data:       client.metadata,
+		Time:       time.Duration(client.timestamp/TimeBaseFactor) * time.Millisecond,
+	}
+	client.metadata = nil
+	return []*av.Packet{pkt}, true
+}
+
+// BackchannelCodec returns the codec the camera takes on its backchannel,
+// zero without one.
+func (client *RTSPClient) BackchannelCodec() av.CodecType {
+	if client.backchannel.id < 0 {
+		return 0
+	}
+	return client.backchannel.codec
+}
+
+// WriteBackchannel sends audio of duration encoded in the BackchannelCodec
+// to the camera.
+func (client *RTSPClient) WriteBackchannel(data []byte, duration time.Duration) (err error) {
+	bc := &client.backchannel
+	if bc.id < 0 {
+		err = fmt.Errorf("rtspv2: no backchannel was set up")
+		return
+	}
+	if len(data) > 65535-RTPHeaderSize {
+		err = fmt.Errorf("rtspv2: backchannel packet of %d bytes is too big", len(data))
+		return
+	}
+	b := make([]byte, 4+RTPHeaderSize+len(data))
+	b[0] = 0x24
+	b[1] = uint8(bc.id)
+	binary.BigEndian.PutUint16(b[2:], uint16(RTPHeaderSize+len(data)))
+	b[4] = 0x80
+	b[5] = bc.payloadType & 0x7f
+	client.writeLock.Lock()
+	defer client.writeLock.Unlock()
+	binary.BigEndian.PutUint16(b[6:], bc.seq)
+	binary.BigEndian.PutUint32(b[8:], bc.timestamp)
+	binary.BigEndian.PutUint32(b[12:], bc.ssrc)
+	copy(b[16:], data)
+	bc.seq++
+	bc.timestamp += uint32(int64(duration) * int64(bc.clockRate) / int64(time.Second))
+	if _, err = client.connRW.Write(b); err != nil {
+		return
+	}
+	err = client.connRW.Flush()
+	return
+}
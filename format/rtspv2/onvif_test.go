@@ -0,0 +1,202 @@
+package rtspv2
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/deepch/vdk/av"
+	"github.com/deepch/vdk/codec/onvif"
+	"github.com/deepch/vdk/format/rtsp/sdp"
+)
+
+// an object in pixels of a 640x480 frame, from an Axis camera
+const metadataDoc = `<?xml version="1.0" encoding="UTF-8"?>
+<tt:MetadataStream xmlns:tt="http://www.onvif.org/ver10/schema">
+<tt:VideoAnalytics>
+<tt:Frame UtcTime="2023-05-04T10:11:12.345Z">
+<tt:Transformation>
+<tt:Translate x="-1.0" y="1.0"/>
+<tt:Scale x="0.003125" y="-0.00416667"/>
+</tt:Transformation>
+<tt:Object ObjectId="12">
+<tt:Appearance>
+<tt:Shape>
+<tt:BoundingBox left="160.0" top="120.0" right="320.0" bottom="360.0"/>
+<tt:CenterOfGravity x="240.0" y="240.0"/>
+</tt:Shape>
+<tt:Class>
+<tt:ClassCandidate>
+<tt:Type>Human</tt:Type>
+<tt:Likelihood>0.8</tt:Likelihood>
+</tt:ClassCandidate>
+</tt:Class>
+</tt:Appearance>
+</tt:Object>
+</tt:Frame>
+</tt:VideoAnalytics>
+</tt:MetadataStream>`
+
+// metadataRTP returns an interleaved RTP packet of the metadata track on
+// channel 4.
+func metadataRTP(marker bool, timestamp uint32, payload []byte) *[]byte {
+	b := append(rtpPacket(1, 1)[:RTPHeaderSize], payload...)
+	b[1] = 107
+	if marker {
+		b[1] |= 0x80
+	}
+	binary.BigEndian.PutUint32(b[4:], timestamp)
+	return interleaved(4, b)
+}
+
+func newMetadataClient() *RTSPClient {
+	return &RTSPClient{
+		videoID:     -1,
+		audioID:     -2,
+		metadataID:  4,
+		metadataIDX: 2,
+		backchannel: backchannel{id: -4},
+	}
+}
+
+func TestHandleMetadata(t *testing.T) {
+	client := newMetadataClient()
+	doc := []byte(metadataDoc)
+	fragments := [][]byte{doc[:100], doc[100:400], doc[400:]}
+	for i, fragment := range fragments {
+		pkts, ok := client.RTPDemuxer(metadataRTP(i == len(fragments)-1, 90000, fragment))
+		if i < len(fragments)-1 {
+			if ok || len(pkts) != 0 {
+				t.Fatalf("packet out at fragment %d", i)
+			}
+			continue
+		}
+		if !ok || len(pkts) != 1 {
+			t.Fatalf("%d packets at the marker", len(pkts))
+		}
+	}
+	pkts, _ := client.RTPDemuxer(metadataRTP(true, 180000, doc))
+	pkt := pkts[0]
+	if pkt.Idx != 2 || !pkt.IsKeyFrame || pkt.Time != 2*time.Second || !bytes.Equal(pkt.Data, doc) {
+		t.Fatalf("packet idx %d keyframe %v at %v, %d bytes", pkt.Idx, pkt.IsKeyFrame, pkt.Time, len(pkt.Data))
+	}
+
+	// the document maps to normalized coordinates through its Transformation
+	metadata, err := onvif.Parse(pkt.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(metadata.Frames) != 1 || len(metadata.Frames[0].Objects) != 1 {
+		t.Fatalf("metadata %+v", metadata)
+	}
+	object := metadata.Frames[0].Objects[0]
+	box, center := object.BoundingBox, object.CenterOfGravity
+	near := func(x, y float64) bool { return x-y < 1e-5 && y-x < 1e-5 }
+	if object.ID != 12 || !near(box.Left, -0.5) || !near(box.Top, 0.5) || !near(box.Right, 0) || !near(box.Bottom, -0.5) ||
+		!near(center.X, -0.25) || !near(center.Y, 0) || len(object.Classes) != 1 || object.Classes[0] != (onvif.Class{Type: "Human", Likelihood: 0.8}) {
+		t.Errorf("object %+v", object)
+	}
+}
+
+func TestHandleMetadataTooBig(t *testing.T) {
+	client := newMetadataClient()
+	fragment := bytes.Repeat([]byte("<tt:Object/>"), 1000)
+	for size := 0; size <= MaxMetadataSize; size += len(fragment) {
+		if _, ok := client.RTPDemuxer(metadataRTP(false, 0, fragment)); ok {
+			t.Fatal("packet out without marker")
+		}
+	}
+	// the rest of the document up to its marker is dropped with it
+	client.RTPDemuxer(metadataRTP(false, 0, fragment))
+	if pkts, ok := client.RTPDemuxer(metadataRTP(true, 0, []byte("</tt:MetadataStream>"))); ok || len(pkts) != 0 {
+		t.Errorf("%d bytes out of a document too big", len(pkts[0].Data))
+	}
+	if pkts, ok := client.RTPDemuxer(metadataRTP(true, 0, []byte(metadataDoc))); !ok || len(pkts) != 1 || string(pkts[0].Data) != metadataDoc {
+		t.Error("next document not whole")
+	}
+
+	// a document of MaxMetadataSize goes through
+	doc := bytes.Repeat([]byte{'x'}, MaxMetadataSize)
+	for i := 0; i < len(doc); i += 60000 {
+		end := i + 60000
+		if end > len(doc) {
+			end = len(doc)
+		}
+		if pkts, ok := client.RTPDemuxer(metadataRTP(end == len(doc), 0, doc[i:end])); end == len(doc) && (!ok || len(pkts[0].Data) != MaxMetadataSize) {
+			t.Error("document of MaxMetadataSize dropped")
+		}
+	}
+}
+
+func TestIsBackchannel(t *testing.T) {
+	for _, test := range []struct {
+		backchannel bool
+		media       sdp.Media
+		want        bool
+	}{
+		{true, sdp.Media{AVType: AUDIO, Direction: "sendonly"}, true},
+		{false, sdp.Media{AVType: AUDIO, Direction: "sendonly"}, false},
+		{true, sdp.Media{AVType: AUDIO, Direction: "recvonly"}, false},
+		{true, sdp.Media{AVType: AUDIO}, false},
+		{true, sdp.Media{AVType: VIDEO, Direction: "sendonly"}, false},
+	} {
+		client := &RTSPClient{options: RTSPClientOptions{Backchannel: test.backchannel}}
+		if got := client.isBackchannel(test.media); got != test.want {
+			t.Errorf("%v %+v: %v", test.backchannel, test.media, got)
+		}
+	}
+}
+
+func TestWriteBackchannel(t *testing.T) {
+	var b bytes.Buffer
+	client := &RTSPClient{
+		backchannel: backchannel{id: -4},
+		connRW:      bufio.NewReadWriter(bufio.NewReader(&b), bufio.NewWriter(&b)),
+	}
+	if client.BackchannelCodec() != 0 {
+		t.Error("codec without backchannel")
+	}
+	if err := client.WriteBackchannel(make([]byte, 160), 20*time.Millisecond); err == nil {
+		t.Error("written without backchannel")
+	}
+
+	client.backchannel = backchannel{id: 6, codec: av.PCM_MULAW, payloadType: 0, clockRate: 8000, seq: 65535, timestamp: 100, ssrc: 0xdeadbeef}
+	if client.BackchannelCodec() != av.PCM_MULAW {
+		t.Errorf("codec %v", client.BackchannelCodec())
+	}
+	for _, test := range []struct {
+		size      int
+		duration  time.Duration
+		seq       uint16
+		timestamp uint32
+	}{
+		{160, 20 * time.Millisecond, 65535, 100},
+		{320, 40 * time.Millisecond, 0, 260},
+		{80, 10 * time.Millisecond, 1, 580},
+	} {
+		b.Reset()
+		data := bytes.Repeat([]byte{0xff}, test.size)
+		if err := client.WriteBackchannel(data, test.duration); err != nil {
+			t.Fatal(err)
+		}
+		pkt := b.Bytes()
+		if len(pkt) != 4+RTPHeaderSize+test.size || pkt[0] != '$' || pkt[1] != 6 || int(binary.BigEndian.Uint16(pkt[2:])) != RTPHeaderSize+test.size {
+			t.Fatalf("interleaved header %x", pkt[:4])
+		}
+		rtp := pkt[4:]
+		if rtp[0] != 0x80 || rtp[1] != 0 || binary.BigEndian.Uint16(rtp[2:]) != test.seq ||
+			binary.BigEndian.Uint32(rtp[4:]) != test.timestamp || binary.BigEndian.Uint32(rtp[8:]) != 0xdeadbeef {
+			t.Errorf("rtp header %x, want seq %d timestamp %d", rtp[:RTPHeaderSize], test.seq, test.timestamp)
+		}
+		if !bytes.Equal(rtp[RTPHeaderSize:], data) {
+			t.Error("payload differs")
+		}
+	}
+
+	if err := client.WriteBackchannel(make([]byte, 65536), time.Second); err == nil || !strings.Contains(err.Error(), "too big") {
+		t.Errorf("packet too big: %v", err)
+	}
+}
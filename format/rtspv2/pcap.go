@@ -0,0 +1,256 @@
+package rtspv2
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+// pcapSegment is the TCP or UDP payload of a captured packet.
+type pcapSegment struct {
+	time    time.Time
+	tcp     bool
+	syn     bool
+	seq     uint32
+	src     string // ip:port
+	dst     string
+	dstPort int
+	payload []byte
+}
+
+// readPcap reads the TCP and UDP payloads of a pcap or pcapng file.
+// Fragmented IP packets are dropped.
+func readPcap(r io.Reader) (segments []pcapSegment, err error) {
+	var b []byte
+	if b, err = io.ReadAll(r); err != nil {
+		return
+	}
+	if len(b) < 24 {
+		err = fmt.Errorf("rtspv2: pcap file too short")
+		return
+	}
+	add := func(linkType int, tm time.Time, frame []byte) {
+		if seg, ok := decodeFrame(linkType, frame); ok {
+			seg.time = tm
+			segments = append(segments, seg)
+		}
+	}
+	switch binary.LittleEndian.Uint32(b) {
+	case 0x0a0d0d0a:
+		err = readPcapNG(b, add)
+	case 0xa1b2c3d4, 0xd4c3b2a1, 0xa1b23c4d, 0x4d3cb2a1:
+		err = readPcapClassic(b, add)
+	default:
+		err = fmt.Errorf("rtspv2: not a pcap file")
+	}
+	return
+}
+
+func readPcapClassic(b []byte, add func(int, time.Time, []byte)) (err error) {
+	var order binary.ByteOrder = binary.LittleEndian
+	magic := order.Uint32(b)
+	if magic == 0xd4c3b2a1 || magic == 0x4d3cb2a1 {
+		order = binary.BigEndian
+		magic = order.Uint32(b)
+	}
+	unit := time.Microsecond
+	if magic == 0xa1b23c4d {
+		unit = time.Nanosecond
+	}
+	linkType := int(order.Uint32(b[20:]) & 0xffff)
+	for pos := 24; pos+16 <= len(b); {
+		sec, frac := order.Uint32(b[pos:]), order.Uint32(b[pos+4:])
+		size := int(order.Uint32(b[pos+8:]))
+		pos += 16
+		if size > len(b)-pos {
+			err = fmt.Errorf("rtspv2: pcap record truncated")
+			return
+		}
+		add(linkType, time.Unix(int64(sec), int64(frac)*int64(unit)), b[pos:pos+size])
+		pos += size
+	}
+	return
+}
+
+func readPcapNG(b []byte, add func(int, time.Time, []byte)) (err error) {
+	var order binary.ByteOrder = binary.LittleEndian
+	type iface struct {
+		linkType int
+		resol    float64 // units by second
+	}
+	var ifaces []iface
+	for pos := 0; pos+12 <= len(b); {
+		typ := order.Uint32(b[pos:])
+		if typ == 0x0a0d0d0a {
+			// section header, the byte order magic tells its byte order
+			if binary.BigEndian.Uint32(b[pos+8:]) == 0x1a2b3c4d {
+				order = binary.BigEndian
+			} else {
+				order = binary.LittleEndian
+			}
+			ifaces = nil
+		}
+		size := int(order.Uint32(b[pos+4:]))
+		if size < 12 || size > len(b)-pos {
+			err = fmt.Errorf("rtspv2: pcapng block truncated")
+			return
+		}
+		body := b[pos+8 : pos+size-4]
+		switch typ {
+		case 1: // interface description
+			if len(body) < 8 {
+				break
+			}
+			ifc := iface{linkType: int(order.Uint16(body)), resol: 1e6}
+			for opt := body[8:]; len(opt) >= 4; {
+				code, n := order.Uint16(opt), int(order.Uint16(opt[2:]))
+				if 4+n > len(opt) || code == 0 {
+					break
+				}
+				if code == 9 && n >= 1 { // if_tsresol
+					if v := opt[4]; v&0x80 != 0 {
+						ifc.resol = float64(uint64(1) << (v & 0x7f))
+					} else {
+						ifc.resol = 1
+						for i := uint8(0); i < v; i++ {
+							ifc.resol *= 10
+						}
+					}
+				}
+				// the padding of the last option may be cut off
+				if padded := 4 + (n+3)&^3; padded < len(opt) {
+					opt = opt[padded:]
+				} else {
+					break
+				}
+			}
+			ifaces = append(ifaces, ifc)
+		case 6: // enhanced packet
+			if len(body) < 20 {
+				break
+			}
+			id := int(order.Uint32(body))
+			ts := uint64(order.Uint32(body[4:]))<<32 | uint64(order.Uint32(body[8:]))
+			caplen := int(order.Uint32(body[12:]))
+			if id >= len(ifaces) || caplen > len(body)-20 {
+				break
+			}
+			ifc := ifaces[id]
+			sec := float64(ts) / ifc.resol
+			tm := time.Unix(0, 0).Add(time.Duration(sec * float64(time.Second)))
+			add(ifc.linkType, tm, body[20:20+caplen])
+		}
+		pos += size
+	}
+	return
+}
+
+// decodeFrame finds the TCP or UDP payload of a frame of the link type,
+// Ethernet, Linux cooked capture or raw IP.
+func decodeFrame(linkType int, b []byte) (seg pcapSegment, ok bool) {
+	switch linkType {
+	case 1: // Ethernet
+		if len(b) < 14 {
+			return
+		}
+		proto, n := binary.BigEndian.Uint16(b[12:]), 14
+		for (proto == 0x8100 || proto == 0x88a8) && len(b) >= n+4 {
+			proto, n = binary.BigEndian.Uint16(b[n+2:]), n+4
+		}
+		if proto != 0x0800 && proto != 0x86dd {
+			return
+		}
+		b = b[n:]
+	case 113: // Linux cooked capture
+		if len(b) < 16 {
+			return
+		}
+		b = b[16:]
+	case 276: // Linux cooked capture v2
+		if len(b) < 20 {
+			return
+		}
+		b = b[20:]
+	case 0: // BSD loopback
+		if len(b) < 4 {
+			return
+		}
+		b = b[4:]
+	case 12, 101, 228, 229: // raw IP
+	default:
+		return
+	}
+	return decodeIP(b)
+}
+
+func decodeIP(b []byte) (seg pcapSegment, ok bool) {
+	if len(b) < 20 {
+		return
+	}
+	var src, dst net.IP
+	var proto int
+	switch b[0] >> 4 {
+	case 4:
+		ihl := int(b[0]&0xf) * 4
+		total := int(binary.BigEndian.Uint16(b[2:]))
+		if ihl < 20 || total < ihl || total > len(b) {
+			return
+		}
+		if binary.BigEndian.Uint16(b[6:])&0x3fff != 0 {
+			// fragment
+			return
+		}
+		proto = int(b[9])
+		src, dst = net.IP(b[12:16]), net.IP(b[16:20])
+		b = b[ihl:total]
+	case 6:
+		if len(b) < 40 {
+			return
+		}
+		total := 40 + int(binary.BigEndian.Uint16(b[4:]))
+		if total > len(b) {
+			return
+		}
+		proto = int(b[6])
+		src, dst = net.IP(b[8:24]), net.IP(b[24:40])
+		b = b[40:total]
+	default:
+		return
+	}
+	var srcPort, dstPort int
+	switch proto {
+	case 6:
+		if len(b) < 20 {
+			return
+		}
+		off := int(b[12]>>4) * 4
+		if off < 20 || off > len(b) {
+			return
+		}
+		srcPort, dstPort = int(binary.BigEndian.Uint16(b)), int(binary.BigEndian.Uint16(b[2:]))
+		seg.tcp = true
+		seg.seq = binary.BigEndian.Uint32(b[4:])
+		seg.syn = b[13]&0x02 != 0
+		seg.payload = b[off:]
+	case 17:
+		if len(b) < 8 {
+			return
+		}
+		size := int(binary.BigEndian.Uint16(b[4:]))
+		if size < 8 || size > len(b) {
+			return
+		}
+		srcPort, dstPort = int(binary.BigEndian.Uint16(b)), int(binary.BigEndian.Uint16(b[2:]))
+		seg.payload = b[8:size]
+	default:
+		return
+	}
+	seg.src = net.JoinHostPort(src.String(), strconv.Itoa(srcPort))
+	seg.dst = net.JoinHostPort(dst.String(), strconv.Itoa(dstPort))
+	seg.dstPort = dstPort
+	ok = true
+	return
+}
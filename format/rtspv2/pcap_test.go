@@ -0,0 +1,112 @@
+package rtspv2
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/deepch/vdk/av"
+)
+
+// testdata/session.pcap and .pcapng hold the same RTSP session: H.264 video
+// interleaved over TCP, each frame split in two segments with the first one
+// sent twice, and G.711 audio over UDP to client_port 5000.
+func TestDialPcap(t *testing.T) {
+	for _, name := range []string{"testdata/session.pcap", "testdata/session.pcapng"} {
+		f, err := os.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		client, err := DialPcap(f, PcapOptions{NoDelay: true})
+		f.Close()
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if len(client.CodecData) != 2 || client.CodecData[0].Type() != av.H264 || client.CodecData[1].Type() != av.PCM_MULAW {
+			t.Fatalf("%s: streams %v", name, client.CodecData)
+		}
+		counts := map[int8]int{}
+		keyframes := 0
+	read:
+		for {
+			select {
+			case pkt := <-client.OutgoingPacketQueue:
+				counts[pkt.Idx]++
+				if pkt.IsKeyFrame {
+					keyframes++
+				}
+			case signal := <-client.Signals:
+				if signal == SignalStreamRTPStop {
+					break read
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("%s: replay timed out", name)
+			}
+		}
+		for len(client.OutgoingPacketQueue) > 0 {
+			pkt := <-client.OutgoingPacketQueue
+			counts[pkt.Idx]++
+			if pkt.IsKeyFrame {
+				keyframes++
+			}
+		}
+		client.Close()
+		// the video of the last frame goes out with the next one
+		if counts[0] < 9 || counts[1] != 20 || keyframes != 2 {
+			t.Errorf("%s: %v packets, %d keyframes, want 9 video, 20 audio and 2 keyframes", name, counts, keyframes)
+		}
+	}
+}
+
+// pcapng returns a little endian pcapng file of blocks.
+func pcapng(blocks ...[]byte) []byte {
+	shb := make([]byte, 16)
+	binary.LittleEndian.PutUint32(shb, 0x1a2b3c4d)
+	binary.LittleEndian.PutUint16(shb[4:], 1)
+	b := pcapngBlock(0x0a0d0d0a, shb)
+	for _, block := range blocks {
+		b = append(b, block...)
+	}
+	return b
+}
+
+func pcapngBlock(typ uint32, body []byte) []byte {
+	b := make([]byte, 12+len(body))
+	binary.LittleEndian.PutUint32(b, typ)
+	binary.LittleEndian.PutUint32(b[4:], uint32(len(b)))
+	copy(b[8:], body)
+	binary.LittleEndian.PutUint32(b[len(b)-4:], uint32(len(b)))
+	return b
+}
+
+func TestReadPcapMalformed(t *testing.T) {
+	idb := func(opts ...byte) []byte {
+		return pcapngBlock(1, append([]byte{1, 0, 0, 0, 0, 0, 0, 0}, opts...))
+	}
+	for _, test := range []struct {
+		name string
+		data []byte
+		err  bool
+	}{
+		{"empty", nil, true},
+		{"not pcap", bytes.Repeat([]byte{1}, 32), true},
+		// an if_tsresol of 1 byte ending the block without its padding
+		{"option padding cut", pcapng(idb(9, 0, 1, 0, 6)), false},
+		{"option longer than block", pcapng(idb(9, 0, 8, 0, 6, 0, 0, 0)), false},
+		{"block past the end", pcapng(idb())[:40], true},
+		{"packet past its block", pcapng(idb(), pcapngBlock(6, []byte{
+			0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0xff, 0, 0, 0, 0xff, 0, 0, 0})), false},
+		{"record past the end", []byte{0xd4, 0xc3, 0xb2, 0xa1, 0, 2, 0, 4, 0, 0, 0, 0, 0, 0, 0, 0,
+			0, 0, 0xff, 0xff, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 100, 0, 0, 0, 100}, true},
+	} {
+		segments, err := readPcap(bytes.NewReader(test.data))
+		if (err != nil) != test.err {
+			t.Errorf("%s: error %v", test.name, err)
+		}
+		if len(segments) != 0 {
+			t.Errorf("%s: %d segments", test.name, len(segments))
+		}
+	}
+}
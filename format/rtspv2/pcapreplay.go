@@ -0,0 +1,384 @@
+package rtspv2
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/deepch/vdk/av"
+	"github.com/deepch/vdk/format/rtsp/sdp"
+)
+
+type PcapOptions struct {
+	Debug        bool
+	DisableAudio bool
+	SDP          []byte // the session of a capture started after the DESCRIBE, its RTP is then told apart by payload type
+	NoDelay      bool   // replay as fast as the packets are read, not with the capture timing
+}
+
+// DialPcap replays the RTSP session of a pcap or pcapng capture as a
+// camera would send it: the DESCRIBE and SETUP exchanged in the capture
+// set up the client, then the RTP of the session, interleaved or over
+// UDP, goes through the RTP demuxer with its original timing. The packets
+// come on OutgoingPacketQueue and SignalStreamRTPStop comes once all are
+// queued. Only the first session of the capture is replayed, RTP lost or
+// out of order in the capture reaches the demuxer as it was captured.
+func DialPcap(r io.Reader, options PcapOptions) (*RTSPClient, error) {
+	segments, err := readPcap(r)
+	if err != nil {
+		return nil, err
+	}
+	client := &RTSPClient{
+		headers:             make(map[string]string),
+		Signals:             make(chan int, 100),
+		OutgoingProxyQueue:  make(chan *[]byte, 3000),
+		OutgoingPacketQueue: make(chan *av.Packet, 3000),
+		BufferRtpPacket:     bytes.NewBuffer([]byte{}),
+		videoID:             -1,
+		audioID:             -2,
+		videoIDX:            -1,
+		audioIDX:            -2,
+		metadataID:          -3,
+		metadataIDX:         -3,
+		backchannel:         backchannel{id: -4},
+		options:             RTSPClientOptions{Debug: options.Debug, DisableAudio: options.DisableAudio},
+		AudioTimeScale:      8000,
+	}
+
+	flows := map[string]*tcpFlow{}
+	var order []*tcpFlow
+	var udp []pcapSegment
+	for _, seg := range segments {
+		if !seg.tcp {
+			udp = append(udp, seg)
+			continue
+		}
+		flow := flows[seg.src+">"+seg.dst]
+		if flow == nil {
+			flow = &tcpFlow{src: seg.src, dst: seg.dst}
+			flows[seg.src+">"+seg.dst] = flow
+			order = append(order, flow)
+		}
+		flow.add(seg)
+	}
+	for _, flow := range order {
+		flow.parse()
+	}
+
+	// the session is the connection of the first DESCRIBE answered with
+	// a session description
+	var server *tcpFlow
+	var requests map[string]rtspMessage
+	for _, flow := range order {
+		reverse := flows[flow.dst+">"+flow.src]
+		if reverse == nil {
+			continue
+		}
+		byCSeq := map[string]rtspMessage{}
+		for _, req := range reverse.messages {
+			byCSeq[req.headers["cseq"]] = req
+		}
+		for _, res := range flow.messages {
+			req, ok := byCSeq[res.headers["cseq"]]
+			if !ok || req.method() != DESCRIBE || !strings.HasPrefix(res.first, "RTSP/") || len(res.body) == 0 {
+				continue
+			}
+			if server == nil || res.time.Before(server.describe.time) {
+				server, requests = flow, byCSeq
+				server.describe = res
+				// the server marks the backchannel sendonly only when asked for it
+				client.options.Backchannel = strings.Contains(req.headers["require"], RequireBackchannel)
+				if err = client.parseURL(req.uri()); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	setups := map[string]string{}
+	if server != nil {
+		client.SDPRaw = server.describe.body
+		if base, ok := server.describe.headers["content-base"]; ok {
+			client.control = base
+		}
+		for _, res := range server.messages {
+			req, ok := requests[res.headers["cseq"]]
+			if !ok || req.method() != SETUP || !strings.HasPrefix(res.first, "RTSP/") {
+				continue
+			}
+			transport, ok := res.headers["transport"]
+			if !ok {
+				transport = req.headers["transport"]
+			}
+			setups[req.uri()] = transport
+		}
+	} else if options.SDP != nil {
+		client.SDPRaw = options.SDP
+	} else {
+		return nil, fmt.Errorf("rtspv2: no DESCRIBE in the capture and no SDP given")
+	}
+	_, client.mediaSDP = sdp.Parse(string(client.SDPRaw))
+
+	ports := map[int]int{}
+	payloadTypes := map[int]int{}
+	for _, i2 := range client.mediaSDP {
+		if (i2.AVType != VIDEO && i2.AVType != AUDIO) || client.isBackchannel(i2) || (options.DisableAudio && i2.AVType == AUDIO) {
+			continue
+		}
+		transport, found := client.findSetup(setups, i2.Control)
+		if ch, ok := transportParam(transport, "interleaved"); ok {
+			client.chTMP = ch
+		} else if port, ok := transportParam(transport, "client_port"); ok {
+			ports[port] = client.chTMP
+		} else if port, ok := transportParam(transport, "port"); ok {
+			ports[port] = client.chTMP
+		} else if !found || server == nil {
+			payloadTypes[i2.PayloadType] = client.chTMP
+		}
+		client.setupCodec(i2)
+		client.chTMP += 2
+	}
+
+	// the RTP of the session, by payload type in the capture of no session
+	var frames []pcapFrame
+	addRTP := func(tm time.Time, ch int, mapped bool, rtp []byte) {
+		if len(rtp) < RTPHeaderSize || len(rtp) > 65535 || rtp[0]>>6 != 2 || (rtp[1] >= RTCPSenderReport && rtp[1] <= 204) {
+			return
+		}
+		if !mapped {
+			if ch, mapped = payloadTypes[int(rtp[1]&0x7f)]; !mapped {
+				return
+			}
+		}
+		content := make([]byte, 4+len(rtp))
+		content[0] = 0x24
+		content[1] = uint8(ch)
+		binary.BigEndian.PutUint16(content[2:], uint16(len(rtp)))
+		copy(content[4:], rtp)
+		frames = append(frames, pcapFrame{time: tm, data: content})
+	}
+	for _, flow := range order {
+		if server != nil && flow != server {
+			continue
+		}
+		for _, frame := range flow.frames {
+			addRTP(frame.time, int(frame.data[1]), server != nil, frame.data[4:])
+		}
+	}
+	for _, seg := range udp {
+		ch, ok := ports[seg.dstPort]
+		addRTP(seg.time, ch, ok, seg.payload)
+	}
+	sort.SliceStable(frames, func(i, j int) bool {
+		return frames[i].time.Before(frames[j].time)
+	})
+	client.Println("RTSP Client pcap replay of", len(frames), "RTP packets")
+
+	done := make(chan struct{})
+	client.onClose = func() {
+		close(done)
+	}
+	go client.replayPcap(frames, options.NoDelay, done)
+	return client, nil
+}
+
+// findSetup returns the Transport of the SETUP of the track control.
+func (client *RTSPClient) findSetup(setups map[string]string, control string) (transport string, ok bool) {
+	if transport, ok = setups[client.ControlTrack(control)]; ok {
+		return
+	}
+	for uri, t := range setups {
+		if uri == control || strings.HasSuffix(uri, "/"+control) {
+			return t, true
+		}
+	}
+	return
+}
+
+// transportParam returns the first number of the Transport parameter key,
+// as 0 of interleaved=0-1.
+func transportParam(transport, key string) (v int, ok bool) {
+	for _, param := range strings.Split(transport, ";") {
+		kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+		if len(kv) != 2 || kv[0] != key {
+			continue
+		}
+		var err error
+		if v, err = strconv.Atoi(strings.SplitN(kv[1], "-", 2)[0]); err == nil {
+			ok = true
+			return
+		}
+	}
+	return
+}
+
+func (client *RTSPClient) replayPcap(frames []pcapFrame, noDelay bool, done chan struct{}) {
+	defer func() {
+		client.Signals <- SignalStreamRTPStop
+	}()
+	start := time.Now()
+	for _, frame := range frames {
+		if !noDelay {
+			if wait := frame.time.Sub(frames[0].time) - time.Since(start); wait > 0 {
+				select {
+				case <-time.After(wait):
+				case <-done:
+					return
+				}
+			}
+		}
+		pkt, got := client.RTPDemuxer(&frame.data)
+		if !got {
+			continue
+		}
+		for _, i2 := range pkt {
+			select {
+			case client.OutgoingPacketQueue <- i2:
+			case <-done:
+				return
+			}
+		}
+	}
+}
+
+type pcapFrame struct {
+	time time.Time
+	data []byte // an interleaved frame
+}
+
+type rtspMessage struct {
+	time    time.Time
+	first   string
+	headers map[string]string // by lower case name
+	body    []byte
+}
+
+func (self rtspMessage) method() string {
+	return strings.SplitN(self.first, " ", 2)[0]
+}
+
+func (self rtspMessage) uri() string {
+	if fields := strings.Fields(self.first); len(fields) > 1 {
+		return fields[1]
+	}
+	return ""
+}
+
+// tcpFlow is one direction of a TCP connection, its data in order and the
+// RTSP messages and interleaved frames in it.
+type tcpFlow struct {
+	src, dst string
+	started  bool
+	next     uint32
+	data     []byte
+	marks    []tcpMark
+	messages []rtspMessage
+	frames   []pcapFrame
+	describe rtspMessage
+}
+
+type tcpMark struct {
+	end  int
+	time time.Time
+}
+
+// add appends the data of seg, retransmitted data is dropped and data lost
+// in the capture is skipped.
+func (self *tcpFlow) add(seg pcapSegment) {
+	seq := seg.seq
+	if seg.syn {
+		seq++
+	}
+	if !self.started {
+		self.started = true
+		self.next = seq
+	}
+	payload := seg.payload
+	if d := int32(self.next - seq); d > 0 {
+		if int(d) >= len(payload) {
+			return
+		}
+		payload = payload[d:]
+	}
+	if len(payload) == 0 {
+		return
+	}
+	self.next = seq + uint32(len(seg.payload))
+	self.data = append(self.data, payload...)
+	self.marks = append(self.marks, tcpMark{end: len(self.data), time: seg.time})
+}
+
+// timeAt is the time the byte before end was captured.
+func (self *tcpFlow) timeAt(end int) time.Time {
+	i := sort.Search(len(self.marks), func(i int) bool {
+		return self.marks[i].end >= end
+	})
+	if i == len(self.marks) {
+		i--
+	}
+	return self.marks[i].time
+}
+
+var rtspMethods = []string{"RTSP/1.0 ", "DESCRIBE ", "SETUP ", "PLAY ", "PAUSE ", "OPTIONS ", "TEARDOWN ",
+	"GET_PARAMETER ", "SET_PARAMETER ", "ANNOUNCE ", "RECORD "}
+
+func isRTSPMessage(b []byte) bool {
+	for _, method := range rtspMethods {
+		if bytes.HasPrefix(b, []byte(method)) {
+			return true
+		}
+	}
+	return false
+}
+
+// parse splits the data in RTSP messages and interleaved frames, looking
+// for the next one after data it does not know.
+func (self *tcpFlow) parse() {
+	data := self.data
+	for pos := 0; pos < len(data); {
+		b := data[pos:]
+		if b[0] == 0x24 && len(b) >= 4 {
+			n := 4 + int(binary.BigEndian.Uint16(b[2:]))
+			if n > len(b) {
+				return
+			}
+			self.frames = append(self.frames, pcapFrame{time: self.timeAt(pos + n), data: b[:n]})
+			pos += n
+			continue
+		}
+		if isRTSPMessage(b) {
+			end := bytes.Index(b, []byte("\r\n\r\n"))
+			if end < 0 {
+				return
+			}
+			lines := strings.Split(string(b[:end]), "\r\n")
+			msg := rtspMessage{first: lines[0], headers: map[string]string{}}
+			for _, line := range lines[1:] {
+				if kv := strings.SplitN(line, ":", 2); len(kv) == 2 {
+					msg.headers[strings.ToLower(strings.TrimSpace(kv[0]))] = strings.TrimSpace(kv[1])
+				}
+			}
+			n := end + 4
+			if size, err := strconv.Atoi(msg.headers["content-length"]); err == nil && size > 0 {
+				if n+size > len(b) {
+					return
+				}
+				msg.body = b[n : n+size]
+				n += size
+			}
+			msg.time = self.timeAt(pos + n)
+			self.messages = append(self.messages, msg)
+			pos += n
+			continue
+		}
+		pos++
+		for pos < len(data) && data[pos] != 0x24 && !isRTSPMessage(data[pos:]) {
+			pos++
+		}
+	}
+}
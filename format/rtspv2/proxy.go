@@ -7,6 +7,7 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
@@ -26,13 +27,26 @@ type ProxyConn struct {
 	session  string
 	protocol int
 	in       int
+
+	proxy   *Proxy
+	tracks  map[int]*rtpTrack
+	lock    sync.Mutex
+	reports map[int]ReceiverReport
+	alive   time.Time
+	closed  bool
 }
 
 type Proxy struct {
 	Addr          string
+	UDPAddr       string     // RTP port of UDP unicast, RTCP on the next one, TCP only if empty
+	Multicast     *Multicast // group of the sessions asking multicast
 	HandleConn    func(*ProxyConn)
 	HandleOptions func(*ProxyConn)
 	HandlePlay    func(*ProxyConn)
+
+	HandleReceiverReport func(*ProxyConn, ReceiverReport)
+
+	udp *udpServer
 }
 
 func NewProxyConn(netconn net.Conn) *ProxyConn {
@@ -41,25 +55,112 @@ func NewProxyConn(netconn net.Conn) *ProxyConn {
 	conn.writebuf = make([]byte, 4096)
 	conn.readbuf = make([]byte, 4096)
 	conn.session = uuid.New().String()
+	conn.protocol = TCPTransferPassive
+	conn.tracks = map[int]*rtpTrack{}
+	conn.reports = map[int]ReceiverReport{}
 	return conn
 }
 
+// Close ends the session, releasing its UDP ports or multicast membership.
 func (self *ProxyConn) Close() (err error) {
-	return nil
+	self.lock.Lock()
+	closed := self.closed
+	self.closed = true
+	self.lock.Unlock()
+	if closed {
+		return nil
+	}
+	if self.proxy != nil {
+		if self.proxy.udp != nil {
+			self.proxy.udp.remove(self)
+		}
+		if self.protocol == MulticastTransfer && self.playing {
+			self.proxy.Multicast.leave()
+		}
+	}
+	return self.netconn.Close()
 }
 
+// WritePacket writes an interleaved packet of the source, with the SSRC
+// and sequence numbers of the session.
 func (self *ProxyConn) WritePacket(pkt *[]byte) (err error) {
+	content := *pkt
+	var track *rtpTrack
+	self.lock.Lock()
+	if len(content) >= 4 {
+		track = self.tracks[int(content[1])/2]
+	}
+	closed, last := self.closed, self.alive
+	self.lock.Unlock()
+	switch self.protocol {
+	case MulticastTransfer, UDPTransfer:
+		if closed {
+			return errors.New("rtsp: session closed")
+		}
+		if self.protocol == UDPTransfer && time.Since(last) > SessionTimeout {
+			return errors.New("rtsp: session timeout")
+		}
+		if self.protocol == MulticastTransfer || track == nil || track.rtp == nil {
+			return nil
+		}
+		b := append([]byte(nil), content[4:]...)
+		if content[1]%2 == 1 {
+			if track.rewrite(b, true) {
+				_, err = self.proxy.udp.rtcp.WriteToUDP(b, track.rtcp)
+			}
+		} else if track.rewrite(b, false) {
+			_, err = self.proxy.udp.rtp.WriteToUDP(b, track.rtp)
+		}
+		return
+	}
+	if track != nil {
+		if len(self.writebuf) < len(content) {
+			self.writebuf = make([]byte, len(content))
+		}
+		b := self.writebuf[:len(content)]
+		copy(b, content)
+		if !track.rewrite(b[4:], content[1]%2 == 1) {
+			return nil
+		}
+		content = b
+	}
 	err = self.netconn.SetDeadline(time.Now().Add(time.Second * 5))
 	if err != nil {
 		return err
 	}
-	_, err = self.netconn.Write(*pkt)
+	_, err = self.netconn.Write(content)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
+func (self *ProxyConn) receiveRTCP(b []byte, track int) {
+	reports := parseReceiverReports(b, track, time.Now())
+	self.lock.Lock()
+	self.alive = time.Now()
+	for _, report := range reports {
+		self.reports[track] = report
+	}
+	self.lock.Unlock()
+	if self.proxy != nil && self.proxy.HandleReceiverReport != nil {
+		for _, report := range reports {
+			self.proxy.HandleReceiverReport(self, report)
+		}
+	}
+}
+
+// ReceiverReports returns the last RTCP report of the client for each
+// track, in UDP unicast.
+func (self *ProxyConn) ReceiverReports() (reports []ReceiverReport) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	for _, report := range self.reports {
+		reports = append(reports, report)
+	}
+	return
+}
+
 func (self *ProxyConn) WriteHeader(sdp []byte) {
 	self.sdp = sdp
 }
@@ -83,6 +184,13 @@ func (self *Proxy) ListenAndServe() (err error) {
 	if listener, err = net.ListenTCP("tcp", tcpaddr); err != nil {
 		return
 	}
+	if self.UDPAddr != "" {
+		if self.udp, err = listenUDP(self.UDPAddr); err != nil {
+			listener.Close()
+			return
+		}
+		defer self.udp.Close()
+	}
 
 	if Debug {
 		fmt.Println("rtsp: server: listening on", addr)
@@ -98,6 +206,7 @@ func (self *Proxy) ListenAndServe() (err error) {
 			fmt.Println("rtsp: server: accepted")
 		}
 		conn := NewProxyConn(netconn)
+		conn.proxy = self
 		go func() {
 			err := self.handleConn(conn)
 			if Debug {
@@ -114,6 +223,7 @@ func (self *Proxy) handleConn(conn *ProxyConn) (err error) {
 	} else {
 		for {
 			if err = conn.prepare(); err != nil {
+				conn.Close()
 				return
 			}
 			if conn.options {
@@ -157,6 +267,9 @@ func (self *ProxyConn) prepare() error {
 	}
 
 	cseq := strings.TrimSpace(stringInBetween(string(self.readbuf[:n]), "CSeq:", "\r\n"))
+	self.lock.Lock()
+	self.alive = time.Now()
+	self.lock.Unlock()
 	switch fistStringsSlice[0] {
 	case OPTIONS:
 
@@ -166,21 +279,25 @@ func (self *ProxyConn) prepare() error {
 		if self.URL, err = url.Parse(fistStringsSlice[1]); err != nil {
 			return err
 		}
-		_, err := self.netconn.Write([]byte("RTSP/1.0 200 OK\r\nPublic: OPTIONS, DESCRIBE, SETUP, PLAY\r\nSession: " + self.session + "\r\nCSeq: " + cseq + "\r\n\r\n"))
+		_, err := self.netconn.Write([]byte("RTSP/1.0 200 OK\r\nPublic: OPTIONS, DESCRIBE, SETUP, PLAY, GET_PARAMETER, TEARDOWN\r\nSession: " + self.session + "\r\nCSeq: " + cseq + "\r\n\r\n"))
 		if err != nil {
 			return err
 		}
 		self.options = true
 
 	case SETUP:
-		if strings.Contains(string(self.readbuf[:n]), "RTP/AVP/UDP") {
+		transport, err := self.setup(stringInBetween(string(self.readbuf[:n]), "Transport:", "\r\n"))
+		if err != nil {
+			if Debug {
+				fmt.Println("rtsp: server: setup:", err)
+			}
 			_, err := self.netconn.Write([]byte("RTSP/1.0 461 Unsupported transport\r\nCSeq: " + cseq + "\r\nSession: " + self.session + "\r\n\r\n"))
 			if err != nil {
 				return err
 			}
 			return nil
 		}
-		_, err := self.netconn.Write([]byte("RTSP/1.0 200 OK\r\nCSeq: " + cseq + "\r\nSession: " + self.session + "\r\nTransport: RTP/AVP/TCP;unicast;interleaved=" + strconv.Itoa(self.in) + "-" + strconv.Itoa(self.in+1) + "\r\n\r\n"))
+		_, err = self.netconn.Write([]byte("RTSP/1.0 200 OK\r\nCSeq: " + cseq + "\r\nSession: " + self.session + "\r\nTransport: " + transport + "\r\n\r\n"))
 		if err != nil {
 			return err
 		}
@@ -198,9 +315,18 @@ func (self *ProxyConn) prepare() error {
 		if err != nil {
 			return err
 		}
+		if !self.playing && self.protocol == MulticastTransfer {
+			self.proxy.Multicast.join()
+		}
 		self.playing = true
+	case GET_PARAMETER:
+		// keep-alive of UDP sessions
+		_, err := self.netconn.Write([]byte("RTSP/1.0 200 OK\r\nSession: " + self.session + "\r\nCSeq: " + cseq + "\r\n\r\n"))
+		if err != nil {
+			return err
+		}
 	case TEARDOWN:
-		self.netconn.Close()
+		self.Close()
 		return errors.New("exit")
 
 	default:
@@ -210,3 +336,63 @@ func (self *ProxyConn) prepare() error {
 	}
 	return nil
 }
+
+// setup chooses the transport of the next track from the Transport header
+// of the client and returns the one of the reply.
+func (self *ProxyConn) setup(request string) (transport string, err error) {
+	track := newRTPTrack()
+	index := self.in / 2
+	ssrc := fmt.Sprintf(";ssrc=%08X", track.ssrc)
+	switch {
+	case strings.Contains(request, "multicast"):
+		if self.proxy == nil || self.proxy.Multicast == nil {
+			err = errors.New("rtsp: multicast disabled")
+			return
+		}
+		var id uint32
+		if id, err = self.proxy.Multicast.setup(index); err != nil {
+			return
+		}
+		self.protocol = MulticastTransfer
+		transport = self.proxy.Multicast.transport(index) + fmt.Sprintf(";ssrc=%08X", id)
+		return
+	case strings.Contains(request, "RTP/AVP/TCP") || strings.Contains(request, "interleaved"):
+		self.protocol = TCPTransferPassive
+		transport = "RTP/AVP/TCP;unicast;interleaved=" + strconv.Itoa(self.in) + "-" + strconv.Itoa(self.in+1) + ssrc
+	default:
+		if self.proxy == nil || self.proxy.udp == nil {
+			err = errors.New("rtsp: udp disabled")
+			return
+		}
+		var rtp, rtcp int
+		ports := strings.SplitN(stringInBetween(request+";", "client_port=", ";"), "-", 2)
+		if rtp, err = strconv.Atoi(ports[0]); err != nil {
+			err = fmt.Errorf("rtsp: client_port `%s` invalid", ports[0])
+			return
+		}
+		rtcp = rtp + 1
+		if len(ports) == 2 {
+			if rtcp, err = strconv.Atoi(ports[1]); err != nil {
+				err = fmt.Errorf("rtsp: client_port `%s` invalid", ports[1])
+				return
+			}
+		}
+		addr, ok := self.netconn.RemoteAddr().(*net.TCPAddr)
+		if !ok {
+			err = fmt.Errorf("rtsp: udp to a client at %v", self.netconn.RemoteAddr())
+			return
+		}
+		host := addr.IP
+		track.rtp = &net.UDPAddr{IP: host, Port: rtp}
+		track.rtcp = &net.UDPAddr{IP: host, Port: rtcp}
+		self.proxy.udp.add(self, index, track.rtcp)
+		self.protocol = UDPTransfer
+		serverRTP, serverRTCP := self.proxy.udp.ports()
+		transport = "RTP/AVP;unicast;client_port=" + strconv.Itoa(rtp) + "-" + strconv.Itoa(rtcp) +
+			";server_port=" + strconv.Itoa(serverRTP) + "-" + strconv.Itoa(serverRTCP) + ssrc
+	}
+	self.lock.Lock()
+	self.tracks[index] = track
+	self.lock.Unlock()
+	return
+}
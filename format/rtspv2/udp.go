@@ -0,0 +1,343 @@
+package rtspv2
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/net/ipv4"
+)
+
+const MulticastTransfer int = 4
+
+// SessionTimeout ends a UDP session whose client stopped sending RTCP.
+const SessionTimeout = 60 * time.Second
+
+// ReceiverReport is a report block of an RTCP receiver or sender report.
+type ReceiverReport struct {
+	Track        int
+	Reporter     uint32 // SSRC of the receiver
+	SSRC         uint32 // SSRC reported on
+	FractionLost uint8
+	PacketsLost  int32
+	HighestSeq   uint32
+	Jitter       uint32
+	LSR          uint32
+	DLSR         uint32
+	Time         time.Time
+}
+
+func parseReceiverReports(b []byte, track int, now time.Time) (reports []ReceiverReport) {
+	for len(b) >= 8 {
+		length := (int(binary.BigEndian.Uint16(b[2:])) + 1) * 4
+		if b[0]>>6 != 2 || length > len(b) {
+			return
+		}
+		count := int(b[0] & 0x1f)
+		blocks := 8
+		switch b[1] {
+		case RTCPSenderReport:
+			blocks = 28
+		case RTCPReceiverReport:
+		default:
+			count = 0
+		}
+		reporter := binary.BigEndian.Uint32(b[4:])
+		for i := 0; i < count && blocks+24 <= length; i++ {
+			block := b[blocks:]
+			lost := int32(binary.BigEndian.Uint32(block[4:])<<8) >> 8
+			reports = append(reports, ReceiverReport{
+				Track:        track,
+				Reporter:     reporter,
+				SSRC:         binary.BigEndian.Uint32(block[0:]),
+				FractionLost: block[4],
+				PacketsLost:  lost,
+				HighestSeq:   binary.BigEndian.Uint32(block[8:]),
+				Jitter:       binary.BigEndian.Uint32(block[12:]),
+				LSR:          binary.BigEndian.Uint32(block[16:]),
+				DLSR:         binary.BigEndian.Uint32(block[20:]),
+				Time:         now,
+			})
+			blocks += 24
+		}
+		b = b[length:]
+	}
+	return
+}
+
+// rtpTrack gives a session its own SSRC and a sequence without jumps,
+// whatever the source does on reconnect.
+type rtpTrack struct {
+	ssrc    uint32
+	seq     uint16
+	delta   uint16
+	srcSSRC uint32
+	started bool
+
+	rtp, rtcp *net.UDPAddr // client ports in UDP unicast
+}
+
+func newRTPTrack() *rtpTrack {
+	return &rtpTrack{
+		ssrc: rand.Uint32(),
+		seq:  uint16(rand.Uint32()),
+	}
+}
+
+// rewrite rewrites RTP packets, or the SSRC of RTCP sender reports, in b
+// which must be a copy.
+func (self *rtpTrack) rewrite(b []byte, rtcp bool) bool {
+	if rtcp {
+		if len(b) < 8 || b[1] != RTCPSenderReport {
+			return false
+		}
+		binary.BigEndian.PutUint32(b[4:], self.ssrc)
+		return true
+	}
+	if len(b) < 12 {
+		return false
+	}
+	seq := binary.BigEndian.Uint16(b[2:])
+	ssrc := binary.BigEndian.Uint32(b[8:])
+	if !self.started || ssrc != self.srcSSRC || int16(seq+self.delta-self.seq) > 3000 || int16(seq+self.delta-self.seq) < -3000 {
+		self.delta = self.seq + 1 - seq
+		self.srcSSRC = ssrc
+		self.started = true
+	}
+	out := seq + self.delta
+	if int16(out-self.seq) > 0 {
+		self.seq = out
+	}
+	binary.BigEndian.PutUint16(b[2:], out)
+	binary.BigEndian.PutUint32(b[8:], self.ssrc)
+	return true
+}
+
+// Multicast sends the proxied stream once to a group shared by every
+// session in multicast, track n on Port+2n and Port+2n+1.
+type Multicast struct {
+	Group string // like 239.0.0.1
+	Port  int
+	TTL   int // 16 if zero
+
+	OnReceiverReport func(ReceiverReport)
+
+	lock    sync.Mutex
+	conn    *net.UDPConn
+	tracks  map[int]*rtpTrack
+	members int
+	rtcp    map[int]*net.UDPConn
+	reports map[uint32]ReceiverReport
+}
+
+func (self *Multicast) ttl() int {
+	if self.TTL == 0 {
+		return 16
+	}
+	return self.TTL
+}
+
+func (self *Multicast) addr(port int) *net.UDPAddr {
+	return &net.UDPAddr{IP: net.ParseIP(self.Group), Port: port}
+}
+
+func (self *Multicast) transport(track int) string {
+	port := self.Port + track*2
+	return "RTP/AVP;multicast;destination=" + self.Group + ";port=" + strconv.Itoa(port) + "-" + strconv.Itoa(port+1) + ";ttl=" + strconv.Itoa(self.ttl())
+}
+
+// setup prepares track and returns its SSRC.
+func (self *Multicast) setup(track int) (ssrc uint32, err error) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if self.addr(self.Port).IP.To4() == nil {
+		err = fmt.Errorf("rtsp: multicast group `%s` invalid", self.Group)
+		return
+	}
+	if self.conn == nil {
+		if self.conn, err = net.ListenUDP("udp4", nil); err != nil {
+			return
+		}
+		ipv4.NewPacketConn(self.conn).SetMulticastTTL(self.ttl())
+		self.tracks = map[int]*rtpTrack{}
+		self.rtcp = map[int]*net.UDPConn{}
+		self.reports = map[uint32]ReceiverReport{}
+	}
+	if self.tracks[track] == nil {
+		self.tracks[track] = newRTPTrack()
+	}
+	if self.rtcp[track] == nil {
+		if conn, err := net.ListenMulticastUDP("udp4", nil, self.addr(self.Port+track*2+1)); err == nil {
+			self.rtcp[track] = conn
+			go self.readRTCP(conn, track)
+		} else if Debug {
+			fmt.Println("rtsp: multicast rtcp:", err)
+		}
+	}
+	ssrc = self.tracks[track].ssrc
+	return
+}
+
+func (self *Multicast) join() {
+	self.lock.Lock()
+	self.members++
+	self.lock.Unlock()
+}
+
+func (self *Multicast) leave() {
+	self.lock.Lock()
+	self.members--
+	self.lock.Unlock()
+}
+
+// WritePacket sends an interleaved packet of the source to the group, if
+// a session plays it.
+func (self *Multicast) WritePacket(pkt *[]byte) (err error) {
+	content := *pkt
+	if len(content) < 4 {
+		return
+	}
+	ch := int(content[1])
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	track := self.tracks[ch/2]
+	if self.members == 0 || track == nil {
+		return
+	}
+	b := append([]byte(nil), content[4:]...)
+	if !track.rewrite(b, ch%2 == 1) {
+		return
+	}
+	_, err = self.conn.WriteToUDP(b, self.addr(self.Port+ch))
+	return
+}
+
+func (self *Multicast) readRTCP(conn *net.UDPConn, track int) {
+	buf := make([]byte, 1500)
+	for {
+		n, _, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		self.lock.Lock()
+		var reports []ReceiverReport
+		for _, report := range parseReceiverReports(buf[:n], track, time.Now()) {
+			if t := self.tracks[track]; t != nil && report.Reporter == t.ssrc {
+				// our own sender report looped back
+				continue
+			}
+			self.reports[report.Reporter] = report
+			reports = append(reports, report)
+		}
+		self.lock.Unlock()
+		if self.OnReceiverReport != nil {
+			for _, report := range reports {
+				self.OnReceiverReport(report)
+			}
+		}
+	}
+}
+
+// ReceiverReports returns the last report of each receiver of the group.
+func (self *Multicast) ReceiverReports() (reports []ReceiverReport) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	for _, report := range self.reports {
+		reports = append(reports, report)
+	}
+	return
+}
+
+// Close stops sending and closes the sockets.
+func (self *Multicast) Close() (err error) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	for track, conn := range self.rtcp {
+		conn.Close()
+		delete(self.rtcp, track)
+	}
+	if self.conn != nil {
+		err = self.conn.Close()
+		self.conn = nil
+		self.tracks = nil
+	}
+	return
+}
+
+// udpServer holds the server ports shared by the UDP unicast sessions,
+// RTCP is matched to the session by the client address.
+type udpServer struct {
+	rtp, rtcp *net.UDPConn
+	lock      sync.Mutex
+	sessions  map[string]udpSession
+}
+
+type udpSession struct {
+	conn  *ProxyConn
+	track int
+}
+
+func listenUDP(addr string) (self *udpServer, err error) {
+	var rtpaddr *net.UDPAddr
+	if rtpaddr, err = net.ResolveUDPAddr("udp", addr); err != nil {
+		err = fmt.Errorf("rtsp: ListenAndServe: %s", err)
+		return
+	}
+	self = &udpServer{sessions: map[string]udpSession{}}
+	if self.rtp, err = net.ListenUDP("udp", rtpaddr); err != nil {
+		return
+	}
+	rtcpaddr := *self.rtp.LocalAddr().(*net.UDPAddr)
+	rtcpaddr.Port++
+	if self.rtcp, err = net.ListenUDP("udp", &rtcpaddr); err != nil {
+		self.rtp.Close()
+		return
+	}
+	go self.readRTCP()
+	return
+}
+
+func (self *udpServer) ports() (int, int) {
+	return self.rtp.LocalAddr().(*net.UDPAddr).Port, self.rtcp.LocalAddr().(*net.UDPAddr).Port
+}
+
+func (self *udpServer) add(conn *ProxyConn, track int, addr *net.UDPAddr) {
+	self.lock.Lock()
+	self.sessions[addr.String()] = udpSession{conn: conn, track: track}
+	self.lock.Unlock()
+}
+
+func (self *udpServer) remove(conn *ProxyConn) {
+	self.lock.Lock()
+	for addr, session := range self.sessions {
+		if session.conn == conn {
+			delete(self.sessions, addr)
+		}
+	}
+	self.lock.Unlock()
+}
+
+func (self *udpServer) readRTCP() {
+	buf := make([]byte, 1500)
+	for {
+		n, addr, err := self.rtcp.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		self.lock.Lock()
+		session, ok := self.sessions[addr.String()]
+		self.lock.Unlock()
+		if ok {
+			session.conn.receiveRTCP(buf[:n], session.track)
+		}
+	}
+}
+
+func (self *udpServer) Close() {
+	self.rtp.Close()
+	self.rtcp.Close()
+}
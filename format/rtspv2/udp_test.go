@@ -0,0 +1,375 @@
+package rtspv2
+
+import (
+	"encoding/binary"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// rtcpReport builds a sender or receiver report of reporter with a block
+// per SSRC.
+func rtcpReport(typ uint8, reporter uint32, lost int32, ssrcs ...uint32) []byte {
+	b := []byte{0x80 | byte(len(ssrcs)), typ, 0, 0, 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(b[4:], reporter)
+	if typ == RTCPSenderReport {
+		b = append(b, make([]byte, 20)...)
+	}
+	for i, ssrc := range ssrcs {
+		block := make([]byte, 24)
+		binary.BigEndian.PutUint32(block[0:], ssrc)
+		binary.BigEndian.PutUint32(block[4:], uint32(lost)&0xffffff)
+		block[4] = byte(10 * (i + 1))
+		binary.BigEndian.PutUint32(block[8:], 70000+uint32(i))
+		binary.BigEndian.PutUint32(block[12:], 90)
+		binary.BigEndian.PutUint32(block[16:], 0x12345678)
+		binary.BigEndian.PutUint32(block[20:], 0x10000)
+		b = append(b, block...)
+	}
+	binary.BigEndian.PutUint16(b[2:], uint16(len(b)/4-1))
+	return b
+}
+
+func TestParseReceiverReports(t *testing.T) {
+	now := time.Now()
+	sdes := []byte{0x81, 202, 0, 1, 0, 0, 0, 1}
+	for _, test := range []struct {
+		name  string
+		b     []byte
+		ssrcs []uint32
+		lost  int32
+	}{
+		{"receiver report", rtcpReport(RTCPReceiverReport, 7, 3, 100), []uint32{100}, 3},
+		{"two blocks", rtcpReport(RTCPReceiverReport, 7, 0, 100, 200), []uint32{100, 200}, 0},
+		{"sender report", rtcpReport(RTCPSenderReport, 7, 5, 300), []uint32{300}, 5},
+		{"duplicates", rtcpReport(RTCPReceiverReport, 7, -2, 100), []uint32{100}, -2},
+		{"compound", append(rtcpReport(RTCPReceiverReport, 7, 1, 100), sdes...), []uint32{100}, 1},
+		{"sdes first", append(append([]byte(nil), sdes...), rtcpReport(RTCPReceiverReport, 7, 1, 100)...), []uint32{100}, 1},
+		{"empty receiver report", rtcpReport(RTCPReceiverReport, 7, 0), nil, 0},
+		{"truncated", rtcpReport(RTCPReceiverReport, 7, 0, 100)[:20], nil, 0},
+		{"bad version", append([]byte{0x41}, rtcpReport(RTCPReceiverReport, 7, 0, 100)[1:]...), nil, 0},
+		{"short", []byte{0x80, 201, 0}, nil, 0},
+	} {
+		reports := parseReceiverReports(test.b, 1, now)
+		if len(reports) != len(test.ssrcs) {
+			t.Errorf("%s: %d reports", test.name, len(reports))
+			continue
+		}
+		for i, report := range reports {
+			want := ReceiverReport{
+				Track:        1,
+				Reporter:     7,
+				SSRC:         test.ssrcs[i],
+				FractionLost: uint8(10 * (i + 1)),
+				PacketsLost:  test.lost,
+				HighestSeq:   70000 + uint32(i),
+				Jitter:       90,
+				LSR:          0x12345678,
+				DLSR:         0x10000,
+				Time:         now,
+			}
+			if report != want {
+				t.Errorf("%s: report %d %+v", test.name, i, report)
+			}
+		}
+	}
+}
+
+func rtpPacket(seq uint16, ssrc uint32) []byte {
+	b := make([]byte, 16)
+	b[0], b[1] = 0x80, 96
+	binary.BigEndian.PutUint16(b[2:], seq)
+	binary.BigEndian.PutUint32(b[8:], ssrc)
+	return b
+}
+
+func TestRTPTrackRewrite(t *testing.T) {
+	track := newRTPTrack()
+	first := track.seq + 1
+	for _, test := range []struct {
+		name string
+		seq  uint16
+		ssrc uint32
+		want uint16 // after the first sequence number of the session
+	}{
+		{"first", 5000, 1, 0},
+		{"next", 5001, 1, 1},
+		{"late", 4999, 1, 65535},
+		{"lost", 5010, 1, 10},
+		{"reordered", 5005, 1, 5},
+		{"wrap", 4000, 1, 64536},
+		{"new source", 100, 2, 11},
+		{"next of new source", 101, 2, 12},
+		{"source jump", 40000, 2, 13},
+		{"back to the first source", 5011, 1, 14},
+	} {
+		b := rtpPacket(test.seq, test.ssrc)
+		if !track.rewrite(b, false) {
+			t.Fatalf("%s: not rewritten", test.name)
+		}
+		if seq := binary.BigEndian.Uint16(b[2:]); seq-first != test.want {
+			t.Errorf("%s: sequence %d, want %d", test.name, seq-first, test.want)
+		}
+		if binary.BigEndian.Uint32(b[8:]) != track.ssrc {
+			t.Errorf("%s: ssrc of the source", test.name)
+		}
+	}
+
+	sr := rtcpReport(RTCPSenderReport, 1, 0)
+	if !track.rewrite(sr, true) || binary.BigEndian.Uint32(sr[4:]) != track.ssrc {
+		t.Error("sender report not rewritten")
+	}
+	if track.rewrite(rtcpReport(RTCPReceiverReport, 1, 0), true) {
+		t.Error("receiver report of the source passed on")
+	}
+	if track.rewrite(make([]byte, 11), false) || track.rewrite(make([]byte, 7), true) {
+		t.Error("short packet passed on")
+	}
+}
+
+func interleaved(ch byte, b []byte) *[]byte {
+	pkt := append([]byte{'$', ch, byte(len(b) >> 8), byte(len(b))}, b...)
+	return &pkt
+}
+
+// udpPeer is the RTP and RTCP sockets of a client.
+type udpPeer struct {
+	rtp, rtcp *net.UDPConn
+}
+
+func newUDPPeer(t *testing.T) (self udpPeer) {
+	var err error
+	if self.rtp, err = net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}); err != nil {
+		t.Fatal(err)
+	}
+	if self.rtcp, err = net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}); err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+func (self udpPeer) ports() (int, int) {
+	return self.rtp.LocalAddr().(*net.UDPAddr).Port, self.rtcp.LocalAddr().(*net.UDPAddr).Port
+}
+
+func (self udpPeer) Close() {
+	self.rtp.Close()
+	self.rtcp.Close()
+}
+
+func readUDP(conn *net.UDPConn, timeout time.Duration) []byte {
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	b := make([]byte, 1500)
+	n, err := conn.Read(b)
+	if err != nil {
+		return nil
+	}
+	return b[:n]
+}
+
+// tcpPair returns the server end of a TCP connection on the loopback.
+func tcpPair(t *testing.T) (server, client net.Conn) {
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if client, err = net.Dial("tcp4", l.Addr().String()); err != nil {
+		t.Fatal(err)
+	}
+	if server, err = l.Accept(); err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+func TestUDPUnicast(t *testing.T) {
+	udp, err := listenUDP("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer udp.Close()
+	serverRTP, serverRTCP := udp.ports()
+	if serverRTCP != serverRTP+1 {
+		t.Errorf("server ports %d-%d", serverRTP, serverRTCP)
+	}
+
+	var lock sync.Mutex
+	var handled []ReceiverReport
+	proxy := &Proxy{udp: udp, HandleReceiverReport: func(conn *ProxyConn, report ReceiverReport) {
+		lock.Lock()
+		handled = append(handled, report)
+		lock.Unlock()
+	}}
+	netconn, client := tcpPair(t)
+	defer client.Close()
+	conn := NewProxyConn(netconn)
+	conn.proxy = proxy
+	peer := newUDPPeer(t)
+	defer peer.Close()
+	rtp, rtcp := peer.ports()
+
+	transport, err := conn.setup(" RTP/AVP;unicast;client_port=" + strconv.Itoa(rtp) + "-" + strconv.Itoa(rtcp))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(transport, "RTP/AVP;unicast;client_port="+strconv.Itoa(rtp)+"-"+strconv.Itoa(rtcp)+";server_port="+strconv.Itoa(serverRTP)+"-"+strconv.Itoa(serverRTCP)+";ssrc=") {
+		t.Errorf("transport %s", transport)
+	}
+	conn.alive = time.Now()
+	ssrc := conn.tracks[0].ssrc
+
+	// packets of the source go out with the SSRC of the session
+	if err = conn.WritePacket(interleaved(0, rtpPacket(100, 1))); err != nil {
+		t.Fatal(err)
+	}
+	if b := readUDP(peer.rtp, time.Second); len(b) != 16 || binary.BigEndian.Uint32(b[8:]) != ssrc {
+		t.Errorf("rtp %x", b)
+	}
+	if err = conn.WritePacket(interleaved(1, rtcpReport(RTCPSenderReport, 1, 0))); err != nil {
+		t.Fatal(err)
+	}
+	if b := readUDP(peer.rtcp, time.Second); len(b) < 8 || binary.BigEndian.Uint32(b[4:]) != ssrc {
+		t.Errorf("rtcp %x", b)
+	}
+	// tracks not set up are skipped
+	if err = conn.WritePacket(interleaved(2, rtpPacket(100, 1))); err != nil {
+		t.Error(err)
+	}
+
+	// receiver reports are matched to the session by the client address
+	stranger := newUDPPeer(t)
+	defer stranger.Close()
+	server := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: serverRTCP}
+	stranger.rtcp.WriteToUDP(rtcpReport(RTCPReceiverReport, 8, 0, ssrc), server)
+	peer.rtcp.WriteToUDP(rtcpReport(RTCPReceiverReport, 9, 4, ssrc), server)
+	deadline := time.Now().Add(time.Second)
+	for len(conn.ReceiverReports()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	// the report of the stranger was read first
+	reports := conn.ReceiverReports()
+	lock.Lock()
+	if len(reports) != 1 || reports[0].Reporter != 9 || reports[0].PacketsLost != 4 || len(handled) != 1 || handled[0] != reports[0] {
+		t.Errorf("reports %+v, handled %+v", reports, handled)
+	}
+	lock.Unlock()
+
+	// without RTCP for SessionTimeout the session ends
+	conn.lock.Lock()
+	conn.alive = time.Now().Add(-SessionTimeout - time.Second)
+	conn.lock.Unlock()
+	if err = conn.WritePacket(interleaved(0, rtpPacket(101, 1))); err == nil || !strings.Contains(err.Error(), "timeout") {
+		t.Errorf("timed out session: %v", err)
+	}
+
+	conn.Close()
+	if err = conn.WritePacket(interleaved(0, rtpPacket(102, 1))); err == nil {
+		t.Error("write to a closed session")
+	}
+	udp.lock.Lock()
+	if len(udp.sessions) != 0 {
+		t.Errorf("sessions %v after close", udp.sessions)
+	}
+	udp.lock.Unlock()
+}
+
+func TestSetupErrors(t *testing.T) {
+	udp, err := listenUDP("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer udp.Close()
+	for _, test := range []struct {
+		name      string
+		proxy     *Proxy
+		transport string
+		err       string
+	}{
+		{"no udp", &Proxy{}, "RTP/AVP;unicast;client_port=5000-5001", "udp disabled"},
+		{"no proxy", nil, "RTP/AVP;unicast;client_port=5000-5001", "udp disabled"},
+		{"bad port", &Proxy{udp: udp}, "RTP/AVP;unicast;client_port=x-5001", "client_port `x`"},
+		{"bad rtcp port", &Proxy{udp: udp}, "RTP/AVP;unicast;client_port=5000-y", "client_port `y`"},
+		{"no multicast", &Proxy{udp: udp}, "RTP/AVP;multicast", "multicast disabled"},
+		{"bad group", &Proxy{Multicast: &Multicast{Group: "ff02::1"}}, "RTP/AVP;multicast", "group `ff02::1`"},
+	} {
+		netconn, client := tcpPair(t)
+		conn := NewProxyConn(netconn)
+		conn.proxy = test.proxy
+		if _, err := conn.setup(test.transport); err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: %v", test.name, err)
+		}
+		if len(conn.tracks) != 0 {
+			t.Errorf("%s: track set up", test.name)
+		}
+		netconn.Close()
+		client.Close()
+	}
+
+	// a single client port has RTCP on the next one
+	netconn, client := tcpPair(t)
+	defer client.Close()
+	conn := NewProxyConn(netconn)
+	conn.proxy = &Proxy{udp: udp}
+	defer conn.Close()
+	if transport, err := conn.setup("RTP/AVP;unicast;client_port=5000"); err != nil || !strings.Contains(transport, "client_port=5000-5001;") {
+		t.Errorf("%s: %v", transport, err)
+	}
+	if track := conn.tracks[0]; track.rtp.Port != 5000 || track.rtcp.Port != 5001 {
+		t.Errorf("client ports %v %v", track.rtp, track.rtcp)
+	}
+}
+
+func TestMulticast(t *testing.T) {
+	// the loopback stands for the group, which is enough to send to
+	peer := newUDPPeer(t)
+	defer peer.Close()
+	port, _ := peer.ports()
+	multicast := &Multicast{Group: "127.0.0.1", Port: port}
+	defer multicast.Close()
+	if transport := multicast.transport(1); transport != "RTP/AVP;multicast;destination=127.0.0.1;port="+strconv.Itoa(port+2)+"-"+strconv.Itoa(port+3)+";ttl=16" {
+		t.Errorf("transport %s", transport)
+	}
+
+	ssrc, err := multicast.setup(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := multicast.setup(0); again != ssrc {
+		t.Error("tracks of the group differ by session")
+	}
+	// nothing is sent until a session plays
+	multicast.WritePacket(interleaved(0, rtpPacket(100, 1)))
+	if b := readUDP(peer.rtp, 50*time.Millisecond); b != nil {
+		t.Errorf("sent %x without members", b)
+	}
+	multicast.join()
+	for seq := uint16(100); seq < 103; seq++ {
+		if err = multicast.WritePacket(interleaved(0, rtpPacket(seq, 1))); err != nil {
+			t.Fatal(err)
+		}
+		b := readUDP(peer.rtp, time.Second)
+		if len(b) != 16 || binary.BigEndian.Uint32(b[8:]) != ssrc {
+			t.Fatalf("rtp %x", b)
+		}
+	}
+	// tracks not set up are skipped
+	if err = multicast.WritePacket(interleaved(2, rtpPacket(100, 1))); err != nil {
+		t.Error(err)
+	}
+	multicast.leave()
+	multicast.WritePacket(interleaved(0, rtpPacket(103, 1)))
+	if b := readUDP(peer.rtp, 50*time.Millisecond); b != nil {
+		t.Errorf("sent %x after the last member left", b)
+	}
+	if err = multicast.Close(); err != nil {
+		t.Error(err)
+	}
+	multicast.join()
+	if err = multicast.WritePacket(interleaved(0, rtpPacket(104, 1))); err != nil {
+		t.Errorf("write after close: %v", err)
+	}
+}
@@ -0,0 +1,62 @@
+package subtitle
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/deepch/vdk/format/ts/tsio"
+)
+
+// Captions turns captions each replacing the one on screen, as decoded from
+// teletext pages, into cues.
+type Captions struct {
+	cues  []Cue
+	text  string
+	start time.Duration
+}
+
+// Show displays text from tm on, an empty text clears the screen.
+func (self *Captions) Show(tm time.Duration, text string) {
+	text = strings.TrimSpace(text)
+	if text == self.text {
+		return
+	}
+	if self.text != "" && tm > self.start {
+		self.cues = append(self.cues, Cue{Start: self.start, End: tm, Text: self.text})
+	}
+	self.text = text
+	self.start = tm
+}
+
+// Cues returns the cues so far, the caption on screen ending at end.
+func (self *Captions) Cues(end time.Duration) (cues []Cue) {
+	cues = append(cues, self.cues...)
+	if self.text != "" && end > self.start {
+		cues = append(cues, Cue{Start: self.start, End: end, Text: self.text})
+	}
+	return
+}
+
+// WriteWebVTTSegment writes the cues overlapping [start, end) as the WebVTT
+// segment of an HLS subtitle rendition. Cue times are on the timeline of
+// the packets, which for MPEG-TS is the PTS, and X-TIMESTAMP-MAP ties start
+// to the matching media segment.
+func WriteWebVTTSegment(w io.Writer, cues []Cue, start, end time.Duration) (err error) {
+	bw := bufio.NewWriter(w)
+	pts := uint64(start*tsio.PTS_HZ/time.Second) & (1<<33 - 1)
+	fmt.Fprintf(bw, "WEBVTT\nX-TIMESTAMP-MAP=MPEGTS:%d,LOCAL:00:00:00.000\n\n", pts)
+	for _, cue := range cues {
+		if cue.End <= start || cue.Start >= end {
+			continue
+		}
+		fmt.Fprintf(bw, "%s --> %s\n", timestamp(cue.Start-start, "."), timestamp(cue.End-start, "."))
+		for _, line := range textLines(cue.Text) {
+			fmt.Fprintf(bw, "%s\n", escapeVTT.Replace(line))
+		}
+		bw.WriteString("\n")
+	}
+	return bw.Flush()
+}
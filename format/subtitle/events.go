@@ -0,0 +1,116 @@
+package subtitle
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/deepch/vdk/events"
+)
+
+const (
+	DefaultPulseDuration = 3 * time.Second
+	DefaultMaxDuration   = 5 * time.Minute
+)
+
+// DefaultText describes an event as its type, channel and source.
+func DefaultText(event events.Event) string {
+	text := fmt.Sprintf("%s channel %d", event.Type, event.Channel+1)
+	if event.Source != "" {
+		text = event.Source + ": " + text
+	}
+	return text
+}
+
+type eventKey struct {
+	source  string
+	typ     string
+	code    string
+	channel int
+}
+
+// Generator turns events, as they come from an events.Bus, into cues timed
+// from the wall clock time Start of the video. A start event is shown until
+// its stop, a pulse for PulseDuration.
+type Generator struct {
+	Start         time.Time
+	Text          func(events.Event) string // DefaultText if nil
+	PulseDuration time.Duration             // DefaultPulseDuration if zero
+	MaxDuration   time.Duration             // of a start without stop, DefaultMaxDuration if zero
+
+	cues []Cue
+	open map[eventKey]int
+}
+
+func NewGenerator(start time.Time) *Generator {
+	return &Generator{Start: start}
+}
+
+func (self *Generator) text(event events.Event) string {
+	if self.Text != nil {
+		return self.Text(event)
+	}
+	return DefaultText(event)
+}
+
+func (self *Generator) maxDuration() time.Duration {
+	if self.MaxDuration > 0 {
+		return self.MaxDuration
+	}
+	return DefaultMaxDuration
+}
+
+// Add takes an event, events before Start are kept so a range already
+// going on at Start is shown from zero.
+func (self *Generator) Add(event events.Event) {
+	if self.open == nil {
+		self.open = map[eventKey]int{}
+	}
+	key := eventKey{event.Source, event.Type, event.Code, event.Channel}
+	at := event.Time.Sub(self.Start)
+	switch event.State {
+	case events.Start:
+		if _, ok := self.open[key]; ok {
+			return
+		}
+		self.open[key] = len(self.cues)
+		self.cues = append(self.cues, Cue{Start: at, End: at + self.maxDuration(), Text: self.text(event)})
+	case events.Stop:
+		i, ok := self.open[key]
+		if !ok {
+			return
+		}
+		delete(self.open, key)
+		if at < self.cues[i].End {
+			self.cues[i].End = at
+		}
+	case events.Pulse:
+		dur := self.PulseDuration
+		if dur <= 0 {
+			dur = DefaultPulseDuration
+		}
+		self.cues = append(self.cues, Cue{Start: at, End: at + dur, Text: self.text(event)})
+	}
+}
+
+// AddRange takes an annotation shown from one wall clock time to another.
+func (self *Generator) AddRange(text string, from, to time.Time) {
+	self.cues = append(self.cues, Cue{Start: from.Sub(self.Start), End: to.Sub(self.Start), Text: text})
+}
+
+// Cues returns the cues within a video of duration end, sorted and clipped
+// to it, a start without stop lasts MaxDuration.
+func (self *Generator) Cues(end time.Duration) (cues []Cue) {
+	for _, cue := range self.cues {
+		if cue.Start < 0 {
+			cue.Start = 0
+		}
+		if cue.End > end {
+			cue.End = end
+		}
+		if cue.End > cue.Start {
+			cues = append(cues, cue)
+		}
+	}
+	Sort(cues)
+	return
+}
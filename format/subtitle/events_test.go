@@ -0,0 +1,69 @@
+package subtitle
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/deepch/vdk/events"
+)
+
+func TestDefaultText(t *testing.T) {
+	event := events.Event{Type: "motion", Channel: 1}
+	if text := DefaultText(event); text != "motion channel 2" {
+		t.Errorf("text %q", text)
+	}
+	event.Source = "gate"
+	if text := DefaultText(event); text != "gate: motion channel 2" {
+		t.Errorf("text %q", text)
+	}
+}
+
+func TestGenerator(t *testing.T) {
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	at := func(sec int) time.Time { return start.Add(time.Duration(sec) * time.Second) }
+	event := func(typ string, channel int, state events.State, sec int) events.Event {
+		return events.Event{Source: "cam", Type: typ, Channel: channel, State: state, Time: at(sec)}
+	}
+
+	gen := NewGenerator(start)
+	gen.MaxDuration = 20 * time.Second
+	for _, event := range []events.Event{
+		event("motion", 0, events.Start, -5), // going on at start
+		event("motion", 0, events.Start, 2),  // already open
+		event("motion", 0, events.Stop, 4),
+		event("motion", 0, events.Stop, 6), // not open
+		event("motion", 1, events.Start, 3),
+		event("motion", 1, events.Stop, 30), // after MaxDuration
+		event("tamper", 0, events.Pulse, 8),
+		event("line", 0, events.Start, 50), // ends past the video
+		event("line", 1, events.Stop, 55),
+		event("face", 0, events.Pulse, 70), // after the video
+	} {
+		gen.Add(event)
+	}
+	gen.AddRange("note", at(1), at(2))
+	gen.AddRange("empty", at(9), at(9))
+
+	want := []Cue{
+		{Start: 0, End: 4 * time.Second, Text: "cam: motion channel 1"},
+		{Start: 1 * time.Second, End: 2 * time.Second, Text: "note"},
+		{Start: 3 * time.Second, End: 23 * time.Second, Text: "cam: motion channel 2"},
+		{Start: 8 * time.Second, End: 11 * time.Second, Text: "cam: tamper channel 1"},
+		{Start: 50 * time.Second, End: 60 * time.Second, Text: "cam: line channel 1"},
+	}
+	if cues := gen.Cues(time.Minute); !reflect.DeepEqual(cues, want) {
+		t.Errorf("cues\n%v\nwant\n%v", cues, want)
+	}
+
+	gen = &Generator{Start: start, PulseDuration: time.Second, Text: func(event events.Event) string { return event.Type }}
+	gen.Add(event("tamper", 0, events.Pulse, 1))
+	gen.Add(event("motion", 0, events.Start, 2))
+	want = []Cue{
+		{Start: 1 * time.Second, End: 2 * time.Second, Text: "tamper"},
+		{Start: 2 * time.Second, End: 2*time.Second + DefaultMaxDuration, Text: "motion"},
+	}
+	if cues := gen.Cues(time.Hour); !reflect.DeepEqual(cues, want) {
+		t.Errorf("cues\n%v\nwant\n%v", cues, want)
+	}
+}
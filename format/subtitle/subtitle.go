@@ -0,0 +1,80 @@
+// Package subtitle writes timed text cues as SRT or WebVTT, and makes cues
+// out of camera events so exported clips carry their context.
+package subtitle
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Cue is a text shown from Start to End, offsets from the start of the
+// video.
+type Cue struct {
+	Start time.Duration
+	End   time.Duration
+	Text  string
+}
+
+// Sort orders cues by start then end time.
+func Sort(cues []Cue) {
+	sort.SliceStable(cues, func(i, j int) bool {
+		if cues[i].Start != cues[j].Start {
+			return cues[i].Start < cues[j].Start
+		}
+		return cues[i].End < cues[j].End
+	})
+}
+
+func timestamp(tm time.Duration, sep string) string {
+	if tm < 0 {
+		tm = 0
+	}
+	ms := int64(tm / time.Millisecond)
+	return fmt.Sprintf("%02d:%02d:%02d%s%03d", ms/3600000, ms/60000%60, ms/1000%60, sep, ms%1000)
+}
+
+// textLines splits the text of a cue in lines, without the blank ones
+// which would end the cue.
+func textLines(text string) (lines []string) {
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if strings.TrimSpace(line) != "" {
+			lines = append(lines, line)
+		}
+	}
+	return
+}
+
+// WriteSRT writes cues as a SubRip file.
+func WriteSRT(w io.Writer, cues []Cue) (err error) {
+	bw := bufio.NewWriter(w)
+	for i, cue := range cues {
+		fmt.Fprintf(bw, "%d\r\n%s --> %s\r\n", i+1, timestamp(cue.Start, ","), timestamp(cue.End, ","))
+		for _, line := range textLines(cue.Text) {
+			fmt.Fprintf(bw, "%s\r\n", line)
+		}
+		bw.WriteString("\r\n")
+	}
+	return bw.Flush()
+}
+
+// escapeVTT escapes the characters WebVTT takes for markup.
+var escapeVTT = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+// WriteWebVTT writes cues as a WebVTT file.
+func WriteWebVTT(w io.Writer, cues []Cue) (err error) {
+	bw := bufio.NewWriter(w)
+	bw.WriteString("WEBVTT\n\n")
+	for _, cue := range cues {
+		fmt.Fprintf(bw, "%s --> %s\n", timestamp(cue.Start, "."), timestamp(cue.End, "."))
+		for _, line := range textLines(cue.Text) {
+			fmt.Fprintf(bw, "%s\n", escapeVTT.Replace(line))
+		}
+		bw.WriteString("\n")
+	}
+	return bw.Flush()
+}
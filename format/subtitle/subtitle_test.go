@@ -0,0 +1,108 @@
+package subtitle
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestWrite(t *testing.T) {
+	cues := []Cue{
+		{Start: 1500 * time.Millisecond, End: 4 * time.Second, Text: "motion channel 1"},
+		{Start: -time.Second, End: time.Hour + 2*time.Minute + 3*time.Second + 45*time.Millisecond, Text: "\nfirst\n\nsecond\r\n \r\nthird\n"},
+		{Start: time.Minute, End: time.Minute + time.Second, Text: "a <b> & c"},
+	}
+	for _, test := range []struct {
+		name  string
+		write func(*bytes.Buffer, []Cue) error
+		want  string
+	}{
+		{"srt", func(b *bytes.Buffer, cues []Cue) error { return WriteSRT(b, cues) },
+			"1\r\n00:00:01,500 --> 00:00:04,000\r\nmotion channel 1\r\n\r\n" +
+				"2\r\n00:00:00,000 --> 01:02:03,045\r\nfirst\r\nsecond\r\nthird\r\n\r\n" +
+				"3\r\n00:01:00,000 --> 00:01:01,000\r\na <b> & c\r\n\r\n"},
+		{"webvtt", func(b *bytes.Buffer, cues []Cue) error { return WriteWebVTT(b, cues) },
+			"WEBVTT\n\n" +
+				"00:00:01.500 --> 00:00:04.000\nmotion channel 1\n\n" +
+				"00:00:00.000 --> 01:02:03.045\nfirst\nsecond\nthird\n\n" +
+				"00:01:00.000 --> 00:01:01.000\na &lt;b&gt; &amp; c\n\n"},
+	} {
+		var b bytes.Buffer
+		if err := test.write(&b, cues); err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if b.String() != test.want {
+			t.Errorf("%s: wrote %q, want %q", test.name, b.String(), test.want)
+		}
+	}
+}
+
+func TestSort(t *testing.T) {
+	cues := []Cue{
+		{Start: 2, End: 5, Text: "a"},
+		{Start: 1, End: 9, Text: "b"},
+		{Start: 2, End: 3, Text: "c"},
+		{Start: 1, End: 9, Text: "d"},
+	}
+	Sort(cues)
+	var texts string
+	for _, cue := range cues {
+		texts += cue.Text
+	}
+	if texts != "bdca" {
+		t.Errorf("sorted %q, want %q", texts, "bdca")
+	}
+}
+
+func TestCaptions(t *testing.T) {
+	var captions Captions
+	captions.Show(1*time.Second, "hello")
+	captions.Show(2*time.Second, " hello \n") // the same caption
+	captions.Show(3*time.Second, "world")
+	captions.Show(3*time.Second, "again") // replaced at once
+	captions.Show(5*time.Second, "")
+	captions.Show(6*time.Second, "last")
+
+	want := []Cue{
+		{Start: 1 * time.Second, End: 3 * time.Second, Text: "hello"},
+		{Start: 3 * time.Second, End: 5 * time.Second, Text: "again"},
+		{Start: 6 * time.Second, End: 8 * time.Second, Text: "last"},
+	}
+	if cues := captions.Cues(8 * time.Second); !reflect.DeepEqual(cues, want) {
+		t.Errorf("cues %v, want %v", cues, want)
+	}
+	if cues := captions.Cues(6 * time.Second); !reflect.DeepEqual(cues, want[:2]) {
+		t.Errorf("cues at the last caption %v, want %v", cues, want[:2])
+	}
+}
+
+func TestWriteWebVTTSegment(t *testing.T) {
+	cues := []Cue{
+		{Start: 1 * time.Second, End: 10 * time.Second, Text: "before"},
+		{Start: 10 * time.Second, End: 12 * time.Second, Text: "across\n\nstart"},
+		{Start: 13 * time.Second, End: 14 * time.Second, Text: "inside"},
+		{Start: 16 * time.Second, End: 17 * time.Second, Text: "after"},
+	}
+	var b bytes.Buffer
+	if err := WriteWebVTTSegment(&b, cues, 11*time.Second, 16*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	// cue times are relative to start, clamped at zero
+	want := "WEBVTT\nX-TIMESTAMP-MAP=MPEGTS:990000,LOCAL:00:00:00.000\n\n" +
+		"00:00:00.000 --> 00:00:01.000\nacross\nstart\n\n" +
+		"00:00:02.000 --> 00:00:03.000\ninside\n\n"
+	if b.String() != want {
+		t.Errorf("wrote %q, want %q", b.String(), want)
+	}
+
+	// the PTS wraps at 33 bits
+	b.Reset()
+	start := 95444 * time.Second // 8589960000 ticks
+	if err := WriteWebVTTSegment(&b, nil, start, start+time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if want := "WEBVTT\nX-TIMESTAMP-MAP=MPEGTS:25408,LOCAL:00:00:00.000\n\n"; b.String() != want {
+		t.Errorf("wrote %q, want %q", b.String(), want)
+	}
+}
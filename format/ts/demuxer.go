@@ -4,12 +4,17 @@ import (
 	"bufio"
 	"fmt"
 	"io"
+	"sort"
 	"time"
 
 	"github.com/deepch/vdk/av"
+	"github.com/deepch/vdk/av/pktque"
 	"github.com/deepch/vdk/codec/aacparser"
+	"github.com/deepch/vdk/codec/dvbsub"
 	"github.com/deepch/vdk/codec/h264parser"
+	"github.com/deepch/vdk/codec/h265parser"
 	"github.com/deepch/vdk/codec/mjpeg"
+	"github.com/deepch/vdk/codec/teletext"
 	"github.com/deepch/vdk/format/ts/tsio"
 	"github.com/deepch/vdk/utils/bits/pio"
 )
@@ -25,6 +30,43 @@ type Demuxer struct {
 	tshdr   []byte
 	AnnexB  bool
 	stage   int
+	offset  int64
+	pktpos  int64 // offset of the last packet read
+
+	// AudioClock selects whether ADTS audio is timed by the PES timestamps
+	// or by its sample count, drift between the two goes to OnAudioDrift.
+	AudioClock    pktque.AudioClockMode
+	MaxAudioDrift time.Duration
+	OnAudioDrift  func(idx int, drift time.Duration)
+
+	// OnUnknown tells the first packet of each PID skipped once the PMT is
+	// known, such as a stream of a type not supported.
+	OnUnknown func(id string, offset int64, size int64)
+
+	unknownTypes map[uint16]uint8
+	reported     map[uint16]bool
+
+	sections map[uint16][]byte
+	services map[uint16]*Service
+}
+
+// Service is what the SDT and EIT tell about a program.
+type Service struct {
+	ServiceID uint16
+	Type      uint8
+	Provider  string
+	Name      string
+	Present   *Event
+	Following *Event
+}
+
+type Event struct {
+	EventID  uint16
+	Start    time.Time
+	Duration time.Duration
+	Language string
+	Name     string
+	Text     string
 }
 
 func NewDemuxer(r io.Reader) *Demuxer {
@@ -96,6 +138,34 @@ func (self *Demuxer) poll() (err error) {
 	return
 }
 
+// Resync skips the packet which failed up to the next one followed by
+// another sync byte, partial PES data is dropped.
+func (self *Demuxer) Resync() (offset int64, length int64, err error) {
+	offset = self.pktpos
+	for _, stream := range self.streams {
+		stream.data = nil
+	}
+	for {
+		var b []byte
+		b, err = self.r.Peek(len(self.tshdr) + 1)
+		if len(b) < len(self.tshdr) {
+			n, _ := self.r.Discard(len(b))
+			self.offset += int64(n)
+			length = self.offset - offset
+			err = io.EOF
+			return
+		}
+		if b[0] == 0x47 && (len(b) == len(self.tshdr) || b[len(self.tshdr)] == 0x47) {
+			err = nil
+			break
+		}
+		self.r.Discard(1)
+		self.offset++
+	}
+	length = self.offset - offset
+	return
+}
+
 func (self *Demuxer) initPMT(payload []byte) (err error) {
 	var psihdrlen int
 	var datalen int
@@ -108,9 +178,9 @@ func (self *Demuxer) initPMT(payload []byte) (err error) {
 	}
 
 	self.streams = []*Stream{}
-	for i, info := range self.pmt.ElementaryStreamInfos {
+	for _, info := range self.pmt.ElementaryStreamInfos {
 		stream := &Stream{}
-		stream.idx = i
+		stream.idx = len(self.streams)
 		stream.demuxer = self
 		stream.pid = info.ElementaryPID
 		stream.streamType = info.StreamType
@@ -120,12 +190,47 @@ func (self *Demuxer) initPMT(payload []byte) (err error) {
 		case tsio.ElementaryStreamTypeAdtsAAC:
 			self.streams = append(self.streams, stream)
 		case tsio.ElementaryStreamTypeAlignmentDescriptor:
+			// subtitles may not show up for a long time, their codec data
+			// comes from the descriptors
+			for _, desc := range info.Descriptors {
+				switch desc.Tag {
+				case tsio.DescriptorTagSubtitling:
+					stream.CodecData = dvbsub.NewCodecData(desc.Data)
+				case tsio.DescriptorTagTeletext:
+					stream.CodecData = teletext.NewCodecData(desc.Data)
+				}
+			}
 			self.streams = append(self.streams, stream)
+		default:
+			if self.unknownTypes == nil {
+				self.unknownTypes = map[uint16]uint8{}
+			}
+			self.unknownTypes[info.ElementaryPID] = info.StreamType
 		}
 	}
 	return
 }
 
+func (self *Demuxer) reportUnknown(pid uint16) {
+	if self.OnUnknown == nil || pid == 0 || pid == 0x1fff || self.reported[pid] {
+		return
+	}
+	for _, entry := range self.pat.Entries {
+		if entry.ProgramMapPID == pid {
+			return
+		}
+	}
+	if self.reported == nil {
+		self.reported = map[uint16]bool{}
+	}
+	self.reported[pid] = true
+	id := fmt.Sprintf("PID 0x%04x", pid)
+	if typ, ok := self.unknownTypes[pid]; ok {
+		id += fmt.Sprintf(" stream type 0x%02x", typ)
+	}
+	self.OnUnknown(id, self.pktpos, int64(len(self.tshdr)))
+}
+
 func (self *Demuxer) payloadEnd() (n int, err error) {
 	for _, stream := range self.streams {
 		var i int
@@ -143,7 +248,10 @@ func (self *Demuxer) readTSPacket() (err error) {
 	var start bool
 	var iskeyframe bool
 
-	if _, err = io.ReadFull(self.r, self.tshdr); err != nil {
+	self.pktpos = self.offset
+	n, err := io.ReadFull(self.r, self.tshdr)
+	self.offset += int64(n)
+	if err != nil {
 		return
 	}
 
@@ -152,6 +260,12 @@ func (self *Demuxer) readTSPacket() (err error) {
 	}
 	payload := self.tshdr[hdrlen:]
 
+	if pid == tsio.SDT_PID || pid == tsio.EIT_PID {
+		// service information is optional, broken sections are skipped
+		self.handleSection(pid, start, payload)
+		return
+	}
+
 	if self.pat == nil {
 		if pid == 0 {
 			var psihdrlen int
@@ -179,21 +293,134 @@ func (self *Demuxer) readTSPacket() (err error) {
 				if stream.streamType == tsio.ElementaryStreamTypeAdtsAAC {
 					iskeyframe = false
 				}
-				if err = stream.handleTSPacket(start, iskeyframe, payload); err != nil {
-					return
-				}
-				break
+				err = stream.handleTSPacket(start, iskeyframe, payload)
+				return
 			}
 		}
+		self.reportUnknown(pid)
 	}
 
 	return
 }
 
+// Services returns the services described so far, by service id.
+func (self *Demuxer) Services() (services []Service) {
+	for _, service := range self.services {
+		services = append(services, *service)
+	}
+	sort.Slice(services, func(i, j int) bool {
+		return services[i].ServiceID < services[j].ServiceID
+	})
+	return
+}
+
+func (self *Demuxer) service(id uint16) *Service {
+	if self.services == nil {
+		self.services = map[uint16]*Service{}
+	}
+	service := self.services[id]
+	if service == nil {
+		service = &Service{ServiceID: id}
+		self.services[id] = service
+	}
+	return service
+}
+
+// handleSection gathers the sections of pid, which may span TS packets.
+func (self *Demuxer) handleSection(pid uint16, start bool, payload []byte) {
+	if self.sections == nil {
+		self.sections = map[uint16][]byte{}
+	}
+	buf, pending := self.sections[pid]
+	if start {
+		if len(payload) == 0 || 1+int(payload[0]) > len(payload) {
+			delete(self.sections, pid)
+			return
+		}
+		pointer := 1 + int(payload[0])
+		if pending {
+			// the bytes before the pointer field end the section in progress
+			self.parseSections(append(buf, payload[1:pointer]...))
+		}
+		buf = append([]byte(nil), payload[pointer:]...)
+	} else if pending {
+		buf = append(buf, payload...)
+	} else {
+		return
+	}
+	if buf = self.parseSections(buf); buf != nil {
+		self.sections[pid] = buf
+	} else {
+		delete(self.sections, pid)
+	}
+}
+
+// parseSections parses the complete sections at the start of buf, it
+// returns the incomplete rest or nil once only stuffing is left.
+func (self *Demuxer) parseSections(buf []byte) []byte {
+	for len(buf) > 0 && buf[0] != 0xff {
+		if len(buf) < 3 {
+			return buf
+		}
+		end := 3 + int(pio.U16BE(buf[1:])&0xfff)
+		if len(buf) < end {
+			return buf
+		}
+		self.parseSection(buf[:end])
+		buf = buf[end:]
+	}
+	return nil
+}
+
+func (self *Demuxer) parseSection(section []byte) {
+	tableid, tableext, hdrlen, datalen, err := tsio.ParsePSI(append([]byte{0}, section...))
+	if err != nil || hdrlen+datalen > len(section)+1 {
+		return
+	}
+	data := section[hdrlen-1 : hdrlen-1+datalen]
+	switch tableid {
+	case tsio.TableIdSDT:
+		var sdt tsio.SDT
+		if _, err = sdt.Unmarshal(data); err != nil {
+			return
+		}
+		for _, entry := range sdt.Services {
+			service := self.service(entry.ServiceID)
+			for _, desc := range entry.Descriptors {
+				if typ, provider, name, ok := tsio.ParseServiceDescriptor(desc); ok {
+					service.Type, service.Provider, service.Name = typ, provider, name
+				}
+			}
+		}
+	case tsio.TableIdEITPresentFollowing:
+		var eit tsio.EIT
+		if _, err = eit.Unmarshal(data); err != nil {
+			return
+		}
+		service := self.service(tableext)
+		var event *Event
+		for _, entry := range eit.Events {
+			event = &Event{EventID: entry.EventID, Start: entry.StartTime, Duration: entry.Duration}
+			for _, desc := range entry.Descriptors {
+				if lang, name, text, ok := tsio.ParseShortEventDescriptor(desc); ok {
+					event.Language, event.Name, event.Text = lang, name, text
+				}
+			}
+		}
+		// section 0 is the present event, 1 the following
+		if section[6] == 0 {
+			service.Present = event
+		} else {
+			service.Following = event
+		}
+	}
+}
+
 func (self *Stream) addPacket(payload []byte, timedelta time.Duration, fixed time.Duration) {
-	dts := self.dts
-	pts := self.pts
+	self.addPacketAt(payload, self.pts, self.dts, self.iskeyframe, timedelta, fixed)
+}
 
+func (self *Stream) addPacketAt(payload []byte, pts, dts time.Duration, iskeyframe bool, timedelta time.Duration, fixed time.Duration) {
 	if dts == 0 {
 		dts = pts
 	}
@@ -211,7 +438,7 @@ func (self *Stream) addPacket(payload []byte, timedelta time.Duration, fixed tim
 	demuxer := self.demuxer
 	pkt := av.Packet{
 		Idx:        int8(self.idx),
-		IsKeyFrame: self.iskeyframe,
+		IsKeyFrame: iskeyframe,
 		Time:       dts + timedelta,
 		Data:       payload,
 		Duration:   dur,
@@ -219,12 +446,50 @@ func (self *Stream) addPacket(payload []byte, timedelta time.Duration, fixed tim
 	if pts != dts {
 		pkt.CompositionTime = pts - dts
 	}
+	switch self.streamType {
+	case tsio.ElementaryStreamTypeH264:
+		pkt.Flags = h264parser.FrameFlags(payload)
+	case tsio.ElementaryStreamTypeH265:
+		pkt.Flags = h265parser.FrameFlags(payload)
+	}
 	demuxer.pkts = append(demuxer.pkts, pkt)
 }
 
+func (self *Stream) addAudioPacket(payload []byte, timedelta time.Duration, dur time.Duration) {
+	demuxer := self.demuxer
+	if self.aclock == nil {
+		idx := self.idx
+		self.aclock = &pktque.AudioClock{
+			Mode:     demuxer.AudioClock,
+			MaxDrift: demuxer.MaxAudioDrift,
+		}
+		if demuxer.OnAudioDrift != nil {
+			self.aclock.OnDrift = func(drift time.Duration) {
+				demuxer.OnAudioDrift(idx, drift)
+			}
+		}
+	}
+	dts := self.dts
+	if dts == 0 {
+		dts = self.pts
+	}
+	tm, _ := self.aclock.Next(dts+timedelta, dur)
+	self.pt = tm
+	demuxer.pkts = append(demuxer.pkts, av.Packet{
+		Idx:      int8(self.idx),
+		Time:     tm,
+		Data:     payload,
+		Duration: dur,
+	})
+}
+
 func (self *Stream) payloadEnd() (n int, err error) {
 	payload := self.data
 	if payload == nil {
+		if self.field != nil {
+			self.flushField()
+			n++
+		}
 		return
 	}
 	if self.datalen != 0 && len(payload) != self.datalen {
@@ -237,6 +502,16 @@ func (self *Stream) payloadEnd() (n int, err error) {
 		if self.CodecData == nil {
 			self.CodecData = mjpeg.CodecData{}
 		}
+		if self.Type().IsData() {
+			self.demuxer.pkts = append(self.demuxer.pkts, av.Packet{
+				Idx:        int8(self.idx),
+				IsKeyFrame: true,
+				Time:       self.pts,
+				Data:       payload,
+			})
+			n++
+			break
+		}
 		b := make([]byte, 4+len(payload))
 		pio.PutU32BE(b[0:4], uint32(len(payload)))
 		copy(b[4:], payload)
@@ -256,7 +531,7 @@ func (self *Stream) payloadEnd() (n int, err error) {
 					return
 				}
 			}
-			self.addPacket(payload[hdrlen:framelen], delta, time.Duration(samples)*time.Second/time.Duration(config.SampleRate))
+			self.addAudioPacket(payload[hdrlen:framelen], delta, time.Duration(samples)*time.Second/time.Duration(config.SampleRate))
 			n++
 			delta += time.Duration(samples) * time.Second / time.Duration(config.SampleRate)
 			payload = payload[framelen:]
@@ -275,6 +550,7 @@ func (self *Stream) payloadEnd() (n int, err error) {
 					info, err := h264parser.ParseSPS(sps)
 					if err == nil {
 						self.fps = info.FPS
+						self.spsInfo = info
 					}
 				case naltype == 8:
 					pps = nalu
@@ -284,6 +560,13 @@ func (self *Stream) payloadEnd() (n int, err error) {
 						b := make([]byte, 4+len(nalu))
 						pio.PutU32BE(b[0:4], uint32(len(nalu)))
 						copy(b[4:], nalu)
+						if self.spsInfo.FrameMbsOnly == 0 && self.spsInfo.MbWidth != 0 {
+							handled, emitted := self.addField(nalu, b)
+							n += emitted
+							if handled {
+								continue
+							}
+						}
 						fps := self.fps
 						if self.fps == 0 {
 							fps = 25
@@ -304,15 +587,72 @@ func (self *Stream) payloadEnd() (n int, err error) {
 		}
 
 		if self.CodecData == nil && len(sps) > 0 && len(pps) > 0 {
-			if self.CodecData, err = h264parser.NewCodecDataFromSPSAndPPS(sps, pps); err != nil {
+			var codec h264parser.CodecData
+			if codec, err = h264parser.NewCodecDataFromSPSAndPPS(sps, pps); err != nil {
 				return
 			}
+			codec.Fields = self.fieldOrder
+			self.CodecData = codec
 		}
 	}
 
 	return
 }
 
+// addField gathers field coded slices into one packet per field pair,
+// frame coded slices are left to the caller.
+func (self *Stream) addField(nalu []byte, avcc []byte) (handled bool, n int) {
+	info, err := h264parser.ParseSliceFieldInfo(nalu, self.spsInfo)
+	if err != nil || !info.FieldPic {
+		if self.field != nil {
+			self.flushField()
+			n++
+		}
+		return
+	}
+	handled = true
+	if self.fieldOrder == av.FieldOrderUnknown {
+		if info.BottomField {
+			self.fieldOrder = av.FieldOrderBottomFirst
+		} else {
+			self.fieldOrder = av.FieldOrderTopFirst
+		}
+	}
+	if field := self.field; field != nil {
+		switch {
+		case info.FirstMb != 0 && info.BottomField == field.last:
+			field.data = append(field.data, avcc...)
+			return
+		case info.FirstMb == 0 && !field.paired && info.BottomField != field.bottom:
+			field.paired = true
+			field.last = info.BottomField
+			field.data = append(field.data, avcc...)
+			return
+		}
+		self.flushField()
+		n++
+	}
+	self.field = &fieldPicture{
+		data:       avcc,
+		pts:        self.pts,
+		dts:        self.dts,
+		iskeyframe: self.iskeyframe,
+		bottom:     info.BottomField,
+		last:       info.BottomField,
+	}
+	return
+}
+
+func (self *Stream) flushField() {
+	field := self.field
+	self.field = nil
+	fps := self.fps
+	if fps == 0 {
+		fps = 25
+	}
+	self.addPacketAt(field.data, field.pts, field.dts, field.iskeyframe, time.Duration(0), time.Second/time.Duration(fps))
+}
+
 func (self *Stream) handleTSPacket(start bool, iskeyframe bool, payload []byte) (err error) {
 	if start {
 		if _, err = self.payloadEnd(); err != nil {
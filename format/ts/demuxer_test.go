@@ -0,0 +1,101 @@
+package ts
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/deepch/vdk/format/ts/tsio"
+)
+
+// eitSection returns an EIT present/following section, without the pointer
+// field, whose event text is padded so the section is size bytes long.
+func eitSection(serviceID uint16, following bool, name string, size int) []byte {
+	eit := func(text string) tsio.EIT {
+		return tsio.EIT{Events: []tsio.EITEvent{{
+			EventID:     1,
+			Descriptors: []tsio.Descriptor{tsio.ShortEventDescriptor("eng", name, text)},
+		}}}
+	}
+	text := strings.Repeat("x", size-(tsio.PSIHeaderLength-1+eit("").Len()+4))
+	b := make([]byte, 1024)
+	data := eit(text)
+	data.Marshal(b[tsio.PSIHeaderLength:])
+	n := tsio.FillPSI(b, tsio.TableIdEITPresentFollowing, serviceID, data.Len())
+	if following {
+		b[7] = 1
+	}
+	return b[1:n]
+}
+
+// sectionPackets splits sections over TS packets of pid, a packet starting
+// a section points to the first one which starts in it.
+func sectionPackets(pid uint16, sections ...[]byte) []byte {
+	var data []byte
+	var starts []int
+	for _, section := range sections {
+		starts = append(starts, len(data))
+		data = append(data, section...)
+	}
+	var out []byte
+	for pos := 0; pos < len(data); {
+		pkt := bytes.Repeat([]byte{0xff}, 188)
+		pkt[0], pkt[1], pkt[2], pkt[3] = 0x47, byte(pid>>8), byte(pid), 0x10
+		payload := pkt[4:]
+		for _, start := range starts {
+			if start >= pos && start < pos+len(payload)-1 {
+				pkt[1] |= 0x40
+				payload[0] = byte(start - pos)
+				payload = payload[1:]
+				break
+			}
+		}
+		pos += copy(payload, data[pos:])
+		out = append(out, pkt...)
+	}
+	return out
+}
+
+func readSections(t *testing.T, stream []byte) []Service {
+	demuxer := NewDemuxer(bytes.NewReader(stream))
+	for {
+		if err := demuxer.readTSPacket(); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return demuxer.Services()
+}
+
+func TestSectionsAcrossPackets(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		sizes [3]int
+	}{
+		// the second section starts in the packet ending the first one
+		{"pointer", [3]int{250, 60, 60}},
+		// the header of the second section is cut after two bytes
+		{"short tail", [3]int{181, 250, 60}},
+	} {
+		stream := sectionPackets(tsio.EIT_PID,
+			eitSection(1, false, "now", test.sizes[0]),
+			eitSection(1, true, "next", test.sizes[1]),
+			eitSection(2, false, "other", test.sizes[2]),
+		)
+		services := readSections(t, stream)
+		if len(services) != 2 {
+			t.Fatalf("%s: got %d services, want 2", test.name, len(services))
+		}
+		if event := services[0].Present; event == nil || event.Name != "now" {
+			t.Errorf("%s: present event %+v", test.name, event)
+		}
+		if event := services[0].Following; event == nil || event.Name != "next" {
+			t.Errorf("%s: following event %+v", test.name, event)
+		}
+		if event := services[1].Present; event == nil || event.Name != "other" {
+			t.Errorf("%s: other service event %+v", test.name, event)
+		}
+	}
+}
@@ -4,15 +4,34 @@ import (
 	"fmt"
 	"github.com/deepch/vdk/codec/h265parser"
 	"io"
+	"sort"
 	"time"
 
 	"github.com/deepch/vdk/av"
 	"github.com/deepch/vdk/codec/aacparser"
+	"github.com/deepch/vdk/codec/dvbsub"
 	"github.com/deepch/vdk/codec/h264parser"
+	"github.com/deepch/vdk/codec/teletext"
 	"github.com/deepch/vdk/format/ts/tsio"
 )
 
-var CodecTypes = []av.CodecType{av.H264, av.H265, av.AAC}
+var CodecTypes = []av.CodecType{av.H264, av.H265, av.AAC, av.DVB_SUBTITLE, av.DVB_TELETEXT}
+
+// StreamConfig sets how a stream is carried, zero fields keep the defaults.
+type StreamConfig struct {
+	PID         uint16
+	StreamType  uint8
+	Descriptors []tsio.Descriptor
+}
+
+var teletextStuffing = func() []byte {
+	b := make([]byte, 46)
+	for i := range b {
+		b[i] = 0xff
+	}
+	b[1] = 44
+	return b
+}()
 
 type Muxer struct {
 	w       io.Writer
@@ -20,6 +39,24 @@ type Muxer struct {
 
 	PaddingToMakeCounterCont bool
 
+	// PID plan, applied by WriteHeader. The default is program 1 with its
+	// PMT on tsio.PMT_PID and the streams on 0x100 onwards, the pids below
+	// 0x20 and 0x1fff are reserved.
+	TransportStreamID  uint16
+	ProgramNumber      uint16
+	PMTPID             uint16
+	PCRPID             uint16 // pid of a stream, the first video stream if zero
+	ProgramDescriptors []tsio.Descriptor
+	Streams            []StreamConfig // by stream index
+
+	// An SDT goes with the PAT and PMT so receivers can show a service
+	// name, unless DisableSDT is set.
+	ServiceProvider string // "vdk" if empty
+	ServiceName     string // "Service01" if empty
+	DisableSDT      bool
+
+	pcrpid uint16
+
 	psidata []byte
 	peshdr  []byte
 	tshdr   []byte
@@ -27,20 +64,21 @@ type Muxer struct {
 	datav   [][]byte
 	nalus   [][]byte
 
-	tswpat, tswpmt *tsio.TSWriter
+	tswpat, tswpmt, tswsdt *tsio.TSWriter
 }
 
 func NewMuxer(w io.Writer) *Muxer {
 	return &Muxer{
 		w:       w,
 		psidata: make([]byte, 188),
-		peshdr:  make([]byte, tsio.MaxPESHeaderLength),
+		peshdr:  make([]byte, tsio.TeletextPESHeaderLength),
 		tshdr:   make([]byte, tsio.MaxTSHeaderLength),
 		adtshdr: make([]byte, aacparser.ADTSHeaderLength),
 		nalus:   make([][]byte, 16),
 		datav:   make([][]byte, 16),
 		tswpmt:  tsio.NewTSWriter(tsio.PMT_PID),
 		tswpat:  tsio.NewTSWriter(tsio.PAT_PID),
+		tswsdt:  tsio.NewTSWriter(tsio.SDT_PID),
 	}
 }
 
@@ -58,12 +96,17 @@ func (self *Muxer) newStream(idx int, codec av.CodecData) (err error) {
 	}
 
 	pid := uint16(idx + 0x100)
+	if idx < len(self.Streams) && self.Streams[idx].PID != 0 {
+		pid = self.Streams[idx].PID
+	}
 	stream := &Stream{
 		muxer:     self,
 		CodecData: codec,
 		pid:       pid,
 		tsw:       tsio.NewTSWriter(pid),
 	}
+	// teletext PES fill whole packets
+	stream.tsw.OmitEmptyAdaptation = codec.Type() == av.DVB_TELETEXT
 	self.streams[idx] = stream
 	return
 }
@@ -98,42 +141,145 @@ func (self *Muxer) SetWriter(w io.Writer) {
 	return
 }
 
+func (self *Muxer) pmtPID() uint16 {
+	if self.PMTPID != 0 {
+		return self.PMTPID
+	}
+	return tsio.PMT_PID
+}
+
+func (self *Muxer) programNumber() uint16 {
+	if self.ProgramNumber != 0 {
+		return self.ProgramNumber
+	}
+	return 1
+}
+
+func (self *Muxer) transportStreamID() uint16 {
+	if self.TransportStreamID != 0 {
+		return self.TransportStreamID
+	}
+	return tsio.TableExtPAT
+}
+
+func (self *Muxer) streamIdxs() (idxs []int) {
+	for idx := range self.streams {
+		idxs = append(idxs, idx)
+	}
+	sort.Ints(idxs)
+	return
+}
+
+// reservedPID tells the pids of the PAT, of the other tables of MPEG and DVB
+// such as the SDT on tsio.SDT_PID, and of null packets.
+func reservedPID(pid uint16) bool {
+	return pid < 0x20 || pid > 0x1ffe
+}
+
+func (self *Muxer) checkPIDs() (err error) {
+	if pid := self.pmtPID(); reservedPID(pid) {
+		err = fmt.Errorf("ts: pmt pid 0x%x is reserved", pid)
+		return
+	}
+	used := map[uint16]int{}
+	for _, idx := range self.streamIdxs() {
+		pid := self.streams[idx].pid
+		if reservedPID(pid) || pid == self.pmtPID() {
+			err = fmt.Errorf("ts: stream %d pid 0x%x is reserved", idx, pid)
+			return
+		}
+		if other, ok := used[pid]; ok {
+			err = fmt.Errorf("ts: streams %d and %d share pid 0x%x", other, idx, pid)
+			return
+		}
+		used[pid] = idx
+	}
+
+	self.pcrpid = self.PCRPID
+	if self.pcrpid != 0 {
+		// the PCR is carried by the packets of an audio or video stream
+		idx, ok := used[self.pcrpid]
+		if !ok {
+			err = fmt.Errorf("ts: pcr pid 0x%x is not the pid of a stream", self.pcrpid)
+		} else if self.streams[idx].Type().IsData() {
+			err = fmt.Errorf("ts: pcr pid 0x%x is the pid of data stream %d", self.pcrpid, idx)
+		}
+		return
+	}
+	// the first video stream, else the first audio one, else no PCR
+	self.pcrpid = 0x1fff
+	audio := false
+	for _, idx := range self.streamIdxs() {
+		stream := self.streams[idx]
+		if stream.Type().IsVideo() {
+			self.pcrpid = stream.pid
+			break
+		}
+		if stream.Type().IsAudio() && !audio {
+			self.pcrpid = stream.pid
+			audio = true
+		}
+	}
+	return
+}
+
+// pcr is the PCR to write with a packet of stream, none off the pcr pid.
+func (self *Muxer) pcr(stream *Stream, tm time.Duration) time.Duration {
+	if stream.pid != self.pcrpid {
+		return 0
+	}
+	return tm
+}
+
 func (self *Muxer) WritePATPMT() (err error) {
 	pat := tsio.PAT{
 		Entries: []tsio.PATEntry{
-			{ProgramNumber: 1, ProgramMapPID: tsio.PMT_PID},
+			{ProgramNumber: self.programNumber(), ProgramMapPID: self.pmtPID()},
 		},
 	}
 	patlen := pat.Marshal(self.psidata[tsio.PSIHeaderLength:])
-	n := tsio.FillPSI(self.psidata, tsio.TableIdPAT, tsio.TableExtPAT, patlen)
+	n := tsio.FillPSI(self.psidata, tsio.TableIdPAT, self.transportStreamID(), patlen)
 	self.datav[0] = self.psidata[:n]
 	if err = self.tswpat.WritePackets(self.w, self.datav[:1], 0, false, true); err != nil {
 		return
 	}
 
 	var elemStreams []tsio.ElementaryStreamInfo
-	for _, stream := range self.streams {
+	for _, idx := range self.streamIdxs() {
+		stream := self.streams[idx]
+		info := tsio.ElementaryStreamInfo{ElementaryPID: stream.pid}
 		switch stream.Type() {
 		case av.AAC:
-			elemStreams = append(elemStreams, tsio.ElementaryStreamInfo{
-				StreamType:    tsio.ElementaryStreamTypeAdtsAAC,
-				ElementaryPID: stream.pid,
-			})
+			info.StreamType = tsio.ElementaryStreamTypeAdtsAAC
 		case av.H264:
-			elemStreams = append(elemStreams, tsio.ElementaryStreamInfo{
-				StreamType:    tsio.ElementaryStreamTypeH264,
-				ElementaryPID: stream.pid,
-			})
+			info.StreamType = tsio.ElementaryStreamTypeH264
 		case av.H265:
-			elemStreams = append(elemStreams, tsio.ElementaryStreamInfo{
-				StreamType:    tsio.ElementaryStreamTypeH265,
-				ElementaryPID: stream.pid,
-			})
+			info.StreamType = tsio.ElementaryStreamTypeH265
+		case av.DVB_SUBTITLE:
+			info.StreamType = tsio.ElementaryStreamTypeAlignmentDescriptor
+			info.Descriptors = []tsio.Descriptor{{
+				Tag:  tsio.DescriptorTagSubtitling,
+				Data: stream.CodecData.(dvbsub.CodecData).Descriptor,
+			}}
+		case av.DVB_TELETEXT:
+			info.StreamType = tsio.ElementaryStreamTypeAlignmentDescriptor
+			info.Descriptors = []tsio.Descriptor{{
+				Tag:  tsio.DescriptorTagTeletext,
+				Data: stream.CodecData.(teletext.CodecData).Descriptor,
+			}}
+		}
+		if idx < len(self.Streams) {
+			if config := self.Streams[idx]; config.StreamType != 0 {
+				info.StreamType = config.StreamType
+			}
+			info.Descriptors = append(info.Descriptors, self.Streams[idx].Descriptors...)
 		}
+		elemStreams = append(elemStreams, info)
 	}
 
 	pmt := tsio.PMT{
-		PCRPID:                0x100,
+		PCRPID:                self.pcrpid,
+		ProgramDescriptors:    self.ProgramDescriptors,
 		ElementaryStreamInfos: elemStreams,
 	}
 	pmtlen := pmt.Len()
@@ -142,24 +288,62 @@ func (self *Muxer) WritePATPMT() (err error) {
 		return
 	}
 	pmt.Marshal(self.psidata[tsio.PSIHeaderLength:])
-	n = tsio.FillPSI(self.psidata, tsio.TableIdPMT, tsio.TableExtPMT, pmtlen)
+	n = tsio.FillPSI(self.psidata, tsio.TableIdPMT, self.programNumber(), pmtlen)
 	self.datav[0] = self.psidata[:n]
 	if err = self.tswpmt.WritePackets(self.w, self.datav[:1], 0, false, true); err != nil {
 		return
 	}
 
+	if !self.DisableSDT {
+		if err = self.writeSDT(); err != nil {
+			return
+		}
+	}
 	return
 }
 
+func (self *Muxer) writeSDT() (err error) {
+	provider, name := self.ServiceProvider, self.ServiceName
+	if provider == "" {
+		provider = "vdk"
+	}
+	if name == "" {
+		name = "Service01"
+	}
+	sdt := tsio.SDT{
+		OriginalNetworkID: 0xff01,
+		Services: []tsio.SDTService{{
+			ServiceID:     self.programNumber(),
+			RunningStatus: tsio.RunningStatusRunning,
+			Descriptors:   []tsio.Descriptor{tsio.ServiceDescriptor(tsio.ServiceTypeDigitalTV, provider, name)},
+		}},
+	}
+	sdtlen := sdt.Len()
+	if sdtlen+tsio.PSIHeaderLength+4 > len(self.psidata) {
+		err = fmt.Errorf("ts: sdt too large")
+		return
+	}
+	sdt.Marshal(self.psidata[tsio.PSIHeaderLength:])
+	n := tsio.FillPSI(self.psidata, tsio.TableIdSDT, self.transportStreamID(), sdtlen)
+	self.datav[0] = self.psidata[:n]
+	return self.tswsdt.WritePackets(self.w, self.datav[:1], 0, false, true)
+}
+
 func (self *Muxer) WriteHeader(streams []av.CodecData) (err error) {
 	self.streams = map[int]*Stream{}
 
+	self.tswpmt = tsio.NewTSWriter(self.pmtPID())
+
 	for idx, stream := range streams {
 		if err = self.newStream(idx, stream); err != nil {
 			fmt.Println(err)
 		}
 	}
 
+	if err = self.checkPIDs(); err != nil {
+		return
+	}
+
 	if err = self.WritePATPMT(); err != nil {
 		return
 	}
@@ -186,7 +370,35 @@ func (self *Muxer) WritePacket(pkt av.Packet) (err error) {
 		self.datav[1] = self.adtshdr
 		self.datav[2] = pkt.Data
 
-		if err = stream.tsw.WritePackets(self.w, self.datav[:3], pkt.Time, true, false); err != nil {
+		if err = stream.tsw.WritePackets(self.w, self.datav[:3], self.pcr(stream, pkt.Time), true, false); err != nil {
+			return
+		}
+
+	case av.DVB_SUBTITLE:
+		n := tsio.FillPESHeader(self.peshdr, tsio.StreamIdPrivate1, len(pkt.Data), pkt.Time, 0)
+		self.datav[0] = self.peshdr[:n]
+		self.datav[1] = pkt.Data
+		if err = stream.tsw.WritePackets(self.w, self.datav[:2], self.pcr(stream, pkt.Time), false, false); err != nil {
+			return
+		}
+
+	case av.DVB_TELETEXT:
+		// the PES must end on a TS packet boundary, pad with stuffing data
+		// units, the data_identifier and 46 byte data units of pkt.Data
+		// always leave room for whole ones
+		padding := (184 - (tsio.TeletextPESHeaderLength+len(pkt.Data))%184) % 184
+		if padding%46 != 0 {
+			err = fmt.Errorf("ts: teletext packet of %d bytes is not a data_identifier and 46 byte data units", len(pkt.Data))
+			return
+		}
+		n := tsio.FillTeletextPESHeader(self.peshdr, len(pkt.Data)+padding, pkt.Time)
+		self.datav[0] = self.peshdr[:n]
+		self.datav[1] = pkt.Data
+		datav := self.datav[:2]
+		for ; padding > 0; padding -= 46 {
+			datav = append(datav, teletextStuffing)
+		}
+		if err = stream.tsw.WritePackets(self.w, datav, self.pcr(stream, pkt.Time), false, false); err != nil {
 			return
 		}
 
@@ -216,7 +428,7 @@ func (self *Muxer) WritePacket(pkt av.Packet) (err error) {
 		n := tsio.FillPESHeader(self.peshdr, tsio.StreamIdH264, -1, pkt.Time+pkt.CompositionTime, pkt.Time)
 		datav[0] = self.peshdr[:n]
 
-		if err = stream.tsw.WritePackets(self.w, datav, pkt.Time, pkt.IsKeyFrame, false); err != nil {
+		if err = stream.tsw.WritePackets(self.w, datav, self.pcr(stream, pkt.Time), pkt.IsKeyFrame, false); err != nil {
 			return
 		}
 	case av.H265:
@@ -246,7 +458,7 @@ func (self *Muxer) WritePacket(pkt av.Packet) (err error) {
 		n := tsio.FillPESHeader(self.peshdr, tsio.StreamIdH264, -1, pkt.Time+pkt.CompositionTime, pkt.Time)
 		datav[0] = self.peshdr[:n]
 
-		if err = stream.tsw.WritePackets(self.w, datav, pkt.Time, pkt.IsKeyFrame, false); err != nil {
+		if err = stream.tsw.WritePackets(self.w, datav, self.pcr(stream, pkt.Time), pkt.IsKeyFrame, false); err != nil {
 			return
 		}
 	}
@@ -0,0 +1,167 @@
+package ts
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/deepch/vdk/av"
+	"github.com/deepch/vdk/av/generator"
+	"github.com/deepch/vdk/codec/dvbsub"
+	"github.com/deepch/vdk/codec/teletext"
+	"github.com/deepch/vdk/format/ts/tsio"
+)
+
+// pcrPIDs counts the TS packets carrying a PCR by pid.
+func pcrPIDs(b []byte) map[uint16]int {
+	pids := map[uint16]int{}
+	for ; len(b) >= 188; b = b[188:] {
+		pid := uint16(b[1]&0x1f)<<8 | uint16(b[2])
+		if b[3]&0x20 != 0 && b[4] > 0 && b[5]&0x10 != 0 {
+			pids[pid]++
+		}
+	}
+	return pids
+}
+
+func TestMuxerPCRPID(t *testing.T) {
+	video, err := generator.NewVideo(generator.Bars(64, 48), 25, 25)
+	if err != nil {
+		t.Fatal(err)
+	}
+	audio, err := generator.NewAudio(av.AAC, 44100, av.CH_STEREO, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sub generator.Source // a DVB subtitle stream
+
+	for _, test := range []struct {
+		name    string
+		sources []generator.Source // by stream index, on pids 0x100 onwards
+		pcrpid  uint16
+		want    uint16 // pid carrying the PCR, 0 for an error
+	}{
+		{"video", []generator.Source{audio, video}, 0, 0x101},
+		{"audio", []generator.Source{audio}, 0, 0x100},
+		{"data first", []generator.Source{sub, audio}, 0, 0x101},
+		{"set audio", []generator.Source{video, audio}, 0x101, 0x101},
+		{"set data", []generator.Source{video, audio, sub}, 0x102, 0},
+	} {
+		var streams []av.CodecData
+		for _, source := range test.sources {
+			if source == sub {
+				streams = append(streams, dvbsub.NewCodecData([]byte{'e', 'n', 'g', 0x10, 0, 1, 0, 1}))
+			} else {
+				streams = append(streams, source.CodecData())
+			}
+		}
+		var b bytes.Buffer
+		muxer := NewMuxer(&b)
+		muxer.PCRPID = test.pcrpid
+		err := muxer.WriteHeader(streams)
+		if test.want == 0 {
+			if err == nil {
+				t.Errorf("%s: no error", test.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		for i := 0; i < 10; i++ {
+			for idx, source := range test.sources {
+				pkt := av.Packet{Data: []byte{0x20, 0x00, 0xff}}
+				if source != sub {
+					pkt = source.Next()
+				}
+				pkt.Idx = int8(idx)
+				if err = muxer.WritePacket(pkt); err != nil {
+					t.Fatalf("%s: %v", test.name, err)
+				}
+			}
+		}
+		if pids := pcrPIDs(b.Bytes()); len(pids) != 1 || pids[test.want] == 0 {
+			t.Errorf("%s: pcr on pids %v, want 0x%x only", test.name, pids, test.want)
+		}
+	}
+}
+
+func TestMuxerTeletext(t *testing.T) {
+	codec, err := teletext.NewCodecDataFromPages([]teletext.Page{{Language: "eng", Type: teletext.PageSubtitle, Magazine: 8, Page: 0x88}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	unit := make([]byte, 46)
+	unit[0], unit[1] = 0x02, 44
+	for _, test := range []struct {
+		units int
+		err   bool
+	}{
+		{1, false},
+		{3, false},
+		{4, false},
+		{10, false},
+		{-1, true}, // not whole data units
+	} {
+		data := []byte{0x10}
+		for i := 0; i < test.units; i++ {
+			data = append(data, unit...)
+		}
+		if test.units < 0 {
+			data = append(data, 1, 2, 3)
+		}
+		var b bytes.Buffer
+		muxer := NewMuxer(&b)
+		muxer.DisableSDT = true
+		if err = muxer.WriteHeader([]av.CodecData{codec}); err != nil {
+			t.Fatal(err)
+		}
+		header := b.Len()
+		err = muxer.WritePacket(av.Packet{Data: data, Time: time.Second})
+		if test.err {
+			if err == nil {
+				t.Errorf("%d units: no error", test.units)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%d units: %v", test.units, err)
+		}
+
+		// whole payloads of 184 bytes, without adaptation field
+		ts := b.Bytes()[header:]
+		var pes []byte
+		for ; len(ts) >= 188; ts = ts[188:] {
+			if ts[3]&0x30 != 0x10 {
+				t.Fatalf("%d units: adaptation field 0x%x", test.units, ts[3])
+			}
+			pes = append(pes, ts[4:188]...)
+		}
+		if len(ts) != 0 {
+			t.Fatalf("%d units: %d trailing bytes", test.units, len(ts))
+		}
+		if length := int(pes[4])<<8 | int(pes[5]); length+6 != len(pes) {
+			t.Errorf("%d units: PES length %d for %d bytes", test.units, length, len(pes))
+		}
+		payload := pes[tsio.TeletextPESHeaderLength:]
+		if !bytes.Equal(payload[:len(data)], data) {
+			t.Errorf("%d units: data differs", test.units)
+		}
+		for rest := payload[len(data):]; len(rest) > 0; rest = rest[46:] {
+			if rest[0] != 0xff || rest[1] != 44 {
+				t.Errorf("%d units: stuffing unit %x", test.units, rest[:2])
+				break
+			}
+		}
+
+		demuxer := NewDemuxer(bytes.NewReader(b.Bytes()))
+		pkt, err := demuxer.ReadPacket()
+		if err != nil {
+			t.Fatalf("%d units: %v", test.units, err)
+		}
+		// the muxer starts times at 1s
+		if !bytes.Equal(pkt.Data, payload) || pkt.Time != 2*time.Second {
+			t.Errorf("%d units: read back %d bytes at %v", test.units, len(pkt.Data), pkt.Time)
+		}
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"time"
 
 	"github.com/deepch/vdk/av"
+	"github.com/deepch/vdk/av/pktque"
+	"github.com/deepch/vdk/codec/h264parser"
 	"github.com/deepch/vdk/format/ts/tsio"
 )
 
@@ -24,4 +26,21 @@ type Stream struct {
 	pts, dts, pt time.Duration
 	data         []byte
 	datalen      int
+
+	aclock *pktque.AudioClock
+
+	spsInfo    h264parser.SPSInfo
+	field      *fieldPicture
+	fieldOrder av.FieldOrder
+}
+
+// fieldPicture collects the slices of an interlaced field pair so it goes
+// out as a single frame packet with the timing of the first field.
+type fieldPicture struct {
+	data       []byte
+	pts, dts   time.Duration
+	iskeyframe bool
+	bottom     bool // parity of the first field
+	last       bool // parity of the latest slice
+	paired     bool
 }
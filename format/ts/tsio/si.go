@@ -0,0 +1,368 @@
+package tsio
+
+import (
+	"fmt"
+	"time"
+	"unicode/utf8"
+
+	"github.com/deepch/vdk/utils/bits/pio"
+)
+
+// DVB service information (EN 300 468).
+
+const (
+	SDT_PID = 0x11
+	EIT_PID = 0x12
+)
+
+const (
+	TableIdSDT                 = 0x42
+	TableIdEITPresentFollowing = 0x4e
+)
+
+const (
+	DescriptorTagService    = 0x48
+	DescriptorTagShortEvent = 0x4d
+)
+
+const ServiceTypeDigitalTV = 0x01
+
+const (
+	RunningStatusUndefined  = 0
+	RunningStatusNotRunning = 1
+	RunningStatusRunning    = 4
+)
+
+var ErrParseSDT = fmt.Errorf("invalid SDT")
+var ErrParseEIT = fmt.Errorf("invalid EIT")
+
+type SDTService struct {
+	ServiceID           uint16
+	EITSchedule         bool
+	EITPresentFollowing bool
+	RunningStatus       uint8
+	FreeCAMode          bool
+	Descriptors         []Descriptor
+}
+
+// SDT is a service description section, the transport_stream_id is the
+// table extension.
+type SDT struct {
+	OriginalNetworkID uint16
+	Services          []SDTService
+}
+
+func descsLen(descs []Descriptor) (n int) {
+	for _, desc := range descs {
+		n += 2 + len(desc.Data)
+	}
+	return
+}
+
+func (self SDT) Len() (n int) {
+	n = 3
+	for _, service := range self.Services {
+		n += 5 + descsLen(service.Descriptors)
+	}
+	return
+}
+
+func (self SDT) Marshal(b []byte) (n int) {
+	pio.PutU16BE(b[n:], self.OriginalNetworkID)
+	n += 2
+	b[n] = 0xff
+	n++
+	for _, service := range self.Services {
+		pio.PutU16BE(b[n:], service.ServiceID)
+		n += 2
+		flags := uint8(0xfc)
+		if service.EITSchedule {
+			flags |= 2
+		}
+		if service.EITPresentFollowing {
+			flags |= 1
+		}
+		b[n] = flags
+		n++
+		v := uint16(service.RunningStatus)<<13 | uint16(descsLen(service.Descriptors))
+		if service.FreeCAMode {
+			v |= 1 << 12
+		}
+		pio.PutU16BE(b[n:], v)
+		n += 2
+		n += PMT{}.fillDescs(b[n:], service.Descriptors)
+	}
+	return
+}
+
+func (self *SDT) Unmarshal(b []byte) (n int, err error) {
+	if len(b) < 3 {
+		err = ErrParseSDT
+		return
+	}
+	self.OriginalNetworkID = pio.U16BE(b)
+	n = 3
+	self.Services = nil
+	for n+5 <= len(b) {
+		service := SDTService{
+			ServiceID:           pio.U16BE(b[n:]),
+			EITSchedule:         b[n+2]&2 != 0,
+			EITPresentFollowing: b[n+2]&1 != 0,
+			RunningStatus:       b[n+3] >> 5,
+			FreeCAMode:          b[n+3]&0x10 != 0,
+		}
+		desclen := int(pio.U16BE(b[n+3:]) & 0xfff)
+		n += 5
+		if n+desclen > len(b) {
+			err = ErrParseSDT
+			return
+		}
+		if service.Descriptors, err = (PMT{}).parseDescs(b[n : n+desclen]); err != nil {
+			return
+		}
+		n += desclen
+		self.Services = append(self.Services, service)
+	}
+	return
+}
+
+type EITEvent struct {
+	EventID       uint16
+	StartTime     time.Time // zero if undefined
+	Duration      time.Duration
+	RunningStatus uint8
+	FreeCAMode    bool
+	Descriptors   []Descriptor
+}
+
+// EIT is an event information section, the service_id is the table
+// extension and the section number tells present (0) from following (1).
+type EIT struct {
+	TransportStreamID        uint16
+	OriginalNetworkID        uint16
+	SegmentLastSectionNumber uint8
+	LastTableID              uint8
+	Events                   []EITEvent
+}
+
+func (self EIT) Len() (n int) {
+	n = 6
+	for _, event := range self.Events {
+		n += 12 + descsLen(event.Descriptors)
+	}
+	return
+}
+
+func (self EIT) Marshal(b []byte) (n int) {
+	pio.PutU16BE(b[n:], self.TransportStreamID)
+	n += 2
+	pio.PutU16BE(b[n:], self.OriginalNetworkID)
+	n += 2
+	b[n] = self.SegmentLastSectionNumber
+	n++
+	b[n] = self.LastTableID
+	n++
+	for _, event := range self.Events {
+		pio.PutU16BE(b[n:], event.EventID)
+		n += 2
+		putMJDTime(b[n:], event.StartTime)
+		n += 5
+		putBCDDuration(b[n:], event.Duration)
+		n += 3
+		v := uint16(event.RunningStatus)<<13 | uint16(descsLen(event.Descriptors))
+		if event.FreeCAMode {
+			v |= 1 << 12
+		}
+		pio.PutU16BE(b[n:], v)
+		n += 2
+		n += PMT{}.fillDescs(b[n:], event.Descriptors)
+	}
+	return
+}
+
+func (self *EIT) Unmarshal(b []byte) (n int, err error) {
+	if len(b) < 6 {
+		err = ErrParseEIT
+		return
+	}
+	self.TransportStreamID = pio.U16BE(b)
+	self.OriginalNetworkID = pio.U16BE(b[2:])
+	self.SegmentLastSectionNumber = b[4]
+	self.LastTableID = b[5]
+	n = 6
+	self.Events = nil
+	for n+12 <= len(b) {
+		event := EITEvent{
+			EventID:       pio.U16BE(b[n:]),
+			StartTime:     mjdTime(b[n+2:]),
+			Duration:      bcdDuration(b[n+7:]),
+			RunningStatus: b[n+10] >> 5,
+			FreeCAMode:    b[n+10]&0x10 != 0,
+		}
+		desclen := int(pio.U16BE(b[n+10:]) & 0xfff)
+		n += 12
+		if n+desclen > len(b) {
+			err = ErrParseEIT
+			return
+		}
+		if event.Descriptors, err = (PMT{}).parseDescs(b[n : n+desclen]); err != nil {
+			return
+		}
+		n += desclen
+		self.Events = append(self.Events, event)
+	}
+	return
+}
+
+func bcd(v int) uint8 {
+	return uint8(v/10<<4 | v%10)
+}
+
+func unbcd(v uint8) int {
+	return int(v>>4)*10 + int(v&0xf)
+}
+
+var mjdEpoch = time.Date(1858, 11, 17, 0, 0, 0, 0, time.UTC)
+
+func mjdTime(b []byte) time.Time {
+	if b[0] == 0xff && b[1] == 0xff && b[2] == 0xff && b[3] == 0xff && b[4] == 0xff {
+		return time.Time{}
+	}
+	days := int(pio.U16BE(b))
+	d := time.Duration(unbcd(b[2]))*time.Hour + time.Duration(unbcd(b[3]))*time.Minute + time.Duration(unbcd(b[4]))*time.Second
+	return mjdEpoch.AddDate(0, 0, days).Add(d)
+}
+
+func putMJDTime(b []byte, tm time.Time) {
+	if tm.IsZero() {
+		copy(b, []byte{0xff, 0xff, 0xff, 0xff, 0xff})
+		return
+	}
+	tm = tm.UTC()
+	days := int(tm.Truncate(24*time.Hour).Sub(mjdEpoch) / (24 * time.Hour))
+	pio.PutU16BE(b, uint16(days))
+	b[2], b[3], b[4] = bcd(tm.Hour()), bcd(tm.Minute()), bcd(tm.Second())
+}
+
+func bcdDuration(b []byte) time.Duration {
+	return time.Duration(unbcd(b[0]))*time.Hour + time.Duration(unbcd(b[1]))*time.Minute + time.Duration(unbcd(b[2]))*time.Second
+}
+
+func putBCDDuration(b []byte, d time.Duration) {
+	s := int(d / time.Second)
+	if s > 99*3600+59*60+59 {
+		s = 99*3600 + 59*60 + 59
+	}
+	b[0], b[1], b[2] = bcd(s/3600), bcd(s/60%60), bcd(s%60)
+}
+
+// DVBString decodes a text field, the Latin alphabet is assumed unless the
+// field selects UTF-8.
+func DVBString(b []byte) string {
+	if len(b) == 0 {
+		return ""
+	}
+	switch {
+	case b[0] == 0x15:
+		return string(b[1:])
+	case b[0] == 0x10:
+		if len(b) < 3 {
+			return ""
+		}
+		b = b[3:]
+	case b[0] == 0x1f:
+		if len(b) < 2 {
+			return ""
+		}
+		b = b[2:]
+	case b[0] < 0x20:
+		b = b[1:]
+	}
+	runes := make([]rune, 0, len(b))
+	for _, c := range b {
+		// control codes, emphasis and line breaks
+		if c >= 0x80 && c < 0xa0 {
+			continue
+		}
+		runes = append(runes, rune(c))
+	}
+	return string(runes)
+}
+
+// PutDVBString encodes s, non ASCII text is sent as UTF-8.
+func PutDVBString(s string) []byte {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0x80 {
+			if utf8.ValidString(s) {
+				return append([]byte{0x15}, s...)
+			}
+			break
+		}
+	}
+	return []byte(s)
+}
+
+func putString8(b []byte, s []byte) []byte {
+	if len(s) > 255 {
+		s = s[:255]
+	}
+	b = append(b, uint8(len(s)))
+	return append(b, s...)
+}
+
+func ServiceDescriptor(serviceType uint8, provider, name string) Descriptor {
+	data := []byte{serviceType}
+	data = putString8(data, PutDVBString(provider))
+	data = putString8(data, PutDVBString(name))
+	return Descriptor{Tag: DescriptorTagService, Data: data}
+}
+
+func ParseServiceDescriptor(desc Descriptor) (serviceType uint8, provider, name string, ok bool) {
+	b := desc.Data
+	if desc.Tag != DescriptorTagService || len(b) < 2 {
+		return
+	}
+	serviceType = b[0]
+	n := int(b[1])
+	if len(b) < 3+n {
+		return
+	}
+	provider = DVBString(b[2 : 2+n])
+	b = b[2+n:]
+	n = int(b[0])
+	if len(b) < 1+n {
+		return
+	}
+	name = DVBString(b[1 : 1+n])
+	ok = true
+	return
+}
+
+func ShortEventDescriptor(lang, name, text string) Descriptor {
+	data := []byte{' ', ' ', ' '}
+	copy(data, lang)
+	data = putString8(data, PutDVBString(name))
+	data = putString8(data, PutDVBString(text))
+	return Descriptor{Tag: DescriptorTagShortEvent, Data: data}
+}
+
+func ParseShortEventDescriptor(desc Descriptor) (lang, name, text string, ok bool) {
+	b := desc.Data
+	if desc.Tag != DescriptorTagShortEvent || len(b) < 4 {
+		return
+	}
+	lang = string(b[:3])
+	n := int(b[3])
+	if len(b) < 5+n {
+		return
+	}
+	name = DVBString(b[4 : 4+n])
+	b = b[4+n:]
+	n = int(b[0])
+	if len(b) < 1+n {
+		return
+	}
+	text = DVBString(b[1 : 1+n])
+	ok = true
+	return
+}
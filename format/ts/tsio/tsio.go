@@ -9,8 +9,9 @@ import (
 )
 
 const (
-	StreamIdH264 = 0xe0
-	StreamIdAAC  = 0xc0
+	StreamIdH264     = 0xe0
+	StreamIdAAC      = 0xc0
+	StreamIdPrivate1 = 0xbd
 )
 
 const (
@@ -98,6 +99,34 @@ type Descriptor struct {
 	Data []byte
 }
 
+const (
+	DescriptorTagRegistration   = 0x05
+	DescriptorTagISO639Language = 0x0a
+	DescriptorTagTeletext       = 0x56
+	DescriptorTagSubtitling     = 0x59
+	DescriptorTagAC3            = 0x6a
+)
+
+// RegistrationDescriptor identifies a private format by its four letter code.
+func RegistrationDescriptor(format string) Descriptor {
+	data := make([]byte, 4)
+	copy(data, format)
+	return Descriptor{Tag: DescriptorTagRegistration, Data: data}
+}
+
+// ISO639LanguageDescriptor tags a stream with a three letter language code,
+// audioType 0 is undefined, 3 visual impaired commentary.
+func ISO639LanguageDescriptor(lang string, audioType uint8) Descriptor {
+	data := []byte{' ', ' ', ' ', audioType}
+	copy(data[:3], lang)
+	return Descriptor{Tag: DescriptorTagISO639Language, Data: data}
+}
+
+// AC3Descriptor is the DVB AC-3 descriptor with no optional field.
+func AC3Descriptor() Descriptor {
+	return Descriptor{Tag: DescriptorTagAC3, Data: []byte{0}}
+}
+
 type ElementaryStreamInfo struct {
 	StreamType    uint8
 	ElementaryPID uint16
@@ -195,7 +224,7 @@ func (self PMT) parseDescs(b []byte) (descs []Descriptor, err error) {
 			desc.Tag = b[n]
 			desc.Data = make([]byte, b[n+1])
 			n += 2
-			if n+len(desc.Data) < len(b) {
+			if n+len(desc.Data) <= len(b) {
 				copy(desc.Data, b[n:])
 				descs = append(descs, desc)
 				n += len(desc.Data)
@@ -500,10 +529,31 @@ func FillPESHeader(h []byte, streamid uint8, datalen int, pts, dts time.Duration
 	return
 }
 
+// TeletextPESHeaderLength is the fixed PES header size of teletext streams.
+const TeletextPESHeaderLength = 45
+
+// FillTeletextPESHeader fills a private stream header stuffed to the size
+// EN 300 472 requires, h must hold TeletextPESHeaderLength bytes.
+func FillTeletextPESHeader(h []byte, datalen int, pts time.Duration) (n int) {
+	n = FillPESHeader(h, StreamIdPrivate1, -1, pts, 0)
+	// the stuffing makes the header size fixed whether a PTS was written or not
+	pio.PutU16BE(h[4:6], uint16(datalen+TeletextPESHeaderLength-6))
+	h[8] = TeletextPESHeaderLength - 9
+	for ; n < TeletextPESHeaderLength; n++ {
+		h[n] = 0xff
+	}
+	return
+}
+
 type TSWriter struct {
 	w                 io.Writer
 	ContinuityCounter uint
 	tshdr             []byte
+
+	// OmitEmptyAdaptation leaves out the adaptation field of the packets
+	// which need neither flags nor stuffing, so their payload is 184 bytes
+	// as teletext requires.
+	OmitEmptyAdaptation bool
 }
 
 func NewTSWriter(pid uint16) *TSWriter {
@@ -633,6 +683,11 @@ func (self *TSWriter) WritePackets(w io.Writer, datav [][]byte, pcr time.Duratio
 			}
 		}
 
+		if self.OmitEmptyAdaptation && self.tshdr[5] == 0 && writepos+184 <= datavlen {
+			self.tshdr[3] &^= 0x20
+			hdrlen = 4
+		}
+
 		padtail := 0
 		end := writepos + 188 - hdrlen
 		if end > datavlen {
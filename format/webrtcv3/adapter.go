@@ -31,6 +31,7 @@ type Muxer struct {
 	ClientACK *time.Timer
 	StreamACK *time.Timer
 	Options   Options
+	stats     stats
 }
 type Stream struct {
 	codec av.CodecData
@@ -49,10 +50,17 @@ type Options struct {
 	PortMin uint16
 	// PortMin is an optional maximum (inclusive) ephemeral UDP port range for the ICEServers connections
 	PortMax uint16
+	// ID is an optional name of the viewer given to Metrics
+	ID string
+	// Metrics is an optional receiver of the stats and connection events
+	Metrics Metrics
+	// StatsInterval is the period of the stats, DefaultStatsInterval if zero
+	StatsInterval time.Duration
 }
 
 func NewMuxer(options Options) *Muxer {
 	tmp := Muxer{Options: options, ClientACK: time.NewTimer(time.Second * 20), StreamACK: time.NewTimer(time.Second * 20), streams: make(map[int8]*Stream)}
+	tmp.stats.done = make(chan struct{})
 	//go tmp.WaitCloser()
 	return &tmp
 }
@@ -130,14 +138,7 @@ func (element *Muxer) WriteHeader(streams []av.CodecData, sdp64 string) (string,
 				if rtpSender, err := peerConnection.AddTrack(track); err != nil {
 					return "", err
 				} else {
-					go func() {
-						rtcpBuf := make([]byte, 1500)
-						for {
-							if _, _, rtcpErr := rtpSender.Read(rtcpBuf); rtcpErr != nil {
-								return
-							}
-						}
-					}()
+					go element.readRTCP(rtpSender, 90000)
 				}
 			}
 		} else if i2.Type().IsAudio() {
@@ -164,14 +165,7 @@ func (element *Muxer) WriteHeader(streams []av.CodecData, sdp64 string) (string,
 			if rtpSender, err := peerConnection.AddTrack(track); err != nil {
 				return "", err
 			} else {
-				go func() {
-					rtcpBuf := make([]byte, 1500)
-					for {
-						if _, _, rtcpErr := rtpSender.Read(rtcpBuf); rtcpErr != nil {
-							return
-						}
-					}
-				}()
+				go element.readRTCP(rtpSender, uint32(i2.(av.AudioCodecData).SampleRate()))
 			}
 		}
 		element.streams[int8(i)] = &Stream{track: track, codec: i2}
@@ -181,6 +175,7 @@ func (element *Muxer) WriteHeader(streams []av.CodecData, sdp64 string) (string,
 	}
 	peerConnection.OnICEConnectionStateChange(func(connectionState webrtc.ICEConnectionState) {
 		element.status = connectionState
+		element.logEvent(connectionState)
 		if connectionState == webrtc.ICEConnectionStateDisconnected {
 			element.Close()
 		}
@@ -239,6 +234,9 @@ func (element *Muxer) WritePacket(pkt av.Packet) (err error) {
 		if len(pkt.Data) < 5 {
 			return nil
 		}
+		element.stats.lock.Lock()
+		element.stats.bytes += int64(len(pkt.Data))
+		element.stats.lock.Unlock()
 		switch tmp.codec.Type() {
 		case av.H264:
 			nalus, _ := h264parser.SplitNALUs(pkt.Data)
@@ -290,6 +288,7 @@ func (element *Muxer) WritePacket(pkt av.Packet) (err error) {
 
 func (element *Muxer) Close() error {
 	element.stop = true
+	element.stopStats()
 	if element.pc != nil {
 		err := element.pc.Close()
 		if err != nil {
@@ -0,0 +1,245 @@
+package webrtc
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/rtcp"
+	"github.com/pion/webrtc/v3"
+)
+
+const (
+	DefaultStatsInterval = 5 * time.Second
+	MaxEvents            = 64
+)
+
+// Metrics receives the stats and the connection events of the viewers,
+// Options.ID tells them apart.
+type Metrics interface {
+	ViewerStats(id string, stats Stats)
+	ViewerEvent(id string, event Event)
+}
+
+// Stats of a viewer, from the RTCP receiver reports of the browser.
+type Stats struct {
+	Time         time.Time
+	RTT          time.Duration // zero until the browser reports on a sender report
+	PacketsLost  int64         // since the start, all tracks
+	FractionLost float64       // in the last report of the worst track
+	Jitter       time.Duration // of the worst track
+	Bitrate      int           // bits per second sent over the last interval
+	BytesSent    int64
+}
+
+// Event is a change of the ICE connection state of a viewer, with the
+// negotiated codecs and the remote candidate once connected and the last
+// stats once ended.
+type Event struct {
+	Time   time.Time
+	State  string
+	Remote string
+	Codecs []string
+	Stats  *Stats
+}
+
+type trackStats struct {
+	clockRate    uint32
+	lost         map[uint32]uint32
+	fractionLost float64
+	jitter       time.Duration
+}
+
+type stats struct {
+	lock      sync.Mutex
+	tracks    []*trackStats
+	rtt       time.Duration
+	bytes     int64
+	lastBytes int64
+	last      Stats
+	events    []Event
+	started   bool
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// ntpMiddle is the middle 32 bits of the NTP time of tm, as in LSR.
+func ntpMiddle(tm time.Time) uint32 {
+	ntp := uint64(tm.Unix()+2208988800)<<32 | uint64(tm.Nanosecond())<<32/uint64(time.Second)
+	return uint32(ntp >> 16)
+}
+
+func (element *Muxer) readRTCP(sender *webrtc.RTPSender, clockRate uint32) {
+	track := element.addTrack(clockRate)
+	for {
+		pkts, _, err := sender.ReadRTCP()
+		if err != nil {
+			return
+		}
+		now := time.Now()
+		for _, pkt := range pkts {
+			if rr, ok := pkt.(*rtcp.ReceiverReport); ok {
+				element.receiverReport(track, rr, now)
+			}
+		}
+	}
+}
+
+func (element *Muxer) addTrack(clockRate uint32) *trackStats {
+	track := &trackStats{clockRate: clockRate, lost: map[uint32]uint32{}}
+	element.stats.lock.Lock()
+	element.stats.tracks = append(element.stats.tracks, track)
+	element.stats.lock.Unlock()
+	return track
+}
+
+// receiverReport accounts a report of the browser on track received at now.
+func (element *Muxer) receiverReport(track *trackStats, rr *rtcp.ReceiverReport, now time.Time) {
+	element.stats.lock.Lock()
+	defer element.stats.lock.Unlock()
+	for _, report := range rr.Reports {
+		track.lost[report.SSRC] = report.TotalLost
+		track.fractionLost = float64(report.FractionLost) / 256
+		if track.clockRate > 0 {
+			track.jitter = time.Duration(report.Jitter) * time.Second / time.Duration(track.clockRate)
+		}
+		if report.LastSenderReport != 0 {
+			if rtt := ntpMiddle(now) - report.LastSenderReport - report.Delay; int32(rtt) >= 0 {
+				element.stats.rtt = time.Duration(rtt) * time.Second / 65536
+			}
+		}
+	}
+}
+
+func (element *Muxer) collectStats(interval time.Duration) Stats {
+	element.stats.lock.Lock()
+	defer element.stats.lock.Unlock()
+	s := Stats{
+		Time:      time.Now(),
+		RTT:       element.stats.rtt,
+		BytesSent: element.stats.bytes,
+		Bitrate:   int((element.stats.bytes - element.stats.lastBytes) * 8 * int64(time.Second) / int64(interval)),
+	}
+	element.stats.lastBytes = element.stats.bytes
+	for _, track := range element.stats.tracks {
+		for _, lost := range track.lost {
+			// the 24 bits are signed, duplicates make it negative
+			s.PacketsLost += int64(int32(lost<<8) >> 8)
+		}
+		if track.fractionLost > s.FractionLost {
+			s.FractionLost = track.fractionLost
+		}
+		if track.jitter > s.Jitter {
+			s.Jitter = track.jitter
+		}
+	}
+	element.stats.last = s
+	return s
+}
+
+func (element *Muxer) statsLoop() {
+	interval := element.Options.StatsInterval
+	if interval <= 0 {
+		interval = DefaultStatsInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-element.stats.done:
+			return
+		case <-ticker.C:
+		}
+		s := element.collectStats(interval)
+		if element.Options.Metrics != nil {
+			element.Options.Metrics.ViewerStats(element.Options.ID, s)
+		}
+	}
+}
+
+// negotiated describes the codecs of the tracks and the remote candidate.
+func (element *Muxer) negotiated() (codecs []string, remote string) {
+	for _, sender := range element.pc.GetSenders() {
+		track, ok := sender.Track().(*webrtc.TrackLocalStaticSample)
+		if !ok {
+			continue
+		}
+		want := track.Codec()
+		for _, codec := range sender.GetParameters().Codecs {
+			if strings.EqualFold(codec.MimeType, want.MimeType) {
+				desc := fmt.Sprintf("%s/%d pt=%d", codec.MimeType, codec.ClockRate, codec.PayloadType)
+				if codec.SDPFmtpLine != "" {
+					desc += " " + codec.SDPFmtpLine
+				}
+				codecs = append(codecs, desc)
+				break
+			}
+		}
+		if remote == "" && sender.Transport() != nil {
+			if pair, err := sender.Transport().ICETransport().GetSelectedCandidatePair(); err == nil && pair != nil {
+				remote = pair.Remote.String()
+			}
+		}
+	}
+	return
+}
+
+func (element *Muxer) logEvent(state webrtc.ICEConnectionState) {
+	event := Event{Time: time.Now(), State: state.String()}
+	switch state {
+	case webrtc.ICEConnectionStateConnected:
+		event.Codecs, event.Remote = element.negotiated()
+		element.stats.lock.Lock()
+		start := !element.stats.started
+		element.stats.started = true
+		element.stats.makeDone()
+		element.stats.lock.Unlock()
+		if start {
+			go element.statsLoop()
+		}
+	case webrtc.ICEConnectionStateDisconnected, webrtc.ICEConnectionStateFailed, webrtc.ICEConnectionStateClosed:
+		s := element.Stats()
+		event.Stats = &s
+	}
+	element.stats.lock.Lock()
+	element.stats.events = append(element.stats.events, event)
+	if len(element.stats.events) > MaxEvents {
+		element.stats.events = element.stats.events[1:]
+	}
+	element.stats.lock.Unlock()
+	if element.Options.Metrics != nil {
+		element.Options.Metrics.ViewerEvent(element.Options.ID, event)
+	}
+}
+
+// makeDone makes the channel ending statsLoop, for a Muxer not made by
+// NewMuxer, the lock held.
+func (self *stats) makeDone() {
+	if self.done == nil {
+		self.done = make(chan struct{})
+	}
+}
+
+func (element *Muxer) stopStats() {
+	element.stats.closeOnce.Do(func() {
+		element.stats.lock.Lock()
+		element.stats.makeDone()
+		element.stats.lock.Unlock()
+		close(element.stats.done)
+	})
+}
+
+// Stats returns the stats of the last interval.
+func (element *Muxer) Stats() Stats {
+	element.stats.lock.Lock()
+	defer element.stats.lock.Unlock()
+	return element.stats.last
+}
+
+// Events returns the last MaxEvents connection events.
+func (element *Muxer) Events() []Event {
+	element.stats.lock.Lock()
+	defer element.stats.lock.Unlock()
+	return append([]Event(nil), element.stats.events...)
+}
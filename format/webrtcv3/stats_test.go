@@ -0,0 +1,129 @@
+package webrtc
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pion/rtcp"
+	"github.com/pion/webrtc/v3"
+)
+
+type testMetrics struct {
+	lock   sync.Mutex
+	ids    map[string]bool
+	stats  []Stats
+	events []Event
+}
+
+func (self *testMetrics) ViewerStats(id string, stats Stats) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.ids[id] = true
+	self.stats = append(self.stats, stats)
+}
+
+func (self *testMetrics) ViewerEvent(id string, event Event) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.ids[id] = true
+	self.events = append(self.events, event)
+}
+
+func TestNTPMiddle(t *testing.T) {
+	tm := time.Date(2021, 3, 4, 10, 15, 30, 0, time.UTC)
+	for _, d := range []time.Duration{time.Second, 500 * time.Millisecond, time.Hour} {
+		if got, want := ntpMiddle(tm.Add(d))-ntpMiddle(tm), uint32(d*65536/time.Second); got != want {
+			t.Errorf("%v: %d, want %d", d, got, want)
+		}
+	}
+}
+
+func TestReceiverReports(t *testing.T) {
+	element := NewMuxer(Options{})
+	video := element.addTrack(90000)
+	audio := element.addTrack(8000)
+	now := time.Now()
+
+	element.receiverReport(video, &rtcp.ReceiverReport{Reports: []rtcp.ReceptionReport{
+		{SSRC: 1, TotalLost: 5, FractionLost: 64, Jitter: 900},
+	}}, now)
+	// the last report of an SSRC replaces the previous one
+	element.receiverReport(video, &rtcp.ReceiverReport{Reports: []rtcp.ReceptionReport{
+		{SSRC: 1, TotalLost: 7, FractionLost: 32, Jitter: 1800},
+	}}, now)
+	// 150ms since our sender report, held 50ms by the browser
+	element.receiverReport(audio, &rtcp.ReceiverReport{Reports: []rtcp.ReceptionReport{
+		{SSRC: 2, TotalLost: 0xfffffe, FractionLost: 128, Jitter: 80,
+			LastSenderReport: ntpMiddle(now.Add(-150 * time.Millisecond)), Delay: 65536 / 20},
+	}}, now)
+	element.stats.bytes = 125000
+	s := element.collectStats(time.Second)
+	if s.PacketsLost != 5 || s.FractionLost != 0.5 || s.Jitter != 20*time.Millisecond {
+		t.Errorf("stats %+v", s)
+	}
+	if s.RTT < 99*time.Millisecond || s.RTT > 101*time.Millisecond {
+		t.Errorf("rtt %v", s.RTT)
+	}
+	if s.Bitrate != 1000000 || s.BytesSent != 125000 || element.Stats() != s {
+		t.Errorf("stats %+v", s)
+	}
+
+	// a sender report from the future is not a round trip
+	element.receiverReport(audio, &rtcp.ReceiverReport{Reports: []rtcp.ReceptionReport{
+		{SSRC: 2, LastSenderReport: ntpMiddle(now.Add(time.Second))},
+	}}, now)
+	element.stats.bytes += 25000
+	s = element.collectStats(100 * time.Millisecond)
+	if s.RTT != element.stats.rtt || s.RTT < 99*time.Millisecond || s.Bitrate != 2000000 || s.BytesSent != 150000 || s.PacketsLost != 7 {
+		t.Errorf("stats %+v", s)
+	}
+}
+
+func TestEvents(t *testing.T) {
+	metrics := &testMetrics{ids: map[string]bool{}}
+	element := NewMuxer(Options{ID: "viewer", Metrics: metrics, StatsInterval: 10 * time.Millisecond})
+	pc, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	element.pc = pc
+	defer element.Close()
+
+	element.logEvent(webrtc.ICEConnectionStateChecking)
+	element.logEvent(webrtc.ICEConnectionStateConnected)
+	element.logEvent(webrtc.ICEConnectionStateConnected)
+	time.Sleep(100 * time.Millisecond)
+	element.stats.lock.Lock()
+	element.stats.bytes = 1000
+	element.stats.lock.Unlock()
+	time.Sleep(50 * time.Millisecond)
+	element.logEvent(webrtc.ICEConnectionStateDisconnected)
+
+	events := element.Events()
+	if len(events) != 4 || events[0].State != "checking" || events[1].State != "connected" || events[3].State != "disconnected" {
+		t.Fatalf("events %+v", events)
+	}
+	if events[1].Stats != nil || events[3].Stats == nil || events[3].Stats.BytesSent != 1000 {
+		t.Errorf("stats of the events %+v %+v", events[1].Stats, events[3].Stats)
+	}
+	element.stopStats()
+	element.stopStats()
+
+	metrics.lock.Lock()
+	if len(metrics.ids) != 1 || !metrics.ids["viewer"] || len(metrics.events) != 4 {
+		t.Errorf("metrics of %v, %d events", metrics.ids, len(metrics.events))
+	}
+	// a single stats loop despite the two connected states
+	if n := len(metrics.stats); n < 5 || n > 20 {
+		t.Errorf("%d stats", n)
+	}
+	metrics.lock.Unlock()
+
+	for i := 0; i < MaxEvents+10; i++ {
+		element.logEvent(webrtc.ICEConnectionStateChecking)
+	}
+	if events = element.Events(); len(events) != MaxEvents || events[0].State != "checking" {
+		t.Errorf("%d events from %s", len(events), events[0].State)
+	}
+}
@@ -3,10 +3,14 @@ module github.com/deepch/vdk
 go 1.18
 
 require (
+	github.com/gobwas/ws v1.3.1
 	github.com/google/uuid v1.3.0
 	github.com/pion/interceptor v0.1.17
+	github.com/pion/rtcp v1.2.10
 	github.com/pion/webrtc/v2 v2.2.26
 	github.com/pion/webrtc/v3 v3.2.12
+	go.etcd.io/bbolt v1.3.7
+	golang.org/x/net v0.11.0
 )
 
 require (
@@ -15,7 +19,6 @@ require (
 	github.com/go-task/slim-sprig v0.0.0-20210107165309-348f09dbbbc0 // indirect
 	github.com/gobwas/httphead v0.1.0 // indirect
 	github.com/gobwas/pool v0.2.1 // indirect
-	github.com/gobwas/ws v1.3.1 // indirect
 	github.com/golang/mock v1.6.0 // indirect
 	github.com/google/pprof v0.0.0-20230309165930-d61513b1440d // indirect
 	github.com/lucas-clemente/quic-go v0.31.1 // indirect
@@ -31,7 +34,6 @@ require (
 	github.com/pion/mdns v0.0.7 // indirect
 	github.com/pion/quic v0.1.4 // indirect
 	github.com/pion/randutil v0.1.0 // indirect
-	github.com/pion/rtcp v1.2.10 // indirect
 	github.com/pion/rtp v1.7.13 // indirect
 	github.com/pion/sctp v1.8.7 // indirect
 	github.com/pion/sdp/v2 v2.4.0 // indirect
@@ -50,7 +52,6 @@ require (
 	golang.org/x/crypto v0.10.0 // indirect
 	golang.org/x/exp v0.0.0-20230310171629-522b1b587ee0 // indirect
 	golang.org/x/mod v0.9.0 // indirect
-	golang.org/x/net v0.11.0 // indirect
 	golang.org/x/sys v0.9.0 // indirect
 	golang.org/x/tools v0.7.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
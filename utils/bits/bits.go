@@ -70,14 +70,14 @@ type Writer struct {
 func (self *Writer) WriteBits64(bits uint64, n int) (err error) {
 	if self.n+n > 64 {
 		move := uint(64 - self.n)
-		mask := bits >> move
-		self.bits = (self.bits << move) | mask
+		rest := uint(n) - move
+		self.bits = (self.bits << move) | (bits >> rest)
 		self.n = 64
 		if err = self.FlushBits(); err != nil {
 			return
 		}
-		n -= int(move)
-		bits ^= (mask << move)
+		n = int(rest)
+		bits &= (1 << rest) - 1
 	}
 	self.bits = (self.bits << uint(n)) | bits
 	self.n += n
@@ -116,3 +116,11 @@ func (self *Writer) FlushBits() (err error) {
 	}
 	return
 }
+
+// PutUInt64BE puts the low n bits of v big endian in b, n a multiple of 8.
+func PutUInt64BE(b []byte, v uint64, n int) {
+	for i := 0; n > 0; i++ {
+		n -= 8
+		b[i] = byte(v >> uint(n))
+	}
+}
@@ -49,3 +49,42 @@ func TestBits(t *testing.T) {
 		t.FailNow()
 	}
 }
+
+func TestWriteBits64(t *testing.T) {
+	for _, sizes := range [][]int{
+		{60, 10},
+		{64, 64},
+		{63, 64, 1},
+		{1, 64, 63},
+		{7, 57, 64, 3},
+		{33, 33, 33, 33},
+	} {
+		// fields of shifted patterns, against the same bits put one by one
+		var want []byte
+		var nbits int
+		wbuf := &bytes.Buffer{}
+		w := &Writer{W: wbuf}
+		for i, n := range sizes {
+			v := uint64(0xa5c3f00f5a3c0ff0) >> uint(i)
+			if n < 64 {
+				v &= 1<<uint(n) - 1
+			}
+			if err := w.WriteBits64(v, n); err != nil {
+				t.Fatal(err)
+			}
+			for bit := n - 1; bit >= 0; bit-- {
+				if nbits%8 == 0 {
+					want = append(want, 0)
+				}
+				want[len(want)-1] |= byte(v>>uint(bit)&1) << uint(7-nbits%8)
+				nbits++
+			}
+		}
+		if err := w.FlushBits(); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(wbuf.Bytes(), want) {
+			t.Errorf("%v: wrote %x, want %x", sizes, wbuf.Bytes(), want)
+		}
+	}
+}
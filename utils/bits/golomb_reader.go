@@ -22,6 +22,17 @@ func (self *GolombBitReader) ReadBit() (res uint, err error) {
 	return
 }
 
+// BitsLeft returns the bits of the last byte read which were not read yet.
+func (self *GolombBitReader) BitsLeft() int {
+	return int(self.left)
+}
+
+// Align drops the bits left of the last byte read, the next bit read is the
+// first of the next byte.
+func (self *GolombBitReader) Align() {
+	self.left = 0
+}
+
 func (self *GolombBitReader) ReadBits(n int) (res uint, err error) {
 	for i := 0; i < n; i++ {
 		var bit uint
@@ -83,7 +94,7 @@ func (self *GolombBitReader) ReadSE() (res uint, err error) {
 	if res&0x01 != 0 {
 		res = (res + 1) / 2
 	} else {
-		res = -res / 2
+		res = -(res / 2)
 	}
 	return
 }
@@ -0,0 +1,178 @@
+// Package iorate throttles and accounts the bytes going through readers and
+// writers, to keep playback or bulk export from saturating shared storage
+// or uplinks.
+package iorate
+
+import (
+	"context"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Limiter is a token bucket refilled at Rate bytes per second, holding at
+// most Burst bytes. It can be shared by several readers and writers.
+type Limiter struct {
+	Rate  int64
+	Burst int64
+
+	lock   sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+func NewLimiter(rate, burst int64) *Limiter {
+	if burst <= 0 {
+		burst = rate
+	}
+	return &Limiter{Rate: rate, Burst: burst, tokens: float64(burst)}
+}
+
+// reserve takes n tokens and returns how long to wait before using them.
+func (self *Limiter) reserve(n int) time.Duration {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	now := time.Now()
+	if !self.last.IsZero() {
+		self.tokens += now.Sub(self.last).Seconds() * float64(self.Rate)
+		if self.tokens > float64(self.Burst) {
+			self.tokens = float64(self.Burst)
+		}
+	}
+	self.last = now
+	self.tokens -= float64(n)
+	if self.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-self.tokens / float64(self.Rate) * float64(time.Second))
+}
+
+// Wait blocks until n bytes may go through.
+func (self *Limiter) Wait(n int) {
+	if self == nil || self.Rate <= 0 || n <= 0 {
+		return
+	}
+	if d := self.reserve(n); d > 0 {
+		time.Sleep(d)
+	}
+}
+
+// WaitContext is Wait returning early with the error of ctx once done.
+func (self *Limiter) WaitContext(ctx context.Context, n int) error {
+	if self == nil || self.Rate <= 0 || n <= 0 {
+		return nil
+	}
+	if d := self.reserve(n); d > 0 {
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+	return nil
+}
+
+// chunk caps a transfer so a single call does not take more than a burst.
+func (self *Limiter) chunk(n int) int {
+	if self == nil || self.Rate <= 0 || self.Burst <= 0 || int64(n) <= self.Burst {
+		return n
+	}
+	return int(self.Burst)
+}
+
+// Counter accounts bytes, OnBytes is called for every transfer.
+type Counter struct {
+	OnBytes func(n int)
+	total   int64
+}
+
+func (self *Counter) Add(n int) {
+	if self == nil || n <= 0 {
+		return
+	}
+	atomic.AddInt64(&self.total, int64(n))
+	if self.OnBytes != nil {
+		self.OnBytes(n)
+	}
+}
+
+func (self *Counter) Total() int64 {
+	if self == nil {
+		return 0
+	}
+	return atomic.LoadInt64(&self.total)
+}
+
+// Reader throttles and counts R, Limiter and Counter may be nil.
+type Reader struct {
+	R       io.Reader
+	Limiter *Limiter
+	Counter *Counter
+}
+
+func (self *Reader) Read(p []byte) (n int, err error) {
+	n, err = self.R.Read(p[:self.Limiter.chunk(len(p))])
+	self.Counter.Add(n)
+	self.Limiter.Wait(n)
+	return
+}
+
+// ReadSeeker is a Reader which can seek, for file demuxers.
+type ReadSeeker struct {
+	Reader
+	S io.Seeker
+}
+
+func NewReadSeeker(rs io.ReadSeeker, limiter *Limiter, counter *Counter) *ReadSeeker {
+	return &ReadSeeker{
+		Reader: Reader{R: rs, Limiter: limiter, Counter: counter},
+		S:      rs,
+	}
+}
+
+func (self *ReadSeeker) Seek(offset int64, whence int) (int64, error) {
+	return self.S.Seek(offset, whence)
+}
+
+// ReaderAt throttles and counts R, for demuxers sharing a file handle.
+type ReaderAt struct {
+	R       io.ReaderAt
+	Limiter *Limiter
+	Counter *Counter
+}
+
+func (self *ReaderAt) ReadAt(p []byte, off int64) (n int, err error) {
+	for n < len(p) && err == nil {
+		var nn int
+		b := p[n:]
+		b = b[:self.Limiter.chunk(len(b))]
+		nn, err = self.R.ReadAt(b, off+int64(n))
+		n += nn
+		self.Counter.Add(nn)
+		self.Limiter.Wait(nn)
+	}
+	return
+}
+
+// Writer throttles and counts W, Limiter and Counter may be nil.
+type Writer struct {
+	W       io.Writer
+	Limiter *Limiter
+	Counter *Counter
+}
+
+func (self *Writer) Write(p []byte) (n int, err error) {
+	for n < len(p) && err == nil {
+		var nn int
+		b := p[n:]
+		b = b[:self.Limiter.chunk(len(b))]
+		self.Limiter.Wait(len(b))
+		nn, err = self.W.Write(b)
+		n += nn
+		self.Counter.Add(nn)
+	}
+	return
+}
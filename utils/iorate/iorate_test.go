@@ -0,0 +1,116 @@
+package iorate
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// the burst goes through at once, the rest at the rate
+const (
+	testRate  = 200000
+	testBurst = 20000
+	testSize  = 80000
+	testMin   = time.Duration(testSize-testBurst) * time.Second / testRate
+)
+
+func TestThroughput(t *testing.T) {
+	data := make([]byte, testSize)
+	for _, test := range []struct {
+		name string
+		copy func(limiter *Limiter, counter *Counter) (int64, error)
+	}{
+		{"reader", func(limiter *Limiter, counter *Counter) (int64, error) {
+			return io.Copy(ioutil.Discard, &Reader{R: bytes.NewReader(data), Limiter: limiter, Counter: counter})
+		}},
+		{"read seeker", func(limiter *Limiter, counter *Counter) (int64, error) {
+			r := NewReadSeeker(bytes.NewReader(data), limiter, counter)
+			r.Seek(testSize/2, io.SeekStart)
+			n, err := io.Copy(ioutil.Discard, r)
+			r.Seek(0, io.SeekStart)
+			m, _ := io.CopyN(ioutil.Discard, r, testSize/2)
+			return n + m, err
+		}},
+		{"reader at", func(limiter *Limiter, counter *Counter) (int64, error) {
+			b := make([]byte, testSize)
+			n, err := (&ReaderAt{R: bytes.NewReader(data), Limiter: limiter, Counter: counter}).ReadAt(b, 0)
+			return int64(n), err
+		}},
+		{"writer", func(limiter *Limiter, counter *Counter) (int64, error) {
+			n, err := (&Writer{W: ioutil.Discard, Limiter: limiter, Counter: counter}).Write(data)
+			return int64(n), err
+		}},
+		{"shared", func(limiter *Limiter, counter *Counter) (int64, error) {
+			// two writers of half the data share the rate
+			var wg sync.WaitGroup
+			for i := 0; i < 2; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					(&Writer{W: ioutil.Discard, Limiter: limiter, Counter: counter}).Write(data[:testSize/2])
+				}()
+			}
+			wg.Wait()
+			return counter.Total(), nil
+		}},
+	} {
+		limiter := NewLimiter(testRate, testBurst)
+		var calls int64
+		counter := &Counter{OnBytes: func(n int) {
+			if n > testBurst {
+				t.Errorf("%s: %d bytes at once", test.name, n)
+			}
+			atomic.AddInt64(&calls, 1)
+		}}
+		start := time.Now()
+		n, err := test.copy(limiter, counter)
+		elapsed := time.Since(start)
+		if err != nil && err != io.EOF || n != testSize || counter.Total() != testSize {
+			t.Errorf("%s: copied %d, counted %d: %v", test.name, n, counter.Total(), err)
+		}
+		if calls < testSize/testBurst {
+			t.Errorf("%s: %d transfers", test.name, calls)
+		}
+		if elapsed < testMin*9/10 || elapsed > testMin*3 {
+			t.Errorf("%s: took %v, want about %v", test.name, elapsed, testMin)
+		}
+	}
+}
+
+func TestUnlimited(t *testing.T) {
+	data := make([]byte, 1<<20)
+	for _, limiter := range []*Limiter{nil, {}, NewLimiter(0, 0)} {
+		start := time.Now()
+		n, err := io.Copy(ioutil.Discard, &Reader{R: bytes.NewReader(data), Limiter: limiter})
+		if err != nil || n != int64(len(data)) || time.Since(start) > 100*time.Millisecond {
+			t.Errorf("%+v: copied %d in %v: %v", limiter, n, time.Since(start), err)
+		}
+	}
+	var counter *Counter
+	counter.Add(10)
+	if counter.Total() != 0 {
+		t.Error("nil counter counted")
+	}
+}
+
+func TestWaitContext(t *testing.T) {
+	limiter := NewLimiter(1000, 1000)
+	if err := limiter.WaitContext(context.Background(), 1000); err != nil {
+		t.Fatal(err)
+	}
+	// the bucket is empty, waiting a second for more is cancelled
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := limiter.WaitContext(ctx, 1000); err != context.DeadlineExceeded {
+		t.Errorf("cancelled wait: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("cancelled wait took %v", elapsed)
+	}
+}
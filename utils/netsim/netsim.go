@@ -0,0 +1,495 @@
+// Package netsim impairs network connections with latency, jitter, loss and
+// reordering, so jitter buffers and reconnection can be tested without a
+// flaky network. Wrap the connections of a test server with a Listener, or
+// a client connection with NewConn or NewPacketConn.
+package netsim
+
+import (
+	"math/rand"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// DefaultRetransmitTimeout is the stall of a stream connection losing a
+// segment, the minimum TCP retransmission timeout.
+const DefaultRetransmitTimeout = 200 * time.Millisecond
+
+// Impairment applies to the data going each way. Datagrams are lost and
+// reordered, streams keep their order and stall as TCP retransmits lost
+// segments.
+type Impairment struct {
+	Latency           time.Duration
+	Jitter            time.Duration // random extra latency up to Jitter
+	Loss              float64       // probability a datagram or a stream write is lost
+	Reorder           float64       // probability a datagram is held back behind the next one
+	RetransmitTimeout time.Duration // stall of a stream loss, DefaultRetransmitTimeout if zero
+	Disconnect        time.Duration // closes the connection after it, never if zero
+	Seed              int64         // of the random impairments, the same seed gives the same run
+
+	lock sync.Mutex
+	rand *rand.Rand
+}
+
+func (self *Impairment) random() float64 {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if self.rand == nil {
+		self.rand = rand.New(rand.NewSource(self.Seed))
+	}
+	return self.rand.Float64()
+}
+
+func (self *Impairment) delay() time.Duration {
+	d := self.Latency
+	if self.Jitter > 0 {
+		d += time.Duration(self.random() * float64(self.Jitter))
+	}
+	return d
+}
+
+func (self *Impairment) lost() bool {
+	return self.Loss > 0 && self.random() < self.Loss
+}
+
+func (self *Impairment) retransmitTimeout() time.Duration {
+	if self.RetransmitTimeout > 0 {
+		return self.RetransmitTimeout
+	}
+	return DefaultRetransmitTimeout
+}
+
+// copy gives each connection its own random source from the same seed.
+func (self *Impairment) copy() *Impairment {
+	return &Impairment{
+		Latency:           self.Latency,
+		Jitter:            self.Jitter,
+		Loss:              self.Loss,
+		Reorder:           self.Reorder,
+		RetransmitTimeout: self.RetransmitTimeout,
+		Disconnect:        self.Disconnect,
+		Seed:              self.Seed,
+	}
+}
+
+type chunk struct {
+	data []byte
+	addr net.Addr
+	due  time.Time
+	err  error
+}
+
+// deadline is a read deadline, the underlying connection keeps reading.
+type deadline struct {
+	lock sync.Mutex
+	t    time.Time
+}
+
+func (self *deadline) set(t time.Time) {
+	self.lock.Lock()
+	self.t = t
+	self.lock.Unlock()
+}
+
+// wait waits for a chunk from ch and until it is due. A chunk not due by
+// the deadline stays in head for the next read.
+func (self *deadline) wait(head **chunk, ch chan chunk, closed chan struct{}) (c chunk, err error) {
+	self.lock.Lock()
+	t := self.t
+	self.lock.Unlock()
+	var timeout <-chan time.Time
+	if !t.IsZero() {
+		timer := time.NewTimer(time.Until(t))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	if *head == nil {
+		select {
+		case c = <-ch:
+			*head = &c
+		case <-closed:
+			err = net.ErrClosed
+			return
+		case <-timeout:
+			err = os.ErrDeadlineExceeded
+			return
+		}
+	}
+	if wait := time.Until((*head).due); wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-closed:
+			err = net.ErrClosed
+			return
+		case <-timeout:
+			err = os.ErrDeadlineExceeded
+			return
+		}
+	}
+	c = **head
+	*head = nil
+	return
+}
+
+// datagrams delivers datagrams after their delay, some lost and some held
+// back until the next one, or twice the latency if none comes.
+type datagrams struct {
+	imp     *Impairment
+	lock    sync.Mutex
+	held    *chunk
+	release *time.Timer
+	deliver func(c chunk)
+}
+
+func (self *datagrams) push(c chunk) {
+	if self.imp.lost() {
+		return
+	}
+	self.lock.Lock()
+	if held := self.held; held != nil {
+		self.held = nil
+		self.release.Stop()
+		self.lock.Unlock()
+		time.AfterFunc(self.imp.delay(), func() {
+			self.deliver(c)
+			self.deliver(*held)
+		})
+		return
+	}
+	if self.imp.Reorder > 0 && self.imp.random() < self.imp.Reorder {
+		held := &c
+		self.held = held
+		self.release = time.AfterFunc(2*self.imp.delay(), func() {
+			self.lock.Lock()
+			if self.held != held {
+				self.lock.Unlock()
+				return
+			}
+			self.held = nil
+			self.lock.Unlock()
+			self.deliver(*held)
+		})
+		self.lock.Unlock()
+		return
+	}
+	self.lock.Unlock()
+	time.AfterFunc(self.imp.delay(), func() {
+		self.deliver(c)
+	})
+}
+
+// Conn impairs a stream connection, or a connected UDP socket as
+// datagrams.
+type Conn struct {
+	net.Conn
+	imp      *Impairment
+	datagram bool
+	in       chan chunk
+	out      chan chunk
+	head     *chunk
+	pending  []byte
+	readErr  error
+	readDL   deadline
+	closed   chan struct{}
+	once     sync.Once
+
+	lock     sync.Mutex
+	lastIn   time.Time
+	lastOut  time.Time
+	writeErr error
+	outgoing *datagrams
+}
+
+// NewConn impairs conn as imp tells, datagrams when conn is UDP or a unix
+// datagram socket.
+func NewConn(conn net.Conn, imp *Impairment) *Conn {
+	imp = imp.copy()
+	self := &Conn{
+		Conn:   conn,
+		imp:    imp,
+		in:     make(chan chunk, 1024),
+		out:    make(chan chunk, 1024),
+		closed: make(chan struct{}),
+	}
+	switch conn.LocalAddr().Network() {
+	case "udp", "udp4", "udp6", "unixgram":
+		self.datagram = true
+	}
+	if self.datagram {
+		self.outgoing = &datagrams{imp: imp, deliver: func(c chunk) {
+			conn.Write(c.data)
+		}}
+		go self.readDatagrams()
+	} else {
+		go self.readStream()
+		go self.writeStream()
+	}
+	if imp.Disconnect > 0 {
+		time.AfterFunc(imp.Disconnect, func() {
+			self.Close()
+		})
+	}
+	return self
+}
+
+// due is when data sent now arrives, streams keep their order.
+func (self *Conn) due(last *time.Time) time.Time {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	due := time.Now().Add(self.imp.delay())
+	if self.imp.lost() {
+		due = due.Add(self.imp.retransmitTimeout())
+	}
+	if due.Before(*last) {
+		due = *last
+	}
+	*last = due
+	return due
+}
+
+func (self *Conn) readStream() {
+	for {
+		b := make([]byte, 64*1024)
+		n, err := self.Conn.Read(b)
+		c := chunk{data: b[:n], err: err, due: self.due(&self.lastIn)}
+		select {
+		case self.in <- c:
+		case <-self.closed:
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (self *Conn) writeStream() {
+	for {
+		var c chunk
+		select {
+		case c = <-self.out:
+		case <-self.closed:
+			return
+		}
+		if wait := time.Until(c.due); wait > 0 {
+			time.Sleep(wait)
+		}
+		if _, err := self.Conn.Write(c.data); err != nil {
+			self.lock.Lock()
+			self.writeErr = err
+			self.lock.Unlock()
+			return
+		}
+	}
+}
+
+func (self *Conn) readDatagrams() {
+	incoming := &datagrams{imp: self.imp, deliver: func(c chunk) {
+		select {
+		case self.in <- c:
+		default:
+			// the socket buffer is full
+		}
+	}}
+	for {
+		b := make([]byte, 64*1024)
+		n, err := self.Conn.Read(b)
+		if err != nil {
+			select {
+			case self.in <- chunk{err: err}:
+			case <-self.closed:
+			}
+			return
+		}
+		incoming.push(chunk{data: b[:n]})
+	}
+}
+
+func (self *Conn) Read(p []byte) (n int, err error) {
+	if len(self.pending) > 0 {
+		n = copy(p, self.pending)
+		self.pending = self.pending[n:]
+		return
+	}
+	if self.readErr != nil {
+		err = self.readErr
+		return
+	}
+	var c chunk
+	if c, err = self.readDL.wait(&self.head, self.in, self.closed); err != nil {
+		return
+	}
+	n = copy(p, c.data)
+	if !self.datagram {
+		self.pending = c.data[n:]
+	}
+	if self.readErr = c.err; n == 0 {
+		err = c.err
+	}
+	return
+}
+
+// Write queues p to be sent after its delay, a write error comes back on
+// a later Write.
+func (self *Conn) Write(p []byte) (n int, err error) {
+	self.lock.Lock()
+	err = self.writeErr
+	self.lock.Unlock()
+	if err != nil {
+		return
+	}
+	b := append([]byte{}, p...)
+	if self.datagram {
+		self.outgoing.push(chunk{data: b})
+		n = len(p)
+		return
+	}
+	select {
+	case self.out <- chunk{data: b, due: self.due(&self.lastOut)}:
+		n = len(p)
+	case <-self.closed:
+		err = net.ErrClosed
+	}
+	return
+}
+
+func (self *Conn) Close() (err error) {
+	self.once.Do(func() {
+		close(self.closed)
+		err = self.Conn.Close()
+	})
+	return
+}
+
+func (self *Conn) SetDeadline(t time.Time) error {
+	self.readDL.set(t)
+	return self.Conn.SetWriteDeadline(t)
+}
+
+func (self *Conn) SetReadDeadline(t time.Time) error {
+	self.readDL.set(t)
+	return nil
+}
+
+// PacketConn impairs the datagrams of an unconnected UDP socket.
+type PacketConn struct {
+	net.PacketConn
+	imp      *Impairment
+	in       chan chunk
+	head     *chunk
+	readDL   deadline
+	closed   chan struct{}
+	once     sync.Once
+	outgoing *datagrams
+}
+
+func NewPacketConn(conn net.PacketConn, imp *Impairment) *PacketConn {
+	imp = imp.copy()
+	self := &PacketConn{
+		PacketConn: conn,
+		imp:        imp,
+		in:         make(chan chunk, 1024),
+		closed:     make(chan struct{}),
+	}
+	self.outgoing = &datagrams{imp: imp, deliver: func(c chunk) {
+		conn.WriteTo(c.data, c.addr)
+	}}
+	go self.readDatagrams()
+	if imp.Disconnect > 0 {
+		time.AfterFunc(imp.Disconnect, func() {
+			self.Close()
+		})
+	}
+	return self
+}
+
+func (self *PacketConn) readDatagrams() {
+	incoming := &datagrams{imp: self.imp, deliver: func(c chunk) {
+		select {
+		case self.in <- c:
+		default:
+		}
+	}}
+	for {
+		b := make([]byte, 64*1024)
+		n, addr, err := self.PacketConn.ReadFrom(b)
+		if err != nil {
+			select {
+			case self.in <- chunk{err: err}:
+			case <-self.closed:
+			}
+			return
+		}
+		incoming.push(chunk{data: b[:n], addr: addr})
+	}
+}
+
+func (self *PacketConn) ReadFrom(p []byte) (n int, addr net.Addr, err error) {
+	var c chunk
+	if c, err = self.readDL.wait(&self.head, self.in, self.closed); err != nil {
+		return
+	}
+	if c.err != nil {
+		err = c.err
+		return
+	}
+	n = copy(p, c.data)
+	addr = c.addr
+	return
+}
+
+func (self *PacketConn) WriteTo(p []byte, addr net.Addr) (n int, err error) {
+	select {
+	case <-self.closed:
+		err = net.ErrClosed
+		return
+	default:
+	}
+	self.outgoing.push(chunk{data: append([]byte{}, p...), addr: addr})
+	n = len(p)
+	return
+}
+
+func (self *PacketConn) Close() (err error) {
+	self.once.Do(func() {
+		close(self.closed)
+		err = self.PacketConn.Close()
+	})
+	return
+}
+
+func (self *PacketConn) SetDeadline(t time.Time) error {
+	self.readDL.set(t)
+	return self.PacketConn.SetWriteDeadline(t)
+}
+
+func (self *PacketConn) SetReadDeadline(t time.Time) error {
+	self.readDL.set(t)
+	return nil
+}
+
+// Listener impairs the connections it accepts.
+type Listener struct {
+	net.Listener
+	Impairment *Impairment
+}
+
+func (self Listener) Accept() (conn net.Conn, err error) {
+	if conn, err = self.Listener.Accept(); err != nil {
+		return
+	}
+	conn = NewConn(conn, self.Impairment)
+	return
+}
+
+// Dial connects to address and impairs the connection.
+func Dial(network, address string, imp *Impairment) (conn net.Conn, err error) {
+	if conn, err = net.Dial(network, address); err != nil {
+		return
+	}
+	conn = NewConn(conn, imp)
+	return
+}
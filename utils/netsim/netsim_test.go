@@ -0,0 +1,102 @@
+package netsim
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestDatagrams(t *testing.T) {
+	server, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	udp, err := net.Dial("udp", server.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// every other datagram is held back, the last one until its release
+	client := NewConn(udp, &Impairment{Latency: 10 * time.Millisecond, Reorder: 1, Seed: 1})
+	defer client.Close()
+	const n = 5
+	for i := 0; i < n; i++ {
+		if _, err := client.Write([]byte{byte(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	server.SetReadDeadline(time.Now().Add(time.Second))
+	var got []byte
+	b := make([]byte, 16)
+	for len(got) < n {
+		m, _, err := server.ReadFrom(b)
+		if err != nil {
+			t.Fatalf("got %v: %v", got, err)
+		}
+		got = append(got, b[:m]...)
+	}
+	pos := map[byte]int{}
+	for i, d := range got {
+		pos[d] = i
+	}
+	if len(pos) != n {
+		t.Fatalf("got %v, want each of %d datagrams", got, n)
+	}
+	for i := byte(0); i+1 < n; i += 2 {
+		if pos[i] < pos[i+1] {
+			t.Errorf("got %v, want %d after %d", got, i, i+1)
+		}
+	}
+}
+
+func TestStream(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	latency := 10 * time.Millisecond
+	listener := Listener{Listener: ln, Impairment: &Impairment{
+		Latency:           latency,
+		Jitter:            5 * time.Millisecond,
+		Loss:              0.3,
+		RetransmitTimeout: 20 * time.Millisecond,
+		Seed:              1,
+	}}
+	defer listener.Close()
+
+	want := make([]byte, 64)
+	for i := range want {
+		want[i] = byte(i)
+	}
+	go func() {
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for i := 0; i < len(want); i += 8 {
+			conn.Write(want[i : i+8])
+		}
+	}()
+
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	start := time.Now()
+	got := make([]byte, len(want))
+	if _, err := io.ReadFull(conn, got); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if elapsed := time.Since(start); elapsed < latency {
+		t.Errorf("read after %v, want at least %v", elapsed, latency)
+	}
+}
@@ -0,0 +1,135 @@
+package rangecache
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// HTTPSource reads a file of an HTTP server with range requests, as S3
+// objects through presigned URLs.
+type HTTPSource struct {
+	URL    string
+	Client *http.Client
+	Header http.Header // added to every request, as credentials
+	// CacheKey replaces Key, for files the URL path does not identify.
+	CacheKey string
+
+	size    int64
+	version string
+}
+
+// NewHTTPSource gets the size of the file at uri, client is
+// http.DefaultClient if nil.
+func NewHTTPSource(uri string, client *http.Client) (self *HTTPSource, err error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	self = &HTTPSource{URL: uri, Client: client}
+	var res *http.Response
+	if res, err = self.get("bytes=0-0"); err != nil {
+		return
+	}
+	res.Body.Close()
+	// Content-Range: bytes 0-0/size
+	cr := res.Header.Get("Content-Range")
+	i := strings.LastIndexByte(cr, '/')
+	if i < 0 {
+		err = fmt.Errorf("rangecache: %s: no size in Content-Range %q", uri, cr)
+		return
+	}
+	if self.size, err = strconv.ParseInt(cr[i+1:], 10, 64); err != nil {
+		err = fmt.Errorf("rangecache: %s: no size in Content-Range %q", uri, cr)
+		return
+	}
+	if self.version = res.Header.Get("ETag"); self.version == "" {
+		self.version = res.Header.Get("Last-Modified")
+	}
+	return
+}
+
+func (self *HTTPSource) get(ranges string) (res *http.Response, err error) {
+	var req *http.Request
+	if req, err = http.NewRequest("GET", self.URL, nil); err != nil {
+		return
+	}
+	for k, v := range self.Header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Range", ranges)
+	if res, err = self.Client.Do(req); err != nil {
+		return
+	}
+	if res.StatusCode != http.StatusPartialContent {
+		res.Body.Close()
+		err = fmt.Errorf("rangecache: %s: status %s to a range request", self.URL, res.Status)
+		return
+	}
+	return
+}
+
+func (self *HTTPSource) Size() int64 {
+	return self.size
+}
+
+// Key is the URL without its signature parameters and the ETag, or
+// Last-Modified, of the file, to open it in a Cache. The signature is left
+// out as presigned URLs sign it differently every session, the rest of the
+// query is kept as it may tell files apart.
+func (self *HTTPSource) Key() string {
+	if self.CacheKey != "" {
+		return self.CacheKey
+	}
+	key := self.URL
+	if u, err := url.Parse(self.URL); err == nil {
+		key = u.Scheme + "://" + u.Host + u.Path
+		query := u.Query()
+		for name := range query {
+			if isSignatureParam(name) {
+				delete(query, name)
+			}
+		}
+		if len(query) > 0 {
+			key += "?" + query.Encode()
+		}
+	}
+	return key + " " + self.version
+}
+
+// isSignatureParam tells the query parameters of an S3 presigned URL, of
+// signature version 4 or 2, which change with every signature.
+func isSignatureParam(name string) bool {
+	switch {
+	case strings.HasPrefix(strings.ToLower(name), "x-amz-"):
+		return true
+	case name == "Signature", name == "Expires":
+		return true
+	}
+	return false
+}
+
+func (self *HTTPSource) ReadAt(b []byte, off int64) (n int, err error) {
+	if off >= self.size {
+		err = io.EOF
+		return
+	}
+	end := off + int64(len(b))
+	if end > self.size {
+		end = self.size
+	}
+	var res *http.Response
+	if res, err = self.get(fmt.Sprintf("bytes=%d-%d", off, end-1)); err != nil {
+		return
+	}
+	defer res.Body.Close()
+	if n, err = io.ReadFull(res.Body, b[:end-off]); err != nil {
+		return
+	}
+	if n < len(b) {
+		err = io.EOF
+	}
+	return
+}
@@ -0,0 +1,235 @@
+// Package rangecache reads remote files, such as archives on HTTP or S3
+// servers, as an io.ReaderAt fetching byte ranges on demand and keeping
+// them in a disk cache, so demuxers can seek in large files without
+// downloading them whole:
+//
+//	src, err := rangecache.NewHTTPSource(url, nil)
+//	r := cache.Open(src.Key(), src, src.Size())
+//	demuxer := mp4.NewDemuxerAt(r, r.Size())
+//
+// Any io.ReaderAt can be a source, wrap it in an iorate.ReaderAt to bound
+// the bandwidth used.
+package rangecache
+
+import (
+	"container/list"
+	"crypto/sha1"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	DefaultBlockSize = 1 << 20
+	DefaultMaxSize   = 1 << 30
+)
+
+// Cache keeps the blocks fetched in Dir, the least recently used ones
+// removed past MaxSize bytes. It can be shared by the readers of many
+// files.
+type Cache struct {
+	Dir       string
+	MaxSize   int64 // DefaultMaxSize if zero
+	BlockSize int   // DefaultBlockSize if zero, not to be changed once used
+
+	lock     sync.Mutex
+	lru      *list.List // of *block, most recent first
+	blocks   map[string]*list.Element
+	size     int64
+	fetching map[string]*fetch
+}
+
+type block struct {
+	name string
+	size int64
+}
+
+type fetch struct {
+	done chan struct{}
+	data []byte
+	err  error
+}
+
+// NewCache opens the cache in dir, creating it, the blocks already there
+// are kept and the oldest ones removed first.
+func NewCache(dir string, maxSize int64) (self *Cache, err error) {
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		return
+	}
+	self = &Cache{
+		Dir:      dir,
+		MaxSize:  maxSize,
+		lru:      list.New(),
+		blocks:   map[string]*list.Element{},
+		fetching: map[string]*fetch{},
+	}
+	var entries []os.DirEntry
+	if entries, err = os.ReadDir(dir); err != nil {
+		return
+	}
+	var infos []os.FileInfo
+	for _, entry := range entries {
+		if strings.HasSuffix(entry.Name(), ".tmp") {
+			os.Remove(filepath.Join(dir, entry.Name()))
+			continue
+		}
+		if info, err := entry.Info(); err == nil && info.Mode().IsRegular() {
+			infos = append(infos, info)
+		}
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].ModTime().After(infos[j].ModTime())
+	})
+	for _, info := range infos {
+		self.blocks[info.Name()] = self.lru.PushBack(&block{name: info.Name(), size: info.Size()})
+		self.size += info.Size()
+	}
+	self.lock.Lock()
+	self.evict()
+	self.lock.Unlock()
+	return
+}
+
+func (self *Cache) blockSize() int64 {
+	if self.BlockSize > 0 {
+		return int64(self.BlockSize)
+	}
+	return DefaultBlockSize
+}
+
+// evict removes blocks past MaxSize, the lock held.
+func (self *Cache) evict() {
+	max := self.MaxSize
+	if max <= 0 {
+		max = DefaultMaxSize
+	}
+	for self.size > max && self.lru.Len() > 0 {
+		b := self.lru.Remove(self.lru.Back()).(*block)
+		delete(self.blocks, b.name)
+		self.size -= b.size
+		os.Remove(filepath.Join(self.Dir, b.name))
+	}
+}
+
+// Size is the number of bytes cached.
+func (self *Cache) Size() int64 {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return self.size
+}
+
+// Open returns a reader of src cached under key, which must change with
+// the content of src, as an URL with the ETag of the file.
+func (self *Cache) Open(key string, src io.ReaderAt, size int64) *Reader {
+	return &Reader{
+		cache:  self,
+		prefix: fmt.Sprintf("%x", sha1.Sum([]byte(key))),
+		src:    src,
+		size:   size,
+	}
+}
+
+// get returns block i of r, from the disk or fetched from the source. A
+// block fetched by a reader is waited for by the others. Blocks are named
+// with their size, so a cache reopened with another BlockSize does not
+// read the blocks left by the previous one at the wrong offsets.
+func (self *Cache) get(r *Reader, i int64) (data []byte, err error) {
+	name := fmt.Sprintf("%s-%d-%d", r.prefix, self.blockSize(), i)
+	path := filepath.Join(self.Dir, name)
+
+	self.lock.Lock()
+	if el, ok := self.blocks[name]; ok {
+		self.lru.MoveToFront(el)
+		self.lock.Unlock()
+		if data, err = os.ReadFile(path); err == nil {
+			return
+		}
+		self.lock.Lock()
+		if el, ok := self.blocks[name]; ok {
+			self.lru.Remove(el)
+			delete(self.blocks, name)
+			self.size -= el.Value.(*block).size
+		}
+	}
+	if f, ok := self.fetching[name]; ok {
+		self.lock.Unlock()
+		<-f.done
+		return f.data, f.err
+	}
+	f := &fetch{done: make(chan struct{})}
+	self.fetching[name] = f
+	self.lock.Unlock()
+
+	off := i * self.blockSize()
+	n := self.blockSize()
+	if off+n > r.size {
+		n = r.size - off
+	}
+	f.data = make([]byte, n)
+	var nn int
+	if nn, f.err = r.src.ReadAt(f.data, off); nn == len(f.data) {
+		f.err = nil
+	}
+	if f.err == nil {
+		tmp := path + ".tmp"
+		if err := os.WriteFile(tmp, f.data, 0644); err == nil && os.Rename(tmp, path) == nil {
+			self.lock.Lock()
+			self.blocks[name] = self.lru.PushFront(&block{name: name, size: n})
+			self.size += n
+			self.evict()
+			self.lock.Unlock()
+		} else {
+			os.Remove(tmp)
+		}
+	} else {
+		f.data = nil
+	}
+
+	self.lock.Lock()
+	delete(self.fetching, name)
+	self.lock.Unlock()
+	close(f.done)
+	return f.data, f.err
+}
+
+// Reader is an io.ReaderAt of a source through the cache, safe for
+// concurrent use.
+type Reader struct {
+	cache  *Cache
+	prefix string
+	src    io.ReaderAt
+	size   int64
+}
+
+func (self *Reader) Size() int64 {
+	return self.size
+}
+
+func (self *Reader) ReadAt(b []byte, off int64) (n int, err error) {
+	if off < 0 {
+		err = fmt.Errorf("rangecache: negative offset")
+		return
+	}
+	bs := self.cache.blockSize()
+	for n < len(b) {
+		pos := off + int64(n)
+		if pos >= self.size {
+			err = io.EOF
+			return
+		}
+		var data []byte
+		if data, err = self.cache.get(self, pos/bs); err != nil {
+			return
+		}
+		if int64(len(data)) <= pos%bs {
+			err = io.ErrUnexpectedEOF
+			return
+		}
+		n += copy(b[n:], data[pos%bs:])
+	}
+	return
+}
@@ -0,0 +1,118 @@
+package rangecache
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func testData(n int) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = byte(i * 7 / 3)
+	}
+	return b
+}
+
+// countingReaderAt counts the reads reaching the source.
+type countingReaderAt struct {
+	io.ReaderAt
+	reads int
+}
+
+func (self *countingReaderAt) ReadAt(b []byte, off int64) (int, error) {
+	self.reads++
+	return self.ReaderAt.ReadAt(b, off)
+}
+
+func TestCacheReadAt(t *testing.T) {
+	data := testData(10000)
+	cache, err := NewCache(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache.BlockSize = 1000
+	src := &countingReaderAt{ReaderAt: bytes.NewReader(data)}
+	r := cache.Open("file", src, int64(len(data)))
+	for pass := 0; pass < 2; pass++ {
+		got := make([]byte, 2500)
+		if _, err := r.ReadAt(got, 3700); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, data[3700:6200]) {
+			t.Fatalf("pass %d: data differs", pass)
+		}
+	}
+	if src.reads != 4 {
+		t.Errorf("%d reads of the source, want 4", src.reads)
+	}
+	if _, err := r.ReadAt(make([]byte, 10), 9995); err != io.EOF {
+		t.Errorf("read past the end: %v, want io.EOF", err)
+	}
+}
+
+func TestCacheReopenWithAnotherBlockSize(t *testing.T) {
+	data := testData(10000)
+	dir := t.TempDir()
+	for _, blockSize := range []int{1000, 1500} {
+		cache, err := NewCache(dir, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cache.BlockSize = blockSize
+		r := cache.Open("file", bytes.NewReader(data), int64(len(data)))
+		got := make([]byte, 4000)
+		if _, err := r.ReadAt(got, 2000); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, data[2000:6000]) {
+			t.Errorf("block size %d: data differs", blockSize)
+		}
+	}
+}
+
+func TestHTTPSource(t *testing.T) {
+	data := testData(5000)
+	modified := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		http.ServeContent(w, req, "file", modified, bytes.NewReader(data))
+	}))
+	defer server.Close()
+
+	src, err := NewHTTPSource(server.URL+"/get?id=1&X-Amz-Signature=abc&X-Amz-Date=1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if src.Size() != int64(len(data)) {
+		t.Fatalf("size %d, want %d", src.Size(), len(data))
+	}
+	got := make([]byte, 100)
+	if _, err := src.ReadAt(got, 1234); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data[1234:1334]) {
+		t.Error("data differs")
+	}
+
+	for _, test := range []struct {
+		url  string
+		same bool
+	}{
+		{server.URL + "/get?id=1&X-Amz-Signature=def&X-Amz-Date=2", true},
+		{server.URL + "/get?X-Amz-Signature=def&id=1", true},
+		{server.URL + "/get?id=2&X-Amz-Signature=abc&X-Amz-Date=1", false},
+		{server.URL + "/get?id=1&Signature=abc&Expires=1", true},
+		{server.URL + "/get", false},
+	} {
+		other, err := NewHTTPSource(test.url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if same := other.Key() == src.Key(); same != test.same {
+			t.Errorf("%s: key %q against %q", test.url, other.Key(), src.Key())
+		}
+	}
+}